@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// bench 中一种操作的统计，-json 时原样输出
+type benchOpSummary struct {
+	Ops         int     `json:"ops"`
+	OK          int     `json:"ok"`
+	SuccessRate float64 `json:"success_rate"`
+	P50         float64 `json:"p50_ms"`
+	P90         float64 `json:"p90_ms"`
+	P99         float64 `json:"p99_ms"`
+	Max         float64 `json:"max_ms"`
+	AvgHops     float64 `json:"avg_hops"`
+	P90Hops     int     `json:"p90_hops"`
+	MaxHops     int     `json:"max_hops"`
+}
+
+type benchSummary struct {
+	Ops      int            `json:"ops"`
+	Contacts int            `json:"contacts"` // 加入网络后读节点路由表中的联系人数
+	Elapsed  string         `json:"elapsed"`
+	Get      benchOpSummary `json:"get"`
+	Put      benchOpSummary `json:"put"`
+}
+
+// 一种操作的全部样本
+type benchSamples struct {
+	latency []time.Duration
+	hops    []int
+	ok      int
+}
+
+func (b *benchSamples) add(latency time.Duration, hops int, ok bool) {
+	b.latency = append(b.latency, latency)
+	b.hops = append(b.hops, hops)
+	if ok {
+		b.ok++
+	}
+}
+
+func (b *benchSamples) summary() benchOpSummary {
+	n := len(b.latency)
+	if n == 0 {
+		return benchOpSummary{}
+	}
+	sort.Slice(b.latency, func(i, j int) bool { return b.latency[i] < b.latency[j] })
+	sort.Ints(b.hops)
+	ms := func(q float64) float64 {
+		return float64(b.latency[percentileIndex(n, q)]) / float64(time.Millisecond)
+	}
+	total := 0
+	for _, h := range b.hops {
+		total += h
+	}
+	return benchOpSummary{
+		Ops:         n,
+		OK:          b.ok,
+		SuccessRate: float64(b.ok) / float64(n),
+		P50:         ms(.5),
+		P90:         ms(.9),
+		P99:         ms(.99),
+		Max:         ms(1),
+		AvgHops:     float64(total) / float64(n),
+		P90Hops:     b.hops[percentileIndex(n, .9)],
+		MaxHops:     b.hops[n-1],
+	}
+}
+
+// 有序的 n 个样本中 q 分位数的下标
+func percentileIndex(n int, q float64) int {
+	return max(int(math.Ceil(q*float64(n)))-1, 0)
+}
+
+// 解析 "读/写" 形式的比例，例如 80/20
+func parseRatio(s string) (gets, puts int, err error) {
+	a, b, ok := strings.Cut(s, "/")
+	if ok {
+		gets, err = strconv.Atoi(strings.TrimSpace(a))
+		if err == nil {
+			puts, err = strconv.Atoi(strings.TrimSpace(b))
+		}
+	}
+	if !ok || err != nil || gets < 0 || puts < 0 || gets+puts == 0 {
+		return 0, 0, fmt.Errorf("-ratio %q: 应为 \"读/写\"，例如 80/20", s)
+	}
+	return gets, puts, nil
+}
+
+// 以客户端模式加入正在运行的网络，执行 -ops 次按 -ratio 混合的读写，输出延迟分位数、
+// 成功率与跳数。写入由一个节点完成，读取由另一个节点完成，读取因此总是经过网络查找。
+// 跳数为一次操作联系的节点数，写入包括 STORE；-timeout 为单次读写的超时时间
+func bench(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("bench", &s, false)
+	fs.Var(listFlag{&s.Bootstrap}, "bootstrap", "种子节点的地址，多个地址用逗号分隔")
+	fs.StringVar(&s.Hash, "hash", s.Hash, "计算 key 的哈希函数：sha1 或 sha256，需要与网络一致，为空时按 ID 长度选择")
+	ops := fs.Int("ops", 1000, "读写的总次数")
+	ratio := fs.String("ratio", "80/20", "读与写的比例")
+	size := fs.Int("size", 64, "写入的值的字节数")
+	seed := fs.Int64("seed", 0, "随机数种子，0 表示使用当前时间")
+	jsonOut := fs.Bool("json", false, "只输出 JSON 格式的结果")
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	gets, puts, err := parseRatio(*ratio)
+	if err != nil {
+		return err
+	}
+	if *ops < 1 || *size < 1 {
+		return errors.New("-ops 与 -size 必须大于 0")
+	}
+	if len(s.Bootstrap) == 0 {
+		return errors.New("需要用 -bootstrap 指定网络中的节点")
+	}
+	var seeds []dht.Contact
+	for _, addr := range s.Bootstrap {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return fmt.Errorf("种子节点 %s: %v", addr, err)
+		}
+		seeds = append(seeds, dht.Contact{Addr: udpAddr})
+	}
+	cfg := dht.DefaultConfig()
+	if err := setHashes(&cfg, s); err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(*seed))
+
+	writer, wt, err := newBenchPeer(cfg, "writer")
+	if err != nil {
+		return err
+	}
+	defer wt.Close()
+	reader, rt, err := newBenchPeer(cfg, "reader")
+	if err != nil {
+		return err
+	}
+	defer rt.Close()
+	// 两个节点都不回复请求，互相封禁以免写入时向读节点 STORE 而超时
+	writer.Ban(dht.Ban{ID: reader.ID()})
+	reader.Ban(dht.Ban{ID: writer.ID()})
+	for _, p := range []*dht.Peer{writer, reader} {
+		if err := p.Bootstrap(seeds); err != nil {
+			return fmt.Errorf("加入网络失败: %v", err)
+		}
+	}
+	summary := benchSummary{Ops: *ops, Contacts: reader.KBucket().Size()}
+	if !*jsonOut {
+		fmt.Printf("已加入网络，路由表中有 %d 个联系人，开始 %d 次读写（读:写 = %d:%d）\n", summary.Contacts, *ops, gets, puts)
+	}
+
+	var getSamples, putSamples benchSamples
+	var keys [][kbucket.IdSize]byte
+	values := make(map[[kbucket.IdSize]byte][]byte)
+	start := time.Now()
+	for i := 0; i < *ops; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		if len(keys) > 0 && r.Intn(gets+puts) < gets {
+			key := keys[r.Intn(len(keys))]
+			begin := time.Now()
+			value, trace, err := reader.TraceGetValue(ctx, key)
+			getSamples.add(time.Since(begin), len(trace.Hops), err == nil && bytes.Equal(value, values[key]))
+		} else { // 还没有可读的 key 时先写入
+			value := make([]byte, *size)
+			r.Read(value)
+			key := dht.KeyFromBytes(value)
+			begin := time.Now()
+			n, trace, err := writer.TraceSetValue(ctx, key[:], value)
+			putSamples.add(time.Since(begin), len(trace.Hops), err == nil && n > 1) // 不计写入节点自己的一份
+			keys = append(keys, key)
+			values[key] = value
+		}
+		cancel()
+	}
+	summary.Elapsed = time.Since(start).Round(time.Millisecond).String()
+	summary.Get = getSamples.summary()
+	summary.Put = putSamples.summary()
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+	for _, row := range []struct {
+		name string
+		s    benchOpSummary
+	}{{"GET", summary.Get}, {"PUT", summary.Put}} {
+		if row.s.Ops == 0 {
+			continue
+		}
+		fmt.Println()
+		fmt.Printf("%s  %d 次，成功率 %.1f%%（%d/%d）\n", row.name, row.s.Ops, 100*row.s.SuccessRate, row.s.OK, row.s.Ops)
+		fmt.Printf("  延迟  p50 %.1fms  p90 %.1fms  p99 %.1fms  最大 %.1fms\n", row.s.P50, row.s.P90, row.s.P99, row.s.Max)
+		fmt.Printf("  跳数  平均 %.2f  p90 %d  最大 %d\n", row.s.AvgHops, row.s.P90Hops, row.s.MaxHops)
+	}
+	fmt.Printf("\n用时 %s，种子 %d\n", summary.Elapsed, *seed)
+	return nil
+}
+
+// 创建一个只发起请求的临时节点：不回复其他节点的请求，也不为它们保存记录。
+// 结束时只关闭传输，不移交记录
+func newBenchPeer(cfg dht.Config, role string) (*dht.Peer, *dht.UDPTransport, error) {
+	cfg.ClientOnly = true
+	cfg.ClientOnlyWhenMetered = true
+	p, err := dht.NewPeerWithConfig(dht.KeyFromString(fmt.Sprintf("kbucketd-bench-%s-%d", role, time.Now().UnixNano())), cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.SetMetered(true)
+	t, err := dht.ListenUDP(p, ":0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, t, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 通过正在运行的节点写入一个值
+func put(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("put", &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("用法: kbucketd put <value>")
+	}
+	resp, err := adminRequest(s, http.MethodPost, "/put", strings.NewReader(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+	var result putResult
+	if err := json.Unmarshal(resp, &result); err != nil {
+		return err
+	}
+	fmt.Printf("key:      %s\nreplicas: %d\n", result.Key, result.Replicas)
+	return nil
+}
+
+// 通过正在运行的节点读取一个值
+func get(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("get", &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("用法: kbucketd get <key>")
+	}
+	if _, err := parseKey(fs.Arg(0)); err != nil {
+		return err
+	}
+	value, err := adminRequest(s, http.MethodGet, "/get?key="+fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(value)
+	fmt.Println()
+	return nil
+}
+
+// 输出正在运行的节点的路由表
+func peers(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("peers", &s, false)
+	jsonOut := fs.Bool("json", false, "输出 JSON")
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	resp, err := adminRequest(s, http.MethodGet, "/peers", nil)
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		os.Stdout.Write(resp)
+		return nil
+	}
+	var table struct {
+		Self    string `json:"self"`
+		Size    int    `json:"size"`
+		Buckets []struct {
+			Index   int     `json:"index"`
+			MeanRTT float64 `json:"mean_rtt_ms"`
+			Nodes   []struct {
+				ID       string     `json:"id"`
+				LastSeen *time.Time `json:"last_seen"`
+				RTT      float64    `json:"rtt_ms"`
+			} `json:"nodes"`
+		} `json:"buckets"`
+	}
+	if err := json.Unmarshal(resp, &table); err != nil {
+		return err
+	}
+	fmt.Printf("节点 %s，共 %d 个联系人\n", table.Self, table.Size)
+	for _, b := range table.Buckets {
+		if b.MeanRTT > 0 {
+			fmt.Printf("Bucket %d（平均 RTT %.1fms）:\n", b.Index, b.MeanRTT)
+		} else {
+			fmt.Printf("Bucket %d:\n", b.Index)
+		}
+		for _, n := range b.Nodes {
+			seen := "未验证"
+			if n.LastSeen != nil {
+				seen = time.Since(*n.LastSeen).Round(time.Second).String() + " 前"
+			}
+			if n.RTT > 0 {
+				seen += fmt.Sprintf("  RTT %.1fms", n.RTT)
+			}
+			fmt.Printf("  %s  %s\n", n.ID, seen)
+		}
+	}
+	return nil
+}
+
+// 用一个临时节点 ping addr
+func ping(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("ping", &s, false)
+	count := fs.Int("n", 1, "ping 的次数")
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("用法: kbucketd ping <addr>")
+	}
+	addr, err := net.ResolveUDPAddr("udp", fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	p := dht.NewPeer(dht.KeyFromString(fmt.Sprintf("kbucketd-ping-%d", time.Now().UnixNano())))
+	t, err := dht.ListenUDP(p, ":0")
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	var failed error
+	var lastID [kbucket.IdSize]byte
+	for i := 0; i < *count; i++ {
+		start := time.Now()
+		id, err := t.Ping(addr)
+		if err != nil {
+			fmt.Printf("%s: %v\n", addr, err)
+			failed = err
+			continue
+		}
+		lastID = id
+		fmt.Printf("%s: id=%x time=%v\n", addr, id, time.Since(start).Round(time.Microsecond))
+	}
+	if failed == nil {
+		if info, ok := p.PeerInfo(lastID); ok {
+			fmt.Printf("version=%s codecs=%s features=%s\n", info.Version,
+				strings.Join(info.Codecs, ","), strings.Join(info.Features, ","))
+		}
+	}
+	return failed
+}
+
+// 封禁节点 ID、CIDR 或 IP 地址，正在运行的节点立即生效
+func ban(args []string) error {
+	return changeBans("ban", http.MethodPost, args)
+}
+
+func unban(args []string) error {
+	return changeBans("unban", http.MethodDelete, args)
+}
+
+func changeBans(name, method string, args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet(name, &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法: kbucketd %s <节点 ID | CIDR | IP>", name)
+	}
+	b, err := dht.ParseBan(fs.Arg(0)) // 在本地先检查格式
+	if err != nil {
+		return err
+	}
+	resp, err := adminRequest(s, method, "/bans?target="+url.QueryEscape(b.String()), nil)
+	if err != nil {
+		return err
+	}
+	return printBans(resp)
+}
+
+// 输出正在运行的节点的封禁
+func bans(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("bans", &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	resp, err := adminRequest(s, http.MethodGet, "/bans", nil)
+	if err != nil {
+		return err
+	}
+	return printBans(resp)
+}
+
+// 输出正在运行的节点与网络的连通状态
+func status(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("status", &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	resp, err := adminRequest(s, http.MethodGet, "/status", nil)
+	if err != nil {
+		return err
+	}
+	var st statusResult
+	if err := json.Unmarshal(resp, &st); err != nil {
+		return err
+	}
+	fmt.Printf("connectivity:   %s\ncontacts:       %d\nfailed lookups: %d\nrejoins:        %d\n",
+		st.Connectivity, st.Contacts, st.FailedLookups, st.Rejoins)
+	if st.LastResponse != nil {
+		fmt.Printf("last response:  %s\n", st.LastResponse.Format(time.RFC3339))
+	}
+	if st.NextRejoin != nil {
+		fmt.Printf("next rejoin:    %s\n", st.NextRejoin.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// 让正在运行的节点立即迁移旧哈希函数的 key
+func migrateKeys(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("migrate-keys", &s, false)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	resp, err := adminRequest(s, http.MethodPost, "/migrate-keys", nil)
+	if err != nil {
+		return err
+	}
+	var r migrateResult
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return err
+	}
+	fmt.Printf("legacy:   %d\nmigrated: %d\nfailed:   %d\ndropped:  %d\n", r.Legacy, r.Migrated, r.Failed, r.Dropped)
+	return nil
+}
+
+func printBans(resp []byte) error {
+	var list struct {
+		Bans []string `json:"bans"`
+	}
+	if err := json.Unmarshal(resp, &list); err != nil {
+		return err
+	}
+	if len(list.Bans) == 0 {
+		fmt.Println("没有封禁")
+	}
+	for _, b := range list.Bans {
+		fmt.Println(b)
+	}
+	return nil
+}
+
+// 向管理接口发送请求，返回响应体。非 2xx 的响应作为错误返回
+func adminRequest(s settings, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://"+s.Admin+path, body)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: s.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
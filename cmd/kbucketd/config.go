@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListen = "0.0.0.0:4000"
+	defaultAdmin  = "127.0.0.1:4001"
+)
+
+// 各子命令共用的设置，可以来自命令行参数或 YAML 配置文件
+type settings struct {
+	Listen     string   // 节点监听的 UDP 地址
+	Admin      string   // 本地管理接口的 HTTP 地址
+	Bootstrap  []string // 种子节点的 "host:port"
+	Identity   string   // 节点密钥文件，不存在时生成
+	Bans       string   // 封禁列表文件，为空时封禁不保存
+	DataDir    string   // 记录的保存目录，为空时记录只保存在内存中
+	MaxRecords int      // 内存中最多保存的记录数，0 表示不限制
+	StoreQueue int      // 排队写入的 STORE 数，超出时写入临时文件，0 表示直接写入
+	PeerRate   float64  // 每个远端节点每秒允许的请求数，0 表示不限制
+	GlobalRate float64  // 所有节点合计每秒允许的请求数，0 表示不限制
+	MaxStore   int      // 单个 STORE 的值的最大字节数，0 表示不限制
+	K          int
+	Flat       int // 扁平路由表最多保存的联系人数，0 表示使用普通路由表
+	Alpha      int
+	RecordTTL  time.Duration
+	CacheDecay bool          // 远离 key 的缓存副本按距离缩短有效期
+	Timeout    time.Duration // 单次命令的超时时间
+
+	BootstrapJitter time.Duration // 加入网络之前随机等待的最长时间，避免同时启动的节点一齐联系种子
+	BootstrapRate   float64       // 每秒最多开始几次加入，包括断开后的重新加入，0 表示不限制
+	FindNodeCache   time.Duration // 热门 FIND_NODE 目标的响应缓存时间，0 表示不缓存
+
+	ValidateContacts string // 查找响应中联系人的公钥检查：off、permissive 或 strict，为空时不检查
+
+	Hash        string // 计算 key 的哈希函数（sha1 或 sha256），为空时按 ID 长度选择
+	LegacyHash  string // 更换哈希函数期间仍然接受的旧哈希函数，为空表示没有迁移
+	LegacyUntil string // 兼容旧哈希函数的截止时间（RFC 3339），为空表示一直兼容
+}
+
+func defaultSettings() settings {
+	return settings{Listen: defaultListen, Admin: defaultAdmin, Timeout: 30 * time.Second}
+}
+
+// 逗号分隔的列表参数，每次设置都替换原有的值
+type listFlag struct{ list *[]string }
+
+func (f listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f listFlag) Set(v string) error {
+	*f.list = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*f.list = append(*f.list, s)
+		}
+	}
+	return nil
+}
+
+// 为子命令定义参数，node 表示命令会启动节点，需要节点相关的参数
+func newFlagSet(name string, s *settings, node bool) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	config := fs.String("config", "", "YAML 配置文件")
+	fs.StringVar(&s.Admin, "admin", s.Admin, "本地管理接口的地址")
+	fs.DurationVar(&s.Timeout, "timeout", s.Timeout, "命令的超时时间")
+	if node {
+		fs.StringVar(&s.Listen, "listen", s.Listen, "节点监听的 UDP 地址")
+		fs.Var(listFlag{&s.Bootstrap}, "bootstrap", "种子节点的地址，多个地址用逗号分隔")
+		fs.StringVar(&s.Identity, "identity", s.Identity, "节点密钥文件，不存在时生成，为空时使用临时身份")
+		fs.StringVar(&s.Bans, "bans", s.Bans, "封禁列表文件，重启后恢复封禁，为空时不保存")
+		fs.StringVar(&s.DataDir, "data", s.DataDir, "记录的保存目录，重启后恢复记录，为空时只保存在内存中")
+		fs.IntVar(&s.MaxRecords, "max-records", s.MaxRecords, "内存中最多保存的记录数，超出时淘汰最久未使用的记录，0 表示不限制")
+		fs.IntVar(&s.StoreQueue, "store-queue", s.StoreQueue, "收到的 STORE 在内存中排队的数量，超出时写入临时文件，0 表示不排队")
+		fs.Float64Var(&s.PeerRate, "peer-rate", s.PeerRate, "每个远端节点每秒允许的请求数，持续超出的节点被临时封禁，0 表示不限制")
+		fs.Float64Var(&s.GlobalRate, "global-rate", s.GlobalRate, "所有节点合计每秒允许的请求数，0 表示不限制")
+		fs.IntVar(&s.MaxStore, "max-store-size", s.MaxStore, "单个 STORE 的值的最大字节数，0 表示不限制")
+		fs.IntVar(&s.K, "k", s.K, "每个 bucket 的容量，0 表示默认值")
+		fs.IntVar(&s.Flat, "flat", s.Flat, "使用不分裂的扁平路由表并最多保存 N 个联系人，适合约 200 个节点以下的小网络，0 表示普通路由表")
+		fs.IntVar(&s.Alpha, "alpha", s.Alpha, "查找每轮并发查询的节点数，0 表示默认值")
+		fs.DurationVar(&s.RecordTTL, "ttl", s.RecordTTL, "记录的有效期，0 表示默认值")
+		fs.BoolVar(&s.CacheDecay, "cache-decay", s.CacheDecay, "远离 key 的缓存副本的有效期随更近的节点数指数缩短且不重新发布")
+		fs.DurationVar(&s.BootstrapJitter, "bootstrap-jitter", s.BootstrapJitter, "加入网络之前随机等待的最长时间，大量节点同时启动时避免一齐联系种子节点")
+		fs.DurationVar(&s.FindNodeCache, "find-node-cache", s.FindNodeCache, "热门 FIND_NODE 目标的响应缓存时间，查询集中在少数 key 时减少路由表查询，0 表示不缓存")
+		fs.StringVar(&s.ValidateContacts, "validate-contacts", s.ValidateContacts, "查找响应中联系人的公钥检查：off、permissive（拒绝 ID 与公钥不符的联系人）或 strict（同时拒绝没有公钥的联系人）")
+		fs.Float64Var(&s.BootstrapRate, "bootstrap-rate", s.BootstrapRate, "每秒最多开始几次加入，包括断开后的重新加入，0 表示不限制")
+		fs.StringVar(&s.Hash, "hash", s.Hash, "计算 key 的哈希函数：sha1 或 sha256，为空时按 ID 长度选择")
+		fs.StringVar(&s.LegacyHash, "legacy-hash", s.LegacyHash, "换用 -hash 期间仍然接受的旧哈希函数，旧 key 的记录在后台以新 key 重新发布")
+		fs.StringVar(&s.LegacyUntil, "legacy-until", s.LegacyUntil, "兼容 -legacy-hash 的截止时间（RFC 3339），之后删除旧 key 的记录，为空表示一直兼容")
+	}
+	return fs, config
+}
+
+// 解析参数：先读取配置文件，再用命令行参数覆盖
+func parseArgs(fs *flag.FlagSet, config *string, s *settings, args []string) error {
+	fs.Parse(args)
+	if *config == "" {
+		return nil
+	}
+	if err := loadConfig(*config, s); err != nil {
+		return err
+	}
+	fs.Parse(args) // 再次解析，使命令行参数优先
+	return nil
+}
+
+// 读取 YAML 配置文件。只支持本工具需要的子集：顶层的 "key: value"、
+// 行内列表 "[a, b]" 与 "- item" 形式的列表，以及 # 注释
+func loadConfig(path string, s *settings) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var list *[]string // 正在读取的 "- item" 列表
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := stripComment(sc.Text())
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if list == nil {
+				return fmt.Errorf("%s:%d: 列表项不属于任何列表", path, line)
+			}
+			*list = append(*list, unquote(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+		list = nil
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || text != strings.TrimLeft(text, " \t") {
+			return fmt.Errorf("%s:%d: 无法解析: %s", path, line, trimmed)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if err := s.set(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, line, key, err)
+		}
+		if key == "bootstrap" && value == "" {
+			s.Bootstrap = nil
+			list = &s.Bootstrap
+		}
+	}
+	return sc.Err()
+}
+
+func (s *settings) set(key, value string) error {
+	var err error
+	switch key {
+	case "listen":
+		s.Listen = unquote(value)
+	case "admin":
+		s.Admin = unquote(value)
+	case "identity":
+		s.Identity = unquote(value)
+	case "bans":
+		s.Bans = unquote(value)
+	case "data":
+		s.DataDir = unquote(value)
+	case "bootstrap":
+		s.Bootstrap = nil
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = unquote(strings.TrimSpace(item)); item != "" {
+					s.Bootstrap = append(s.Bootstrap, item)
+				}
+			}
+		} else if value != "" {
+			s.Bootstrap = []string{unquote(value)}
+		}
+	case "max-records":
+		s.MaxRecords, err = strconv.Atoi(value)
+	case "store-queue":
+		s.StoreQueue, err = strconv.Atoi(value)
+	case "peer-rate":
+		s.PeerRate, err = strconv.ParseFloat(value, 64)
+	case "global-rate":
+		s.GlobalRate, err = strconv.ParseFloat(value, 64)
+	case "max-store-size":
+		s.MaxStore, err = strconv.Atoi(value)
+	case "k":
+		s.K, err = strconv.Atoi(value)
+	case "flat":
+		s.Flat, err = strconv.Atoi(value)
+	case "alpha":
+		s.Alpha, err = strconv.Atoi(value)
+	case "ttl":
+		s.RecordTTL, err = time.ParseDuration(unquote(value))
+	case "cache-decay":
+		s.CacheDecay, err = strconv.ParseBool(value)
+	case "hash":
+		s.Hash = unquote(value)
+	case "legacy-hash":
+		s.LegacyHash = unquote(value)
+	case "legacy-until":
+		s.LegacyUntil = unquote(value)
+	case "bootstrap-jitter":
+		s.BootstrapJitter, err = time.ParseDuration(unquote(value))
+	case "bootstrap-rate":
+		s.BootstrapRate, err = strconv.ParseFloat(value, 64)
+	case "find-node-cache":
+		s.FindNodeCache, err = time.ParseDuration(unquote(value))
+	case "validate-contacts":
+		s.ValidateContacts = unquote(value)
+	case "timeout":
+		s.Timeout, err = time.ParseDuration(unquote(value))
+	default:
+		return fmt.Errorf("未知的设置")
+	}
+	return err
+}
+
+// 去掉 # 开始的注释，引号内的 # 保留
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/simulator"
+)
+
+// demo 的结果，-json 时原样输出
+type demoSummary struct {
+	Seed         int64   `json:"seed"`
+	Peers        int     `json:"peers"`
+	K            int     `json:"k"`
+	Alpha        int     `json:"alpha"`
+	Keys         int     `json:"keys"`
+	Gets         int     `json:"gets"`
+	Found        int     `json:"found"`
+	SuccessRate  float64 `json:"success_rate"`
+	AvgHops      float64 `json:"avg_hops"`
+	MeanReplicas float64 `json:"mean_replicas"`
+	Replicas     []int   `json:"replicas"`
+	Attackers    int     `json:"attackers,omitempty"`
+	Accept       string  `json:"accept,omitempty"`
+	Forged       int     `json:"forged,omitempty"`
+	ForgedShare  float64 `json:"forged_share,omitempty"`
+	PeakLoad     int     `json:"peak_load,omitempty"`
+	JoinSpan     string  `json:"join_span,omitempty"`
+}
+
+// 在进程内创建一个模拟网络，逐步演示加入、写入与读取，最后输出成功率与平均跳数
+func demo(args []string) error {
+	cfg := simulator.Config{DHT: dht.DefaultConfig()}
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	fs.IntVar(&cfg.Peers, "peers", simulator.DefaultPeers, "节点数量")
+	fs.IntVar(&cfg.Keys, "keys", simulator.DefaultKeys, "写入的键值对数量")
+	fs.IntVar(&cfg.Gets, "gets", simulator.DefaultGets, "读取次数")
+	fs.IntVar(&cfg.DHT.K, "k", cfg.DHT.K, "每个 bucket 的容量")
+	fs.IntVar(&cfg.DHT.Alpha, "alpha", cfg.DHT.Alpha, "查找每轮并发查询的节点数")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "随机数种子，相同的参数与种子得到相同的结果")
+	fs.IntVar(&cfg.Attackers, "attackers", 0, "对 FIND_NODE 回复伪造联系人的恶意节点数")
+	accept := fs.String("accept", cfg.DHT.ContactAcceptance.String(), "查找响应中的联系人如何加入路由表：responders、all 或 verified")
+	fs.IntVar(&cfg.Seeds, "seeds", 0, "其余节点加入时使用的种子节点数，0 表示任意已有节点")
+	fs.DurationVar(&cfg.StartJitter, "start-jitter", 0, "节点在这段仿真时间内随机错开启动")
+	fs.Float64Var(&cfg.JoinRate, "join-rate", 0, "所有节点共用的加入限速，每秒开始加入的节点数，0 表示不限制")
+	jsonOut := fs.Bool("json", false, "只输出 JSON 格式的结果")
+	fs.Parse(args)
+	var err error
+	if cfg.DHT.ContactAcceptance, err = dht.ParseContactAcceptance(*accept); err != nil {
+		return err
+	}
+	if cfg.Peers < 1 || cfg.Keys < 1 || cfg.Gets < 1 {
+		return errors.New("-peers、-keys 与 -gets 必须大于 0")
+	}
+
+	if !*jsonOut {
+		fmt.Printf("1. 创建 %d 个节点（k=%d，α=%d），每个新节点通过一个已有节点加入网络，并查找自己的 ID 填充路由表\n",
+			cfg.Peers, cfg.DHT.K, cfg.DHT.Alpha)
+		fmt.Printf("2. 由随机节点写入 %d 个键值对，key 是值的哈希，值复制到离 key 最近的节点上\n", cfg.Keys)
+		fmt.Printf("3. 由随机节点读取 %d 次，每一轮并发询问 %d 个更接近 key 的节点，直到找到值\n", cfg.Gets, cfg.DHT.Alpha)
+	}
+	report, err := simulator.Run(cfg)
+	if err != nil {
+		return err
+	}
+	summary := demoSummary{
+		Seed:         report.Seed,
+		Peers:        report.Peers,
+		K:            cfg.DHT.K,
+		Alpha:        cfg.DHT.Alpha,
+		Keys:         cfg.Keys,
+		Gets:         report.Gets,
+		Found:        report.Found,
+		SuccessRate:  report.SuccessRate,
+		AvgHops:      report.AvgHops,
+		MeanReplicas: report.MeanReplicas,
+		Replicas:     report.Replicas,
+	}
+	if report.Attackers > 0 {
+		summary.Attackers = report.Attackers
+		summary.Accept = cfg.DHT.ContactAcceptance.String()
+		summary.Forged = report.Forged
+		summary.ForgedShare = report.ForgedShare
+	}
+	if report.PeakLoad > 0 {
+		summary.PeakLoad = report.PeakLoad
+		summary.JoinSpan = report.JoinSpan.String()
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+	fmt.Println()
+	fmt.Printf("成功率      %.1f%%（%d/%d）\n", 100*summary.SuccessRate, summary.Found, summary.Gets)
+	fmt.Printf("平均跳数    %.2f\n", summary.AvgHops)
+	fmt.Printf("平均副本数  %.2f\n", summary.MeanReplicas)
+	if summary.Attackers > 0 {
+		fmt.Printf("伪造联系人  %d（路由表条目的 %.1f%%，%d 个恶意节点，-accept=%s）\n",
+			summary.Forged, 100*summary.ForgedShare, summary.Attackers, summary.Accept)
+	}
+	if summary.PeakLoad > 0 {
+		fmt.Printf("加入负载    单个节点每秒最多应答 %d 个请求，加入过程持续 %s\n", summary.PeakLoad, summary.JoinSpan)
+	}
+	fmt.Printf("种子        %d\n", summary.Seed)
+	return nil
+}
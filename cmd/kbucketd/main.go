@@ -0,0 +1,73 @@
+// kbucketd 运行一个 DHT 节点并通过它读写数据，不需要编写 Go 代码：
+//
+//	kbucketd serve -listen 0.0.0.0:4000 -bootstrap 10.0.0.2:4000
+//	kbucketd put "hello"
+//	kbucketd get <key>
+//	kbucketd peers
+//	kbucketd status
+//	kbucketd ping 10.0.0.2:4000
+//	kbucketd ban 10.0.0.0/8
+//	kbucketd migrate-keys
+//	kbucketd demo -peers 100 -keys 200
+//	kbucketd bench -bootstrap 10.0.0.2:4000 -ops 1000 -ratio 80/20
+//
+// demo 不需要正在运行的节点，它在进程内模拟一个网络演示 DHT 的工作过程。
+// bench 以客户端模式加入正在运行的网络，测量读写的延迟、成功率与跳数。
+// serve 在 -admin 地址上提供本地管理接口，put、get 与 peers 通过它访问正在运行的节点。
+// 每个子命令都可以用 -config 读取 YAML 配置文件，命令行参数优先于配置文件
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `用法: kbucketd <命令> [参数]
+
+命令:
+  serve         启动节点
+  put <value>   写入一个值，输出它的 key
+  get <key>     读取 key（十六进制）对应的值
+  peers         输出节点的路由表
+  status        输出节点与网络的连通状态
+  ping <addr>   ping 一个节点，输出它的 ID 与往返时间
+  ban <target>  封禁节点 ID、CIDR 或 IP 地址
+  unban <target>
+                解除封禁
+  bans          输出当前的封禁
+  migrate-keys  以 -hash 的 key 重新发布 -legacy-hash 的记录，节点也会在后台定期迁移
+  demo          在进程内模拟一个网络，演示写入与读取并输出成功率与平均跳数
+  bench         以客户端模式对正在运行的网络执行混合读写，输出延迟分位数、成功率与跳数
+
+使用 kbucketd <命令> -h 查看命令的参数
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	commands := map[string]func(args []string) error{
+		"serve":        serve,
+		"put":          put,
+		"get":          get,
+		"peers":        peers,
+		"status":       status,
+		"ping":         ping,
+		"ban":          ban,
+		"unban":        unban,
+		"bans":         bans,
+		"migrate-keys": migrateKeys,
+		"demo":         demo,
+		"bench":        bench,
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "kbucketd:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const maxPutSize = 64 << 10 // 管理接口接受的最大值，与 UDP 报文能携带的大小相当
+
+const closeTimeout = 10 * time.Second // 退出时移交记录与通知邻居的最长时间
+
+const defaultHotKeys = 20 // /hot-keys 默认返回的 key 数
+
+// 启动节点并提供管理接口，直到收到 SIGINT 或 SIGTERM
+func serve(args []string) error {
+	s := defaultSettings()
+	fs, config := newFlagSet("serve", &s, true)
+	if err := parseArgs(fs, config, &s, args); err != nil {
+		return err
+	}
+	cfg := dht.DefaultConfig()
+	cfg.HandoffOnClose = true
+	cfg.SyncOnJoin = true
+	cfg.FreshnessThreshold = dht.DefaultFreshnessThreshold
+	if s.K > 0 {
+		cfg.K = s.K
+	}
+	if s.Alpha > 0 {
+		cfg.Alpha = s.Alpha
+	}
+	if s.RecordTTL > 0 {
+		cfg.RecordTTL = s.RecordTTL
+		if cfg.RepublishInterval >= s.RecordTTL { // 较短的有效期需要更频繁的重新发布
+			cfg.RepublishInterval = s.RecordTTL / 2
+		}
+	}
+	cfg.CacheDecay = s.CacheDecay
+	cfg.MaxRecords = s.MaxRecords
+	cfg.PeerRequestRate = s.PeerRate
+	cfg.GlobalRequestRate = s.GlobalRate
+	cfg.MaxStoreSize = s.MaxStore
+	cfg.FlatTable = s.Flat
+	cfg.BootstrapJitter = s.BootstrapJitter
+	cfg.FindNodeCacheTTL = s.FindNodeCache
+	if s.BootstrapRate > 0 {
+		cfg.BootstrapLimiter = dht.NewBootstrapLimiter(s.BootstrapRate, 1)
+	}
+	if s.ValidateContacts != "" {
+		var err error
+		if cfg.ContactValidation, err = dht.ParseContactValidation(s.ValidateContacts); err != nil {
+			return err
+		}
+	}
+	if err := setHashes(&cfg, s); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil { // 在创建密钥文件与监听之前报告配置错误
+		return err
+	}
+	priv, err := loadOrCreateIdentity(s.Identity)
+	if err != nil {
+		return err
+	}
+	p, err := dht.NewPeerWithIdentity(priv, cfg)
+	if err != nil {
+		return err
+	}
+	if s.DataDir != "" {
+		if s.MaxRecords > 0 {
+			log.Printf("使用 -data 时记录保存在磁盘上，忽略 -max-records")
+		}
+		store, err := dht.OpenDiskStorage(s.DataDir)
+		if err != nil {
+			return err
+		}
+		if err := p.SetStorage(store); err != nil {
+			return err
+		}
+		log.Printf("记录保存在 %s，已有 %d 条", s.DataDir, store.Len())
+	}
+	if err := p.SetStoreQueue(s.StoreQueue, ""); err != nil {
+		return err
+	}
+	if s.Bans != "" { // 在开始通信之前恢复封禁
+		if err := p.SetBanFile(s.Bans); err != nil {
+			return err
+		}
+		if bans := p.Bans(); len(bans) > 0 {
+			log.Printf("已恢复 %d 条封禁", len(bans))
+		}
+	}
+	t, err := dht.ListenUDP(p, s.Listen)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	t.SetPacketLogger(log.Default(), 0)
+	id := p.ID()
+	log.Printf("节点 %x 监听 %s", id, t.Addr())
+
+	var seeds []dht.Contact
+	for _, addr := range s.Bootstrap {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return fmt.Errorf("种子节点 %s: %v", addr, err)
+		}
+		seeds = append(seeds, dht.Contact{Addr: udpAddr})
+	}
+	if len(seeds) > 0 {
+		if err := p.Bootstrap(seeds); err != nil {
+			log.Printf("加入网络失败: %v", err) // 继续运行，等待其他节点联系
+		} else {
+			log.Printf("已加入网络，路由表中有 %d 个节点", p.KBucket().Size())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	p.Start()
+	go p.RunJanitor(ctx, 0)
+
+	ln, err := net.Listen("tcp", s.Admin)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: adminHandler(p)}
+	go srv.Serve(ln)
+	log.Printf("管理接口 http://%s", ln.Addr())
+
+	<-ctx.Done()
+	log.Printf("正在停止，移交本地记录并通知邻居")
+	srv.Close()
+	closeCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return p.Close(closeCtx)
+}
+
+// 按 -hash 更换计算 key 的哈希函数，并按 -legacy-hash 与 -legacy-until 设置兼容窗口
+func setHashes(cfg *dht.Config, s settings) error {
+	if s.Hash != "" {
+		h, err := parseHasher(s.Hash)
+		if err != nil {
+			return err
+		}
+		if err := dht.SetHasher(h); err != nil {
+			return err
+		}
+	}
+	if s.LegacyHash != "" {
+		h, err := parseHasher(s.LegacyHash)
+		if err != nil {
+			return err
+		}
+		cfg.LegacyHasher = h
+	}
+	if s.LegacyUntil != "" {
+		until, err := time.Parse(time.RFC3339, s.LegacyUntil)
+		if err != nil {
+			return fmt.Errorf("-legacy-until: %v", err)
+		}
+		cfg.LegacyUntil = until
+	}
+	return nil
+}
+
+// sha256 在 20 字节 ID 的构建中截断为 160 位
+func parseHasher(name string) (dht.Hasher, error) {
+	switch name {
+	case "sha1":
+		return dht.SHA1, nil
+	case "sha256":
+		if kbucket.IdSize < sha256.Size {
+			return dht.Truncated(dht.SHA256, kbucket.IdSize), nil
+		}
+		return dht.SHA256, nil
+	}
+	return nil, fmt.Errorf("未知的哈希函数 %q，应为 sha1 或 sha256", name)
+}
+
+// 读取密钥文件，不存在时生成并保存。path 为空时使用临时身份
+func loadOrCreateIdentity(path string) (ed25519.PrivateKey, error) {
+	if path != "" {
+		priv, err := dht.LoadIdentity(path)
+		if !errors.Is(err, os.ErrNotExist) {
+			return priv, err
+		}
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		if err := dht.SaveIdentity(path, priv); err != nil {
+			return nil, err
+		}
+	}
+	return priv, nil
+}
+
+// 本地管理接口：
+//
+//	GET  /peers       路由表（JSON）
+//	POST /put         请求体为值，返回 {"key": ..., "replicas": ...}
+//	GET  /get?key=hex 值本身，不存在时返回 404，已过期或已删除时返回 410，记录冲突时返回 409
+//	GET  /aging       路由表老化数据，见 Peer.AgingHandler
+//	GET  /regions     路由表各区域的分裂、批量淘汰与刷新事件，?key= 只看该 key 所在的区域，见 Peer.RegionHistoryHandler
+//	GET  /bans        当前的封禁；POST 或 DELETE /bans?target= 封禁或解除，见 Peer.BanHandler
+//	GET  /status      与网络的连通状态（JSON），见 Peer.Status
+//	GET  /diversity   路由表的多样性与警告（JSON），见 Peer.DiversityReport
+//	GET  /invalid     最近丢弃的无效数据包（JSON），见 Peer.InvalidPacketHandler
+//	GET  /freshness   联系人距上次确认存活的时间分布与后台 ping 的统计（JSON），见 Peer.Freshness
+//	POST /migrate-keys 立即以新哈希函数的 key 重新发布旧 key 的记录（JSON），见 Peer.MigrateKeys
+//	GET  /hot-keys?k=n 本节点上访问最多的 n 个 key 与估计的 GET/STORE 次数（JSON，默认 20），见 Peer.HotKeys
+func adminHandler(p *dht.Peer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.KBucket())
+	})
+	mux.HandleFunc("/put", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "需要 POST", http.StatusMethodNotAllowed)
+			return
+		}
+		value, err := io.ReadAll(io.LimitReader(r.Body, maxPutSize+1))
+		if err != nil || len(value) == 0 || len(value) > maxPutSize {
+			http.Error(w, "值为空或过大", http.StatusBadRequest)
+			return
+		}
+		key := dht.KeyFromBytes(value)
+		n, err := p.SetValue(r.Context(), key[:], value)
+		if err != nil && n == 0 {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(putResult{Key: hex.EncodeToString(key[:]), Replicas: n})
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		key, err := parseKey(r.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := p.Get(r.Context(), key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		switch result.Status {
+		case dht.StatusFound:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(result.Value)
+		case dht.StatusExpired, dht.StatusTombstoned:
+			http.Error(w, result.Status.String(), http.StatusGone)
+		case dht.StatusConflict:
+			http.Error(w, result.Status.String(), http.StatusConflict)
+		default:
+			http.Error(w, result.Status.String(), http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		st := p.Status()
+		result := statusResult{
+			Connectivity:  st.Connectivity.String(),
+			Contacts:      st.Contacts,
+			FailedLookups: st.FailedLookups,
+			Rejoins:       st.Rejoins,
+		}
+		if !st.LastResponse.IsZero() {
+			result.LastResponse = &st.LastResponse
+		}
+		if !st.NextRejoin.IsZero() {
+			result.NextRejoin = &st.NextRejoin
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/diversity", func(w http.ResponseWriter, r *http.Request) {
+		d := p.DiversityReport(dht.DiversityThresholds{})
+		result := diversityResult{
+			Contacts:    d.Contacts,
+			Depth:       d.Depth,
+			Covered:     d.Covered,
+			Subnets:     d.Subnets,
+			Versions:    d.Versions,
+			Unverified:  d.Unverified,
+			MeanAgeSec:  d.MeanAge.Seconds(),
+			NewContacts: d.NewContacts,
+			Warnings:    []string{},
+		}
+		for _, warning := range d.Warnings {
+			result.Warnings = append(result.Warnings, warning.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/freshness", func(w http.ResponseWriter, r *http.Request) {
+		f := p.Freshness()
+		result := freshnessResult{
+			Contacts:      f.Contacts,
+			Unverified:    f.Unverified,
+			Stale:         f.Stale,
+			Due:           f.Due,
+			Ages:          map[string]int{},
+			MedianSec:     f.Median.Seconds(),
+			OldestSec:     f.Oldest.Seconds(),
+			Probes:        f.Probes,
+			ProbeFailures: f.ProbeFailures,
+		}
+		for _, b := range f.Ages {
+			label := "older"
+			if b.UpTo > 0 {
+				label = b.UpTo.String()
+			}
+			result.Ages[label] = b.Contacts
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/migrate-keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "需要 POST", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := p.MigrateKeys(r.Context())
+		if errors.Is(err, dht.ErrNoLegacyHasher) {
+			http.Error(w, "节点没有设置 -legacy-hash", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(migrateResult{
+			Legacy:   report.Legacy,
+			Migrated: report.Migrated,
+			Failed:   report.Failed,
+			Dropped:  report.Dropped,
+		})
+	})
+	mux.HandleFunc("/hot-keys", func(w http.ResponseWriter, r *http.Request) {
+		k := defaultHotKeys
+		if s := r.URL.Query().Get("k"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "k 应为正整数", http.StatusBadRequest)
+				return
+			}
+			k = n
+		}
+		result := []hotKeyResult{}
+		for _, u := range p.HotKeys(k) {
+			result = append(result, hotKeyResult{Key: hex.EncodeToString(u.Key[:]), Gets: u.Gets, Stores: u.Stores})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.Handle("/aging", p.AgingHandler())
+	mux.Handle("/regions", p.RegionHistoryHandler())
+	mux.Handle("/invalid", p.InvalidPacketHandler())
+	mux.Handle("/bans", p.BanHandler())
+	return mux
+}
+
+type putResult struct {
+	Key      string `json:"key"`
+	Replicas int    `json:"replicas"`
+}
+
+type statusResult struct {
+	Connectivity  string     `json:"connectivity"`
+	Contacts      int        `json:"contacts"`
+	FailedLookups int        `json:"failed_lookups"`
+	LastResponse  *time.Time `json:"last_response,omitempty"`
+	Rejoins       int        `json:"rejoins"`
+	NextRejoin    *time.Time `json:"next_rejoin,omitempty"`
+}
+
+type diversityResult struct {
+	Contacts    int            `json:"contacts"`
+	Depth       int            `json:"depth"`
+	Covered     int            `json:"covered"`
+	Subnets     map[string]int `json:"subnets"`
+	Versions    map[string]int `json:"versions"`
+	Unverified  int            `json:"unverified"`
+	MeanAgeSec  float64        `json:"mean_age_sec"`
+	NewContacts int            `json:"new_contacts"`
+	Warnings    []string       `json:"warnings"`
+}
+
+type freshnessResult struct {
+	Contacts      int            `json:"contacts"`
+	Unverified    int            `json:"unverified"`
+	Stale         int            `json:"stale"`
+	Due           int            `json:"due"`
+	Ages          map[string]int `json:"ages"` // 区间上界 → 联系人数，"older" 为更久或从未确认存活
+	MedianSec     float64        `json:"median_sec"`
+	OldestSec     float64        `json:"oldest_sec"`
+	Probes        uint64         `json:"probes"`
+	ProbeFailures uint64         `json:"probe_failures"`
+}
+
+type hotKeyResult struct {
+	Key    string `json:"key"`
+	Gets   uint32 `json:"gets"`
+	Stores uint32 `json:"stores"`
+}
+
+type migrateResult struct {
+	Legacy   int `json:"legacy"`
+	Migrated int `json:"migrated"`
+	Failed   int `json:"failed"`
+	Dropped  int `json:"dropped"`
+}
+
+func parseKey(s string) ([kbucket.IdSize]byte, error) {
+	var key [kbucket.IdSize]byte
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != kbucket.IdSize {
+		return key, fmt.Errorf("key 应为 %d 字节的十六进制: %q", kbucket.IdSize, s)
+	}
+	copy(key[:], raw)
+	return key, nil
+}
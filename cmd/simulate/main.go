@@ -0,0 +1,51 @@
+// simulate 运行一次可复现的仿真：创建一批节点，随机写入并读取键值对，输出统计报告
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/simulator"
+)
+
+func main() {
+	cfg := simulator.Config{DHT: dht.DefaultConfig()}
+	flag.IntVar(&cfg.Peers, "peers", simulator.DefaultPeers, "节点数量")
+	flag.IntVar(&cfg.Keys, "keys", simulator.DefaultKeys, "写入的键值对数量")
+	flag.IntVar(&cfg.Gets, "gets", simulator.DefaultGets, "读取次数")
+	flag.Float64Var(&cfg.ChurnRate, "churn", 0, "每次读写之后发生节点更替的概率")
+	flag.Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "随机数种子")
+	flag.IntVar(&cfg.DHT.K, "k", cfg.DHT.K, "每个 bucket 的容量")
+	flag.IntVar(&cfg.DHT.Alpha, "alpha", cfg.DHT.Alpha, "查找每轮并发查询的节点数")
+	flag.IntVar(&cfg.DHT.ReplicationFactor, "replication", cfg.DHT.ReplicationFactor, "读取时每一跳查询的节点数")
+	profiles := flag.String("profiles", "", "节点类别，例如 server:3,home:5:uptime=0.6:bw=20,mobile:2:client")
+	metric := flag.String("metric", "xor", "距离度量：xor、ring 或 linear")
+	strategy := flag.String("strategy", "kademlia", "路由策略：kademlia 或作为比较基线的 chord")
+	flag.Parse()
+
+	var err error
+	if cfg.DHT.Metric, err = simulator.ParseMetric(*metric); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.Strategy, err = simulator.ParseStrategy(*strategy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *profiles != "" {
+		if cfg.Profiles, err = simulator.ParseProfiles(*profiles); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	report, err := simulator.Run(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fmt.Print(report)
+}
@@ -0,0 +1,44 @@
+// vanity 生成节点 ID 落在指定前缀范围内的 ed25519 密钥对
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func main() {
+	prefixHex := flag.String("prefix", "", "目标前缀（十六进制）")
+	bits := flag.Int("bits", -1, "需要匹配的前缀比特数，默认为 prefix 的全部比特")
+	workers := flag.Int("workers", 0, "并行搜索的 goroutine 数，0 表示使用全部 CPU")
+	flag.Parse()
+
+	raw, err := hex.DecodeString(*prefixHex)
+	if err != nil || len(raw) > kbucket.IdSize {
+		fmt.Fprintln(os.Stderr, "无效的前缀:", *prefixHex)
+		os.Exit(2)
+	}
+	var prefix [kbucket.IdSize]byte
+	copy(prefix[:], raw)
+	if *bits < 0 {
+		*bits = len(raw) * 8
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	priv, id, err := dht.GrindKey(ctx, prefix, *bits, *workers, func(p dht.GrindProgress) {
+		fmt.Fprintf(os.Stderr, "已尝试 %d 个密钥，%.0f 个/秒\n", p.Tries, p.Rate)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("id:   %x\n", id)
+	fmt.Printf("seed: %x\n", priv.Seed())
+}
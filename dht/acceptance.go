@@ -0,0 +1,72 @@
+package dht
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 迭代查找从响应中学到的联系人如何进入路由表
+type ContactAcceptance int
+
+const (
+	// 只有回复过请求的节点加入路由表，响应中的联系人只用于本次查找（默认）
+	AcceptResponders ContactAcceptance = iota
+	// 响应中的联系人直接加入路由表：路由表填充最快，但恶意节点可以用伪造的联系人污染路由表
+	AcceptAll
+	// 响应中的联系人在后台 ping 一次，回复之后才加入路由表
+	AcceptVerified
+)
+
+func (a ContactAcceptance) String() string {
+	switch a {
+	case AcceptResponders:
+		return "responders"
+	case AcceptAll:
+		return "all"
+	case AcceptVerified:
+		return "verified"
+	}
+	return fmt.Sprintf("ContactAcceptance(%d)", int(a))
+}
+
+// 解析 String 的输出
+func ParseContactAcceptance(s string) (ContactAcceptance, error) {
+	for a := AcceptResponders; a <= AcceptVerified; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("dht: unknown contact acceptance %q", s)
+}
+
+// 按 Config.ContactAcceptance 处理一轮查找从响应中学到的联系人，在后台进行，不推迟查找
+func (p *Peer) acceptLearned(nodes []kbucket.Node) {
+	if p.cfg.ContactAcceptance == AcceptResponders || len(nodes) == 0 {
+		return
+	}
+	var fresh []kbucket.Node
+	for _, n := range nodes {
+		if n.ID == p.node.ID {
+			continue
+		}
+		if _, known := p.kb.GetBucket(p.kb.BucketIndex(n.ID)).FindNode(n.ID); !known {
+			fresh = append(fresh, n)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+	p.spawn("accept", func() {
+		for _, n := range fresh {
+			if p.cfg.ContactAcceptance == AcceptVerified {
+				if !p.ping(n) {
+					continue
+				}
+				n.LastSeen = time.Now()
+			}
+			p.kb.InsertNode(n) // bucket 已满时可能 ping 最久未联系的节点
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package dht
+
+import (
+	"net"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 路由表中的节点换了地址，并且已经通过验证
+type AddressChanged struct {
+	ID   [kbucket.IdSize]byte
+	Old  *net.UDPAddr
+	New  *net.UDPAddr
+	Time time.Time
+}
+
+// 订阅节点地址变化，缓冲区满时丢弃事件
+func (p *Peer) SubscribeAddressChanges(buffer int) <-chan AddressChanged {
+	ch := make(chan AddressChanged, buffer)
+	p.addrSubs = append(p.addrSubs, ch)
+	return ch
+}
+
+func (p *Peer) emitAddressChanged(ev AddressChanged) {
+	for _, ch := range p.addrSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.Zone == b.Zone && a.IP.Equal(b.IP)
+}
+
+// 把通信过的远端节点加入路由表。已知节点从新地址发来消息时不直接覆盖，
+// 而是在后台验证后再更新
+func (t *UDPTransport) learn(id [kbucket.IdSize]byte, addr *net.UDPAddr) {
+	if id == t.p.node.ID {
+		return
+	}
+	if old, ok := t.p.kb.GetBucket(t.p.kb.BucketIndex(id)).FindNode(id); ok {
+		if oldAddr, isAddr := old.Data.(*net.UDPAddr); isAddr {
+			if !sameAddr(oldAddr, addr) {
+				if t.startVerify(id) {
+					go t.verifyAddress(id, oldAddr, addr)
+				}
+				return
+			}
+			addr = oldAddr // 沿用已有的地址，路由表比较时不需要格式化
+		}
+	}
+	t.p.kb.InsertNode(kbucket.Node{ID: id, Data: addr, LastSeen: time.Now()})
+}
+
+// 同一节点同时只进行一次验证，返回是否需要开始验证
+func (t *UDPTransport) startVerify(id [kbucket.IdSize]byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.verifying[id] {
+		return false
+	}
+	if t.verifying == nil {
+		t.verifying = make(map[[kbucket.IdSize]byte]bool)
+	}
+	t.verifying[id] = true
+	return true
+}
+
+// 用挑战 ping 确认地址变化：新地址以同一 ID 响应、并且旧地址已经无法联系时
+// 才更新路由表，防止其他节点冒用已知 ID 劫持联系记录
+func (t *UDPTransport) verifyAddress(id [kbucket.IdSize]byte, old, addr *net.UDPAddr) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.verifying, id)
+		t.mu.Unlock()
+	}()
+	if got, err := t.Ping(addr); err != nil || got != id {
+		return
+	}
+	if got, err := t.Ping(old); err == nil && got == id { // 旧地址仍然有效，保留原记录
+		return
+	}
+	now := time.Now()
+	t.p.kb.GetBucket(t.p.kb.BucketIndex(id)).UpdateNode(kbucket.Node{ID: id, Data: addr})
+	t.p.kb.MarkSeen(id, now)
+	t.p.emitAddressChanged(AddressChanged{ID: id, Old: old, New: addr, Time: now})
+}
@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+type agingBucketJSON struct {
+	Bucket     int     `json:"bucket"`
+	Contacts   int     `json:"contacts"`
+	Unverified int     `json:"unverified"`
+	MeanAgeSec float64 `json:"mean_age_sec"`
+	MaxAgeSec  float64 `json:"max_age_sec"`
+}
+
+type agingSampleJSON struct {
+	Time    time.Time         `json:"time"`
+	Buckets []agingBucketJSON `json:"buckets"`
+}
+
+type evictionJSON struct {
+	Time   time.Time `json:"time"`
+	Bucket int       `json:"bucket"`
+	ID     string    `json:"id"`
+	Reason string    `json:"reason"`
+}
+
+// 管理接口：以 JSON 返回路由表老化的时间序列与淘汰事件，供仪表盘展示
+// 各区域联系人的新旧程度。采样由 KBucket().RecordAging() 周期性产生
+func (p *Peer) AgingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp struct {
+			Samples   []agingSampleJSON `json:"samples"`
+			Evictions []evictionJSON    `json:"evictions"`
+		}
+		for _, s := range p.kb.AgingHistory() {
+			sample := agingSampleJSON{Time: s.Time}
+			for _, b := range s.Buckets {
+				sample.Buckets = append(sample.Buckets, agingBucketJSON{
+					Bucket:     b.Bucket,
+					Contacts:   b.Contacts,
+					Unverified: b.Unverified,
+					MeanAgeSec: b.MeanAge.Seconds(),
+					MaxAgeSec:  b.MaxAge.Seconds(),
+				})
+			}
+			resp.Samples = append(resp.Samples, sample)
+		}
+		for _, e := range p.kb.Evictions() {
+			resp.Evictions = append(resp.Evictions, evictionJSON{
+				Time:   e.Time,
+				Bucket: e.Bucket,
+				ID:     hex.EncodeToString(e.ID[:]),
+				Reason: e.Reason.String(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+type regionEventJSON struct {
+	Time     time.Time `json:"time"`
+	Bucket   int       `json:"bucket"`
+	Kind     string    `json:"kind"`
+	Contacts int       `json:"contacts"`
+	Count    int       `json:"count,omitempty"`
+}
+
+// 管理接口：以 JSON 返回路由表各区域最近的分裂、批量淘汰、刷新与修复事件，从旧到新。
+// ?key=（十六进制）只返回该 key 所在 bucket 的事件，用于对照某个 key 的查找失败；
+// ?bucket= 只返回指定 bucket 的事件
+func (p *Peer) RegionHistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []kbucket.RegionEvent
+		q := r.URL.Query()
+		switch {
+		case q.Has("key"):
+			raw, err := hex.DecodeString(q.Get("key"))
+			if err != nil || len(raw) != kbucket.IdSize {
+				http.Error(w, "key must be a hex-encoded ID", http.StatusBadRequest)
+				return
+			}
+			events = p.kb.RegionHistory(p.kb.BucketIndex(MustKey(raw)))
+		case q.Has("bucket"):
+			pos, err := strconv.Atoi(q.Get("bucket"))
+			if err != nil || pos < 0 || pos >= kbucket.IdSize*8 {
+				http.Error(w, "bad bucket index", http.StatusBadRequest)
+				return
+			}
+			events = p.kb.RegionHistory(pos)
+		default:
+			events = p.kb.RegionHistories()
+		}
+		resp := struct {
+			Home   int               `json:"home"`
+			Events []regionEventJSON `json:"events"`
+		}{Home: p.kb.HomeBucket(), Events: []regionEventJSON{}}
+		for _, e := range events {
+			resp.Events = append(resp.Events, regionEventJSON{
+				Time:     e.Time,
+				Bucket:   e.Bucket,
+				Kind:     e.Kind.String(),
+				Contacts: e.Contacts,
+				Count:    e.Count,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// 管理接口：GET 以 JSON 返回当前的封禁；POST ?target= 封禁，DELETE ?target= 解除封禁，
+// target 为节点 ID（十六进制）、CIDR 或 IP 地址，见 ParseBan。修改后返回新的封禁列表
+func (p *Peer) BanHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			b, err := ParseBan(r.URL.Query().Get("target"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if r.Method == http.MethodPost {
+				err = p.Ban(b)
+			} else {
+				err = p.Unban(b)
+			}
+			if err != nil { // 封禁已经生效，只是没能保存
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := struct {
+			Bans []string `json:"bans"`
+		}{Bans: []string{}}
+		for _, b := range p.Bans() {
+			resp.Bans = append(resp.Bans, b.String())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+type invalidPacketJSON struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	Reason string    `json:"reason"`
+	Size   int       `json:"size"`
+	Head   string    `json:"head"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// 管理接口：以 JSON 返回端口收到的无效数据包的计数与最近的采样，用于排查与其他实现的互通问题。
+// 没有启动 UDPTransport 时计数为空
+func (p *Peer) InvalidPacketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var s InvalidPacketSummary
+		if p.transport != nil {
+			s = p.transport.InvalidPackets()
+		}
+		resp := struct {
+			Total      uint64              `json:"total"`
+			ByReason   map[string]uint64   `json:"by_reason"`
+			Suppressed uint64              `json:"suppressed"`
+			Recent     []invalidPacketJSON `json:"recent"`
+		}{Total: s.Total, ByReason: s.ByReason, Suppressed: s.Suppressed, Recent: []invalidPacketJSON{}}
+		if resp.ByReason == nil {
+			resp.ByReason = map[string]uint64{}
+		}
+		for _, pkt := range s.Recent {
+			resp.Recent = append(resp.Recent, invalidPacketJSON{
+				Time:   pkt.Time,
+				From:   pkt.From.String(),
+				Reason: pkt.Reason,
+				Size:   pkt.Size,
+				Head:   hex.EncodeToString(pkt.Head),
+				Error:  pkt.Err,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
@@ -0,0 +1,46 @@
+package dht
+
+import (
+	"math"
+	"sync"
+)
+
+// 查找 RPC 结果的平滑系数：每个结果占失败比例的 1/8
+const alphaSmoothing = 0.125
+
+// 按最近查找 RPC 的失败比例调整的并发度
+type adaptiveAlpha struct {
+	mu       sync.Mutex
+	failRate float64 // 指数加权的超时与连接失败比例
+}
+
+// 记录一次查找 RPC 的结果，只有超时与连接失败计为失败
+func (a *adaptiveAlpha) observe(failed bool) {
+	x := 0.0
+	if failed {
+		x = 1
+	}
+	a.mu.Lock()
+	a.failRate += alphaSmoothing * (x - a.failRate)
+	a.mu.Unlock()
+}
+
+func (a *adaptiveAlpha) rate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failRate
+}
+
+// 迭代查找当前每一轮并发查询的节点数。MaxAlpha 大于 Alpha 时，失败比例为 f 的网络中
+// 每轮发出约 Alpha/(1-f) 个查询（不超过 MaxAlpha），期望收到的响应数保持为 Alpha；
+// 响应恢复正常后逐渐回落到 Alpha
+func (p *Peer) LookupWidth() int {
+	if p.cfg.MaxAlpha <= p.cfg.Alpha {
+		return p.cfg.Alpha
+	}
+	ok := 1 - p.alpha.rate()
+	if ok*float64(p.cfg.MaxAlpha) <= float64(p.cfg.Alpha) {
+		return p.cfg.MaxAlpha
+	}
+	return int(math.Round(float64(p.cfg.Alpha) / ok))
+}
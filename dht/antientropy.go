@@ -0,0 +1,221 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	bloomBitsPerKey = 10 // 摘要中每个 key 占用的比特数
+	bloomHashes     = 4  // 摘要使用的哈希函数个数
+)
+
+// 存储 key 的紧凑摘要（Bloom 过滤器），用于邻居间比较各自持有的记录。
+// salt 不同时误判落在不同的 key 上，反熵每轮换一个 salt，本轮被误判的 key 之后仍能补齐
+type KeyDigest struct {
+	salt uint32
+	bits []uint64
+}
+
+// 容纳约 n 个 key 的空摘要，大小不超过一个 DIGEST_SYNC 请求能携带的上限
+func NewKeyDigest(n int, salt uint32) *KeyDigest {
+	words := min((n*bloomBitsPerKey+63)/64, maxDigestWords)
+	if words == 0 {
+		words = 1
+	}
+	return &KeyDigest{salt: salt, bits: make([]uint64, words)}
+}
+
+// key 本身就是哈希值，取其中的片段与 salt 混合后作为各个哈希函数的结果
+func (d *KeyDigest) positions(key [kbucket.IdSize]byte) [bloomHashes]uint32 {
+	var pos [bloomHashes]uint32
+	m := uint64(len(d.bits) * 64)
+	for i := 0; i < bloomHashes; i++ {
+		x := uint64(binary.BigEndian.Uint32(key[i*4:]))<<32 | uint64(d.salt)
+		pos[i] = uint32(mix64(x) % m)
+	}
+	return pos
+}
+
+// splitmix64 的终结步骤，对输入是一一映射
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func (d *KeyDigest) Add(key [kbucket.IdSize]byte) {
+	for _, x := range d.positions(key) {
+		d.bits[x/64] |= 1 << (x % 64)
+	}
+}
+
+// 可能存在误判（返回 true 但实际不存在），不会漏判
+func (d *KeyDigest) Has(key [kbucket.IdSize]byte) bool {
+	for _, x := range d.positions(key) {
+		if d.bits[x/64]&(1<<(x%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// salt(4) | 比特数组，每 64 位一组，大端
+func (d *KeyDigest) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 4+8*len(d.bits))
+	binary.BigEndian.PutUint32(b, d.salt)
+	for i, w := range d.bits {
+		binary.BigEndian.PutUint64(b[4+8*i:], w)
+	}
+	return b, nil
+}
+
+func (d *KeyDigest) UnmarshalBinary(b []byte) error {
+	if len(b) < 4+8 || (len(b)-4)%8 != 0 || (len(b)-4)/8 > maxDigestWords {
+		return ErrBadPacket
+	}
+	d.salt = binary.BigEndian.Uint32(b)
+	d.bits = make([]uint64, (len(b)-4)/8)
+	for i := range d.bits {
+		d.bits[i] = binary.BigEndian.Uint64(b[4+8*i:])
+	}
+	return nil
+}
+
+// 本地保存的、满足 in 的 key 的摘要
+func (p *Peer) keyDigest(salt uint32, in func([kbucket.IdSize]byte) bool) *KeyDigest {
+	var keys [][kbucket.IdSize]byte
+	for _, key := range p.store.keys() {
+		if in(key) {
+			keys = append(keys, key)
+		}
+	}
+	d := NewKeyDigest(len(keys), salt)
+	for _, key := range keys {
+		d.Add(key)
+	}
+	return d
+}
+
+// 与副本邻居进行一轮反熵同步，返回本轮修复的记录数量。
+// 调用方应周期性调用，以便比单纯依赖重新发布更快地补齐因节点流失而丢失的副本。
+// 进程内的邻居直接交换摘要并双向补齐；网络邻居通过 DIGEST_SYNC（记录较多时为 MERKLE_SYNC）
+// 比较本节点负责区域内的 key，只取回本地缺少的记录，对方缺少的记录由它自己的反熵拉取。
+// Messenger 两者都不支持的网络邻居不参与。RANGE_SYNC 只用于新加入的节点，见 SyncNeighbors。
+// RunJanitor 每个周期调用一次
+func (p *Peer) AntiEntropy() int {
+	return p.antiEntropy(context.Background())
+}
+
+func (p *Peer) antiEntropy(ctx context.Context) int {
+	neighbors := p.replicaNeighbors()
+	group := append([]*Peer{p}, neighbors...)
+	repaired := 0
+	for _, n := range neighbors {
+		repaired += p.syncWith(n, group)
+	}
+	return repaired + p.syncRemoteNeighbors(ctx)
+}
+
+// 与距离自身最近的 K 个联系人中的网络节点比较负责区域内的 key，返回新保存的记录数
+func (p *Peer) syncRemoteNeighbors(ctx context.Context) int {
+	if p.static {
+		return 0
+	}
+	r := p.ResponsibleRange()
+	repaired := 0
+	for _, c := range contactsOf(p.kb.FindClosestNodes(p.node.ID, p.cfg.K)) {
+		if c.Peer != nil {
+			continue
+		}
+		n, _ := p.syncRemote(ctx, c, r) // 失败的邻居等下一轮
+		repaired += n
+	}
+	return repaired
+}
+
+// 在 keyspace 中与自身最近的若干个邻居
+func (p *Peer) replicaNeighbors() []*Peer {
+	if p.static {
+		return p.staticClosest(p.node.ID, p.cfg.K)
+	}
+	var neighbors []*Peer
+	for _, node := range p.kb.AllNodes() {
+		peer, ok := node.Data.(*Peer)
+		if !ok {
+			continue
+		}
+		i := len(neighbors)
+		for i > 0 && p.closer(peer.node.ID, neighbors[i-1].node.ID, p.node.ID) {
+			i--
+		}
+		if i >= p.cfg.K {
+			continue
+		}
+		neighbors = append(neighbors, nil)
+		copy(neighbors[i+1:], neighbors[i:])
+		neighbors[i] = peer
+		if len(neighbors) > p.cfg.K {
+			neighbors = neighbors[:p.cfg.K]
+		}
+	}
+	return neighbors
+}
+
+// 交换摘要并互相补齐对方缺失、且双方都应当持有副本的记录。
+// 记录较多时使用 Merkle 树定位差异，否则使用 Bloom 摘要
+func (p *Peer) syncWith(n *Peer, group []*Peer) int {
+	var missingThere, missingHere [][kbucket.IdSize]byte
+	if p.store.len()+n.store.len() >= merkleMinKeys {
+		missingThere, missingHere, _ = merkleDiff(p.merkleRoot(), n.merkleRoot(), 0)
+	} else {
+		all := func([kbucket.IdSize]byte) bool { return true }
+		theirs := n.keyDigest(0, all)
+		ours := p.keyDigest(0, all)
+		for _, key := range p.store.keys() {
+			if !theirs.Has(key) {
+				missingThere = append(missingThere, key)
+			}
+		}
+		for _, key := range n.store.keys() {
+			if !ours.Has(key) {
+				missingHere = append(missingHere, key)
+			}
+		}
+	}
+	repaired := 0
+	for _, key := range missingThere {
+		if value, ok := p.store.get(key); ok && isReplica(n, key, group) && n.repairValue(key, value, p.store.repairOrigin(key, p.node.ID)) {
+			repaired++
+		}
+	}
+	for _, key := range missingHere {
+		if value, ok := n.store.get(key); ok && isReplica(p, key, group) && p.repairValue(key, value, n.store.repairOrigin(key, n.node.ID)) {
+			repaired++
+		}
+	}
+	return repaired
+}
+
+// 保存反熵补齐的记录：与收到的 STORE 一样经过校验、存储容量与预算检查，并通知关注者
+func (p *Peer) repairValue(key [kbucket.IdSize]byte, value []byte, origin Provenance) bool {
+	if p.validate(key, value) != nil {
+		return false
+	}
+	return p.saveValue(key, value, origin, ValueRepaired)
+}
+
+// 在 group 中 peer 是否属于距离 key 最近的 K 个节点之一
+func isReplica(peer *Peer, key [kbucket.IdSize]byte, group []*Peer) bool {
+	closer := 0
+	for _, m := range group {
+		if m != peer && peer.closer(m.node.ID, peer.node.ID, key) {
+			closer++
+		}
+	}
+	return closer < peer.cfg.K
+}
@@ -0,0 +1,229 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 把所有请求转给进程内的 to，但联系人只带网络地址，相当于经过网络传输。记录各类请求的次数
+type rangeMessenger struct {
+	from, to *Peer
+	calls    map[string]int
+}
+
+func (m rangeMessenger) count(op string) {
+	if m.calls != nil {
+		m.calls[op]++
+	}
+}
+
+func (m rangeMessenger) Ping(ctx context.Context, c Contact) ([kbucket.IdSize]byte, error) {
+	return m.to.node.ID, nil
+}
+
+func (m rangeMessenger) FindNode(ctx context.Context, c Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	return nil, nil
+}
+
+func (m rangeMessenger) FindValue(ctx context.Context, c Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	m.count(OpFindValue)
+	value, _ := m.to.store.get(key)
+	return value, nil, nil
+}
+
+func (m rangeMessenger) Store(ctx context.Context, c Contact, key [kbucket.IdSize]byte, value []byte) error {
+	return nil
+}
+
+func (m rangeMessenger) RangeSync(ctx context.Context, c Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	m.count(OpRangeSync)
+	page, code, wait := m.to.serveRangeSync(m.from.node.ID, r, from, limit)
+	if code != CodeOK {
+		return RangePage{}, &RPCError{Code: code, RetryAfter: wait}
+	}
+	return page, nil
+}
+
+func (m rangeMessenger) DigestSync(ctx context.Context, c Contact, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	m.count(OpDigestSync)
+	keys, more, code, wait := m.to.serveDigestSync(m.from.node.ID, r, digest, limit)
+	if code != CodeOK {
+		return nil, false, &RPCError{Code: code, RetryAfter: wait}
+	}
+	return keys, more, nil
+}
+
+func (m rangeMessenger) MerkleSync(ctx context.Context, c Contact, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	m.count(OpMerkleSync)
+	return m.to.serveMerkleSync(r, prefix, depth), nil
+}
+
+// 只能通过网络联系到的邻居也参与反熵：缺少的记录经 DIGEST_SYNC 找出后逐个取回
+func TestAntiEntropyNetworkNeighbor(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	q := NewPeer(KeyFromString("antientropy-remote"))
+	p.SetMessenger(rangeMessenger{from: p, to: q})
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+
+	value := []byte("antientropy-value")
+	key := KeyFromBytes(value)
+	q.store.put(key, value, Provenance{})
+	if n := p.AntiEntropy(); n != 1 {
+		t.Fatalf("AntiEntropy repaired %d records, want 1", n)
+	}
+	if got, ok := p.store.get(key); !ok || string(got) != string(value) {
+		t.Fatalf("record after AntiEntropy = %q, %v", got, ok)
+	}
+	if n := p.AntiEntropy(); n != 0 {
+		t.Fatalf("second AntiEntropy repaired %d records, want 0", n)
+	}
+}
+
+// 反熵只取回本地缺少的记录，已有的记录不经过网络传输，也不使用 RANGE_SYNC
+func TestAntiEntropyFetchesOnlyMissing(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RangeSyncRate = -1
+	p, _ := NewPeerWithConfig(KeyFromString("antientropy-self"), cfg)
+	q, _ := NewPeerWithConfig(KeyFromString("antientropy-remote"), cfg)
+	m := rangeMessenger{from: p, to: q, calls: make(map[string]int)}
+	p.SetMessenger(m)
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+
+	const shared, missing = 200, 5
+	for i := 0; i < shared+missing; i++ {
+		value := []byte(fmt.Sprintf("antientropy-%d", i))
+		key := KeyFromBytes(value)
+		q.store.put(key, value, Provenance{})
+		if i < shared {
+			p.store.put(key, value, Provenance{})
+		}
+	}
+	if n := p.AntiEntropy(); n != missing {
+		t.Fatalf("AntiEntropy repaired %d records, want %d", n, missing)
+	}
+	if m.calls[OpFindValue] != missing || m.calls[OpRangeSync] != 0 || m.calls[OpDigestSync] != 1 {
+		t.Fatalf("AntiEntropy sent %v, want 1 DIGEST_SYNC and %d FIND_VALUE", m.calls, missing)
+	}
+}
+
+// 对方缺少的 key 超过一次能返回的数量时换一个 salt 继续比较
+func TestDigestSyncMore(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	for i := 0; i < 10; i++ {
+		value := []byte(fmt.Sprintf("digest-more-%d", i))
+		p.store.put(KeyFromBytes(value), value, Provenance{})
+	}
+	all := ResponsibilityRange{}
+	keys, more := p.digestMissing(all, NewKeyDigest(0, 1), 4)
+	if len(keys) != 4 || !more {
+		t.Fatalf("digestMissing returned %d keys, more = %v, want 4 and more", len(keys), more)
+	}
+	for i := 1; i < len(keys); i++ {
+		if bytes.Compare(keys[i-1][:], keys[i][:]) >= 0 {
+			t.Fatalf("keys not sorted: %x", keys)
+		}
+	}
+	d := p.keyDigest(7, all.Contains)
+	if keys, more := p.digestMissing(all, d, 4); len(keys) != 0 || more {
+		t.Fatalf("digestMissing with a full digest returned %d keys, more = %v", len(keys), more)
+	}
+	raw, _ := d.MarshalBinary()
+	var decoded KeyDigest
+	if err := decoded.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := p.digestMissing(all, &decoded, 4); len(keys) != 0 {
+		t.Fatalf("decoded digest lost %d keys", len(keys))
+	}
+}
+
+// 经过 UDP 的反熵：DIGEST_SYNC 与 MERKLE_SYNC 的编码在两端一致
+func TestAntiEntropyUDP(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	q := NewPeer(KeyFromString("antientropy-remote"))
+	tp, err := ListenUDP(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	tq, err := ListenUDP(q, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tq.Close()
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: tq.Addr()})
+
+	for i := 0; i < 3; i++ {
+		value := []byte(fmt.Sprintf("antientropy-udp-%d", i))
+		key := KeyFromBytes(value)
+		q.store.put(key, value, Provenance{})
+		if i > 0 {
+			p.store.put(key, value, Provenance{})
+		}
+	}
+	if n := p.AntiEntropy(); n != 1 {
+		t.Fatalf("AntiEntropy repaired %d records, want 1", n)
+	}
+	if p.store.len() != 3 {
+		t.Fatalf("%d records after AntiEntropy, want 3", p.store.len())
+	}
+	s, err := tp.MerkleSync(tq.Addr(), ResponsibilityRange{}, [kbucket.IdSize]byte{}, 0)
+	if err != nil || !s.Leaf || s.Hash != p.merkleRoot().hash {
+		t.Fatalf("MerkleSync = %+v, %v, want the root shared by both peers", s, err)
+	}
+}
+
+// Messenger 不支持 DIGEST_SYNC 时跳过网络邻居
+func TestAntiEntropySkipsUnsupportedMessenger(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	m := &slowMessenger{started: make(map[[kbucket.IdSize]byte]time.Time)}
+	p.SetMessenger(m)
+	p.kb.InsertNode(kbucket.Node{ID: KeyFromString("antientropy-remote"), Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+	if n := p.AntiEntropy(); n != 0 {
+		t.Fatalf("AntiEntropy repaired %d records, want 0", n)
+	}
+}
+
+// RunJanitor 周期性地做反熵同步；补齐的记录经过校验并通知关注者
+func TestJanitorRunsAntiEntropy(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	q := NewPeer(KeyFromString("antientropy-neighbor"))
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+	q.kb.InsertNode(kbucket.Node{ID: p.node.ID, Data: p})
+
+	value := []byte("antientropy-janitor")
+	key := KeyFromBytes(value)
+	q.store.put(key, value, Provenance{})
+	bogus := KeyFromString("antientropy-bogus") // key 与值不符，校验不通过
+	q.store.put(bogus, []byte("not the preimage"), Provenance{})
+	updates, err := p.WatchKey(key, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.RunJanitor(ctx, time.Millisecond)
+		close(done)
+	}()
+	select {
+	case u := <-updates:
+		if string(u.Value) != string(value) {
+			t.Fatalf("watcher saw %q, want %q", u.Value, value)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not repair the missing record")
+	}
+	cancel()
+	<-done
+	if p.store.has(bogus) {
+		t.Fatal("anti-entropy copied a record that fails validation")
+	}
+}
@@ -0,0 +1,224 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const bansVersion = 1
+
+var bansMagic = [4]byte{'K', 'B', 'B', 'N'}
+
+// 一条封禁：Network 不为 nil 时封禁该网段内的所有地址，否则封禁节点 ID。
+// 被封禁的节点发来的请求被拒绝，不会加入路由表，本节点也不再联系它
+type Ban struct {
+	ID      [kbucket.IdSize]byte
+	Network *net.IPNet
+}
+
+// 解析十六进制的节点 ID、CIDR 或单个 IP 地址
+func ParseBan(s string) (Ban, error) {
+	s = strings.TrimSpace(s)
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return Ban{Network: n}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return Ban{Network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != kbucket.IdSize {
+		return Ban{}, fmt.Errorf("dht: invalid ban %q: want a node ID, CIDR or IP address", s)
+	}
+	return Ban{ID: MustKey(raw)}, nil
+}
+
+func (b Ban) String() string {
+	if b.Network != nil {
+		return b.Network.String()
+	}
+	return hex.EncodeToString(b.ID[:])
+}
+
+type banList struct {
+	mu   sync.RWMutex
+	ids  map[[kbucket.IdSize]byte]bool
+	nets map[string]*net.IPNet // 以 CIDR 字符串为键
+	path string                // 每次修改后保存的文件，空表示不保存
+
+	saveMu sync.Mutex // 使并发的保存按顺序写出
+}
+
+func (l *banList) blocks(id [kbucket.IdSize]byte, ip net.IP) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.ids[id] {
+		return true
+	}
+	if ip != nil {
+		for _, n := range l.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 调用方需持有 l.mu 的写锁，返回封禁列表是否改变
+func (l *banList) addLocked(b Ban) bool {
+	if b.Network != nil {
+		key := b.Network.String()
+		if l.nets[key] != nil {
+			return false
+		}
+		if l.nets == nil {
+			l.nets = make(map[string]*net.IPNet)
+		}
+		l.nets[key] = b.Network
+		return true
+	}
+	if l.ids[b.ID] {
+		return false
+	}
+	if l.ids == nil {
+		l.ids = make(map[[kbucket.IdSize]byte]bool)
+	}
+	l.ids[b.ID] = true
+	return true
+}
+
+func (l *banList) list() []Ban {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	bans := make([]Ban, 0, len(l.ids)+len(l.nets))
+	for id := range l.ids {
+		bans = append(bans, Ban{ID: id})
+	}
+	for _, n := range l.nets {
+		bans = append(bans, Ban{Network: n})
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].String() < bans[j].String() })
+	return bans
+}
+
+// 每行一条封禁，格式与 Ban.String 相同
+func (l *banList) save() error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+	l.mu.RLock()
+	path := l.path
+	l.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	var payload bytes.Buffer
+	for _, b := range l.list() {
+		payload.WriteString(b.String())
+		payload.WriteByte('\n')
+	}
+	var buf bytes.Buffer
+	if err := writeVersioned(&buf, bansMagic, ArtifactBans, payload.Bytes()); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+// 封禁节点 ID 或网段，立即把匹配的节点移出路由表。设置了封禁文件时同时保存
+func (p *Peer) Ban(b Ban) error {
+	p.bans.mu.Lock()
+	changed := p.bans.addLocked(b)
+	p.bans.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	p.kb.Refilter()
+	return p.bans.save()
+}
+
+// 解除封禁，封禁不存在时不做任何事
+func (p *Peer) Unban(b Ban) error {
+	p.bans.mu.Lock()
+	changed := false
+	if b.Network != nil {
+		key := b.Network.String()
+		changed = p.bans.nets[key] != nil
+		delete(p.bans.nets, key)
+	} else {
+		changed = p.bans.ids[b.ID]
+		delete(p.bans.ids, b.ID)
+	}
+	p.bans.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return p.bans.save()
+}
+
+// 当前的封禁，按字符串形式排序
+func (p *Peer) Bans() []Ban {
+	return p.bans.list()
+}
+
+// 使用 path 持久化封禁：读取其中已有的封禁（文件不存在时从空列表开始），
+// 之后每次 Ban 或 Unban 都原子地重写该文件
+func (p *Peer) SetBanFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var loaded []Ban
+	if err == nil {
+		payload, err := readVersioned(data, bansMagic, ArtifactBans)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(payload), "\n") {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			b, err := ParseBan(line)
+			if err != nil {
+				return err
+			}
+			loaded = append(loaded, b)
+		}
+	}
+	p.bans.mu.Lock()
+	for _, b := range loaded {
+		p.bans.addLocked(b)
+	}
+	p.bans.path = path
+	p.bans.mu.Unlock()
+	p.kb.Refilter()
+	return nil
+}
+
+// 路由表的准入检查
+func (p *Peer) admits(n kbucket.Node) bool {
+	if other, ok := n.Data.(*Peer); ok && (other.cfg.ClientOnly || other.servingPaused()) {
+		return false
+	}
+	addr, _ := n.Data.(*net.UDPAddr)
+	return !p.banned(n.ID, addr)
+}
+
+// 是否拒绝来自 id、addr 的请求，addr 为 nil 时只检查 ID
+func (p *Peer) banned(id [kbucket.IdSize]byte, addr *net.UDPAddr) bool {
+	if addr == nil {
+		return p.bans.blocks(id, nil)
+	}
+	return p.bans.blocks(id, addr.IP)
+}
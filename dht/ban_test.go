@@ -0,0 +1,147 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func (p *Peer) knows(id [kbucket.IdSize]byte) bool {
+	_, ok := p.kb.GetBucket(p.kb.BucketIndex(id)).FindNode(id)
+	return ok
+}
+
+// 单个 IPv4 地址保存为 /32，IPv6 地址为 /128，节点 ID 为十六进制
+func TestParseBan(t *testing.T) {
+	id := KeyFromString("ban-id")
+	for _, c := range []struct{ in, want string }{
+		{"10.1.2.3", "10.1.2.3/32"},
+		{" 10.1.2.3\n", "10.1.2.3/32"},
+		{"10.1.0.0/16", "10.1.0.0/16"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{fmt.Sprintf("%x", id), fmt.Sprintf("%x", id)},
+	} {
+		b, err := ParseBan(c.in)
+		if err != nil || b.String() != c.want {
+			t.Fatalf("ParseBan(%q) = %v, %v, want %s", c.in, b, err, c.want)
+		}
+	}
+	if b, _ := ParseBan("10.1.2.3"); b.Network.Contains(net.IPv4(10, 1, 2, 4)) || !b.Network.Contains(net.IPv4(10, 1, 2, 3)) {
+		t.Fatal("a bare IPv4 ban covers more than its address")
+	}
+	for _, bad := range []string{"", "10.1.2", "abcd", "10.0.0.0/33"} {
+		if _, err := ParseBan(bad); err == nil {
+			t.Fatalf("ParseBan(%q) accepted", bad)
+		}
+	}
+}
+
+// 封禁网段或 ID 立即把匹配的节点移出路由表，之后也不再接受它们
+func TestBanEvictsAndRejectsContacts(t *testing.T) {
+	p := NewPeer(KeyFromString("ban-self"))
+	inside, outside, byID := KeyFromString("ban-inside"), KeyFromString("ban-outside"), KeyFromString("ban-by-id")
+	p.kb.InsertNode(kbucket.Node{ID: inside, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 4000}})
+	p.kb.InsertNode(kbucket.Node{ID: outside, Data: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 1), Port: 4000}})
+	p.kb.InsertNode(kbucket.Node{ID: byID, Data: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 4000}})
+
+	network, _ := ParseBan("10.0.0.0/8")
+	if err := p.Ban(network); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Ban(Ban{ID: byID}); err != nil {
+		t.Fatal(err)
+	}
+	if p.knows(inside) || p.knows(byID) || !p.knows(outside) {
+		t.Fatalf("after Ban: inside %v, by ID %v, outside %v, want only the unbanned contact", p.knows(inside), p.knows(byID), p.knows(outside))
+	}
+	other := KeyFromString("ban-other-inside")
+	if p.kb.InsertNode(kbucket.Node{ID: other, Data: &net.UDPAddr{IP: net.IPv4(10, 9, 9, 9), Port: 4000}}) || p.knows(other) {
+		t.Fatal("learned a contact in a banned network")
+	}
+	if p.kb.InsertNode(kbucket.Node{ID: byID, Data: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 3), Port: 4000}}) || p.knows(byID) {
+		t.Fatal("learned a banned ID at a new address")
+	}
+	if m := p.messengerFor(Contact{ID: other, Addr: &net.UDPAddr{IP: net.IPv4(10, 9, 9, 9), Port: 4000}}); m != nil {
+		t.Fatal("a messenger was chosen for a banned contact")
+	}
+}
+
+// 被封禁的 ID 或 IP 发来的请求不被处理，发出方也不会被加入路由表
+func TestBanRejectsRPCs(t *testing.T) {
+	for _, ban := range []string{"id", "127.0.0.1"} {
+		t.Run(ban, func(t *testing.T) {
+			p, q := NewPeer(KeyFromString("ban-rpc-client")), NewPeer(KeyFromString("ban-rpc-server"))
+			tp, err := ListenUDP(p, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tp.Close()
+			tq, err := ListenUDP(q, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tq.Close()
+			tp.Timeout, tp.Retries = 50*time.Millisecond, 0
+
+			b := Ban{ID: p.ID()}
+			if ban != "id" {
+				b, _ = ParseBan(ban)
+			}
+			if err := q.Ban(b); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tp.Ping(tq.Addr()); !errors.Is(err, ErrTimeout) {
+				t.Fatalf("Ping to a peer that banned us = %v, want ErrTimeout", err)
+			}
+			if q.knows(p.ID()) {
+				t.Fatal("banned peer was learned from its request")
+			}
+			if err := q.Unban(b); err != nil {
+				t.Fatal(err)
+			}
+			if id, err := tp.Ping(tq.Addr()); err != nil || id != q.ID() {
+				t.Fatalf("Ping after Unban = %x, %v", id[:4], err)
+			}
+		})
+	}
+}
+
+// 封禁文件在重启后恢复全部封禁，Unban 也写回文件
+func TestBanFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans")
+	p := NewPeer(KeyFromString("ban-file"))
+	if err := p.SetBanFile(path); err != nil {
+		t.Fatal(err)
+	}
+	var bans []Ban
+	for _, s := range []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32", fmt.Sprintf("%x", KeyFromString("ban-file-id"))} {
+		b, err := ParseBan(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Ban(b); err != nil {
+			t.Fatal(err)
+		}
+		bans = append(bans, b)
+	}
+	if err := p.Unban(bans[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewPeer(KeyFromString("ban-file"))
+	restarted.kb.InsertNode(kbucket.Node{ID: KeyFromString("ban-file-peer"), Data: &net.UDPAddr{IP: net.IPv4(192, 168, 1, 7), Port: 4000}})
+	if err := restarted.SetBanFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(restarted.Bans()), fmt.Sprint(p.Bans()); got != want || len(restarted.Bans()) != len(bans)-1 {
+		t.Fatalf("bans after restart = %s, want %s", got, want)
+	}
+	if restarted.knows(KeyFromString("ban-file-peer")) {
+		t.Fatal("contact covered by a loaded ban stayed in the routing table")
+	}
+}
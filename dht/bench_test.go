@@ -0,0 +1,112 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 10000 个进程内节点组成的网络上的迭代查找
+func BenchmarkLookup(b *testing.B) {
+	peers := newTestNetwork(10000, 1)
+	ctx := context.Background()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p := peers[i%len(peers)]
+		if _, err := p.Lookup(ctx, KeyFromString(fmt.Sprintf("bench-%d", i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetValue(b *testing.B) {
+	peers := newTestNetwork(10000, 2)
+	ctx := context.Background()
+	value := []byte("bench-value")
+	key := KeyFromBytes(value)
+	if _, err := peers[0].SetValue(ctx, key[:], value); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		peers[i%len(peers)].GetValue(ctx, key)
+	}
+}
+
+// 回环地址上两个节点之间的 RPC，并发发出请求以达到每秒上万次的速率。
+// 除了每次 RPC 的分配，还报告每千次 RPC 触发的 GC 次数与达到的速率
+func benchmarkUDPRPC(b *testing.B, rpc func(t *UDPTransport, addr *net.UDPAddr) error) {
+	server, client := NewPeer(KeyFromString("bench-server")), NewPeer(KeyFromString("bench-client"))
+	for i := 0; i < kbucket.BucketSize; i++ { // FIND_NODE 的响应带满 K 个联系人
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 4000}
+		server.kb.InsertNode(kbucket.Node{ID: KeyFromString(fmt.Sprintf("bench-contact-%d", i)), Data: addr})
+	}
+	st, err := ListenUDP(server, "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer st.Close()
+	ct, err := ListenUDP(client, "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ct.Close()
+	addr := st.Addr()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.SetParallelism(4)
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := rpc(ct, addr); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	elapsed := time.Since(start)
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	b.ReportMetric(float64(after.NumGC-before.NumGC)*1000/float64(b.N), "gc/1k-rpc")
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "rpc/s")
+}
+
+func BenchmarkUDPPing(b *testing.B) {
+	benchmarkUDPRPC(b, func(t *UDPTransport, addr *net.UDPAddr) error {
+		_, err := t.Ping(addr)
+		return err
+	})
+}
+
+func BenchmarkUDPFindNode(b *testing.B) {
+	target := KeyFromString("bench-target")
+	benchmarkUDPRPC(b, func(t *UDPTransport, addr *net.UDPAddr) error {
+		_, err := t.FindNode(addr, target)
+		return err
+	})
+}
+
+func BenchmarkUDPFindValue(b *testing.B) {
+	value := bytes.Repeat([]byte("v"), 4096)
+	key := KeyFromBytes(value)
+	benchmarkUDPRPC(b, func(t *UDPTransport, addr *net.UDPAddr) error {
+		if err := t.Store(addr, key, value); err != nil {
+			return err
+		}
+		got, _, err := t.FindValue(addr, key)
+		if err == nil && got == nil {
+			err = ErrNotFound
+		}
+		return err
+	})
+}
@@ -0,0 +1,71 @@
+package dht
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 限制节点加入网络的速率：平均每秒 rate 次 Bootstrap，最多 burst 次同时开始。
+// 可以由多个节点共用，零值不限制
+type BootstrapLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	tat      time.Time // 理论上下一个名额的到达时间
+}
+
+func NewBootstrapLimiter(rate float64, burst int) *BootstrapLimiter {
+	l := &BootstrapLimiter{burst: max(burst, 1)}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// 在 now 预约一个名额，返回可以开始加入的时间，不早于 now。仿真按虚拟时间调用
+func (l *BootstrapLimiter) Reserve(now time.Time) time.Time {
+	if l == nil || l.interval <= 0 {
+		return now
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	start := tat.Add(-time.Duration(l.burst-1) * l.interval)
+	if start.Before(now) {
+		start = now
+	}
+	l.tat = tat.Add(l.interval)
+	return start
+}
+
+// 等待一个名额，ctx 结束时返回它的错误；已经预约的名额不退还
+func (l *BootstrapLimiter) Wait(ctx context.Context) error {
+	return sleepContext(ctx, time.Until(l.Reserve(time.Now())))
+}
+
+// Bootstrap 之前按 BootstrapJitter 与 BootstrapLimiter 等待
+func (p *Peer) bootstrapDelay() {
+	if d := p.cfg.BootstrapJitter; d > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(d))))
+	}
+	p.cfg.BootstrapLimiter.Wait(context.Background())
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+var ErrNoSeeds = errors.New("dht: no seed responded")
+
+// 一个已知的联系方式。进程内的节点设置 Peer，网络中的节点设置 Addr；
+// ID 未知时可以留空，由 ping 的响应补齐
+type Contact struct {
+	ID       [kbucket.IdSize]byte
+	Addr     *net.UDPAddr
+	Peer     *Peer
+	LastSeen time.Time   // 最近一次确认存活的时间，零值表示尚未验证
+	Hint     QualityHint // FIND_NODE 响应方给出的质量提示，见 Config.RTTHints
+
+	PublicKey ed25519.PublicKey // FIND_NODE 响应方给出的公钥，nil 表示不知道，见 Config.ContactValidation
+}
+
+// 加入已有的网络：ping 种子节点并把响应的节点加入路由表，然后查找自身 ID 以认识
+// 附近的节点，最后刷新比最近邻居更远的每个 bucket；扁平路由表改为认识网络中的全部节点，
+// 见 Config.FlatTable。没有种子响应时返回 ErrNoSeeds。
+// 种子节点被记住，与网络断开后用于重新加入（见 Rejoin）。
+// 联系种子之前按 Config.BootstrapJitter 与 BootstrapLimiter 等待
+func (p *Peer) Bootstrap(seeds []Contact) error {
+	p.rememberSeeds(seeds)
+	p.bootstrapDelay()
+	joined := 0
+	for _, seed := range seeds {
+		if p.pingSeed(seed) {
+			joined++
+		}
+	}
+	if joined == 0 {
+		return ErrNoSeeds
+	}
+	closest := p.lookup(p.node.ID, p.newLookupBudget(context.Background()))
+	if len(closest) == 0 {
+		return nil
+	}
+	if p.kb.Flat() {
+		p.learnAll(context.Background())
+	}
+	for pos := p.kb.BucketIndex(closest[0].ID) + 1; pos < kbucket.IdSize*8; pos++ {
+		p.lookup(p.kb.RefreshTarget(pos), p.newLookupBudget(context.Background()))
+		p.kb.RecordRefresh(pos)
+	}
+	if p.cfg.SyncOnJoin {
+		p.SyncNeighbors(context.Background()) // 拉取失败时仍然可以等待重新发布
+	}
+	return nil
+}
+
+func (p *Peer) pingSeed(seed Contact) bool {
+	now := time.Now()
+	switch {
+	case seed.Peer != nil:
+		if seed.Peer == p || (seed.ID != [kbucket.IdSize]byte{} && seed.ID != seed.Peer.node.ID) || !p.accepts(seed.Peer) || !seed.Peer.accepts(p) {
+			return false
+		}
+		seed.Peer.kb.InsertNode(kbucket.Node{ID: p.node.ID, Data: p, LastSeen: now}) // 种子也认识了新节点
+		p.observe(seed.Peer.node.ID, true, 0)
+		p.helloPeer(seed.Peer)
+		return p.kb.InsertNode(kbucket.Node{ID: seed.Peer.node.ID, Data: seed.Peer, LastSeen: now})
+	case seed.Addr != nil:
+		m := p.messengerFor(seed)
+		if m == nil {
+			return false
+		}
+		id, err := m.Ping(context.Background(), seed) // 响应方由 Messenger 加入路由表
+		return err == nil && id != p.node.ID && (seed.ID == [kbucket.IdSize]byte{} || id == seed.ID)
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// 只认识一个种子的新节点加入后认识了附近的节点，写入的值可以被网络中的其他节点读到
+func TestBootstrapJoinsNetwork(t *testing.T) {
+	peers := newTestNetwork(32, 10)
+	p := NewPeer(KeyFromString("bootstrap-new"))
+	if err := p.Bootstrap([]Contact{{Peer: peers[0]}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.kb.Size(); n <= 1 {
+		t.Fatalf("routing table has %d nodes after Bootstrap", n)
+	}
+	nearest := peers[0]
+	for _, q := range peers[1:] {
+		if p.closer(q.node.ID, nearest.node.ID, p.node.ID) {
+			nearest = q
+		}
+	}
+	if _, ok := p.kb.GetBucket(p.kb.BucketIndex(nearest.ID())).FindNode(nearest.ID()); !ok {
+		t.Fatalf("self-lookup did not find the nearest peer %x", nearest.node.ID[:4])
+	}
+	value := []byte("bootstrap-value")
+	key := KeyFromBytes(value)
+	if n, err := p.SetValue(context.Background(), key[:], value); n == 0 {
+		t.Fatalf("SetValue after Bootstrap = %d, %v", n, err)
+	}
+	if got, err := peers[len(peers)-1].GetValue(context.Background(), key); string(got) != string(value) {
+		t.Fatalf("GetValue from another peer = %q, %v", got, err)
+	}
+}
+
+// 没有种子响应时返回 ErrNoSeeds：没有种子、种子是自身或 ID 与种子不符
+func TestBootstrapNoSeeds(t *testing.T) {
+	p := NewPeer(KeyFromString("bootstrap-alone"))
+	seed := NewPeer(KeyFromString("bootstrap-seed"))
+	for name, seeds := range map[string][]Contact{
+		"none":     nil,
+		"self":     {{Peer: p}},
+		"mismatch": {{ID: KeyFromString("bootstrap-other"), Peer: seed}},
+	} {
+		if err := p.Bootstrap(seeds); !errors.Is(err, ErrNoSeeds) {
+			t.Fatalf("%s: Bootstrap = %v, want ErrNoSeeds", name, err)
+		}
+	}
+	if n := p.kb.Size(); n != 0 {
+		t.Fatalf("failed Bootstraps left %d nodes in the routing table", n)
+	}
+}
+
+// 以地址给出的种子通过 UDP 联系，响应方的 ID 由 ping 补齐
+func TestBootstrapOverUDP(t *testing.T) {
+	seed := NewPeer(KeyFromString("bootstrap-udp-seed"))
+	ts, err := ListenUDP(seed, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	p := NewPeer(KeyFromString("bootstrap-udp-new"))
+	tp, err := ListenUDP(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	if err := p.Bootstrap([]Contact{{Addr: ts.Addr()}}); err != nil {
+		t.Fatal(err)
+	}
+	node, ok := p.kb.GetBucket(p.kb.BucketIndex(seed.ID())).FindNode(seed.ID())
+	if !ok {
+		t.Fatal("seed missing from the routing table")
+	}
+	if addr, isAddr := node.Data.(*net.UDPAddr); !isAddr || addr.Port != ts.Addr().Port {
+		t.Fatalf("seed stored as %v, want its address %v", node.Data, ts.Addr())
+	}
+}
+
+// 共用的 BootstrapLimiter 先放行 burst 个节点，之后按速率逐个放行
+func TestBootstrapLimiter(t *testing.T) {
+	l := NewBootstrapLimiter(10, 2)
+	t0 := time.Now()
+	want := []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, w := range want {
+		if got := l.Reserve(t0).Sub(t0); got != w {
+			t.Fatalf("reservation %d starts after %v, want %v", i, got, w)
+		}
+	}
+	if got := l.Reserve(t0.Add(time.Second)).Sub(t0); got != time.Second {
+		t.Fatalf("reservation after an idle second starts after %v, want 1s", got)
+	}
+
+	peers := newTestNetwork(8, 10)
+	cfg := Config{BootstrapLimiter: NewBootstrapLimiter(20, 1)}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p, err := NewPeerWithConfig(KeyFromString(fmt.Sprintf("bootstrap-limited-%d", i)), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Bootstrap([]Contact{{Peer: peers[0]}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("three limited Bootstraps at 20/s took %v, want at least 100ms", elapsed)
+	}
+}
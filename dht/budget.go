@@ -0,0 +1,133 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 估计一次完整迭代查找的耗时时使用的轮数
+const budgetLookupRounds = 3
+
+var ErrBudgetExceeded = errors.New("dht: latency budget exceeded")
+
+// GetValueWithBudget 中满足请求的层级
+type ValueTier int
+
+const (
+	TierNone   ValueTier = iota // 没有找到
+	TierLocal                   // 本地存储或缓存
+	TierNearby                  // 路由表中距离 key 最近的节点之一
+	TierLookup                  // 完整的迭代查找
+)
+
+func (t ValueTier) String() string {
+	switch t {
+	case TierNone:
+		return "none"
+	case TierLocal:
+		return "local"
+	case TierNearby:
+		return "nearby"
+	case TierLookup:
+		return "lookup"
+	}
+	return fmt.Sprintf("ValueTier(%d)", int(t))
+}
+
+// 在 budget 时间内读取 key，并返回满足请求的层级。先查本地存储与缓存；
+// 再用至多一半的预算同时询问路由表中距离 key 最近的节点；剩余时间足够一次
+// 完整的迭代查找（按已测得的 RTT 估计）时才进行迭代查找。
+// 查找完成仍未找到时返回 ErrNotFound，预算不足以完成查找时返回 ErrBudgetExceeded
+func (p *Peer) GetValueWithBudget(ctx context.Context, key [kbucket.IdSize]byte, budget time.Duration) ([]byte, ValueTier, error) {
+	deadline := time.Now().Add(budget)
+	p.stats.record(key, false)
+	value, ok := p.store.get(key)
+	p.metricStore(ok)
+	if ok {
+		return value, TierLocal, nil
+	}
+	if p.negativeCached(key) {
+		return nil, TierNone, ErrNotFound
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, TierNone, err
+	}
+	if p.static {
+		if value = p.staticGetValue(key); value != nil {
+			return value, TierNearby, nil
+		}
+		return nil, TierNone, ErrNotFound
+	}
+
+	nearCtx, cancel := context.WithDeadline(ctx, time.Now().Add(budget/2))
+	value, slowest := p.askNearby(nearCtx, key)
+	cancel()
+	if value != nil {
+		return value, TierNearby, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, TierNone, err
+	}
+	if time.Until(deadline) <= slowest*budgetLookupRounds {
+		return nil, TierNone, ErrBudgetExceeded
+	}
+
+	lookupCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	b := p.newLookupBudget(lookupCtx)
+	if value = p.lookupValue(key, b); value != nil {
+		return value, TierLookup, nil
+	}
+	switch {
+	case b.err == nil:
+		if b.missConfirmed() {
+			p.cacheMiss(key)
+		}
+		return nil, TierNone, ErrNotFound
+	case ctx.Err() == nil && errors.Is(b.err, context.DeadlineExceeded):
+		return nil, TierNone, ErrBudgetExceeded
+	}
+	return nil, TierNone, b.err
+}
+
+// 同时向路由表中距离 key 最近的 K 个节点发送 FIND_VALUE，返回最先收到的有效值，
+// 以及其中已测得的最大平均 RTT，用于估计迭代查找的耗时
+func (p *Peer) askNearby(ctx context.Context, key [kbucket.IdSize]byte) ([]byte, time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var slowest time.Duration
+	found := make(chan []byte, p.cfg.K)
+	pending := 0
+	for _, c := range p.preferLowRTT(key, contactsOf(p.kb.FindClosestNodes(key, p.cfg.K))) {
+		m := p.messengerFor(c)
+		if m == nil {
+			continue
+		}
+		if s, ok := p.PeerStats(c.ID); ok && s.MeanRTT() > slowest {
+			slowest = s.MeanRTT()
+		}
+		pending++
+		go func(c Contact) {
+			v, _, err := m.FindValue(ctx, c, key)
+			if err != nil || v == nil || p.validate(key, v) != nil {
+				v = nil
+			}
+			found <- v
+		}(c)
+	}
+	for ; pending > 0; pending-- {
+		select {
+		case v := <-found:
+			if v != nil {
+				return v, slowest
+			}
+		case <-ctx.Done():
+			return nil, slowest
+		}
+	}
+	return nil, slowest
+}
@@ -0,0 +1,52 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const DefaultRetryAfter = time.Second // 存储已满时建议对方等待的时间
+
+// 回复 BUSY 的节点在 RetryAfter 之前不再优先联系
+type backoffList struct {
+	mu    sync.Mutex
+	until map[[kbucket.IdSize]byte]time.Time
+}
+
+// 设置本节点回复 BUSY 时携带的重试等待时间，0 表示使用 DefaultRetryAfter
+func (p *Peer) SetRetryAfter(d time.Duration) {
+	p.retryAfter = d
+}
+
+func (p *Peer) busyRetryAfter() time.Duration {
+	if p.retryAfter > 0 {
+		return p.retryAfter
+	}
+	return DefaultRetryAfter
+}
+
+// 记录 id 回复了 BUSY，在 d 之内不优先联系它
+func (p *Peer) throttle(id [kbucket.IdSize]byte, d time.Duration) {
+	b := &p.backoff
+	b.mu.Lock()
+	if b.until == nil {
+		b.until = make(map[[kbucket.IdSize]byte]time.Time)
+	}
+	b.until[id] = time.Now().Add(d)
+	b.mu.Unlock()
+}
+
+// id 是否仍在回复 BUSY 后的等待期内
+func (p *Peer) throttled(id [kbucket.IdSize]byte) bool {
+	b := &p.backoff
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[id]
+	if ok && !time.Now().Before(until) {
+		delete(b.until, id)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,100 @@
+package dht
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 存储前面的 LRU 缓存：读取时先查缓存，未命中再读存储并放入缓存（read-through），
+// 写入时同时更新存储和缓存（write-through）
+type valueCache struct {
+	mu     sync.Mutex
+	size   int
+	ll     *list.List // 最近使用的在前
+	items  map[[kbucket.IdSize]byte]*list.Element
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry struct {
+	key     [kbucket.IdSize]byte
+	value   []byte
+	expires time.Time // 与存储中的记录一致，零值表示不过期
+}
+
+// 缓存命中统计
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Len    int // 当前缓存的记录数
+	Size   int // 缓存容量，0 表示未启用缓存
+}
+
+func newValueCache(size int) *valueCache {
+	if size <= 0 {
+		return nil
+	}
+	return &valueCache{size: size, ll: list.New(), items: make(map[[kbucket.IdSize]byte]*list.Element)}
+}
+
+func (c *valueCache) get(key [kbucket.IdSize]byte, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if e.expires.IsZero() || now.Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.value, true
+		}
+		c.removeElement(el) // 过期的条目交给存储判断
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *valueCache) add(key [kbucket.IdSize]byte, value []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, expires: expires})
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *valueCache) remove(key [kbucket.IdSize]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// 调用方需持有 c.mu
+func (c *valueCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+func (c *valueCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Len: c.ll.Len(), Size: c.size}
+}
+
+// 本地存储前面的缓存的命中统计
+func (p *Peer) CacheStats() CacheStats {
+	return p.store.cache.stats()
+}
@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	routingCardVersion = 1
+	RoutingCardTTL     = time.Hour
+)
+
+// 发布路由名片使用的公共 key，目录、tracker 等外部系统从这里读取在线节点
+var RoutingCardKey = KeyFromString("kbucket/routing-cards")
+
+var ErrBadRoutingCard = errors.New("dht: invalid routing card")
+
+// 节点自身的联系记录以及少量已验证存活的节点
+type RoutingCard struct {
+	ID        [kbucket.IdSize]byte
+	PublicKey ed25519.PublicKey
+	Issued    time.Time
+	Peers     [][kbucket.IdSize]byte
+}
+
+// 生成签名并压缩的路由名片，最多包含 sample 个近期确认过存活的节点。
+// 格式：签名(64) | gzip(版本 | 自身 ID | 公钥 | 签发时间 | 节点数 | 节点 ID...)
+func (p *Peer) RoutingCard(priv ed25519.PrivateKey, sample int) ([]byte, error) {
+	var verified [][kbucket.IdSize]byte
+	for _, node := range p.kb.SampleContacts(len(p.kb.AllNodes()), 0) {
+		if len(verified) >= sample || len(verified) == 255 {
+			break
+		}
+		if !node.LastSeen.IsZero() {
+			verified = append(verified, node.ID)
+		}
+	}
+	var payload bytes.Buffer
+	payload.WriteByte(routingCardVersion)
+	payload.Write(p.node.ID[:])
+	payload.Write(priv.Public().(ed25519.PublicKey))
+	binary.Write(&payload, binary.BigEndian, time.Now().Unix())
+	payload.WriteByte(byte(len(verified)))
+	for _, id := range verified {
+		payload.Write(id[:])
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(payload.Bytes())
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return append(ed25519.Sign(priv, compressed.Bytes()), compressed.Bytes()...), nil
+}
+
+// 校验签名并解析路由名片
+func ParseRoutingCard(card []byte) (RoutingCard, error) {
+	if len(card) <= ed25519.SignatureSize {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	sig, compressed := card[:ed25519.SignatureSize], card[ed25519.SignatureSize:]
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	r := bytes.NewReader(payload)
+	var c RoutingCard
+	version, _ := r.ReadByte()
+	c.PublicKey = make(ed25519.PublicKey, ed25519.PublicKeySize)
+	var issued int64
+	if version != routingCardVersion ||
+		!readFull(r, c.ID[:]) || !readFull(r, c.PublicKey) ||
+		binary.Read(r, binary.BigEndian, &issued) != nil {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	if !ed25519.Verify(c.PublicKey, compressed, sig) {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	c.Issued = time.Unix(issued, 0)
+	n, err := r.ReadByte()
+	if err != nil {
+		return RoutingCard{}, ErrBadRoutingCard
+	}
+	c.Peers = make([][kbucket.IdSize]byte, n)
+	for i := range c.Peers {
+		if !readFull(r, c.Peers[i][:]) {
+			return RoutingCard{}, ErrBadRoutingCard
+		}
+	}
+	return c, nil
+}
+
+func readFull(r io.Reader, b []byte) bool {
+	_, err := io.ReadFull(r, b)
+	return err == nil
+}
+
+// 把路由名片发布到 RoutingCardKey 下
+func (p *Peer) PublishRoutingCard(priv ed25519.PrivateKey, sample int) error {
+	card, err := p.RoutingCard(priv, sample)
+	if err != nil {
+		return err
+	}
+	return p.AppendValue(RoutingCardKey, card, RoutingCardTTL)
+}
@@ -0,0 +1,104 @@
+package dht
+
+import (
+	"encoding/gob"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 某个时刻单个节点的完整状态
+type PeerCheckpoint struct {
+	ID       [kbucket.IdSize]byte
+	Contacts [][kbucket.IdSize]byte // 路由表中的节点
+	Keys     [][kbucket.IdSize]byte // 本地保存的 key
+}
+
+// 仿真在 At 时刻的全部节点状态
+type Checkpoint struct {
+	At    time.Duration
+	Peers []PeerCheckpoint
+}
+
+// 在仿真过程中周期性记录全量状态，事后可以回到任意时刻查询
+type Recorder struct {
+	Checkpoints []Checkpoint
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// 记录 peers 在仿真时刻 at 的状态，at 应当单调递增
+func (r *Recorder) Record(at time.Duration, peers []*Peer) {
+	cp := Checkpoint{At: at, Peers: make([]PeerCheckpoint, 0, len(peers))}
+	for _, p := range peers {
+		pc := PeerCheckpoint{ID: p.node.ID}
+		for _, node := range p.kb.AllNodes() {
+			pc.Contacts = append(pc.Contacts, node.ID)
+		}
+		pc.Keys = append(pc.Keys, p.store.keys()...)
+		cp.Peers = append(cp.Peers, pc)
+	}
+	r.Checkpoints = append(r.Checkpoints, cp)
+}
+
+// 返回 t 时刻（含）之前最近的一个检查点，没有则返回 nil
+func (r *Recorder) At(t time.Duration) *Checkpoint {
+	i := sort.Search(len(r.Checkpoints), func(i int) bool { return r.Checkpoints[i].At > t })
+	if i == 0 {
+		return nil
+	}
+	return &r.Checkpoints[i-1]
+}
+
+func (r *Recorder) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(r)
+}
+
+func LoadRecorder(rd io.Reader) (*Recorder, error) {
+	r := &Recorder{}
+	if err := gob.NewDecoder(rd).Decode(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 检查点中距离 key 最近的 n 个节点
+func (c *Checkpoint) Closest(key [kbucket.IdSize]byte, n int) [][kbucket.IdSize]byte {
+	ids := make([][kbucket.IdSize]byte, len(c.Peers))
+	for i, pc := range c.Peers {
+		ids[i] = pc.ID
+	}
+	sort.Slice(ids, func(i, j int) bool { return kbucket.Closer(ids[i], ids[j], key) })
+	if n < len(ids) {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+// 检查点中保存了 key 的节点
+func (c *Checkpoint) Holders(key [kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+	var holders [][kbucket.IdSize]byte
+	for _, pc := range c.Peers {
+		for _, k := range pc.Keys {
+			if k == key {
+				holders = append(holders, pc.ID)
+				break
+			}
+		}
+	}
+	return holders
+}
+
+// 检查点中 id 对应节点的状态
+func (c *Checkpoint) Peer(id [kbucket.IdSize]byte) (PeerCheckpoint, bool) {
+	for _, pc := range c.Peers {
+		if pc.ID == id {
+			return pc, true
+		}
+	}
+	return PeerCheckpoint{}, false
+}
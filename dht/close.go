@@ -0,0 +1,90 @@
+package dht
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 优雅地离开网络：停止后台任务；配置了 HandoffOnClose 时把本地记录复制到剩余的最近节点；
+// 通知路由表中的所有节点本节点离开；最后关闭传输层并 Stop。ctx 结束时跳过剩余的复制与通知，
+// 但仍然关闭节点。返回遇到的第一个错误
+func (p *Peer) Close(ctx context.Context) error {
+	if p.refreshStop != nil { // 复制期间不再刷新路由表
+		close(p.refreshStop)
+		<-p.refreshDone
+		p.refreshStop, p.refreshDone = nil, nil
+	}
+	var first error
+	keep := func(err error) {
+		if first == nil && err != nil {
+			first = err
+		}
+	}
+	if q := p.queue(); q != nil { // 排队的 STORE 也要移交
+		q.close()
+	}
+	if p.cfg.HandoffOnClose {
+		keep(p.handoff(ctx))
+	}
+	keep(p.announceLeave(ctx))
+	if t := p.transport; t != nil {
+		keep(t.Close())
+	}
+	if c, ok := p.messenger.(io.Closer); ok {
+		keep(c.Close())
+	}
+	keep(p.Stop())
+	return first
+}
+
+// 把本地记录逐条复制到剩余的最近节点，本节点已经不在查找结果中
+func (p *Peer) handoff(ctx context.Context) error {
+	var err error
+	p.RangeRecords(func(r StoredRecord) bool {
+		_, err = p.replicate(ctx, r.Key, r.Value, NewTraceID())
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return false
+		}
+		err = nil // 查找提前结束只影响这一条记录
+		return true
+	})
+	return err
+}
+
+// 并行通知路由表中的节点本节点离开，对方没有响应不算错误
+func (p *Peer) announceLeave(ctx context.Context) error {
+	ctx = ContextWithTrace(ctx, NewTraceID())
+	var wg sync.WaitGroup
+	for _, n := range p.kb.AllNodes() {
+		c := contactOf(n)
+		d, ok := p.messengerFor(c).(Departer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Leave(ctx, c)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// 处理 id 发来的离开通知。from 是通知的来源地址，进程内通知为 nil。
+// 路由表中记录的是网络地址时，只接受来自该地址的通知，避免其他节点冒用 ID 把它删除
+func (p *Peer) peerDeparted(id [kbucket.IdSize]byte, from *net.UDPAddr) bool {
+	n, ok := p.kb.GetBucket(p.kb.BucketIndex(id)).FindNode(id)
+	if !ok {
+		return false
+	}
+	if addr, isAddr := n.Data.(*net.UDPAddr); isAddr && (from == nil || !sameAddr(addr, from)) {
+		return false
+	}
+	return p.kb.RemoveDeparted(id)
+}
@@ -0,0 +1,114 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 加入 peers 并被它们认识的节点
+func closingPeer(t *testing.T, name string, peers []*Peer) *Peer {
+	t.Helper()
+	p, err := NewPeerWithConfig(KeyFromString(name), Config{HandoffOnClose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range peers {
+		p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+		q.kb.InsertNode(kbucket.Node{ID: p.node.ID, Data: p})
+	}
+	if !p.Start() {
+		t.Fatal("Start refused")
+	}
+	return p
+}
+
+// 离开前把只有本节点保存的记录移交给剩余的节点，邻居把本节点移出路由表
+func TestCloseHandsOffRecords(t *testing.T) {
+	peers := newTestNetwork(16, 11)
+	p := closingPeer(t, "close-handoff", peers[:8])
+	var keys [][kbucket.IdSize]byte
+	for i := 0; i < 5; i++ {
+		value := []byte(fmt.Sprintf("close-%d", i))
+		key := KeyFromBytes(value)
+		p.store.put(key, value, Provenance{})
+		keys = append(keys, key)
+	}
+	var neighbours []*Peer // 互相在路由表中的节点会收到离开通知
+	for _, q := range peers[:8] {
+		if knows(q, p) && knows(p, q) {
+			neighbours = append(neighbours, q)
+		}
+	}
+	if len(neighbours) == 0 {
+		t.Fatal("no mutual neighbours")
+	}
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.State(); s != StateStopped {
+		t.Fatalf("State after Close = %v", s)
+	}
+	for _, key := range keys {
+		held := false
+		for _, q := range peers {
+			held = held || q.store.has(key)
+		}
+		if !held {
+			t.Fatalf("record %x was lost on Close", key[:4])
+		}
+	}
+	for _, q := range neighbours {
+		if knows(q, p) {
+			t.Fatalf("neighbour %x still routes to the departed peer", q.node.ID[:4])
+		}
+	}
+}
+
+// ctx 已经结束时跳过移交与通知，但节点仍然停止
+func TestCloseCancelled(t *testing.T) {
+	peers := newTestNetwork(8, 12)
+	p := closingPeer(t, "close-cancelled", peers)
+	value := []byte("close-cancelled")
+	key := KeyFromBytes(value)
+	p.store.put(key, value, Provenance{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Close with a cancelled ctx = %v, want context.Canceled", err)
+	}
+	if s := p.State(); s != StateStopped {
+		t.Fatalf("State after a cancelled Close = %v", s)
+	}
+	for _, q := range peers {
+		if q.store.has(key) {
+			t.Fatal("cancelled Close still handed off records")
+		}
+	}
+}
+
+// 网络中的节点只接受来自路由表中记录的地址的离开通知
+func TestPeerDepartedChecksAddress(t *testing.T) {
+	p := NewPeer(KeyFromString("close-addr"))
+	id := KeyFromString("close-remote")
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}
+	p.kb.InsertNode(kbucket.Node{ID: id, Data: addr})
+	for _, from := range []*net.UDPAddr{nil, {IP: net.IPv4(10, 0, 0, 2), Port: 4000}} {
+		if p.peerDeparted(id, from) {
+			t.Fatalf("departure from %v accepted", from)
+		}
+	}
+	if !p.peerDeparted(id, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}) {
+		t.Fatal("departure from the recorded address rejected")
+	}
+}
+
+// q 的路由表中有 p
+func knows(q, p *Peer) bool {
+	_, ok := q.kb.GetBucket(q.kb.BucketIndex(p.ID())).FindNode(p.ID())
+	return ok
+}
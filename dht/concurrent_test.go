@@ -0,0 +1,187 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func newTestNetwork(n int, seed int64) []*Peer {
+	r := rand.New(rand.NewSource(seed))
+	peers := make([]*Peer, n)
+	for i := range peers {
+		peers[i] = NewPeer(KeyFromString(fmt.Sprintf("peer-%d-%d", seed, i)))
+	}
+	for _, p := range peers {
+		for j := 0; j < 3*kbucket.BucketSize; j++ {
+			if q := peers[r.Intn(n)]; q != p {
+				p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+			}
+		}
+	}
+	return peers
+}
+
+// 多个 goroutine 同时在同一组节点上读写，需配合 go test -race 运行
+func TestPeerConcurrentSetGet(t *testing.T) {
+	peers := newTestNetwork(32, 1)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 200; i++ {
+				p := peers[r.Intn(len(peers))]
+				value := []byte(fmt.Sprintf("value-%d", r.Intn(50)))
+				key := KeyFromBytes(value)
+				switch i % 4 {
+				case 0:
+					if n, err := p.SetValue(ctx, key[:], value); n == 0 {
+						t.Errorf("SetValue(%q) refused a valid record: %v", value, err)
+					}
+				case 1:
+					p.GetValue(ctx, key)
+				case 2:
+					p.Lookup(ctx, key)
+				case 3:
+					p.HotKeys(3)
+					p.AntiEntropy()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// 本地写入之后，并发读取必须都能看到
+func TestPeerConcurrentLocalReads(t *testing.T) {
+	p := NewPeer(KeyFromString("local"))
+	ctx := context.Background()
+	values := make([][]byte, 100)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("local-%d", i))
+	}
+	var wg sync.WaitGroup
+	for _, value := range values {
+		wg.Add(1)
+		go func(value []byte) {
+			defer wg.Done()
+			key := KeyFromBytes(value)
+			p.SetValue(ctx, key[:], value)
+			if got, err := p.GetValue(ctx, key); string(got) != string(value) {
+				t.Errorf("GetValue after SetValue = %q, %v, want %q", got, err, value)
+			}
+		}(value)
+	}
+	wg.Wait()
+	if n := p.store.len(); n != len(values) {
+		t.Fatalf("store holds %d records, want %d", n, len(values))
+	}
+}
+
+// 多个节点同时合并并读取同一个 CRDT 记录，合并随 STORE 进入负责节点的状态
+func TestPeerConcurrentCRDT(t *testing.T) {
+	peers := newTestNetwork(16, 2)
+	for _, p := range peers {
+		p.RegisterCRDTNamespace("counters", GCounterKind)
+	}
+	const workers, n = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(p *Peer) {
+			defer wg.Done()
+			c := NewGCounter()
+			for i := 0; i < n; i++ {
+				c.Increment(p.node.ID, 1)
+				if err := p.MergeCRDT(context.Background(), "counters", "hits", c); err != nil {
+					t.Errorf("MergeCRDT rejected a GCounter: %v", err)
+					return
+				}
+				p.GetCRDT(context.Background(), "counters", "hits")
+			}
+		}(peers[w])
+	}
+	wg.Wait()
+	for _, p := range peers[:workers] {
+		v, err := p.GetCRDT(context.Background(), "counters", "hits")
+		got, ok := v.(*GCounter)
+		if err != nil || !ok {
+			t.Fatalf("GetCRDT on %x returned no counter", p.node.ID[:4])
+		}
+		if got.counts[p.node.ID] != n {
+			t.Fatalf("counter on %x has %d of its own increments, want %d", p.node.ID[:4], got.counts[p.node.ID], n)
+		}
+	}
+}
+
+// 同时向同一个 key 追加并读取多值记录，读取不修改记录
+func TestPeerConcurrentMultiValue(t *testing.T) {
+	peers := newTestNetwork(16, 3)
+	key := KeyFromString("multi")
+	const workers, n = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int, p *Peer) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := p.AppendValue(key, []byte(fmt.Sprintf("%d-%d", w, i%5)), time.Minute); err != nil {
+					t.Error(err)
+					return
+				}
+				p.GetValues(key)
+			}
+		}(w, peers[w])
+	}
+	wg.Wait()
+	values, err := peers[0].GetValues(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(values); got == 0 || got > MaxValuesPerKey*(1+DefaultReplicationFactor) {
+		t.Fatalf("GetValues returned %d values", got)
+	}
+	if n := peers[0].expireValues(); n != 0 {
+		t.Fatalf("expireValues removed %d live values", n)
+	}
+}
+
+// 后台存活检查转换状态的同时读取状态，需配合 go test -race 运行
+func TestPeerConcurrentState(t *testing.T) {
+	peers := newTestNetwork(4, 3)
+	p, err := NewPeerWithConfig(KeyFromString("state-self"), Config{HealthCheckInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := kbucket.Node{ID: peers[0].node.ID, Data: peers[0]}
+	events := p.SubscribeState(16)
+	if !p.Start() {
+		t.Fatal("Start refused")
+	}
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ { // 路由表在空与非空之间切换，后台检查在 Ready 与 Degraded 之间转换
+		if i%2 == 0 {
+			p.kb.InsertNode(node)
+		} else {
+			p.kb.RemoveNode(node.ID)
+		}
+		p.State()
+		time.Sleep(time.Millisecond)
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if s := p.State(); s != StateStopped {
+		t.Fatalf("State after Stop = %v", s)
+	}
+	for range events { // Stop 关闭订阅
+	}
+}
@@ -0,0 +1,345 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+const (
+	DefaultAlpha             = 3         // 迭代查找每一轮并发查询的节点数
+	DefaultLowPriorityRPCs   = 8         // 同时进行的低优先级 RPC 数
+	DefaultReplicationFactor = 2         // GetValue 每一跳查询的节点数
+	DefaultRefreshInterval   = time.Hour // bucket 多久没有查找经过就需要刷新
+	DefaultRecordTTL         = 24 * time.Hour
+	DefaultRepublishInterval = time.Hour
+
+	DefaultProviderTTL               = 24 * time.Hour
+	DefaultProviderRepublishInterval = 12 * time.Hour
+)
+
+// 节点参数。零值字段使用默认值
+type Config struct {
+	K                 int           // 每个 bucket 的容量，也是查找返回的节点数
+	Alpha             int           // 迭代查找每一轮并发查询的节点数
+	MaxAlpha          int           // 查找超时频繁时并发度最多提高到的值，不大于 Alpha 表示固定为 Alpha，见 LookupWidth
+	IDBits            int           // 节点 ID 的比特数，必须等于 kbucket.IdSize*8，由构建标签决定
+	ReplicationFactor int           // 读取时每一跳查询的节点数
+	RefreshInterval   time.Duration // bucket 多久没有查找经过就需要刷新
+	RecordTTL         time.Duration // 本地记录的有效期，负数表示不过期
+	RepublishInterval time.Duration // 记录重新发布的周期，应小于 RecordTTL，负数表示不重新发布
+	CacheDecay        bool          // 远离 key 的缓存副本的有效期随更近的节点数指数缩短且不重新发布，见 Kademlia 论文 2.5 节
+	CacheSize         int           // 本地存储前面的 LRU 缓存能保存的记录数，0 表示不使用缓存
+	MaxRecords        int           // 默认的内存存储最多保存的记录数，超出时淘汰最久未读写的记录，0 表示不限制
+	MaxRecordBytes    int           // 默认的内存存储中值的总字节数上限，0 表示不限制
+	Jitter            float64       // 后台刷新、存活检查与清理周期的随机抖动比例，0.1 表示在 ±10% 内变化
+
+	ProviderTTL               time.Duration // 本地保存的 provider 记录的有效期，负数表示不过期
+	ProviderRepublishInterval time.Duration // 重新通告 Provide 过的 key 的周期，应小于 ProviderTTL，负数表示不重新通告
+
+	ConflictPolicy    kbucket.ConflictPolicy // 不同地址声称同一节点 ID 时的处理策略
+	ContactAcceptance ContactAcceptance      // 查找响应中的联系人如何加入路由表，见 AcceptResponders
+	Protocol          Protocol               // Listen 使用的协议
+
+	RequireSignatures bool              // 只接受 ID 由公钥导出并带有有效签名的节点的消息
+	ContactValidation ContactValidation // 是否拒绝查找响应中 ID 与公钥不符的联系人，见 ValidatePermissive
+
+	StaleFailures       int           // 连续联系失败多少次后节点视为失效，0 表示 kbucket.DefaultStaleFailures
+	HealthCheckInterval time.Duration // Start 之后后台存活检查的周期，0 表示不自动检查
+	FreshnessThreshold  time.Duration // 超过这个时间没有确认存活的联系人由后台逐个 ping，最久的优先，0 表示不主动 ping
+	ProbeRate           float64       // 后台每秒最多 ping 的联系人数，0 表示 DefaultProbeRate
+	QuarantineGrace     time.Duration // 疑似失效的节点在隔离列表中等待恢复的时间，0 表示直接淘汰
+	HandoffOnClose      bool          // Close 时把本地记录复制到剩余的最近节点
+
+	PartitionFailures int           // 连续多少次查找没有任何响应视为与网络断开
+	RejoinInterval    time.Duration // 与网络断开后从种子节点重新加入的最短间隔，失败时加倍，负数表示不自动重新加入
+	MaxRejoinInterval time.Duration // 重新加入失败后的最长等待间隔
+
+	// 大量节点同时启动时避免一齐涌向种子节点：Bootstrap 先随机等待 [0, BootstrapJitter)，
+	// 再等待 BootstrapLimiter 的名额。同一进程中的多个节点可以共用一个 BootstrapLimiter
+	BootstrapJitter  time.Duration
+	BootstrapLimiter *BootstrapLimiter
+
+	DriftCheckInterval time.Duration // 检查本节点发布的 key 副本漂移的周期，负数表示不检查
+
+	RTTHints bool // FIND_NODE 请求响应方附带它观测到的 RTT 与可靠性，查找时优先查询较快的节点
+
+	// 一个目标在 FindNodeCacheTTL 内收到 FindNodePopularity 次 FIND_NODE 之后，对它的响应
+	// 缓存 FindNodeCacheTTL，期间不再查询路由表；0 表示不缓存。缓存的响应可能缺少期间新加入的节点
+	FindNodeCacheTTL time.Duration
+
+	// 迭代查找中的查询超过对方 RTT 的 p95 仍未回复时，立即查询下一个候选而不等待超时；
+	// 被对冲的慢节点不再推迟本轮结束。RTT 样本不足的节点不对冲，对冲次数见 OpStats
+	HedgeLookups bool
+
+	// 发布时不等查找结束：每轮查询之后，排在最前面、已经回复过的节点立即收到 STORE，
+	// 查找的后几轮与复制重叠，距离远的 key 发布延迟大约减半。之后学到更近的节点时，
+	// 先收到 STORE 的节点可能不在最终的最近节点中，多出的副本同样计入结果
+	PipelineStores bool
+
+	SoftwareVersion string // 握手中声明的软件版本，见 PeerInfo
+
+	PeerRequestRate    float64       // 每个远端节点每秒允许的请求数，0 表示不限制
+	PeerRequestBurst   int           // 单个节点允许的突发请求数，0 表示与 PeerRequestRate 相同
+	GlobalRequestRate  float64       // 所有节点合计每秒允许的请求数，0 表示不限制
+	GlobalRequestBurst int           // 全局允许的突发请求数，0 表示与 GlobalRequestRate 相同
+	MaxStoreSize       int           // 单个 STORE 请求中值的最大字节数，0 表示不限制
+	QuotaBanThreshold  int           // 节点连续超出配额多少次后临时封禁，负数表示不封禁
+	QuotaBanDuration   time.Duration // 临时封禁的时长
+
+	RangeSyncRate float64 // 每个节点每秒可以请求的 RANGE_SYNC 页数与 DIGEST_SYNC 次数，负数表示不限制
+	SyncOnJoin    bool    // Bootstrap 之后用 RANGE_SYNC 从邻居拉取本节点负责区域内的记录
+
+	// 节点只发起请求、不为其他节点提供服务：进程内的其他节点不把它加入路由表，
+	// 因而不会向它查询或复制记录。通过网络联系的节点不受影响
+	ClientOnly bool
+
+	// 连接按流量计费（见 SetMetered）时暂停服务：进程内的其他节点不把它加入路由表，
+	// 通过网络收到的请求不予回复，对方视为超时。本节点发起的请求不受影响
+	ClientOnlyWhenMetered bool
+
+	// 大于 0 时低优先级（维护）RPC 推迟到 LowPriorityBatch 的整数倍时刻一起发出，
+	// 无线模块每一批只需唤醒一次。维护查找的每一轮都要等到下一批
+	LowPriorityBatch time.Duration
+
+	// 设置 SnapshotPath 时，Start 之后每隔 SnapshotInterval 把路由表与记录写入快照
+	// （见 SaveSnapshot），Stop 时再写一次；SnapshotInterval 为 0 表示只在 Stop 时写入
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+
+	// 读取时找到值之后继续询问其他副本，直到 ReadFanout 个副本返回了值，再比较它们
+	// 以发现静默损坏或投毒的副本，见 ReadVerification。0 或 1 表示找到第一个值即返回
+	ReadFanout int
+
+	// 大于 0 时使用扁平路由表：只有一个不分裂、最多保存 FlatTable 个联系人的 bucket，
+	// Bootstrap 时认识网络中的全部节点。适合约 200 个节点以下的小网络，存储与查找的
+	// 接口不变。0 表示使用普通的 Kademlia 路由表，见 DefaultFlatTableSize
+	FlatTable int
+
+	// 比较节点与 key 的距离的度量，nil 表示 kbucket.XORMetric。只改变"最近"的排序，
+	// bucket 仍按 XOR 前缀划分；同一网络中的节点应使用相同的度量
+	Metric kbucket.Metric
+
+	Resources ResourceLimits // 节点可以占用的资源上限，零值表示不限制
+
+	LowPriorityRPCs int // 同时进行的低优先级（维护）RPC 数，超出时排队等待，0 表示 DefaultLowPriorityRPCs，负数表示不限制
+
+	// 更换哈希函数（SetHasher）后仍然接受的旧哈希函数，nil 表示没有迁移。在 LegacyUntil
+	// 之前，以旧哈希计算 key 的内容寻址记录仍然有效，GetValue 在找不到值时改读
+	// MigrateKeys 记下的另一个 key；零值的 LegacyUntil 表示一直兼容
+	LegacyHasher Hasher
+	LegacyUntil  time.Time
+
+	// 单次 RPC 超时的上下限。每个节点的超时按测得的 RTT 均值与偏差估计（RFC 6298），
+	// 超时后加倍；没有测量过的节点使用传输层的 Timeout
+	MinRTO time.Duration
+	MaxRTO time.Duration
+
+	JournalAcks int // 发布日志中的记录有多少个远端副本确认后才算完成，0 表示 1，见 Journal
+}
+
+func DefaultConfig() Config {
+	return Config{
+		K:                 kbucket.BucketSize,
+		Alpha:             DefaultAlpha,
+		LowPriorityRPCs:   DefaultLowPriorityRPCs,
+		IDBits:            kbucket.IdSize * 8,
+		ReplicationFactor: DefaultReplicationFactor,
+		RefreshInterval:   DefaultRefreshInterval,
+		RecordTTL:         DefaultRecordTTL,
+		RepublishInterval: DefaultRepublishInterval,
+
+		ProviderTTL:               DefaultProviderTTL,
+		ProviderRepublishInterval: DefaultProviderRepublishInterval,
+
+		PartitionFailures: DefaultPartitionFailures,
+		RejoinInterval:    DefaultRejoinInterval,
+		MaxRejoinInterval: DefaultMaxRejoinInterval,
+
+		DriftCheckInterval: DefaultDriftCheckInterval,
+
+		SoftwareVersion: DefaultSoftwareVersion,
+
+		QuotaBanThreshold: DefaultQuotaBanThreshold,
+		QuotaBanDuration:  DefaultQuotaBanDuration,
+
+		RangeSyncRate: DefaultRangeSyncRate,
+
+		ProbeRate: DefaultProbeRate,
+
+		MinRTO: DefaultMinRTO,
+		MaxRTO: DefaultMaxRTO,
+	}
+}
+
+// 用默认值填充零值字段
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.K == 0 {
+		c.K = d.K
+	}
+	if c.Alpha == 0 {
+		c.Alpha = d.Alpha
+	}
+	if c.LowPriorityRPCs == 0 {
+		c.LowPriorityRPCs = d.LowPriorityRPCs
+	}
+	if c.IDBits == 0 {
+		c.IDBits = d.IDBits
+	}
+	if c.ReplicationFactor == 0 {
+		c.ReplicationFactor = d.ReplicationFactor
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = d.RefreshInterval
+	}
+	if c.RecordTTL == 0 {
+		c.RecordTTL = d.RecordTTL
+	}
+	if c.RepublishInterval == 0 {
+		c.RepublishInterval = d.RepublishInterval
+	}
+	if c.ProviderTTL == 0 {
+		c.ProviderTTL = d.ProviderTTL
+	}
+	if c.ProviderRepublishInterval == 0 {
+		c.ProviderRepublishInterval = d.ProviderRepublishInterval
+	}
+	if c.PartitionFailures == 0 {
+		c.PartitionFailures = d.PartitionFailures
+	}
+	if c.RejoinInterval == 0 {
+		c.RejoinInterval = d.RejoinInterval
+	}
+	if c.MaxRejoinInterval == 0 {
+		c.MaxRejoinInterval = d.MaxRejoinInterval
+	}
+	if c.DriftCheckInterval == 0 {
+		c.DriftCheckInterval = d.DriftCheckInterval
+	}
+	if c.SoftwareVersion == "" {
+		c.SoftwareVersion = d.SoftwareVersion
+	}
+	if c.QuotaBanThreshold == 0 {
+		c.QuotaBanThreshold = d.QuotaBanThreshold
+	}
+	if c.QuotaBanDuration == 0 {
+		c.QuotaBanDuration = d.QuotaBanDuration
+	}
+	if c.RangeSyncRate == 0 {
+		c.RangeSyncRate = d.RangeSyncRate
+	}
+	if c.ProbeRate == 0 {
+		c.ProbeRate = d.ProbeRate
+	}
+	if c.MinRTO == 0 {
+		c.MinRTO = d.MinRTO
+	}
+	if c.MaxRTO == 0 {
+		c.MaxRTO = d.MaxRTO
+	}
+	return c
+}
+
+// Validate 返回的错误之一，指出不合法的字段
+type ConfigError struct {
+	Field  string      // Config 中的字段名
+	Value  interface{} // 字段的值
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("dht: Config.%s = %v: %s", e.Field, e.Value, e.Reason)
+}
+
+// 检查参数之间的约束，零值字段按默认值检查。返回所有不合法的字段，
+// 每一个都是 *ConfigError，可以用 errors.As 取出第一个
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	var errs []error
+	check := func(ok bool, field string, value interface{}, reason string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, &ConfigError{Field: field, Value: value, Reason: fmt.Sprintf(reason, args...)})
+		}
+	}
+	check(c.K >= 1, "K", c.K, "must be at least 1")
+	check(c.K <= udpwire.MaxContacts, "K", c.K, "must not exceed %d, the most contacts a reply can carry", udpwire.MaxContacts)
+	check(c.Alpha >= 1, "Alpha", c.Alpha, "must be at least 1")
+	check(c.Alpha <= c.K, "Alpha", c.Alpha, "must not exceed K (%d)", c.K)
+	check(c.MaxAlpha <= c.K, "MaxAlpha", c.MaxAlpha, "must not exceed K (%d)", c.K)
+	check(c.BootstrapJitter >= 0, "BootstrapJitter", c.BootstrapJitter, "must not be negative")
+	check(c.FindNodeCacheTTL >= 0, "FindNodeCacheTTL", c.FindNodeCacheTTL, "must not be negative")
+	check(c.LowPriorityBatch >= 0, "LowPriorityBatch", c.LowPriorityBatch, "must not be negative")
+	check(c.SnapshotInterval >= 0, "SnapshotInterval", c.SnapshotInterval, "must not be negative")
+	check(c.IDBits%8 == 0, "IDBits", c.IDBits, "must be a multiple of 8")
+	check(c.IDBits%8 != 0 || c.IDBits == kbucket.IdSize*8, "IDBits", c.IDBits, "this build uses %d-bit IDs", kbucket.IdSize*8)
+	check(c.ReplicationFactor >= 1, "ReplicationFactor", c.ReplicationFactor, "must be at least 1")
+	check(c.ReplicationFactor <= c.K, "ReplicationFactor", c.ReplicationFactor, "must not exceed K (%d)", c.K)
+	check(c.RefreshInterval >= 0, "RefreshInterval", c.RefreshInterval, "must not be negative")
+	check(c.RecordTTL <= 0 || c.RepublishInterval < c.RecordTTL, "RepublishInterval", c.RepublishInterval,
+		"must be shorter than RecordTTL (%v)", c.RecordTTL)
+	check(c.ProviderTTL <= 0 || c.ProviderRepublishInterval < c.ProviderTTL, "ProviderRepublishInterval", c.ProviderRepublishInterval,
+		"must be shorter than ProviderTTL (%v)", c.ProviderTTL)
+	check(c.HealthCheckInterval >= 0, "HealthCheckInterval", c.HealthCheckInterval, "must not be negative")
+	check(c.FreshnessThreshold >= 0, "FreshnessThreshold", c.FreshnessThreshold, "must not be negative")
+	check(c.ProbeRate > 0, "ProbeRate", c.ProbeRate, "must be positive")
+	check(c.PartitionFailures >= 1, "PartitionFailures", c.PartitionFailures, "must be at least 1")
+	check(c.RejoinInterval <= 0 || c.MaxRejoinInterval >= c.RejoinInterval, "MaxRejoinInterval", c.MaxRejoinInterval,
+		"must not be shorter than RejoinInterval (%v)", c.RejoinInterval)
+	check(c.QuarantineGrace >= 0, "QuarantineGrace", c.QuarantineGrace, "must not be negative")
+	check(c.Jitter >= 0 && c.Jitter < 1, "Jitter", c.Jitter, "must be in [0, 1)")
+	check(c.CacheSize >= 0, "CacheSize", c.CacheSize, "must not be negative")
+	check(c.MaxRecords >= 0, "MaxRecords", c.MaxRecords, "must not be negative")
+	check(c.MaxRecordBytes >= 0, "MaxRecordBytes", c.MaxRecordBytes, "must not be negative")
+	check(c.StaleFailures >= 0, "StaleFailures", c.StaleFailures, "must not be negative")
+	check(c.PeerRequestRate >= 0, "PeerRequestRate", c.PeerRequestRate, "must not be negative")
+	check(c.PeerRequestBurst >= 0, "PeerRequestBurst", c.PeerRequestBurst, "must not be negative")
+	check(c.GlobalRequestRate >= 0, "GlobalRequestRate", c.GlobalRequestRate, "must not be negative")
+	check(c.GlobalRequestBurst >= 0, "GlobalRequestBurst", c.GlobalRequestBurst, "must not be negative")
+	check(c.MaxStoreSize >= 0, "MaxStoreSize", c.MaxStoreSize, "must not be negative")
+	check(c.QuotaBanDuration > 0, "QuotaBanDuration", c.QuotaBanDuration, "must be positive")
+	check(c.ReadFanout >= 0, "ReadFanout", c.ReadFanout, "must not be negative")
+	check(c.ReadFanout <= c.K, "ReadFanout", c.ReadFanout, "must not exceed K (%d)", c.K)
+	check(c.FlatTable >= 0, "FlatTable", c.FlatTable, "must not be negative")
+	check(c.FlatTable == 0 || c.FlatTable >= c.K, "FlatTable", c.FlatTable, "must be at least K (%d)", c.K)
+	check(c.Resources.Lookups >= 0, "Resources.Lookups", c.Resources.Lookups, "must not be negative")
+	check(c.Resources.Messages >= 0, "Resources.Messages", c.Resources.Messages, "must not be negative")
+	check(c.Resources.Goroutines >= 0, "Resources.Goroutines", c.Resources.Goroutines, "must not be negative")
+	check(c.Resources.Sockets >= 0, "Resources.Sockets", c.Resources.Sockets, "must not be negative")
+	check(c.Resources.StoreBytes >= 0, "Resources.StoreBytes", c.Resources.StoreBytes, "must not be negative")
+	if c.LegacyHasher != nil {
+		size := c.LegacyHasher.New().Size()
+		check(size == kbucket.IdSize, "LegacyHasher", c.LegacyHasher.Name(), "produces %d-byte digests, IDs are %d bytes", size, kbucket.IdSize)
+	}
+	check(c.MinRTO > 0, "MinRTO", c.MinRTO, "must be positive")
+	check(c.MaxRTO >= c.MinRTO, "MaxRTO", c.MaxRTO, "must be at least MinRTO (%v)", c.MinRTO)
+	check(c.JournalAcks >= 0, "JournalAcks", c.JournalAcks, "must not be negative")
+	check(c.JournalAcks <= c.K, "JournalAcks", c.JournalAcks, "must not exceed K (%d)", c.K)
+	check(c.Protocol >= ProtocolUDP && c.Protocol <= ProtocolWebSocket, "Protocol", c.Protocol, "unknown protocol")
+	check(c.ContactAcceptance >= AcceptResponders && c.ContactAcceptance <= AcceptVerified,
+		"ContactAcceptance", c.ContactAcceptance, "unknown acceptance mode")
+	check(c.ContactValidation >= ValidateOff && c.ContactValidation <= ValidateStrict,
+		"ContactValidation", c.ContactValidation, "unknown validation mode")
+	check(c.ConflictPolicy >= kbucket.ConflictReplace && c.ConflictPolicy <= kbucket.ConflictRejectBoth,
+		"ConflictPolicy", c.ConflictPolicy, "unknown policy")
+	return errors.Join(errs...)
+}
+
+// 超过 RefreshInterval 没有查找经过、需要刷新的 bucket
+func (p *Peer) BucketsToRefresh() []int {
+	return p.kb.StaleBuckets(p.cfg.RefreshInterval)
+}
+
+// 按 Kademlia 论文刷新陈旧的 bucket：对每个 bucket 查找一个落在其范围内的随机 ID，
+// 查找以低优先级发出。返回刷新的 bucket 数
+func (p *Peer) RefreshBuckets() int {
+	stale := p.BucketsToRefresh()
+	for _, pos := range stale {
+		p.lookup(p.kb.RefreshTarget(pos), p.newLookupBudget(maintenanceContext())) // 查找本身会更新 bucket 的 lastLookup
+		p.kb.RecordRefresh(pos)
+	}
+	return len(stale)
+}
@@ -0,0 +1,83 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 一个 key 在已保存的记录之外最多保留的并发记录数，更多的并发记录被丢弃
+const MaxConcurrentRecords = 4
+
+// Selector 无法在版本相同的记录之间选择时返回，收到的记录与已保存的记录一起保留
+var ErrConcurrentRecords = errors.New("dht: concurrent records with the same version")
+
+// 签名记录的版本：发布者与序号。同一个发布者的两个记录序号相同而内容不同时，
+// 说明发布者在两处并发地写入了记录（例如两台设备共用同一个密钥）
+type RecordVersion struct {
+	Publisher [kbucket.IdSize]byte
+	Seq       uint64
+}
+
+// 签名记录的版本，value 不是有效的签名记录时返回 false
+func VersionOf(value []byte) (RecordVersion, bool) {
+	pub, seq, _, err := OpenRecord(value)
+	if err != nil {
+		return RecordVersion{}, false
+	}
+	return RecordVersion{Publisher: NodeIDFromPublicKey(pub), Seq: seq}, true
+}
+
+// 与本地保存的 key 的记录版本相同、内容不同的其他记录。Get 把它们与已保存的记录
+// 一起交给 Selector，Selector 选出一个之后其余的被丢弃；选不出时返回 StatusConflict
+func (p *Peer) Conflicts(key [kbucket.IdSize]byte) [][]byte {
+	if !p.store.has(key) {
+		p.dropSiblings(key)
+		return nil
+	}
+	p.siblingMu.Lock()
+	defer p.siblingMu.Unlock()
+	return append([][]byte(nil), p.siblings[key]...)
+}
+
+// 在已保存的记录之外保留并发的 value，重复的记录与超出 MaxConcurrentRecords 的记录被忽略
+func (p *Peer) keepSibling(key [kbucket.IdSize]byte, value []byte) {
+	p.siblingMu.Lock()
+	defer p.siblingMu.Unlock()
+	for _, v := range p.siblings[key] {
+		if bytes.Equal(v, value) {
+			return
+		}
+	}
+	if len(p.siblings[key]) >= MaxConcurrentRecords {
+		return
+	}
+	if p.siblings == nil {
+		p.siblings = make(map[[kbucket.IdSize]byte][][]byte)
+	}
+	p.siblings[key] = append(p.siblings[key], append([]byte(nil), value...))
+}
+
+// 已保存的记录被替换、删除或过期时丢弃与它并发的记录
+func (p *Peer) dropSiblings(key [kbucket.IdSize]byte) {
+	p.siblingMu.Lock()
+	delete(p.siblings, key)
+	p.siblingMu.Unlock()
+}
+
+// Get 的 Selector 在并发的记录之间选出了 value：保存它并丢弃其余的记录
+func (p *Peer) settleConflict(key [kbucket.IdSize]byte, value []byte) {
+	rec, live, ok := p.store.record(key)
+	if !ok || !live {
+		return
+	}
+	if !bytes.Equal(rec.Value, value) {
+		if p.store.put(key, value, rec.Provenance) != nil {
+			return
+		}
+		p.emitStore(ValueStored, key)
+		p.notifyWatchers(key)
+	}
+	p.dropSiblings(key)
+}
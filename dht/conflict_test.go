@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 序号相同时选择数据较大的记录
+type tieBreakSelector struct{}
+
+func (tieBreakSelector) Select(key [kbucket.IdSize]byte, values [][]byte) (int, error) {
+	best := 0
+	for i, v := range values {
+		_, seq, data, err := OpenRecord(v)
+		if err != nil {
+			continue
+		}
+		_, bestSeq, bestData, _ := OpenRecord(values[best])
+		if seq > bestSeq || seq == bestSeq && bytes.Compare(data, bestData) > 0 {
+			best = i
+		}
+	}
+	return best, nil
+}
+
+// 同一序号的两个不同记录都被保留并作为冲突交给应用；更大的序号或应用的选择解决冲突
+func TestConcurrentRecordsKept(t *testing.T) {
+	p := NewPeer(KeyFromString("conflict-self"))
+	p.SetValidator(SignedValidator{})
+	p.SetSelector(SequenceSelector{})
+	_, priv, _ := ed25519.GenerateKey(nil)
+	key := NodeIDFromPublicKey(priv.Public().(ed25519.PublicKey))
+	a, b := SignRecord(priv, 5, []byte("a")), SignRecord(priv, 5, []byte("b"))
+	if v, ok := VersionOf(a); !ok || v.Publisher != key || v.Seq != 5 {
+		t.Fatalf("VersionOf = %+v, %v", v, ok)
+	}
+	p.acceptValue(key, a, Provenance{})
+	p.acceptValue(key, b, Provenance{})
+	if got, _ := p.store.get(key); !bytes.Equal(got, a) {
+		t.Fatal("concurrent record replaced the stored one")
+	}
+	if c := p.Conflicts(key); len(c) != 1 || !bytes.Equal(c[0], b) {
+		t.Fatalf("Conflicts = %d records, want the concurrent one", len(c))
+	}
+	r, err := p.Get(context.Background(), key)
+	if err != nil || r.Status != StatusConflict || len(r.Values) != 2 {
+		t.Fatalf("Get = %v with %d values, %v, want a conflict of 2", r.Status, len(r.Values), err)
+	}
+
+	p.SetSelector(tieBreakSelector{})
+	r, err = p.Get(context.Background(), key)
+	if err != nil || r.Status != StatusFound || !bytes.Equal(r.Value, b) {
+		t.Fatalf("Get with a tie-breaking Selector = %v, %v", r.Status, err)
+	}
+	if got, _ := p.store.get(key); !bytes.Equal(got, b) || len(p.Conflicts(key)) != 0 {
+		t.Fatal("settled conflict was not saved")
+	}
+
+	p.SetSelector(SequenceSelector{})
+	p.acceptValue(key, a, Provenance{})
+	newer := SignRecord(priv, 6, []byte("c"))
+	p.acceptValue(key, newer, Provenance{})
+	if got, _ := p.store.get(key); !bytes.Equal(got, newer) || len(p.Conflicts(key)) != 0 {
+		t.Fatal("a newer sequence number did not clear the conflict")
+	}
+}
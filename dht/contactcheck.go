@@ -0,0 +1,117 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 最多记住的其他节点的公钥数，超出时随机替换一个
+const peerKeyCacheSize = 4096
+
+// 如何检查 FIND_NODE 响应中的联系人与其公钥是否相符
+type ContactValidation int
+
+const (
+	// 不请求联系人的公钥，也不检查（默认）
+	ValidateOff ContactValidation = iota
+	// 请求联系人的公钥，拒绝 ID 不是由公钥导出的联系人；响应方不知道公钥的联系人照常接受，
+	// 适合仍有不签名的旧版本节点的网络
+	ValidatePermissive
+	// 同时拒绝没有公钥的联系人，只适合所有节点都使用密钥身份的网络
+	ValidateStrict
+)
+
+func (v ContactValidation) String() string {
+	switch v {
+	case ValidateOff:
+		return "off"
+	case ValidatePermissive:
+		return "permissive"
+	case ValidateStrict:
+		return "strict"
+	}
+	return fmt.Sprintf("ContactValidation(%d)", int(v))
+}
+
+// 解析 String 的输出
+func ParseContactValidation(s string) (ContactValidation, error) {
+	for v := ValidateOff; v <= ValidateStrict; v++ {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("dht: unknown contact validation %q", s)
+}
+
+// 记住带有效签名的消息中发送方的公钥，回复 FIND_NODE 时附带给请求方
+func (p *Peer) learnKey(id [kbucket.IdSize]byte, pub []byte) {
+	if len(pub) != ed25519.PublicKeySize || NodeIDFromPublicKey(pub) != id {
+		return
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	if _, ok := p.peerKeys[id]; ok {
+		return
+	}
+	if p.peerKeys == nil {
+		p.peerKeys = make(map[[kbucket.IdSize]byte]ed25519.PublicKey)
+	}
+	if len(p.peerKeys) >= peerKeyCacheSize {
+		for old := range p.peerKeys {
+			delete(p.peerKeys, old)
+			break
+		}
+	}
+	p.peerKeys[id] = append(ed25519.PublicKey(nil), pub...)
+}
+
+// 节点 id 的公钥，不知道时返回 nil
+func (p *Peer) peerKey(id [kbucket.IdSize]byte) ed25519.PublicKey {
+	if id == p.node.ID {
+		return p.PublicKey()
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	return p.peerKeys[id]
+}
+
+// 回复 FIND_NODE 时附带的联系人公钥：进程内的节点直接取它的公钥，其他节点使用
+// 从它的签名中得知的公钥
+func (p *Peer) contactKey(n kbucket.Node) ed25519.PublicKey {
+	if other, ok := n.Data.(*Peer); ok {
+		return other.PublicKey()
+	}
+	return p.peerKey(n.ID)
+}
+
+// 按 Config.ContactValidation 去掉 from 的响应中与公钥不符的联系人，
+// 被拒绝的联系人计入 from 的 PeerStats.InvalidContacts
+func (p *Peer) checkContacts(from [kbucket.IdSize]byte, contacts []Contact) []Contact {
+	mode := p.cfg.ContactValidation
+	if mode == ValidateOff {
+		return contacts
+	}
+	var kept []Contact
+	rejected := 0
+	for i, c := range contacts {
+		ok := c.PublicKey == nil && mode == ValidatePermissive ||
+			c.PublicKey != nil && NodeIDFromPublicKey(c.PublicKey) == c.ID
+		if !ok {
+			if rejected == 0 {
+				kept = append([]Contact(nil), contacts[:i]...)
+			}
+			rejected++
+		} else if rejected > 0 {
+			kept = append(kept, c)
+		}
+	}
+	if rejected == 0 {
+		return contacts
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	p.statsLocked(from).InvalidContacts += uint64(rejected)
+	return kept
+}
@@ -0,0 +1,140 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func newIdentityPeer(t *testing.T, cfg Config) *Peer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPeerWithIdentity(priv, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// 按 ContactValidation 拒绝恶意节点响应中的联系人：公钥与 ID 不符的 bogus 与没有公钥的
+// 旧版本节点 legacy，被拒绝的联系人计入 attacker 的统计并降低它的可靠性
+func TestContactValidation(t *testing.T) {
+	for _, tc := range []struct {
+		mode                  ContactValidation
+		wantLegacy, wantBogus bool
+		invalid               uint64
+	}{
+		{ValidateOff, true, true, 0},
+		{ValidatePermissive, true, false, 1},
+		{ValidateStrict, false, false, 2},
+	} {
+		t.Run(tc.mode.String(), func(t *testing.T) {
+			p, err := NewPeerWithConfig(KeyFromString("validate-self"), Config{ContactAcceptance: AcceptAll, ContactValidation: tc.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			attacker, legacy := NewPeer(KeyFromString("validate-attacker")), NewPeer(KeyFromString("validate-legacy"))
+			real := newIdentityPeer(t, Config{})
+			bogus := Contact{
+				ID:        KeyFromString("validate-bogus"),
+				Addr:      &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000},
+				PublicKey: real.PublicKey(),
+			}
+			// real 排在最后：它出现在路由表中时之前的联系人都已处理
+			attacker.Faults().ForgeContacts([]Contact{bogus, {ID: legacy.ID(), Peer: legacy}, {ID: real.ID(), Peer: real, PublicKey: real.PublicKey()}})
+			p.kb.InsertNode(kbucket.Node{ID: attacker.ID(), Data: attacker})
+			p.SetMaxLookupHops(1)
+			p.Lookup(context.Background(), KeyFromString("validate-target"))
+
+			deadline := time.Now().Add(time.Second)
+			for !knows(p, real) && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if !knows(p, real) {
+				t.Fatal("contact with a matching key was rejected")
+			}
+			if knows(p, legacy) != tc.wantLegacy {
+				t.Fatalf("contact without a key in the routing table = %v, want %v", !tc.wantLegacy, tc.wantLegacy)
+			}
+			if _, ok := p.kb.GetBucket(p.kb.BucketIndex(bogus.ID)).FindNode(bogus.ID); ok != tc.wantBogus {
+				t.Fatalf("contact with a mismatched key in the routing table = %v, want %v", ok, tc.wantBogus)
+			}
+			s, _ := p.PeerStats(attacker.ID())
+			if s.InvalidContacts != tc.invalid {
+				t.Fatalf("InvalidContacts = %d, want %d", s.InvalidContacts, tc.invalid)
+			}
+			if want := 1 / float64(1+tc.invalid); s.Reliability() != want {
+				t.Fatalf("Reliability = %v, want %v", s.Reliability(), want)
+			}
+		})
+	}
+}
+
+// 节点从带签名的请求中得知对方的公钥，在 UDP 与 gRPC 的 FIND_NODE 响应中附带给请求方
+func TestContactKeysOverNetwork(t *testing.T) {
+	ctx := context.Background()
+	check := func(t *testing.T, contacts []Contact, err error, want *Peer) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range contacts {
+			if c.ID == want.ID() {
+				if !bytes.Equal(c.PublicKey, want.PublicKey()) {
+					t.Fatalf("key of %x = %x, want %x", c.ID[:4], c.PublicKey, want.PublicKey())
+				}
+				return
+			}
+		}
+		t.Fatalf("response %d contacts without %x", len(contacts), want.ID())
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		a, b := newIdentityPeer(t, Config{}), newIdentityPeer(t, Config{})
+		c, err := NewPeerWithConfig(KeyFromString("keys-udp-c"), Config{ContactValidation: ValidateStrict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ts []*UDPTransport
+		for _, p := range []*Peer{a, b, c} {
+			tr, err := ListenUDP(p, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			ts = append(ts, tr)
+		}
+		to := Contact{ID: a.ID(), Addr: ts[0].Addr()}
+		if _, err := (udpMessenger{t: ts[1]}).Ping(ctx, to); err != nil {
+			t.Fatal(err)
+		}
+		contacts, err := udpMessenger{t: ts[2]}.FindNode(ctx, to, b.ID())
+		check(t, contacts, err, b)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		tlsConfig := selfSignedTLS(t)
+		a, b := newIdentityPeer(t, Config{}), newIdentityPeer(t, Config{})
+		c, err := NewPeerWithConfig(KeyFromString("keys-grpc-c"), Config{ContactValidation: ValidateStrict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, ca := listenGRPCPeer(t, a, tlsConfig)
+		tb, _ := listenGRPCPeer(t, b, tlsConfig)
+		tc, _ := listenGRPCPeer(t, c, tlsConfig)
+		if _, err := tb.Ping(ctx, ca); err != nil {
+			t.Fatal(err)
+		}
+		contacts, err := tc.FindNode(ctx, ca, b.ID())
+		check(t, contacts, err, b)
+	})
+}
@@ -0,0 +1,537 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// CRDT 值类型：节点收到并发的 STORE 时合并状态，而不是后写覆盖
+type CRDT interface {
+	Merge(other CRDT) bool // 将 other 合并进自身，返回状态是否发生变化
+	Clone() CRDT
+}
+
+type CRDTKind int
+
+var (
+	ErrCRDTKind    = errors.New("dht: CRDT kind not registered for namespace")
+	ErrCRDTCorrupt = errors.New("dht: malformed CRDT record")
+)
+
+const (
+	GCounterKind CRDTKind = iota
+	LWWRegisterKind
+	ORSetKind
+)
+
+func (k CRDTKind) matches(v CRDT) bool {
+	kind, ok := kindOf(v)
+	return ok && kind == k
+}
+
+func kindOf(v CRDT) (CRDTKind, bool) {
+	switch v.(type) {
+	case *GCounter:
+		return GCounterKind, true
+	case *LWWRegister:
+		return LWWRegisterKind, true
+	case *ORSet:
+		return ORSetKind, true
+	}
+	return 0, false
+}
+
+// 该类型的零值状态，用于解码
+func (k CRDTKind) empty() (encodedCRDT, bool) {
+	switch k {
+	case GCounterKind:
+		return NewGCounter(), true
+	case LWWRegisterKind:
+		return NewLWWRegister(), true
+	case ORSetKind:
+		return NewORSet(), true
+	}
+	return nil, false
+}
+
+// 可以编码成记录值的 CRDT 状态
+type encodedCRDT interface {
+	CRDT
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
+// 只增计数器，每个节点维护自己的分量
+type GCounter struct {
+	counts map[[kbucket.IdSize]byte]uint64
+}
+
+func NewGCounter() *GCounter {
+	return &GCounter{counts: make(map[[kbucket.IdSize]byte]uint64)}
+}
+
+func (c *GCounter) Increment(node [kbucket.IdSize]byte, delta uint64) {
+	c.counts[node] += delta
+}
+
+func (c *GCounter) Value() uint64 {
+	var sum uint64
+	for _, n := range c.counts {
+		sum += n
+	}
+	return sum
+}
+
+func (c *GCounter) Merge(other CRDT) bool {
+	o, ok := other.(*GCounter)
+	if !ok {
+		return false
+	}
+	changed := false
+	for node, n := range o.counts { // 每个分量取最大值
+		if n > c.counts[node] {
+			c.counts[node] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (c *GCounter) Clone() CRDT {
+	clone := NewGCounter()
+	for node, n := range c.counts {
+		clone.counts[node] = n
+	}
+	return clone
+}
+
+// 编码为分量数(4) 与按节点 ID 排序的 ID|计数(8)
+func (c *GCounter) MarshalBinary() ([]byte, error) {
+	nodes := make([][kbucket.IdSize]byte, 0, len(c.counts))
+	for node := range c.counts {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return bytes.Compare(nodes[i][:], nodes[j][:]) < 0 })
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(nodes)))
+	for _, node := range nodes {
+		buf.Write(node[:])
+		binary.Write(&buf, binary.BigEndian, c.counts[node])
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GCounter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || int64(n)*(kbucket.IdSize+8) != int64(r.Len()) {
+		return ErrCRDTCorrupt
+	}
+	c.counts = make(map[[kbucket.IdSize]byte]uint64, n)
+	for i := uint32(0); i < n; i++ {
+		var node [kbucket.IdSize]byte
+		var count uint64
+		io.ReadFull(r, node[:])
+		binary.Read(r, binary.BigEndian, &count)
+		c.counts[node] = count
+	}
+	return nil
+}
+
+// 最后写入者胜出的寄存器，时间戳相同时按写入者 ID 决定
+type LWWRegister struct {
+	value     []byte
+	timestamp int64
+	writer    [kbucket.IdSize]byte
+}
+
+func NewLWWRegister() *LWWRegister {
+	return &LWWRegister{}
+}
+
+func (r *LWWRegister) Set(value []byte, timestamp int64, writer [kbucket.IdSize]byte) {
+	r.Merge(&LWWRegister{value: value, timestamp: timestamp, writer: writer})
+}
+
+func (r *LWWRegister) Value() []byte {
+	return r.value
+}
+
+func (r *LWWRegister) Merge(other CRDT) bool {
+	o, ok := other.(*LWWRegister)
+	if !ok {
+		return false
+	}
+	if o.timestamp < r.timestamp ||
+		(o.timestamp == r.timestamp && bytes.Compare(o.writer[:], r.writer[:]) <= 0) {
+		return false
+	}
+	r.value, r.timestamp, r.writer = o.value, o.timestamp, o.writer
+	return true
+}
+
+func (r *LWWRegister) Clone() CRDT {
+	clone := *r
+	return &clone
+}
+
+// 编码为时间戳(8)|写入者 ID|值
+func (r *LWWRegister) MarshalBinary() ([]byte, error) {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(r.timestamp))
+	buf = append(buf, r.writer[:]...)
+	return append(buf, r.value...), nil
+}
+
+func (r *LWWRegister) UnmarshalBinary(data []byte) error {
+	if len(data) < 8+kbucket.IdSize {
+		return ErrCRDTCorrupt
+	}
+	r.timestamp = int64(binary.BigEndian.Uint64(data))
+	copy(r.writer[:], data[8:])
+	r.value = append([]byte(nil), data[8+kbucket.IdSize:]...)
+	return nil
+}
+
+// 观察删除集合：每次添加生成唯一标签，删除只作用于已观察到的标签
+type ORSet struct {
+	adds    map[string]map[uint64]bool
+	removed map[uint64]bool
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{
+		adds:    make(map[string]map[uint64]bool),
+		removed: make(map[uint64]bool),
+	}
+}
+
+func (s *ORSet) Add(elem string) {
+	if s.adds[elem] == nil {
+		s.adds[elem] = make(map[uint64]bool)
+	}
+	s.adds[elem][rand.Uint64()] = true
+}
+
+func (s *ORSet) Remove(elem string) {
+	for tag := range s.adds[elem] {
+		s.removed[tag] = true
+	}
+}
+
+func (s *ORSet) Contains(elem string) bool {
+	for tag := range s.adds[elem] {
+		if !s.removed[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ORSet) Elements() []string {
+	var elems []string
+	for elem := range s.adds {
+		if s.Contains(elem) {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+func (s *ORSet) Merge(other CRDT) bool {
+	o, ok := other.(*ORSet)
+	if !ok {
+		return false
+	}
+	changed := false
+	for elem, tags := range o.adds {
+		if s.adds[elem] == nil {
+			s.adds[elem] = make(map[uint64]bool)
+		}
+		for tag := range tags {
+			if !s.adds[elem][tag] {
+				s.adds[elem][tag] = true
+				changed = true
+			}
+		}
+	}
+	for tag := range o.removed {
+		if !s.removed[tag] {
+			s.removed[tag] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s *ORSet) Clone() CRDT {
+	clone := NewORSet()
+	clone.Merge(s)
+	return clone
+}
+
+// 编码为元素数(4)，按元素排序的 长度(2)|元素|标签数(4)|标签(8)...，
+// 再是删除的标签数(4) 与标签。标签从小到大排列
+func (s *ORSet) MarshalBinary() ([]byte, error) {
+	elems := make([]string, 0, len(s.adds))
+	for elem := range s.adds {
+		if len(elem) > 0xffff {
+			return nil, ErrCRDTCorrupt
+		}
+		elems = append(elems, elem)
+	}
+	sort.Strings(elems)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(len(elems)))
+	for _, elem := range elems {
+		binary.Write(&buf, binary.BigEndian, uint16(len(elem)))
+		buf.WriteString(elem)
+		writeTags(&buf, s.adds[elem])
+	}
+	writeTags(&buf, s.removed)
+	return buf.Bytes(), nil
+}
+
+func writeTags(buf *bytes.Buffer, tags map[uint64]bool) {
+	sorted := make([]uint64, 0, len(tags))
+	for tag := range tags {
+		sorted = append(sorted, tag)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	binary.Write(buf, binary.BigEndian, uint32(len(sorted)))
+	for _, tag := range sorted {
+		binary.Write(buf, binary.BigEndian, tag)
+	}
+}
+
+func (s *ORSet) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || int64(n)*(2+4) > int64(r.Len()) {
+		return ErrCRDTCorrupt
+	}
+	adds := make(map[string]map[uint64]bool, n)
+	for i := uint32(0); i < n; i++ {
+		var size uint16
+		if binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+			return ErrCRDTCorrupt
+		}
+		elem := make([]byte, size)
+		io.ReadFull(r, elem)
+		tags, err := readTags(r)
+		if err != nil {
+			return err
+		}
+		adds[string(elem)] = tags
+	}
+	removed, err := readTags(r)
+	if err != nil || r.Len() != 0 {
+		return ErrCRDTCorrupt
+	}
+	s.adds, s.removed = adds, removed
+	return nil
+}
+
+func readTags(r *bytes.Reader) (map[uint64]bool, error) {
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil || int64(n)*8 > int64(r.Len()) {
+		return nil, ErrCRDTCorrupt
+	}
+	tags := make(map[uint64]bool, n)
+	for i := uint32(0); i < n; i++ {
+		var tag uint64
+		binary.Read(r, binary.BigEndian, &tag)
+		tags[tag] = true
+	}
+	return tags, nil
+}
+
+// 指定命名空间使用的 CRDT 类型，未登记的命名空间不接受合并。
+// 登记后该命名空间的记录按 CRDT 校验，STORE 收到的状态与本地的记录合并后保存
+func (p *Peer) RegisterCRDTNamespace(namespace string, kind CRDTKind) {
+	p.crdtMu.Lock()
+	p.crdtKinds[namespace] = kind
+	p.crdtMu.Unlock()
+}
+
+func crdtKey(namespace, name string) [kbucket.IdSize]byte {
+	return KeyFromString(namespace + "/" + name)
+}
+
+// CRDT 记录的值：namespace/name、0、类型(1) 与状态。以命名空间开头，
+// 与 Namespace 的约定一致，接收方据此找到登记的类型
+func encodeCRDT(namespace, name string, v CRDT) ([]byte, error) {
+	kind, ok := kindOf(v)
+	if !ok || namespace == "" || strings.ContainsRune(namespace, '/') || strings.ContainsRune(name, 0) {
+		return nil, ErrCRDTKind
+	}
+	state, err := v.(encodedCRDT).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	value := append([]byte(namespace+"/"+name), 0, byte(kind))
+	return append(value, state...), nil
+}
+
+func decodeCRDT(value []byte) (namespace, name string, v encodedCRDT, err error) {
+	i := bytes.IndexByte(value, 0)
+	if i < 0 || i+1 >= len(value) {
+		return "", "", nil, ErrCRDTCorrupt
+	}
+	namespace, name, ok := strings.Cut(string(value[:i]), "/")
+	if !ok {
+		return "", "", nil, ErrCRDTCorrupt
+	}
+	v, ok = CRDTKind(value[i+1]).empty()
+	if !ok {
+		return "", "", nil, ErrCRDTCorrupt
+	}
+	if err := v.UnmarshalBinary(value[i+2:]); err != nil {
+		return "", "", nil, err
+	}
+	return namespace, name, v, nil
+}
+
+// value 属于登记过的 CRDT 命名空间时返回登记的类型
+func (p *Peer) crdtKindOf(value []byte) (CRDTKind, bool) {
+	namespace := Namespace(value)
+	if namespace == "" {
+		return 0, false
+	}
+	p.crdtMu.Lock()
+	defer p.crdtMu.Unlock()
+	kind, ok := p.crdtKinds[namespace]
+	return kind, ok
+}
+
+// CRDT 记录的检查：能够解码、类型与登记的一致，且 key 由 namespace/name 得出
+func validateCRDT(kind CRDTKind, key [kbucket.IdSize]byte, value []byte) error {
+	namespace, name, v, err := decodeCRDT(value)
+	if err != nil {
+		return err
+	}
+	if !kind.matches(v) {
+		return ErrCRDTKind
+	}
+	if crdtKey(namespace, name) != key {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// 把 value 中的状态合并进已有的记录 old，返回合并后的值以及状态是否变化。
+// old 无法解码时由 value 替换
+func mergeCRDTValues(old, value []byte) ([]byte, bool) {
+	namespace, name, cur, err := decodeCRDT(old)
+	if err != nil {
+		return value, true
+	}
+	_, _, v, err := decodeCRDT(value)
+	if err != nil || !cur.Merge(v) {
+		return old, false
+	}
+	merged, err := encodeCRDT(namespace, name, cur)
+	if err != nil {
+		return old, false
+	}
+	return merged, true
+}
+
+// saveValue 中 CRDT 记录的部分：与本地的记录合并后保存，状态没有变化时只延长有效期。
+// 读取、合并与写入由 crdtWrite 串行化，并发的 STORE 不会丢失彼此的更新
+func (p *Peer) saveCRDT(hash [kbucket.IdSize]byte, value []byte, origin Provenance, typ StoreEventType) bool {
+	p.crdtWrite.Lock()
+	if old, ok := p.store.get(hash); ok {
+		merged, changed := mergeCRDTValues(old, value)
+		if !changed {
+			p.crdtWrite.Unlock()
+			return p.store.refresh(hash)
+		}
+		err := p.store.put(hash, merged, origin)
+		p.crdtWrite.Unlock()
+		if err != nil {
+			return false
+		}
+	} else {
+		saved := !p.storeFullFor(value) && p.store.putIfAbsent(hash, value, origin)
+		p.crdtWrite.Unlock()
+		if !saved {
+			return false
+		}
+	}
+	p.forgetMiss(hash)
+	p.emitStore(typ, hash)
+	p.notifyWatchers(hash)
+	return true
+}
+
+// 将 v 合并到 namespace/name 对应的记录：先合并进本地的记录，再像 SetValue 一样
+// STORE 给距离 key 最近的节点，由各节点在保存时合并进自己的状态。
+// 传播的是 v 编码后的副本，调用返回后应用可以继续修改 v。namespace 未登记或类型不符时
+// 返回 ErrCRDTKind；ctx 结束时返回 ctx.Err()，已经放置的副本保留合并结果
+func (p *Peer) MergeCRDT(ctx context.Context, namespace, name string, v CRDT) error {
+	p.crdtMu.Lock()
+	kind, ok := p.crdtKinds[namespace]
+	p.crdtMu.Unlock()
+	if !ok || v == nil || !kind.matches(v) {
+		return ErrCRDTKind
+	}
+	value, err := encodeCRDT(namespace, name, v)
+	if err != nil {
+		return err
+	}
+	key := crdtKey(namespace, name)
+	_, err = p.setValue(ctx, key[:], value, NewTraceID())
+	return err
+}
+
+// 读取 namespace/name 的状态：询问距离 key 最近的节点，合并本地与各副本返回的状态，
+// 没有记录时返回 nil。namespace 未登记时返回 ErrCRDTKind
+func (p *Peer) GetCRDT(ctx context.Context, namespace, name string) (CRDT, error) {
+	p.crdtMu.Lock()
+	_, ok := p.crdtKinds[namespace]
+	p.crdtMu.Unlock()
+	if !ok {
+		return nil, ErrCRDTKind
+	}
+	r, err := p.Get(ctx, crdtKey(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+	var merged CRDT
+	for _, value := range r.Values {
+		_, _, v, err := decodeCRDT(value)
+		if err != nil {
+			continue
+		}
+		if merged == nil {
+			merged = v
+		} else {
+			merged.Merge(v)
+		}
+	}
+	return merged, nil
+}
+
+// 本地 key 的 CRDT 状态，记录不属于登记的 CRDT 命名空间时返回 false
+func (p *Peer) crdtState(key [kbucket.IdSize]byte) (CRDT, bool) {
+	value, ok := p.store.get(key)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := p.crdtKindOf(value); !ok {
+		return nil, false
+	}
+	_, _, v, err := decodeCRDT(value)
+	if err != nil {
+		return nil, false
+	}
+	return v, true
+}
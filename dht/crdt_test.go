@@ -0,0 +1,162 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func encodeTestCRDT(t *testing.T, v CRDT) []byte {
+	t.Helper()
+	value, err := encodeCRDT("test", "v", v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// 两个副本按不同顺序合并后得到相同的编码，编码后再解码不改变状态
+func TestCRDTMergeCommutes(t *testing.T) {
+	a, b := KeyFromString("crdt-a"), KeyFromString("crdt-b")
+	counters := [2]*GCounter{NewGCounter(), NewGCounter()}
+	counters[0].Increment(a, 3)
+	counters[1].Increment(a, 1)
+	counters[1].Increment(b, 5)
+	registers := [2]*LWWRegister{NewLWWRegister(), NewLWWRegister()}
+	registers[0].Set([]byte("first"), 10, a)
+	registers[1].Set([]byte("second"), 10, b)
+	sets := [2]*ORSet{NewORSet(), NewORSet()}
+	sets[0].Add("x")
+	sets[0].Add("y")
+	sets[0].Remove("y")
+	sets[1].Add("y")
+	sets[1].Add("z")
+
+	for _, pair := range [][2]CRDT{{counters[0], counters[1]}, {registers[0], registers[1]}, {sets[0], sets[1]}} {
+		ab, ba := pair[0].Clone(), pair[1].Clone()
+		ab.Merge(pair[1])
+		ba.Merge(pair[0])
+		if x, y := encodeTestCRDT(t, ab), encodeTestCRDT(t, ba); !bytes.Equal(x, y) {
+			t.Fatalf("%T: merge order changes the state", pair[0])
+		}
+		if ab.Merge(pair[0]) || ab.Merge(pair[1]) {
+			t.Fatalf("%T: merging a state twice changed it again", pair[0])
+		}
+		value := encodeTestCRDT(t, ab)
+		_, _, decoded, err := decodeCRDT(value)
+		if err != nil || !bytes.Equal(encodeTestCRDT(t, decoded), value) {
+			t.Fatalf("%T: decode = %v, state not preserved", pair[0], err)
+		}
+	}
+	if _, _, _, err := decodeCRDT(encodeTestCRDT(t, counters[0])[:12]); err != ErrCRDTCorrupt {
+		t.Fatalf("decoding a truncated counter = %v, want ErrCRDTCorrupt", err)
+	}
+}
+
+// 一个副本删除元素的同时另一个副本再次添加它，合并后元素仍然存在（添加胜出），
+// 只有删除方观察到的添加被删除
+func TestORSetRemoveThenConcurrentAdd(t *testing.T) {
+	a := NewORSet()
+	a.Add("x")
+	b := a.Clone().(*ORSet)
+	a.Remove("x")
+	b.Add("x")
+
+	ab, ba := a.Clone().(*ORSet), b.Clone().(*ORSet)
+	ab.Merge(b)
+	ba.Merge(a)
+	if !ab.Contains("x") || !ba.Contains("x") {
+		t.Fatal("concurrent add lost to a remove that never observed it")
+	}
+	if !bytes.Equal(encodeTestCRDT(t, ab), encodeTestCRDT(t, ba)) {
+		t.Fatal("replicas diverge after merging in both orders")
+	}
+	ab.Remove("x") // 观察到两次添加之后的删除对合并后的状态生效
+	ba.Merge(ab)
+	if ba.Contains("x") {
+		t.Fatal("remove after observing every add did not take effect")
+	}
+}
+
+// STORE 收到的 CRDT 状态与本地记录合并；未登记命名空间或类型不符的节点拒绝它
+func TestCRDTStoreMerges(t *testing.T) {
+	p := NewPeer(KeyFromString("crdt-store"))
+	p.RegisterCRDTNamespace("counters", GCounterKind)
+	a, b := KeyFromString("crdt-a"), KeyFromString("crdt-b")
+	key := crdtKey("counters", "hits")
+	for _, node := range [][kbucket.IdSize]byte{a, b, a} {
+		c := NewGCounter()
+		c.Increment(node, 2)
+		value, err := encodeCRDT("counters", "hits", c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code, _ := p.offerStore(key, value, NewTraceID(), Provenance{}); code != CodeOK {
+			t.Fatalf("STORE of a counter = %v, want OK", code)
+		}
+	}
+	v, ok := p.crdtState(key)
+	if c, isCounter := v.(*GCounter); !ok || !isCounter || c.Value() != 4 {
+		t.Fatalf("merged state = %v, want a counter of 4", v)
+	}
+
+	value, _ := p.store.get(key)
+	if code, _ := p.offerStore(crdtKey("counters", "other"), value, NewTraceID(), Provenance{}); code != CodeBadToken {
+		t.Fatalf("STORE under another name's key = %v, want BAD_TOKEN", code)
+	}
+	r := NewPeer(KeyFromString("crdt-register"))
+	r.RegisterCRDTNamespace("counters", LWWRegisterKind)
+	if code, _ := r.offerStore(key, value, NewTraceID(), Provenance{}); code != CodeBadToken {
+		t.Fatalf("STORE of a counter into a register namespace = %v, want BAD_TOKEN", code)
+	}
+	unregistered := NewPeer(KeyFromString("crdt-unregistered"))
+	if code, _ := unregistered.offerStore(key, value, NewTraceID(), Provenance{}); code != CodeBadToken {
+		t.Fatalf("STORE into an unregistered namespace = %v, want BAD_TOKEN", code)
+	}
+	if err := unregistered.MergeCRDT(context.Background(), "counters", "hits", NewGCounter()); err != ErrCRDTKind {
+		t.Fatalf("MergeCRDT in an unregistered namespace = %v, want ErrCRDTKind", err)
+	}
+}
+
+// MergeCRDT 通过 UDP 把状态 STORE 给负责的节点，GetCRDT 合并本地与对方的状态
+func TestCRDTOverUDP(t *testing.T) {
+	p := NewPeer(KeyFromString("crdt-udp-self"))
+	q := NewPeer(KeyFromString("crdt-udp-remote"))
+	for _, peer := range []*Peer{p, q} {
+		peer.RegisterCRDTNamespace("counters", GCounterKind)
+	}
+	tp, err := ListenUDP(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	tq, err := ListenUDP(q, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tq.Close()
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: tq.Addr()})
+
+	ctx := context.Background()
+	remote := NewGCounter()
+	remote.Increment(q.node.ID, 2)
+	if err := q.MergeCRDT(ctx, "counters", "hits", remote); err != nil {
+		t.Fatal(err)
+	}
+	local := NewGCounter()
+	local.Increment(p.node.ID, 3)
+	if err := p.MergeCRDT(ctx, "counters", "hits", local); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := q.crdtState(crdtKey("counters", "hits"))
+	if c, isCounter := v.(*GCounter); !ok || !isCounter || c.Value() != 5 {
+		t.Fatalf("remote state = %v, want the merged counter of 5", v)
+	}
+	p.store.remove(crdtKey("counters", "hits"))
+	v, err = p.GetCRDT(ctx, "counters", "hits")
+	if c, isCounter := v.(*GCounter); err != nil || !isCounter || c.Value() != 5 {
+		t.Fatalf("GetCRDT = %v, %v, want the counter of 5 held by the remote peer", v, err)
+	}
+}
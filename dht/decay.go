@@ -0,0 +1,46 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// CacheDecay 最多把有效期减半的次数
+const maxCacheDecay = 6
+
+// 本节点保存 key 的记录时的有效期。设置了 CacheDecay 时按 Kademlia 论文的缓存规则
+// 随距离指数缩短：路由表中比本节点更接近 key 的节点不足 K 个时本节点是副本节点，
+// 使用 base；之外每多 K 个更近的节点有效期减半，至多减半 maxCacheDecay 次。
+// 本节点发布的 key 总是使用 base
+func (p *Peer) decayedTTL(key [kbucket.IdSize]byte, base time.Duration) time.Duration {
+	if !p.cfg.CacheDecay || base <= 0 || p.owned.has(key) {
+		return base
+	}
+	return base >> p.cacheDecay(key)
+}
+
+// 有效期减半的次数，0 表示本节点是 key 的副本节点
+func (p *Peer) cacheDecay(key [kbucket.IdSize]byte) int {
+	closer := 0
+	for _, n := range p.kb.FindClosestNodes(key, p.cfg.K*(maxCacheDecay+1)) {
+		if p.closer(n.ID, p.node.ID, key) {
+			closer++
+		}
+	}
+	return min(closer/p.cfg.K, maxCacheDecay)
+}
+
+// 保存 value 时的有效期，见 decayedTTL
+func (p *Peer) storedTTL(key [kbucket.IdSize]byte, value []byte) time.Duration {
+	return p.decayedTTL(key, p.recordTTL(value))
+}
+
+// 记录的重新发布周期。设置了 CacheDecay 时远离 key 的缓存副本不重新发布，任由它按
+// 缩短的有效期过期；副本节点与发布者照常重新发布
+func (p *Peer) republishDue(key [kbucket.IdSize]byte, value []byte) time.Duration {
+	if p.cfg.CacheDecay && !p.owned.has(key) && p.cacheDecay(key) > 0 {
+		return 0
+	}
+	return p.republishInterval(value)
+}
@@ -0,0 +1,118 @@
+package dht
+
+import "github.com/WuQingyang2/K_Bucket/kbucket"
+
+const maxDelegateHops = 3 // 最多跟随的转交提示次数
+
+// 设置本地存储容量，0 表示不限制
+func (p *Peer) SetStoreCapacity(n int) {
+	p.capacity = n
+}
+
+func (p *Peer) storeFull() bool {
+	return p.capacity > 0 && p.store.len() >= p.capacity
+}
+
+// 是否放不下一条新记录：记录数已满或值会超出 Resources.StoreBytes
+func (p *Peer) storeFullFor(value []byte) bool {
+	return p.storeFull() || p.storeOverBudget(len(value))
+}
+
+// 只接受与自身 XOR 距离小于 2^bits 的 key 的 STORE，0 表示不限制。
+// 小节点可以借此拒绝保存不归自己负责的数据
+func (p *Peer) SetStoreRadius(bits int) {
+	p.storeRadius = bits
+}
+
+// 按实际的 XOR 距离判断，不用 BucketIndex：尚未分裂的路由表把近处的 key 都归入 home bucket
+func (p *Peer) tooFar(hash [kbucket.IdSize]byte) bool {
+	return p.storeRadius > 0 && hash != p.node.ID && p.kb.DistanceIndex(hash) >= p.storeRadius
+}
+
+// 处理一次 STORE 请求。存储已满时返回 CodeBusy，key 超出存储半径时返回
+// CodeTooFar，两种情况都附带更适合保存该 key 的进程内节点。转交提示不随网络响应发送，
+// 网络中的请求方只收到错误码
+func (p *Peer) offerStore(hash [kbucket.IdSize]byte, value []byte, trace TraceID, origin Provenance) (ErrorCode, []*Peer) {
+	if err := p.faults.storeError(); err != nil {
+		return CodeOf(err), nil
+	}
+	if p.storeTooBig(value) {
+		return CodeTooBig, nil
+	}
+	if err := p.validate(hash, value); err != nil {
+		if code := CodeOf(err); code == CodeTooBig {
+			return code, nil
+		}
+		return CodeBadToken, nil
+	}
+	if p.keepExisting(hash, value) { // 重复的 STORE（例如重新发布）只延长有效期
+		return CodeOK, nil
+	}
+	if p.tooFar(hash) {
+		return CodeTooFar, p.closerPeers(hash)
+	}
+	if p.storeFullFor(value) && !p.store.has(hash) { // 替换已有的记录不占用新的容量
+		return CodeBusy, p.routeTargets(hash)
+	}
+	if q := p.queue(); q != nil { // 由后台写入，队列与临时文件都写不进时才拒绝
+		err := q.push(queuedStore{key: hash, value: value, origin: origin})
+		if err == nil {
+			return CodeOK, nil
+		}
+		if err != errStoreQueueClosed {
+			return CodeBusy, p.routeTargets(hash)
+		}
+	}
+	if !p.acceptValue(hash, value, origin) {
+		return CodeBusy, p.routeTargets(hash)
+	}
+	return CodeOK, nil
+}
+
+// 路由表中比自身更接近 hash 的进程内节点
+func (p *Peer) closerPeers(hash [kbucket.IdSize]byte) []*Peer {
+	var peers []*Peer
+	for _, node := range p.kb.FindClosestNodes(hash, p.cfg.K) {
+		if peer, ok := node.Data.(*Peer); ok && p.closer(node.ID, p.node.ID, hash) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// 向 peer 发送 STORE；对方已满或距离过远时按照其给出的转交提示继续尝试
+func (p *Peer) storeAt(peer *Peer, hash [kbucket.IdSize]byte, value []byte, trace TraceID) error {
+	visited := map[[kbucket.IdSize]byte]bool{p.node.ID: true}
+	candidates := []*Peer{peer}
+	err := ErrTimeout // 最后一个拒绝的原因
+	origin := p.ownOrigin()
+	for hops := 0; hops <= maxDelegateHops && len(candidates) > 0; hops++ {
+		var next []*Peer
+		for _, c := range candidates {
+			if visited[c.node.ID] {
+				continue
+			}
+			visited[c.node.ID] = true
+			if p.faults.timedOut(c.node.ID) {
+				continue
+			}
+			if c != peer { // 第一跳已由 lookupHop 通知
+				c.onRequest(trace, OpStore, p.node.ID, hash)
+			}
+			origin.Hops = hops
+			code, delegates := c.offerStore(hash, value, trace, origin)
+			if code == CodeOK {
+				return nil
+			}
+			err = &RPCError{Code: code}
+			if code == CodeBusy {
+				retry := c.busyRetryAfter()
+				p.throttle(c.node.ID, retry)
+				err = &RPCError{Code: code, RetryAfter: retry}
+			}
+			next = append(next, delegates...)
+		}
+		candidates = next
+	}
+	return err
+}
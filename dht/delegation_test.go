@@ -0,0 +1,32 @@
+package dht
+
+import (
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 存储半径按实际距离判断：路由表尚未分裂时，距离为 1 的 key 也不会被当作太远
+func TestStoreRadiusNearKey(t *testing.T) {
+	p := NewPeer(KeyFromString("radius-self"))
+	p.SetStoreRadius(8)
+	near := p.node.ID
+	near[kbucket.IdSize-1] ^= 1
+	if p.tooFar(near) {
+		t.Fatalf("key at distance 1 rejected, home bucket %d", p.kb.HomeBucket())
+	}
+	far := p.node.ID
+	far[0] ^= 0x80
+	if !p.tooFar(far) {
+		t.Fatal("key differing in the top bit accepted with radius 8")
+	}
+	edge := p.node.ID
+	edge[kbucket.IdSize-1] ^= 0x80 // 距离 2^7，仍在半径之内
+	if p.tooFar(edge) {
+		t.Fatal("key at distance 2^7 rejected with radius 8")
+	}
+	edge[kbucket.IdSize-2] ^= 1 // 距离的最高位为第 8 位
+	if !p.tooFar(edge) {
+		t.Fatal("key at distance 2^8 accepted with radius 8")
+	}
+}
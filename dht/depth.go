@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 单次查找默认最多联系的节点数。查找至少要查询最近的 K 个节点才结束，在模拟的
+// 8000 个节点的网络中，K=20、α=3 的查找平均联系 26 个、最多 38 个节点，
+// 64 留出了大约一倍的余量；K 更大时默认值按 3K 放宽，见 maxLookupHops
+const DefaultMaxLookupHops = 64
+
+var ErrLookupDepthExceeded = errors.New("dht: lookup depth exceeded")
+
+// 一次查找的跳数预算，在递归经过的所有节点之间共享，
+// 防止异常的路由状态或恶意构造的联系人链让一次查找无限进行
+type lookupBudget struct {
+	ctx       context.Context // 取消或超时时中断查找
+	left      int
+	exceeded  bool
+	err       error   // 查找中断的原因：ctx.Err() 或 ErrLookupDepthExceeded
+	converged bool    // 最近一次查找问完了最近的 K 个节点且有节点回复，见 missConfirmed
+	trace     TraceID // 本次查找的追踪 ID
+	value     []byte  // 正在发布的值，用于按命名空间选择首选节点；读取时为 nil
+
+	progress func(closest []kbucket.Node, hops int) // 每轮结束后的进度回调，nil 表示不通知
+	settled  func(nodes []kbucket.Node)             // 每轮结束后已经确定的最近节点，见 settledPrefix
+}
+
+func (p *Peer) newLookupBudget(ctx context.Context) *lookupBudget {
+	return &lookupBudget{ctx: ctx, left: p.maxLookupHops(), trace: NewTraceID()}
+}
+
+// 消耗一跳，预算用尽或 ctx 结束时返回 false
+func (b *lookupBudget) spend() bool {
+	if err := b.ctx.Err(); err != nil {
+		b.err = err
+		return false
+	}
+	if b.left <= 0 {
+		b.exceeded = true
+		b.err = ErrLookupDepthExceeded
+		return false
+	}
+	b.left--
+	return true
+}
+
+// 查找确认了 key 不存在：查找收敛且没有被 ctx 或跳数预算中断。
+// 只有这时才能做否定缓存，部分结果不能说明 key 不存在
+func (b *lookupBudget) missConfirmed() bool {
+	return b.converged && b.err == nil && b.ctx.Err() == nil
+}
+
+// 设置单次查找最多联系的节点数，n <= 0 恢复默认值。可以在查找进行时调用，
+// 只影响之后开始的查找
+func (p *Peer) SetMaxLookupHops(n int) {
+	atomic.StoreInt64(&p.maxHops, int64(n))
+}
+
+func (p *Peer) maxLookupHops() int {
+	if n := atomic.LoadInt64(&p.maxHops); n > 0 {
+		return int(n)
+	}
+	return max(DefaultMaxLookupHops, 3*p.cfg.K)
+}
+
+// 因超出跳数限制而中断的查找次数
+func (p *Peer) LookupDepthExceeded() uint64 {
+	return atomic.LoadUint64(&p.depthExceeded)
+}
@@ -0,0 +1,423 @@
+// Package dht 在 kbucket 路由表之上实现节点（Peer）、键值存储与查找
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+var (
+	ErrEmptyKey    = errors.New("dht: empty key or value")
+	ErrKeyMismatch = errors.New("dht: key does not match value")
+	ErrNotFound    = errors.New("dht: value not found")
+
+	// 负责 key 的节点都只能通过网络联系，而操作只在进程内的节点之间工作，见 routeTargets
+	ErrRemoteReplicas = errors.New("dht: replicas are only reachable over the network")
+)
+
+// 添加DHT结构体
+type DHT struct {
+	kb *kbucket.KBucket
+}
+
+// 添加Peer结构体
+type Peer struct {
+	node  kbucket.Node
+	kb    *kbucket.KBucket
+	store *recordStore //保存键值对
+	dht   DHT
+	cfg   Config
+
+	static  bool    // 是否处于静态成员模式
+	members []*Peer // 静态模式下的固定成员
+
+	multiMu   sync.RWMutex                          // 保护 multi
+	multi     map[[kbucket.IdSize]byte][]multiEntry // 一个 key 对应多个值的记录
+	providers providerStore                         // provider 记录以及本节点提供的 key
+
+	siblingMu   sync.Mutex
+	siblings    map[[kbucket.IdSize]byte][][]byte // 与已保存的记录并发写入的记录，见 Conflicts
+	negMu       sync.Mutex
+	negCache    map[[kbucket.IdSize]byte]negEntry // 最近确认不存在的 key
+	stats       keyStats                          // 每个 key 的 GET/STORE 访问统计
+	capacity    int                               // 本地最多保存的记录数，0 表示不限制
+	storeRadius int                               // 接受 STORE 的最大距离（比特数），0 表示不限制
+	retryAfter  time.Duration                     // 回复 BUSY 时建议的重试等待时间
+	backoff     backoffList                       // 回复过 BUSY 的节点
+
+	findMu    sync.Mutex
+	findCache map[[kbucket.IdSize]byte]*findEntry // 热门 FIND_NODE 目标的响应，见 Config.FindNodeCacheTTL
+
+	maxHops       int64  // 单次查找最多联系的节点数，0 表示使用默认值；原子访问
+	depthExceeded uint64 // 因超出跳数限制而中断的查找次数
+	lookups       uint64 // 完成的迭代查找次数
+	hedged        uint64 // 迭代查找中对冲发出的查询数，见 Config.HedgeLookups
+
+	crdtMu    sync.Mutex          // 保护 crdtKinds
+	crdtKinds map[string]CRDTKind // 命名空间对应的 CRDT 类型，这些命名空间的记录以 CRDT 语义合并
+	crdtWrite sync.Mutex          // 串行化 CRDT 记录的读取、合并与写入
+
+	journal    *Journal    // 尚未完成复制的 STORE 日志
+	storeQueue *storeQueue // 收到的 STORE 的写入队列，nil 表示直接写入，由 storeMu 保护
+
+	storeMu   sync.RWMutex      // 保护 storeQueue 与 storeSubs
+	storeSubs []chan StoreEvent // 存储事件的订阅者
+
+	metered atomic.Bool // 连接是否按流量计费，见 SetMetered
+
+	hooks *Hooks                      // 仿真统计回调，nil 表示不使用
+	trace atomic.Pointer[LookupTrace] // 正在记录的查找路径，nil 表示不记录
+	maint maintenance
+
+	watched   map[[kbucket.IdSize]byte]*watchedPeer // 应用关注的节点
+	reachSubs []chan ReachabilityEvent              // 可达性变化的订阅者
+	addrSubs  []chan AddressChanged                 // 节点地址变化的订阅者
+
+	watchMu    sync.Mutex                         // 保护 watchers 与 keyWatches
+	watchers   map[[kbucket.IdSize]byte][]*Peer   // 关注本地记录变化的节点
+	keyWatches map[[kbucket.IdSize]byte]*keyWatch // 本节点关注的 key
+
+	respRange ResponsibilityRange        // 最近一次通知的负责区域
+	respSubs  []chan ResponsibilityEvent // 负责区域变化的订阅者
+
+	stateMu     sync.Mutex
+	state       PeerState          // 生命周期状态，由 stateMu 保护
+	stateSubsMu sync.Mutex         // 保护 stateSubs，通知与关闭订阅时持有
+	stateSubs   []chan StateChange // 状态变化的订阅者
+
+	partMu sync.Mutex
+	part   partitionState // 与网络的连通状态与自动重新加入
+
+	health  healthState // 路由健康分
+	metrics Metrics     // 指标回调，nil 表示不收集
+	faults  Faults      // 测试中注入的故障
+
+	refreshStop chan struct{} // 关闭以停止后台刷新，nil 表示未启动
+	refreshDone chan struct{} // 后台刷新退出后关闭
+
+	alpha       adaptiveAlpha // 查找并发度随超时比例调整，见 LookupWidth
+	lowSends    chan struct{} // 低优先级 RPC 的发送名额，nil 表示不限制
+	peerStatsMu sync.Mutex
+	peerStats   map[[kbucket.IdSize]byte]*PeerStats        // 其他节点的长期统计
+	peerKeys    map[[kbucket.IdSize]byte]ed25519.PublicKey // 从带签名的消息中得知的公钥
+	infos       peerInfos                                  // 其他节点在握手中声明的信息
+
+	transport *UDPTransport // 网络传输层，nil 表示只在进程内通信
+	messenger Messenger     // 联系网络中节点的 RPC，nil 表示使用 transport
+
+	identity ed25519.PrivateKey // 节点的密钥身份，nil 表示消息不签名
+
+	pins   pinList      // 应用固定的首选节点
+	owned  ownedKeys    // 本节点发布的 key 及其副本，见 DriftReport
+	jitter jitterSource // 周期性任务的随机抖动
+
+	bans    banList         // 管理员设置的封禁
+	limiter rateLimiter     // 网络请求的配额与临时封禁
+	reads   readStats       // ReadFanout 的副本比较统计
+	fresh   prober          // 按 FreshnessThreshold 主动 ping 联系人
+	res     resourceManager // Config.Resources 限制的资源的占用
+	aliases keyAliases      // MigrateKeys 记下的新旧 key
+
+	validator Validator // 检查记录，nil 表示 ContentValidator
+	selector  Selector  // 在冲突的记录之间选择，nil 表示保留先收到的记录
+
+	policyMu sync.RWMutex
+	policies map[string]NamespacePolicy // 按命名空间覆盖的复制与有效期策略
+}
+
+// 使用默认参数创建节点
+func NewPeer(id [kbucket.IdSize]byte) *Peer {
+	p, _ := NewPeerWithConfig(id, DefaultConfig())
+	return p
+}
+
+// 使用 cfg 创建节点，cfg 中的零值字段使用默认值
+func NewPeerWithConfig(id [kbucket.IdSize]byte, cfg Config) (*Peer, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	kb := kbucket.NewKBucket(id, cfg.K)
+	if cfg.FlatTable > 0 {
+		kb = kbucket.NewFlatKBucket(id, cfg.K, cfg.FlatTable)
+	}
+	kb.SetDistanceMetric(cfg.Metric)
+	mem := NewMemoryStorage(cfg.MaxRecords, cfg.MaxRecordBytes)
+	p := &Peer{
+		node:  kbucket.Node{ID: id},
+		kb:    kb,
+		store: newRecordStore(cfg.RecordTTL, cfg.CacheSize, mem),
+		dht:   DHT{kb: kb},
+		cfg:   cfg,
+
+		multi:     make(map[[kbucket.IdSize]byte][]multiEntry),
+		negCache:  make(map[[kbucket.IdSize]byte]negEntry),
+		crdtKinds: make(map[string]CRDTKind),
+
+		respRange: ResponsibilityRange{Self: id}, // 没有邻居时负责整个 keyspace
+	}
+	if cfg.LowPriorityRPCs > 0 {
+		p.lowSends = make(chan struct{}, cfg.LowPriorityRPCs)
+	}
+	p.store.clock = p.now
+	p.store.ttl = p.storedTTL
+	mem.setEvict(p.evicted)
+	kb.SetPinger(p.ping) // bucket 已满时按 LRU 策略淘汰无响应的节点
+	kb.SetConflictPolicy(cfg.ConflictPolicy)
+	kb.SetStaleFailures(cfg.StaleFailures)
+	kb.SetQuarantine(cfg.QuarantineGrace)
+	kb.SetFilter(p.admits) // 被封禁的节点不加入路由表
+	return p, nil
+}
+
+func (p *Peer) ID() [kbucket.IdSize]byte {
+	return p.node.ID
+}
+
+func (p *Peer) KBucket() *kbucket.KBucket { // 返回节点的路由表
+	return p.kb
+}
+
+// 发布一个值：先查找距离 key 最近的 K 个节点，再把值 STORE 到这些节点上。
+// 返回成功放置的副本数（包括本地保存的一份）。ctx 结束时停止发布，
+// 返回已经放置的副本数以及 ctx.Err()
+func (p *Peer) SetValue(ctx context.Context, key, value []byte) (int, error) {
+	return p.setValue(ctx, key, value, NewTraceID())
+}
+
+func (p *Peer) setValue(ctx context.Context, key, value []byte, trace TraceID) (int, error) {
+	if len(key) == 0 || len(value) == 0 {
+		return 0, ErrEmptyKey
+	}
+	if len(key) != kbucket.IdSize {
+		return 0, ErrKeyMismatch
+	}
+	hash := MustKey(key)
+	if err := p.validate(hash, value); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if p.journal != nil {
+		if err := p.journal.append(journalPending, hash, value); err != nil {
+			return 0, err // 无法记录日志时不接受写入
+		}
+	}
+	p.owned.track(hash, value)
+	stored := 0
+	if p.acceptValue(hash, value, p.ownOrigin()) { // 本地存储已满时只负责发布
+		stored++
+	}
+	n, err := p.replicate(ctx, hash, value, trace)
+	stored += n
+	if p.journal != nil && n >= p.journalAcks() { // 远端副本不足的发布留在日志中，重启后重放
+		p.journal.append(journalDone, hash, nil)
+	}
+	return stored, err
+}
+
+// 在本地保存一个值（不再向其他节点复制），存储已满时返回 false。
+// 保存或替换了记录时通知关注该 key 的节点
+func (p *Peer) acceptValue(hash [kbucket.IdSize]byte, value []byte, origin Provenance) bool {
+	p.stats.record(hash, true)
+	return p.saveValue(hash, value, origin, ValueStored)
+}
+
+// acceptValue 的存储部分，保存时发出 typ 类型的存储事件
+func (p *Peer) saveValue(hash [kbucket.IdSize]byte, value []byte, origin Provenance, typ StoreEventType) bool {
+	if p.faults.storeError() != nil {
+		return false
+	}
+	if _, ok := p.crdtKindOf(value); ok { // CRDT 记录与已有的状态合并
+		return p.saveCRDT(hash, value, origin, typ)
+	}
+	if p.keepExisting(hash, value) { // 已有的记录只延长有效期
+		return true
+	}
+	p.forgetMiss(hash) // 经过本节点的 STORE 使否定缓存失效
+	if p.store.has(hash) {
+		// Selector 选择了新值，替换已有的记录
+		if p.store.put(hash, value, origin) != nil {
+			return false
+		}
+		p.dropSiblings(hash)
+		p.emitStore(typ, hash)
+		p.notifyWatchers(hash)
+		return true
+	}
+	if p.storeFullFor(value) {
+		return false
+	}
+	if p.store.putIfAbsent(hash, value, origin) {
+		p.dropSiblings(hash) // 已过期的记录留下的并发记录
+		p.emitStore(typ, hash)
+		p.notifyWatchers(hash)
+	}
+	return true
+}
+
+// 将值复制到距离 hash 最近的节点（默认 K 个，见 NamespacePolicy.Replicas），
+// 返回成功的副本数以及查找中断的原因
+func (p *Peer) replicate(ctx context.Context, hash [kbucket.IdSize]byte, value []byte, trace TraceID) (int, error) {
+	if p.static { // 静态模式只在成员之间复制
+		return p.staticSetValue(hash, value), nil
+	}
+	if p.cfg.PipelineStores {
+		return p.replicatePipelined(ctx, hash, value, trace)
+	}
+	budget := p.newLookupBudget(ctx)
+	budget.trace = trace
+	budget.value = value
+	targets := p.replicaSet(hash, value, budget) // 首选节点总是收到副本
+	var holders [][kbucket.IdSize]byte
+	defer func() { // 本节点发布的 key 记下这次的副本，见 DriftReport
+		now := p.now()
+		var expires time.Time
+		if ttl := p.recordTTL(value); ttl > 0 {
+			expires = now.Add(ttl)
+		}
+		p.owned.setHolders(hash, holders, now, expires)
+	}()
+	rpcCtx := ContextWithTrace(ctx, trace)
+	for _, c := range targets {
+		if err := ctx.Err(); err != nil {
+			return len(holders), err
+		}
+		if p.storeTo(rpcCtx, c, hash, value) {
+			holders = append(holders, c.ID)
+		}
+	}
+	return len(holders), budget.err
+}
+
+// 通过 Messenger 向 c 发出一次 STORE，返回 c 是否保存了值
+func (p *Peer) storeTo(ctx context.Context, c Contact, hash [kbucket.IdSize]byte, value []byte) bool {
+	m := p.messengerFor(c)
+	if m == nil {
+		return false
+	}
+	start := time.Now()
+	err := m.Store(ctx, c, hash, value)
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnreachable) {
+		p.observe(c.ID, false, 0)
+	}
+	p.traceHop(c, nil, start, err == nil)
+	return err == nil
+}
+
+// 按 Config.Metric 比较 a 与 b 哪个距离 target 更近
+func (p *Peer) closer(a, b, target [kbucket.IdSize]byte) bool {
+	if p.cfg.Metric != nil {
+		return p.cfg.Metric.Closer(a, b, target)
+	}
+	return kbucket.Closer(a, b, target)
+}
+
+// 负责 key 的下一跳联系人，包括只能通过网络联系的节点
+func (p *Peer) routeContacts(key [kbucket.IdSize]byte) []Contact {
+	if p.static {
+		members := p.staticClosest(key, p.cfg.K)
+		contacts := make([]Contact, len(members))
+		for i, m := range members {
+			contacts[i] = Contact{ID: m.node.ID, Peer: m}
+		}
+		return contacts
+	}
+	pos := p.kb.BucketIndex(key)
+	p.kb.Touch(pos)
+	if p.kb.Flat() { // 扁平路由表认识所有节点，直接交给最近的节点
+		return contactsOf(p.kb.FindClosestNodes(key, p.cfg.ReplicationFactor))
+	}
+	nodes := p.kb.GetBucket(pos).Nodes()
+	if len(nodes) > p.cfg.ReplicationFactor {
+		nodes = nodes[:p.cfg.ReplicationFactor]
+	}
+	return contactsOf(nodes)
+}
+
+// routeContacts 中的进程内节点。CRDT、多值 key、WatchKey 与 STORE 的转交提示
+// 直接读写对方的状态，没有对应的 RPC，只在进程内的节点之间工作，见 ErrRemoteReplicas
+func (p *Peer) routeTargets(key [kbucket.IdSize]byte) []*Peer {
+	peers, _ := p.inProcessReplicas(key)
+	return peers
+}
+
+// 负责 key 的进程内节点；负责的节点都只能通过网络联系时返回 ErrRemoteReplicas，
+// 只在进程内工作的操作不会把写入悄悄留在本地
+func (p *Peer) inProcessReplicas(key [kbucket.IdSize]byte) ([]*Peer, error) {
+	contacts := p.routeContacts(key)
+	peers := make([]*Peer, 0, len(contacts))
+	for _, c := range contacts {
+		if c.Peer != nil {
+			peers = append(peers, c.Peer)
+		}
+	}
+	if len(peers) == 0 && len(contacts) > 0 {
+		return nil, ErrRemoteReplicas
+	}
+	return peers, nil
+}
+
+// 读取 key 对应的值，本地没有时向其他节点查找。不存在时返回 ErrNotFound，
+// 查找被中断时返回 ctx.Err() 或 ErrLookupDepthExceeded。
+// 需要区分过期、删除与冲突的记录，或需要值的来源时使用 Get。
+// 迁移哈希函数的兼容窗口中找不到值时改读新旧 key 中的另一个，见 MigrateKeys
+func (p *Peer) GetValue(ctx context.Context, key [kbucket.IdSize]byte) ([]byte, error) {
+	return p.getValue(key, p.newLookupBudget(ctx))
+}
+
+func (p *Peer) getValue(key [kbucket.IdSize]byte, budget *lookupBudget) ([]byte, error) {
+	value, err := p.getOne(key, budget)
+	if err == ErrNotFound {
+		if alt, ok := p.keyAlias(key); ok {
+			if value, err := p.getOne(alt, budget); err == nil {
+				return value, nil
+			}
+		}
+	}
+	return value, err
+}
+
+func (p *Peer) getOne(key [kbucket.IdSize]byte, budget *lookupBudget) ([]byte, error) {
+	p.stats.record(key, false)
+	value, ok := p.store.get(key)
+	p.metricStore(ok)
+	if ok {
+		return value, nil
+	}
+	if p.negativeCached(key) { // 最近确认过不存在
+		return nil, ErrNotFound
+	}
+	if err := budget.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if value = p.lookupValue(key, budget); value != nil {
+		return value, nil
+	}
+	if budget.err != nil {
+		return nil, budget.err
+	}
+	if budget.missConfirmed() { // 没有收敛的查找不能说明 key 不存在，不做否定缓存
+		p.cacheMiss(key)
+	}
+	return nil, ErrNotFound
+}
+
+func (p *Peer) lookupValue(key [kbucket.IdSize]byte, budget *lookupBudget) []byte { // 向其他节点查找值
+	if p.static {
+		budget.converged = true // 静态成员模式直接询问负责 key 的成员
+		return p.staticGetValue(key)
+	}
+	if p.cfg.ReadFanout > 1 {
+		return p.verifiedLookup(key, budget)
+	}
+	value, _ := p.findValue(key, budget)
+	return value
+}
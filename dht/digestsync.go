@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultDigestSyncKeys = 1024 // DIGEST_SYNC 一次最多返回的 key 数
+
+	digestSyncRounds = 4 // 一轮反熵中与同一个邻居最多比较几次摘要
+)
+
+// 一个 DIGEST_SYNC 请求能携带的摘要大小上限（64 位一组）：
+// 消息头、签名、区域、数量上限、摘要长度与 salt 之外的空间
+const maxDigestWords = (maxPacketSize - headerSize - sigSize - kbucket.IdSize - 1 - 2 - 4 - 4) / 8
+
+// 支持 DIGEST_SYNC 的 Messenger。反熵用它与网络邻居比较负责区域内的 key，
+// 只取回本地缺少的记录。没有实现它的 Messenger 联系的节点不参与反熵
+type DigestSyncer interface {
+	// 请求对方保存的、落在 r 中且不在 digest 中的 key，按 key 从小到大至多 limit 个。
+	// more 为 true 表示还有更多
+	DigestSync(ctx context.Context, to Contact, r ResponsibilityRange, digest *KeyDigest, limit int) (keys [][kbucket.IdSize]byte, more bool, err error)
+}
+
+// 本地保存的、落在 r 中且不在 digest 中的 key，按 key 从小到大至多 limit 个
+func (p *Peer) digestMissing(r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool) {
+	if limit <= 0 || limit > DefaultDigestSyncKeys {
+		limit = DefaultDigestSyncKeys
+	}
+	var keys [][kbucket.IdSize]byte
+	for _, key := range p.store.keys() {
+		if r.Contains(key) && !digest.Has(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	if len(keys) > limit {
+		return keys[:limit], true
+	}
+	return keys, false
+}
+
+// 处理 id 的一次 DIGEST_SYNC 请求。与 RANGE_SYNC 共用频率限制，超出时返回 CodeBusy 与等待时间
+func (p *Peer) serveDigestSync(id [kbucket.IdSize]byte, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, ErrorCode, time.Duration) {
+	if ok, wait := p.allowRangeSync(id); !ok {
+		return nil, false, CodeBusy, wait
+	}
+	keys, more := p.digestMissing(r, digest, limit)
+	return keys, more, CodeOK, 0
+}
+
+// 与网络邻居 c 比较 r 中的 key，逐个取回本地缺少的记录，返回新保存的记录数。
+// 本地在 r 中的记录达到 merkleMinKeys 且对方支持 MERKLE_SYNC 时逐层比较 Merkle 树，
+// 否则比较摘要：对方还有更多缺少的 key 时换一个 salt 再比较，至多 digestSyncRounds 次
+func (p *Peer) syncRemote(ctx context.Context, c Contact, r ResponsibilityRange) (int, error) {
+	m := p.messengerFor(c)
+	if syncer, ok := m.(MerkleSyncer); ok && len(p.merkleKeys(r, r.Self, r.Bits)) >= merkleMinKeys {
+		missing, _, err := p.merkleMissing(ctx, syncer, c, r)
+		if err != nil {
+			return 0, err
+		}
+		return p.fetchMissing(ctx, m, c, r, missing)
+	}
+	syncer, ok := m.(DigestSyncer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	repaired := 0
+	for round := 0; round < digestSyncRounds; round++ {
+		digest := p.keyDigest(rand.Uint32(), r.Contains)
+		keys, more, err := syncer.DigestSync(ctx, c, r, digest, DefaultDigestSyncKeys)
+		if err != nil {
+			return repaired, err
+		}
+		n, err := p.fetchMissing(ctx, m, c, r, keys)
+		repaired += n
+		if err != nil || !more {
+			return repaired, err
+		}
+	}
+	return repaired, nil
+}
+
+// 逐个向 c 取回 keys 中本地没有的记录，返回新保存的记录数
+func (p *Peer) fetchMissing(ctx context.Context, m Messenger, c Contact, r ResponsibilityRange, keys [][kbucket.IdSize]byte) (int, error) {
+	origin := Provenance{StoredBy: c.ID, Hops: 1}
+	repaired := 0
+	for _, key := range keys {
+		if !r.Contains(key) || p.store.has(key) {
+			continue
+		}
+		value, _, err := m.FindValue(ctx, c, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return repaired, ctx.Err()
+			}
+			continue
+		}
+		if value != nil && p.repairValue(key, value, origin) {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
@@ -0,0 +1,402 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	recordVersion    = 1
+	storageTxVersion = 1
+)
+
+var (
+	recordMagic    = [4]byte{'K', 'B', 'R', 'C'}
+	storageTxMagic = [4]byte{'K', 'B', 'T', 'X'}
+)
+
+const (
+	recordExt = ".rec"
+	txFile    = "pending.tx" // 已提交但还没有全部应用到记录文件的事务
+)
+
+// 保存在磁盘目录中的存储后端，每条记录一个文件，以 key 的十六进制命名，
+// 写入时先写临时文件再改名。节点重启后用同一个目录打开即可恢复记录。
+//
+// 没有使用 bbolt、LevelDB 之类的嵌入式 KV 库：模块不依赖任何第三方包，单个节点的记录数
+// 受 MaxRecords 与 Resources.StoreBytes 限制，一条记录一个文件配合原子改名已经能保证
+// 崩溃后不留下写了一半的记录，损坏的文件也只影响一条记录。需要大量小记录时可以通过
+// Storage 接口接入 KV 库。
+//
+// 记录数与值的总字节数保存在内存中，打开时扫描一次目录，之后随写入与删除更新，
+// 检查存储预算时不需要读取磁盘。
+//
+// 事务（见 TxStorage）提交时先把全部写入原子地写进一个日志文件，再逐个更新记录文件，
+// 最后删除日志；中途崩溃时日志留在目录中，下次打开时重做，不会只留下事务的一部分
+type DiskStorage struct {
+	dir    string
+	mu     sync.Mutex                   // 使同一个 key 的写入按顺序进行，保护 sizes、sorted 与 bytes
+	sizes  map[[kbucket.IdSize]byte]int // 每条记录的值的字节数
+	sorted keyIndex
+	bytes  int
+}
+
+// 打开 dir 作为存储后端，目录不存在时创建。无法解析的记录文件不计入 Len 与 Bytes，
+// 读取时返回错误
+func OpenDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &DiskStorage{dir: dir, sizes: make(map[[kbucket.IdSize]byte]int)}
+	if err := s.recoverTx(); err != nil {
+		return nil, err
+	}
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if rec, ok, err := s.Get(key); err == nil && ok {
+			s.sizes[key] = len(rec.Value)
+			s.sorted.add(key)
+			s.bytes += len(rec.Value)
+		}
+	}
+	return s, nil
+}
+
+func (s *DiskStorage) path(key [kbucket.IdSize]byte) string {
+	return filepath.Join(s.dir, hex.EncodeToString(key[:])+recordExt)
+}
+
+func (s *DiskStorage) Get(key [kbucket.IdSize]byte) (StoredRecord, bool, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return StoredRecord{}, false, nil
+	}
+	if err != nil {
+		return StoredRecord{}, false, err
+	}
+	rec, err := decodeRecord(data)
+	if err != nil || rec.Key != key {
+		return StoredRecord{}, false, fmt.Errorf("dht: corrupt record file %s", s.path(key))
+	}
+	return rec, true, nil
+}
+
+func (s *DiskStorage) Put(rec StoredRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putLocked(rec)
+}
+
+// 调用方需持有 s.mu
+func (s *DiskStorage) putLocked(rec StoredRecord) error {
+	var buf bytes.Buffer
+	if err := writeVersioned(&buf, recordMagic, ArtifactRecord, encodeRecord(rec)); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path(rec.Key), buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	s.bytes += len(rec.Value) - s.sizes[rec.Key]
+	s.sizes[rec.Key] = len(rec.Value)
+	s.sorted.add(rec.Key)
+	return nil
+}
+
+func (s *DiskStorage) Delete(key [kbucket.IdSize]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteLocked(key)
+}
+
+// 调用方需持有 s.mu
+func (s *DiskStorage) deleteLocked(key [kbucket.IdSize]byte) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.bytes -= s.sizes[key]
+	delete(s.sizes, key)
+	s.sorted.remove(key)
+	return nil
+}
+
+func (s *DiskStorage) Begin() (StorageTx, error) {
+	return &diskTx{s: s}, nil
+}
+
+type diskTx struct {
+	s   *DiskStorage
+	ops []txOp
+}
+
+func (t *diskTx) Put(rec StoredRecord) error {
+	t.ops = append(t.ops, txOp{rec: rec})
+	return nil
+}
+
+func (t *diskTx) Delete(key [kbucket.IdSize]byte) error {
+	t.ops = append(t.ops, txOp{rec: StoredRecord{Key: key}, delete: true})
+	return nil
+}
+
+// 写入日志之后事务即已提交：之后应用到记录文件时出错，日志保留到下次打开时重做
+func (t *diskTx) Commit() error {
+	ops := t.ops
+	t.ops = nil
+	if len(ops) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := writeVersioned(&buf, storageTxMagic, ArtifactStorageTx, encodeTx(ops)); err != nil {
+		return err
+	}
+	s := t.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := filepath.Join(s.dir, txFile)
+	if err := writeFileAtomic(path, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := s.applyLocked(ops); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (t *diskTx) Rollback() error {
+	t.ops = nil
+	return nil
+}
+
+// 调用方需持有 s.mu
+func (s *DiskStorage) applyLocked(ops []txOp) error {
+	for _, op := range ops {
+		var err error
+		if op.delete {
+			err = s.deleteLocked(op.rec.Key)
+		} else {
+			err = s.putLocked(op.rec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 重做崩溃前已提交但没有应用完的事务。重做是幂等的，可以重复进行
+func (s *DiskStorage) recoverTx() error {
+	path := filepath.Join(s.dir, txFile)
+	os.Remove(path + ".tmp") // 没有写完的日志，事务没有提交
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	payload, err := readVersioned(data, storageTxMagic, ArtifactStorageTx)
+	if err != nil {
+		return err
+	}
+	ops, err := decodeTx(payload)
+	if err != nil {
+		return fmt.Errorf("dht: corrupt transaction log %s", path)
+	}
+	if err := s.applyLocked(ops); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// 按文件名顺序遍历，遇到无法解析的记录文件时返回错误
+func (s *DiskStorage) Iterate(fn func(StoredRecord) bool) error {
+	keys, err := s.keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rec, ok, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if ok && !fn(rec) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// 按 key 从小到大遍历，只读取遍历到的记录文件
+func (s *DiskStorage) IterateFrom(from [kbucket.IdSize]byte, fn func(StoredRecord) bool) error {
+	return iterateFrom(from, func(from [kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.sorted.batch(from)
+	}, s.Get, fn)
+}
+
+func (s *DiskStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sizes)
+}
+
+// 值的总字节数
+func (s *DiskStorage) Bytes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// 目录中记录文件对应的 key，忽略其他文件
+func (s *DiskStorage) keys() ([][kbucket.IdSize]byte, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys [][kbucket.IdSize]byte
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), recordExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		raw, err := hex.DecodeString(name)
+		if err != nil || len(raw) != kbucket.IdSize {
+			continue
+		}
+		keys = append(keys, MustKey(raw))
+	}
+	return keys, nil
+}
+
+// key | 过期时间(8) | 发布时间(8) | 发布者 | 已签名(1) | 写入者 | 接收时间(8) | 转交次数(4) | 值。
+// 时间为 Unix 纳秒，0 表示零值
+func encodeRecord(rec StoredRecord) []byte {
+	b := make([]byte, 0, 3*kbucket.IdSize+29+len(rec.Value))
+	b = append(b, rec.Key[:]...)
+	b = binary.BigEndian.AppendUint64(b, unixNano(rec.Expires))
+	b = binary.BigEndian.AppendUint64(b, unixNano(rec.Published))
+	b = append(b, rec.Provenance.Publisher[:]...)
+	signed := byte(0)
+	if rec.Provenance.Signed {
+		signed = 1
+	}
+	b = append(b, signed)
+	b = append(b, rec.Provenance.StoredBy[:]...)
+	b = binary.BigEndian.AppendUint64(b, unixNano(rec.Provenance.Received))
+	b = binary.BigEndian.AppendUint32(b, uint32(rec.Provenance.Hops))
+	return append(b, rec.Value...)
+}
+
+func decodeRecord(data []byte) (StoredRecord, error) {
+	payload, err := readVersioned(data, recordMagic, ArtifactRecord)
+	if err != nil {
+		return StoredRecord{}, err
+	}
+	return parseRecord(payload)
+}
+
+// 解析 encodeRecord 的输出，Value 引用 payload
+func parseRecord(payload []byte) (StoredRecord, error) {
+	if len(payload) < 3*kbucket.IdSize+29 {
+		return StoredRecord{}, ErrBadRecord
+	}
+	var rec StoredRecord
+	r := bytes.NewReader(payload)
+	r.Read(rec.Key[:])
+	var expires, published, received uint64
+	var hops uint32
+	binary.Read(r, binary.BigEndian, &expires)
+	binary.Read(r, binary.BigEndian, &published)
+	r.Read(rec.Provenance.Publisher[:])
+	signed, _ := r.ReadByte()
+	r.Read(rec.Provenance.StoredBy[:])
+	binary.Read(r, binary.BigEndian, &received)
+	binary.Read(r, binary.BigEndian, &hops)
+	rec.Expires, rec.Published = fromUnixNano(expires), fromUnixNano(published)
+	rec.Provenance.Signed = signed == 1
+	rec.Provenance.Received = fromUnixNano(received)
+	rec.Provenance.Hops = int(hops)
+	rec.Value = payload[len(payload)-r.Len():]
+	return rec, nil
+}
+
+// 事务日志：操作数(4) | 每个操作为 类型(1) | 删除时为 key，写入时为 长度(4) | encodeRecord 的输出
+func encodeTx(ops []txOp) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(ops)))
+	for _, op := range ops {
+		if op.delete {
+			b = append(b, 1)
+			b = append(b, op.rec.Key[:]...)
+			continue
+		}
+		rec := encodeRecord(op.rec)
+		b = append(b, 0)
+		b = binary.BigEndian.AppendUint32(b, uint32(len(rec)))
+		b = append(b, rec...)
+	}
+	return b
+}
+
+func decodeTx(payload []byte) ([]txOp, error) {
+	if len(payload) < 4 {
+		return nil, ErrBadRecord
+	}
+	n := binary.BigEndian.Uint32(payload)
+	payload = payload[4:]
+	var ops []txOp
+	for i := uint32(0); i < n; i++ {
+		if len(payload) < 1 {
+			return nil, ErrBadRecord
+		}
+		kind := payload[0]
+		payload = payload[1:]
+		switch {
+		case kind == 1 && len(payload) >= kbucket.IdSize:
+			ops = append(ops, txOp{rec: StoredRecord{Key: MustKey(payload[:kbucket.IdSize])}, delete: true})
+			payload = payload[kbucket.IdSize:]
+		case kind == 0 && len(payload) >= 4:
+			size := binary.BigEndian.Uint32(payload)
+			payload = payload[4:]
+			if uint32(len(payload)) < size {
+				return nil, ErrBadRecord
+			}
+			rec, err := parseRecord(payload[:size])
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, txOp{rec: rec})
+			payload = payload[size:]
+		default:
+			return nil, ErrBadRecord
+		}
+	}
+	return ops, nil
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func fromUnixNano(n uint64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(n))
+}
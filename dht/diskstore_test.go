@@ -0,0 +1,202 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDisk(t *testing.T, dir string) *DiskStorage {
+	t.Helper()
+	s, err := OpenDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 写入的记录原样读回，字段包括过期时间与来源；删除后计数随之更新
+func TestDiskStorageRoundTrip(t *testing.T) {
+	s := openTestDisk(t, t.TempDir())
+	now := time.Unix(0, time.Now().UnixNano())
+	rec := StoredRecord{
+		Key:       KeyFromString("disk-a"),
+		Value:     []byte("disk-value"),
+		Expires:   now.Add(time.Hour),
+		Published: now,
+		Provenance: Provenance{
+			Publisher: KeyFromString("disk-publisher"),
+			Signed:    true,
+			StoredBy:  KeyFromString("disk-sender"),
+			Received:  now,
+			Hops:      2,
+		},
+	}
+	if err := s.Put(rec); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := s.Get(rec.Key)
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v", ok, err)
+	}
+	if !bytes.Equal(got.Value, rec.Value) || !got.Expires.Equal(rec.Expires) || !got.Published.Equal(rec.Published) ||
+		got.Provenance.Publisher != rec.Provenance.Publisher || !got.Provenance.Signed ||
+		got.Provenance.StoredBy != rec.Provenance.StoredBy || !got.Provenance.Received.Equal(now) || got.Provenance.Hops != 2 {
+		t.Fatalf("Get returned %+v, want %+v", got, rec)
+	}
+	rec.Value = []byte("longer disk-value")
+	s.Put(rec)
+	if s.Len() != 1 || s.Bytes() != len(rec.Value) {
+		t.Fatalf("after overwrite Len = %d, Bytes = %d", s.Len(), s.Bytes())
+	}
+	if err := s.Delete(rec.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get(rec.Key); ok || s.Len() != 0 || s.Bytes() != 0 {
+		t.Fatalf("after Delete ok = %v, Len = %d, Bytes = %d", ok, s.Len(), s.Bytes())
+	}
+}
+
+// 节点重启后用同一个目录打开，之前保存的记录与计数都恢复
+func TestDiskStorageRestart(t *testing.T) {
+	dir := t.TempDir()
+	value := []byte("disk-restart")
+	key := KeyFromBytes(value)
+	p := NewPeer(KeyFromString("disk-self"))
+	if err := p.SetStorage(openTestDisk(t, dir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetValue(context.Background(), key[:], value); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openTestDisk(t, dir)
+	if s.Len() != 1 || s.Bytes() != len(value) {
+		t.Fatalf("reopened Len = %d, Bytes = %d", s.Len(), s.Bytes())
+	}
+	q := NewPeer(KeyFromString("disk-self"))
+	if err := q.SetStorage(s); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := q.GetValue(context.Background(), key); err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("GetValue after restart = %q, %v", got, err)
+	}
+}
+
+// 损坏的记录文件读取时报错，不计入容量，节点按记录不存在处理
+func TestDiskStorageCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestDisk(t, dir)
+	good := StoredRecord{Key: KeyFromString("disk-good"), Value: []byte("ok")}
+	bad := KeyFromString("disk-bad")
+	s.Put(good)
+	if err := os.WriteFile(s.path(bad), []byte("not a record"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestDisk(t, dir)
+	if _, _, err := s.Get(bad); err == nil {
+		t.Fatal("Get of a corrupt file returned no error")
+	}
+	if s.Len() != 1 || s.Bytes() != len(good.Value) {
+		t.Fatalf("Len = %d, Bytes = %d, corrupt file counted", s.Len(), s.Bytes())
+	}
+	if err := s.Iterate(func(StoredRecord) bool { return true }); err == nil {
+		t.Fatal("Iterate over a corrupt file returned no error")
+	}
+	p := NewPeer(KeyFromString("disk-self"))
+	p.store.backend = s // 直接换用，SetStorage 会复制原有的记录
+	if _, ok := p.store.get(bad); ok {
+		t.Fatal("corrupt record read as present")
+	}
+	if got, ok := p.store.get(good.Key); !ok || !bytes.Equal(got, good.Value) {
+		t.Fatalf("intact record = %q, %v", got, ok)
+	}
+}
+
+// 事务提交之前的写入不可见；日志写入之后崩溃的事务在下次打开时重做，没有写完的日志被丢弃
+func TestDiskStorageTxRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestDisk(t, dir)
+	old := StoredRecord{Key: KeyFromString("tx-old"), Value: []byte("old")}
+	chunk := StoredRecord{Key: KeyFromString("tx-chunk"), Value: []byte("chunk")}
+	s.Put(old)
+	tx, _ := s.Begin()
+	tx.Put(chunk)
+	tx.Delete(old.Key)
+	if _, ok, _ := s.Get(chunk.Key); ok {
+		t.Fatal("uncommitted write visible")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get(old.Key); ok || s.Len() != 1 {
+		t.Fatalf("after Commit old present = %v, Len = %d", ok, s.Len())
+	}
+
+	// 模拟写完日志、更新记录文件之前的崩溃
+	var buf bytes.Buffer
+	writeVersioned(&buf, storageTxMagic, ArtifactStorageTx, encodeTx([]txOp{{rec: old}, {rec: chunk, delete: true}}))
+	if err := os.WriteFile(filepath.Join(dir, txFile), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestDisk(t, dir)
+	if _, ok, _ := s.Get(old.Key); !ok {
+		t.Fatal("committed write lost after a crash")
+	}
+	if _, ok, _ := s.Get(chunk.Key); ok || s.Len() != 1 {
+		t.Fatalf("committed delete not redone, Len = %d", s.Len())
+	}
+	if _, err := os.Stat(filepath.Join(dir, txFile)); !os.IsNotExist(err) {
+		t.Fatalf("transaction log left behind: %v", err)
+	}
+
+	// 日志没有写完（仍是临时文件）时事务没有提交
+	buf.Reset()
+	writeVersioned(&buf, storageTxMagic, ArtifactStorageTx, encodeTx([]txOp{{rec: chunk}}))
+	os.WriteFile(filepath.Join(dir, txFile+".tmp"), buf.Bytes(), 0o644)
+	s = openTestDisk(t, dir)
+	if _, ok, _ := s.Get(chunk.Key); ok {
+		t.Fatal("uncommitted transaction applied")
+	}
+}
+
+// StoreLocal 一起保存分块并删除旧记录；有一个值不通过检查时什么都不写入
+func TestStoreLocal(t *testing.T) {
+	p := NewPeer(KeyFromString("batch-self"))
+	if err := p.SetStorage(openTestDisk(t, t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+	stale := []byte("stale")
+	p.store.put(KeyFromBytes(stale), stale, Provenance{})
+
+	var b LocalBatch
+	chunks := [][]byte{[]byte("chunk-1"), []byte("chunk-2")}
+	for _, c := range chunks {
+		b.Put(KeyFromBytes(c), c)
+	}
+	b.Delete(KeyFromBytes(stale))
+	var bad LocalBatch
+	bad.Put(KeyFromBytes(chunks[0]), chunks[0])
+	bad.Delete(KeyFromBytes(stale))
+	bad.Put(KeyFromString("batch-bad"), []byte("x")) // key 不是值的哈希
+	if err := p.StoreLocal(&bad); err == nil {
+		t.Fatal("batch with an invalid value accepted")
+	}
+	if p.store.has(KeyFromBytes(chunks[0])) || !p.store.has(KeyFromBytes(stale)) {
+		t.Fatal("rejected batch partially applied")
+	}
+	if err := p.StoreLocal(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if got, ok := p.store.get(KeyFromBytes(c)); !ok || !bytes.Equal(got, c) {
+			t.Fatalf("chunk %q = %q, %v", c, got, ok)
+		}
+	}
+	if p.store.has(KeyFromBytes(stale)) {
+		t.Fatal("deleted record still present")
+	}
+}
@@ -0,0 +1,210 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// DiversityThresholds 的默认值
+const (
+	DefaultMinCoverage    = 0.5              // 非空 bucket 占路由表深度的最低比例
+	DefaultMaxSubnetShare = 0.25             // 同一子网的联系人的最高比例
+	DefaultMaxNewShare    = 0.5              // 新联系人的最高比例
+	DefaultNewContactAge  = 10 * time.Minute // 首次出现不超过这个时间的联系人视为新联系人
+)
+
+// DiversityWarning.Kind
+const (
+	WarnCoverage    = "coverage"     // 非空 bucket 太少，路由表只认识 keyspace 的一小部分
+	WarnSubnet      = "subnet"       // 太多联系人来自同一个子网
+	WarnVersion     = "version"      // 太多联系人运行同一个软件版本
+	WarnNewContacts = "new_contacts" // 太多联系人是最近才出现的
+)
+
+// 发出警告的阈值，零值字段使用默认值
+type DiversityThresholds struct {
+	MinCoverage     float64       // 非空 bucket 占路由表深度的最低比例
+	MaxSubnetShare  float64       // 同一子网（IPv4 /24、IPv6 /48）的联系人的最高比例
+	MaxVersionShare float64       // 同一软件版本的联系人的最高比例，0 表示不检查
+	MaxNewShare     float64       // 新联系人的最高比例
+	NewContactAge   time.Duration // 首次出现不超过这个时间的联系人视为新联系人
+	MinContacts     int           // 联系人少于这个数时不检查各项比例，0 表示 K
+}
+
+func (t DiversityThresholds) withDefaults(k int) DiversityThresholds {
+	if t.MinCoverage == 0 {
+		t.MinCoverage = DefaultMinCoverage
+	}
+	if t.MaxSubnetShare == 0 {
+		t.MaxSubnetShare = DefaultMaxSubnetShare
+	}
+	if t.MaxNewShare == 0 {
+		t.MaxNewShare = DefaultMaxNewShare
+	}
+	if t.NewContactAge == 0 {
+		t.NewContactAge = DefaultNewContactAge
+	}
+	if t.MinContacts == 0 {
+		t.MinContacts = k
+	}
+	return t
+}
+
+// 一项多样性指标低于阈值
+type DiversityWarning struct {
+	Kind      string  // WarnCoverage 等
+	Detail    string  // 占比最高的子网或版本，其他指标为空
+	Value     float64 // 实际的比例
+	Threshold float64
+}
+
+func (w DiversityWarning) String() string {
+	if w.Detail != "" {
+		return fmt.Sprintf("%s %s: %.2f (threshold %.2f)", w.Kind, w.Detail, w.Value, w.Threshold)
+	}
+	return fmt.Sprintf("%s: %.2f (threshold %.2f)", w.Kind, w.Value, w.Threshold)
+}
+
+// 路由表联系人的多样性。被日蚀攻击的节点通常表现为联系人集中在少数子网、
+// 大量联系人在短时间内出现，或者只剩下 keyspace 一小部分的联系人
+type DiversityReport struct {
+	Time     time.Time
+	Contacts int
+
+	Depth    int     // 路由表的深度：从 HomeBucket 到最远 bucket 的 bucket 数
+	Covered  int     // 其中非空的 bucket 数
+	Coverage float64 // Covered / Depth
+
+	Subnets   map[string]int // 子网 → 联系人数，没有地址的联系人不计入
+	NoAddress int            // 没有网络地址的联系人数（进程内节点）
+	Versions  map[string]int // 握手中声明的软件版本 → 联系人数
+	NoVersion int            // 还没有握手的联系人数
+
+	Unverified  int           // 尚未确认过存活的联系人数
+	KnownAge    int           // 有首次出现时间的联系人数
+	MeanAge     time.Duration // 这些联系人距首次出现的平均时间
+	NewContacts int           // 其中的新联系人数，见 DiversityThresholds.NewContactAge
+
+	Warnings []DiversityWarning
+}
+
+// 联系人所在的子网：IPv4 /24、IPv6 /48
+func subnetOf(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// 联系人的网络地址，进程内的节点使用它的 UDPTransport 的地址，都没有时返回 nil
+func addrOfNode(n kbucket.Node) *net.UDPAddr {
+	switch data := n.Data.(type) {
+	case *net.UDPAddr:
+		return data
+	case *Peer:
+		if data.transport != nil {
+			return data.transport.Addr()
+		}
+	}
+	return nil
+}
+
+// 统计路由表的多样性，并按 t 给出警告
+func (p *Peer) DiversityReport(t DiversityThresholds) DiversityReport {
+	t = t.withDefaults(p.cfg.K)
+	now := time.Now()
+	r := DiversityReport{
+		Time:     now,
+		Subnets:  make(map[string]int),
+		Versions: make(map[string]int),
+	}
+	var totalAge, maxAge time.Duration
+	first := p.kb.HomeBucket()
+	r.Depth = kbucket.IdSize*8 - first
+	for pos := first; pos < kbucket.IdSize*8; pos++ {
+		nodes := p.kb.GetBucket(pos).Nodes()
+		if len(nodes) > 0 {
+			r.Covered++
+		}
+		for _, n := range nodes {
+			r.Contacts++
+			if n.LastSeen.IsZero() {
+				r.Unverified++
+			}
+			if addr := addrOfNode(n); addr != nil {
+				r.Subnets[subnetOf(addr.IP)]++
+			} else {
+				r.NoAddress++
+			}
+			if info, ok := p.PeerInfo(n.ID); ok && info.Version != "" {
+				r.Versions[info.Version]++
+			} else {
+				r.NoVersion++
+			}
+			if s, ok := p.PeerStats(n.ID); ok && !s.FirstSeen.IsZero() {
+				age := now.Sub(s.FirstSeen)
+				r.KnownAge++
+				totalAge += age
+				maxAge = max(maxAge, age)
+				if age <= t.NewContactAge {
+					r.NewContacts++
+				}
+			}
+		}
+	}
+	if r.Depth > 0 {
+		r.Coverage = float64(r.Covered) / float64(r.Depth)
+	}
+	if r.KnownAge > 0 {
+		r.MeanAge = totalAge / time.Duration(r.KnownAge)
+	}
+	if r.Contacts >= t.MinContacts {
+		r.Warnings = r.check(t, maxAge)
+	}
+	return r
+}
+
+// oldest 为最早出现的联系人的年龄。节点刚启动时所有联系人都是新的，此时不检查新联系人的比例
+func (r DiversityReport) check(t DiversityThresholds, oldest time.Duration) []DiversityWarning {
+	var warnings []DiversityWarning
+	if r.Coverage < t.MinCoverage {
+		warnings = append(warnings, DiversityWarning{Kind: WarnCoverage, Value: r.Coverage, Threshold: t.MinCoverage})
+	}
+	if name, share := topShare(r.Subnets); share > t.MaxSubnetShare {
+		warnings = append(warnings, DiversityWarning{Kind: WarnSubnet, Detail: name, Value: share, Threshold: t.MaxSubnetShare})
+	}
+	if name, share := topShare(r.Versions); t.MaxVersionShare > 0 && share > t.MaxVersionShare {
+		warnings = append(warnings, DiversityWarning{Kind: WarnVersion, Detail: name, Value: share, Threshold: t.MaxVersionShare})
+	}
+	if r.KnownAge > 0 && oldest > t.NewContactAge {
+		if share := float64(r.NewContacts) / float64(r.KnownAge); share > t.MaxNewShare {
+			warnings = append(warnings, DiversityWarning{Kind: WarnNewContacts, Value: share, Threshold: t.MaxNewShare})
+		}
+	}
+	return warnings
+}
+
+// 占比最高的一项及其比例，比例相同时取名字最小的一项
+func topShare(counts map[string]int) (string, float64) {
+	names := make([]string, 0, len(counts))
+	total := 0
+	for name, n := range counts {
+		names = append(names, name)
+		total += n
+	}
+	if total == 0 {
+		return "", 0
+	}
+	sort.Strings(names)
+	top := names[0]
+	for _, name := range names[1:] {
+		if counts[name] > counts[top] {
+			top = name
+		}
+	}
+	return top, float64(counts[top]) / float64(total)
+}
@@ -0,0 +1,16 @@
+// Package dht 在 kbucket 路由表之上实现 Kademlia 节点：迭代查找、键值存储与复制、
+// 节点的生命周期，以及 UDP 与 gRPC 两种传输。UDP 报文也可以承载在 WebSocket 上
+// （见 ListenWebSocket 与 DialWebSocket），包可以以 GOOS=js GOARCH=wasm 编译，
+// 浏览器中的节点由此加入网络。浏览器节点之间经由双方都连接着的节点交换 SIGNAL
+// 信令（见 UDPTransport.Signal），在 wasm 中用 NewWebRTC 建立直接的 WebRTC 数据通道。
+//
+// 模块的包划分：
+//
+//   - kbucket：路由表，不涉及网络
+//   - dht：节点、查找与存储，下游代码应当只依赖这里的 API
+//   - transport/udpwire：UDPTransport 的数据包格式，供其他实现与之互通
+//   - internal/...：模块内部的辅助实现，不对外承诺兼容
+//
+// Peer、Config、Contact、Messenger 以及事件类型与错误值保持兼容。Peer.KBucket
+// 返回的路由表、Faults 与 Hooks 面向测试与仿真，会随路由表的实现变化，不在兼容承诺之内
+package dht
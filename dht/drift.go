@@ -0,0 +1,187 @@
+package dht
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const DefaultDriftCheckInterval = 15 * time.Minute
+
+// 本节点发布的一个 key 的副本漂移情况：发布时接受副本的节点中，
+// 还有多少仍在当前距离 key 最近的节点集合中。节点加入或离开后，
+// 新的最近节点没有副本，应用可以据此提前重新发布
+type DriftReport struct {
+	Key       [kbucket.IdSize]byte
+	Published time.Time // 最近一次发布（或重新发布）的时间
+	Holders   int       // 发布时接受副本的节点数
+	Retained  int       // 其中仍在当前最近节点集合中的数量
+	Newcomers []Contact // 当前最近节点中没有收到副本的节点
+	Checked   time.Time // 检查的时间，零值表示发布后还没有检查过
+}
+
+// 已经离开最近节点集合的副本比例，0 表示没有漂移
+func (r DriftReport) Drift() float64 {
+	if r.Holders == 0 {
+		return 0
+	}
+	return 1 - float64(r.Retained)/float64(r.Holders)
+}
+
+type ownedKey struct {
+	holders map[[kbucket.IdSize]byte]bool // 最近一次发布时接受副本的节点
+	value   []byte
+	expires time.Time // 记录在其他节点上过期的时间，之后停止监测
+	report  DriftReport
+}
+
+// 本节点通过 SetValue 发布的 key
+type ownedKeys struct {
+	mu sync.Mutex
+	m  map[[kbucket.IdSize]byte]*ownedKey
+}
+
+// 开始监测 key，holders 在复制完成后由 setHolders 填入
+func (o *ownedKeys) track(key [kbucket.IdSize]byte, value []byte) {
+	value = append([]byte(nil), value...)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.m == nil {
+		o.m = make(map[[kbucket.IdSize]byte]*ownedKey)
+	}
+	if k, ok := o.m[key]; ok {
+		k.value = value
+		return
+	}
+	o.m[key] = &ownedKey{value: value}
+}
+
+func (o *ownedKeys) has(key [kbucket.IdSize]byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, ok := o.m[key]
+	return ok
+}
+
+// 记录一次发布的结果，不在监测中的 key 忽略
+func (o *ownedKeys) setHolders(key [kbucket.IdSize]byte, holders [][kbucket.IdSize]byte, now, expires time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k, ok := o.m[key]
+	if !ok {
+		return
+	}
+	k.holders = make(map[[kbucket.IdSize]byte]bool, len(holders))
+	for _, id := range holders {
+		k.holders[id] = true
+	}
+	k.expires = expires
+	k.report = DriftReport{Key: key, Published: now, Holders: len(holders), Retained: len(holders)}
+}
+
+// 需要检查的 key：距上次检查超过 interval，已过期的 key 停止监测
+func (o *ownedKeys) due(now time.Time, interval time.Duration) map[[kbucket.IdSize]byte][]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	due := make(map[[kbucket.IdSize]byte][]byte)
+	for key, k := range o.m {
+		if !k.expires.IsZero() && now.After(k.expires) {
+			delete(o.m, key)
+			continue
+		}
+		last := k.report.Checked
+		if last.IsZero() {
+			last = k.report.Published
+		}
+		if now.Sub(last) >= interval {
+			due[key] = k.value
+		}
+	}
+	return due
+}
+
+// 与当前的最近节点 closest 比较，更新并返回 key 的漂移报告
+func (o *ownedKeys) compare(key [kbucket.IdSize]byte, closest []Contact, now time.Time) (DriftReport, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	k, ok := o.m[key]
+	if !ok {
+		return DriftReport{}, false
+	}
+	r := DriftReport{Key: key, Published: k.report.Published, Holders: len(k.holders), Checked: now}
+	for _, c := range closest {
+		if k.holders[c.ID] {
+			r.Retained++
+		} else {
+			r.Newcomers = append(r.Newcomers, c)
+		}
+	}
+	k.report = r
+	return r, true
+}
+
+// 所有监测中的 key 最近一次的漂移报告，漂移最大的在前
+func (p *Peer) DriftReports() []DriftReport {
+	p.owned.mu.Lock()
+	reports := make([]DriftReport, 0, len(p.owned.m))
+	for _, k := range p.owned.m {
+		reports = append(reports, k.report)
+	}
+	p.owned.mu.Unlock()
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Drift() > reports[j].Drift()
+	})
+	return reports
+}
+
+// 重新查找 key 当前的最近节点并与发布时的副本比较。key 不是本节点发布的时返回 false
+func (p *Peer) CheckDrift(ctx context.Context, key [kbucket.IdSize]byte) (DriftReport, bool) {
+	p.owned.mu.Lock()
+	k, ok := p.owned.m[key]
+	var value []byte
+	if ok {
+		value = k.value
+	}
+	p.owned.mu.Unlock()
+	if !ok {
+		return DriftReport{}, false
+	}
+	return p.owned.compare(key, p.replicaSet(key, value, p.newLookupBudget(ctx)), p.now())
+}
+
+// 检查所有距上次检查超过 DriftCheckInterval 的 key，由 RunJanitor 调用。返回检查的 key 数
+func (p *Peer) checkDrift(ctx context.Context) int {
+	if p.cfg.DriftCheckInterval < 0 || p.static {
+		return 0
+	}
+	due := p.owned.due(p.now(), p.cfg.DriftCheckInterval)
+	for key, value := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		p.owned.compare(key, p.replicaSet(key, value, p.newLookupBudget(ctx)), p.now())
+	}
+	return len(due)
+}
+
+// 现在发布 value 时会收到副本的节点，与 replicate 的选择相同。
+// 最近的节点超过 Replicas 个时，距离相当的节点中优先选择 RTT 较低的
+func (p *Peer) replicaSet(key [kbucket.IdSize]byte, value []byte, budget *lookupBudget) []Contact {
+	targets := p.preferLowRTT(key, contactsOf(p.lookup(key, budget)))
+	if n := p.policy(Namespace(value)).Replicas; len(targets) > n {
+		targets = targets[:n]
+	}
+	covered := make(map[[kbucket.IdSize]byte]bool, len(targets))
+	for _, c := range targets {
+		covered[c.ID] = true
+	}
+	for _, c := range p.pinnedFor(key, value) {
+		if !covered[c.ID] {
+			targets = append(targets, c)
+		}
+	}
+	return targets
+}
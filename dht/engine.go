@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	engineCacheSize = 4096             // LookupEngine 最多缓存的响应数
+	engineCacheTTL  = 30 * time.Second // 缓存的响应的有效期，之后对方的路由表可能已经变化
+)
+
+// 以 p 为起点执行节点查找（FIND_NODE）。同一个 LookupEngine 上的多次查找
+// 共享已经得到的响应，RPCs 记录实际发出的 FIND_NODE 次数
+type LookupEngine struct {
+	p     *Peer
+	RPCs  int
+	mu    sync.Mutex                   // 保护 RPCs 与 cache，同一轮的查询并发进行
+	cache map[findNodeKey]engineResult // 已查询过的节点对某个目标的响应
+}
+
+// 节点对 FIND_NODE 的响应取决于精确的目标：目标所在的 bucket 不满 K 个节点时，
+// 其余的节点按与目标的距离从相邻的 bucket 中选取，因此只有同一个目标才能复用响应
+type findNodeKey struct {
+	peer   [kbucket.IdSize]byte
+	target [kbucket.IdSize]byte
+}
+
+type engineResult struct {
+	nodes   []Contact
+	expires time.Time
+}
+
+func NewLookupEngine(p *Peer) *LookupEngine {
+	return &LookupEngine{p: p, cache: make(map[findNodeKey]engineResult)}
+}
+
+// 通过 m 向 c 查询距离 target 最近的节点，有效期内已有 c 对 target 的响应时不再发出 RPC
+func (e *LookupEngine) findNode(ctx context.Context, m Messenger, c Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	key := findNodeKey{peer: c.ID, target: target}
+	now := e.p.now()
+	e.mu.Lock()
+	cached, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.nodes, nil
+	}
+	resp, err := m.FindNode(ctx, c, target)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.RPCs++
+	if err == nil {
+		if len(e.cache) >= engineCacheSize {
+			e.prune(now)
+		}
+		e.cache[key] = engineResult{nodes: resp, expires: now.Add(engineCacheTTL)}
+	}
+	return resp, err
+}
+
+// 删除过期的响应，仍然没有空位时清空缓存。调用方持有 e.mu
+func (e *LookupEngine) prune(now time.Time) {
+	for key, r := range e.cache {
+		if !now.Before(r.expires) {
+			delete(e.cache, key)
+		}
+	}
+	if len(e.cache) >= engineCacheSize {
+		e.cache = make(map[findNodeKey]engineResult) // 代价只是之后的查找重新发出 RPC
+	}
+}
+
+// 查找距离 target 最近的至多 K 个节点，按距离从近到远排序
+func (e *LookupEngine) Lookup(target [kbucket.IdSize]byte) []kbucket.Node {
+	return e.lookup(target, NewTraceID())
+}
+
+func (e *LookupEngine) lookup(target [kbucket.IdSize]byte, trace TraceID) []kbucket.Node {
+	budget := e.p.newLookupBudget(context.Background())
+	budget.trace = trace
+	closest, _ := e.p.iterate(target, budget, OpFindNode, func(ctx context.Context, m Messenger, c Contact) iterReply {
+		nodes, err := e.findNode(ctx, m, c, target)
+		return func() ([]Contact, bool, error) { return nodes, false, err }
+	})
+	return closest
+}
+
+// 对多个目标执行查找。目标按 ID 排序后依次查找，重复的目标只查找一次
+func (e *LookupEngine) LookupMany(targets [][kbucket.IdSize]byte) map[[kbucket.IdSize]byte][]kbucket.Node {
+	ordered := append([][kbucket.IdSize]byte(nil), targets...)
+	sort.Slice(ordered, func(i, j int) bool { return bytes.Compare(ordered[i][:], ordered[j][:]) < 0 })
+	results := make(map[[kbucket.IdSize]byte][]kbucket.Node, len(targets))
+	trace := NewTraceID() // 整批查找属于同一次操作
+	for _, target := range ordered {
+		if _, ok := results[target]; !ok {
+			results[target] = e.lookup(target, trace)
+		}
+	}
+	return results
+}
@@ -0,0 +1,105 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 协议层错误码，随响应返回给请求方
+type ErrorCode uint8
+
+const (
+	CodeOK ErrorCode = iota
+	CodeBusy
+	CodeTooBig
+	CodeUnauthorized
+	CodeBadToken
+	CodeUnsupported
+	CodeTooFar
+)
+
+var (
+	ErrBusy         = errors.New("dht: peer busy")
+	ErrTooBig       = errors.New("dht: request too big")
+	ErrUnauthorized = errors.New("dht: unauthorized")
+	ErrBadToken     = errors.New("dht: bad token")
+	ErrUnsupported  = errors.New("dht: unsupported request")
+	ErrTooFar       = errors.New("dht: key too far from peer")
+)
+
+var codeErrors = map[ErrorCode]error{
+	CodeBusy:         ErrBusy,
+	CodeTooBig:       ErrTooBig,
+	CodeUnauthorized: ErrUnauthorized,
+	CodeBadToken:     ErrBadToken,
+	CodeUnsupported:  ErrUnsupported,
+	CodeTooFar:       ErrTooFar,
+}
+
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeOK:
+		return "OK"
+	case CodeBusy:
+		return "BUSY"
+	case CodeTooBig:
+		return "TOO_BIG"
+	case CodeUnauthorized:
+		return "UNAUTHORIZED"
+	case CodeBadToken:
+		return "BAD_TOKEN"
+	case CodeUnsupported:
+		return "UNSUPPORTED"
+	case CodeTooFar:
+		return "TOO_FAR"
+	}
+	return fmt.Sprintf("ErrorCode(%d)", uint8(c))
+}
+
+// 远端返回的错误，可以用 errors.Is 与 ErrBusy 等哨兵错误比较
+type RPCError struct {
+	Code       ErrorCode
+	Message    string
+	RetryAfter time.Duration // BUSY 时远端建议的重试等待时间，0 表示没有建议
+}
+
+func (e *RPCError) Error() string {
+	s := e.Code.String()
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.RetryAfter > 0 {
+		s += fmt.Sprintf(" (retry after %v)", e.RetryAfter)
+	}
+	return s
+}
+
+func (e *RPCError) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// 将响应中的错误码转换为 Go 错误，CodeOK 返回 nil
+func ErrorFromCode(code ErrorCode, message string) error {
+	if code == CodeOK {
+		return nil
+	}
+	return &RPCError{Code: code, Message: message}
+}
+
+// 将错误转换为响应中携带的错误码，无法归类的错误返回 CodeUnsupported
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	for code, sentinel := range codeErrors {
+		if errors.Is(err, sentinel) {
+			return code
+		}
+	}
+	return CodeUnsupported
+}
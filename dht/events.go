@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+type StoreEventType int
+
+const (
+	ValueStored   StoreEventType = iota // 新值写入本地存储
+	ValueExpired                        // 值过期被删除
+	ValueEvicted                        // 因容量限制被淘汰
+	ValueRepaired                       // 通过反熵同步或 RANGE_SYNC 补齐
+)
+
+func (t StoreEventType) String() string {
+	switch t {
+	case ValueStored:
+		return "ValueStored"
+	case ValueExpired:
+		return "ValueExpired"
+	case ValueEvicted:
+		return "ValueEvicted"
+	case ValueRepaired:
+		return "ValueRepaired"
+	}
+	return "Unknown"
+}
+
+// 本地存储发生的变化，应用可以据此维护二级索引或审计日志
+type StoreEvent struct {
+	Type StoreEventType
+	Key  [kbucket.IdSize]byte
+	Time time.Time
+}
+
+// 订阅本地存储事件。订阅者消费过慢时，缓冲区满后的事件会被丢弃而不会阻塞存储
+func (p *Peer) SubscribeStore(buffer int) <-chan StoreEvent {
+	ch := make(chan StoreEvent, buffer)
+	p.storeMu.Lock()
+	p.storeSubs = append(p.storeSubs, ch)
+	p.storeMu.Unlock()
+	return ch
+}
+
+// 取消订阅并关闭对应的 channel
+func (p *Peer) UnsubscribeStore(sub <-chan StoreEvent) {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+	for i, ch := range p.storeSubs {
+		if ch == sub {
+			p.storeSubs = append(p.storeSubs[:i], p.storeSubs[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+func (p *Peer) emitStore(typ StoreEventType, key [kbucket.IdSize]byte) {
+	if (typ == ValueStored || typ == ValueRepaired) && p.hooks != nil && p.hooks.OnStore != nil {
+		p.hooks.OnStore(p, key)
+	}
+	// 发送不会阻塞，持有读锁发送，UnsubscribeStore 不会关闭正在发送的 channel
+	p.storeMu.RLock()
+	defer p.storeMu.RUnlock()
+	if len(p.storeSubs) == 0 {
+		return
+	}
+	ev := StoreEvent{Type: typ, Key: key, Time: time.Now()}
+	for _, ch := range p.storeSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
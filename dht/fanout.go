@@ -0,0 +1,130 @@
+package dht
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 比较各副本返回的值的结果，见 Config.ReadFanout
+const (
+	ReadConsistent = "consistent" // 所有副本返回相同的值
+	ReadStale      = "stale"      // 同一发布者的签名记录序号不同，部分副本尚未更新
+	ReadDivergent  = "divergent"  // 副本返回了互不相同的值
+	ReadInvalid    = "invalid"    // 副本只返回了没有通过检查的值
+)
+
+// 可选的 Metrics 扩展，SetMetrics 设置的实现同时实现它时统计 ReadFanout 的副本比较结果。
+// replicas 为返回有效值的副本数，invalid 为返回无效值的副本数
+type ReadMetrics interface {
+	ReadVerified(outcome string, replicas, invalid int)
+}
+
+// ReadFanout 副本比较的累计次数
+type ReadVerification struct {
+	Reads           uint64 // 比较过的读取次数
+	Consistent      uint64
+	Stale           uint64
+	Divergent       uint64
+	Invalid         uint64
+	InvalidReplicas uint64 // 返回无效值的副本总数，可能是数据损坏或投毒
+}
+
+type readStats struct {
+	mu sync.Mutex
+	ReadVerification
+}
+
+// 到目前为止的副本比较统计
+func (p *Peer) ReadVerification() ReadVerification {
+	p.reads.mu.Lock()
+	defer p.reads.mu.Unlock()
+	return p.reads.ReadVerification
+}
+
+// 比较收到的有效值，记录结果
+func (p *Peer) verifyReplicas(found []sourcedValue, invalid int) {
+	if len(found) == 0 && invalid == 0 {
+		return
+	}
+	outcome := replicaOutcome(found)
+	s := &p.reads
+	s.mu.Lock()
+	s.Reads++
+	switch outcome {
+	case ReadConsistent:
+		s.Consistent++
+	case ReadStale:
+		s.Stale++
+	case ReadDivergent:
+		s.Divergent++
+	case ReadInvalid:
+		s.Invalid++
+	}
+	s.InvalidReplicas += uint64(invalid)
+	s.mu.Unlock()
+	if m, ok := p.metrics.(ReadMetrics); ok {
+		m.ReadVerified(outcome, len(found), invalid)
+	}
+}
+
+// 值不同但都是同一发布者的签名记录时视为副本过时，否则视为分歧
+func replicaOutcome(found []sourcedValue) string {
+	if len(found) == 0 {
+		return ReadInvalid
+	}
+	first := found[0].value
+	same := true
+	for _, f := range found[1:] {
+		same = same && bytes.Equal(f.value, first)
+	}
+	if same {
+		return ReadConsistent
+	}
+	pub, _, _, err := OpenRecord(first)
+	if err != nil {
+		return ReadDivergent
+	}
+	for _, f := range found[1:] {
+		other, _, _, err := OpenRecord(f.value)
+		if err != nil || !pub.Equal(other) {
+			return ReadDivergent
+		}
+	}
+	return ReadStale
+}
+
+// 询问至多 ReadFanout 个副本并返回其中的一个值：有 Selector 时由它选择，
+// 否则选择返回最多的值，次数相同时选择先收到的
+func (p *Peer) verifiedLookup(key [kbucket.IdSize]byte, budget *lookupBudget) []byte {
+	found := p.collectValues(key, budget)
+	if len(found) == 0 {
+		return nil
+	}
+	var values [][]byte
+	var counts []int
+	for _, f := range found {
+		i := 0
+		for i < len(values) && !bytes.Equal(values[i], f.value) {
+			i++
+		}
+		if i == len(values) {
+			values = append(values, f.value)
+			counts = append(counts, 0)
+		}
+		counts[i]++
+	}
+	if len(values) > 1 && p.selector != nil {
+		if i, err := p.selector.Select(key, values); err == nil && i >= 0 && i < len(values) {
+			return values[i]
+		}
+	}
+	best := 0
+	for i := range values {
+		if counts[i] > counts[best] {
+			best = i
+		}
+	}
+	return values[best]
+}
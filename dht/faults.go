@@ -0,0 +1,96 @@
+package dht
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 注入到节点内部的故障，供嵌入本包的应用在测试中确定性地触发错误路径。
+// 零值表示没有故障，所有方法都可以在多个 goroutine 中同时调用
+type Faults struct {
+	mu       sync.Mutex
+	storeErr error                         // 本地存储写入返回的错误
+	timeouts map[[kbucket.IdSize]byte]bool // 无法联系的节点
+	forged   []Contact                     // 代替 FIND_NODE 回复的联系人
+
+	skew atomic.Int64 // 节点时钟相对系统时钟的偏移（纳秒）
+}
+
+// 节点的故障注入点
+func (p *Peer) Faults() *Faults {
+	return &p.faults
+}
+
+// 之后的本地存储写入（包括收到的 STORE）都以 err 失败，nil 表示恢复正常。
+// 收到的 STORE 按 CodeOf(err) 回复错误码
+func (f *Faults) FailStores(err error) {
+	f.mu.Lock()
+	f.storeErr = err
+	f.mu.Unlock()
+}
+
+// 发往 id 的请求超时，来自 id 的消息被丢弃，直到调用 Heal
+func (f *Faults) Timeout(id [kbucket.IdSize]byte) {
+	f.mu.Lock()
+	if f.timeouts == nil {
+		f.timeouts = make(map[[kbucket.IdSize]byte]bool)
+	}
+	f.timeouts[id] = true
+	f.mu.Unlock()
+}
+
+// 恢复与 id 的通信
+func (f *Faults) Heal(id [kbucket.IdSize]byte) {
+	f.mu.Lock()
+	delete(f.timeouts, id)
+	f.mu.Unlock()
+}
+
+// 之后节点通过进程内的 Messenger 回复 FIND_NODE 时返回 contacts 而不是它最近的节点，
+// 模拟用伪造联系人污染路由表的恶意节点；为空时恢复正常
+func (f *Faults) ForgeContacts(contacts []Contact) {
+	f.mu.Lock()
+	f.forged = append([]Contact(nil), contacts...)
+	f.mu.Unlock()
+}
+
+// 把节点的时钟向前拨 d（d 为负时向后拨）。影响记录的过期与重新发布、否定缓存
+func (f *Faults) JumpClock(d time.Duration) {
+	f.skew.Add(int64(d))
+}
+
+// 清除所有故障并恢复时钟
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	f.storeErr = nil
+	f.timeouts = nil
+	f.forged = nil
+	f.mu.Unlock()
+	f.skew.Store(0)
+}
+
+func (f *Faults) storeError() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.storeErr
+}
+
+func (f *Faults) forgedContacts() []Contact {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.forged
+}
+
+func (f *Faults) timedOut(id [kbucket.IdSize]byte) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.timeouts[id]
+}
+
+// 节点的当前时间，包括 JumpClock 的偏移
+func (p *Peer) now() time.Time {
+	return time.Now().Add(time.Duration(p.faults.skew.Load()))
+}
@@ -0,0 +1,65 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func TestFaultsStoreError(t *testing.T) {
+	peers := newTestNetwork(16, 2)
+	ctx := context.Background()
+	value := []byte("fault-store")
+	key := KeyFromBytes(value)
+	for _, p := range peers {
+		p.Faults().FailStores(ErrBusy)
+	}
+	if n, _ := peers[0].SetValue(ctx, key[:], value); n != 0 {
+		t.Fatalf("SetValue placed %d replicas with failing stores", n)
+	}
+	if code, _ := peers[1].offerStore(key, value, NewTraceID(), Provenance{}); code != CodeBusy {
+		t.Fatalf("offerStore = %v, want BUSY", code)
+	}
+	for _, p := range peers {
+		p.Faults().Reset()
+	}
+	if n, _ := peers[0].SetValue(ctx, key[:], value); n == 0 {
+		t.Fatal("SetValue failed after Reset")
+	}
+}
+
+func TestFaultsTimeout(t *testing.T) {
+	p, q := NewPeer(KeyFromString("fault-p")), NewPeer(KeyFromString("fault-q"))
+	node := kbucket.Node{ID: q.ID(), Data: q}
+	p.Faults().Timeout(q.ID())
+	if p.ping(node) {
+		t.Fatal("ping succeeded to a timed-out peer")
+	}
+	p.kb.InsertNode(node)
+	if got, _ := p.Lookup(context.Background(), KeyFromString("target")); len(got) != 0 {
+		t.Fatalf("Lookup returned %d nodes through a timed-out peer", len(got))
+	}
+	p.Faults().Heal(q.ID())
+	if !p.ping(node) {
+		t.Fatal("ping failed after Heal")
+	}
+}
+
+func TestFaultsJumpClock(t *testing.T) {
+	p := NewPeer(KeyFromString("fault-clock"))
+	value := []byte("fault-clock")
+	key := KeyFromBytes(value)
+	p.SetValue(context.Background(), key[:], value)
+	if n := p.ExpireRecords(); n != 0 {
+		t.Fatalf("ExpireRecords = %d before the clock jump", n)
+	}
+	p.Faults().JumpClock(p.cfg.RecordTTL + time.Second)
+	if _, err := p.GetValue(context.Background(), key); err != ErrNotFound {
+		t.Fatal("record still readable after its TTL")
+	}
+	if n := p.ExpireRecords(); n != 1 {
+		t.Fatalf("ExpireRecords = %d, want 1", n)
+	}
+}
@@ -0,0 +1,78 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	FindNodePopularity = 8    // 一个目标在 FindNodeCacheTTL 内收到多少次 FIND_NODE 后缓存响应
+	FindNodeCacheSize  = 1024 // 最多跟踪的 FIND_NODE 目标数
+)
+
+// 一个 FIND_NODE 目标的查询次数与缓存的响应
+type findEntry struct {
+	since   time.Time // 本次计数开始的时间
+	hits    int
+	count   int            // nodes 按多少个节点计算
+	nodes   []kbucket.Node // 缓存的响应，为 nil 表示还不够热门
+	expires time.Time
+}
+
+// 回复 FIND_NODE 的 count 个最近节点。热门目标的结果在 FindNodeCacheTTL 内共用，
+// 调用方不能修改返回的切片
+func (p *Peer) findNodeResponse(target [kbucket.IdSize]byte, count int) []kbucket.Node {
+	ttl := p.cfg.FindNodeCacheTTL
+	if ttl <= 0 {
+		return p.kb.FindClosestNodes(target, count)
+	}
+	now := p.now()
+	p.findMu.Lock()
+	e := p.findCache[target]
+	if e != nil && e.nodes != nil && e.count == count && now.Before(e.expires) {
+		p.findMu.Unlock()
+		return e.nodes
+	}
+	popular := p.countFindLocked(target, e, now, ttl)
+	p.findMu.Unlock()
+	nodes := p.kb.FindClosestNodes(target, count)
+	if popular {
+		p.findMu.Lock()
+		if e := p.findCache[target]; e != nil {
+			e.nodes, e.count, e.expires = nodes, count, now.Add(ttl)
+		}
+		p.findMu.Unlock()
+	}
+	return nodes
+}
+
+// 记录一次对 target 的查询，返回它是否已经足够热门。调用方需持有 findMu
+func (p *Peer) countFindLocked(target [kbucket.IdSize]byte, e *findEntry, now time.Time, ttl time.Duration) bool {
+	if e == nil {
+		if p.findCache == nil {
+			p.findCache = make(map[[kbucket.IdSize]byte]*findEntry)
+		}
+		if len(p.findCache) >= FindNodeCacheSize && p.expireFindsLocked(now, ttl) == 0 {
+			return false // 跟踪的目标已满且都还在计数，不再跟踪新的目标
+		}
+		e = &findEntry{since: now}
+		p.findCache[target] = e
+	} else if now.Sub(e.since) >= ttl {
+		e.since, e.hits = now, 0
+	}
+	e.hits++
+	return e.hits >= FindNodePopularity
+}
+
+// 删除计数周期与缓存都已过期的目标，返回删除的数量。调用方需持有 findMu
+func (p *Peer) expireFindsLocked(now time.Time, ttl time.Duration) int {
+	n := 0
+	for target, e := range p.findCache {
+		if now.Sub(e.since) >= ttl && !now.Before(e.expires) {
+			delete(p.findCache, target)
+			n++
+		}
+	}
+	return n
+}
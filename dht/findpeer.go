@@ -0,0 +1,22 @@
+package dht
+
+import (
+	"context"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 查找 id 对应节点的最新联系记录：以 id 为目标执行迭代查找（FIND_NODE），
+// 进程内与网络中的节点都会被询问。查找联系到了节点本身时返回刚刚确认的记录，
+// 否则返回本地路由表中的记录
+func (p *Peer) FindPeer(id [kbucket.IdSize]byte) (kbucket.Node, bool) {
+	for _, n := range p.lookup(id, p.newLookupBudget(context.Background())) {
+		if n.ID == id { // 只返回联系成功的节点，响应方已由 Messenger 记入路由表
+			n.LastSeen = time.Now()
+			p.kb.MarkSeen(id, n.LastSeen)
+			return n, true
+		}
+	}
+	return p.kb.GetBucket(p.kb.BucketIndex(id)).FindNode(id)
+}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"context"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// Kademlia FIND_VALUE：与 Lookup 一样迭代地查询更近的节点，任一节点持有该值时
+// 立即结束，并把值缓存到查询过的、没有该值的最近节点上，使热点 key 的后续查找
+// 更早命中。找到值时返回该值；否则返回距离 key 最近的至多 K 个节点。
+// 查找被中断时返回已查询过的最近节点以及 ctx.Err() 或 ErrLookupDepthExceeded
+func (p *Peer) FindValue(ctx context.Context, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	if value, ok := p.store.get(key); ok {
+		return value, nil, nil
+	}
+	budget := p.newLookupBudget(ctx)
+	value, closest := p.findValue(key, budget)
+	if value == nil && budget.err != nil {
+		return nil, contactsOf(closest), budget.err
+	}
+	return value, contactsOf(closest), nil
+}
+
+func (p *Peer) findValue(key [kbucket.IdSize]byte, budget *lookupBudget) ([]byte, []kbucket.Node) {
+	value, _, closest := p.findValueAt(key, budget)
+	return value, closest
+}
+
+// 找到值时同时返回持有该值的节点，未找到时返回最近的节点
+func (p *Peer) findValueAt(key [kbucket.IdSize]byte, budget *lookupBudget) ([]byte, *Contact, []kbucket.Node) {
+	var value []byte
+	closest, holder := p.iterate(key, budget, OpFindValue, p.findValueQuery(key, &value))
+	if holder == nil {
+		return nil, nil, closest
+	}
+	p.cacheNearest(key, value, holder.ID, closest, budget)
+	return value, holder, nil
+}
+
+// FIND_VALUE 查询：响应中的值通过校验时结束查找并写入 *value
+func (p *Peer) findValueQuery(key [kbucket.IdSize]byte, value *[]byte) iterQuery {
+	return func(ctx context.Context, m Messenger, c Contact) iterReply {
+		v, nodes, err := m.FindValue(ctx, c, key)
+		return func() ([]Contact, bool, error) {
+			if err == nil && v != nil {
+				if err := p.validate(key, v); err != nil { // 返回无效记录的节点视为查询失败
+					return nil, false, err
+				}
+				*value = v
+				return nil, true, nil
+			}
+			return nodes, false, err
+		}
+	}
+}
+
+// 把 value 缓存到 closest 中最近的、不是持有者的节点上
+func (p *Peer) cacheNearest(key [kbucket.IdSize]byte, value []byte, holder [kbucket.IdSize]byte, closest []kbucket.Node, budget *lookupBudget) {
+	for _, node := range closest { // 按距离排序，第一个不是持有者的节点就是最近的未命中节点
+		if node.ID == holder {
+			continue
+		}
+		c := contactOf(node)
+		if m := p.messengerFor(c); m != nil {
+			m.Store(ContextWithTrace(budget.ctx, budget.trace), c, key, value)
+		}
+		return
+	}
+}
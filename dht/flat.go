@@ -0,0 +1,41 @@
+package dht
+
+import (
+	"context"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 小网络建议使用的 Config.FlatTable，足够容纳约 200 个节点的网络
+const DefaultFlatTableSize = 256
+
+// 扁平路由表模式下认识网络中的全部节点。按前缀划分 keyspace：查找一个区域内的 ID，
+// 返回的 K 个最近节点都在区域内时区域内可能还有其他节点，把区域分成两半分别查找。
+// 查找中联系过的节点由 Messenger 加入路由表，它们也因此认识了本节点
+func (p *Peer) learnAll(ctx context.Context) {
+	type region struct {
+		target [kbucket.IdSize]byte // 区域内的一个 ID，区域为与它共享前 bits 位的部分
+		bits   int
+		found  []kbucket.Node // 已经查找过 target 时为查找结果
+	}
+	stack := []region{{target: p.node.ID}}
+	for len(stack) > 0 && ctx.Err() == nil && p.kb.Size() < p.cfg.FlatTable {
+		r := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if r.found == nil {
+			r.found = p.lookup(r.target, p.newLookupBudget(ctx))
+		}
+		inside := 0
+		for _, n := range r.found {
+			if kbucket.CommonPrefixLen(n.ID, r.target) >= r.bits {
+				inside++
+			}
+		}
+		if inside < p.cfg.K || r.bits == kbucket.IdSize*8 { // 区域内的节点已经全部找到
+			continue
+		}
+		other := r.target
+		other[r.bits/8] ^= 0x80 >> (r.bits % 8)
+		stack = append(stack, region{other, r.bits + 1, nil}, region{r.target, r.bits + 1, r.found})
+	}
+}
@@ -0,0 +1,155 @@
+package dht
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultProbeRate          = 1                // 后台每秒最多 ping 的联系人数
+	DefaultFreshnessThreshold = 15 * time.Minute // 建议的 Config.FreshnessThreshold
+)
+
+// FreshnessReport.Ages 的区间上界
+var freshnessBounds = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+// 可选的 Metrics 扩展，SetMetrics 设置的实现同时实现它时统计后台 ping 的结果
+// 与联系人的新鲜度分布，见 Config.FreshnessThreshold
+type FreshnessMetrics interface {
+	ContactProbed(ok bool)
+	ContactFreshness(r FreshnessReport) // 每次后台 ping 之后的新鲜度分布
+}
+
+// 距上次确认存活不超过 UpTo 的联系人数（不含更小区间的联系人），最后一个区间的 UpTo 为 0，
+// 表示更久或从未确认存活
+type FreshnessBucket struct {
+	UpTo     time.Duration
+	Contacts int
+}
+
+// 路由表联系人的新鲜度
+type FreshnessReport struct {
+	Time       time.Time
+	Contacts   int
+	Unverified int               // 从未确认存活的联系人数
+	Stale      int               // 已失效、等待清理的联系人数
+	Due        int               // 超过 FreshnessThreshold 等待 ping 的联系人数
+	Ages       []FreshnessBucket // 距上次确认存活的时间的分布
+	Median     time.Duration     // 已确认存活的联系人距上次确认存活的时间的中位数
+	Oldest     time.Duration
+
+	Probes        uint64 // 后台 ping 的累计次数
+	ProbeFailures uint64
+}
+
+// 后台 ping 的状态
+type prober struct {
+	mu       sync.Mutex
+	tried    map[[kbucket.IdSize]byte]time.Time // ping 失败的联系人上次 ping 的时间
+	probes   uint64
+	failures uint64
+}
+
+// 联系人在 now 时是否需要 ping，返回排序用的时间：上次确认存活与上次 ping 失败中较晚的一个
+func (p *Peer) probeDue(n kbucket.Node, now time.Time) (time.Time, bool) {
+	if p.cfg.FreshnessThreshold <= 0 || p.kb.IsStale(n) { // 失效的联系人交给 HealthCheck 清理
+		return time.Time{}, false
+	}
+	last := n.LastSeen
+	if t := p.fresh.tried[n.ID]; t.After(last) {
+		last = t
+	}
+	return last, now.Sub(last) >= p.cfg.FreshnessThreshold
+}
+
+// ping 最久没有确认存活、且超过 FreshnessThreshold 的一个联系人。失败的联系人累计连续
+// 失败次数，至少再等一个 FreshnessThreshold 才会再次 ping。由 Start 的后台循环按
+// ProbeRate 调用，也可以手动调用。返回是否 ping 了联系人
+func (p *Peer) ProbeTick() bool {
+	now := time.Now()
+	nodes := p.kb.AllNodes()
+	p.fresh.mu.Lock()
+	var target kbucket.Node
+	var oldest time.Time
+	found := false
+	present := make(map[[kbucket.IdSize]byte]bool, len(nodes))
+	for _, n := range nodes {
+		present[n.ID] = true
+		if last, due := p.probeDue(n, now); due && (!found || last.Before(oldest)) {
+			target, oldest, found = n, last, true
+		}
+	}
+	for id := range p.fresh.tried { // 已离开路由表的联系人
+		if !present[id] {
+			delete(p.fresh.tried, id)
+		}
+	}
+	p.fresh.mu.Unlock()
+	ok := false
+	if found {
+		ok = p.ping(target)
+		p.fresh.mu.Lock()
+		p.fresh.probes++
+		if ok {
+			delete(p.fresh.tried, target.ID)
+		} else {
+			p.fresh.failures++
+			if p.fresh.tried == nil {
+				p.fresh.tried = make(map[[kbucket.IdSize]byte]time.Time)
+			}
+			p.fresh.tried[target.ID] = time.Now()
+		}
+		p.fresh.mu.Unlock()
+		if ok { // 失败由 ping 记录
+			p.kb.MarkSeen(target.ID, time.Now())
+		}
+	}
+	if m, isFresh := p.metrics.(FreshnessMetrics); isFresh {
+		if found {
+			m.ContactProbed(ok)
+		}
+		m.ContactFreshness(p.Freshness())
+	}
+	return found
+}
+
+// 统计路由表联系人的新鲜度
+func (p *Peer) Freshness() FreshnessReport {
+	now := time.Now()
+	r := FreshnessReport{Time: now, Ages: make([]FreshnessBucket, len(freshnessBounds)+1)}
+	for i, b := range freshnessBounds {
+		r.Ages[i].UpTo = b
+	}
+	var ages []time.Duration
+	nodes := p.kb.AllNodes()
+	p.fresh.mu.Lock()
+	defer p.fresh.mu.Unlock()
+	for _, n := range nodes {
+		r.Contacts++
+		if p.kb.IsStale(n) {
+			r.Stale++
+		}
+		if _, due := p.probeDue(n, now); due {
+			r.Due++
+		}
+		if n.LastSeen.IsZero() {
+			r.Unverified++
+			r.Ages[len(freshnessBounds)].Contacts++
+			continue
+		}
+		age := now.Sub(n.LastSeen)
+		ages = append(ages, age)
+		i := sort.Search(len(freshnessBounds), func(i int) bool { return age <= freshnessBounds[i] })
+		r.Ages[i].Contacts++
+	}
+	if len(ages) > 0 {
+		sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+		r.Median = ages[len(ages)/2]
+		r.Oldest = ages[len(ages)-1]
+	}
+	r.Probes, r.ProbeFailures = p.fresh.probes, p.fresh.failures
+	return r
+}
@@ -0,0 +1,104 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+)
+
+const gatewayBuffer = 1024 // 网关订阅存储事件的缓冲区大小
+
+// 记录所属的命名空间：值中第一个 '/' 之前的部分，没有 '/' 时为空。
+// 与 CRDT 记录的 "namespace/name" 约定一致
+func Namespace(value []byte) string {
+	if i := bytes.IndexByte(value, '/'); i >= 0 {
+		return string(value[:i])
+	}
+	return ""
+}
+
+// 把一个 DHT 中新保存的记录重新发布到另一个 DHT，用于在网络之间分阶段迁移数据。
+// 网关订阅 From 的存储事件，只转发 Namespaces 中的记录（为空时转发全部），
+// 转发前可以用 Transform 改写记录；由于 key 是值的哈希，改写值也就改写了 key
+type Gateway struct {
+	From, To   *Peer
+	Namespaces []string
+	Transform  func(value []byte) ([]byte, bool) // 返回 false 时跳过该记录，nil 表示原样转发
+
+	Forwarded int // 成功发布到 To 的记录数
+	Skipped   int // 被过滤或发布失败的记录数
+
+	events <-chan StoreEvent
+}
+
+func NewGateway(from, to *Peer, namespaces ...string) *Gateway {
+	return &Gateway{From: from, To: to, Namespaces: namespaces, events: from.SubscribeStore(gatewayBuffer)}
+}
+
+// 转发目前已收到的所有事件，返回转发的记录数。由调用方周期性调用
+func (g *Gateway) Tick() int {
+	n := 0
+	for {
+		select {
+		case ev := <-g.events:
+			if g.forward(ev) {
+				n++
+			}
+		default:
+			return n
+		}
+	}
+}
+
+// 持续转发直到 ctx 结束
+func (g *Gateway) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-g.events:
+			g.forward(ev)
+		}
+	}
+}
+
+// 停止订阅 From 的存储事件
+func (g *Gateway) Close() {
+	g.From.UnsubscribeStore(g.events)
+}
+
+func (g *Gateway) forward(ev StoreEvent) bool {
+	if ev.Type != ValueStored && ev.Type != ValueRepaired {
+		return false
+	}
+	value, ok := g.From.store.get(ev.Key)
+	if !ok || !g.selected(value) {
+		g.Skipped++
+		return false
+	}
+	if g.Transform != nil {
+		if value, ok = g.Transform(value); !ok {
+			g.Skipped++
+			return false
+		}
+	}
+	key := KeyFromBytes(value)
+	if n, err := g.To.SetValue(context.Background(), key[:], value); n == 0 || err != nil {
+		g.Skipped++
+		return false
+	}
+	g.Forwarded++
+	return true
+}
+
+func (g *Gateway) selected(value []byte) bool {
+	if len(g.Namespaces) == 0 {
+		return true
+	}
+	ns := Namespace(value)
+	for _, n := range g.Namespaces {
+		if n == ns {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package dht
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	snapshotPrefix     = "snapshot-"
+	snapshotSuffix     = ".kbs"
+	snapshotTimeLayout = "20060102T150405.000000000Z"
+)
+
+// 在目录中按时间戳保存多代快照，只保留最近 Keep 代
+type SnapshotStore struct {
+	Dir  string
+	Keep int
+}
+
+type SnapshotGeneration struct {
+	Path string
+	Time time.Time
+}
+
+func NewSnapshotStore(dir string, keep int) *SnapshotStore {
+	if keep < 1 {
+		keep = 1
+	}
+	return &SnapshotStore{Dir: dir, Keep: keep}
+}
+
+// 保存新一代快照并清理超出保留数量的旧快照
+func (s *SnapshotStore) Save(p *Peer) (SnapshotGeneration, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return SnapshotGeneration{}, err
+	}
+	now := time.Now().UTC()
+	gen := SnapshotGeneration{
+		Path: filepath.Join(s.Dir, snapshotPrefix+now.Format(snapshotTimeLayout)+snapshotSuffix),
+		Time: now,
+	}
+	if err := p.SaveSnapshot(gen.Path); err != nil {
+		return SnapshotGeneration{}, err
+	}
+	return gen, s.prune()
+}
+
+// 列出目录中的快照，最新的排在前面
+func (s *SnapshotStore) Generations() ([]SnapshotGeneration, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var gens []SnapshotGeneration
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		gens = append(gens, SnapshotGeneration{Path: filepath.Join(s.Dir, name), Time: t})
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].Time.After(gens[j].Time) })
+	return gens, nil
+}
+
+func (s *SnapshotStore) prune() error {
+	gens, err := s.Generations()
+	if err != nil {
+		return err
+	}
+	for i := s.Keep; i < len(gens); i++ {
+		if err := os.Remove(gens[i].Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从指定的一代快照恢复
+func (s *SnapshotStore) Restore(p *Peer, gen SnapshotGeneration, resolve func(id [kbucket.IdSize]byte) interface{}) error {
+	return p.LoadSnapshot(gen.Path, resolve)
+}
+
+// 从最新的可用快照恢复，遇到损坏的快照时依次回退到更早的一代
+func (s *SnapshotStore) Load(p *Peer, resolve func(id [kbucket.IdSize]byte) interface{}) error {
+	gens, err := s.Generations()
+	if err != nil {
+		return err
+	}
+	if len(gens) == 0 {
+		return os.ErrNotExist
+	}
+	var first error
+	for _, gen := range gens {
+		err := s.Restore(p, gen, resolve)
+		if err == nil || errors.Is(err, ErrSnapshotMismatch) {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// 超出 Keep 的旧快照被清理，Generations 按时间从新到旧返回
+func TestSnapshotGenerationsPrune(t *testing.T) {
+	s := NewSnapshotStore(t.TempDir(), 2)
+	p := NewPeer(KeyFromString("generations-prune"))
+	var saved []SnapshotGeneration
+	for i := 0; i < 4; i++ {
+		gen, err := s.Save(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, gen)
+	}
+	gens, err := s.Generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gens) != 2 || gens[0].Path != saved[3].Path || gens[1].Path != saved[2].Path {
+		t.Fatalf("Generations = %v, want the two newest saves", gens)
+	}
+	if _, err := os.Stat(saved[0].Path); !os.IsNotExist(err) {
+		t.Fatalf("oldest generation was not pruned: %v", err)
+	}
+}
+
+// 最新一代损坏时 Load 回退到上一代；Restore 可以选择任意一代
+func TestSnapshotGenerationsFallback(t *testing.T) {
+	peers := newTestNetwork(4, 8)
+	id := KeyFromString("generations-fallback")
+	s := NewSnapshotStore(t.TempDir(), 3)
+	if err := s.Load(NewPeer(id), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load from an empty store = %v, want os.ErrNotExist", err)
+	}
+	old, err := s.Save(snapshotPeer(t, id, peers, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := s.Save(snapshotPeer(t, id, peers, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPeer(id)
+	if err := s.Load(p, resolvePeers(peers)); err != nil || p.store.len() != 6 {
+		t.Fatalf("Load = %v with %d records, want the latest generation", err, p.store.len())
+	}
+	p = NewPeer(id)
+	if err := s.Restore(p, old, nil); err != nil || p.store.len() != 2 {
+		t.Fatalf("Restore(old) = %v with %d records, want 2", err, p.store.len())
+	}
+
+	if err := os.WriteFile(latest.Path, []byte("not a snapshot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p = NewPeer(id)
+	if err := s.Load(p, resolvePeers(peers)); err != nil || p.store.len() != 2 || p.kb.Size() == 0 {
+		t.Fatalf("Load after corrupting the latest = %v with %d records, want the previous generation", err, p.store.len())
+	}
+	if err := s.Load(NewPeer(KeyFromString("generations-other")), nil); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("Load into another node = %v, want ErrSnapshotMismatch", err)
+	}
+}
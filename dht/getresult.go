@@ -0,0 +1,225 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// Get 的结果状态
+type GetStatus int
+
+const (
+	StatusNotFound   GetStatus = iota // 查找完成，没有节点保存该 key
+	StatusFound                       // Value 为找到的记录
+	StatusExpired                     // 网络中没有有效的记录，只有本地已过期的记录，Value 为过期的值
+	StatusTombstoned                  // 发布者删除了记录，Value 为墓碑记录，见 SignTombstone
+	StatusConflict                    // 收到多个互不相同的有效记录且 Selector 无法选出一个，见 Values
+)
+
+func (s GetStatus) String() string {
+	switch s {
+	case StatusNotFound:
+		return "not found"
+	case StatusFound:
+		return "found"
+	case StatusExpired:
+		return "expired"
+	case StatusTombstoned:
+		return "tombstoned"
+	case StatusConflict:
+		return "conflict"
+	}
+	return fmt.Sprintf("GetStatus(%d)", int(s))
+}
+
+// 一次 Get 的结果
+type GetResult struct {
+	Status GetStatus
+	Value  []byte   // 选定的记录，StatusNotFound 与 StatusConflict 时为 nil
+	Values [][]byte // 收到的所有互不相同的有效记录，包括本地保存的
+	Source Contact  // 提供 Value 的节点，Value 来自本地存储时为本节点
+	Local  bool     // Value 来自本地存储
+
+	Received time.Time // Value 保存到本地或从 Source 收到的时间
+	Expires  time.Time // 本地记录的过期时间；零值表示不过期，来自其他节点时表示未知
+}
+
+// 收到的一个值及其来源
+type sourcedValue struct {
+	value    []byte
+	from     Contact
+	local    bool
+	received time.Time
+	expires  time.Time
+}
+
+// 读取 key 并用 GetResult 说明结果，未找到、已过期、已删除与冲突都不作为错误返回。
+// 内容寻址的记录找到一份即可；其他记录（例如签名的可变记录）会询问距离 key 最近的
+// 全部节点。设置了 Config.ReadFanout 时两种记录都询问到 ReadFanout 个副本为止。
+// 收到多个不同的值时由 Selector 选择，没有 Selector 或选择失败时返回
+// StatusConflict。只在查找被中断且没有收到任何记录时返回 ctx.Err() 或 ErrLookupDepthExceeded
+func (p *Peer) Get(ctx context.Context, key [kbucket.IdSize]byte) (GetResult, error) {
+	p.stats.record(key, false)
+	rec, live, ok := p.store.record(key)
+	p.metricStore(ok && live)
+	var found []sourcedValue
+	conflicted := false // 本地保留了并发的记录，Selector 选出一个之后保存它
+	if ok && live {
+		found = append(found, sourcedValue{
+			value:    rec.Value,
+			from:     Contact{ID: p.node.ID, Peer: p},
+			local:    true,
+			received: rec.Provenance.Received,
+			expires:  rec.Expires,
+		})
+		if contentAddressed(key, rec.Value) { // 内容寻址的记录不会冲突
+			return p.resolve(key, found, rec, false), nil
+		}
+		for _, v := range p.Conflicts(key) { // 本地保留的并发记录同样交给 Selector
+			found = append(found, sourcedValue{value: v, from: Contact{ID: p.node.ID, Peer: p}, local: true})
+			conflicted = true
+		}
+	} else if p.negativeCached(key) {
+		return p.resolve(key, nil, rec, ok), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return GetResult{}, err
+	}
+	if p.static {
+		found = append(found, p.staticCollect(key)...)
+		return p.settle(key, p.resolve(key, found, rec, ok && !live), conflicted), nil
+	}
+	b := p.newLookupBudget(ctx)
+	found = append(found, p.collectValues(key, b)...)
+	if len(found) == 0 {
+		if b.err != nil {
+			return GetResult{}, b.err
+		}
+		if b.missConfirmed() { // 查找被中断或没有节点回复时结果不可信，不做否定缓存
+			p.cacheMiss(key)
+		}
+	}
+	return p.settle(key, p.resolve(key, found, rec, ok && !live), conflicted), nil
+}
+
+// 本地保留了并发的记录而 Selector 选出了其中一个时，保存选出的记录并丢弃其余的
+func (p *Peer) settle(key [kbucket.IdSize]byte, r GetResult, conflicted bool) GetResult {
+	if conflicted && (r.Status == StatusFound || r.Status == StatusTombstoned) {
+		p.settleConflict(key, r.Value)
+	}
+	return r
+}
+
+// 迭代查找 key，收集各节点返回的有效值。收到内容寻址的值时立即结束，设置了
+// ReadFanout 时收到 ReadFanout 个值才结束并比较它们。提前结束时像 FindValue 一样
+// 把最后收到的值缓存到最近的未命中节点上
+func (p *Peer) collectValues(key [kbucket.IdSize]byte, budget *lookupBudget) []sourcedValue {
+	var found []sourcedValue
+	invalid := 0
+	fanout := p.cfg.ReadFanout
+	closest, holder := p.iterate(key, budget, OpFindValue, func(ctx context.Context, m Messenger, c Contact) iterReply {
+		v, nodes, err := m.FindValue(ctx, c, key)
+		received := time.Now()
+		return func() ([]Contact, bool, error) {
+			if err != nil || v == nil {
+				return nodes, false, err
+			}
+			if err := p.validate(key, v); err != nil { // 返回无效记录的节点视为查询失败
+				invalid++
+				return nil, false, err
+			}
+			found = append(found, sourcedValue{value: v, from: c, received: received})
+			if fanout > 1 {
+				return nil, len(found) >= fanout, nil
+			}
+			return nil, contentAddressed(key, v), nil
+		}
+	})
+	if holder != nil {
+		p.cacheNearest(key, found[len(found)-1].value, holder.ID, closest, budget)
+	}
+	if fanout > 1 {
+		p.verifyReplicas(found, invalid)
+	}
+	return found
+}
+
+// 静态成员模式下从距离 key 最近的成员收集值
+func (p *Peer) staticCollect(key [kbucket.IdSize]byte) []sourcedValue {
+	var found []sourcedValue
+	for _, m := range p.staticClosest(key, p.cfg.K) {
+		if value, ok := m.store.get(key); ok {
+			found = append(found, sourcedValue{value: value, from: Contact{ID: m.node.ID, Peer: m}, received: time.Now()})
+			if contentAddressed(key, value) {
+				break
+			}
+		}
+	}
+	return found
+}
+
+// 从收到的值中选出结果。没有收到值时，expired 表示本地的 rec 已经过期
+func (p *Peer) resolve(key [kbucket.IdSize]byte, found []sourcedValue, rec StoredRecord, expired bool) GetResult {
+	var distinct []sourcedValue
+	var values [][]byte
+	for _, f := range found {
+		dup := false
+		for _, v := range values {
+			if bytes.Equal(v, f.value) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			distinct = append(distinct, f)
+			values = append(values, f.value)
+		}
+	}
+	switch {
+	case len(distinct) == 0 && expired:
+		return GetResult{
+			Status:   StatusExpired,
+			Value:    rec.Value,
+			Source:   Contact{ID: p.node.ID, Peer: p},
+			Local:    true,
+			Received: rec.Provenance.Received,
+			Expires:  rec.Expires,
+		}
+	case len(distinct) == 0:
+		return GetResult{Status: StatusNotFound}
+	}
+	chosen := 0
+	if len(distinct) > 1 {
+		if p.selector == nil {
+			return GetResult{Status: StatusConflict, Values: values}
+		}
+		i, err := p.selector.Select(key, values)
+		if err != nil || i < 0 || i >= len(distinct) {
+			return GetResult{Status: StatusConflict, Values: values}
+		}
+		chosen = i
+	}
+	f := distinct[chosen]
+	status := StatusFound
+	if IsTombstone(f.value) {
+		status = StatusTombstoned
+	}
+	return GetResult{
+		Status:   status,
+		Value:    f.value,
+		Values:   values,
+		Source:   f.from,
+		Local:    f.local,
+		Received: f.received,
+		Expires:  f.expires,
+	}
+}
+
+// value 是否以自身的哈希为 key。这样的记录之间不会冲突，找到一份即可
+func contentAddressed(key [kbucket.IdSize]byte, value []byte) bool {
+	return KeyFromBytes(value) == key
+}
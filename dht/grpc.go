@@ -0,0 +1,1070 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/internal/protowire"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// 节点之间使用的协议
+type Protocol int
+
+const (
+	ProtocolUDP       Protocol = iota // 自定义的 UDP 报文（默认）
+	ProtocolGRPC                      // 基于 HTTP/2 与 TLS 的 gRPC，消息定义见 dht.proto
+	ProtocolWebSocket                 // 承载在 WebSocket 上的 UDP 报文，浏览器中的节点使用，见 WebSocketConn
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolUDP:
+		return "udp"
+	case ProtocolGRPC:
+		return "grpc"
+	case ProtocolWebSocket:
+		return "websocket"
+	}
+	return fmt.Sprintf("Protocol(%d)", int(p))
+}
+
+const (
+	grpcService     = "kbucket.dht.v1.DHT"
+	grpcTraceHeader = "Kbucket-Trace-Id"
+	grpcSigHeader   = "Kbucket-Signature-Bin" // 公钥 | 签名，gRPC 的二进制 metadata 以 base64 编码
+
+	grpcPriorityHeader = "Kbucket-Priority" // 低优先级的请求为 "low"，见 Priority
+)
+
+// gRPC 状态码
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+var (
+	ErrNoTLS       = errors.New("dht: grpc transport requires a TLS config")
+	ErrUnreachable = errors.New("dht: peer unreachable")     // 连接、TLS 握手或读取响应失败
+	ErrNotGRPC     = errors.New("dht: response is not grpc") // 响应没有 Grpc-Status，对方不是 gRPC 服务
+)
+
+// 按 Config.Protocol 在 addr 上监听并为 p 处理 RPC。ProtocolGRPC 需要 tlsConfig，
+// ProtocolWebSocket 在 tlsConfig 不为 nil 时使用 wss，ProtocolUDP 忽略它
+func Listen(p *Peer, addr string, tlsConfig *tls.Config) (io.Closer, error) {
+	switch p.cfg.Protocol {
+	case ProtocolGRPC:
+		return ListenGRPC(p, addr, tlsConfig)
+	case ProtocolWebSocket:
+		return ListenWebSocket(p, addr, tlsConfig)
+	}
+	return ListenUDP(p, addr)
+}
+
+// 基于 gRPC 的 Kademlia RPC，供需要经过 HTTP/2 基础设施或与其他语言的客户端
+// 互通的部署使用。它实现 Messenger 并在监听后成为节点联系网络中节点的方式。
+// 为了与 UDP 节点共用路由表，对方的 "host:port" 同样以 *net.UDPAddr 保存。
+// tlsConfig 同时用于服务端与客户端：需要包含本节点的证书，以及验证其他节点证书的
+// RootCAs（节点通常以 IP 地址联系，证书中需要有对应的 IP SAN）
+type GRPCTransport struct {
+	Timeout time.Duration // 等待没有测量过 RTT 的节点响应的时间，其他节点使用各自的 RTO，见 Config.MinRTO
+
+	// 出站连接空闲超过 KeepAlive 时发送 PING，KeepAliveTimeout 内没有响应就关闭到对方的连接，
+	// 等待中的请求立即失败。KeepAlive 同时作为 TCP 保活的间隔，不大于 0 时不发送 PING，
+	// 负数同时关闭 TCP 保活。应在第一次请求之前设置
+	KeepAlive        time.Duration
+	KeepAliveTimeout time.Duration
+
+	p      *Peer
+	ln     net.Listener
+	srv    *http.Server
+	client *http.Client
+	closed sync.Once // 只归还一次监听 socket 的配额
+	done   chan struct{}
+
+	keepAliveOnce sync.Once
+	connMu        sync.Mutex
+	conns         map[string]*grpcPeerConns // 出站连接，以对方的 "host:port" 为键
+}
+
+// 在 addr 上监听 gRPC 请求，并把节点的 Messenger 设为返回的传输层
+func ListenGRPC(p *Peer, addr string, tlsConfig *tls.Config) (*GRPCTransport, error) {
+	if tlsConfig == nil {
+		return nil, ErrNoTLS
+	}
+	if p.transport != nil || p.messenger != nil {
+		return nil, ErrTransportUp
+	}
+	if !p.acquire(ResourceSockets, "listen") {
+		return nil, ErrBusy
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		p.release(ResourceSockets)
+		return nil, err
+	}
+	t := &GRPCTransport{
+		Timeout:          DefaultRPCTimeout,
+		KeepAlive:        DefaultKeepAlive,
+		KeepAliveTimeout: DefaultKeepAliveTimeout,
+		p:                p,
+		ln:               ln,
+		done:             make(chan struct{}),
+		conns:            make(map[string]*grpcPeerConns),
+	}
+	t.client = &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   tlsConfig.Clone(),
+		ForceAttemptHTTP2: true,
+		DialContext:       p.dialLimited(t.dial),
+	}}
+	t.srv = &http.Server{Handler: t, TLSConfig: tlsConfig.Clone()}
+	go t.srv.ServeTLS(limitedListener{Listener: ln, p: p}, "", "")
+	p.SetMessenger(t)
+	return t, nil
+}
+
+func (t *GRPCTransport) Addr() net.Addr {
+	return t.ln.Addr()
+}
+
+func (t *GRPCTransport) Close() error {
+	if t.p.messenger == t {
+		t.p.SetMessenger(nil)
+	}
+	t.client.CloseIdleConnections()
+	err := t.srv.Close()
+	t.closed.Do(func() {
+		close(t.done)
+		t.p.release(ResourceSockets)
+	})
+	return err
+}
+
+// 请求中的公共字段，各请求的字段编号一致：sender = 1，key/target = 2，value = 3，
+// FindNode 的 with_hints = 4 与 with_keys = 9，Ping 的 info = 5
+type grpcRequest struct {
+	sender [kbucket.IdSize]byte
+	addr   string
+	key    [kbucket.IdSize]byte
+	value  []byte
+	hints  bool
+	keys   bool
+	hello  *PeerInfo
+
+	// RangeSync、DigestSync 与 MerkleSync 的参数，区域的 Self 放在 key 中，
+	// DigestSync 的摘要放在 value 中，MerkleSync 的子树前缀放在 from 中
+	bits  int
+	from  [kbucket.IdSize]byte
+	limit int
+	depth int
+}
+
+func (r grpcRequest) encode() []byte {
+	var sender []byte
+	sender = protowire.AppendBytes(sender, 1, r.sender[:])
+	sender = protowire.AppendBytes(sender, 2, []byte(r.addr))
+	var b []byte
+	b = protowire.AppendBytes(b, 1, sender)
+	if r.key != ([kbucket.IdSize]byte{}) {
+		b = protowire.AppendBytes(b, 2, r.key[:])
+	}
+	b = protowire.AppendBytes(b, 3, r.value)
+	if r.hints {
+		b = protowire.AppendVarint(b, 4, 1)
+	}
+	if r.hello != nil {
+		b = protowire.AppendBytes(b, 5, encodeGRPCInfo(*r.hello))
+	}
+	if r.bits > 0 {
+		b = protowire.AppendVarint(b, 6, uint64(r.bits))
+	}
+	if r.from != ([kbucket.IdSize]byte{}) {
+		b = protowire.AppendBytes(b, 7, r.from[:])
+	}
+	if r.limit > 0 {
+		b = protowire.AppendVarint(b, 8, uint64(r.limit))
+	}
+	if r.keys {
+		b = protowire.AppendVarint(b, 9, 1)
+	}
+	if r.depth > 0 {
+		b = protowire.AppendVarint(b, 10, uint64(r.depth))
+	}
+	return b
+}
+
+func decodeGRPCRequest(b []byte) (grpcRequest, error) {
+	var r grpcRequest
+	err := protowire.Fields(b, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			c, err := decodeGRPCContact(v)
+			r.sender, r.addr = c.ID, c.addr
+			return err
+		case 2:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			copy(r.key[:], v)
+		case 3:
+			r.value = append([]byte(nil), v...)
+		case 4:
+			r.hints = x != 0
+		case 5:
+			info, err := decodeGRPCInfo(v)
+			r.hello = &info
+			return err
+		case 6:
+			if x > kbucket.IdSize*8 {
+				return protowire.ErrMalformed
+			}
+			r.bits = int(x)
+		case 7:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			copy(r.from[:], v)
+		case 8:
+			r.limit = int(min(x, math.MaxInt32)) // 各请求在处理时按自己的上限截断
+		case 9:
+			r.keys = x != 0
+		case 10:
+			if x > kbucket.IdSize*8 {
+				return protowire.ErrMalformed
+			}
+			r.depth = int(x)
+		}
+		return nil
+	})
+	return r, err
+}
+
+// PeerInfo 消息：version = 1，codecs = 2，features = 3，max_value_size = 4，max_records = 5
+func encodeGRPCInfo(info PeerInfo) []byte {
+	var b []byte
+	b = protowire.AppendBytes(b, 1, []byte(info.Version))
+	for _, c := range info.Codecs {
+		b = protowire.AppendBytes(b, 2, []byte(c))
+	}
+	for _, f := range info.Features {
+		b = protowire.AppendBytes(b, 3, []byte(f))
+	}
+	b = protowire.AppendVarint(b, 4, uint64(info.MaxValueSize))
+	return protowire.AppendVarint(b, 5, uint64(info.MaxRecords))
+}
+
+func decodeGRPCInfo(b []byte) (PeerInfo, error) {
+	var info PeerInfo
+	err := protowire.Fields(b, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			info.Version = string(v)
+		case 2:
+			info.Codecs = append(info.Codecs, string(v))
+		case 3:
+			info.Features = append(info.Features, string(v))
+		case 4:
+			info.MaxValueSize = int(min(x, math.MaxInt32))
+		case 5:
+			info.MaxRecords = int(min(x, math.MaxInt32))
+		}
+		return nil
+	})
+	return info, err
+}
+
+type grpcContact struct {
+	ID   [kbucket.IdSize]byte
+	addr string
+	hint udpwire.Hint
+	key  ed25519.PublicKey
+}
+
+func decodeGRPCContact(b []byte) (grpcContact, error) {
+	var c grpcContact
+	err := protowire.Fields(b, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			copy(c.ID[:], v)
+		case 2:
+			c.addr = string(v)
+		case 3:
+			c.hint.RTT = uint32(min(x, math.MaxUint32))
+		case 4:
+			c.hint.Reliability = uint8(min(x, 100))
+		case 5:
+			if len(v) != ed25519.PublicKeySize {
+				return protowire.ErrMalformed
+			}
+			c.key = append(ed25519.PublicKey(nil), v...)
+		}
+		return nil
+	})
+	return c, err
+}
+
+// 只编码通过网络认识的节点。hint 不为 nil 时附带对每个节点的质量提示，
+// key 不为 nil 时附带它返回的每个节点的公钥
+func appendGRPCContacts(b []byte, field int, nodes []kbucket.Node, hint func([kbucket.IdSize]byte) QualityHint, key func(kbucket.Node) ed25519.PublicKey) []byte {
+	for _, n := range nodes {
+		addr, ok := n.Data.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		var c []byte
+		c = protowire.AppendBytes(c, 1, n.ID[:])
+		c = protowire.AppendBytes(c, 2, []byte(addr.String()))
+		if hint != nil {
+			h := hint(n.ID).wire()
+			c = protowire.AppendVarint(c, 3, uint64(h.RTT))
+			c = protowire.AppendVarint(c, 4, uint64(h.Reliability))
+		}
+		if key != nil {
+			if pub := key(n); pub != nil {
+				c = protowire.AppendBytes(c, 5, pub)
+			}
+		}
+		b = protowire.AppendBytes(b, field, c)
+	}
+	return b
+}
+
+func grpcContactsOf(raw [][]byte) ([]Contact, error) {
+	contacts := make([]Contact, 0, len(raw))
+	for _, v := range raw {
+		c, err := decodeGRPCContact(v)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := net.ResolveUDPAddr("udp", c.addr)
+		if err != nil {
+			return nil, protowire.ErrMalformed
+		}
+		contacts = append(contacts, Contact{ID: c.ID, Addr: addr, Hint: hintFromWire(c.hint), PublicKey: c.key})
+	}
+	return contacts, nil
+}
+
+// 处理一个 gRPC 请求并回复，同时把请求方加入路由表
+func (t *GRPCTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "grpc requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	msg, err := protowire.ReadFrame(r.Body)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArgument, "malformed request")
+		return
+	}
+	req, err := decodeGRPCRequest(msg)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	var trace TraceID
+	if raw, err := hex.DecodeString(r.Header.Get(grpcTraceHeader)); err == nil && len(raw) == len(trace) {
+		copy(trace[:], raw)
+	} else {
+		trace = NewTraceID()
+	}
+	p := t.p
+	if p.faults.timedOut(req.sender) { // 注入的故障：与该节点的通信中断，请求方等到超时
+		<-r.Context().Done()
+		return
+	}
+	if t.bannedRequest(req, r.RemoteAddr) {
+		grpcStatus(w, grpcPermissionDenied, "banned")
+		return
+	}
+	if sig := r.Header.Get(grpcSigHeader); sig != "" {
+		raw, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil || verifySender(req.sender, msg, raw) != nil {
+			grpcStatus(w, grpcUnauthenticated, "bad signature")
+			return
+		}
+		p.learnKey(req.sender, raw[:ed25519.PublicKeySize])
+	} else if p.cfg.RequireSignatures {
+		grpcStatus(w, grpcUnauthenticated, "signature required")
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/"+grpcService+"/")
+	if p.servingPaused() {
+		grpcStatus(w, grpcUnavailable, "client only on a metered link")
+		return
+	}
+	if !p.allowRequest(req.sender, grpcOp(method)) {
+		grpcStatus(w, grpcResourceExhausted, "rate limited")
+		return
+	}
+	pri := PriorityHigh
+	if r.Header.Get(grpcPriorityHeader) == "low" {
+		pri = PriorityLow
+	}
+	if !p.acquireRequest(grpcOp(method), pri) {
+		grpcStatus(w, grpcResourceExhausted, "busy")
+		return
+	}
+	defer p.release(ResourceMessages)
+	var resp []byte
+	departed := false // 离开的节点不再加入路由表
+	switch method {
+	case "Ping":
+		p.onRequest(trace, OpPing, req.sender, req.key)
+		resp = protowire.AppendBytes(resp, 1, p.node.ID[:])
+		if req.hello != nil { // 请求方附带了握手信息，回复本节点的信息
+			p.learnInfo(req.sender, *req.hello)
+			resp = protowire.AppendBytes(resp, 2, encodeGRPCInfo(p.LocalInfo()))
+		}
+	case "Store":
+		p.onRequest(trace, OpStore, req.sender, req.key)
+		code, _ := p.offerStore(req.key, req.value, trace, senderOrigin(req.sender, r.Header.Get(grpcSigHeader) != ""))
+		resp = protowire.AppendVarint(resp, 1, uint64(code))
+		if code == CodeBusy {
+			resp = protowire.AppendVarint(resp, 2, uint64(p.busyRetryAfter()/time.Millisecond))
+		}
+	case "FindNode":
+		p.onRequest(trace, OpFindNode, req.sender, req.key)
+		var hint func([kbucket.IdSize]byte) QualityHint
+		if req.hints {
+			hint = p.qualityHint
+		}
+		var key func(kbucket.Node) ed25519.PublicKey
+		if req.keys {
+			key = p.contactKey
+		}
+		resp = appendGRPCContacts(resp, 1, p.findNodeResponse(req.key, p.cfg.K), hint, key)
+	case "FindValue":
+		p.onRequest(trace, OpFindValue, req.sender, req.key)
+		p.stats.record(req.key, false)
+		value, ok := p.store.get(req.key)
+		p.metricStore(ok)
+		if ok {
+			resp = protowire.AppendVarint(resp, 1, 1)
+			resp = protowire.AppendBytes(resp, 2, value)
+		} else {
+			resp = appendGRPCContacts(resp, 3, p.kb.FindClosestNodes(req.key, p.cfg.K), nil, nil)
+		}
+	case "Leave":
+		p.onRequest(trace, OpLeave, req.sender, req.key)
+		p.peerDeparted(req.sender, advertisedAddr(req.addr, r.RemoteAddr))
+		departed = true
+	case "AddProvider":
+		p.onRequest(trace, OpAddProvider, req.sender, req.key)
+		if addr := advertisedAddr(req.addr, r.RemoteAddr); addr != nil { // 没有监听地址的节点无法被联系
+			p.addProvider(req.key, Contact{ID: req.sender, Addr: addr})
+		}
+		resp = protowire.AppendVarint(resp, 1, uint64(CodeOK))
+	case "GetProviders":
+		p.onRequest(trace, OpGetProviders, req.sender, req.key)
+		now := p.now()
+		for _, pr := range p.providers.get(req.key, now) {
+			if pr.Contact.Addr == nil {
+				continue
+			}
+			var entry []byte
+			entry = appendGRPCContacts(entry, 1, []kbucket.Node{pr.Contact.node()}, nil, nil)
+			if !pr.Expires.IsZero() {
+				entry = protowire.AppendVarint(entry, 2, uint64(max(pr.Expires.Sub(now)/time.Second, 1)))
+			}
+			resp = protowire.AppendBytes(resp, 1, entry)
+		}
+		resp = appendGRPCContacts(resp, 2, p.kb.FindClosestNodes(req.key, p.cfg.K), nil, nil)
+	case "RangeSync":
+		p.onRequest(trace, OpRangeSync, req.sender, req.key)
+		page, code, wait := p.serveRangeSync(req.sender, ResponsibilityRange{Self: req.key, Bits: req.bits}, req.from, req.limit)
+		resp = protowire.AppendVarint(resp, 1, uint64(code))
+		if code == CodeBusy {
+			resp = protowire.AppendVarint(resp, 2, uint64(wait/time.Millisecond))
+			break
+		}
+		for _, rec := range page.Records {
+			var entry []byte
+			entry = protowire.AppendBytes(entry, 1, rec.Key[:])
+			entry = protowire.AppendBytes(entry, 2, rec.Value)
+			entry = protowire.AppendVarint(entry, 3, uint64(rec.TTL/time.Second))
+			resp = protowire.AppendBytes(resp, 3, entry)
+		}
+		if page.More {
+			resp = protowire.AppendBytes(resp, 4, page.Next[:])
+		}
+	case "DigestSync":
+		var digest KeyDigest
+		if err := digest.UnmarshalBinary(req.value); err != nil {
+			grpcStatus(w, grpcInvalidArgument, "malformed digest")
+			return
+		}
+		p.onRequest(trace, OpDigestSync, req.sender, req.key)
+		keys, more, code, wait := p.serveDigestSync(req.sender, ResponsibilityRange{Self: req.key, Bits: req.bits}, &digest, req.limit)
+		resp = protowire.AppendVarint(resp, 1, uint64(code))
+		if code == CodeBusy {
+			resp = protowire.AppendVarint(resp, 2, uint64(wait/time.Millisecond))
+			break
+		}
+		for _, key := range keys {
+			resp = protowire.AppendBytes(resp, 3, key[:])
+		}
+		if more {
+			resp = protowire.AppendVarint(resp, 4, 1)
+		}
+	case "MerkleSync":
+		p.onRequest(trace, OpMerkleSync, req.sender, req.key)
+		s := p.serveMerkleSync(ResponsibilityRange{Self: req.key, Bits: req.bits}, req.from, req.depth)
+		if s.Hash == ([sha256.Size]byte{}) {
+			break
+		}
+		resp = protowire.AppendBytes(resp, 1, s.Hash[:])
+		if s.Leaf {
+			resp = protowire.AppendVarint(resp, 2, 1)
+			for _, key := range s.Keys {
+				resp = protowire.AppendBytes(resp, 3, key[:])
+			}
+			break
+		}
+		resp = protowire.AppendBytes(resp, 4, s.Children[0][:])
+		resp = protowire.AppendBytes(resp, 5, s.Children[1][:])
+	default:
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+		return
+	}
+	if addr := advertisedAddr(req.addr, r.RemoteAddr); addr != nil && !departed {
+		p.spawn(grpcOp(method), func() { t.learn(req.sender, addr) }) // 加入路由表可能需要 ping 其他节点
+	}
+	if p.identity != nil {
+		w.Header().Set(grpcSigHeader, base64.StdEncoding.EncodeToString(p.sign(resp)))
+	}
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	w.Write(protowire.Frame(resp))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// gRPC 方法对应的操作名
+func grpcOp(method string) string {
+	switch method {
+	case "Ping":
+		return OpPing
+	case "Store":
+		return OpStore
+	case "FindNode":
+		return OpFindNode
+	case "FindValue":
+		return OpFindValue
+	case "Leave":
+		return OpLeave
+	case "AddProvider":
+		return OpAddProvider
+	case "GetProviders":
+		return OpGetProviders
+	case "RangeSync":
+		return OpRangeSync
+	case "DigestSync":
+		return OpDigestSync
+	case "MerkleSync":
+		return OpMerkleSync
+	}
+	return "unknown"
+}
+
+// 只有状态、没有消息的响应
+func grpcStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+	w.WriteHeader(http.StatusOK)
+}
+
+// 请求方声明的监听地址；未指定 IP 时使用连接的来源 IP
+func advertisedAddr(addr, remote string) *net.UDPAddr {
+	if addr == "" {
+		return nil
+	}
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil
+	}
+	if a.IP == nil || a.IP.IsUnspecified() {
+		host, _, err := net.SplitHostPort(remote)
+		if err != nil {
+			return nil
+		}
+		a.IP = net.ParseIP(host)
+	}
+	return a
+}
+
+// 请求方的 ID、连接的来源地址或声明的地址被封禁
+func (t *GRPCTransport) bannedRequest(req grpcRequest, remote string) bool {
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		if ip := net.ParseIP(host); ip != nil && t.p.banned(req.sender, &net.UDPAddr{IP: ip}) {
+			return true
+		}
+	}
+	addr := advertisedAddr(req.addr, remote)
+	return t.p.banned(req.sender, addr)
+}
+
+// 把通信过的远端节点加入路由表。已知节点换了地址时保留原记录
+func (t *GRPCTransport) learn(id [kbucket.IdSize]byte, addr *net.UDPAddr) {
+	if id == t.p.node.ID {
+		return
+	}
+	if old, ok := t.p.kb.GetBucket(t.p.kb.BucketIndex(id)).FindNode(id); ok {
+		if oldAddr, isAddr := old.Data.(*net.UDPAddr); isAddr && oldAddr.String() != addr.String() {
+			return
+		}
+	}
+	t.p.kb.InsertNode(kbucket.Node{ID: id, Data: addr, LastSeen: time.Now()})
+}
+
+// 发出一个请求并返回响应消息，以及签名的响应方（响应未签名时为零值）。
+// 等待超过 RTO 时返回 ErrTimeout 并退避 RTO；连接失败返回包装了原因的 ErrUnreachable；
+// 对方不是 gRPC 服务时返回 ErrNotGRPC；ctx 被调用方取消时返回 ctx.Err()
+func (t *GRPCTransport) call(ctx context.Context, to Contact, method, op string, req grpcRequest) ([]byte, [kbucket.IdSize]byte, error) {
+	var signer [kbucket.IdSize]byte
+	if err := ctx.Err(); err != nil {
+		return nil, signer, err
+	}
+	done, err := t.p.scheduleSend(ctx)
+	if err != nil {
+		return nil, signer, err
+	}
+	defer done()
+	req.sender = t.p.node.ID
+	req.addr = t.ln.Addr().String()
+	timeout := t.Timeout
+	if rto := t.p.rto(to.ID); rto > 0 {
+		timeout = rto
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	url := "https://" + to.Addr.String() + "/" + grpcService + "/" + method
+	body := req.encode()
+	hreq, err := http.NewRequestWithContext(callCtx, http.MethodPost, url, bytes.NewReader(protowire.Frame(body)))
+	if err != nil {
+		return nil, signer, err
+	}
+	hreq.Header.Set("Content-Type", "application/grpc+proto")
+	hreq.Header.Set("Te", "trailers")
+	hreq.Header.Set(grpcTraceHeader, TraceFromContext(ctx).String())
+	if PriorityFromContext(ctx) == PriorityLow {
+		hreq.Header.Set(grpcPriorityHeader, "low")
+	}
+	if t.p.identity != nil {
+		hreq.Header.Set(grpcSigHeader, base64.StdEncoding.EncodeToString(t.p.sign(body)))
+	}
+	start := time.Now()
+	resp, err := t.client.Do(hreq)
+	if err != nil {
+		return nil, signer, t.callFailed(ctx, callCtx, to, op, err)
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		t.p.metricRPC(op, 0, false)
+		return nil, signer, fmt.Errorf("%w: http %s", ErrNotGRPC, resp.Status)
+	}
+	msg, err := protowire.ReadFrame(resp.Body)
+	if err != nil && err != io.EOF {
+		return nil, signer, t.callFailed(ctx, callCtx, to, op, err)
+	}
+	io.Copy(io.Discard, resp.Body) // 读完消息体才能拿到 trailer
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status == "" { // 不是 gRPC 响应
+		t.p.metricRPC(op, 0, false)
+		return nil, signer, fmt.Errorf("%w: missing grpc-status", ErrNotGRPC)
+	}
+	message := resp.Trailer.Get("Grpc-Message") + resp.Header.Get("Grpc-Message")
+	switch status {
+	case strconv.Itoa(grpcOK):
+	case strconv.Itoa(grpcUnauthenticated), strconv.Itoa(grpcPermissionDenied):
+		return nil, signer, &RPCError{Code: CodeUnauthorized, Message: message}
+	case strconv.Itoa(grpcResourceExhausted): // 超出对方的请求配额
+		return nil, signer, &RPCError{Code: CodeBusy, Message: message}
+	case strconv.Itoa(grpcUnavailable): // 对方暂停提供服务，见 Config.ClientOnlyWhenMetered
+		return nil, signer, fmt.Errorf("%w: %s", ErrUnreachable, message)
+	default:
+		return nil, signer, fmt.Errorf("dht: grpc status %s: %s", status, message)
+	}
+	if sig := resp.Header.Get(grpcSigHeader); sig != "" {
+		raw, err := base64.StdEncoding.DecodeString(sig)
+		if err != nil || len(raw) != sigSize {
+			return nil, signer, ErrBadSignature
+		}
+		signer = NodeIDFromPublicKey(raw[:ed25519.PublicKeySize])
+		if to.ID != ([kbucket.IdSize]byte{}) && signer != to.ID {
+			return nil, signer, ErrIdentityMismatch
+		}
+		if err := verifySender(signer, msg, raw); err != nil {
+			return nil, signer, err
+		}
+		t.p.learnKey(signer, raw[:ed25519.PublicKeySize])
+	} else if t.p.cfg.RequireSignatures {
+		return nil, signer, ErrUnauthorized
+	}
+	rtt := time.Since(start)
+	t.p.observe(to.ID, true, rtt)
+	t.p.metricRPC(op, rtt, true)
+	if signer != ([kbucket.IdSize]byte{}) {
+		to.ID = signer
+	}
+	t.touch(to)
+	return msg, signer, nil
+}
+
+// 区分请求失败的原因：只有等待超过 RTO 才算超时并退避 RTO
+func (t *GRPCTransport) callFailed(ctx, callCtx context.Context, to Contact, op string, err error) error {
+	t.p.metricRPC(op, 0, false)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if callCtx.Err() != nil {
+		t.p.backoffRTO(to.ID)
+		return ErrTimeout
+	}
+	return fmt.Errorf("%w: %v", ErrUnreachable, err)
+}
+
+func (t *GRPCTransport) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	var id [kbucket.IdSize]byte
+	var req grpcRequest
+	if t.p.needHello(to.ID) {
+		info := t.p.LocalInfo()
+		req.hello = &info
+	}
+	msg, signer, err := t.call(ctx, to, "Ping", OpPing, req)
+	if err != nil {
+		return id, err
+	}
+	var info *PeerInfo
+	err = protowire.Fields(msg, func(field int, v []byte, _ uint64) error {
+		switch field {
+		case 1:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			copy(id[:], v)
+		case 2:
+			i, err := decodeGRPCInfo(v)
+			info = &i
+			return err
+		}
+		return nil
+	})
+	if err == nil && signer != ([kbucket.IdSize]byte{}) && signer != id {
+		err = ErrIdentityMismatch
+	}
+	if err == nil {
+		t.learn(id, to.Addr)
+		t.touch(Contact{ID: id, Addr: to.Addr}) // 请求时可能还不知道对方的 ID
+		if info != nil {
+			t.p.learnInfo(id, *info)
+		}
+	}
+	return id, err
+}
+
+func (t *GRPCTransport) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	msg, _, err := t.call(ctx, to, "FindNode", OpFindNode, grpcRequest{key: target, hints: t.p.cfg.RTTHints, keys: t.p.cfg.ContactValidation != ValidateOff})
+	if err != nil {
+		return nil, err
+	}
+	var raw [][]byte
+	if err := protowire.Fields(msg, func(field int, v []byte, _ uint64) error {
+		if field == 1 {
+			raw = append(raw, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	t.learn(to.ID, to.Addr)
+	return grpcContactsOf(raw)
+}
+
+func (t *GRPCTransport) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	msg, _, err := t.call(ctx, to, "FindValue", OpFindValue, grpcRequest{key: key})
+	if err != nil {
+		return nil, nil, err
+	}
+	found := false
+	var value []byte
+	var raw [][]byte
+	if err := protowire.Fields(msg, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			found = x != 0
+		case 2:
+			value = append([]byte(nil), v...)
+		case 3:
+			raw = append(raw, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	t.learn(to.ID, to.Addr)
+	if found {
+		if t.p.validate(key, value) != nil { // 不接受无法通过检查的值
+			return nil, nil, protowire.ErrMalformed
+		}
+		return value, nil, nil
+	}
+	nodes, err := grpcContactsOf(raw)
+	return nil, nodes, err
+}
+
+// 通知远端节点本节点即将离开
+func (t *GRPCTransport) Leave(ctx context.Context, to Contact) error {
+	_, _, err := t.call(ctx, to, "Leave", OpLeave, grpcRequest{})
+	return err
+}
+
+// 请求远端节点保存 value，远端拒绝时返回对应的 RPCError
+func (t *GRPCTransport) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	msg, _, err := t.call(ctx, to, "Store", OpStore, grpcRequest{key: key, value: value})
+	if err != nil {
+		return err
+	}
+	var code, retryMs uint64
+	if err := protowire.Fields(msg, func(field int, _ []byte, x uint64) error {
+		switch field {
+		case 1:
+			code = x
+		case 2:
+			retryMs = x
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	t.learn(to.ID, to.Addr)
+	if ErrorCode(code) == CodeBusy && retryMs > 0 {
+		wait := time.Duration(retryMs) * time.Millisecond
+		t.p.throttle(to.ID, wait)
+		return &RPCError{Code: CodeBusy, RetryAfter: wait}
+	}
+	return ErrorFromCode(ErrorCode(code), "")
+}
+
+// 向远端节点请求落在 r 中、key 不小于 from 的至多 limit 条记录。
+// 远端限制请求频率时返回带有 RetryAfter 的 RPCError
+func (t *GRPCTransport) RangeSync(ctx context.Context, to Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	msg, _, err := t.call(ctx, to, "RangeSync", OpRangeSync, grpcRequest{key: r.Self, bits: r.Bits, from: from, limit: limit})
+	if err != nil {
+		return RangePage{}, err
+	}
+	var code, retryMs uint64
+	var page RangePage
+	if err := protowire.Fields(msg, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			code = x
+		case 2:
+			retryMs = x
+		case 3:
+			var rec SyncRecord
+			if err := protowire.Fields(v, func(field int, v []byte, x uint64) error {
+				switch field {
+				case 1:
+					if len(v) != kbucket.IdSize {
+						return protowire.ErrMalformed
+					}
+					copy(rec.Key[:], v)
+				case 2:
+					rec.Value = append([]byte(nil), v...)
+				case 3:
+					rec.TTL = time.Duration(min(x, math.MaxUint32)) * time.Second
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			page.Records = append(page.Records, rec)
+		case 4:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			copy(page.Next[:], v)
+			page.More = true
+		}
+		return nil
+	}); err != nil {
+		return RangePage{}, err
+	}
+	t.learn(to.ID, to.Addr)
+	if ErrorCode(code) == CodeBusy {
+		return RangePage{}, &RPCError{Code: CodeBusy, RetryAfter: time.Duration(retryMs) * time.Millisecond}
+	}
+	if err := ErrorFromCode(ErrorCode(code), ""); err != nil {
+		return RangePage{}, err
+	}
+	return page, nil
+}
+
+// 向远端节点请求落在 r 中、不在 digest 中的至多 limit 个 key。
+// 远端限制请求频率时返回带有 RetryAfter 的 RPCError
+func (t *GRPCTransport) DigestSync(ctx context.Context, to Contact, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	raw, _ := digest.MarshalBinary()
+	msg, _, err := t.call(ctx, to, "DigestSync", OpDigestSync, grpcRequest{key: r.Self, bits: r.Bits, value: raw, limit: limit})
+	if err != nil {
+		return nil, false, err
+	}
+	var code, retryMs uint64
+	var keys [][kbucket.IdSize]byte
+	more := false
+	if err := protowire.Fields(msg, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			code = x
+		case 2:
+			retryMs = x
+		case 3:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			keys = append(keys, [kbucket.IdSize]byte(v))
+		case 4:
+			more = x != 0
+		}
+		return nil
+	}); err != nil {
+		return nil, false, err
+	}
+	t.learn(to.ID, to.Addr)
+	if ErrorCode(code) == CodeBusy {
+		return nil, false, &RPCError{Code: CodeBusy, RetryAfter: time.Duration(retryMs) * time.Millisecond}
+	}
+	if err := ErrorFromCode(ErrorCode(code), ""); err != nil {
+		return nil, false, err
+	}
+	return keys, more, nil
+}
+
+// 向远端节点请求落在 r 中、与 prefix 共享前 depth 位的 key 构成的 Merkle 子树的摘要
+func (t *GRPCTransport) MerkleSync(ctx context.Context, to Contact, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	msg, _, err := t.call(ctx, to, "MerkleSync", OpMerkleSync, grpcRequest{key: r.Self, bits: r.Bits, from: prefix, depth: depth})
+	if err != nil {
+		return MerkleSummary{}, err
+	}
+	var s MerkleSummary
+	hash := func(dst *[sha256.Size]byte, v []byte) error {
+		if len(v) != sha256.Size {
+			return protowire.ErrMalformed
+		}
+		copy(dst[:], v)
+		return nil
+	}
+	if err := protowire.Fields(msg, func(field int, v []byte, x uint64) error {
+		switch field {
+		case 1:
+			return hash(&s.Hash, v)
+		case 2:
+			s.Leaf = x != 0
+		case 3:
+			if len(v) != kbucket.IdSize {
+				return protowire.ErrMalformed
+			}
+			s.Keys = append(s.Keys, [kbucket.IdSize]byte(v))
+		case 4:
+			return hash(&s.Children[0], v)
+		case 5:
+			return hash(&s.Children[1], v)
+		}
+		return nil
+	}); err != nil {
+		return MerkleSummary{}, err
+	}
+	t.learn(to.ID, to.Addr)
+	return s, nil
+}
+
+// 请求远端节点把本节点记为 key 的 provider，远端以请求中声明的地址联系本节点
+func (t *GRPCTransport) AddProvider(ctx context.Context, to Contact, key [kbucket.IdSize]byte) error {
+	msg, _, err := t.call(ctx, to, "AddProvider", OpAddProvider, grpcRequest{key: key})
+	if err != nil {
+		return err
+	}
+	var code uint64
+	if err := protowire.Fields(msg, func(field int, _ []byte, x uint64) error {
+		if field == 1 {
+			code = x
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	t.learn(to.ID, to.Addr)
+	return ErrorFromCode(ErrorCode(code), "")
+}
+
+// 向远端节点请求它保存的 key 的 provider 以及它知道的最近节点
+func (t *GRPCTransport) GetProviders(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]Provider, []Contact, error) {
+	msg, _, err := t.call(ctx, to, "GetProviders", OpGetProviders, grpcRequest{key: key})
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	var providers []Provider
+	var raw [][]byte
+	if err := protowire.Fields(msg, func(field int, v []byte, _ uint64) error {
+		switch field {
+		case 1:
+			var contact [][]byte
+			var ttl uint64
+			if err := protowire.Fields(v, func(field int, v []byte, x uint64) error {
+				switch field {
+				case 1:
+					contact = append(contact, v)
+				case 2:
+					ttl = x
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			contacts, err := grpcContactsOf(contact)
+			if err != nil || len(contacts) != 1 {
+				return protowire.ErrMalformed
+			}
+			pr := Provider{Contact: contacts[0]}
+			if ttl > 0 {
+				pr.Expires = now.Add(time.Duration(min(ttl, math.MaxUint32)) * time.Second)
+			}
+			providers = append(providers, pr)
+		case 2:
+			raw = append(raw, v)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+	t.learn(to.ID, to.Addr)
+	nodes, err := grpcContactsOf(raw)
+	return providers, nodes, err
+}
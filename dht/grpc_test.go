@@ -0,0 +1,316 @@
+package dht
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 自签名证书，同时作为服务端证书与客户端信任的根证书
+func selfSignedTLS(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		RootCAs:      roots,
+	}
+}
+
+// 在回环地址上监听 gRPC 的节点，测试结束时关闭
+func listenGRPCPeer(t *testing.T, p *Peer, tlsConfig *tls.Config) (*GRPCTransport, Contact) {
+	t.Helper()
+	tr, err := ListenGRPC(p, "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	addr := tr.Addr().(*net.TCPAddr)
+	return tr, Contact{ID: p.ID(), Addr: &net.UDPAddr{IP: addr.IP, Port: addr.Port}}
+}
+
+func TestGRPCRPCs(t *testing.T) {
+	tlsConfig := selfSignedTLS(t)
+	a, b := NewPeer(KeyFromString("grpc-a")), NewPeer(KeyFromString("grpc-b"))
+	ta, _ := listenGRPCPeer(t, a, tlsConfig)
+	_, cb := listenGRPCPeer(t, b, tlsConfig)
+	ctx := context.Background()
+
+	if id, err := ta.Ping(ctx, cb); err != nil || id != b.ID() {
+		t.Fatalf("Ping = %x, %v", id[:4], err)
+	}
+
+	value := []byte("grpc-value")
+	key := KeyFromBytes(value)
+	if err := ta.Store(ctx, cb, key, value); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if got, _, err := ta.FindValue(ctx, cb, key); err != nil || string(got) != string(value) {
+		t.Fatalf("FindValue = %q, %v, want %q", got, err, value)
+	}
+
+	other := KeyFromString("grpc-other")
+	b.kb.InsertNode(kbucket.Node{ID: other, Data: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}})
+	nodes, err := ta.FindNode(ctx, cb, other)
+	if err != nil {
+		t.Fatalf("FindNode: %v", err)
+	}
+	found := false
+	for _, c := range nodes {
+		found = found || c.ID == other && c.Addr.Port == 9
+	}
+	if !found {
+		t.Fatalf("FindNode returned %d contacts without the known node", len(nodes))
+	}
+
+	if err := ta.AddProvider(ctx, cb, key); err != nil {
+		t.Fatalf("AddProvider: %v", err)
+	}
+	providers, _, err := ta.GetProviders(ctx, cb, key)
+	if err != nil || len(providers) != 1 || providers[0].Contact.ID != a.ID() {
+		t.Fatalf("GetProviders = %v, %v, want the announcing peer", providers, err)
+	}
+
+	page, err := ta.RangeSync(ctx, cb, ResponsibilityRange{Self: key}, [kbucket.IdSize]byte{}, 10)
+	if err != nil || len(page.Records) != 1 || page.Records[0].Key != key || string(page.Records[0].Value) != string(value) {
+		t.Fatalf("RangeSync = %+v, %v, want the stored record", page, err)
+	}
+	if keys, more, err := ta.DigestSync(ctx, cb, ResponsibilityRange{Self: key}, NewKeyDigest(0, 1), 10); err != nil || len(keys) != 1 || keys[0] != key || more {
+		t.Fatalf("DigestSync = %x, %v, %v, want the stored key", keys, more, err)
+	}
+	if s, err := ta.MerkleSync(ctx, cb, ResponsibilityRange{Self: key}, key, 0); err != nil || !s.Leaf || len(s.Keys) != 1 || s.Keys[0] != key || s.Hash != buildMerkle([][kbucket.IdSize]byte{key}, 0).hash {
+		t.Fatalf("MerkleSync = %+v, %v, want a leaf with the stored key", s, err)
+	}
+
+	if err := ta.Leave(ctx, cb); err != nil {
+		t.Fatalf("Leave: %v", err)
+	}
+	if _, ok := b.kb.GetBucket(b.kb.BucketIndex(a.ID())).FindNode(a.ID()); ok {
+		t.Fatal("departed peer still in the routing table")
+	}
+}
+
+// 服务端拒绝请求时返回的 gRPC 状态在 call 中转换为对应的错误
+func TestGRPCStatusMapping(t *testing.T) {
+	tlsConfig := selfSignedTLS(t)
+	ctx := context.Background()
+	newPeer := func(name string, cfg Config) *Peer {
+		p, err := NewPeerWithConfig(KeyFromString(name), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := newPeer("grpc-status-a", Config{})
+	ta, _ := listenGRPCPeer(t, a, tlsConfig)
+
+	banning := newPeer("grpc-status-ban", Config{})
+	_, cb := listenGRPCPeer(t, banning, tlsConfig)
+	banning.Ban(Ban{ID: a.ID()})
+	if _, err := ta.Ping(ctx, cb); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Ping to a peer that banned us = %v, want ErrUnauthorized", err)
+	}
+
+	limited := newPeer("grpc-status-rate", Config{PeerRequestRate: 0.001, PeerRequestBurst: 1})
+	_, cl := listenGRPCPeer(t, limited, tlsConfig)
+	if _, err := ta.Ping(ctx, cl); err != nil {
+		t.Fatalf("first Ping within the burst: %v", err)
+	}
+	if _, err := ta.Ping(ctx, cl); !errors.Is(err, ErrBusy) {
+		t.Fatalf("Ping over the rate limit = %v, want ErrBusy", err)
+	}
+
+	strict := newPeer("grpc-status-strict", Config{RequireSignatures: true})
+	_, cs := listenGRPCPeer(t, strict, tlsConfig)
+	if _, err := ta.Ping(ctx, cs); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("unsigned Ping to a peer requiring signatures = %v, want ErrUnauthorized", err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := NewPeerWithIdentity(priv, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cg := listenGRPCPeer(t, signed, tlsConfig)
+	if id, err := ta.Ping(ctx, cg); err != nil || id != signed.ID() {
+		t.Fatalf("Ping to a signing peer = %x, %v", id[:4], err)
+	}
+	cg.ID = KeyFromString("someone-else")
+	if _, err := ta.Ping(ctx, cg); !errors.Is(err, ErrIdentityMismatch) {
+		t.Fatalf("Ping signed by another identity = %v, want ErrIdentityMismatch", err)
+	}
+}
+
+// 非 gRPC 响应、连接失败与超时各自返回不同的错误
+func TestGRPCCallErrors(t *testing.T) {
+	tlsConfig := selfSignedTLS(t)
+	ctx := context.Background()
+	a := NewPeer(KeyFromString("grpc-errors-a"))
+	ta, _ := listenGRPCPeer(t, a, tlsConfig)
+
+	plain := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	plain.TLS = tlsConfig
+	plain.StartTLS()
+	defer plain.Close()
+	addr := plain.Listener.Addr().(*net.TCPAddr)
+	if _, err := ta.Ping(ctx, Contact{Addr: &net.UDPAddr{IP: addr.IP, Port: addr.Port}}); !errors.Is(err, ErrNotGRPC) {
+		t.Fatalf("Ping to an HTTP server = %v, want ErrNotGRPC", err)
+	}
+
+	gone := NewPeer(KeyFromString("grpc-errors-gone"))
+	tg, cg := listenGRPCPeer(t, gone, tlsConfig)
+	if _, err := ta.Ping(ctx, cg); err != nil {
+		t.Fatal(err)
+	}
+	rto := a.rto(gone.ID())
+	tg.Close()
+	if _, err := ta.Ping(ctx, cg); !errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) {
+		t.Fatalf("Ping to a closed port = %v, want ErrUnreachable", err)
+	}
+	if got := a.rto(gone.ID()); got != rto {
+		t.Fatalf("refused connection changed the RTO from %v to %v", rto, got)
+	}
+
+	b := NewPeer(KeyFromString("grpc-errors-b"))
+	_, cb := listenGRPCPeer(t, b, tlsConfig)
+	if _, err := ta.Ping(ctx, cb); err != nil {
+		t.Fatal(err)
+	}
+	rto = a.rto(b.ID())
+	b.Faults().Timeout(a.ID())
+	if _, err := ta.Ping(ctx, cb); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Ping to a silent peer = %v, want ErrTimeout", err)
+	}
+	if got := a.rto(b.ID()); got <= rto {
+		t.Fatalf("timeout left the RTO at %v, want it backed off from %v", got, rto)
+	}
+}
+
+// 转发到 target 的 TCP 代理，freeze 之后不再转发数据也不关闭连接，模拟静默消失的对方
+type freezingProxy struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	frozen bool
+}
+
+func newFreezingProxy(t *testing.T, target string) *freezingProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	fp := &freezingProxy{ln: ln}
+	go func() {
+		for {
+			in, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", target)
+			if err != nil {
+				in.Close()
+				continue
+			}
+			t.Cleanup(func() { in.Close(); out.Close() })
+			go fp.pipe(in, out)
+			go fp.pipe(out, in)
+		}
+	}()
+	return fp
+}
+
+func (fp *freezingProxy) pipe(dst, src net.Conn) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if err != nil {
+			return
+		}
+		fp.mu.Lock()
+		frozen := fp.frozen
+		fp.mu.Unlock()
+		if frozen {
+			continue
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (fp *freezingProxy) freeze() {
+	fp.mu.Lock()
+	fp.frozen = true
+	fp.mu.Unlock()
+}
+
+// 对方静默消失时，保活的 PING 超时后关闭连接，等待中的请求立即失败而不是等到超时
+func TestGRPCKeepAliveDetectsDeadPeer(t *testing.T) {
+	tlsConfig := selfSignedTLS(t)
+	a, b := NewPeer(KeyFromString("keepalive-a")), NewPeer(KeyFromString("keepalive-b"))
+	ta, _ := listenGRPCPeer(t, a, tlsConfig)
+	ta.Timeout = 10 * time.Second
+	ta.KeepAlive = 50 * time.Millisecond
+	ta.KeepAliveTimeout = 100 * time.Millisecond
+	tb, _ := listenGRPCPeer(t, b, tlsConfig)
+	proxy := newFreezingProxy(t, tb.Addr().String())
+	addr := proxy.ln.Addr().(*net.TCPAddr)
+	to := Contact{Addr: &net.UDPAddr{IP: addr.IP, Port: addr.Port}}
+
+	if _, err := ta.Ping(context.Background(), to); err != nil {
+		t.Fatal(err)
+	}
+	proxy.freeze()
+	begin := time.Now()
+	if _, err := ta.Ping(context.Background(), to); err == nil {
+		t.Fatal("Ping through a frozen connection succeeded")
+	}
+	if elapsed := time.Since(begin); elapsed >= 2*time.Second {
+		t.Fatalf("request to a silent peer failed after %v, keep-alive did not close the connection", elapsed)
+	}
+	if s, ok := a.PeerStats(b.ID()); !ok || s.Failures == 0 {
+		t.Fatalf("keep-alive failure not recorded: %+v", s)
+	}
+}
@@ -0,0 +1,151 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// gRPC 出站连接的保活参数
+const (
+	DefaultKeepAlive        = 15 * time.Second // 连接空闲多久之后发送一次 PING
+	DefaultKeepAliveTimeout = 3 * time.Second  // 保活的 PING 等待多久没有响应就认为连接失效
+)
+
+// 到一个地址的出站连接
+type grpcPeerConns struct {
+	id    [kbucket.IdSize]byte // 最近一次响应的节点 ID
+	used  time.Time            // 最近一次收到响应的时间
+	conns map[*trackedConn]bool
+}
+
+// 记录在 GRPCTransport 中的出站连接，关闭时移除
+type trackedConn struct {
+	net.Conn
+	t    *GRPCTransport
+	addr string
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.t.untrack(c) })
+	return c.Conn.Close()
+}
+
+// 建立出站连接：TCP 层按 KeepAlive 开启保活，连接记录下来供 keepAlive 检查
+func (t *GRPCTransport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	t.keepAliveOnce.Do(func() {
+		if t.KeepAlive > 0 {
+			go t.keepAlive(t.KeepAlive, t.KeepAliveTimeout)
+		}
+	})
+	d := net.Dialer{KeepAlive: t.KeepAlive}
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &trackedConn{Conn: conn, t: t, addr: addr}
+	t.connMu.Lock()
+	pc := t.conns[addr]
+	if pc == nil {
+		pc = &grpcPeerConns{used: time.Now(), conns: make(map[*trackedConn]bool)}
+		t.conns[addr] = pc
+	}
+	pc.conns[c] = true
+	t.connMu.Unlock()
+	return c, nil
+}
+
+func (t *GRPCTransport) untrack(c *trackedConn) {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	if pc := t.conns[c.addr]; pc != nil {
+		delete(pc.conns, c)
+		if len(pc.conns) == 0 {
+			delete(t.conns, c.addr)
+		}
+	}
+}
+
+// 收到 to 的响应，连接仍然可用
+func (t *GRPCTransport) touch(to Contact) {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	if pc := t.conns[to.Addr.String()]; pc != nil {
+		pc.used = time.Now()
+		if to.ID != ([kbucket.IdSize]byte{}) {
+			pc.id = to.ID
+		}
+	}
+}
+
+// 每 interval/2 检查一次出站连接：空闲超过 interval 的地址发送 PING，timeout 内没有响应时
+// 关闭到该地址的所有连接，使仍在等待的请求立即失败，而不是等到各自超时。直到 Close
+func (t *GRPCTransport) keepAlive(interval, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultKeepAliveTimeout
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+		var wg sync.WaitGroup
+		for addr, id := range t.idle(interval) {
+			wg.Add(1)
+			go func(addr string, id [kbucket.IdSize]byte) {
+				defer wg.Done()
+				t.probe(addr, id, timeout)
+			}(addr, id)
+		}
+		wg.Wait()
+	}
+}
+
+// 空闲超过 interval 的地址及其节点 ID
+func (t *GRPCTransport) idle(interval time.Duration) map[string][kbucket.IdSize]byte {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	idle := make(map[string][kbucket.IdSize]byte)
+	for addr, pc := range t.conns {
+		if time.Since(pc.used) >= interval {
+			idle[addr] = pc.id
+		}
+	}
+	return idle
+}
+
+func (t *GRPCTransport) probe(addr string, id [kbucket.IdSize]byte, timeout time.Duration) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ContextWithPriority(context.Background(), PriorityLow), timeout)
+	defer cancel()
+	_, err = t.Ping(ctx, Contact{ID: id, Addr: udpAddr})
+	var rpcErr *RPCError
+	if err == nil || errors.As(err, &rpcErr) {
+		return // 对方回复了，包括拒绝请求的回复
+	}
+	t.connMu.Lock()
+	var conns []*trackedConn
+	if pc := t.conns[addr]; pc != nil {
+		for c := range pc.conns {
+			conns = append(conns, c)
+		}
+	}
+	t.connMu.Unlock()
+	if id != ([kbucket.IdSize]byte{}) {
+		t.p.observe(id, false, 0)
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+}
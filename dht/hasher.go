@@ -0,0 +1,81 @@
+package dht
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 计算 key 和节点 ID 使用的哈希函数，摘要长度必须等于 kbucket.IdSize。
+// 可以用来接入标准库之外的实现（例如 BLAKE2）
+type Hasher interface {
+	Name() string
+	New() hash.Hash
+}
+
+type stdHasher struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (h stdHasher) Name() string   { return h.name }
+func (h stdHasher) New() hash.Hash { return h.new() }
+
+var (
+	SHA1   Hasher = stdHasher{"sha1", sha1.New}
+	SHA256 Hasher = stdHasher{"sha256", sha256.New}
+)
+
+// 当前使用的哈希函数，默认按 ID 长度选择 SHA-1 或 SHA-256
+var keyHasher = defaultHasher()
+
+func defaultHasher() Hasher {
+	if kbucket.IdSize == sha256.Size {
+		return SHA256
+	}
+	return SHA1
+}
+
+// 更换计算 key 的哈希函数。需要在创建节点和计算任何 key 之前调用，
+// 网络中的所有节点必须使用相同的哈希函数
+func SetHasher(h Hasher) error {
+	if size := h.New().Size(); size != kbucket.IdSize {
+		return fmt.Errorf("dht: hasher %s produces %d-byte digests, IDs are %d bytes", h.Name(), size, kbucket.IdSize)
+	}
+	keyHasher = h
+	return nil
+}
+
+// 当前使用的哈希函数
+func KeyHasher() Hasher {
+	return keyHasher
+}
+
+// 截断为前 size 字节的哈希函数，例如 Truncated(SHA256, 20) 使 20 字节 ID 的网络
+// 可以从 SHA-1 换用 SHA-256，见 Config.LegacyHasher
+func Truncated(h Hasher, size int) Hasher {
+	return truncHasher{h, size}
+}
+
+type truncHasher struct {
+	h    Hasher
+	size int
+}
+
+func (t truncHasher) Name() string   { return fmt.Sprintf("%s/%d", t.h.Name(), t.size*8) }
+func (t truncHasher) New() hash.Hash { return truncHash{t.h.New(), t.size} }
+
+type truncHash struct {
+	hash.Hash
+	size int
+}
+
+func (t truncHash) Size() int { return t.size }
+
+func (t truncHash) Sum(b []byte) []byte {
+	sum := t.Hash.Sum(nil)
+	return append(b, sum[:min(t.size, len(sum))]...)
+}
@@ -0,0 +1,132 @@
+package dht
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultHealthThreshold = 0.5 // 健康分低于该值视为不健康
+	lookupEMAWeight        = 0.1 // 查找成功率 EMA 中最新一次查找的权重
+)
+
+// 路由健康分的组成部分，都在 [0, 1] 之间
+type HealthScore struct {
+	Score      float64 // 三项的平均值
+	Fullness   float64 // 已分裂出的 bucket 的平均填充率
+	Freshness  float64 // 在 RefreshInterval 内被查找经过的 bucket 比例
+	LookupRate float64 // 查找成功率的指数移动平均
+}
+
+// 健康分越过阈值
+type HealthEvent struct {
+	Score   HealthScore
+	Healthy bool
+	Time    time.Time
+}
+
+type healthState struct {
+	mu        sync.Mutex
+	ema       float64
+	lookups   int // 已记录的查找数，为 0 时 EMA 尚无意义
+	threshold float64
+	healthy   bool
+	subs      []chan HealthEvent
+}
+
+// 记录一次查找是否成功（得到了至少一个响应）
+func (p *Peer) recordLookup(ok bool) {
+	v := 0.0
+	if ok {
+		v = 1
+	}
+	h := &p.health
+	h.mu.Lock()
+	if h.lookups == 0 {
+		h.ema = v
+	} else {
+		h.ema += lookupEMAWeight * (v - h.ema)
+	}
+	h.lookups++
+	h.mu.Unlock()
+	p.recordReachable(ok)
+}
+
+// 计算当前的路由健康分
+func (p *Peer) HealthScore() HealthScore {
+	var s HealthScore
+	first := p.kb.HomeBucket()
+	buckets := kbucket.IdSize*8 - first
+	nodes := 0
+	for pos := first; pos < kbucket.IdSize*8; pos++ {
+		nodes += p.kb.GetBucket(pos).Len()
+	}
+	s.Fullness = min(float64(nodes)/float64(buckets*p.kb.MaxNodes()), 1) // 扁平路由表的 bucket 可以超过 K 个联系人
+	s.Freshness = 1 - float64(len(p.BucketsToRefresh()))/float64(buckets)
+	p.health.mu.Lock()
+	s.LookupRate = p.health.ema
+	if p.health.lookups == 0 {
+		s.LookupRate = 1 // 还没有查找时不扣分
+	}
+	p.health.mu.Unlock()
+	s.Score = (s.Fullness + s.Freshness + s.LookupRate) / 3
+	return s
+}
+
+// 设置健康阈值，0 表示使用 DefaultHealthThreshold
+func (p *Peer) SetHealthThreshold(t float64) {
+	p.health.mu.Lock()
+	p.health.threshold = t
+	p.health.mu.Unlock()
+}
+
+// 订阅健康分越过阈值的事件，缓冲区满时丢弃事件
+func (p *Peer) SubscribeHealth(buffer int) <-chan HealthEvent {
+	ch := make(chan HealthEvent, buffer)
+	p.health.mu.Lock()
+	p.health.subs = append(p.health.subs, ch)
+	p.health.mu.Unlock()
+	return ch
+}
+
+// 重新计算健康分，越过阈值时通知订阅者。UpdateHealth 会调用它
+func (p *Peer) checkHealthScore() HealthScore {
+	s := p.HealthScore()
+	h := &p.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	threshold := h.threshold
+	if threshold == 0 {
+		threshold = DefaultHealthThreshold
+	}
+	if healthy := s.Score >= threshold; healthy != h.healthy {
+		h.healthy = healthy
+		ev := HealthEvent{Score: s, Healthy: healthy, Time: time.Now()}
+		for _, ch := range h.subs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+	return s
+}
+
+// 以 Prometheus 文本格式导出健康分，告警规则只需比较一个 gauge
+func (p *Peer) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := p.HealthScore()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP kbucket_routing_health_score Routing health score between 0 and 1.")
+		fmt.Fprintln(w, "# TYPE kbucket_routing_health_score gauge")
+		fmt.Fprintf(w, "kbucket_routing_health_score %g\n", s.Score)
+		fmt.Fprintln(w, "# TYPE kbucket_routing_health_score_component gauge")
+		fmt.Fprintf(w, "kbucket_routing_health_score_component{component=\"fullness\"} %g\n", s.Fullness)
+		fmt.Fprintf(w, "kbucket_routing_health_score_component{component=\"freshness\"} %g\n", s.Freshness)
+		fmt.Fprintf(w, "kbucket_routing_health_score_component{component=\"lookups\"} %g\n", s.LookupRate)
+	})
+}
@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 计算对冲阈值至少需要的 RTT 样本数
+const hedgeMinSamples = 4
+
+// 迭代查找的统计
+type OpStats struct {
+	Lookups        uint64 // 完成的迭代查找次数
+	HedgedRequests uint64 // 因前一个查询过慢而对冲发出的查询数
+	DepthExceeded  uint64 // 因超出跳数限制而中断的查找次数
+}
+
+func (p *Peer) OpStats() OpStats {
+	return OpStats{
+		Lookups:        atomic.LoadUint64(&p.lookups),
+		HedgedRequests: atomic.LoadUint64(&p.hedged),
+		DepthExceeded:  atomic.LoadUint64(&p.depthExceeded),
+	}
+}
+
+// 向 id 发出的查询等待多久之后对冲：最近 RTT 样本的 p95，样本不足时返回 0 表示不对冲
+func (p *Peer) hedgeAfter(id [kbucket.IdSize]byte) time.Duration {
+	p.peerStatsMu.Lock()
+	var rtts []time.Duration
+	if s := p.peerStats[id]; s != nil {
+		rtts = append(rtts, s.RTTs...)
+	}
+	p.peerStatsMu.Unlock()
+	if len(rtts) < hedgeMinSamples {
+		return 0
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	return rtts[(len(rtts)*95+99)/100-1]
+}
@@ -0,0 +1,151 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+const DefaultSoftwareVersion = "kbucket-go/1"
+
+// PeerInfo.Features 中的可选功能
+const (
+	FeatureProviders = "providers" // ADD_PROVIDER 与 GET_PROVIDERS
+	FeatureRTTHints  = "rtt-hints" // FIND_NODE 响应附带质量提示
+	FeatureSigned    = "signed"    // 消息带有 ed25519 签名
+	FeaturePriority  = "priority"  // 请求可以标记为低优先级，见 Priority
+)
+
+// 节点在握手中声明的软件版本、支持的编码与限制。握手附加在 PING 上：
+// 还不知道对方的信息时，PING 带上本节点的信息，对方在响应中回复自己的信息。
+// 不支持握手的旧版本节点忽略这些字段
+type PeerInfo struct {
+	Version      string
+	Codecs       []string  // 支持的传输编码，例如 "udp"、"grpc"
+	Features     []string  // 支持的可选功能，见 FeatureProviders 等
+	MaxValueSize int       // 接受的值的最大字节数，0 表示未声明
+	MaxRecords   int       // 最多保存的记录数，0 表示不限制
+	Received     time.Time // 本节点收到的时间，LocalInfo 中为零值
+}
+
+// 是否声明了支持 feature
+func (i PeerInfo) Supports(feature string) bool {
+	for _, f := range i.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+type peerInfos struct {
+	mu sync.Mutex
+	m  map[[kbucket.IdSize]byte]PeerInfo
+}
+
+// 本节点在握手中发送的信息
+func (p *Peer) LocalInfo() PeerInfo {
+	info := PeerInfo{
+		Version:    p.cfg.SoftwareVersion,
+		Codecs:     []string{p.cfg.Protocol.String()},
+		Features:   []string{FeatureProviders, FeaturePriority},
+		MaxRecords: p.capacity,
+	}
+	if info.MaxRecords == 0 {
+		info.MaxRecords = p.cfg.MaxRecords
+	}
+	if p.cfg.RTTHints {
+		info.Features = append(info.Features, FeatureRTTHints)
+	}
+	if p.identity != nil {
+		info.Features = append(info.Features, FeatureSigned)
+	}
+	limit := p.cfg.MaxRecordBytes
+	if v, ok := p.validator.(MaxSizeValidator); ok && (limit == 0 || v.MaxSize < limit) {
+		limit = v.MaxSize
+	}
+	if p.cfg.Protocol == ProtocolUDP {
+		udpLimit := maxPacketSize - headerSize - kbucket.IdSize - 4 - sigSize
+		if limit == 0 || udpLimit < limit {
+			limit = udpLimit
+		}
+	}
+	info.MaxValueSize = limit
+	return info
+}
+
+// 节点 id 在握手中声明的信息，还没有握手时返回 false
+func (p *Peer) PeerInfo(id [kbucket.IdSize]byte) (PeerInfo, bool) {
+	p.infos.mu.Lock()
+	defer p.infos.mu.Unlock()
+	info, ok := p.infos.m[id]
+	return info, ok
+}
+
+// 与 FindPeer 相同，同时返回节点在握手中声明的信息。
+// 联系到节点但还没有握手时先 ping 一次
+func (p *Peer) FindPeerInfo(id [kbucket.IdSize]byte) (kbucket.Node, PeerInfo, bool) {
+	node, ok := p.FindPeer(id)
+	if !ok {
+		return node, PeerInfo{}, false
+	}
+	info, known := p.PeerInfo(id)
+	if !known && p.ping(node) {
+		info, _ = p.PeerInfo(id)
+	}
+	return node, info, true
+}
+
+// 节点 id 是否在握手中声明支持 feature
+func (p *Peer) supports(id [kbucket.IdSize]byte, feature string) bool {
+	info, ok := p.PeerInfo(id)
+	return ok && info.Supports(feature)
+}
+
+func (p *Peer) needHello(id [kbucket.IdSize]byte) bool {
+	if id == ([kbucket.IdSize]byte{}) {
+		return true
+	}
+	_, ok := p.PeerInfo(id)
+	return !ok
+}
+
+func (p *Peer) learnInfo(id [kbucket.IdSize]byte, info PeerInfo) {
+	info.Received = time.Now()
+	p.infos.mu.Lock()
+	defer p.infos.mu.Unlock()
+	if p.infos.m == nil {
+		p.infos.m = make(map[[kbucket.IdSize]byte]PeerInfo)
+	}
+	p.infos.m[id] = info
+}
+
+// 进程内的节点直接交换信息
+func (p *Peer) helloPeer(peer *Peer) {
+	if p.needHello(peer.node.ID) || peer.needHello(p.node.ID) {
+		p.learnInfo(peer.node.ID, peer.LocalInfo())
+		peer.learnInfo(p.node.ID, p.LocalInfo())
+	}
+}
+
+func (i PeerInfo) wire() udpwire.Hello {
+	return udpwire.Hello{
+		Version:      i.Version,
+		Codecs:       i.Codecs,
+		Features:     i.Features,
+		MaxValueSize: uint32(i.MaxValueSize),
+		MaxRecords:   uint32(i.MaxRecords),
+	}
+}
+
+func infoFromWire(h udpwire.Hello) PeerInfo {
+	return PeerInfo{
+		Version:      h.Version,
+		Codecs:       h.Codecs,
+		Features:     h.Features,
+		MaxValueSize: int(h.MaxValueSize),
+		MaxRecords:   int(h.MaxRecords),
+	}
+}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// FIND_NODE 的响应方对返回的联系人的观测，只是提示：响应方可能不诚实，
+// 也可能与本节点处在不同的网络位置
+type QualityHint struct {
+	RTT         time.Duration // 响应方测得的平均 RTT，0 表示没有观测
+	Reliability float64       // 响应方成功联系的比例
+}
+
+// 本节点对 id 的观测，作为提示发给请求方
+func (p *Peer) qualityHint(id [kbucket.IdSize]byte) QualityHint {
+	s, ok := p.PeerStats(id)
+	if !ok {
+		return QualityHint{}
+	}
+	return QualityHint{RTT: s.MeanRTT(), Reliability: s.Reliability()}
+}
+
+func (h QualityHint) wire() udpwire.Hint {
+	us := h.RTT.Microseconds()
+	if us > math.MaxUint32 {
+		us = math.MaxUint32
+	}
+	return udpwire.Hint{RTT: uint32(us), Reliability: uint8(math.Round(h.Reliability * 100))}
+}
+
+func hintFromWire(h udpwire.Hint) QualityHint {
+	return QualityHint{RTT: time.Duration(h.RTT) * time.Microsecond, Reliability: float64(h.Reliability) / 100}
+}
+
+// 按提示调整本轮查询的候选：在距离最近的 2*Alpha 个候选中优先选择提示 RTT
+// 较低的节点，没有提示的节点排在后面。超出窗口的候选不参与，避免查找偏离目标
+func (p *Peer) preferFast(round []int, shortlist []shortlistEntry, hints map[[kbucket.IdSize]byte]QualityHint) []int {
+	window := round
+	if len(window) > 2*p.cfg.Alpha {
+		window = window[:2*p.cfg.Alpha]
+	}
+	rtt := func(i int) time.Duration {
+		if h := hints[shortlist[i].node.ID]; h.RTT > 0 {
+			return h.RTT
+		}
+		return math.MaxInt64
+	}
+	sort.SliceStable(window, func(a, b int) bool { return rtt(window[a]) < rtt(window[b]) })
+	if len(window) > p.cfg.Alpha {
+		window = window[:p.cfg.Alpha]
+	}
+	return window
+}
+
+// 只保留通过网络认识的节点，与 udpwire.AppendContacts 编码的节点一致
+func networkNodes(nodes []kbucket.Node) []kbucket.Node {
+	out := nodes[:0:0]
+	for _, n := range nodes {
+		if _, ok := n.Data.(*net.UDPAddr); ok {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package dht
+
+import "github.com/WuQingyang2/K_Bucket/kbucket"
+
+// 仿真时按节点挂载的统计回调，用于收集自定义的研究指标。
+// 未设置的回调不会被调用，不设置 Hooks 时没有额外开销。
+// 查找每一轮的查询并发进行，回调可能同时在多个 goroutine 中被调用
+type Hooks struct {
+	OnInsert    func(p *Peer, n kbucket.Node)                       // 节点加入 p 的路由表
+	OnLookupHop func(p *Peer, key [kbucket.IdSize]byte, next *Peer) // p 在查找或发布 key 时联系 next
+	OnStore     func(p *Peer, key [kbucket.IdSize]byte)             // p 在本地保存了 key
+
+	// p 在追踪 ID 为 trace 的操作中处理了来自 from 的 op 请求，可用于按操作关联各节点的日志
+	OnRequest func(p *Peer, trace TraceID, op string, from, key [kbucket.IdSize]byte)
+}
+
+func (p *Peer) SetHooks(h *Hooks) {
+	p.hooks = h
+	p.kb.SetOnInsert(nil)
+	if h != nil && h.OnInsert != nil {
+		p.kb.SetOnInsert(func(n kbucket.Node) { h.OnInsert(p, n) })
+	}
+}
+
+func (p *Peer) lookupHop(key [kbucket.IdSize]byte, next *Peer, op string, trace TraceID) {
+	if t := p.trace.Load(); t != nil { // 查找路径记录随请求传递给下一跳，记录结束后（peers 为 nil）不再传递
+		t.mu.Lock()
+		if t.peers != nil && next.trace.CompareAndSwap(nil, t) {
+			t.peers = append(t.peers, next)
+		}
+		t.mu.Unlock()
+	}
+	if p.hooks != nil && p.hooks.OnLookupHop != nil {
+		p.hooks.OnLookupHop(p, key, next)
+	}
+	next.onRequest(trace, op, p.node.ID, key)
+}
@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"os"
+)
+
+const identityVersion = 1
+
+var (
+	identityMagic      = [4]byte{'K', 'B', 'I', 'D'}
+	ErrIdentityCorrupt = errors.New("dht: identity file corrupt")
+)
+
+// 保存节点密钥（ed25519 种子），节点重启后使用同一身份
+func SaveIdentity(path string, priv ed25519.PrivateKey) error {
+	var buf bytes.Buffer
+	writeVersioned(&buf, identityMagic, ArtifactIdentity, priv.Seed())
+	return writeFileAtomic(path, buf.Bytes(), 0o600)
+}
+
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 5 || !bytes.Equal(data[:4], identityMagic[:]) {
+		return nil, ErrIdentityCorrupt
+	}
+	seed, err := readVersioned(data, identityMagic, ArtifactIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrIdentityCorrupt
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// 先写临时文件再重命名，写入中途失败不会破坏已有文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
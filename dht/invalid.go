@@ -0,0 +1,147 @@
+package dht
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInvalidLogRate = 1 // 每秒最多采样的无效数据包数
+
+	invalidLogBurst   = 10 // 采样的突发上限
+	invalidHistory    = 64 // 保留的最近采样数
+	invalidSampleSize = 32 // 每个采样保留的数据包开头的字节数
+)
+
+// 丢弃数据包的原因
+const (
+	DropMalformed      = "malformed"       // 消息头或请求负载无法解析
+	DropBadSignature   = "bad_signature"   // 签名无效或与发送方 ID 不符
+	DropUnsigned       = "unsigned"        // RequireSignatures 时收到没有签名的消息
+	DropUnknownNetwork = "unknown_network" // 没有挂载对应网络的节点
+	DropUnknownKind    = "unknown_kind"    // 不认识的消息类型
+)
+
+// 一个被丢弃的数据包的采样
+type InvalidPacket struct {
+	Time   time.Time
+	From   *net.UDPAddr
+	Reason string // DropMalformed 等
+	Size   int
+	Head   []byte // 数据包（或请求负载）开头的至多 32 个字节
+	Err    string // 解析错误，没有时为空
+}
+
+// 最近丢弃的数据包的汇总
+type InvalidPacketSummary struct {
+	Total      uint64
+	ByReason   map[string]uint64
+	Suppressed uint64          // 超出采样频率而没有记录的数量
+	Recent     []InvalidPacket // 最近的采样，从旧到新
+}
+
+// 统计并按频率采样无效的数据包，采样同时写入日志
+type packetLog struct {
+	mu         sync.Mutex
+	total      uint64
+	counts     map[string]uint64
+	suppressed uint64
+	recent     []InvalidPacket
+	bucket     tokenBucket
+	rate       float64
+	logger     *log.Logger
+}
+
+func (l *packetLog) record(from *net.UDPAddr, reason string, packet []byte, err error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total++
+	if l.counts == nil {
+		l.counts = make(map[string]uint64)
+	}
+	l.counts[reason]++
+	rate := l.rate
+	if rate <= 0 {
+		rate = DefaultInvalidLogRate
+	}
+	if !l.bucket.take(now, rate, invalidLogBurst) {
+		l.suppressed++
+		return
+	}
+	sample := InvalidPacket{
+		Time:   now,
+		From:   from,
+		Reason: reason,
+		Size:   len(packet),
+		Head:   append([]byte(nil), packet[:min(len(packet), invalidSampleSize)]...),
+	}
+	if err != nil {
+		sample.Err = err.Error()
+	}
+	if len(l.recent) == invalidHistory {
+		l.recent = append(l.recent[:0], l.recent[1:]...)
+	}
+	l.recent = append(l.recent, sample)
+	if l.logger != nil {
+		l.logger.Printf("dht: dropped %d-byte packet from %v: %s: %s [%s] (%d suppressed)",
+			sample.Size, from, reason, sample.Err, hex.EncodeToString(sample.Head), l.suppressed)
+	}
+}
+
+func (l *packetLog) summary() InvalidPacketSummary {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := InvalidPacketSummary{
+		Total:      l.total,
+		ByReason:   make(map[string]uint64, len(l.counts)),
+		Suppressed: l.suppressed,
+		Recent:     append([]InvalidPacket(nil), l.recent...),
+	}
+	for reason, n := range l.counts {
+		s.ByReason[reason] = n
+	}
+	return s
+}
+
+// 把无效数据包的采样写入 logger，每秒至多 perSecond 条（不大于 0 时使用 DefaultInvalidLogRate）。
+// logger 为 nil 时只统计不写日志
+func (m *UDPMux) SetPacketLogger(logger *log.Logger, perSecond float64) {
+	m.invalid.mu.Lock()
+	m.invalid.logger = logger
+	m.invalid.rate = perSecond
+	m.invalid.mu.Unlock()
+}
+
+// 该端口收到后被丢弃的无效数据包的汇总
+func (m *UDPMux) InvalidPackets() InvalidPacketSummary {
+	return m.invalid.summary()
+}
+
+// 见 UDPMux.SetPacketLogger。共享端口的各个网络使用同一个日志设置
+func (t *UDPTransport) SetPacketLogger(logger *log.Logger, perSecond float64) {
+	t.mux.SetPacketLogger(logger, perSecond)
+}
+
+// 本节点所在端口收到的无效数据包的汇总，包括共享端口的其他网络的数据包
+func (t *UDPTransport) InvalidPackets() InvalidPacketSummary {
+	return t.mux.InvalidPackets()
+}
+
+// decodeMessage 出错时丢弃的原因
+func decodeDropReason(err error) string {
+	if errors.Is(err, ErrBadSignature) || errors.Is(err, ErrIdentityMismatch) {
+		return DropBadSignature
+	}
+	return DropMalformed
+}
+
+// 记录一条无法处理的消息，采样中保留的是消息的负载
+func (t *UDPTransport) drop(msg message, reason string) {
+	t.mux.invalid.record(msg.from, reason, msg.payload, fmt.Errorf("message kind %d", msg.kind))
+}
@@ -0,0 +1,29 @@
+package dht
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 周期性任务间隔的随机抖动，避免大量节点的维护流量同步到同一时刻
+type jitterSource struct {
+	mu sync.Mutex
+	r  *rand.Rand // nil 表示使用全局随机数源
+}
+
+// 在 d 的基础上加入 ±Config.Jitter 比例的均匀抖动
+func (p *Peer) jittered(d time.Duration) time.Duration {
+	if p.cfg.Jitter <= 0 || d <= 0 {
+		return d
+	}
+	p.jitter.mu.Lock()
+	var f float64
+	if p.jitter.r != nil {
+		f = p.jitter.r.Float64()
+	} else {
+		f = rand.Float64()
+	}
+	p.jitter.mu.Unlock()
+	return time.Duration(float64(d) * (1 + p.cfg.Jitter*(2*f-1)))
+}
@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestJitterDisabled(t *testing.T) {
+	p := NewPeer(KeyFromString("jitter-off"))
+	if d := p.jittered(time.Minute); d != time.Minute {
+		t.Fatalf("jittered = %v without Jitter", d)
+	}
+}
+
+// 在虚拟时间上推进 n 个周期，检查每次等待时间的分布
+func TestJitterDistribution(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Jitter = 0.2
+	p, err := NewPeerWithConfig(KeyFromString("jitter-on"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.jitter.r = rand.New(rand.NewSource(1))
+	const (
+		n        = 10000
+		interval = time.Minute
+		bins     = 10
+	)
+	lo, hi := time.Duration(float64(interval)*0.8), time.Duration(float64(interval)*1.2)
+	var clock time.Duration // 虚拟时钟
+	var hist [bins]int
+	for i := 0; i < n; i++ {
+		d := p.jittered(interval)
+		if d < lo || d > hi {
+			t.Fatalf("interval %v outside [%v, %v]", d, lo, hi)
+		}
+		clock += d
+		bin := int(float64(d-lo) / float64(hi-lo) * bins)
+		if bin == bins {
+			bin--
+		}
+		hist[bin]++
+	}
+	if mean := clock / n; mean < interval-time.Second || mean > interval+time.Second {
+		t.Fatalf("mean interval %v, want about %v", mean, interval)
+	}
+	for i, c := range hist { // 均匀分布：每个区间约 n/bins 个样本
+		if c < n/bins*8/10 || c > n/bins*12/10 {
+			t.Fatalf("bin %d has %d samples, histogram %v", i, c, hist)
+		}
+	}
+}
+
+func TestJitterValidate(t *testing.T) {
+	for _, j := range []float64{-0.1, 1, 1.5} {
+		cfg := DefaultConfig()
+		cfg.Jitter = j
+		if cfg.Validate() == nil {
+			t.Errorf("Validate accepted Jitter %v", j)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	journalPending byte = iota + 1 // 已接受但尚未完成复制的 STORE
+	journalDone                    // 已有足够的远端副本确认，见 Config.JournalAcks
+)
+
+const journalVersion = 1
+
+// 日志超过这个大小、并且比上次压缩后大了一倍时，janitor 压缩日志
+const JournalCompactSize = 1 << 20
+
+var journalMagic = [4]byte{'K', 'B', 'J', 'L'}
+
+// 持久化的发布日志：SetValue 在复制前写入 pending 记录，有 JournalAcks 个远端副本
+// 确认后写入 done 记录，节点重启后重放未完成的记录，保证已接受的 SetValue 至少发布一次
+type Journal struct {
+	mu        sync.Mutex // 保证并发写入的记录不会交错
+	path      string
+	file      *os.File
+	size      int64 // 日志文件的当前大小
+	compacted int64 // 上次压缩（或打开）后的大小
+}
+
+// 打开日志文件，旧版本的日志先升级到当前格式
+func OpenJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) < 5 || !bytes.Equal(data[:4], journalMagic[:]) || data[4] != journalVersion {
+		records, err := readVersioned(data, journalMagic, ArtifactJournal)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		writeVersioned(&buf, journalMagic, ArtifactJournal, records)
+		if err := writeFileAtomic(path, buf.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Journal{path: path, file: f, size: info.Size(), compacted: info.Size()}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+// 记录格式：类型(1字节) + key + 值长度(4字节) + 值
+func appendJournalRecord(buf []byte, kind byte, key [kbucket.IdSize]byte, value []byte) []byte {
+	buf = append(buf, kind)
+	buf = append(buf, key[:]...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+	return append(buf, value...)
+}
+
+func (j *Journal) append(kind byte, key [kbucket.IdSize]byte, value []byte) error {
+	buf := appendJournalRecord(make([]byte, 0, 1+kbucket.IdSize+4+len(value)), kind, key, value)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	n, err := j.file.Write(buf)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+type journalEntry struct {
+	key   [kbucket.IdSize]byte
+	value []byte
+}
+
+// 读取所有尚未完成的记录，按写入顺序返回；末尾不完整的记录会被忽略
+func (j *Journal) pending() ([]journalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.readPending()
+}
+
+func (j *Journal) readPending() ([]journalEntry, error) {
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return nil, err
+	}
+	records, err := readVersioned(data, journalMagic, ArtifactJournal)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(records)
+	var order [][kbucket.IdSize]byte
+	values := make(map[[kbucket.IdSize]byte][]byte)
+	header := make([]byte, 1+kbucket.IdSize+4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		var key [kbucket.IdSize]byte
+		copy(key[:], header[1:])
+		value := make([]byte, binary.BigEndian.Uint32(header[1+kbucket.IdSize:]))
+		if _, err := io.ReadFull(r, value); err != nil {
+			break
+		}
+		switch header[0] {
+		case journalPending:
+			if _, ok := values[key]; !ok {
+				order = append(order, key)
+			}
+			values[key] = value
+		case journalDone:
+			delete(values, key)
+		}
+	}
+	entries := make([]journalEntry, 0, len(values))
+	for _, key := range order {
+		if value, ok := values[key]; ok {
+			entries = append(entries, journalEntry{key: key, value: value})
+			delete(values, key)
+		}
+	}
+	return entries, nil
+}
+
+// 重写日志，只保留尚未完成的记录。重写期间写入的记录同样保留
+func (j *Journal) compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries, err := j.readPending()
+	if err != nil {
+		return err
+	}
+	var records []byte
+	for _, e := range entries {
+		records = appendJournalRecord(records, journalPending, e.key, e.value)
+	}
+	var buf bytes.Buffer
+	writeVersioned(&buf, journalMagic, ArtifactJournal, records)
+	if err := writeFileAtomic(j.path, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = f
+	j.size = int64(buf.Len())
+	j.compacted = j.size
+	return nil
+}
+
+// 日志中已完成的记录占用了足够多的空间，值得重写
+func (j *Journal) grown() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.size > JournalCompactSize && j.size > 2*j.compacted
+}
+
+// 日志增长到需要压缩时压缩它，由 janitor 定期调用。已完成的记录只在压缩时删除，
+// 长时间运行、不重启的节点需要它限制日志的大小
+func (p *Peer) compactJournal() error {
+	if p.journal == nil || !p.journal.grown() {
+		return nil
+	}
+	return p.journal.compact()
+}
+
+func (p *Peer) SetJournal(j *Journal) {
+	p.journal = j
+}
+
+// 重放日志中未完成的发布，返回重放的记录数量。远端副本仍然不足 JournalAcks 的
+// 记录留在日志中，下次重放时再试，例如路由表为空、尚未 Bootstrap 时的重放
+func (p *Peer) ReplayJournal() (int, error) {
+	if p.journal == nil {
+		return 0, nil
+	}
+	entries, err := p.journal.pending()
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if p.store.putIfAbsent(e.key, e.value, p.ownOrigin()) {
+			p.emitStore(ValueStored, e.key)
+		}
+		if n, _ := p.replicate(context.Background(), e.key, e.value, NewTraceID()); n >= p.journalAcks() {
+			if err := p.journal.append(journalDone, e.key, nil); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(entries), p.journal.compact()
+}
+
+// 发布日志中的记录需要的远端副本数
+func (p *Peer) journalAcks() int {
+	if p.cfg.JournalAcks > 0 {
+		return p.cfg.JournalAcks
+	}
+	return 1
+}
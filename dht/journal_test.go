@@ -0,0 +1,139 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func openTestJournal(t *testing.T, p *Peer, path string) *Journal {
+	t.Helper()
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	p.SetJournal(j)
+	return j
+}
+
+func pendingCount(t *testing.T, j *Journal) int {
+	t.Helper()
+	entries, err := j.pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+// 没有远端副本的发布留在日志中：重启后在 Bootstrap 之前重放不会丢失它，
+// 加入网络后的重放完成发布并从日志中删除
+func TestJournalReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	id := KeyFromString("journal-self")
+	value := []byte("journal-value")
+	key := KeyFromBytes(value)
+
+	p := NewPeer(id)
+	j := openTestJournal(t, p, path)
+	if n, err := p.SetValue(context.Background(), key[:], value); n != 1 || err != nil {
+		t.Fatalf("SetValue on a lone peer = %d, %v", n, err)
+	}
+	if got := pendingCount(t, j); got != 1 {
+		t.Fatalf("%d pending entries after an unacknowledged SetValue, want 1", got)
+	}
+	j.Close()
+
+	restarted := NewPeer(id)
+	j = openTestJournal(t, restarted, path)
+	if n, err := restarted.ReplayJournal(); n != 1 || err != nil {
+		t.Fatalf("ReplayJournal before bootstrap = %d, %v", n, err)
+	}
+	if got := pendingCount(t, j); got != 1 {
+		t.Fatalf("%d pending entries after replaying with an empty table, want 1", got)
+	}
+
+	peers := newTestNetwork(8, 4)
+	for _, q := range peers {
+		restarted.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+	}
+	if n, err := restarted.ReplayJournal(); n != 1 || err != nil {
+		t.Fatalf("ReplayJournal after joining = %d, %v", n, err)
+	}
+	if got := pendingCount(t, j); got != 0 {
+		t.Fatalf("%d pending entries after a replicated replay, want 0", got)
+	}
+	held := 0
+	for _, q := range peers {
+		if q.store.has(key) {
+			held++
+		}
+	}
+	if held == 0 {
+		t.Fatal("replayed value reached no peer")
+	}
+	if n, err := restarted.ReplayJournal(); n != 0 || err != nil {
+		t.Fatalf("third ReplayJournal = %d, %v", n, err)
+	}
+}
+
+// 远端副本少于 JournalAcks 时记录保持未完成
+func TestJournalAcks(t *testing.T) {
+	for _, tc := range []struct {
+		acks, pending int
+	}{{1, 0}, {2, 1}} {
+		cfg := DefaultConfig()
+		cfg.JournalAcks = tc.acks
+		p, err := NewPeerWithConfig(KeyFromString("journal-acks"), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j := openTestJournal(t, p, filepath.Join(t.TempDir(), "journal"))
+		q := NewPeer(KeyFromString("journal-only-replica")) // 唯一的远端副本
+		p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+		value := []byte("journal-acks")
+		key := KeyFromBytes(value)
+		if n, err := p.SetValue(context.Background(), key[:], value); n != 2 || err != nil {
+			t.Fatalf("SetValue = %d, %v, want the local copy and one replica", n, err)
+		}
+		if got := pendingCount(t, j); got != tc.pending {
+			t.Fatalf("JournalAcks %d: %d pending entries, want %d", tc.acks, got, tc.pending)
+		}
+	}
+}
+
+// 已完成的记录让日志超过 JournalCompactSize 后，压缩只留下未完成的记录
+func TestJournalCompactsWhenGrown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	p := NewPeer(KeyFromString("journal-compact"))
+	j := openTestJournal(t, p, path)
+	value := make([]byte, 64<<10)
+	for i := 0; j.size <= JournalCompactSize; i++ {
+		key := KeyFromString(fmt.Sprintf("done-%d", i))
+		j.append(journalPending, key, value)
+		j.append(journalDone, key, nil)
+	}
+	pending := KeyFromString("still-pending")
+	j.append(journalPending, pending, []byte("pending"))
+	if err := p.compactJournal(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 1024 {
+		t.Fatalf("journal is %d bytes after compaction", info.Size())
+	}
+	entries, err := j.pending()
+	if err != nil || len(entries) != 1 || entries[0].key != pending {
+		t.Fatalf("pending after compaction = %v, %v, want the unfinished record", entries, err)
+	}
+	if j.grown() {
+		t.Fatal("journal still reports growth right after compaction")
+	}
+}
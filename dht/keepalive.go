@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 被关注节点的可达性变化
+type ReachabilityEvent struct {
+	ID        [kbucket.IdSize]byte
+	Reachable bool
+	Time      time.Time
+}
+
+type watchedPeer struct {
+	contact   kbucket.Node
+	known     bool // 是否已经获得过联系记录
+	reachable bool
+}
+
+// 关注一个应用希望保持可联系的节点（例如聊天对象）
+func (p *Peer) Watch(id [kbucket.IdSize]byte) {
+	if p.watched == nil {
+		p.watched = make(map[[kbucket.IdSize]byte]*watchedPeer)
+	}
+	if _, ok := p.watched[id]; !ok {
+		p.watched[id] = &watchedPeer{}
+	}
+}
+
+func (p *Peer) Unwatch(id [kbucket.IdSize]byte) {
+	delete(p.watched, id)
+}
+
+// 订阅被关注节点的可达性变化，缓冲区满时丢弃事件
+func (p *Peer) SubscribeReachability(buffer int) <-chan ReachabilityEvent {
+	ch := make(chan ReachabilityEvent, buffer)
+	p.reachSubs = append(p.reachSubs, ch)
+	return ch
+}
+
+// ping 所有被关注的节点，联系失败时通过 FindPeer 刷新其联系记录。
+// 由调用方按计划周期性调用，返回当前可达的节点数
+func (p *Peer) KeepAliveTick() int {
+	reachable := 0
+	for id, w := range p.watched {
+		ok := false
+		if w.known {
+			if _, alive := w.contact.Data.(*Peer); alive {
+				w.contact.LastSeen = time.Now()
+				ok = true
+			}
+		}
+		if !ok { // 旧记录失效，重新查找节点的最新联系方式
+			if rec, found := p.FindPeer(id); found {
+				if _, alive := rec.Data.(*Peer); alive {
+					w.contact, w.known, ok = rec, true, true
+				}
+			}
+		}
+		if ok {
+			reachable++
+		}
+		if ok != w.reachable {
+			w.reachable = ok
+			p.emitReachability(ReachabilityEvent{ID: id, Reachable: ok, Time: time.Now()})
+		}
+	}
+	return reachable
+}
+
+func (p *Peer) emitReachability(ev ReachabilityEvent) {
+	for _, ch := range p.reachSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 计算 b 的 key
+func KeyFromBytes(b []byte) [kbucket.IdSize]byte {
+	return keyWith(keyHasher, b)
+}
+
+func keyWith(hasher Hasher, b []byte) [kbucket.IdSize]byte {
+	h := hasher.New()
+	h.Write(b)
+	return MustKey(h.Sum(nil))
+}
+
+func KeyFromString(s string) [kbucket.IdSize]byte {
+	return KeyFromBytes([]byte(s))
+}
+
+// 以流的方式计算 r 中全部内容的 key，不需要把内容整体读入内存
+func KeyFromReader(r io.Reader) ([kbucket.IdSize]byte, error) {
+	h := keyHasher.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return [kbucket.IdSize]byte{}, err
+	}
+	return MustKey(h.Sum(nil)), nil
+}
+
+// 把已经计算好的摘要转换为 key，长度不等于 kbucket.IdSize 时 panic
+func MustKey(digest []byte) [kbucket.IdSize]byte {
+	if len(digest) != kbucket.IdSize {
+		panic(fmt.Sprintf("dht: key must be %d bytes, got %d", kbucket.IdSize, len(digest)))
+	}
+	var key [kbucket.IdSize]byte
+	copy(key[:], digest)
+	return key
+}
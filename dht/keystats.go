@@ -0,0 +1,106 @@
+package dht
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	sketchWidth     = 1024 // count-min 每行的计数器数量
+	sketchDepth     = 4    // count-min 的行数
+	hotKeyCandidate = 64   // 追踪的热点候选 key 数量上限
+)
+
+// count-min sketch：用固定空间估计每个 key 的访问次数，只会高估不会低估
+type countMin struct {
+	table [sketchDepth][sketchWidth]uint32
+}
+
+// key 本身就是哈希值，每一行取其中不同的 4 个字节作为下标
+func (c *countMin) add(key [kbucket.IdSize]byte) uint32 {
+	est := ^uint32(0)
+	for i := 0; i < sketchDepth; i++ {
+		j := binary.BigEndian.Uint32(key[i*4:]) % sketchWidth
+		c.table[i][j]++
+		if c.table[i][j] < est {
+			est = c.table[i][j]
+		}
+	}
+	return est
+}
+
+func (c *countMin) estimate(key [kbucket.IdSize]byte) uint32 {
+	est := ^uint32(0)
+	for i := 0; i < sketchDepth; i++ {
+		if n := c.table[i][binary.BigEndian.Uint32(key[i*4:])%sketchWidth]; n < est {
+			est = n
+		}
+	}
+	return est
+}
+
+type keyStats struct {
+	mu         sync.Mutex
+	gets       countMin
+	stores     countMin
+	candidates map[[kbucket.IdSize]byte]uint32 // 候选热点 key 及其估计的总访问次数
+}
+
+type KeyUsage struct {
+	Key    [kbucket.IdSize]byte
+	Gets   uint32
+	Stores uint32
+}
+
+func (s *keyStats) record(key [kbucket.IdSize]byte, store bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.candidates == nil {
+		s.candidates = make(map[[kbucket.IdSize]byte]uint32)
+	}
+	if store {
+		s.stores.add(key)
+	} else {
+		s.gets.add(key)
+	}
+	total := s.gets.estimate(key) + s.stores.estimate(key)
+	if _, ok := s.candidates[key]; ok || len(s.candidates) < hotKeyCandidate {
+		s.candidates[key] = total
+		return
+	}
+	var coldest [kbucket.IdSize]byte
+	min := ^uint32(0)
+	for k, n := range s.candidates {
+		if n < min {
+			coldest, min = k, n
+		}
+	}
+	if total > min { // 替换候选集中最冷的 key
+		delete(s.candidates, coldest)
+		s.candidates[key] = total
+	}
+}
+
+// 返回本节点上访问最多的 k 个 key（GET 与 STORE 次数均为估计值）
+func (p *Peer) HotKeys(k int) []KeyUsage {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	usage := make([]KeyUsage, 0, len(p.stats.candidates))
+	for key := range p.stats.candidates {
+		usage = append(usage, KeyUsage{
+			Key:    key,
+			Gets:   p.stats.gets.estimate(key),
+			Stores: p.stats.stores.estimate(key),
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Gets+usage[i].Stores > usage[j].Gets+usage[j].Stores
+	})
+	if k < len(usage) {
+		usage = usage[:k]
+	}
+	return usage
+}
@@ -0,0 +1,47 @@
+package dht
+
+import (
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 在与 target 距离相当（落在以 target 为准的同一个 bucket，即公共前缀长度相同）的节点之间
+// 优先选择本节点测得 RTT 较低的节点，没有测量过的排在同组的后面。ids 已按与 target 的
+// 距离排序，返回调整后的下标顺序，不同组之间的顺序不变
+func (p *Peer) latencyOrder(target [kbucket.IdSize]byte, ids [][kbucket.IdSize]byte) []int {
+	order := make([]int, len(ids))
+	cpl := make([]int, len(ids))
+	rtt := make([]time.Duration, len(ids))
+	p.peerStatsMu.Lock()
+	for i, id := range ids {
+		order[i] = i
+		cpl[i] = kbucket.CommonPrefixLen(id, target)
+		if s := p.peerStats[id]; s != nil {
+			rtt[i] = s.MeanRTT()
+		}
+	}
+	p.peerStatsMu.Unlock()
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if cpl[i] != cpl[j] {
+			return cpl[i] > cpl[j]
+		}
+		return rtt[i] > 0 && (rtt[j] == 0 || rtt[i] < rtt[j])
+	})
+	return order
+}
+
+// 按 latencyOrder 重新排列 contacts
+func (p *Peer) preferLowRTT(target [kbucket.IdSize]byte, contacts []Contact) []Contact {
+	ids := make([][kbucket.IdSize]byte, len(contacts))
+	for i, c := range contacts {
+		ids[i] = c.ID
+	}
+	sorted := make([]Contact, 0, len(contacts))
+	for _, i := range p.latencyOrder(target, ids) {
+		sorted = append(sorted, contacts[i])
+	}
+	return sorted
+}
@@ -0,0 +1,215 @@
+package dht
+
+import "time"
+
+// Peer 的生命周期状态
+type PeerState int
+
+const (
+	StateCreated       PeerState = iota // 已创建，尚未启动
+	StateBootstrapping                  // 正在填充路由表
+	StateReady                          // 路由表可用
+	StateDegraded                       // 路由表为空或不可用
+	StateStopping                       // 正在停止
+	StateStopped                        // 已停止
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case StateCreated:
+		return "Created"
+	case StateBootstrapping:
+		return "Bootstrapping"
+	case StateReady:
+		return "Ready"
+	case StateDegraded:
+		return "Degraded"
+	case StateStopping:
+		return "Stopping"
+	case StateStopped:
+		return "Stopped"
+	}
+	return "Unknown"
+}
+
+// 允许的状态转换
+var stateTransitions = map[PeerState][]PeerState{
+	StateCreated:       {StateBootstrapping, StateStopping},
+	StateBootstrapping: {StateReady, StateDegraded, StateStopping},
+	StateReady:         {StateDegraded, StateStopping},
+	StateDegraded:      {StateReady, StateBootstrapping, StateStopping},
+	StateStopping:      {StateStopped},
+}
+
+type StateChange struct {
+	From PeerState
+	To   PeerState
+	Time time.Time
+}
+
+func (p *Peer) State() PeerState {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	return p.state
+}
+
+// 订阅状态变化，缓冲区满时丢弃事件而不阻塞状态转换
+func (p *Peer) SubscribeState(buffer int) <-chan StateChange {
+	ch := make(chan StateChange, buffer)
+	p.stateSubsMu.Lock()
+	p.stateSubs = append(p.stateSubs, ch)
+	p.stateSubsMu.Unlock()
+	return ch
+}
+
+// 转换状态并通知订阅者。后台刷新与调用方可能同时转换状态，
+// 检查与修改在 stateMu 之内完成，通知在 stateMu 之外发送
+func (p *Peer) setState(to PeerState) bool {
+	p.stateMu.Lock()
+	allowed := false
+	for _, s := range stateTransitions[p.state] {
+		if s == to {
+			allowed = true
+		}
+	}
+	if !allowed {
+		p.stateMu.Unlock()
+		return false
+	}
+	ev := StateChange{From: p.state, To: to, Time: time.Now()}
+	p.state = to
+	p.stateMu.Unlock()
+
+	p.stateSubsMu.Lock()
+	defer p.stateSubsMu.Unlock()
+	for _, ch := range p.stateSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return true
+}
+
+// 启动节点：进入 Bootstrapping，路由表中已有节点时进入 Ready，
+// 并在后台按 RefreshInterval 刷新陈旧的 bucket、按 HealthCheckInterval 检查存活、
+// 按 FreshnessThreshold 逐个 ping 陈旧的联系人、与网络断开时重新加入，直到 Stop
+func (p *Peer) Start() bool {
+	if !p.setState(StateBootstrapping) {
+		return false
+	}
+	p.UpdateHealth()
+	p.refreshStop = make(chan struct{})
+	p.refreshDone = make(chan struct{})
+	go p.refreshLoop(p.refreshStop, p.refreshDone)
+	return true
+}
+
+// 每 RefreshInterval/4 检查一次，陈旧的 bucket 最迟在 1.25 倍刷新间隔内得到刷新；
+// 配置了 HealthCheckInterval 时同时周期性执行 HealthCheck，配置了 FreshnessThreshold 时
+// 按 ProbeRate 执行 ProbeTick；与网络断开时按 RejoinInterval 退避重新加入。
+// 刷新、存活检查与 ping 的等待时间按 Jitter 抖动
+func (p *Peer) refreshLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	interval := p.cfg.RefreshInterval / 4
+	if interval <= 0 {
+		interval = p.cfg.RefreshInterval
+	}
+	refresh := time.NewTimer(p.jittered(interval))
+	defer refresh.Stop()
+	var health <-chan time.Time // 未配置存活检查时为 nil，不会触发
+	var healthTimer *time.Timer
+	if p.cfg.HealthCheckInterval > 0 {
+		healthTimer = time.NewTimer(p.jittered(p.cfg.HealthCheckInterval))
+		defer healthTimer.Stop()
+		health = healthTimer.C
+	}
+	var probe <-chan time.Time // 不主动 ping 联系人时为 nil
+	var probeTimer *time.Timer
+	probeInterval := time.Duration(float64(time.Second) / p.cfg.ProbeRate)
+	if p.cfg.FreshnessThreshold > 0 {
+		probeTimer = time.NewTimer(p.jittered(probeInterval))
+		defer probeTimer.Stop()
+		probe = probeTimer.C
+	}
+	var rejoin <-chan time.Time // 不自动重新加入时为 nil
+	if p.cfg.RejoinInterval > 0 {
+		ticker := time.NewTicker(p.cfg.RejoinInterval)
+		defer ticker.Stop()
+		rejoin = ticker.C
+	}
+	var snapshot <-chan time.Time // 不定期保存快照时为 nil
+	if p.cfg.SnapshotPath != "" && p.cfg.SnapshotInterval > 0 {
+		ticker := time.NewTicker(p.cfg.SnapshotInterval)
+		defer ticker.Stop()
+		snapshot = ticker.C
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-refresh.C:
+			p.RefreshBuckets()
+			refresh.Reset(p.jittered(interval))
+		case <-health:
+			p.HealthCheck()
+			healthTimer.Reset(p.jittered(p.cfg.HealthCheckInterval))
+		case <-probe:
+			p.ProbeTick()
+			probeTimer.Reset(p.jittered(probeInterval))
+		case now := <-rejoin:
+			p.maybeRejoin(now)
+		case <-snapshot:
+			p.SaveSnapshot(p.cfg.SnapshotPath) // 写入失败时保留上一次的快照，下一次再试
+		}
+	}
+}
+
+// 根据路由表重新评估节点状态：没有任何联系人时为 Degraded，否则为 Ready。
+// 同时重新计算路由健康分
+func (p *Peer) UpdateHealth() PeerState {
+	p.checkHealthScore()
+	switch state := p.State(); state {
+	case StateBootstrapping, StateReady, StateDegraded:
+		if len(p.kb.AllNodes()) == 0 {
+			if state != StateBootstrapping {
+				p.setState(StateDegraded)
+			}
+		} else {
+			p.setState(StateReady)
+		}
+	}
+	return p.State()
+}
+
+// 停止节点，关闭发布日志并通知所有订阅者
+func (p *Peer) Stop() error {
+	if !p.setState(StateStopping) {
+		return nil
+	}
+	if p.refreshStop != nil { // 等待正在进行的刷新结束
+		close(p.refreshStop)
+		<-p.refreshDone
+		p.refreshStop, p.refreshDone = nil, nil
+	}
+	if q := p.queue(); q != nil { // 排队的 STORE 写完后再停止
+		q.close()
+	}
+	var err error
+	if p.cfg.SnapshotPath != "" {
+		err = p.SaveSnapshot(p.cfg.SnapshotPath)
+	}
+	if p.journal != nil {
+		if jerr := p.journal.Close(); err == nil {
+			err = jerr
+		}
+	}
+	p.setState(StateStopped)
+	p.stateSubsMu.Lock()
+	for _, ch := range p.stateSubs {
+		close(ch)
+	}
+	p.stateSubs = nil
+	p.stateSubsMu.Unlock()
+	return err
+}
@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 一组在本地一起生效的写入与删除，例如分块保存的值的各个分块与清单，或者墓碑与新 key
+// 的值。按调用顺序应用，通过 Peer.StoreLocal 提交
+type LocalBatch struct {
+	ops []txOp
+}
+
+func (b *LocalBatch) Put(key [kbucket.IdSize]byte, value []byte) {
+	b.ops = append(b.ops, txOp{rec: StoredRecord{Key: key, Value: append([]byte(nil), value...)}})
+}
+
+func (b *LocalBatch) Delete(key [kbucket.IdSize]byte) {
+	b.ops = append(b.ops, txOp{rec: StoredRecord{Key: key}, delete: true})
+}
+
+func (b *LocalBatch) Len() int {
+	return len(b.ops)
+}
+
+// 在本地一起保存 b 中的写入并删除其中的 key，不向其他节点复制。每个值先经过 Validator
+// 检查，有一个不通过或存储放不下时什么都不写入（存储已满时返回 ErrBusy）。
+// 存储后端实现 TxStorage 时作为一个事务提交，崩溃之后也不会只留下其中的一部分
+func (p *Peer) StoreLocal(b *LocalBatch) error {
+	if err := p.faults.storeError(); err != nil {
+		return err
+	}
+	size := 0
+	for _, op := range b.ops {
+		if op.delete {
+			continue
+		}
+		if err := p.validate(op.rec.Key, op.rec.Value); err != nil {
+			return err
+		}
+		size += len(op.rec.Value)
+	}
+	if p.storeFull() || p.storeOverBudget(size) {
+		return ErrBusy
+	}
+	if err := p.store.commit(b.ops, p.ownOrigin()); err != nil {
+		return err
+	}
+	for _, op := range b.ops {
+		key := op.rec.Key
+		p.dropSiblings(key)
+		if op.delete {
+			continue
+		}
+		p.stats.record(key, true)
+		p.forgetMiss(key)
+		p.emitStore(ValueStored, key)
+		p.notifyWatchers(key)
+	}
+	return nil
+}
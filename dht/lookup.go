@@ -0,0 +1,298 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 候选列表中的一个节点
+type shortlistEntry struct {
+	node    kbucket.Node
+	queried bool
+	failed  bool // 无法联系，不计入结果
+	pinned  bool // 应用固定的首选节点，不在最近的 K 个之内也要查询
+}
+
+// 通过 m 向 c 发出的查询。同一轮的查询在各自的 goroutine 中并发进行，只应访问网络；
+// 返回的 iterReply 在发起查找的 goroutine 中调用，处理响应
+type iterQuery func(ctx context.Context, m Messenger, c Contact) iterReply
+
+// 返回 c 给出的更近的节点；done 为 true 时立即结束查找，err 不为 nil 时视为联系失败
+type iterReply func() (nodes []Contact, done bool, err error)
+
+// 一个已完成的查询
+type iterResult struct {
+	i     int // 在候选列表中的下标
+	c     Contact
+	start time.Time
+	reply iterReply
+}
+
+// Kademlia 迭代查找（FIND_NODE）：每一轮向候选列表中 Alpha 个最近且尚未查询的
+// 节点请求它们最近的节点，合并进候选列表；当最近的 K 个节点都已查询过时
+// 结束。返回距离 target 最近的至多 K 个节点，按距离从近到远排序。
+// ctx 结束或超出跳数限制时返回已经查询过的节点以及中断的原因
+func (p *Peer) Lookup(ctx context.Context, target [kbucket.IdSize]byte) ([]Contact, error) {
+	budget := p.newLookupBudget(ctx)
+	closest := p.lookup(target, budget)
+	return contactsOf(closest), budget.err
+}
+
+func (p *Peer) lookup(target [kbucket.IdSize]byte, budget *lookupBudget) []kbucket.Node {
+	closest, _ := p.iterate(target, budget, OpFindNode, func(ctx context.Context, m Messenger, c Contact) iterReply {
+		nodes, err := m.FindNode(ctx, c, target)
+		return func() ([]Contact, bool, error) { return nodes, false, err }
+	})
+	return closest
+}
+
+// 迭代查找的公共部分，op 为每一跳通知 Hooks 时使用的操作类型。
+// 返回已查询过的最近的至多 K 个节点（包括使查找提前结束的节点），
+// 以及使查找提前结束的节点，没有时为 nil
+func (p *Peer) iterate(target [kbucket.IdSize]byte, budget *lookupBudget, op string, query iterQuery) ([]kbucket.Node, *Contact) {
+	if !p.acquire(ResourceLookups, op) {
+		budget.err = ErrBusy
+		return nil, nil
+	}
+	defer p.release(ResourceLookups)
+	seen := map[[kbucket.IdSize]byte]bool{p.node.ID: true}
+	var shortlist []shortlistEntry
+	merge := func(nodes []kbucket.Node) {
+		for _, n := range nodes {
+			if seen[n.ID] {
+				continue
+			}
+			seen[n.ID] = true
+			i := len(shortlist)
+			for i > 0 && p.closer(n.ID, shortlist[i-1].node.ID, target) {
+				i--
+			}
+			shortlist = append(shortlist, shortlistEntry{})
+			copy(shortlist[i+1:], shortlist[i:])
+			shortlist[i] = shortlistEntry{node: n}
+		}
+	}
+	p.kb.Touch(p.kb.BucketIndex(target))
+	merge(p.kb.FindClosestNodes(target, p.cfg.K))
+	for _, c := range p.pinnedFor(target, budget.value) {
+		merge([]kbucket.Node{c.node()})
+		for i := range shortlist {
+			if shortlist[i].node.ID == c.ID {
+				shortlist[i].pinned = true
+			}
+		}
+	}
+	ctx := ContextWithTrace(budget.ctx, budget.trace)
+	budget.converged = false
+	var hints map[[kbucket.IdSize]byte]QualityHint // 响应方给出的质量提示
+	if p.cfg.RTTHints {
+		hints = make(map[[kbucket.IdSize]byte]QualityHint)
+	}
+	var stop *Contact
+	hops := 0
+	// 至多 n 个尚未查询的候选下标，回复过 BUSY 的节点排在最后；距离相当的候选中优先选择 RTT 较低的
+	pick := func(n int) []int {
+		var picked []int
+		for pass := 0; pass < 2; pass++ {
+			var eligible []int
+			var ids [][kbucket.IdSize]byte
+			live := 0 // 排在前面且没有联系失败的候选数，失败的节点不占用 K 个名额
+			for i := range shortlist {
+				if shortlist[i].failed {
+					continue
+				}
+				live++
+				if live > p.cfg.K && !shortlist[i].pinned {
+					continue
+				}
+				if !shortlist[i].queried && p.throttled(shortlist[i].node.ID) == (pass == 1) {
+					eligible = append(eligible, i)
+					ids = append(ids, shortlist[i].node.ID)
+				}
+			}
+			for _, j := range p.latencyOrder(target, ids) {
+				if len(picked) == n {
+					break
+				}
+				picked = append(picked, eligible[j])
+			}
+		}
+		return picked
+	}
+	for stop == nil {
+		width := p.LookupWidth() // 每轮按最近的失败比例重新计算
+		if hints != nil {
+			width *= 2
+		}
+		round := pick(width)
+		if len(round) == 0 { // 最近的 K 个节点与首选节点都已查询
+			budget.converged = len(closestQueried(shortlist, p.cfg.K)) > 0
+			break
+		}
+		if hints != nil {
+			round = p.preferFast(round, shortlist, hints)
+		}
+		// 本轮的查询同时发出，在这里依次处理响应，慢的节点不会推迟同一轮的其他查询
+		roundCtx, cancel := context.WithCancel(ctx)
+		results := make(chan iterResult, 2*len(round)) // 每个查询至多对冲一次；提前结束时剩下的查询不会阻塞
+		pending := make(map[int]bool, len(round))
+		hedgeAt := make(map[int]time.Time) // 尚未回复的查询在这个时刻之后对冲
+		hedged := make(map[int]bool)       // 已对冲的慢查询，不再推迟本轮结束
+		// 向候选 i 发出查询，没有可用的 Messenger 时返回 false
+		send := func(i int) bool {
+			c := contactOf(shortlist[i].node)
+			m := p.messengerFor(c)
+			shortlist[i].queried = true
+			if m == nil {
+				shortlist[i].failed = true
+				return false
+			}
+			hops++
+			pending[i] = true
+			start := time.Now()
+			if p.cfg.HedgeLookups {
+				if d := p.hedgeAfter(c.ID); d > 0 {
+					hedgeAt[i] = start.Add(d)
+				}
+			}
+			go func() {
+				results <- iterResult{i: i, c: c, start: start, reply: query(roundCtx, m, c)} // 响应方由 Messenger 加入路由表
+			}()
+			return true
+		}
+		for _, i := range round {
+			if !budget.spend() { // 预算用尽时没有联系的候选保持未查询，不计入结果
+				break
+			}
+			send(i)
+		}
+		var learned []kbucket.Node
+		for len(pending) > len(hedged) && stop == nil {
+			slow := -1
+			for i, at := range hedgeAt {
+				if slow < 0 || at.Before(hedgeAt[slow]) {
+					slow = i
+				}
+			}
+			var r iterResult
+			var timer *time.Timer
+			var hedge <-chan time.Time
+			if slow >= 0 {
+				timer = time.NewTimer(time.Until(hedgeAt[slow]))
+				hedge = timer.C
+			}
+			select {
+			case r = <-results:
+			case <-hedge:
+				// 超过对方 RTT 的 p95 仍未回复：查询下一个候选，预算用尽时不再对冲
+				delete(hedgeAt, slow)
+				for next := pick(1); len(next) > 0 && budget.left > 0 && budget.spend(); next = pick(1) {
+					if send(next[0]) {
+						hedged[slow] = true
+						atomic.AddUint64(&p.hedged, 1)
+						break
+					}
+				}
+				continue
+			case <-ctx.Done(): // 不再等待本轮其余的响应，返回目前的结果与中断的原因
+				budget.err = ctx.Err()
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if r.reply == nil {
+				break
+			}
+			delete(pending, r.i)
+			delete(hedgeAt, r.i)
+			delete(hedged, r.i) // 对冲之后仍在本轮结束前回复的响应照常处理
+			nodes, done, err := r.reply()
+			if err != nil && ctx.Err() != nil { // 调用方放弃了等待，不能据此判断对方失败
+				shortlist[r.i].queried = false
+				budget.err = ctx.Err()
+				break
+			}
+			p.alpha.observe(errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnreachable))
+			if err != nil {
+				p.observe(r.c.ID, false, 0)
+				shortlist[r.i].failed = true
+				continue
+			}
+			nodes = p.checkContacts(r.c.ID, nodes)
+			p.traceHop(r.c, nodes, r.start, done)
+			if done {
+				stop = &r.c
+				break
+			}
+			for _, n := range nodes {
+				learned = append(learned, n.node())
+				if hints != nil && n.Hint.RTT > 0 {
+					hints[n.ID] = n.Hint
+				}
+			}
+		}
+		cancel()
+		for i := range pending {
+			if hedged[i] { // 被对冲放弃的慢节点在本次查找中按失败处理，不计入节点统计
+				shortlist[i].failed = true
+			} else { // 提前结束时没有收到的响应不计入结果
+				shortlist[i].queried = false
+			}
+		}
+		if budget.err != nil {
+			if budget.exceeded {
+				atomic.AddUint64(&p.depthExceeded, 1)
+			}
+			break
+		}
+		merge(learned)
+		p.acceptLearned(learned)
+		if budget.progress != nil {
+			budget.progress(closestQueried(shortlist, p.cfg.K), hops)
+		}
+		if budget.settled != nil {
+			budget.settled(settledPrefix(shortlist, p.cfg.K))
+		}
+	}
+	if budget.err == nil && stop == nil {
+		budget.err = ctx.Err() // 最后一轮中 ctx 结束时结果同样不完整
+	}
+	closest := closestQueried(shortlist, p.cfg.K)
+	atomic.AddUint64(&p.lookups, 1)
+	p.recordLookup(len(closest) > 0)
+	p.metricLookup(op, hops)
+	return closest, stop
+}
+
+// 候选列表开头已经回复过的至多 k 个节点：比它们更近的候选都已查询（或联系失败），
+// 只有之后的响应带来更近的节点时它们才会被挤出结果
+func settledPrefix(shortlist []shortlistEntry, k int) []kbucket.Node {
+	var settled []kbucket.Node
+	for _, e := range shortlist {
+		if len(settled) == k || !e.queried {
+			break
+		}
+		if !e.failed {
+			settled = append(settled, e.node)
+		}
+	}
+	return settled
+}
+
+// 候选列表中已查询且联系成功的最近的至多 k 个节点
+func closestQueried(shortlist []shortlistEntry, k int) []kbucket.Node {
+	closest := make([]kbucket.Node, 0, k)
+	for _, e := range shortlist {
+		if len(closest) == k {
+			break
+		}
+		if e.queried && !e.failed {
+			closest = append(closest, e.node)
+		}
+	}
+	return closest
+}
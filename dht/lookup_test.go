@@ -0,0 +1,567 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 网络联系人的 Messenger：slow 中的节点延迟 delay 后才回复，其余节点对 FIND_VALUE
+// 返回 value；记录每次查询的开始时间
+type slowMessenger struct {
+	slow  map[[kbucket.IdSize]byte]bool
+	delay time.Duration
+	value []byte
+
+	mu      sync.Mutex
+	started map[[kbucket.IdSize]byte]time.Time
+}
+
+func (m *slowMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	return to.ID, nil
+}
+
+// 记录查询开始，慢节点等待 delay，返回是否是慢节点
+func (m *slowMessenger) query(to Contact) bool {
+	m.mu.Lock()
+	m.started[to.ID] = time.Now()
+	m.mu.Unlock()
+	if m.slow[to.ID] {
+		time.Sleep(m.delay)
+		return true
+	}
+	return false
+}
+
+func (m *slowMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	m.query(to)
+	return nil, nil
+}
+
+func (m *slowMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	if m.query(to) {
+		return nil, nil, nil
+	}
+	return m.value, nil, nil
+}
+
+func (m *slowMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	return nil
+}
+
+// 向 p 的路由表加入 Alpha 个网络联系人，只有第一个是快节点：不管距离顺序如何，
+// 它都排在某个慢节点之后
+func newSlowRound(t *testing.T, p *Peer) *slowMessenger {
+	t.Helper()
+	m := &slowMessenger{slow: make(map[[kbucket.IdSize]byte]bool), delay: 300 * time.Millisecond, started: make(map[[kbucket.IdSize]byte]time.Time)}
+	p.SetMessenger(m)
+	for i := 0; len(m.slow) < p.cfg.Alpha; i++ {
+		id := KeyFromString(string(rune('a' + i)))
+		addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i+1)), Port: 4000}
+		if p.kb.InsertNode(kbucket.Node{ID: id, Data: addr}) {
+			m.slow[id] = len(m.slow) > 0
+		}
+	}
+	return m
+}
+
+// 同一轮的 Alpha 个查询同时发出，慢节点不推迟其他查询
+func TestLookupRoundIsConcurrent(t *testing.T) {
+	p := NewPeer(KeyFromString("lookup-self"))
+	m := newSlowRound(t, p)
+	begin := time.Now()
+	if _, err := p.Lookup(context.Background(), KeyFromString("lookup-target")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed >= 2*m.delay {
+		t.Fatalf("lookup took %v, slow contacts were queried one after another", elapsed)
+	}
+	if len(m.started) != len(m.slow) {
+		t.Fatalf("queried %d of %d contacts", len(m.started), len(m.slow))
+	}
+	for id, at := range m.started {
+		if d := at.Sub(begin); d >= m.delay {
+			t.Fatalf("query to %x started %v after the lookup, behind a slow contact", id[:4], d)
+		}
+	}
+}
+
+// 快节点返回值后查找立即结束，不等待同一轮中尚未回复的慢节点
+func TestFindValueStopsBeforeStragglers(t *testing.T) {
+	p := NewPeer(KeyFromString("lookup-self"))
+	m := newSlowRound(t, p)
+	m.value = []byte("lookup-straggler")
+	begin := time.Now()
+	value, _, err := p.FindValue(context.Background(), KeyFromBytes(m.value))
+	if err != nil || string(value) != string(m.value) {
+		t.Fatalf("FindValue = %q, %v", value, err)
+	}
+	if elapsed := time.Since(begin); elapsed >= m.delay {
+		t.Fatalf("FindValue took %v, waited for slow contacts", elapsed)
+	}
+}
+
+// 网络联系人的 Messenger：dead 中的节点超时，其余节点对 FIND_NODE 返回 next 中的联系人
+type deadMessenger struct {
+	dead map[[kbucket.IdSize]byte]bool
+	next map[[kbucket.IdSize]byte][]Contact
+
+	mu      sync.Mutex
+	queried map[[kbucket.IdSize]byte]bool
+}
+
+func (m *deadMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	return to.ID, nil
+}
+
+func (m *deadMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	m.mu.Lock()
+	m.queried[to.ID] = true
+	m.mu.Unlock()
+	if m.dead[to.ID] {
+		return nil, ErrTimeout
+	}
+	return m.next[to.ID], nil
+}
+
+func (m *deadMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	return nil, nil, ErrTimeout
+}
+
+func (m *deadMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	return nil
+}
+
+func netContact(first byte) Contact {
+	var id [kbucket.IdSize]byte
+	id[0] = first
+	return Contact{ID: id, Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 1, first), Port: 4000}}
+}
+
+// 排在候选列表最前面的失效节点不占用 K 个名额，之后学到的存活节点仍然会被查询
+func TestLookupSkipsDeadContacts(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p, err := NewPeerWithConfig(self, Config{K: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead1, dead2, live := netContact(0x01), netContact(0x02), netContact(0x10)
+	far1, far2 := netContact(0x20), netContact(0x30)
+	m := &deadMessenger{
+		dead:    map[[kbucket.IdSize]byte]bool{dead1.ID: true, dead2.ID: true},
+		next:    map[[kbucket.IdSize]byte][]Contact{live.ID: {far1, far2}},
+		queried: make(map[[kbucket.IdSize]byte]bool),
+	}
+	p.SetMessenger(m)
+	for _, c := range []Contact{dead1, dead2, live} {
+		p.kb.InsertNode(c.node())
+	}
+	closest, err := p.Lookup(context.Background(), [kbucket.IdSize]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(closest) != 3 {
+		t.Fatalf("Lookup returned %d contacts, want 3", len(closest))
+	}
+	for _, c := range []Contact{far1, far2} {
+		if !m.queried[c.ID] {
+			t.Fatalf("live contact %x was never queried", c.ID[:1])
+		}
+	}
+}
+
+// 跳数预算用尽后没有联系过的候选不计入结果
+func TestLookupBudgetUnqueried(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p := NewPeer(self)
+	p.SetMaxLookupHops(1)
+	m := &deadMessenger{queried: make(map[[kbucket.IdSize]byte]bool)}
+	p.SetMessenger(m)
+	for _, first := range []byte{0x01, 0x02, 0x03} {
+		p.kb.InsertNode(netContact(first).node())
+	}
+	closest, err := p.Lookup(context.Background(), [kbucket.IdSize]byte{})
+	if err != ErrLookupDepthExceeded {
+		t.Fatalf("Lookup error = %v, want ErrLookupDepthExceeded", err)
+	}
+	if len(closest) != len(m.queried) || len(closest) != 1 {
+		t.Fatalf("Lookup returned %d contacts, contacted %d, want 1", len(closest), len(m.queried))
+	}
+}
+
+// 默认跳数上限随 K 放宽；查找进行时修改上限需配合 go test -race 运行
+func TestMaxLookupHops(t *testing.T) {
+	if n := NewPeer(KeyFromString("hops-default")).maxLookupHops(); n != DefaultMaxLookupHops {
+		t.Fatalf("default hop limit = %d, want %d", n, DefaultMaxLookupHops)
+	}
+	wide, err := NewPeerWithConfig(KeyFromString("hops-wide"), Config{K: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := wide.maxLookupHops(); n != 120 {
+		t.Fatalf("hop limit with K=40 = %d, want 120", n)
+	}
+	peers := newTestNetwork(16, 5)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			peers[0].Lookup(context.Background(), KeyFromString(fmt.Sprint("hops-", i)))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		peers[0].SetMaxLookupHops(i % 4)
+	}
+	wg.Wait()
+}
+
+// GetValueContext 与 LookupEngine 通过 Messenger 联系网络中的节点
+func TestNetworkOnlyLookups(t *testing.T) {
+	p := NewPeer(KeyFromString("lookup-self"))
+	m := newSlowRound(t, p)
+	m.delay = 0
+	m.value = []byte("lookup-partial")
+	result, err := p.GetValueContext(context.Background(), KeyFromBytes(m.value))
+	if err != nil || string(result.Value) != string(m.value) {
+		t.Fatalf("GetValueContext = %q, %v", result.Value, err)
+	}
+	if result.Contacted == 0 {
+		t.Fatal("GetValueContext found a value without contacting anyone")
+	}
+	e := NewLookupEngine(p)
+	if closest := e.Lookup(KeyFromString("lookup-target")); len(closest) != len(m.slow) {
+		t.Fatalf("LookupEngine returned %d of %d network contacts", len(closest), len(m.slow))
+	}
+	rpcs := e.RPCs
+	e.Lookup(KeyFromString("lookup-target"))
+	if e.RPCs != rpcs {
+		t.Fatalf("repeated lookup sent %d more FIND_NODE, want cached responses", e.RPCs-rpcs)
+	}
+}
+
+// 网络联系人的 Messenger：responder 按与目标的距离返回 nodes 中最近的 K 个，其余节点返回空列表
+type tableMessenger struct {
+	responder [kbucket.IdSize]byte
+	nodes     []Contact
+	k         int
+}
+
+func (m tableMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	return to.ID, nil
+}
+
+func (m tableMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	if to.ID != m.responder {
+		return nil, nil
+	}
+	nodes := append([]Contact(nil), m.nodes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := kbucket.Distance(nodes[i].ID, target), kbucket.Distance(nodes[j].ID, target)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	return nodes[:min(m.k, len(nodes))], nil
+}
+
+func (m tableMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	return nil, nil, nil
+}
+
+func (m tableMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	return nil
+}
+
+func firstByteKey(b byte) [kbucket.IdSize]byte {
+	var key [kbucket.IdSize]byte
+	key[0] = b
+	return key
+}
+
+// 目标所在的 bucket 不满 K 个节点时，同一个 bucket 中的两个目标得到不同的响应，
+// LookupEngine 不能把一个目标的响应用于另一个目标；缓存的响应过期后重新查询
+func TestLookupEngineExactTarget(t *testing.T) {
+	p, err := NewPeerWithConfig(firstByteKey(0x01), Config{K: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := firstByteKey(0x00)
+	m := tableMessenger{responder: q, k: 3}
+	for i, b := range []byte{0x80, 0x40, 0xc0, 0x01} { // q 的第一个 bucket 中只有 0x80 与 0xc0
+		m.nodes = append(m.nodes, Contact{ID: firstByteKey(b), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 1, byte(i+1)), Port: 4000}})
+	}
+	m.nodes[3].ID[kbucket.IdSize-1] = 1 // 与 p 区分
+	p.SetMessenger(m)
+	p.kb.InsertNode(kbucket.Node{ID: q, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+
+	ids := func(nodes []kbucket.Node) []byte {
+		var first []byte
+		for _, n := range nodes {
+			first = append(first, n.ID[0])
+		}
+		sort.Slice(first, func(i, j int) bool { return first[i] < first[j] })
+		return first
+	}
+	e := NewLookupEngine(p)
+	if got := ids(e.Lookup(firstByteKey(0x80))); fmt.Sprintf("%x", got) != "0080c0" {
+		t.Fatalf("Lookup(0x80) = %x, want 00 80 c0", got)
+	}
+	if got := ids(e.Lookup(firstByteKey(0xc0))); fmt.Sprintf("%x", got) != "4080c0" {
+		t.Fatalf("Lookup(0xc0) after Lookup(0x80) = %x, want 40 80 c0", got)
+	}
+
+	rpcs := e.RPCs
+	e.Lookup(firstByteKey(0xc0))
+	if e.RPCs != rpcs {
+		t.Fatalf("repeated lookup sent %d more FIND_NODE, want cached responses", e.RPCs-rpcs)
+	}
+	p.Faults().JumpClock(engineCacheTTL)
+	e.Lookup(firstByteKey(0xc0))
+	if e.RPCs == rpcs {
+		t.Fatal("lookup after the cache TTL sent no FIND_NODE")
+	}
+
+	for i := 0; i < engineCacheSize+10; i++ {
+		e.findNode(context.Background(), m, Contact{ID: q}, KeyFromString(fmt.Sprint("engine-", i)))
+	}
+	if len(e.cache) > engineCacheSize {
+		t.Fatalf("cache holds %d responses, limit %d", len(e.cache), engineCacheSize)
+	}
+}
+
+// 只在进程内工作的操作遇到只能通过网络联系的副本时报错，不把写入悄悄留在本地
+func TestInProcessOnlyFailsLoudly(t *testing.T) {
+	p := NewPeer(KeyFromString("lookup-self"))
+	newSlowRound(t, p)
+	key := KeyFromString("multi")
+	if err := p.AppendValue(key, []byte("v"), time.Minute); err != ErrRemoteReplicas {
+		t.Fatalf("AppendValue = %v, want ErrRemoteReplicas", err)
+	}
+	if _, err := p.GetValues(key); err != ErrRemoteReplicas {
+		t.Fatalf("GetValues = %v, want ErrRemoteReplicas", err)
+	}
+	if _, err := p.WatchKey(key, 1); err != ErrRemoteReplicas {
+		t.Fatalf("WatchKey = %v, want ErrRemoteReplicas", err)
+	}
+	lone := NewPeer(KeyFromString("lookup-lone")) // 没有任何联系人时只在本地保存
+	if err := lone.AppendValue(key, []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// FindPeer 通过迭代查找找到只能通过网络联系、本地路由表中没有的节点
+func TestFindPeerOverNetwork(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p := NewPeer(self)
+	via, target := netContact(0x10), netContact(0x20)
+	m := &deadMessenger{
+		next:    map[[kbucket.IdSize]byte][]Contact{via.ID: {target}},
+		queried: make(map[[kbucket.IdSize]byte]bool),
+	}
+	p.SetMessenger(m)
+	p.kb.InsertNode(via.node())
+	node, ok := p.FindPeer(target.ID)
+	if !ok || node.ID != target.ID {
+		t.Fatalf("FindPeer = %x, %v", node.ID[:1], ok)
+	}
+	if addr, _ := node.Data.(*net.UDPAddr); addr == nil || !addr.IP.Equal(target.Addr.IP) {
+		t.Fatalf("FindPeer returned address %v, want %v", node.Data, target.Addr)
+	}
+}
+
+// 统计同时进行的 FIND_NODE 数；down 为 true 时所有查询超时
+type inflightMessenger struct {
+	deadMessenger
+	mu       sync.Mutex
+	down     bool
+	inflight int
+	peak     int
+}
+
+func (m *inflightMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	m.mu.Lock()
+	m.inflight++
+	if m.inflight > m.peak {
+		m.peak = m.inflight
+	}
+	down := m.down
+	m.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	m.mu.Lock()
+	m.inflight--
+	m.mu.Unlock()
+	if down {
+		return nil, ErrTimeout
+	}
+	return nil, nil
+}
+
+// 查询频繁超时时每轮的并发度提高到 MaxAlpha，响应恢复后回落到 Alpha
+func TestAdaptiveAlpha(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p, err := NewPeerWithConfig(self, Config{K: 4, Alpha: 1, MaxAlpha: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &inflightMessenger{down: true}
+	p.SetMessenger(m)
+	for _, first := range []byte{0x01, 0x02, 0x03, 0x04} {
+		p.kb.InsertNode(netContact(first).node())
+	}
+	lookupUntil := func(width int) {
+		t.Helper()
+		for i := 0; i < 50 && p.LookupWidth() != width; i++ {
+			p.Lookup(context.Background(), [kbucket.IdSize]byte{})
+		}
+		if got := p.LookupWidth(); got != width {
+			t.Fatalf("LookupWidth = %d, want %d", got, width)
+		}
+	}
+	peakOfLookup := func() int {
+		m.mu.Lock()
+		m.peak = 0
+		m.mu.Unlock()
+		p.Lookup(context.Background(), [kbucket.IdSize]byte{})
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.peak
+	}
+
+	lookupUntil(3)
+	m.mu.Lock()
+	m.down = false
+	m.mu.Unlock()
+	if peak := peakOfLookup(); peak != 3 {
+		t.Fatalf("%d concurrent queries after timeouts, want MaxAlpha (3)", peak)
+	}
+	lookupUntil(1)
+	if peak := peakOfLookup(); peak != 1 {
+		t.Fatalf("%d concurrent queries after recovery, want Alpha (1)", peak)
+	}
+}
+
+// hang 中的节点直到查询取消或 1 秒之后才超时，其余节点立即回复
+type hangingMessenger struct {
+	deadMessenger
+	hang map[[kbucket.IdSize]byte]bool
+}
+
+func (m *hangingMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	if m.hang[to.ID] {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return nil, ErrTimeout
+		}
+	}
+	return nil, nil
+}
+
+// 查询超过对方 RTT 的 p95 仍未回复时对冲到下一个候选，查找不等待慢节点超时
+func TestHedgedLookup(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p, err := NewPeerWithConfig(self, Config{K: 3, Alpha: 1, HedgeLookups: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := netContact(0x01)
+	p.SetMessenger(&hangingMessenger{hang: map[[kbucket.IdSize]byte]bool{slow.ID: true}})
+	for _, c := range []Contact{slow, netContact(0x02), netContact(0x03)} {
+		p.kb.InsertNode(c.node())
+	}
+	for i := 0; i < hedgeMinSamples; i++ {
+		p.observe(slow.ID, true, 5*time.Millisecond)
+	}
+	begin := time.Now()
+	closest, err := p.Lookup(context.Background(), [kbucket.IdSize]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed >= 500*time.Millisecond {
+		t.Fatalf("lookup took %v, waited for the slow contact", elapsed)
+	}
+	if len(closest) != 2 {
+		t.Fatalf("Lookup returned %d contacts, want the 2 responders", len(closest))
+	}
+	for _, c := range closest {
+		if c.ID == slow.ID {
+			t.Fatal("hedged-out contact counted as a responder")
+		}
+	}
+	if s := p.OpStats(); s.HedgedRequests != 1 || s.Lookups != 1 {
+		t.Fatalf("OpStats = %+v, want 1 lookup with 1 hedged request", s)
+	}
+}
+
+// 按 ContactAcceptance 处理恶意节点在响应中给出的联系人：真实节点 real 与不可达的伪造节点
+func TestContactAcceptance(t *testing.T) {
+	for _, mode := range []ContactAcceptance{AcceptResponders, AcceptAll, AcceptVerified} {
+		t.Run(mode.String(), func(t *testing.T) {
+			p, err := NewPeerWithConfig(KeyFromString("accept-self"), Config{ContactAcceptance: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			attacker, real := NewPeer(KeyFromString("accept-attacker")), NewPeer(KeyFromString("accept-real"))
+			bogus := Contact{ID: KeyFromString("accept-bogus"), Addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}}
+			attacker.Faults().ForgeContacts([]Contact{{ID: real.ID(), Peer: real}, bogus})
+			p.kb.InsertNode(kbucket.Node{ID: attacker.ID(), Data: attacker})
+			p.SetMaxLookupHops(1) // 只查询 attacker，学到的联系人不在查找中联系
+			p.Lookup(context.Background(), KeyFromString("accept-target"))
+
+			wantReal := mode != AcceptResponders
+			deadline := time.Now().Add(time.Second)
+			for knows(p, real) != wantReal && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if knows(p, real) != wantReal {
+				t.Fatalf("real contact in the routing table = %v, want %v", !wantReal, wantReal)
+			}
+			_, forged := p.kb.GetBucket(p.kb.BucketIndex(bogus.ID)).FindNode(bogus.ID)
+			if forged != (mode == AcceptAll) {
+				t.Fatalf("forged contact in the routing table = %v under %v", forged, mode)
+			}
+		})
+	}
+}
+
+// ctx 的截止时间到达时查找不再等待不回复的 UDP 节点，返回部分结果与 ctx.Err()
+func TestGetValueContextDeadline(t *testing.T) {
+	p := NewPeer(KeyFromString("deadline-self"))
+	tr, err := ListenUDP(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	p.kb.InsertNode(Contact{ID: KeyFromString("deadline-silent"), Addr: silent.LocalAddr().(*net.UDPAddr)}.node())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err := p.GetValueContext(ctx, KeyFromString("deadline-key"))
+	if elapsed := time.Since(start); elapsed > tr.Timeout/2 {
+		t.Fatalf("GetValueContext returned after %v, deadline was 100ms", elapsed)
+	}
+	if err != context.DeadlineExceeded {
+		t.Fatalf("GetValueContext error = %v, want context.DeadlineExceeded", err)
+	}
+	if result.Contacted != 1 || len(result.Closest) != 0 {
+		t.Fatalf("partial result contacted %d, closest %d; want 1 and 0", result.Contacted, len(result.Closest))
+	}
+}
@@ -0,0 +1,52 @@
+package dht
+
+import "github.com/WuQingyang2/K_Bucket/kbucket"
+
+const DefaultProbesPerTick = 8 // 每个维护周期探测的节点数
+
+// 一项维护工作（探测一个节点或与一个邻居做反熵同步），每项消耗一次 RPC
+type maintTask struct {
+	probe    *kbucket.Node
+	neighbor *Peer
+}
+
+type maintenance struct {
+	budget  int         // 每个周期最多发出的 RPC 数，0 表示不限制
+	pending []maintTask // 上个周期预算用尽后留下的工作
+}
+
+// 设置每个 MaintenanceTick 的 RPC 预算，低功耗设备可以借此避免占满上行带宽。
+// 只限制 MaintenanceTick 中的探测与反熵同步；Start 之后的后台刷新、存活检查与 ping
+// 由 RefreshInterval、HealthCheckInterval 与 ProbeRate 控制，RunJanitor 的重新发布
+// 与迁移由其检查周期控制，都不计入这个预算
+func (p *Peer) SetMaintenanceBudget(n int) {
+	p.maint.budget = n
+}
+
+// 执行一个维护周期：先完成上个周期遗留的工作，再安排新的探测和反熵同步，
+// 超出预算的工作顺延到下个周期。返回本周期使用的 RPC 数
+func (p *Peer) MaintenanceTick() int {
+	if len(p.maint.pending) == 0 {
+		for _, node := range p.kb.SampleContacts(DefaultProbesPerTick, 1) {
+			node := node
+			p.maint.pending = append(p.maint.pending, maintTask{probe: &node})
+		}
+		for _, n := range p.replicaNeighbors() {
+			p.maint.pending = append(p.maint.pending, maintTask{neighbor: n})
+		}
+	}
+	group := append([]*Peer{p}, p.replicaNeighbors()...)
+	used := 0
+	for len(p.maint.pending) > 0 && (p.maint.budget == 0 || used < p.maint.budget) {
+		task := p.maint.pending[0]
+		p.maint.pending = p.maint.pending[1:]
+		if task.probe != nil {
+			p.probe(*task.probe)
+		} else {
+			p.syncWith(task.neighbor, group)
+		}
+		used++
+	}
+	p.UpdateHealth()
+	return used
+}
@@ -0,0 +1,224 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"sort"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	merkleLeafSize = 16  // 叶子节点最多包含的 key 数量
+	merkleMinKeys  = 256 // 双方记录总数达到该值时改用 Merkle 树比较
+)
+
+// 基于 key 前缀的 Merkle 树节点：第 depth 层按 key 的第 depth 位划分左右子树，
+// 两个邻居只需要逐层比较哈希即可定位存在差异的子树
+type merkleNode struct {
+	hash     [sha256.Size]byte
+	children [2]*merkleNode
+	keys     [][kbucket.IdSize]byte // 仅叶子节点使用，已排序
+}
+
+func (m *merkleNode) isLeaf() bool {
+	return m.children[0] == nil && m.children[1] == nil
+}
+
+func keyBit(key [kbucket.IdSize]byte, depth int) int {
+	return int(key[depth/8]>>uint(7-depth%8)) & 0x01
+}
+
+func buildMerkle(keys [][kbucket.IdSize]byte, depth int) *merkleNode {
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(keys) <= merkleLeafSize || depth == kbucket.IdSize*8 {
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+		h := sha256.New()
+		for _, key := range keys {
+			h.Write(key[:])
+		}
+		leaf := &merkleNode{keys: keys}
+		h.Sum(leaf.hash[:0])
+		return leaf
+	}
+	var parts [2][][kbucket.IdSize]byte
+	for _, key := range keys {
+		b := keyBit(key, depth)
+		parts[b] = append(parts[b], key)
+	}
+	node := &merkleNode{}
+	h := sha256.New()
+	for i := range parts {
+		node.children[i] = buildMerkle(parts[i], depth+1)
+		if node.children[i] != nil {
+			h.Write(node.children[i].hash[:])
+		} else {
+			h.Write(make([]byte, sha256.Size))
+		}
+	}
+	h.Sum(node.hash[:0])
+	return node
+}
+
+func (p *Peer) merkleRoot() *merkleNode {
+	return buildMerkle(p.store.keys(), 0)
+}
+
+func (m *merkleNode) collect(keys [][kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+	if m == nil {
+		return keys
+	}
+	if m.isLeaf() {
+		return append(keys, m.keys...)
+	}
+	return m.children[1].collect(m.children[0].collect(keys))
+}
+
+// 比较两棵 Merkle 树，返回只存在于 a、只存在于 b 中的 key，以及比较过程中所需的消息数
+func merkleDiff(a, b *merkleNode, depth int) (onlyA, onlyB [][kbucket.IdSize]byte, msgs int) {
+	msgs = 1
+	switch {
+	case a == nil && b == nil:
+		return nil, nil, msgs
+	case a == nil:
+		return nil, b.collect(nil), msgs
+	case b == nil:
+		return a.collect(nil), nil, msgs
+	case a.hash == b.hash:
+		return nil, nil, msgs
+	}
+	if a.isLeaf() || b.isLeaf() { // 叶子节点直接交换 key 列表
+		inA := make(map[[kbucket.IdSize]byte]bool)
+		for _, key := range a.collect(nil) {
+			inA[key] = true
+		}
+		for _, key := range b.collect(nil) {
+			if inA[key] {
+				delete(inA, key)
+			} else {
+				onlyB = append(onlyB, key)
+			}
+		}
+		for key := range inA {
+			onlyA = append(onlyA, key)
+		}
+		return onlyA, onlyB, msgs
+	}
+	for i := range a.children {
+		x, y, n := merkleDiff(a.children[i], b.children[i], depth+1)
+		onlyA = append(onlyA, x...)
+		onlyB = append(onlyB, y...)
+		msgs += n
+	}
+	return onlyA, onlyB, msgs
+}
+
+// Merkle 树中一个子树的摘要，MERKLE_SYNC 的响应。Hash 为零值表示子树为空；
+// 叶子节点带有其中全部的 key，其余节点带有左右子树的哈希，空子树为零值
+type MerkleSummary struct {
+	Hash     [sha256.Size]byte
+	Leaf     bool
+	Keys     [][kbucket.IdSize]byte
+	Children [2][sha256.Size]byte
+}
+
+// 支持 MERKLE_SYNC 的 Messenger。负责区域内的记录较多时，反熵用它与网络邻居逐层比较
+// Merkle 树，只请求哈希不同的子树。没有实现它的 Messenger 改用 DIGEST_SYNC
+type MerkleSyncer interface {
+	// 请求对方保存的、落在 r 中且与 prefix 共享前 depth 位的 key 构成的子树的摘要
+	MerkleSync(ctx context.Context, to Contact, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error)
+}
+
+func (m *merkleNode) summary() MerkleSummary {
+	if m == nil {
+		return MerkleSummary{}
+	}
+	s := MerkleSummary{Hash: m.hash}
+	if m.isLeaf() {
+		s.Leaf, s.Keys = true, m.keys
+		return s
+	}
+	for i, child := range m.children {
+		if child != nil {
+			s.Children[i] = child.hash
+		}
+	}
+	return s
+}
+
+func (m *merkleNode) hashOf() [sha256.Size]byte {
+	if m == nil {
+		return [sha256.Size]byte{}
+	}
+	return m.hash
+}
+
+// 本地保存的、落在 r 中且与 prefix 共享前 depth 位的 key
+func (p *Peer) merkleKeys(r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) [][kbucket.IdSize]byte {
+	var keys [][kbucket.IdSize]byte
+	for _, key := range p.store.keys() {
+		if r.Contains(key) && kbucket.CommonPrefixLen(key, prefix) >= depth {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// 处理一次 MERKLE_SYNC 请求：以 prefix 的前 depth 位为根的子树，与完整的树中对应的节点相同
+func (p *Peer) serveMerkleSync(r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) MerkleSummary {
+	return buildMerkle(p.merkleKeys(r, prefix, depth), depth).summary()
+}
+
+// 与网络邻居 c 从 r 的根开始逐层比较 Merkle 树，只向下请求哈希不同的子树。
+// 返回对方有而本地没有的 key 与发出的请求数
+func (p *Peer) merkleMissing(ctx context.Context, syncer MerkleSyncer, c Contact, r ResponsibilityRange) ([][kbucket.IdSize]byte, int, error) {
+	var missing [][kbucket.IdSize]byte
+	msgs := 0
+	var walk func(prefix [kbucket.IdSize]byte, depth int, keys [][kbucket.IdSize]byte) error
+	walk = func(prefix [kbucket.IdSize]byte, depth int, keys [][kbucket.IdSize]byte) error {
+		s, err := syncer.MerkleSync(ctx, c, r, prefix, depth)
+		msgs++
+		if err != nil {
+			return err
+		}
+		if s.Hash == ([sha256.Size]byte{}) || s.Hash == buildMerkle(keys, depth).hashOf() {
+			return nil
+		}
+		if s.Leaf { // 叶子节点直接比较 key 列表
+			have := make(map[[kbucket.IdSize]byte]bool, len(keys))
+			for _, key := range keys {
+				have[key] = true
+			}
+			for _, key := range s.Keys {
+				if !have[key] && r.Contains(key) && kbucket.CommonPrefixLen(key, prefix) >= depth {
+					missing = append(missing, key)
+				}
+			}
+			return nil
+		}
+		if depth == kbucket.IdSize*8 {
+			return nil
+		}
+		var parts [2][][kbucket.IdSize]byte
+		for _, key := range keys {
+			b := keyBit(key, depth)
+			parts[b] = append(parts[b], key)
+		}
+		for i := range parts {
+			if s.Children[i] == ([sha256.Size]byte{}) || s.Children[i] == buildMerkle(parts[i], depth+1).hashOf() {
+				continue
+			}
+			child := prefix
+			child[depth/8] = child[depth/8]&^(0x80>>uint(depth%8)) | byte(i)<<uint(7-depth%8)
+			if err := walk(child, depth+1, parts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(r.Self, r.Bits, p.merkleKeys(r, r.Self, r.Bits))
+	return missing, msgs, err
+}
@@ -0,0 +1,101 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func sortedKeys(keys [][kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	return keys
+}
+
+func sameKeys(a, b [][kbucket.IdSize]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sortedKeys(a), sortedKeys(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 相差几个 key 的两棵树：merkleDiff 准确找出两边各自独有的 key，只比较了很少的节点
+func TestMerkleDiff(t *testing.T) {
+	var shared, onlyA, onlyB [][kbucket.IdSize]byte
+	for i := 0; i < 1000; i++ {
+		shared = append(shared, KeyFromString(fmt.Sprintf("merkle-shared-%d", i)))
+	}
+	for i := 0; i < 3; i++ {
+		onlyA = append(onlyA, KeyFromString(fmt.Sprintf("merkle-a-%d", i)))
+	}
+	for i := 0; i < 2; i++ {
+		onlyB = append(onlyB, KeyFromString(fmt.Sprintf("merkle-b-%d", i)))
+	}
+	a := buildMerkle(append(append([][kbucket.IdSize]byte(nil), shared...), onlyA...), 0)
+	b := buildMerkle(append(append([][kbucket.IdSize]byte(nil), shared...), onlyB...), 0)
+
+	gotA, gotB, msgs := merkleDiff(a, b, 0)
+	if !sameKeys(gotA, onlyA) || !sameKeys(gotB, onlyB) {
+		t.Fatalf("merkleDiff = %d, %d keys, want %d, %d", len(gotA), len(gotB), len(onlyA), len(onlyB))
+	}
+	if msgs > 100 {
+		t.Fatalf("merkleDiff compared %d nodes for 5 differing keys", msgs)
+	}
+	if x, y, _ := merkleDiff(a, a, 0); len(x) != 0 || len(y) != 0 {
+		t.Fatalf("identical trees differ by %d, %d keys", len(x), len(y))
+	}
+}
+
+// 记录较多时反熵通过 MERKLE_SYNC 逐层比较网络邻居的树，只取回本地缺少的记录
+func TestAntiEntropyMerkleRemote(t *testing.T) {
+	p := NewPeer(KeyFromString("antientropy-self"))
+	q := NewPeer(KeyFromString("antientropy-remote"))
+	m := rangeMessenger{from: p, to: q, calls: make(map[string]int)}
+	p.SetMessenger(m)
+	p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}})
+
+	const shared, missing = 2 * merkleMinKeys, 3
+	var want [][kbucket.IdSize]byte
+	for i := 0; i < shared+missing; i++ {
+		value := []byte(fmt.Sprintf("merkle-remote-%d", i))
+		key := KeyFromBytes(value)
+		q.store.put(key, value, Provenance{})
+		if i < shared {
+			p.store.put(key, value, Provenance{})
+		} else {
+			want = append(want, key)
+		}
+	}
+	extra := []byte("merkle-remote-extra") // 只有本地有的记录不影响结果
+	p.store.put(KeyFromBytes(extra), extra, Provenance{})
+
+	r := p.ResponsibleRange()
+	got, msgs, err := p.merkleMissing(context.Background(), m, Contact{ID: q.node.ID}, r)
+	if err != nil || !sameKeys(got, want) {
+		t.Fatalf("merkleMissing = %x, %v, want %x", got, err, want)
+	}
+	if msgs > 40 {
+		t.Fatalf("merkleMissing sent %d requests for %d missing keys", msgs, missing)
+	}
+
+	delete(m.calls, OpMerkleSync)
+	if n := p.AntiEntropy(); n != missing {
+		t.Fatalf("AntiEntropy repaired %d records, want %d", n, missing)
+	}
+	if m.calls[OpFindValue] != missing || m.calls[OpDigestSync] != 0 || m.calls[OpMerkleSync] == 0 {
+		t.Fatalf("AntiEntropy sent %v, want MERKLE_SYNC and %d FIND_VALUE", m.calls, missing)
+	}
+	if n := p.AntiEntropy(); n != 0 {
+		t.Fatalf("second AntiEntropy repaired %d records, want 0", n)
+	}
+}
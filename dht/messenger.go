@@ -0,0 +1,394 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// 节点之间的 RPC。迭代查找、复制与存活检查都通过 Messenger 联系其他节点：
+// 进程内的联系人（Contact.Peer）直接调用对方，网络中的联系人（Contact.Addr）
+// 默认使用节点的 UDPTransport，也可以用 SetMessenger 换成其他实现。
+// 实现应当在成功时把响应方记入路由表与 PeerStats，与 UDPTransport 的行为一致
+type Messenger interface {
+	Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error)
+	FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error)
+	// 对方持有该值时返回值，否则返回它知道的最近节点
+	FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error)
+	Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error
+}
+
+// 可以通知对方本节点即将离开网络的 Messenger。没有实现它的 Messenger 在 Close 时
+// 不发送通知，对方之后通过联系失败发现节点已经离开
+type Departer interface {
+	Leave(ctx context.Context, to Contact) error
+}
+
+// 设置联系网络中的节点使用的 Messenger，nil 表示使用 UDPTransport
+func (p *Peer) SetMessenger(m Messenger) {
+	p.messenger = m
+}
+
+// 联系 c 使用的 Messenger，无法联系时返回 nil
+func (p *Peer) messengerFor(c Contact) Messenger {
+	switch {
+	case p.banned(c.ID, c.Addr):
+		return nil
+	case c.Peer != nil:
+		return memMessenger{from: p}
+	case c.Addr == nil:
+		return nil
+	case p.messenger != nil:
+		return p.messenger
+	case p.transport != nil:
+		return udpMessenger{t: p.transport}
+	}
+	return nil
+}
+
+// 路由表中的节点对应的联系方式
+func contactOf(n kbucket.Node) Contact {
+	c := Contact{ID: n.ID, LastSeen: n.LastSeen}
+	switch data := n.Data.(type) {
+	case *Peer:
+		c.Peer = data
+	case *net.UDPAddr:
+		c.Addr = data
+	}
+	return c
+}
+
+func contactsOf(nodes []kbucket.Node) []Contact {
+	contacts := make([]Contact, len(nodes))
+	for i, n := range nodes {
+		contacts[i] = contactOf(n)
+	}
+	return contacts
+}
+
+// 保存到路由表中的形式
+func (c Contact) node() kbucket.Node {
+	n := kbucket.Node{ID: c.ID, LastSeen: c.LastSeen}
+	if c.Peer != nil {
+		n.Data = c.Peer
+	} else if c.Addr != nil {
+		n.Data = c.Addr
+	}
+	return n
+}
+
+// 进程内的 RPC：直接访问对方节点，保持单进程仿真的行为
+type memMessenger struct {
+	from *Peer
+}
+
+// 检查请求能否发出，注入的故障按超时处理
+func (m memMessenger) begin(ctx context.Context, op string, to Contact) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.from.faults.timedOut(to.ID) || to.Peer.servingPaused() {
+		m.from.metricRPC(op, 0, false)
+		return ErrTimeout
+	}
+	if !m.from.accepts(to.Peer) || !to.Peer.accepts(m.from) {
+		m.from.metricRPC(op, 0, false)
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (m memMessenger) done(op string, to Contact, start time.Time) {
+	rtt := time.Since(start)
+	m.from.observe(to.ID, true, rtt)
+	m.from.metricRPC(op, rtt, true)
+}
+
+// 查询之后双方都认识了对方
+func (m memMessenger) meet(to *Peer) {
+	now := time.Now()
+	m.from.kb.InsertNode(kbucket.Node{ID: to.node.ID, Data: to, LastSeen: now})
+	to.kb.InsertNode(kbucket.Node{ID: m.from.node.ID, Data: m.from, LastSeen: now})
+}
+
+func (m memMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	if err := m.begin(ctx, OpPing, to); err != nil {
+		return [kbucket.IdSize]byte{}, err
+	}
+	m.done(OpPing, to, time.Now())
+	m.from.helloPeer(to.Peer)
+	return to.Peer.node.ID, nil
+}
+
+func (m memMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	if err := m.begin(ctx, OpFindNode, to); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	m.from.lookupHop(target, to.Peer, OpFindNode, TraceFromContext(ctx))
+	contacts := contactsOf(to.Peer.findNodeResponse(target, m.from.cfg.K))
+	if m.from.cfg.ContactValidation != ValidateOff {
+		for i := range contacts {
+			contacts[i].PublicKey = to.Peer.contactKey(contacts[i].node())
+		}
+	}
+	if forged := to.Peer.faults.forgedContacts(); forged != nil {
+		contacts = append([]Contact(nil), forged...)
+	}
+	if m.from.cfg.RTTHints {
+		for i := range contacts {
+			contacts[i].Hint = to.Peer.qualityHint(contacts[i].ID)
+		}
+	}
+	m.done(OpFindNode, to, start)
+	m.meet(to.Peer)
+	return contacts, nil
+}
+
+func (m memMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	if err := m.begin(ctx, OpFindValue, to); err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	peer := to.Peer
+	m.from.lookupHop(key, peer, OpFindValue, TraceFromContext(ctx))
+	peer.stats.record(key, false)
+	value, ok := peer.store.get(key)
+	peer.metricStore(ok)
+	var nodes []kbucket.Node
+	if !ok {
+		nodes = peer.kb.FindClosestNodes(key, m.from.cfg.K)
+	}
+	m.done(OpFindValue, to, start)
+	m.meet(peer)
+	return value, contactsOf(nodes), nil
+}
+
+// 对方拒绝时跟随它给出的转交提示，直到有节点保存或提示用尽
+func (m memMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	if err := m.begin(ctx, OpStore, to); err != nil {
+		return err
+	}
+	start := time.Now()
+	trace := TraceFromContext(ctx)
+	m.from.lookupHop(key, to.Peer, OpStore, trace)
+	err := m.from.storeAt(to.Peer, key, value, trace)
+	m.done(OpStore, to, start)
+	return err
+}
+
+// 离开通知不经过 meet，对方不会重新认识本节点
+func (m memMessenger) Leave(ctx context.Context, to Contact) error {
+	if err := m.begin(ctx, OpLeave, to); err != nil {
+		return err
+	}
+	start := time.Now()
+	to.Peer.onRequest(TraceFromContext(ctx), OpLeave, m.from.node.ID, [kbucket.IdSize]byte{})
+	to.Peer.peerDeparted(m.from.node.ID, nil)
+	m.done(OpLeave, to, start)
+	return nil
+}
+
+func (m memMessenger) AddProvider(ctx context.Context, to Contact, key [kbucket.IdSize]byte) error {
+	if err := m.begin(ctx, OpAddProvider, to); err != nil {
+		return err
+	}
+	start := time.Now()
+	to.Peer.onRequest(TraceFromContext(ctx), OpAddProvider, m.from.node.ID, key)
+	to.Peer.addProvider(key, Contact{ID: m.from.node.ID, Peer: m.from})
+	m.done(OpAddProvider, to, start)
+	m.meet(to.Peer)
+	return nil
+}
+
+func (m memMessenger) GetProviders(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]Provider, []Contact, error) {
+	if err := m.begin(ctx, OpGetProviders, to); err != nil {
+		return nil, nil, err
+	}
+	start := time.Now()
+	peer := to.Peer
+	m.from.lookupHop(key, peer, OpGetProviders, TraceFromContext(ctx))
+	providers := peer.providers.get(key, peer.now())
+	nodes := peer.kb.FindClosestNodes(key, m.from.cfg.K)
+	m.done(OpGetProviders, to, start)
+	m.meet(peer)
+	return providers, contactsOf(nodes), nil
+}
+
+func (m memMessenger) RangeSync(ctx context.Context, to Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	if err := m.begin(ctx, OpRangeSync, to); err != nil {
+		return RangePage{}, err
+	}
+	start := time.Now()
+	to.Peer.onRequest(TraceFromContext(ctx), OpRangeSync, m.from.node.ID, r.Self)
+	page, code, wait := to.Peer.serveRangeSync(m.from.node.ID, r, from, limit)
+	m.done(OpRangeSync, to, start)
+	m.meet(to.Peer)
+	if code != CodeOK {
+		return RangePage{}, &RPCError{Code: code, RetryAfter: wait}
+	}
+	return page, nil
+}
+
+func (m memMessenger) DigestSync(ctx context.Context, to Contact, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	if err := m.begin(ctx, OpDigestSync, to); err != nil {
+		return nil, false, err
+	}
+	start := time.Now()
+	to.Peer.onRequest(TraceFromContext(ctx), OpDigestSync, m.from.node.ID, r.Self)
+	keys, more, code, wait := to.Peer.serveDigestSync(m.from.node.ID, r, digest, limit)
+	m.done(OpDigestSync, to, start)
+	m.meet(to.Peer)
+	if code != CodeOK {
+		return nil, false, &RPCError{Code: code, RetryAfter: wait}
+	}
+	return keys, more, nil
+}
+
+func (m memMessenger) MerkleSync(ctx context.Context, to Contact, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	if err := m.begin(ctx, OpMerkleSync, to); err != nil {
+		return MerkleSummary{}, err
+	}
+	start := time.Now()
+	to.Peer.onRequest(TraceFromContext(ctx), OpMerkleSync, m.from.node.ID, r.Self)
+	s := to.Peer.serveMerkleSync(r, prefix, depth)
+	m.done(OpMerkleSync, to, start)
+	m.meet(to.Peer)
+	return s, nil
+}
+
+// 通过 UDPTransport 联系网络中的节点
+type udpMessenger struct {
+	t *UDPTransport
+}
+
+// 按 ctx 的优先级等待发送名额，返回发送请求用的 TracedTransport 与归还名额的函数。
+// 低优先级的请求只对声明支持 FeaturePriority 的节点标记
+func (m udpMessenger) begin(ctx context.Context, to Contact) (*TracedTransport, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	done, err := m.t.p.scheduleSend(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := m.t.tracedTo(ctx, TraceFromContext(ctx), to)
+	c.low = PriorityFromContext(ctx) == PriorityLow && m.t.p.supports(to.ID, FeaturePriority)
+	return c, done, nil
+}
+
+func (m udpMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return [kbucket.IdSize]byte{}, err
+	}
+	defer done()
+	return c.ping(to.Addr, m.t.p.needHello(to.ID))
+}
+
+func (m udpMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	var flags byte
+	if m.t.p.cfg.RTTHints {
+		flags |= udpwire.FindNodeWithHints
+	}
+	if m.t.p.cfg.ContactValidation != ValidateOff {
+		flags |= udpwire.FindNodeWithKeys
+	}
+	if flags == 0 {
+		nodes, err := c.FindNode(to.Addr, target)
+		return contactsOf(nodes), err
+	}
+	nodes, hints, keys, err := c.findNode(to.Addr, target, flags)
+	contacts := contactsOf(nodes)
+	for i := range contacts {
+		if i < len(hints) {
+			contacts[i].Hint = hints[i]
+		}
+		if i < len(keys) {
+			contacts[i].PublicKey = keys[i]
+		}
+	}
+	return contacts, err
+}
+
+func (m udpMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	value, nodes, err := c.FindValue(to.Addr, key)
+	return value, contactsOf(nodes), err
+}
+
+func (m udpMessenger) Leave(ctx context.Context, to Contact) error {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.Leave(to.Addr)
+}
+
+func (m udpMessenger) AddProvider(ctx context.Context, to Contact, key [kbucket.IdSize]byte) error {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.AddProvider(to.Addr, key)
+}
+
+func (m udpMessenger) GetProviders(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]Provider, []Contact, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	providers, nodes, err := c.GetProviders(to.Addr, key)
+	return providers, contactsOf(nodes), err
+}
+
+func (m udpMessenger) RangeSync(ctx context.Context, to Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return RangePage{}, err
+	}
+	defer done()
+	return c.RangeSync(to.Addr, r, from, limit)
+}
+
+func (m udpMessenger) DigestSync(ctx context.Context, to Contact, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return nil, false, err
+	}
+	defer done()
+	return c.DigestSync(to.Addr, r, digest, limit)
+}
+
+func (m udpMessenger) MerkleSync(ctx context.Context, to Contact, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return MerkleSummary{}, err
+	}
+	defer done()
+	return c.MerkleSync(to.Addr, r, prefix, depth)
+}
+
+func (m udpMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	c, done, err := m.begin(ctx, to)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return c.Store(to.Addr, key, value)
+}
@@ -0,0 +1,287 @@
+package dht
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 节点的指标回调：查找跳数、RPC 延迟、本地存储命中率，以及路由表的
+// bucket 占用与淘汰。实现需要可以在多个 goroutine 中同时调用
+type Metrics interface {
+	kbucket.Metrics
+	LookupHops(op string, hops int)                   // 一次迭代查找联系的节点数
+	RPCLatency(op string, rtt time.Duration, ok bool) // 一次 RPC 的往返时间
+	StoreAccess(hit bool)                             // 一次本地存储读取是否命中
+}
+
+// 设置指标回调，nil 表示不收集。应在节点开始工作之前调用
+func (p *Peer) SetMetrics(m Metrics) {
+	p.metrics = m
+	p.kb.SetMetrics(m)
+}
+
+func (p *Peer) metricLookup(op string, hops int) {
+	if p.metrics != nil {
+		p.metrics.LookupHops(op, hops)
+	}
+}
+
+func (p *Peer) metricRPC(op string, rtt time.Duration, ok bool) {
+	if p.metrics != nil {
+		p.metrics.RPCLatency(op, rtt, ok)
+	}
+}
+
+func (p *Peer) metricStore(hit bool) {
+	if p.metrics != nil {
+		p.metrics.StoreAccess(hit)
+	}
+}
+
+var (
+	hopBuckets     = []float64{1, 2, 4, 8, 16, 32, 64}
+	latencyBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+)
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] 为不大于 bounds[i] 的样本数，最后一项为全部样本数
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(h.bounds)]++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, b, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.counts[len(h.bounds)])
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.counts[len(h.bounds)])
+}
+
+// Metrics 的默认实现，以 Prometheus 文本格式导出，不依赖 Prometheus 客户端库
+type PrometheusMetrics struct {
+	mu        sync.Mutex
+	hops      map[string]*histogram
+	latency   map[string]*histogram
+	failures  map[string]uint64
+	hits      uint64
+	misses    uint64
+	occupancy map[int]int
+	evictions map[kbucket.EvictionReason]uint64
+	rejected  map[[2]string]uint64 // 以操作名与原因为键
+	suspended uint64
+	reads     map[string]uint64 // 以副本比较结果为键
+	poisoned  uint64            // 返回无效值的副本数
+	probes    [2]uint64         // 后台 ping 失败与成功的次数
+	freshness FreshnessReport   // 最近一次后台 ping 之后的新鲜度分布
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		hops:      make(map[string]*histogram),
+		latency:   make(map[string]*histogram),
+		failures:  make(map[string]uint64),
+		occupancy: make(map[int]int),
+		evictions: make(map[kbucket.EvictionReason]uint64),
+		rejected:  make(map[[2]string]uint64),
+		reads:     make(map[string]uint64),
+	}
+}
+
+func (m *PrometheusMetrics) LookupHops(op string, hops int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.hops[op]
+	if !ok {
+		h = newHistogram(hopBuckets)
+		m.hops[op] = h
+	}
+	h.observe(float64(hops))
+}
+
+func (m *PrometheusMetrics) RPCLatency(op string, rtt time.Duration, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !ok {
+		m.failures[op]++
+		return
+	}
+	h, found := m.latency[op]
+	if !found {
+		h = newHistogram(latencyBuckets)
+		m.latency[op] = h
+	}
+	h.observe(rtt.Seconds())
+}
+
+func (m *PrometheusMetrics) StoreAccess(hit bool) {
+	m.mu.Lock()
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) BucketOccupancy(bucket, nodes int) {
+	m.mu.Lock()
+	m.occupancy[bucket] = nodes
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) Eviction(bucket int, reason kbucket.EvictionReason) {
+	m.mu.Lock()
+	m.evictions[reason]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) RequestRejected(op, reason string) {
+	m.mu.Lock()
+	m.rejected[[2]string{op, reason}]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) PeerSuspended() {
+	m.mu.Lock()
+	m.suspended++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ReadVerified(outcome string, replicas, invalid int) {
+	m.mu.Lock()
+	m.reads[outcome]++
+	m.poisoned += uint64(invalid)
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ContactProbed(ok bool) {
+	m.mu.Lock()
+	if ok {
+		m.probes[1]++
+	} else {
+		m.probes[0]++
+	}
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ContactFreshness(r FreshnessReport) {
+	m.mu.Lock()
+	m.freshness = r
+	m.mu.Unlock()
+}
+
+func writeHistograms(w io.Writer, name string, hs map[string]*histogram) {
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	ops := make([]string, 0, len(hs))
+	for op := range hs {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		hs[op].write(w, name, fmt.Sprintf("op=%q", op))
+	}
+}
+
+// 以 Prometheus 文本格式写出所有指标
+func (m *PrometheusMetrics) WriteText(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	writeHistograms(w, "kbucket_lookup_hops", m.hops)
+	writeHistograms(w, "kbucket_rpc_latency_seconds", m.latency)
+	fmt.Fprintln(w, "# TYPE kbucket_rpc_failures_total counter")
+	ops := make([]string, 0, len(m.failures))
+	for op := range m.failures {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(w, "kbucket_rpc_failures_total{op=%q} %d\n", op, m.failures[op])
+	}
+	fmt.Fprintln(w, "# TYPE kbucket_store_reads_total counter")
+	fmt.Fprintf(w, "kbucket_store_reads_total{result=\"hit\"} %d\n", m.hits)
+	fmt.Fprintf(w, "kbucket_store_reads_total{result=\"miss\"} %d\n", m.misses)
+	fmt.Fprintln(w, "# TYPE kbucket_bucket_nodes gauge")
+	buckets := make([]int, 0, len(m.occupancy))
+	for b := range m.occupancy {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	for _, b := range buckets {
+		fmt.Fprintf(w, "kbucket_bucket_nodes{bucket=\"%d\"} %d\n", b, m.occupancy[b])
+	}
+	fmt.Fprintln(w, "# TYPE kbucket_evictions_total counter")
+	for _, reason := range []kbucket.EvictionReason{kbucket.EvictedUnresponsive, kbucket.EvictedRemoved, kbucket.EvictedConflict, kbucket.EvictedStale, kbucket.EvictedDeparted, kbucket.EvictedFiltered} {
+		fmt.Fprintf(w, "kbucket_evictions_total{reason=%q} %d\n", reason, m.evictions[reason])
+	}
+	fmt.Fprintln(w, "# TYPE kbucket_requests_rejected_total counter")
+	keys := make([][2]string, 0, len(m.rejected))
+	for k := range m.rejected {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "kbucket_requests_rejected_total{op=%q,reason=%q} %d\n", k[0], k[1], m.rejected[k])
+	}
+	fmt.Fprintln(w, "# TYPE kbucket_quota_suspensions_total counter")
+	fmt.Fprintf(w, "kbucket_quota_suspensions_total %d\n", m.suspended)
+	fmt.Fprintln(w, "# TYPE kbucket_read_verifications_total counter")
+	for _, outcome := range []string{ReadConsistent, ReadStale, ReadDivergent, ReadInvalid} {
+		fmt.Fprintf(w, "kbucket_read_verifications_total{outcome=%q} %d\n", outcome, m.reads[outcome])
+	}
+	fmt.Fprintln(w, "# TYPE kbucket_read_invalid_replicas_total counter")
+	fmt.Fprintf(w, "kbucket_read_invalid_replicas_total %d\n", m.poisoned)
+	fmt.Fprintln(w, "# TYPE kbucket_freshness_probes_total counter")
+	fmt.Fprintf(w, "kbucket_freshness_probes_total{result=\"ok\"} %d\n", m.probes[1])
+	fmt.Fprintf(w, "kbucket_freshness_probes_total{result=\"failed\"} %d\n", m.probes[0])
+	fmt.Fprintln(w, "# TYPE kbucket_contacts_by_age gauge") // 距上次确认存活不超过 le 秒的联系人数
+	total := 0
+	for _, b := range m.freshness.Ages {
+		total += b.Contacts
+		if b.UpTo > 0 {
+			fmt.Fprintf(w, "kbucket_contacts_by_age{le=\"%g\"} %d\n", b.UpTo.Seconds(), total)
+		}
+	}
+	fmt.Fprintf(w, "kbucket_contacts_by_age{le=\"+Inf\"} %d\n", total)
+	fmt.Fprintln(w, "# TYPE kbucket_contacts_due gauge")
+	fmt.Fprintf(w, "kbucket_contacts_due %d\n", m.freshness.Due)
+	fmt.Fprintln(w, "# TYPE kbucket_contacts_unverified gauge")
+	fmt.Fprintf(w, "kbucket_contacts_unverified %d\n", m.freshness.Unverified)
+}
+
+// 以 Prometheus 文本格式导出指标的 HTTP handler
+func (m *PrometheusMetrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.WriteText(w)
+	})
+}
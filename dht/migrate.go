@@ -0,0 +1,101 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 需要持久化的状态类型，每种都有独立的格式版本
+type Artifact string
+
+const (
+	ArtifactIdentity  Artifact = "identity"  // 节点密钥
+	ArtifactSnapshot  Artifact = "snapshot"  // 路由表与存储快照
+	ArtifactJournal   Artifact = "journal"   // 发布日志
+	ArtifactPeerStats Artifact = "peerstats" // 节点长期统计
+	ArtifactBans      Artifact = "bans"      // 管理员设置的封禁
+	ArtifactRecord    Artifact = "record"    // DiskStorage 中的一条记录
+	ArtifactStorageTx Artifact = "storagetx" // DiskStorage 已提交的事务日志
+)
+
+// 把 from 版本的数据转换为 from+1 版本
+type Migration func(payload []byte) ([]byte, error)
+
+var ErrUnsupportedVersion = errors.New("dht: unsupported state version")
+
+// 当前写出的格式版本
+var currentVersions = map[Artifact]byte{
+	ArtifactIdentity:  identityVersion,
+	ArtifactSnapshot:  snapshotVersion,
+	ArtifactJournal:   journalVersion,
+	ArtifactPeerStats: peerStatsVersion,
+	ArtifactBans:      bansVersion,
+	ArtifactRecord:    recordVersion,
+	ArtifactStorageTx: storageTxVersion,
+}
+
+var migrations = map[Artifact]map[byte]Migration{
+	// 版本 0 的日志与统计没有文件头，内容与版本 1 相同
+	ArtifactJournal:   {0: unchanged},
+	ArtifactPeerStats: {0: unchanged},
+	ArtifactSnapshot:  {1: migrateSnapshotV1},
+}
+
+func unchanged(payload []byte) ([]byte, error) {
+	return payload, nil
+}
+
+// 注册从 from 版本升级到 from+1 版本的转换函数。格式变化时提高当前版本号并
+// 注册对应的迁移，旧节点留下的状态在读取时逐版本升级
+func RegisterMigration(kind Artifact, from byte, fn Migration) {
+	if migrations[kind] == nil {
+		migrations[kind] = make(map[byte]Migration)
+	}
+	migrations[kind][from] = fn
+}
+
+func CurrentVersion(kind Artifact) byte {
+	return currentVersions[kind]
+}
+
+// 把 version 版本的数据逐版本升级到当前版本
+func migrate(kind Artifact, version byte, payload []byte) ([]byte, error) {
+	current := currentVersions[kind]
+	if version > current {
+		return nil, fmt.Errorf("%w: %s version %d is newer than %d", ErrUnsupportedVersion, kind, version, current)
+	}
+	for ; version < current; version++ {
+		fn := migrations[kind][version]
+		if fn == nil {
+			return nil, fmt.Errorf("%w: no migration for %s version %d", ErrUnsupportedVersion, kind, version)
+		}
+		var err error
+		if payload, err = fn(payload); err != nil {
+			return nil, fmt.Errorf("dht: migrating %s from version %d: %w", kind, version, err)
+		}
+	}
+	return payload, nil
+}
+
+// 带文件头的状态：magic(4) | 版本(1) | 内容
+func writeVersioned(w io.Writer, magic [4]byte, kind Artifact, payload []byte) error {
+	if _, err := w.Write(magic[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{currentVersions[kind]}); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// 读取带文件头的状态并升级到当前版本。没有文件头的数据视为版本 0
+func readVersioned(data []byte, magic [4]byte, kind Artifact) ([]byte, error) {
+	version, payload := byte(0), data
+	if len(data) >= 5 && bytes.Equal(data[:4], magic[:]) {
+		version, payload = data[4], data[5:]
+	}
+	return migrate(kind, version, payload)
+}
@@ -0,0 +1,52 @@
+package dht
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultLowPriorityBatch       = 30 * time.Second // MobileConfig 中维护 RPC 成批发出的间隔
+	DefaultMobileSnapshotInterval = time.Minute      // MobileConfig 中保存快照的间隔
+)
+
+// 移动设备与低功耗设备的参数：维护周期是默认值的 4 倍，按流量计费时只作为客户端，
+// 维护 RPC 成批发出，频繁保存快照以便进程随时被系统终止后恢复。调用方需要设置
+// SnapshotPath 并在连接类型变化时调用 SetMetered
+func MobileConfig() Config {
+	c := DefaultConfig()
+	c.RefreshInterval = 4 * DefaultRefreshInterval
+	c.DriftCheckInterval = 4 * DefaultDriftCheckInterval
+	c.RejoinInterval = 4 * DefaultRejoinInterval
+	c.MaxRejoinInterval = 4 * DefaultMaxRejoinInterval
+	c.ProbeRate = float64(DefaultProbeRate) / 4
+	c.LowPriorityRPCs = 2
+	c.LowPriorityBatch = DefaultLowPriorityBatch
+	c.ClientOnlyWhenMetered = true
+	c.SnapshotInterval = DefaultMobileSnapshotInterval
+	return c
+}
+
+// 设置连接是否按流量计费（例如从 Wi-Fi 切换到蜂窝网络），见 Config.ClientOnlyWhenMetered
+func (p *Peer) SetMetered(metered bool) {
+	p.metered.Store(metered)
+}
+
+func (p *Peer) Metered() bool {
+	return p.metered.Load()
+}
+
+// 是否因为连接按流量计费而暂停为其他节点提供服务
+func (p *Peer) servingPaused() bool {
+	return p.cfg.ClientOnlyWhenMetered && p.metered.Load()
+}
+
+// 配置了 LowPriorityBatch 时等到下一个批次的时刻
+func (p *Peer) waitBatch(ctx context.Context) error {
+	batch := p.cfg.LowPriorityBatch
+	if batch <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return sleepContext(ctx, now.Truncate(batch).Add(batch).Sub(now))
+}
@@ -0,0 +1,107 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func TestMobileConfig(t *testing.T) {
+	cfg := MobileConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RefreshInterval <= DefaultRefreshInterval || cfg.ProbeRate >= DefaultProbeRate || cfg.ProbeRate <= 0 {
+		t.Fatalf("MobileConfig maintenance not relaxed: refresh %v, probe rate %v", cfg.RefreshInterval, cfg.ProbeRate)
+	}
+}
+
+// 按流量计费时节点不进入其他节点的路由表，对它的请求表现为超时；恢复后重新提供服务
+func TestMeteredClientOnly(t *testing.T) {
+	cfg := MobileConfig()
+	cfg.LowPriorityBatch = 0
+	mobile, err := NewPeerWithConfig(KeyFromString("mobile-phone"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewPeer(KeyFromString("mobile-other"))
+	node := kbucket.Node{ID: mobile.ID(), Data: mobile}
+	mobile.SetMetered(true)
+	if other.kb.InsertNode(node) {
+		t.Fatal("metered peer admitted to a routing table")
+	}
+	to := Contact{ID: mobile.ID(), Peer: mobile}
+	if _, err := other.messengerFor(to).FindNode(context.Background(), to, other.ID()); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("FindNode to a metered peer = %v, want ErrTimeout", err)
+	}
+	back := Contact{ID: other.ID(), Peer: other}
+	if _, err := mobile.messengerFor(back).Ping(context.Background(), back); err != nil {
+		t.Fatalf("metered peer cannot send its own requests: %v", err)
+	}
+	mobile.SetMetered(false)
+	if !other.kb.InsertNode(node) {
+		t.Fatal("peer still refused after leaving the metered link")
+	}
+}
+
+// LowPriorityBatch 把不同时刻发起的维护 RPC 推迟到同一个批次一起发出
+func TestLowPriorityBatch(t *testing.T) {
+	const batch = 100 * time.Millisecond
+	p, err := NewPeerWithConfig(KeyFromString("mobile-batch"), Config{LowPriorityBatch: batch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := ContextWithPriority(context.Background(), PriorityLow)
+	time.Sleep(time.Until(time.Now().Truncate(batch).Add(batch + 10*time.Millisecond))) // 从批次开始之后出发
+	released := make(chan time.Time, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done, err := p.scheduleSend(low)
+			if err != nil {
+				t.Error(err)
+			}
+			released <- time.Now()
+			done()
+		}()
+		time.Sleep(30 * time.Millisecond)
+	}
+	high := time.Now()
+	done, _ := p.scheduleSend(context.Background())
+	done()
+	if time.Since(high) > 10*time.Millisecond {
+		t.Fatal("high-priority send waited for the batch")
+	}
+	first, second := <-released, <-released
+	if d := second.Sub(first); d > 20*time.Millisecond || d < -20*time.Millisecond {
+		t.Fatalf("low-priority sends released %v apart, want the same batch", d)
+	}
+}
+
+// 设置 SnapshotPath 时 Stop 写入快照，重启后可以恢复记录
+func TestSnapshotOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state")
+	p, err := NewPeerWithConfig(KeyFromString("mobile-snapshot"), Config{SnapshotPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("mobile-value")
+	key := KeyFromBytes(value)
+	if err := p.store.put(key, value, Provenance{}); err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	q := NewPeer(KeyFromString("mobile-snapshot"))
+	if err := q.LoadSnapshot(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := q.store.get(key); !ok || string(got) != string(value) {
+		t.Fatalf("restored record = %q, %v", got, ok)
+	}
+}
@@ -0,0 +1,142 @@
+package dht
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const MaxValuesPerKey = 20 // 每个 key 最多保存的值数量
+
+var ErrBadTTL = errors.New("dht: ttl must be positive")
+
+type multiEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// 向 key 追加一个值（例如一个 provider 联系方式），已存在的值只刷新过期时间。
+// 每个值有独立的 TTL，集合已满时淘汰最早过期的值。负责的节点都只能通过网络联系时
+// 返回 ErrRemoteReplicas，不做任何修改
+func (p *Peer) AppendValue(key [kbucket.IdSize]byte, value []byte, ttl time.Duration) error {
+	if value == nil {
+		return ErrEmptyKey
+	}
+	if ttl <= 0 {
+		return ErrBadTTL
+	}
+	if _, err := p.inProcessReplicas(key); err != nil {
+		return err
+	}
+	p.appendValue(key, multiEntry{value: value, expires: time.Now().Add(ttl)})
+	return nil
+}
+
+func (p *Peer) appendValue(key [kbucket.IdSize]byte, e multiEntry) {
+	if !p.appendLocal(key, e) { // 没有变化则不再继续传播
+		return
+	}
+	p.notifyWatchers(key)
+	for _, peer := range p.routeTargets(key) {
+		peer.appendValue(key, e)
+	}
+}
+
+func (p *Peer) appendLocal(key [kbucket.IdSize]byte, e multiEntry) bool {
+	p.multiMu.Lock()
+	defer p.multiMu.Unlock()
+	entries := liveEntries(p.multi[key], time.Now()) // 顺便删除已过期的值
+	if len(entries) == 0 {
+		delete(p.multi, key)
+	} else {
+		p.multi[key] = entries
+	}
+	for i := range entries {
+		if bytes.Equal(entries[i].value, e.value) {
+			if !e.expires.After(entries[i].expires) {
+				return false
+			}
+			entries[i].expires = e.expires
+			return true
+		}
+	}
+	if len(entries) >= MaxValuesPerKey {
+		oldest := 0
+		for i := range entries {
+			if entries[i].expires.Before(entries[oldest].expires) {
+				oldest = i
+			}
+		}
+		if !e.expires.After(entries[oldest].expires) {
+			return false
+		}
+		entries = append(entries[:oldest], entries[oldest+1:]...)
+	}
+	p.multi[key] = append(entries, e)
+	return true
+}
+
+// entries 中在 now 仍然有效的值，返回新的切片
+func liveEntries(entries []multiEntry, now time.Time) []multiEntry {
+	var live []multiEntry
+	for _, e := range entries {
+		if e.expires.After(now) {
+			live = append(live, e)
+		}
+	}
+	return live
+}
+
+// key 仍然有效的值。只读取，过期的值在追加或 expireValues 时删除
+func (p *Peer) liveValues(key [kbucket.IdSize]byte, now time.Time) []multiEntry {
+	p.multiMu.RLock()
+	defer p.multiMu.RUnlock()
+	return liveEntries(p.multi[key], now)
+}
+
+// 删除所有已过期的值，返回删除的数量
+func (p *Peer) expireValues() int {
+	p.multiMu.Lock()
+	defer p.multiMu.Unlock()
+	now := time.Now()
+	removed := 0
+	for key, entries := range p.multi {
+		live := liveEntries(entries, now)
+		removed += len(entries) - len(live)
+		if len(live) == 0 {
+			delete(p.multi, key)
+		} else if len(live) < len(entries) {
+			p.multi[key] = live
+		}
+	}
+	return removed
+}
+
+// 返回本地与各副本合并后仍然有效的值集合。
+// 负责的节点都只能通过网络联系时返回 ErrRemoteReplicas
+func (p *Peer) GetValues(key [kbucket.IdSize]byte) ([][]byte, error) {
+	peers, err := p.inProcessReplicas(key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var values [][]byte
+	merge := func(entries []multiEntry) {
+	next:
+		for _, e := range entries {
+			for _, v := range values {
+				if bytes.Equal(v, e.value) {
+					continue next
+				}
+			}
+			values = append(values, e.value)
+		}
+	}
+	merge(p.liveValues(key, now))
+	for _, peer := range peers {
+		merge(peer.liveValues(key, now))
+	}
+	return values, nil
+}
@@ -0,0 +1,134 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// 区分同一个监听端口上的多个独立 DHT 网络，随每条消息发送
+type NetworkID uint32
+
+const DefaultNetwork NetworkID = 0
+
+var ErrNetworkAttached = errors.New("dht: network already attached")
+
+// UDPMux 收发数据包使用的连接。除了 *net.UDPConn，也可以是把数据包承载在其他协议上的
+// 连接（见 WebSocketConn），对方同样以 *net.UDPAddr 表示；LocalAddr 返回 *net.UDPAddr
+type PacketConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// 多个 DHT 网络共享的 UDP 监听端口。每个网络挂载一个节点，收到的消息按网络 ID
+// 分发给对应的 UDPTransport，未挂载网络的消息被丢弃。网关可以借此在一个进程中
+// 同时加入多个网络
+type UDPMux struct {
+	conn PacketConn
+	mu   sync.RWMutex // 保护 endpoints
+	done chan struct{}
+
+	endpoints map[NetworkID]*UDPTransport
+	invalid   packetLog // 丢弃的无效数据包
+}
+
+func ListenMux(addr string) (*UDPMux, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewMux(conn), nil
+}
+
+// 在已有的连接上收发数据包，关闭 UDPMux 时一并关闭 conn
+func NewMux(conn PacketConn) *UDPMux {
+	m := &UDPMux{conn: conn, done: make(chan struct{}), endpoints: make(map[NetworkID]*UDPTransport)}
+	go m.readLoop()
+	return m
+}
+
+func (m *UDPMux) Addr() *net.UDPAddr {
+	return m.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// 让 p 通过该端口加入网络 network
+func (m *UDPMux) Attach(p *Peer, network NetworkID) (*UDPTransport, error) {
+	if p.transport != nil {
+		return nil, ErrTransportUp
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.endpoints[network]; ok {
+		return nil, ErrNetworkAttached
+	}
+	t := &UDPTransport{
+		Timeout: DefaultRPCTimeout,
+		Retries: DefaultRPCRetries,
+		p:       p,
+		mux:     m,
+		network: network,
+		pending: make(map[uint64]chan message),
+		done:    make(chan struct{}),
+	}
+	m.endpoints[network] = t
+	p.transport = t
+	return t, nil
+}
+
+func (m *UDPMux) detach(t *UDPTransport) {
+	m.mu.Lock()
+	if m.endpoints[t.network] == t {
+		delete(m.endpoints, t.network)
+	}
+	m.mu.Unlock()
+}
+
+// 关闭监听端口。仍挂载的 UDPTransport 之后发出的请求都会失败
+func (m *UDPMux) Close() error {
+	m.mu.Lock()
+	select {
+	case <-m.done:
+		m.mu.Unlock()
+		return nil
+	default:
+	}
+	close(m.done)
+	m.mu.Unlock()
+	return m.conn.Close()
+}
+
+func (m *UDPMux) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.done:
+				return
+			default:
+				continue
+			}
+		}
+		msg, err := decodeMessage(buf[:n])
+		if err != nil { // 丢弃无法解析的数据包
+			m.invalid.record(from, decodeDropReason(err), buf[:n], err)
+			continue
+		}
+		msg.from = from
+		m.mu.RLock()
+		t, ok := m.endpoints[msg.network]
+		m.mu.RUnlock()
+		if ok {
+			t.dispatch(msg)
+		} else { // 带签名的数据包此时已经去掉了签名
+			m.invalid.record(from, DropUnknownNetwork, buf[:n], fmt.Errorf("network %d", msg.network))
+		}
+	}
+}
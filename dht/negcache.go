@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"crypto/sha256"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	NegativeCacheTTL  = 30 * time.Second // 否定缓存的有效期
+	NegativeCacheSize = 4096             // 否定缓存最多保存的 key 数
+)
+
+// 一次未找到的结果，以及当时负责该 key 的节点集合的摘要
+type negEntry struct {
+	expires time.Time
+	digest  [sha256.Size]byte
+}
+
+// 负责 key 的节点集合的摘要，路由表变化导致集合改变时缓存自动失效
+func (p *Peer) closestDigest(key [kbucket.IdSize]byte) [sha256.Size]byte {
+	h := sha256.New()
+	for _, c := range p.routeContacts(key) {
+		h.Write(c.ID[:])
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func (p *Peer) negativeCached(key [kbucket.IdSize]byte) bool {
+	p.negMu.Lock()
+	e, ok := p.negCache[key]
+	p.negMu.Unlock()
+	if !ok {
+		return false
+	}
+	if p.now().After(e.expires) || e.digest != p.closestDigest(key) {
+		p.forgetMiss(key)
+		return false
+	}
+	return true
+}
+
+func (p *Peer) cacheMiss(key [kbucket.IdSize]byte) {
+	e := negEntry{
+		expires: p.now().Add(NegativeCacheTTL),
+		digest:  p.closestDigest(key),
+	}
+	p.negMu.Lock()
+	defer p.negMu.Unlock()
+	if _, ok := p.negCache[key]; !ok && len(p.negCache) >= NegativeCacheSize {
+		p.evictMissLocked()
+	}
+	p.negCache[key] = e
+}
+
+// 为新的 key 腾出位置：先删除所有过期的项，仍然已满时删除最早过期的一项。
+// 调用方需持有 negMu
+func (p *Peer) evictMissLocked() {
+	if p.expireMissesLocked(p.now()) > 0 {
+		return
+	}
+	var oldest [kbucket.IdSize]byte
+	var first time.Time
+	for key, e := range p.negCache {
+		if first.IsZero() || e.expires.Before(first) {
+			oldest, first = key, e.expires
+		}
+	}
+	delete(p.negCache, oldest)
+}
+
+// 删除过期的否定缓存项，由 RunJanitor 调用。返回删除的数量
+func (p *Peer) expireMisses() int {
+	p.negMu.Lock()
+	defer p.negMu.Unlock()
+	return p.expireMissesLocked(p.now())
+}
+
+func (p *Peer) expireMissesLocked(now time.Time) int {
+	removed := 0
+	for key, e := range p.negCache {
+		if now.After(e.expires) {
+			delete(p.negCache, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (p *Peer) forgetMiss(key [kbucket.IdSize]byte) {
+	p.negMu.Lock()
+	delete(p.negCache, key)
+	p.negMu.Unlock()
+}
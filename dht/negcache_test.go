@@ -0,0 +1,118 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 大量不同的未命中 key 不会让否定缓存无限增长，过期的项由 expireMisses 删除
+func TestNegativeCacheBounded(t *testing.T) {
+	p := NewPeer(KeyFromString("negcache-self"))
+	for i := 0; i < NegativeCacheSize+100; i++ {
+		p.cacheMiss(KeyFromString(fmt.Sprintf("missing-%d", i)))
+	}
+	if n := len(p.negCache); n > NegativeCacheSize {
+		t.Fatalf("negative cache holds %d keys, cap is %d", n, NegativeCacheSize)
+	}
+	last := KeyFromString(fmt.Sprintf("missing-%d", NegativeCacheSize+99))
+	if !p.negativeCached(last) {
+		t.Fatal("most recent miss was evicted")
+	}
+	p.Faults().JumpClock(2 * NegativeCacheTTL)
+	p.expireMisses()
+	if n := len(p.negCache); n != 0 {
+		t.Fatalf("%d expired entries left after expireMisses", n)
+	}
+}
+
+// 热门目标的 FIND_NODE 响应在 FindNodeCacheTTL 内不再查询路由表，冷门目标每次都重新计算
+func TestFindNodeCache(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("findcache-self"), Config{FindNodeCacheTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.kb.InsertNode(kbucket.Node{ID: KeyFromString("findcache-other"), Data: NewPeer(KeyFromString("findcache-other"))})
+	popular, cold := KeyFromString("findcache-popular"), KeyFromString("findcache-cold")
+	for i := 0; i < FindNodePopularity; i++ {
+		p.findNodeResponse(popular, p.cfg.K)
+	}
+	p.findNodeResponse(cold, p.cfg.K)
+	for _, id := range [][kbucket.IdSize]byte{popular, cold} {
+		p.kb.InsertNode(kbucket.Node{ID: id, Data: NewPeer(id)})
+	}
+	has := func(target [kbucket.IdSize]byte) bool {
+		for _, n := range p.findNodeResponse(target, p.cfg.K) {
+			if n.ID == target {
+				return true
+			}
+		}
+		return false
+	}
+	if has(popular) {
+		t.Fatal("popular target answered from the routing table instead of the cache")
+	}
+	if !has(cold) {
+		t.Fatal("cold target answered from a stale cache")
+	}
+	p.Faults().JumpClock(2 * time.Minute)
+	if !has(popular) {
+		t.Fatal("popular target still cached after FindNodeCacheTTL")
+	}
+}
+
+// 只有收敛的查找才把 key 记入否定缓存：截止时间到达、跳数预算用尽或没有节点回复时
+// 查找结果不能说明 key 不存在
+func TestNegativeCacheOnlyAfterConvergence(t *testing.T) {
+	key := KeyFromString("negcache-missing")
+	check := func(t *testing.T, p *Peer, ctx context.Context, wantErr error, wantCached bool) {
+		t.Helper()
+		if _, err := p.GetValue(ctx, key); err != wantErr {
+			t.Fatalf("GetValue error = %v, want %v", err, wantErr)
+		}
+		if p.negativeCached(key) != wantCached {
+			t.Fatalf("negative cached = %v, want %v", !wantCached, wantCached)
+		}
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		p := NewPeer(KeyFromString("negcache-deadline"))
+		tr, err := ListenUDP(p, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.Close()
+		silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer silent.Close()
+		p.kb.InsertNode(Contact{ID: KeyFromString("negcache-silent"), Addr: silent.LocalAddr().(*net.UDPAddr)}.node())
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		check(t, p, ctx, context.DeadlineExceeded, false)
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		p := newTestNetwork(16, 11)[0]
+		p.SetMaxLookupHops(1)
+		check(t, p, context.Background(), ErrLookupDepthExceeded, false)
+	})
+
+	t.Run("no responders", func(t *testing.T) {
+		p := NewPeer(KeyFromString("negcache-dead"))
+		p.SetMessenger(&deadMessenger{queried: make(map[[kbucket.IdSize]byte]bool)})
+		for _, first := range []byte{0x01, 0x02, 0x03} {
+			p.kb.InsertNode(netContact(first).node())
+		}
+		check(t, p, context.Background(), ErrNotFound, false)
+	})
+
+	t.Run("converged", func(t *testing.T) {
+		check(t, newTestNetwork(16, 11)[0], context.Background(), ErrNotFound, true)
+	})
+}
@@ -0,0 +1,41 @@
+package dht
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 查找过程中收集到的信息，即使查找未完成也会返回
+type PartialResult struct {
+	Value     []byte         // 找到的值，未找到时为 nil
+	Closest   []kbucket.Node // 已联系且有响应的最近的至多 K 个节点，按与 key 的距离从近到远排序
+	Contacted int            // 已联系的节点数量
+}
+
+// 与 FindValue 相同的迭代查找，通过 Messenger 联系进程内与网络中的节点。
+// ctx 取消、超时或超出跳数限制时返回目前为止收集到的信息以及中断的原因，
+// 调用方可以据此决定是否重试
+func (p *Peer) GetValueContext(ctx context.Context, key [kbucket.IdSize]byte) (PartialResult, error) {
+	var result PartialResult
+	if value, ok := p.store.get(key); ok {
+		result.Value = value
+		return result, nil
+	}
+	budget := p.newLookupBudget(ctx)
+	var contacted atomic.Int32 // 同一轮的查询并发进行
+	var value []byte
+	query := p.findValueQuery(key, &value)
+	closest, holder := p.iterate(key, budget, OpFindValue, func(ctx context.Context, m Messenger, c Contact) iterReply {
+		contacted.Add(1)
+		return query(ctx, m, c)
+	})
+	result.Closest = closest
+	result.Contacted = int(contacted.Load())
+	if holder != nil {
+		result.Value = value
+		return result, nil
+	}
+	return result, budget.err
+}
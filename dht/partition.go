@@ -0,0 +1,163 @@
+package dht
+
+import "time"
+
+const (
+	DefaultRejoinInterval    = 10 * time.Second
+	DefaultMaxRejoinInterval = 10 * time.Minute
+	DefaultPartitionFailures = 3
+)
+
+// 节点与网络的连通状态
+type Connectivity int
+
+const (
+	ConnOnline      Connectivity = iota // 路由表中有节点且查找能得到响应
+	ConnPartitioned                     // 路由表为空，或连续 PartitionFailures 次查找没有任何响应
+	ConnRejoining                       // 正在从种子节点重新加入
+)
+
+func (c Connectivity) String() string {
+	switch c {
+	case ConnOnline:
+		return "Online"
+	case ConnPartitioned:
+		return "Partitioned"
+	case ConnRejoining:
+		return "Rejoining"
+	}
+	return "Unknown"
+}
+
+// 节点连通状态的快照
+type Status struct {
+	Connectivity  Connectivity
+	Contacts      int       // 路由表中的节点数
+	FailedLookups int       // 连续没有得到任何响应的查找数
+	LastResponse  time.Time // 最近一次收到其他节点的响应，零值表示还没有收到过
+	Rejoins       int       // 自动重新加入成功的次数
+	NextRejoin    time.Time // 下次尝试重新加入的时间，零值表示没有安排
+}
+
+type partitionState struct {
+	seeds        []Contact // 最近一次 Bootstrap 使用的种子节点
+	failures     int
+	lastResponse time.Time
+	rejoining    bool
+	rejoins      int
+	backoff      time.Duration // 下次失败后的等待时间，0 表示 RejoinInterval
+	next         time.Time
+}
+
+func (p *Peer) Status() Status {
+	contacts := p.kb.Size()
+	p.partMu.Lock()
+	defer p.partMu.Unlock()
+	s := Status{
+		Contacts:      contacts,
+		FailedLookups: p.part.failures,
+		LastResponse:  p.part.lastResponse,
+		Rejoins:       p.part.rejoins,
+		NextRejoin:    p.part.next,
+	}
+	switch {
+	case p.part.rejoining:
+		s.Connectivity = ConnRejoining
+	case p.partitioned(contacts):
+		s.Connectivity = ConnPartitioned
+	}
+	return s
+}
+
+// 调用方需持有 p.partMu
+func (p *Peer) partitioned(contacts int) bool {
+	return contacts == 0 || p.part.failures >= p.cfg.PartitionFailures
+}
+
+// 记录查找是否得到了响应，由 recordLookup 调用
+func (p *Peer) recordReachable(ok bool) {
+	p.partMu.Lock()
+	defer p.partMu.Unlock()
+	if ok {
+		p.part.failures = 0
+	} else {
+		p.part.failures++
+	}
+}
+
+// 收到了其他节点的响应
+func (p *Peer) recordResponse(now time.Time) {
+	p.partMu.Lock()
+	p.part.lastResponse = now
+	p.partMu.Unlock()
+}
+
+func (p *Peer) rememberSeeds(seeds []Contact) {
+	if len(seeds) == 0 {
+		return
+	}
+	p.partMu.Lock()
+	p.part.seeds = append([]Contact(nil), seeds...)
+	p.partMu.Unlock()
+}
+
+// 立即从最近一次 Bootstrap 的种子节点重新加入网络，不论当前是否连通；
+// 已经在重新加入时直接返回。没有种子节点或都没有响应时返回 ErrNoSeeds
+func (p *Peer) Rejoin() error {
+	p.partMu.Lock()
+	seeds := p.part.seeds
+	if len(seeds) == 0 {
+		p.partMu.Unlock()
+		return ErrNoSeeds
+	}
+	if p.part.rejoining {
+		p.partMu.Unlock()
+		return nil
+	}
+	p.part.rejoining = true
+	p.partMu.Unlock()
+
+	err := p.Bootstrap(seeds)
+	if err == nil && p.kb.Size() == 0 {
+		err = ErrNoSeeds
+	}
+	p.partMu.Lock()
+	p.part.rejoining = false
+	if err == nil {
+		p.part.failures = 0
+		p.part.rejoins++
+		p.part.backoff, p.part.next = 0, time.Time{}
+	}
+	p.partMu.Unlock()
+	p.UpdateHealth()
+	return err
+}
+
+// 与网络断开时按退避间隔重新加入，由后台刷新周期调用。返回是否尝试了重新加入
+func (p *Peer) maybeRejoin(now time.Time) bool {
+	contacts := p.kb.Size()
+	p.partMu.Lock()
+	if !p.partitioned(contacts) {
+		p.part.backoff, p.part.next = 0, time.Time{}
+		p.partMu.Unlock()
+		return false
+	}
+	if len(p.part.seeds) == 0 || p.part.rejoining || now.Before(p.part.next) {
+		p.partMu.Unlock()
+		return false
+	}
+	p.partMu.Unlock()
+
+	if p.Rejoin() == nil {
+		return true
+	}
+	p.partMu.Lock()
+	defer p.partMu.Unlock()
+	wait := p.part.backoff
+	if wait == 0 {
+		wait = p.cfg.RejoinInterval
+	}
+	p.part.next = now.Add(p.jittered(wait))
+	p.part.backoff = min(2*wait, p.cfg.MaxRejoinInterval)
+	return true
+}
@@ -0,0 +1,179 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	rttHistorySize   = 16 // 每个节点保留的最近 RTT 样本数
+	peerStatsVersion = 1
+)
+
+var peerStatsMagic = [4]byte{'K', 'B', 'P', 'S'}
+
+// 一个节点的长期统计：在线观测、RTT 历史与可靠性
+type PeerStats struct {
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Successes uint64          // 成功联系的次数
+	Failures  uint64          // 联系失败的次数
+	RTTs      []time.Duration // 最近的 RTT 样本，从旧到新
+
+	InvalidContacts uint64 // 响应中 ID 与公钥不符而被拒绝的联系人数，见 Config.ContactValidation
+
+	// 按 RFC 6298 估计的请求超时：平滑 RTT、RTT 的平均偏差与当前的 RTO。
+	// RTO 为 0 表示还没有测量，请求使用传输层的 Timeout，见 Config.MinRTO
+	SRTT   time.Duration
+	RTTVar time.Duration
+	RTO    time.Duration
+}
+
+// 成功联系的比例，没有任何观测时返回 0。每个被拒绝的联系人按一次失败计算
+func (s PeerStats) Reliability() float64 {
+	total := s.Successes + s.Failures + s.InvalidContacts
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(total)
+}
+
+// 最近 RTT 样本的平均值，没有样本时返回 0
+func (s PeerStats) MeanRTT() time.Duration {
+	if len(s.RTTs) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, rtt := range s.RTTs {
+		sum += rtt
+	}
+	return sum / time.Duration(len(s.RTTs))
+}
+
+// 记录一次对节点 id 的联系结果，rtt 只在成功时计入历史与 RTO 估计，不大于 0 表示
+// 没有可用的样本（例如重发之后才收到的响应）。失败同时计入路由表中该节点的连续失败次数
+func (p *Peer) observe(id [kbucket.IdSize]byte, ok bool, rtt time.Duration) {
+	if !ok {
+		p.kb.MarkFailed(id)
+	} else {
+		p.recordResponse(time.Now())
+		p.kb.ObserveRTT(id, rtt)
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	s := p.statsLocked(id)
+	now := time.Now()
+	if !ok {
+		s.Failures++
+		return
+	}
+	s.Successes++
+	s.LastSeen = now
+	if rtt <= 0 {
+		return
+	}
+	s.RTTs = append(s.RTTs, rtt)
+	if len(s.RTTs) > rttHistorySize {
+		s.RTTs = s.RTTs[len(s.RTTs)-rttHistorySize:]
+	}
+	s.sampleRTO(rtt, p.cfg.MinRTO, p.cfg.MaxRTO)
+}
+
+// 节点 id 的统计，没有时创建。调用方需持有 peerStatsMu
+func (p *Peer) statsLocked(id [kbucket.IdSize]byte) *PeerStats {
+	if p.peerStats == nil {
+		p.peerStats = make(map[[kbucket.IdSize]byte]*PeerStats)
+	}
+	s := p.peerStats[id]
+	if s == nil {
+		s = &PeerStats{FirstSeen: time.Now()}
+		p.peerStats[id] = s
+	}
+	return s
+}
+
+// 返回节点 id 的统计副本
+func (p *Peer) PeerStats(id [kbucket.IdSize]byte) (PeerStats, bool) {
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	s, ok := p.peerStats[id]
+	if !ok {
+		return PeerStats{}, false
+	}
+	c := *s
+	c.RTTs = append([]time.Duration(nil), s.RTTs...)
+	return c, true
+}
+
+// 写出所有节点的统计：文件头之后为 gob 编码的内容
+func (p *Peer) WritePeerStats(w io.Writer) error {
+	var buf bytes.Buffer
+	p.peerStatsMu.Lock()
+	err := gob.NewEncoder(&buf).Encode(p.peerStats)
+	p.peerStatsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeVersioned(w, peerStatsMagic, ArtifactPeerStats, buf.Bytes())
+}
+
+// 读取统计并与现有统计合并，已有的节点以读入的历史为基础继续累计
+func (p *Peer) ReadPeerStats(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	payload, err := readVersioned(data, peerStatsMagic, ArtifactPeerStats)
+	if err != nil {
+		return err
+	}
+	var stats map[[kbucket.IdSize]byte]*PeerStats
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&stats); err != nil {
+		return err
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	if p.peerStats == nil {
+		p.peerStats = make(map[[kbucket.IdSize]byte]*PeerStats, len(stats))
+	}
+	for id, s := range stats {
+		if cur, ok := p.peerStats[id]; ok {
+			s.Successes += cur.Successes
+			s.Failures += cur.Failures
+			s.InvalidContacts += cur.InvalidContacts
+			if cur.LastSeen.After(s.LastSeen) {
+				s.LastSeen = cur.LastSeen
+			}
+			s.RTTs = append(s.RTTs, cur.RTTs...)
+			if len(s.RTTs) > rttHistorySize {
+				s.RTTs = s.RTTs[len(s.RTTs)-rttHistorySize:]
+			}
+		}
+		p.peerStats[id] = s
+	}
+	return nil
+}
+
+// 原子地保存节点统计，重启后通过 LoadPeerStats 恢复，避免信誉与 RTT 从零开始
+func (p *Peer) SavePeerStats(path string) error {
+	var buf bytes.Buffer
+	if err := p.WritePeerStats(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0o644)
+}
+
+func (p *Peer) LoadPeerStats(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.ReadPeerStats(bufio.NewReader(f))
+}
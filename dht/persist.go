@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const storeVersion = 1
+
+// 保存路由表。只有通过网络认识的节点可以在重启后恢复
+func (p *Peer) SaveRoutingTable(w io.Writer) error {
+	return p.kb.Save(w)
+}
+
+// 恢复 SaveRoutingTable 保存的路由表，返回恢复的节点数量
+func (p *Peer) LoadRoutingTable(r io.Reader) (int, error) {
+	return p.kb.Load(r, func(id [kbucket.IdSize]byte, addr string) interface{} {
+		udp, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil || addr == "" {
+			return nil // 进程内的节点没有地址，无法恢复
+		}
+		return udp
+	})
+}
+
+type savedRecord struct {
+	Key     string    `json:"key"`
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// 以 JSON 保存本地存储中未过期的记录及其过期时间
+func (p *Peer) SaveStore(w io.Writer) error {
+	var saved struct {
+		Version int           `json:"version"`
+		Records []savedRecord `json:"records"`
+	}
+	saved.Version = storeVersion
+	for _, r := range p.store.records() {
+		saved.Records = append(saved.Records, savedRecord{Key: hex.EncodeToString(r.Key[:]), Value: r.Value, Expires: r.Expires})
+	}
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// 恢复 SaveStore 保存的记录，已经过期或与 key 不符的记录被丢弃。返回恢复的记录数
+func (p *Peer) LoadStore(r io.Reader) (int, error) {
+	var saved struct {
+		Version int           `json:"version"`
+		Records []savedRecord `json:"records"`
+	}
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return 0, fmt.Errorf("dht: decode store: %w", err)
+	}
+	if saved.Version != storeVersion {
+		return 0, fmt.Errorf("dht: unsupported store version %d", saved.Version)
+	}
+	now := time.Now()
+	loaded := 0
+	for _, rec := range saved.Records {
+		raw, err := hex.DecodeString(rec.Key)
+		if err != nil || len(raw) != kbucket.IdSize {
+			return loaded, fmt.Errorf("dht: invalid record key %q", rec.Key)
+		}
+		key := MustKey(raw)
+		if p.validate(key, rec.Value) != nil || (!rec.Expires.IsZero() && !now.Before(rec.Expires)) {
+			continue
+		}
+		if p.store.restore(key, rec.Value, rec.Expires, Provenance{}) {
+			p.emitStore(ValueStored, key)
+			loaded++
+		}
+	}
+	return loaded, nil
+}
@@ -0,0 +1,79 @@
+package dht
+
+import (
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 应用指定的首选节点（例如自己的基础设施节点）。key 在 MinPrefix 的距离范围内时，
+// 它总是作为查找的起点之一，并且总是收到写入该命名空间的记录
+type PinnedPeer struct {
+	Namespace string // 适用的命名空间（见 Namespace），空字符串表示所有命名空间
+	Contact   Contact
+	MinPrefix int // 与 key 至少有多少位公共前缀时才使用，0 表示不限制
+}
+
+type pinList struct {
+	mu   sync.RWMutex
+	pins []PinnedPeer
+}
+
+// 固定一个首选节点。同一命名空间中 ID 相同的节点会被替换
+func (p *Peer) PinPeer(pin PinnedPeer) {
+	p.pins.mu.Lock()
+	defer p.pins.mu.Unlock()
+	for i, x := range p.pins.pins {
+		if x.Namespace == pin.Namespace && x.Contact.ID == pin.Contact.ID {
+			p.pins.pins[i] = pin
+			return
+		}
+	}
+	p.pins.pins = append(p.pins.pins, pin)
+}
+
+// 取消命名空间 namespace 中固定的节点 id
+func (p *Peer) UnpinPeer(namespace string, id [kbucket.IdSize]byte) {
+	p.pins.mu.Lock()
+	defer p.pins.mu.Unlock()
+	for i, x := range p.pins.pins {
+		if x.Namespace == namespace && x.Contact.ID == id {
+			p.pins.pins = append(p.pins.pins[:i], p.pins.pins[i+1:]...)
+			return
+		}
+	}
+}
+
+// 当前固定的首选节点
+func (p *Peer) PinnedPeers() []PinnedPeer {
+	p.pins.mu.RLock()
+	defer p.pins.mu.RUnlock()
+	return append([]PinnedPeer(nil), p.pins.pins...)
+}
+
+// 对 key 生效的首选节点，按 ID 去重。value 为 nil 时（读取，不知道命名空间）
+// 使用所有命名空间的节点，否则只使用 Namespace(value) 与空命名空间的节点
+func (p *Peer) pinnedFor(key [kbucket.IdSize]byte, value []byte) []Contact {
+	p.pins.mu.RLock()
+	defer p.pins.mu.RUnlock()
+	if len(p.pins.pins) == 0 {
+		return nil
+	}
+	ns := Namespace(value)
+	var contacts []Contact
+	seen := make(map[[kbucket.IdSize]byte]bool)
+	for _, pin := range p.pins.pins {
+		c := pin.Contact
+		switch {
+		case c.ID == p.node.ID || seen[c.ID]:
+			continue
+		case value != nil && pin.Namespace != "" && pin.Namespace != ns:
+			continue
+		case kbucket.CommonPrefixLen(c.ID, key) < pin.MinPrefix:
+			continue
+		}
+		seen[c.ID] = true
+		contacts = append(contacts, c)
+	}
+	return contacts
+}
@@ -0,0 +1,66 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 边查找边复制（Config.PipelineStores）：每轮查询之后向已经确定的最近节点发出 STORE，
+// 查找结束后再补上最终结果中还没有收到的节点与首选节点。返回成功的副本数以及查找中断的原因
+func (p *Peer) replicatePipelined(ctx context.Context, hash [kbucket.IdSize]byte, value []byte, trace TraceID) (int, error) {
+	budget := p.newLookupBudget(ctx)
+	budget.trace = trace
+	budget.value = value
+	rpcCtx := ContextWithTrace(ctx, trace)
+	replicas := p.policy(Namespace(value)).Replicas
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		holders [][kbucket.IdSize]byte
+	)
+	sent := make(map[[kbucket.IdSize]byte]bool) // 只在发起查找的 goroutine 中访问
+	send := func(c Contact) {
+		if sent[c.ID] || ctx.Err() != nil {
+			return
+		}
+		sent[c.ID] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.storeTo(rpcCtx, c, hash, value) {
+				mu.Lock()
+				holders = append(holders, c.ID)
+				mu.Unlock()
+			}
+		}()
+	}
+	budget.settled = func(nodes []kbucket.Node) {
+		for _, n := range nodes[:min(len(nodes), replicas)] {
+			if len(sent) >= replicas {
+				return
+			}
+			send(contactOf(n))
+		}
+	}
+	closest := contactsOf(p.lookup(hash, budget))
+	for _, c := range closest[:min(len(closest), replicas)] { // 最终的最近节点总是收到副本
+		send(c)
+	}
+	for _, c := range p.pinnedFor(hash, value) {
+		send(c)
+	}
+	wg.Wait()
+	now := p.now()
+	var expires time.Time
+	if ttl := p.recordTTL(value); ttl > 0 {
+		expires = now.Add(ttl)
+	}
+	p.owned.setHolders(hash, holders, now, expires)
+	if err := ctx.Err(); err != nil {
+		return len(holders), err
+	}
+	return len(holders), budget.err
+}
@@ -0,0 +1,65 @@
+package dht
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 每个 FIND_NODE 延迟 delay 且不返回新节点，记录最后一个 FIND_NODE 结束与每个 STORE 开始的时间
+type timedMessenger struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	lastFind time.Time
+	stores   map[[kbucket.IdSize]byte]time.Time
+}
+
+func (m *timedMessenger) Ping(ctx context.Context, to Contact) ([kbucket.IdSize]byte, error) {
+	return to.ID, nil
+}
+
+func (m *timedMessenger) FindNode(ctx context.Context, to Contact, target [kbucket.IdSize]byte) ([]Contact, error) {
+	time.Sleep(m.delay)
+	m.mu.Lock()
+	m.lastFind = time.Now()
+	m.mu.Unlock()
+	return nil, nil
+}
+
+func (m *timedMessenger) FindValue(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]byte, []Contact, error) {
+	return nil, nil, nil
+}
+
+func (m *timedMessenger) Store(ctx context.Context, to Contact, key [kbucket.IdSize]byte, value []byte) error {
+	m.mu.Lock()
+	m.stores[to.ID] = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+// 逐个查询时，最近的节点回复后就收到 STORE，不等剩下的节点回复
+func TestPipelinedStoresOverlapLookup(t *testing.T) {
+	var self [kbucket.IdSize]byte
+	self[0] = 0x80
+	p, err := NewPeerWithConfig(self, Config{K: 3, Alpha: 1, PipelineStores: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &timedMessenger{delay: 20 * time.Millisecond, stores: make(map[[kbucket.IdSize]byte]time.Time)}
+	p.SetMessenger(m)
+	for _, first := range []byte{0x10, 0x11, 0x12} {
+		p.kb.InsertNode(netContact(first).node())
+	}
+	n, err := p.replicate(context.Background(), [kbucket.IdSize]byte{}, []byte("pipelined"), NewTraceID())
+	if err != nil || n != 3 {
+		t.Fatalf("replicate = %d, %v, want 3 replicas", n, err)
+	}
+	nearest := netContact(0x10).ID
+	if at := m.stores[nearest]; !at.Before(m.lastFind) {
+		t.Fatalf("STORE to the nearest node started %v after the last FIND_NODE ended", at.Sub(m.lastFind))
+	}
+}
@@ -0,0 +1,76 @@
+package dht
+
+import (
+	"fmt"
+	"time"
+)
+
+// provider 记录使用的策略名。它包含 '/'，不会与值的命名空间（见 Namespace）冲突
+const ProviderNamespace = "/providers"
+
+// 一个命名空间的复制与有效期策略，零值字段使用 Config 中的默认值。
+// 发布方与保存方按值的命名空间各自查找策略，网络中的节点应当使用相同的设置
+type NamespacePolicy struct {
+	Replicas          int           // 发布时写入的最近节点数，不超过 K，0 表示 K
+	TTL               time.Duration // 保存方的记录有效期，负数表示不过期
+	RepublishInterval time.Duration // 重新发布的周期，应小于 TTL，负数表示不重新发布
+	Validator         Validator     // 该命名空间的记录使用的 Validator，nil 表示 SetValidator 设置的 Validator
+}
+
+// 设置命名空间的策略，ProviderNamespace 表示 provider 记录。
+// 覆盖 Config 中的 RecordTTL、RepublishInterval 或 ProviderTTL、ProviderRepublishInterval
+func (p *Peer) SetNamespacePolicy(namespace string, policy NamespacePolicy) error {
+	if namespace == ProviderNamespace && policy.Validator != nil {
+		return fmt.Errorf("dht: namespace %q: provider records have no value to validate", namespace)
+	}
+	resolved := p.resolvePolicy(namespace, policy)
+	if resolved.Replicas < 0 || resolved.Replicas > p.cfg.K {
+		return fmt.Errorf("dht: namespace %q: Replicas = %d: must be in [0, K]", namespace, policy.Replicas)
+	}
+	if resolved.TTL > 0 && resolved.RepublishInterval >= resolved.TTL {
+		return fmt.Errorf("dht: namespace %q: RepublishInterval = %v: must be shorter than TTL (%v)",
+			namespace, resolved.RepublishInterval, resolved.TTL)
+	}
+	p.policyMu.Lock()
+	defer p.policyMu.Unlock()
+	if p.policies == nil {
+		p.policies = make(map[string]NamespacePolicy)
+	}
+	p.policies[namespace] = policy
+	return nil
+}
+
+// namespace 生效的策略，零值字段已经用默认值填充
+func (p *Peer) policy(namespace string) NamespacePolicy {
+	p.policyMu.RLock()
+	policy := p.policies[namespace]
+	p.policyMu.RUnlock()
+	return p.resolvePolicy(namespace, policy)
+}
+
+func (p *Peer) resolvePolicy(namespace string, policy NamespacePolicy) NamespacePolicy {
+	ttl, republish := p.cfg.RecordTTL, p.cfg.RepublishInterval
+	if namespace == ProviderNamespace {
+		ttl, republish = p.cfg.ProviderTTL, p.cfg.ProviderRepublishInterval
+	}
+	if policy.Replicas == 0 {
+		policy.Replicas = p.cfg.K
+	}
+	if policy.TTL == 0 {
+		policy.TTL = ttl
+	}
+	if policy.RepublishInterval == 0 {
+		policy.RepublishInterval = republish
+	}
+	return policy
+}
+
+// 保存 value 时使用的有效期，不大于 0 表示不过期
+func (p *Peer) recordTTL(value []byte) time.Duration {
+	return p.policy(Namespace(value)).TTL
+}
+
+// value 的重新发布周期，不大于 0 表示不重新发布
+func (p *Peer) republishInterval(value []byte) time.Duration {
+	return p.policy(Namespace(value)).RepublishInterval
+}
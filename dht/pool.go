@@ -0,0 +1,64 @@
+package dht
+
+import (
+	"bytes"
+	"sync"
+)
+
+// 传输层热路径上复用的缓冲区。每次 RPC 的编码报文、响应负载与回复的构造缓冲区
+// 都从这里取得并在用完后归还，高请求速率下可以明显减少分配与 GC
+
+const (
+	pooledPacketSize = 512                     // 新分配的报文缓冲区的初始容量，足够放下常见的请求与响应
+	maxPooledSize    = maxPacketSize + sigSize // 超过 UDP 报文上限的缓冲区不归还
+)
+
+var (
+	packetPool = sync.Pool{New: func() any {
+		b := make([]byte, 0, pooledPacketSize)
+		return &b
+	}}
+	bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+// 取得一个长度为 0 的报文缓冲区，用完后由 putPacket 归还
+func getPacket() *[]byte {
+	b := packetPool.Get().(*[]byte)
+	*b = (*b)[:0]
+	return b
+}
+
+func putPacket(b *[]byte) {
+	if b == nil || cap(*b) > maxPooledSize {
+		return
+	}
+	packetPool.Put(b)
+}
+
+// 取得一个空的 bytes.Buffer，用完后由 putBuffer 归还
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// 把 payload 复制到池中的缓冲区，使消息可以离开读循环。用完后调用 release
+func (m *message) retain() {
+	b := getPacket()
+	*b = append(*b, m.payload...)
+	m.payload, m.pooled = *b, b
+	m.pub = nil // 公钥已在分发时记下，不再需要
+}
+
+// 归还 retain 取得的缓冲区，之后不能再使用 payload
+func (m *message) release() {
+	putPacket(m.pooled)
+	m.payload, m.pooled = nil, nil
+}
@@ -0,0 +1,66 @@
+package dht
+
+import "context"
+
+// RPC 的优先级。用户发起的操作默认为高优先级；刷新与重新发布等维护流量为低优先级：
+// 发送方限制同时进行的低优先级 RPC 数（Config.LowPriorityRPCs），接收方为它们
+// 只保留一半的处理名额（ResourceLimits.Messages），繁忙时维护流量先让路
+type Priority uint8
+
+const (
+	PriorityHigh Priority = iota
+	PriorityLow
+)
+
+type priorityKey struct{}
+
+// 返回携带优先级的 ctx，Messenger 用它发出的请求按该优先级调度
+func ContextWithPriority(ctx context.Context, pri Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, pri)
+}
+
+// ctx 携带的优先级，没有时为 PriorityHigh
+func PriorityFromContext(ctx context.Context) Priority {
+	pri, _ := ctx.Value(priorityKey{}).(Priority)
+	return pri
+}
+
+// 维护流量使用的 ctx
+func maintenanceContext() context.Context {
+	return ContextWithPriority(context.Background(), PriorityLow)
+}
+
+func priorityOf(low bool) Priority {
+	if low {
+		return PriorityLow
+	}
+	return PriorityHigh
+}
+
+// 低优先级的请求等待发送名额，ctx 结束时返回其错误；返回的函数归还名额
+func (p *Peer) scheduleSend(ctx context.Context) (func(), error) {
+	if PriorityFromContext(ctx) != PriorityLow {
+		return func() {}, nil
+	}
+	if err := p.waitBatch(ctx); err != nil {
+		return nil, err
+	}
+	if p.lowSends == nil {
+		return func() {}, nil
+	}
+	select {
+	case p.lowSends <- struct{}{}:
+		return func() { <-p.lowSends }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 为收到的请求占用一份 ResourceMessages，低优先级的请求最多占用上限的一半
+func (p *Peer) acquireRequest(op string, pri Priority) bool {
+	limit := p.cfg.Resources.Messages
+	if pri == PriorityLow && limit > 0 {
+		limit = max(limit/2, 1)
+	}
+	return p.acquireUpTo(ResourceMessages, op, limit)
+}
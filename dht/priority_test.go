@@ -0,0 +1,74 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 低优先级的 RPC 超出 LowPriorityRPCs 时排队，高优先级的 RPC 不受限制
+func TestLowPrioritySendsQueue(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("priority-sends"), Config{LowPriorityRPCs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := ContextWithPriority(context.Background(), PriorityLow)
+	done, err := p.scheduleSend(low)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(low, 20*time.Millisecond)
+	defer cancel()
+	if _, err := p.scheduleSend(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("second low-priority send = %v, want it queued until the deadline", err)
+	}
+	high, err := p.scheduleSend(context.Background())
+	if err != nil {
+		t.Fatalf("high-priority send queued behind maintenance traffic: %v", err)
+	}
+	high()
+	done()
+	if _, err := p.scheduleSend(low); err != nil {
+		t.Fatalf("low-priority send after the slot was released: %v", err)
+	}
+}
+
+// 接收方繁忙时丢弃标记为低优先级的 UDP 请求，高优先级的请求仍然得到处理
+func TestLowPriorityRequestsYield(t *testing.T) {
+	a := NewPeer(KeyFromString("priority-a"))
+	b, err := NewPeerWithConfig(KeyFromString("priority-b"), Config{Resources: ResourceLimits{Messages: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta, err := ListenUDP(a, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ta.Close()
+	ta.Timeout = 100 * time.Millisecond
+	ta.Retries = 0
+	tb, err := ListenUDP(b, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Close()
+	m, to := udpMessenger{t: ta}, Contact{ID: b.ID(), Addr: tb.Addr()}
+	low := ContextWithPriority(context.Background(), PriorityLow)
+
+	if _, err := m.Ping(context.Background(), to); err != nil { // 握手，a 得知 b 支持优先级
+		t.Fatal(err)
+	}
+	if _, err := m.Ping(low, to); err != nil {
+		t.Fatalf("low-priority Ping to an idle peer: %v", err)
+	}
+	if !b.acquire(ResourceMessages, "test") { // 占用一半的处理名额
+		t.Fatal("acquire failed")
+	}
+	defer b.release(ResourceMessages)
+	if _, err := m.Ping(low, to); err != ErrTimeout {
+		t.Fatalf("low-priority Ping to a busy peer = %v, want it dropped", err)
+	}
+	if _, err := m.Ping(context.Background(), to); err != nil {
+		t.Fatalf("high-priority Ping to a busy peer: %v", err)
+	}
+}
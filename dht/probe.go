@@ -0,0 +1,61 @@
+package dht
+
+import (
+	"context"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 探测一批偏向陈旧/未验证的节点，更新其存活时间并删除无法联系的节点，
+// 返回删除的节点数量。由调用方周期性调用，无需对整个路由表逐一 ping
+func (p *Peer) ProbeContacts(n int) int {
+	removed := 0
+	for _, node := range p.kb.SampleContacts(n, 1) {
+		if !p.probe(node) {
+			removed++
+		}
+	}
+	p.UpdateHealth()
+	return removed
+}
+
+// 存活检查：ping 超过 HealthCheckInterval 没有确认存活的节点（未配置时 ping 所有节点），
+// 成功的更新存活时间，失败的累计连续失败次数；然后删除已失效且超过该周期
+// 没有确认存活的节点。返回删除的节点数
+func (p *Peer) HealthCheck() int {
+	now := time.Now()
+	for _, node := range p.kb.AllNodes() {
+		if now.Sub(node.LastSeen) < p.cfg.HealthCheckInterval {
+			continue
+		}
+		if p.ping(node) {
+			p.kb.MarkSeen(node.ID, time.Now())
+		}
+	}
+	removed := p.kb.PruneStale(p.cfg.HealthCheckInterval)
+	p.UpdateHealth()
+	return removed
+}
+
+// ping 一个节点，无法联系时将其从路由表中删除
+func (p *Peer) probe(node kbucket.Node) bool {
+	if p.ping(node) {
+		p.kb.MarkSeen(node.ID, time.Now())
+		return true
+	}
+	p.kb.RemoveNode(node.ID)
+	return false
+}
+
+// 检查节点是否存活并记录结果，不修改路由表。也用作路由表淘汰时的存活检查
+func (p *Peer) ping(node kbucket.Node) bool {
+	c := contactOf(node)
+	if m := p.messengerFor(c); m != nil {
+		if id, err := m.Ping(context.Background(), c); err == nil && id == c.ID { // 成功时由 Messenger 记录
+			return true
+		}
+	}
+	p.observe(node.ID, false, 0)
+	return false
+}
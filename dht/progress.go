@@ -0,0 +1,43 @@
+package dht
+
+import "github.com/WuQingyang2/K_Bucket/kbucket"
+
+// 路由表预热进度
+type BootstrapProgress struct {
+	BucketsRefreshed int     // 已被查找/刷新过的 bucket 数量
+	ContactsLearned  int     // 路由表中的节点数量
+	Readiness        float64 // 估计的就绪百分比（0-100）
+}
+
+// 报告路由表的填充程度，应用可以据此推迟对外服务，直到路由表足够完整。
+// 就绪度按照从最深的非空 bucket 到最浅 bucket 之间各 bucket 的填充率估算
+func (p *Peer) BootstrapProgress() BootstrapProgress {
+	var progress BootstrapProgress
+	deepest := -1
+	for i := 0; i < kbucket.IdSize*8; i++ {
+		bucket := p.kb.GetBucket(i)
+		if !bucket.LastLookup().IsZero() {
+			progress.BucketsRefreshed++
+		}
+		if bucket.Len() > 0 {
+			progress.ContactsLearned += bucket.Len()
+			if deepest < 0 {
+				deepest = i
+			}
+		}
+	}
+	if deepest < 0 {
+		return progress
+	}
+	var fill float64
+	span := kbucket.IdSize*8 - deepest
+	for i := deepest; i < kbucket.IdSize*8; i++ {
+		n := p.kb.GetBucket(i).Len()
+		if n > p.kb.MaxNodes() {
+			n = p.kb.MaxNodes()
+		}
+		fill += float64(n) / float64(p.kb.MaxNodes())
+	}
+	progress.Readiness = 100 * fill / float64(span)
+	return progress
+}
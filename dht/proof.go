@@ -0,0 +1,106 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 响应 GET 的节点对 key 的看法：它所知道的距离 key 最近的至多 K 个 ID（包括它自己）。
+// 客户端可以据此检查响应方是否可能负责该 key，并与自己的视图比较以发现分区或被污染的路由
+type ResponsibilityProof struct {
+	Key       [kbucket.IdSize]byte
+	Responder [kbucket.IdSize]byte
+	Closest   [][kbucket.IdSize]byte // 按与 Key 的距离从近到远排序
+}
+
+// 本节点对 key 的负责证明
+func (p *Peer) responsibilityProof(key [kbucket.IdSize]byte) *ResponsibilityProof {
+	proof := &ResponsibilityProof{Key: key, Responder: p.node.ID}
+	self := false
+	for _, node := range p.kb.FindClosestNodes(key, p.cfg.K) {
+		if !self && p.closer(p.node.ID, node.ID, key) {
+			proof.Closest = append(proof.Closest, p.node.ID)
+			self = true
+		}
+		proof.Closest = append(proof.Closest, node.ID)
+	}
+	if !self {
+		proof.Closest = append(proof.Closest, p.node.ID)
+	}
+	if len(proof.Closest) > p.cfg.K {
+		proof.Closest = proof.Closest[:p.cfg.K]
+	}
+	return proof
+}
+
+// 响应方是否在它自己给出的最近节点之中
+func (r *ResponsibilityProof) Responsible() bool {
+	for _, id := range r.Closest {
+		if id == r.Responder {
+			return true
+		}
+	}
+	return false
+}
+
+// 证明中的最近节点与 view 的交集大小。交集过小说明双方看到的网络不一致
+func (r *ResponsibilityProof) Overlap(view []kbucket.Node) int {
+	n := 0
+	for _, node := range view {
+		for _, id := range r.Closest {
+			if id == node.ID {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// 与 GetValue 相同，同时返回持有该值的节点的负责证明
+func (p *Peer) GetValueWithProof(ctx context.Context, key [kbucket.IdSize]byte) ([]byte, *ResponsibilityProof, error) {
+	if value, ok := p.store.get(key); ok {
+		return value, p.responsibilityProof(key), nil
+	}
+	budget := p.newLookupBudget(ctx)
+	value, holder, _ := p.findValueAt(key, budget)
+	if holder == nil && budget.err != nil {
+		return nil, nil, budget.err
+	}
+	if holder == nil {
+		return nil, nil, ErrNotFound
+	}
+	if holder.Peer != nil {
+		return value, holder.Peer.responsibilityProof(key), nil
+	}
+	if p.transport == nil {
+		return value, nil, ErrUnsupported
+	}
+	_, proof, err := p.transport.Traced(budget.trace).FindValueWithProof(holder.Addr, key) // 网络中的持有者另外请求证明
+	return value, proof, err
+}
+
+// 证明格式：数量(1) | 每个 ID(IdSize)。响应方 ID 取自消息头
+func encodeProof(buf *bytes.Buffer, proof *ResponsibilityProof) {
+	buf.WriteByte(byte(len(proof.Closest)))
+	for _, id := range proof.Closest {
+		buf.Write(id[:])
+	}
+}
+
+func decodeProof(r *bytes.Reader, key, responder [kbucket.IdSize]byte) (*ResponsibilityProof, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrBadPacket
+	}
+	proof := &ResponsibilityProof{Key: key, Responder: responder, Closest: make([][kbucket.IdSize]byte, count)}
+	for i := range proof.Closest {
+		if _, err := io.ReadFull(r, proof.Closest[i][:]); err != nil {
+			return nil, ErrBadPacket
+		}
+	}
+	return proof, nil
+}
@@ -0,0 +1,40 @@
+package dht
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 本地保存的一条记录及其来源
+type StoredRecord struct {
+	Key        [kbucket.IdSize]byte
+	Value      []byte
+	Expires    time.Time // 零值表示不过期
+	Published  time.Time // 最近一次由本节点发布（或重新发布）的时间
+	Provenance Provenance
+}
+
+// 按 key 的顺序遍历本地未过期的记录，fn 返回 false 时停止。
+// 遍历的是调用时的副本，fn 中可以读写 DHT
+func (p *Peer) RangeRecords(fn func(StoredRecord) bool) {
+	records := p.store.records()
+	sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i].Key[:], records[j].Key[:]) < 0 })
+	for _, r := range records {
+		if !fn(r) {
+			return
+		}
+	}
+}
+
+// 本节点自己发布的记录的来源
+func (p *Peer) ownOrigin() Provenance {
+	return Provenance{Publisher: p.node.ID, Signed: p.verifiable(), StoredBy: p.node.ID}
+}
+
+// 由 sender 直接发来的 STORE 的来源，signed 表示消息的签名已经验证
+func senderOrigin(sender [kbucket.IdSize]byte, signed bool) Provenance {
+	return Provenance{Publisher: sender, Signed: signed, StoredBy: sender}
+}
@@ -0,0 +1,265 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const MaxProvidersPerKey = 20 // 每个 key 最多保存的 provider 记录数
+
+// 一条 provider 记录：持有 key 对应数据的节点以及记录的过期时间，零值表示不过期。
+// 与 SetValue 不同，数据本身不进入 DHT，下载方按 Contact 直接联系 provider
+type Provider struct {
+	Contact Contact
+	Expires time.Time
+}
+
+// 支持 provider 记录的 Messenger。没有实现它的 Messenger 联系的节点不保存
+// provider 记录，FindProviders 经过它们时只用 FIND_NODE 继续查找
+type ProviderMessenger interface {
+	// 请求对方把本节点记为 key 的 provider，有效期由对方的 Config.ProviderTTL 决定
+	AddProvider(ctx context.Context, to Contact, key [kbucket.IdSize]byte) error
+	// 返回对方保存的 key 的 provider 以及它知道的最近节点
+	GetProviders(ctx context.Context, to Contact, key [kbucket.IdSize]byte) ([]Provider, []Contact, error)
+}
+
+// 本地保存的 provider 记录，以及本节点自己提供的 key
+type providerStore struct {
+	mu  sync.Mutex
+	m   map[[kbucket.IdSize]byte]map[[kbucket.IdSize]byte]Provider
+	own map[[kbucket.IdSize]byte]time.Time // 本节点提供的 key 及最近一次通告的时间
+}
+
+// 保存或续期 key 的一条记录，ttl 不大于 0 表示不过期。key 的记录已满时
+// 替换最早过期的记录，新记录并不更晚过期时不保存。返回是否保存
+func (s *providerStore) add(key [kbucket.IdSize]byte, c Contact, ttl time.Duration, now time.Time) bool {
+	pr := Provider{Contact: c}
+	if ttl > 0 {
+		pr.Expires = now.Add(ttl)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.m[key]
+	if entries == nil {
+		if s.m == nil {
+			s.m = make(map[[kbucket.IdSize]byte]map[[kbucket.IdSize]byte]Provider)
+		}
+		entries = make(map[[kbucket.IdSize]byte]Provider)
+		s.m[key] = entries
+	}
+	if _, ok := entries[c.ID]; !ok && len(entries) >= MaxProvidersPerKey {
+		var oldest [kbucket.IdSize]byte
+		found := false
+		for id, e := range entries {
+			if !e.Expires.IsZero() && (!found || e.Expires.Before(entries[oldest].Expires)) {
+				oldest, found = id, true
+			}
+		}
+		if !found || (!pr.Expires.IsZero() && !pr.Expires.After(entries[oldest].Expires)) {
+			return false
+		}
+		delete(entries, oldest)
+	}
+	entries[c.ID] = pr
+	return true
+}
+
+// key 未过期的记录，按过期时间从晚到早排序
+func (s *providerStore) get(key [kbucket.IdSize]byte, now time.Time) []Provider {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var providers []Provider
+	for _, e := range s.m[key] {
+		if e.Expires.IsZero() || now.Before(e.Expires) {
+			providers = append(providers, e)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return laterExpiry(providers[i], providers[j]) })
+	return providers
+}
+
+// a 比 b 更晚过期，不过期的记录排在最前
+func laterExpiry(a, b Provider) bool {
+	switch {
+	case a.Expires.IsZero():
+		return !b.Expires.IsZero()
+	case b.Expires.IsZero():
+		return false
+	}
+	return a.Expires.After(b.Expires)
+}
+
+// 删除过期的记录，返回删除的数量
+func (s *providerStore) expire(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, entries := range s.m {
+		for id, e := range entries {
+			if !e.Expires.IsZero() && !now.Before(e.Expires) {
+				delete(entries, id)
+				n++
+			}
+		}
+		if len(entries) == 0 {
+			delete(s.m, key)
+		}
+	}
+	return n
+}
+
+func (s *providerStore) providing(key [kbucket.IdSize]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.own[key]
+	return ok
+}
+
+func (s *providerStore) markOwn(key [kbucket.IdSize]byte, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.own == nil {
+		s.own = make(map[[kbucket.IdSize]byte]time.Time)
+	}
+	s.own[key] = now
+}
+
+// 上次通告早于 before 的本节点提供的 key，并把它们的通告时间记为 now
+func (s *providerStore) dueAnnounce(before, now time.Time) [][kbucket.IdSize]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due [][kbucket.IdSize]byte
+	for key, last := range s.own {
+		if last.Before(before) {
+			due = append(due, key)
+			s.own[key] = now
+		}
+	}
+	return due
+}
+
+// 把本节点通告为 key 的 provider：查找距离 key 最近的节点（默认 K 个，见
+// ProviderNamespace 的策略）并向它们发送 ADD_PROVIDER，之后按
+// ProviderRepublishInterval 重新通告，直到 StopProviding。
+// 返回接受了记录的节点数，ctx 结束时返回已经通告的节点数以及 ctx.Err()
+func (p *Peer) Provide(ctx context.Context, key [kbucket.IdSize]byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	p.providers.markOwn(key, p.now())
+	return p.announce(ctx, key)
+}
+
+// 停止通告 key，已发出的记录在各节点上按 ProviderTTL 过期
+func (p *Peer) StopProviding(key [kbucket.IdSize]byte) {
+	p.providers.mu.Lock()
+	defer p.providers.mu.Unlock()
+	delete(p.providers.own, key)
+}
+
+func (p *Peer) announce(ctx context.Context, key [kbucket.IdSize]byte) (int, error) {
+	trace := NewTraceID()
+	budget := p.newLookupBudget(ctx)
+	budget.trace = trace
+	targets := contactsOf(p.lookup(key, budget))
+	if n := p.policy(ProviderNamespace).Replicas; len(targets) > n {
+		targets = targets[:n]
+	}
+	rpcCtx := ContextWithTrace(ctx, trace)
+	added := 0
+	for _, c := range targets {
+		if err := ctx.Err(); err != nil {
+			return added, err
+		}
+		m, ok := p.messengerFor(c).(ProviderMessenger)
+		if !ok {
+			continue
+		}
+		err := m.AddProvider(rpcCtx, c, key)
+		if errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnreachable) {
+			p.observe(c.ID, false, 0)
+		}
+		if err == nil {
+			added++
+		}
+	}
+	return added, budget.err
+}
+
+// 查找 key 的 provider：先读取本地记录，再像 FindValue 一样迭代地查询更近的
+// 节点，合并它们返回的记录，凑够 limit 条时提前结束（limit <= 0 表示查询到
+// 最近的 K 个节点为止）。本节点自己也在提供 key 时排在最前。
+// 查找被中断时返回已经找到的记录以及 ctx.Err() 或 ErrLookupDepthExceeded
+func (p *Peer) FindProviders(ctx context.Context, key [kbucket.IdSize]byte, limit int) ([]Provider, error) {
+	var providers []Provider
+	seen := make(map[[kbucket.IdSize]byte]bool)
+	collect := func(found []Provider) bool {
+		for _, pr := range found {
+			if seen[pr.Contact.ID] || p.banned(pr.Contact.ID, pr.Contact.Addr) {
+				continue
+			}
+			seen[pr.Contact.ID] = true
+			providers = append(providers, pr)
+		}
+		return limit > 0 && len(providers) >= limit
+	}
+	if p.providers.providing(key) {
+		collect([]Provider{{Contact: Contact{ID: p.node.ID, Peer: p}}})
+	}
+	if collect(p.providers.get(key, p.now())) {
+		return providers[:limit], nil
+	}
+	if err := ctx.Err(); err != nil {
+		return providers, err
+	}
+	budget := p.newLookupBudget(ctx)
+	p.iterate(key, budget, OpGetProviders, func(ctx context.Context, m Messenger, c Contact) iterReply {
+		pm, ok := m.(ProviderMessenger)
+		if !ok {
+			nodes, err := m.FindNode(ctx, c, key)
+			return func() ([]Contact, bool, error) { return nodes, false, err }
+		}
+		found, nodes, err := pm.GetProviders(ctx, c, key)
+		return func() ([]Contact, bool, error) {
+			if err != nil {
+				return nil, false, err
+			}
+			return nodes, collect(found), nil
+		}
+	})
+	if limit > 0 && len(providers) >= limit {
+		return providers[:limit], nil
+	}
+	return providers, budget.err
+}
+
+// 收到 ADD_PROVIDER：把请求方 c 记为 key 的 provider。记录已满时可能被忽略，
+// 请求方不需要区分
+func (p *Peer) addProvider(key [kbucket.IdSize]byte, c Contact) {
+	p.providers.add(key, c, p.policy(ProviderNamespace).TTL, p.now())
+}
+
+// 删除本地过期的 provider 记录，返回删除的数量
+func (p *Peer) ExpireProviders() int {
+	return p.providers.expire(p.now())
+}
+
+// 重新通告超过 ProviderRepublishInterval（或 ProviderNamespace 的策略）没有通告过的 key，
+// 使其他节点上的记录在过期之前得到续期。返回重新通告的 key 数
+func (p *Peer) RepublishProviders() int {
+	interval := p.policy(ProviderNamespace).RepublishInterval
+	if interval <= 0 {
+		return 0
+	}
+	now := p.now()
+	due := p.providers.dueAnnounce(now.Add(-interval), now)
+	for _, key := range due {
+		p.announce(maintenanceContext(), key)
+	}
+	return len(due)
+}
@@ -0,0 +1,84 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 不再重试而副本数仍未达到 PublishOptions.MinReplicas
+var ErrInsufficientReplicas = errors.New("dht: not enough replicas acknowledged")
+
+// SetValueAsync 的选项，零值字段使用默认值
+type PublishOptions struct {
+	MinReplicas   int           // 至少需要确认的副本数（包括本地保存的一份），0 表示 K
+	RetryInterval time.Duration // 副本不足时重试的间隔，0 表示记录的重新发布周期，负数表示不重试
+}
+
+// 异步发布的最终结果
+type PublishResult struct {
+	Key      [kbucket.IdSize]byte
+	Replicas int   // 最后一次尝试确认的副本数
+	Attempts int   // 尝试发布的次数
+	Err      error // nil 表示副本数达到 MinReplicas；ctx 结束时为 ctx.Err()
+}
+
+// 异步发布一个值，立即返回。后台像 SetValue 一样发布，确认的副本少于 MinReplicas 时
+// 每隔 RetryInterval 重新发布，直到满足或 ctx 结束。结果写入返回的 channel，随后关闭它。
+// 后台 goroutine 已到 Config.Resources.Goroutines 时结果为 ErrBusy
+func (p *Peer) SetValueAsync(ctx context.Context, key, value []byte, opts PublishOptions) <-chan PublishResult {
+	ch := make(chan PublishResult, 1)
+	if opts.MinReplicas <= 0 {
+		opts.MinReplicas = p.cfg.K
+	}
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = p.republishInterval(value)
+	}
+	started := p.spawn(OpStore, func() {
+		defer close(ch)
+		ch <- p.publishUntil(ctx, key, value, opts)
+	})
+	if !started { // 后台 goroutine 已到上限
+		var r PublishResult
+		copy(r.Key[:], key)
+		r.Err = ErrBusy
+		ch <- r
+		close(ch)
+	}
+	return ch
+}
+
+func (p *Peer) publishUntil(ctx context.Context, key, value []byte, opts PublishOptions) PublishResult {
+	var r PublishResult
+	copy(r.Key[:], key)
+	n, err := p.SetValue(ctx, key, value)
+	r.Replicas, r.Attempts = n, 1
+	for {
+		switch {
+		case err != nil && n == 0 && ctx.Err() == nil && !errors.Is(err, ErrLookupDepthExceeded): // 值无效或无法写入日志，重试没有意义
+			r.Err = err
+			return r
+		case r.Replicas >= opts.MinReplicas:
+			return r
+		case opts.RetryInterval <= 0:
+			r.Err = ErrInsufficientReplicas
+			return r
+		}
+		timer := time.NewTimer(p.jittered(opts.RetryInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.Err = ctx.Err()
+			return r
+		case <-timer.C:
+		}
+		n, err = p.replicate(ctx, r.Key, value, NewTraceID())
+		if p.store.has(r.Key) {
+			n++
+		}
+		r.Replicas = n
+		r.Attempts++
+	}
+}
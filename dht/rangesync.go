@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultRangeSyncPageSize = 64 // RANGE_SYNC 每页最多的记录数
+	DefaultRangeSyncRate     = 5  // 每个节点每秒可以请求的 RANGE_SYNC 页数
+
+	rangeSyncMaxBusy = 5 // 连续收到多少次 BUSY 后放弃同步
+)
+
+// RANGE_SYNC 一页的字节数上限，使一页总能放进一个 UDP 数据包：
+// 消息头、签名、标志与下一页起点之外，每条记录另有 key、有效期与长度
+const rangeSyncMaxBytes = maxPacketSize - headerSize - sigSize - 1 - 1 - kbucket.IdSize - 2
+
+// RANGE_SYNC 返回的一条记录。TTL 为剩余有效期，0 表示不过期
+type SyncRecord struct {
+	Key   [kbucket.IdSize]byte
+	Value []byte
+	TTL   time.Duration
+}
+
+// RANGE_SYNC 的一页结果，记录按 key 从小到大排列。More 为 true 时
+// 以 Next 为起点请求下一页
+type RangePage struct {
+	Records []SyncRecord
+	Next    [kbucket.IdSize]byte
+	More    bool
+}
+
+// 支持 RANGE_SYNC 的 Messenger。新加入的节点用它向相邻节点请求落在自己负责区域内的
+// 全部记录，不必等待重新发布。没有实现它的 Messenger 联系的节点不参与同步
+type RangeSyncer interface {
+	// 请求对方保存的、落在 r 中且 key 不小于 from 的记录，至多 limit 条
+	RangeSync(ctx context.Context, to Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error)
+}
+
+// 本地保存的、落在 r 中且 key 不小于 from 的记录，至多 limit 条，总字节数不超过 rangeSyncMaxBytes。
+// 单条就超过上限的记录无法通过 RANGE_SYNC 传输，直接跳过
+func (p *Peer) rangePage(r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) RangePage {
+	if limit <= 0 || limit > DefaultRangeSyncPageSize {
+		limit = DefaultRangeSyncPageSize
+	}
+	now := p.now()
+	if first := r.first(); bytes.Compare(from[:], first[:]) < 0 {
+		from = first
+	}
+	var page RangePage
+	size := 0
+	// 负责区域是一个前缀，其中的 key 连续排列，遇到第一个区域外的 key 就结束
+	p.store.ascend(from, func(rec StoredRecord) bool {
+		if !r.Contains(rec.Key) {
+			return false
+		}
+		n := kbucket.IdSize + 4 + 4 + len(rec.Value)
+		if n > rangeSyncMaxBytes {
+			return true
+		}
+		if len(page.Records) == limit || size+n > rangeSyncMaxBytes {
+			page.Next, page.More = rec.Key, true
+			return false
+		}
+		size += n
+		sr := SyncRecord{Key: rec.Key, Value: rec.Value}
+		if !rec.Expires.IsZero() {
+			sr.TTL = max(rec.Expires.Sub(now), time.Second)
+		}
+		page.Records = append(page.Records, sr)
+		return true
+	})
+	return page
+}
+
+// 是否为 id 提供一页 RANGE_SYNC，拒绝时返回建议的等待时间
+func (p *Peer) allowRangeSync(id [kbucket.IdSize]byte) (bool, time.Duration) {
+	rate := p.cfg.RangeSyncRate
+	if rate <= 0 {
+		return true, 0
+	}
+	burst := burstOf(rate, 0)
+	l := &p.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.syncs == nil {
+		l.syncs = make(map[[kbucket.IdSize]byte]*tokenBucket)
+	}
+	b, ok := l.syncs[id]
+	if !ok {
+		if len(l.syncs) >= maxTrackedPeers {
+			l.syncs = make(map[[kbucket.IdSize]byte]*tokenBucket) // 令牌桶很快补满，清空的代价只是多允许一次突发
+		}
+		b = &tokenBucket{}
+		l.syncs[id] = b
+	}
+	if b.take(time.Now(), rate, burst) {
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// 处理 id 的一次 RANGE_SYNC 请求，超出频率时返回 CodeBusy 与等待时间
+func (p *Peer) serveRangeSync(id [kbucket.IdSize]byte, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, ErrorCode, time.Duration) {
+	if ok, wait := p.allowRangeSync(id); !ok {
+		return RangePage{}, CodeBusy, wait
+	}
+	return p.rangePage(r, from, limit), CodeOK, 0
+}
+
+// 逐页从 c 拉取落在 r 中的记录并保存，返回新保存的记录数。
+// 对方要求等待时按它给出的时间暂停，已有的记录不覆盖
+func (p *Peer) SyncRange(ctx context.Context, c Contact, r ResponsibilityRange) (int, error) {
+	m := p.messengerFor(c)
+	syncer, ok := m.(RangeSyncer)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	var from [kbucket.IdSize]byte
+	stored, busy := 0, 0
+	for {
+		page, err := syncer.RangeSync(ctx, c, r, from, DefaultRangeSyncPageSize)
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) && rpcErr.Code == CodeBusy && busy < rangeSyncMaxBusy {
+			busy++
+			wait := rpcErr.RetryAfter
+			if wait <= 0 {
+				wait = p.busyRetryAfter()
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return stored, ctx.Err()
+			}
+		}
+		if err != nil {
+			return stored, err
+		}
+		busy = 0
+		stored += p.acceptSynced(c.ID, r, page.Records)
+		if !page.More || bytes.Compare(page.Next[:], from[:]) <= 0 { // 起点不前进的响应视为结束
+			return stored, nil
+		}
+		from = page.Next
+	}
+}
+
+// 保存 RANGE_SYNC 收到的记录，保留剩余的有效期。返回新保存的记录数
+func (p *Peer) acceptSynced(from [kbucket.IdSize]byte, r ResponsibilityRange, records []SyncRecord) int {
+	now := p.now()
+	origin := Provenance{StoredBy: from, Hops: 1}
+	stored := 0
+	for _, rec := range records {
+		if !r.Contains(rec.Key) || p.validate(rec.Key, rec.Value) != nil || p.store.has(rec.Key) {
+			continue
+		}
+		if p.storeFullFor(rec.Value) {
+			continue
+		}
+		var expires time.Time
+		if rec.TTL > 0 {
+			expires = now.Add(rec.TTL)
+		}
+		if p.store.restore(rec.Key, rec.Value, expires, origin) {
+			p.forgetMiss(rec.Key)
+			p.emitStore(ValueRepaired, rec.Key)
+			p.notifyWatchers(rec.Key)
+			stored++
+		}
+	}
+	return stored
+}
+
+// 向距离自身最近的 K 个邻居请求落在本节点负责区域内的记录，返回新保存的记录数。
+// 新加入的节点调用它可以立即成为这些 key 的副本，见 Config.SyncOnJoin
+func (p *Peer) SyncNeighbors(ctx context.Context) (int, error) {
+	r := p.ResponsibleRange()
+	stored := 0
+	var errs []error
+	for _, c := range contactsOf(p.kb.FindClosestNodes(p.node.ID, p.cfg.K)) {
+		if ctx.Err() != nil {
+			break
+		}
+		n, err := p.SyncRange(ctx, c, r)
+		stored += n
+		if err != nil && !errors.Is(err, ErrUnsupported) {
+			errs = append(errs, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return stored, err
+	}
+	if stored == 0 && len(errs) > 0 {
+		return 0, errors.Join(errs...)
+	}
+	return stored, nil
+}
@@ -0,0 +1,196 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 按页读取时每页的 key 从上一页的 Next 开始、从小到大排列，合起来恰好是区域内的全部记录。
+// 内存与磁盘两种后端都按 key 的顺序遍历
+func TestRangePageCursor(t *testing.T) {
+	for _, backend := range []string{"memory", "disk"} {
+		t.Run(backend, func(t *testing.T) {
+			p := NewPeer(KeyFromString("rangesync-cursor"))
+			if backend == "disk" {
+				s, err := OpenDiskStorage(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := p.SetStorage(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := ResponsibilityRange{Self: p.ID(), Bits: 2}
+			var want [][kbucket.IdSize]byte
+			for i := 0; i < 600; i++ {
+				value := []byte(fmt.Sprintf("rangesync-cursor-%d", i))
+				key := KeyFromBytes(value)
+				p.store.put(key, value, Provenance{})
+				if r.Contains(key) {
+					want = append(want, key)
+				}
+			}
+			sort.Slice(want, func(i, j int) bool { return bytes.Compare(want[i][:], want[j][:]) < 0 })
+
+			var got [][kbucket.IdSize]byte
+			var from [kbucket.IdSize]byte
+			pages := 0
+			for {
+				page := p.rangePage(r, from, 16)
+				pages++
+				for _, rec := range page.Records {
+					got = append(got, rec.Key)
+				}
+				if !page.More {
+					break
+				}
+				if len(page.Records) != 16 || bytes.Compare(page.Next[:], page.Records[15].Key[:]) <= 0 {
+					t.Fatalf("page %d: %d records ending at %x, next %x", pages, len(page.Records), page.Records[len(page.Records)-1].Key[:4], page.Next[:4])
+				}
+				from = page.Next
+			}
+			if pages < 2 || len(got) != len(want) {
+				t.Fatalf("%d pages with %d records, want all %d records in the range", pages, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("record %d = %x, want %x", i, got[i][:4], want[i][:4])
+				}
+			}
+		})
+	}
+}
+
+// 一页的字节数不超过 rangeSyncMaxBytes，放不下的记录留到下一页；经过 UDP 逐页取回全部记录，
+// 单条就超过上限的记录被跳过
+func TestSyncRangeByteCap(t *testing.T) {
+	p := NewPeer(KeyFromString("rangesync-self"))
+	q := NewPeer(KeyFromString("rangesync-remote"))
+	tp, err := ListenUDP(p, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tp.Close()
+	tq, err := ListenUDP(q, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tq.Close()
+
+	const records = 12
+	for i := 0; i < records; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, rangeSyncMaxBytes/5)
+		q.store.put(KeyFromBytes(value), value, Provenance{})
+	}
+	huge := bytes.Repeat([]byte{0xff}, rangeSyncMaxBytes)
+	q.store.put(KeyFromBytes(huge), huge, Provenance{})
+
+	var from [kbucket.IdSize]byte
+	for pages := 1; ; pages++ {
+		page := q.rangePage(ResponsibilityRange{}, from, DefaultRangeSyncPageSize)
+		size := 0
+		for _, rec := range page.Records {
+			size += kbucket.IdSize + 4 + 4 + len(rec.Value)
+		}
+		if size > rangeSyncMaxBytes || len(page.Records) == 0 || len(page.Records) == records {
+			t.Fatalf("page %d: %d records of %d bytes, want a partial page within %d bytes", pages, len(page.Records), size, rangeSyncMaxBytes)
+		}
+		if !page.More {
+			break
+		}
+		from = page.Next
+	}
+
+	n, err := p.SyncRange(context.Background(), Contact{ID: q.ID(), Addr: tq.Addr()}, ResponsibilityRange{})
+	if err != nil || n != records {
+		t.Fatalf("SyncRange = %d, %v, want %d records", n, err, records)
+	}
+	if p.store.has(KeyFromBytes(huge)) {
+		t.Fatal("a record larger than a page was transferred")
+	}
+}
+
+// 对方回复 BUSY 时按给出的时间等待后继续，最终取回全部记录
+func TestSyncRangeBusyBackoff(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RangeSyncRate = 8 // 突发 8 页，之后每页等待 125ms
+	p := NewPeer(KeyFromString("rangesync-self"))
+	q, _ := NewPeerWithConfig(KeyFromString("rangesync-remote"), cfg)
+	m := rangeMessenger{from: p, to: q, calls: make(map[string]int)}
+	p.SetMessenger(m)
+
+	const pages = 10
+	for i := 0; i < pages*DefaultRangeSyncPageSize; i++ {
+		value := []byte(fmt.Sprintf("rangesync-busy-%d", i))
+		q.store.put(KeyFromBytes(value), value, Provenance{})
+	}
+	c := Contact{ID: q.ID(), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}}
+	start := time.Now()
+	n, err := p.SyncRange(context.Background(), c, ResponsibilityRange{})
+	if err != nil || n != pages*DefaultRangeSyncPageSize {
+		t.Fatalf("SyncRange = %d, %v, want %d records", n, err, pages*DefaultRangeSyncPageSize)
+	}
+	if m.calls[OpRangeSync] <= pages || time.Since(start) < 200*time.Millisecond {
+		t.Fatalf("%d RANGE_SYNC requests in %v, want BUSY replies waited out", m.calls[OpRangeSync], time.Since(start))
+	}
+}
+
+// 一直回复 BUSY 的 Messenger
+type busyRangeMessenger struct {
+	rangeMessenger
+}
+
+func (m busyRangeMessenger) RangeSync(ctx context.Context, c Contact, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	m.count(OpRangeSync)
+	return RangePage{}, &RPCError{Code: CodeBusy, RetryAfter: time.Millisecond}
+}
+
+// 连续 rangeSyncMaxBusy 次 BUSY 之后放弃
+func TestSyncRangeGivesUpWhenBusy(t *testing.T) {
+	p := NewPeer(KeyFromString("rangesync-self"))
+	m := busyRangeMessenger{rangeMessenger{from: p, to: p, calls: make(map[string]int)}}
+	p.SetMessenger(m)
+	c := Contact{ID: KeyFromString("rangesync-busy"), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4000}}
+	if _, err := p.SyncRange(context.Background(), c, ResponsibilityRange{}); !errors.Is(err, ErrBusy) {
+		t.Fatalf("SyncRange = %v, want ErrBusy", err)
+	}
+	if m.calls[OpRangeSync] != rangeSyncMaxBusy+1 {
+		t.Fatalf("%d RANGE_SYNC requests, want %d", m.calls[OpRangeSync], rangeSyncMaxBusy+1)
+	}
+}
+
+// RangeSyncRate 按节点分别限制：突发用完后拒绝并给出等待时间，不影响其他节点
+func TestRangeSyncRate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RangeSyncRate = 2
+	p, _ := NewPeerWithConfig(KeyFromString("rangesync-rate"), cfg)
+	a, b := KeyFromString("rangesync-a"), KeyFromString("rangesync-b")
+	for i := 0; i < 2; i++ {
+		if ok, _ := p.allowRangeSync(a); !ok {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	_, code, wait := p.serveRangeSync(a, ResponsibilityRange{}, [kbucket.IdSize]byte{}, 0)
+	if code != CodeBusy || wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("request over the rate = %v, wait %v, want BUSY with a wait up to 500ms", code, wait)
+	}
+	if _, code, _ := p.serveRangeSync(b, ResponsibilityRange{}, [kbucket.IdSize]byte{}, 0); code != CodeOK {
+		t.Fatalf("another peer's request = %v, want OK", code)
+	}
+
+	cfg.RangeSyncRate = -1
+	unlimited, _ := NewPeerWithConfig(KeyFromString("rangesync-unlimited"), cfg)
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.allowRangeSync(a); !ok {
+			t.Fatal("a negative RangeSyncRate limited requests")
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultQuotaBanThreshold = 100              // 连续超出配额多少次后临时封禁
+	DefaultQuotaBanDuration  = 10 * time.Minute // 临时封禁的时长
+
+	maxTrackedPeers = 4096 // 超过后清理令牌已经补满的节点
+)
+
+// 请求被拒绝的原因，用于 QuotaMetrics
+const (
+	RejectPeerRate   = "peer_rate"   // 超出单个节点的速率
+	RejectGlobalRate = "global_rate" // 超出全局速率
+	RejectTooBig     = "too_big"     // STORE 的值超过 MaxStoreSize
+	RejectSuspended  = "suspended"   // 节点因超出配额被临时封禁
+)
+
+// 可选的 Metrics 扩展，SetMetrics 设置的实现同时实现它时统计被拒绝的请求与临时封禁
+type QuotaMetrics interface {
+	RequestRejected(op, reason string)
+	PeerSuspended()
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 按 rate 补充令牌，最多 burst 个，有令牌时取走一个
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed*rate, float64(burst))
+	}
+	b.last = now
+}
+
+type peerQuota struct {
+	bucket  tokenBucket
+	strikes int // 令牌补满之前连续被拒绝的次数
+}
+
+// 网络传输层收到的请求的配额：每个远端节点一个令牌桶，另有一个全局令牌桶。
+// 被拒绝的次数达到 QuotaBanThreshold 的节点在 QuotaBanDuration 内的请求都被拒绝
+type rateLimiter struct {
+	mu        sync.Mutex
+	global    tokenBucket
+	peers     map[[kbucket.IdSize]byte]*peerQuota
+	suspended map[[kbucket.IdSize]byte]time.Time    // 临时封禁的截止时间
+	syncs     map[[kbucket.IdSize]byte]*tokenBucket // 每个节点请求 RANGE_SYNC 的频率
+}
+
+func burstOf(rate float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return max(int(rate), 1)
+}
+
+// 是否处理 id 发来的 op 请求，由网络传输层在处理请求之前调用
+func (p *Peer) allowRequest(id [kbucket.IdSize]byte, op string) bool {
+	reason, suspended := p.limiter.check(p.cfg, id, time.Now())
+	if reason == "" {
+		return true
+	}
+	if m, ok := p.metrics.(QuotaMetrics); ok {
+		m.RequestRejected(op, reason)
+		if suspended {
+			m.PeerSuspended()
+		}
+	}
+	return false
+}
+
+// 返回拒绝的原因，空表示允许；suspended 表示 id 因这次请求被临时封禁
+func (l *rateLimiter) check(cfg Config, id [kbucket.IdSize]byte, now time.Time) (reason string, suspended bool) {
+	if cfg.PeerRequestRate <= 0 && cfg.GlobalRequestRate <= 0 {
+		return "", false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until, ok := l.suspended[id]; ok {
+		if now.Before(until) {
+			return RejectSuspended, false
+		}
+		delete(l.suspended, id)
+	}
+	if cfg.PeerRequestRate > 0 {
+		burst := burstOf(cfg.PeerRequestRate, cfg.PeerRequestBurst)
+		if l.peers == nil {
+			l.peers = make(map[[kbucket.IdSize]byte]*peerQuota)
+		}
+		q, ok := l.peers[id]
+		if !ok {
+			if len(l.peers) >= maxTrackedPeers {
+				l.pruneLocked(cfg, now)
+			}
+			q = &peerQuota{}
+			l.peers[id] = q
+		}
+		if !q.bucket.take(now, cfg.PeerRequestRate, burst) {
+			q.strikes++
+			if cfg.QuotaBanThreshold > 0 && q.strikes >= cfg.QuotaBanThreshold {
+				if l.suspended == nil {
+					l.suspended = make(map[[kbucket.IdSize]byte]time.Time)
+				}
+				l.suspended[id] = now.Add(cfg.QuotaBanDuration)
+				delete(l.peers, id)
+				suspended = true
+			}
+			return RejectPeerRate, suspended
+		}
+		if q.bucket.tokens >= float64(burst-1) { // 请求之前令牌是满的，节点没有持续超出配额
+			q.strikes = 0
+		}
+	}
+	if cfg.GlobalRequestRate > 0 && !l.global.take(now, cfg.GlobalRequestRate, burstOf(cfg.GlobalRequestRate, cfg.GlobalRequestBurst)) {
+		return RejectGlobalRate, false
+	}
+	return "", false
+}
+
+// 删除令牌已经补满、没有被拒绝记录的节点，以及过期的临时封禁
+func (l *rateLimiter) pruneLocked(cfg Config, now time.Time) {
+	burst := burstOf(cfg.PeerRequestRate, cfg.PeerRequestBurst)
+	for id, q := range l.peers {
+		q.bucket.refill(now, cfg.PeerRequestRate, burst)
+		if q.bucket.tokens >= float64(burst) {
+			delete(l.peers, id)
+		}
+	}
+	for id, until := range l.suspended {
+		if !now.Before(until) {
+			delete(l.suspended, id)
+		}
+	}
+}
+
+// id 是否因超出请求配额处于临时封禁中。临时封禁只拒绝请求，不影响路由表
+func (p *Peer) IsSuspended(id [kbucket.IdSize]byte) bool {
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	until, ok := p.limiter.suspended[id]
+	return ok && time.Now().Before(until)
+}
+
+// 解除 id 的临时封禁并清空它的配额记录，返回之前是否处于封禁中
+func (p *Peer) Unsuspend(id [kbucket.IdSize]byte) bool {
+	p.limiter.mu.Lock()
+	defer p.limiter.mu.Unlock()
+	_, ok := p.limiter.suspended[id]
+	delete(p.limiter.suspended, id)
+	delete(p.limiter.peers, id)
+	return ok
+}
+
+// STORE 的值是否超过 MaxStoreSize
+func (p *Peer) storeTooBig(value []byte) bool {
+	if n := p.cfg.MaxStoreSize; n > 0 && len(value) > n {
+		if m, ok := p.metrics.(QuotaMetrics); ok {
+			m.RequestRejected(OpStore, RejectTooBig)
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 突发之内的请求都被允许，之后按速率补充令牌，补充不超过突发数
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	cfg := Config{PeerRequestRate: 10, PeerRequestBurst: 3, QuotaBanThreshold: -1}
+	var l rateLimiter
+	id := KeyFromString("ratelimit-a")
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if reason, _ := l.check(cfg, id, now); reason != "" {
+			t.Fatalf("request %d within the burst rejected: %s", i, reason)
+		}
+	}
+	if reason, _ := l.check(cfg, id, now); reason != RejectPeerRate {
+		t.Fatalf("request over the burst = %q, want %q", reason, RejectPeerRate)
+	}
+
+	now = now.Add(100 * time.Millisecond) // 补充一个令牌
+	if reason, _ := l.check(cfg, id, now); reason != "" {
+		t.Fatalf("request after one refill interval rejected: %s", reason)
+	}
+	if reason, _ := l.check(cfg, id, now); reason != RejectPeerRate {
+		t.Fatalf("second request after one refill interval = %q, want %q", reason, RejectPeerRate)
+	}
+
+	now = now.Add(time.Minute)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if reason, _ := l.check(cfg, id, now); reason == "" {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("%d requests allowed after a long idle period, want the burst of 3", allowed)
+	}
+}
+
+// 每个节点的令牌桶相互独立，全局令牌桶由所有节点共享
+func TestRateLimitPerPeer(t *testing.T) {
+	cfg := Config{PeerRequestRate: 1, PeerRequestBurst: 2, QuotaBanThreshold: -1}
+	var l rateLimiter
+	a, b := KeyFromString("ratelimit-a"), KeyFromString("ratelimit-b")
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		l.check(cfg, a, now)
+	}
+	for i := 0; i < 2; i++ {
+		if reason, _ := l.check(cfg, b, now); reason != "" {
+			t.Fatalf("request %d from another peer rejected: %s", i, reason)
+		}
+	}
+
+	cfg.GlobalRequestRate, cfg.GlobalRequestBurst = 1, 3
+	var g rateLimiter
+	for i, id := range [][kbucket.IdSize]byte{a, b, a} {
+		if reason, _ := g.check(cfg, id, now); reason != "" {
+			t.Fatalf("request %d within the global burst rejected: %s", i, reason)
+		}
+	}
+	if reason, _ := g.check(cfg, b, now); reason != RejectGlobalRate {
+		t.Fatalf("request over the global burst = %q, want %q", reason, RejectGlobalRate)
+	}
+}
+
+// 连续被拒绝 QuotaBanThreshold 次的节点被临时封禁，Unsuspend 或到期后恢复
+func TestRateLimitSuspends(t *testing.T) {
+	cfg := Config{PeerRequestRate: 1, PeerRequestBurst: 1, QuotaBanThreshold: 3, QuotaBanDuration: time.Minute}
+	p, err := NewPeerWithConfig(KeyFromString("ratelimit-self"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := KeyFromString("ratelimit-a")
+	exhaust := func() {
+		for i := 0; i < 4; i++ {
+			p.allowRequest(id, OpPing)
+		}
+	}
+	exhaust()
+	if !p.IsSuspended(id) || p.allowRequest(id, OpPing) {
+		t.Fatal("peer over its quota was not suspended")
+	}
+	if !p.Unsuspend(id) || p.IsSuspended(id) || !p.allowRequest(id, OpPing) {
+		t.Fatal("Unsuspend did not restore the peer")
+	}
+
+	exhaust()
+	if reason, _ := p.limiter.check(p.cfg, id, time.Now().Add(2*time.Minute)); reason != "" {
+		t.Fatalf("request after QuotaBanDuration = %q, want it allowed", reason)
+	}
+}
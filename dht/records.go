@@ -0,0 +1,401 @@
+package dht
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 记录的来源，用于排查放错位置或过时的数据
+type Provenance struct {
+	Publisher [kbucket.IdSize]byte // 发起写入的节点，未知时为零值
+	Signed    bool                 // Publisher 已由签名（进程内为密钥身份）确认
+	StoredBy  [kbucket.IdSize]byte // 把记录交给本节点的节点
+	Received  time.Time            // 本节点保存记录的时间
+	Hops      int                  // 经过的转交次数，0 表示由 StoredBy 直接写入
+}
+
+// 本地保存的键值对，可以在多个 goroutine 中同时访问。记录保存在 Storage 后端中，
+// 过期的记录在读取时视为不存在，由 expire 统一删除。后端出错时按记录不存在处理
+type recordStore struct {
+	mu      sync.RWMutex // 使读取-修改-写入的操作不与其他写入交错
+	backend Storage
+	ttl     func(key [kbucket.IdSize]byte, value []byte) time.Duration // 保存记录时的有效期，不大于 0 表示不过期
+	cache   *valueCache                                                // 读写都经过的 LRU 缓存，nil 表示不使用
+	clock   func() time.Time
+}
+
+func newRecordStore(ttl time.Duration, cacheSize int, backend Storage) *recordStore {
+	return &recordStore{
+		backend: backend,
+		ttl:     func([kbucket.IdSize]byte, []byte) time.Duration { return ttl },
+		cache:   newValueCache(cacheSize),
+		clock:   time.Now,
+	}
+}
+
+func (s *recordStore) newRecord(key [kbucket.IdSize]byte, value []byte, origin Provenance, now time.Time) StoredRecord {
+	origin.Received = now
+	r := StoredRecord{Key: key, Value: value, Published: now, Provenance: origin}
+	if ttl := s.ttl(key, value); ttl > 0 {
+		r.Expires = now.Add(ttl)
+	}
+	return r
+}
+
+func (r StoredRecord) live(now time.Time) bool {
+	return r.Expires.IsZero() || now.Before(r.Expires)
+}
+
+// 调用方需持有 s.mu
+func (s *recordStore) lookup(key [kbucket.IdSize]byte, now time.Time) (StoredRecord, bool) {
+	r, ok, err := s.backend.Get(key)
+	if err != nil || !ok || !r.live(now) {
+		return StoredRecord{}, false
+	}
+	return r, true
+}
+
+// 调用方需持有 s.mu 的写锁
+func (s *recordStore) write(r StoredRecord) error {
+	if err := s.backend.Put(r); err != nil {
+		return err
+	}
+	if s.cache != nil {
+		s.cache.add(r.Key, r.Value, r.Expires)
+	}
+	return nil
+}
+
+// 所有未过期的记录，调用方需持有 s.mu
+func (s *recordStore) live(now time.Time) []StoredRecord {
+	var records []StoredRecord
+	s.backend.Iterate(func(r StoredRecord) bool {
+		if r.live(now) {
+			records = append(records, r)
+		}
+		return true
+	})
+	return records
+}
+
+func (s *recordStore) get(key [kbucket.IdSize]byte) ([]byte, bool) {
+	now := s.clock()
+	if s.cache != nil {
+		if value, ok := s.cache.get(key, now); ok {
+			return value, true
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock() // 持有读锁填充缓存，避免与并发的写入或过期清理交错
+	r, ok := s.lookup(key, now)
+	if !ok {
+		return nil, false
+	}
+	if s.cache != nil {
+		s.cache.add(key, r.Value, r.Expires)
+	}
+	return r.Value, true
+}
+
+func (s *recordStore) has(key [kbucket.IdSize]byte) bool {
+	_, ok := s.get(key)
+	return ok
+}
+
+// 记录存在时延长其有效期（再次收到 STORE），返回记录是否存在
+func (s *recordStore) refresh(key [kbucket.IdSize]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	r, ok := s.lookup(key, now)
+	if !ok {
+		return false
+	}
+	if ttl := s.ttl(key, r.Value); ttl > 0 {
+		r.Expires = now.Add(ttl)
+		return s.write(r) == nil
+	}
+	return true
+}
+
+func (s *recordStore) put(key [kbucket.IdSize]byte, value []byte, origin Provenance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(s.newRecord(key, value, origin, s.clock()))
+}
+
+// 只在 key 不存在（或已过期）时保存，返回是否保存
+func (s *recordStore) putIfAbsent(key [kbucket.IdSize]byte, value []byte, origin Provenance) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if _, ok := s.lookup(key, now); ok {
+		return false
+	}
+	return s.write(s.newRecord(key, value, origin, now)) == nil
+}
+
+// 后端中的记录数，包括尚未删除的过期记录
+func (s *recordStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.backend.(storageLen); ok {
+		return b.Len()
+	}
+	n := 0
+	s.backend.Iterate(func(StoredRecord) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// 后端中的值的总字节数，包括尚未删除的过期记录
+func (s *recordStore) bytes() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.backend.(storageBytes); ok {
+		return b.Bytes()
+	}
+	n := 0
+	s.backend.Iterate(func(r StoredRecord) bool {
+		n += len(r.Value)
+		return true
+	})
+	return n
+}
+
+func (s *recordStore) keys() [][kbucket.IdSize]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := s.live(s.clock())
+	keys := make([][kbucket.IdSize]byte, len(records))
+	for i, r := range records {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+// 所有未过期记录的副本
+func (s *recordStore) all() map[[kbucket.IdSize]byte][]byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := s.live(s.clock())
+	m := make(map[[kbucket.IdSize]byte][]byte, len(records))
+	for _, r := range records {
+		m[r.Key] = r.Value
+	}
+	return m
+}
+
+// 删除所有过期记录，返回被删除的 key
+func (s *recordStore) expire(now time.Time) [][kbucket.IdSize]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired [][kbucket.IdSize]byte
+	s.backend.Iterate(func(r StoredRecord) bool {
+		if !r.live(now) {
+			expired = append(expired, r.Key)
+		}
+		return true
+	})
+	deleted := expired[:0]
+	for _, key := range expired {
+		if s.backend.Delete(key) != nil {
+			continue
+		}
+		if s.cache != nil {
+			s.cache.remove(key)
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted
+}
+
+// 删除 key 的记录，返回是否删除
+func (s *recordStore) remove(key [kbucket.IdSize]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.backend.Delete(key) != nil {
+		return false
+	}
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+	return true
+}
+
+// 返回距上次发布已超过 interval(key, value) 的记录，并把它们的发布时间记为 now。
+// interval 不大于 0 的记录不重新发布
+func (s *recordStore) duePublish(now time.Time, interval func(key [kbucket.IdSize]byte, value []byte) time.Duration) map[[kbucket.IdSize]byte][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := make(map[[kbucket.IdSize]byte][]byte)
+	for _, r := range s.live(now) {
+		if d := interval(r.Key, r.Value); d > 0 && r.Published.Before(now.Add(-d)) {
+			r.Published = now
+			if s.backend.Put(r) == nil {
+				due[r.Key] = r.Value
+			}
+		}
+	}
+	return due
+}
+
+// 所有未过期记录的副本，包括过期时间与来源
+func (s *recordStore) records() []StoredRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.live(s.clock())
+}
+
+// 按 key 从小到大遍历 key 不小于 from 的未过期记录，fn 返回 false 时停止。
+// 后端不能按顺序遍历时先取出全部记录再排序
+func (s *recordStore) ascend(from [kbucket.IdSize]byte, fn func(StoredRecord) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.clock()
+	if b, ok := s.backend.(storageRange); ok {
+		b.IterateFrom(from, func(r StoredRecord) bool {
+			return !r.live(now) || fn(r)
+		})
+		return
+	}
+	records := s.live(now)
+	sort.Slice(records, func(i, j int) bool { return bytes.Compare(records[i].Key[:], records[j].Key[:]) < 0 })
+	for _, r := range records {
+		if bytes.Compare(r.Key[:], from[:]) >= 0 && !fn(r) {
+			return
+		}
+	}
+}
+
+// key 的记录，包括已过期但尚未删除的记录，live 表示记录仍然有效
+func (s *recordStore) record(key [kbucket.IdSize]byte) (r StoredRecord, live, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok, err := s.backend.Get(key)
+	if err != nil || !ok {
+		return StoredRecord{}, false, false
+	}
+	return r, r.live(s.clock()), true
+}
+
+// key 对应记录的来源
+func (s *recordStore) provenance(key [kbucket.IdSize]byte) (Provenance, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.lookup(key, s.clock())
+	if !ok {
+		return Provenance{}, false
+	}
+	return r.Provenance, true
+}
+
+// 由 from 修复到其他副本时使用的来源：保留发布者，转交次数加一
+func (s *recordStore) repairOrigin(key, from [kbucket.IdSize]byte) Provenance {
+	origin, _ := s.provenance(key)
+	origin.StoredBy = from
+	origin.Hops++
+	return origin
+}
+
+// 按保存时的过期时间恢复一条记录，key 已存在时不覆盖
+func (s *recordStore) restore(key [kbucket.IdSize]byte, value []byte, expires time.Time, origin Provenance) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	if _, ok := s.lookup(key, now); ok {
+		return false
+	}
+	if origin.Received.IsZero() { // 快照恢复的记录保留原来的保存时间
+		origin.Received = now
+	}
+	r := StoredRecord{Key: key, Value: value, Expires: expires, Published: now, Provenance: origin}
+	return s.write(r) == nil
+}
+
+// 换用 backend 保存记录，已有的记录被复制过去。backend 实现 TxStorage 时在一个事务中复制
+func (s *recordStore) setBackend(backend Storage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ops []txOp
+	s.backend.Iterate(func(r StoredRecord) bool {
+		ops = append(ops, txOp{rec: r})
+		return true
+	})
+	if err := writeOps(backend, ops); err != nil {
+		return err
+	}
+	s.backend = backend
+	return nil
+}
+
+// 一起保存 ops 中的写入（来源为 origin）并删除其中的 key
+func (s *recordStore) commit(ops []txOp, origin Provenance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	records := make([]txOp, len(ops))
+	for i, op := range ops {
+		records[i] = op
+		if !op.delete {
+			records[i].rec = s.newRecord(op.rec.Key, op.rec.Value, origin, now)
+		}
+	}
+	return s.apply(records)
+}
+
+// 按顺序应用写入与删除，更新缓存。调用方需持有 s.mu 的写锁
+func (s *recordStore) apply(ops []txOp) error {
+	err := writeOps(s.backend, ops)
+	if s.cache != nil {
+		for _, op := range ops {
+			if op.delete || err != nil { // 出错时不确定哪些写入生效了
+				s.cache.remove(op.rec.Key)
+			} else {
+				s.cache.add(op.rec.Key, op.rec.Value, op.rec.Expires)
+			}
+		}
+	}
+	return err
+}
+
+// 后端实现 TxStorage 时把 ops 作为一个事务提交，否则逐条写入，出错时可能只完成了一部分
+func writeOps(backend Storage, ops []txOp) error {
+	tb, ok := backend.(TxStorage)
+	if !ok {
+		for _, op := range ops {
+			var err error
+			if op.delete {
+				err = backend.Delete(op.rec.Key)
+			} else {
+				err = backend.Put(op.rec)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tx, err := tb.Begin()
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.delete {
+			err = tx.Delete(op.rec.Key)
+		} else {
+			err = tx.Put(op.rec)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
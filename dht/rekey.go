@@ -0,0 +1,110 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+var ErrNoLegacyHasher = errors.New("dht: Config.LegacyHasher is not set")
+
+// MigrateKeys 的结果
+type KeyMigrationReport struct {
+	Legacy   int // 以旧哈希计算 key 的本地记录数
+	Migrated int // 以新 key 重新发布的记录数
+	Failed   int // 新 key 既没有保存在本地、也没有其他节点确认的记录数
+	Dropped  int // 兼容窗口结束后删除的旧记录数
+}
+
+// 新旧 key 的双向对应
+type keyAliases struct {
+	mu sync.Mutex
+	m  map[[kbucket.IdSize]byte][kbucket.IdSize]byte
+}
+
+func (a *keyAliases) add(oldKey, newKey [kbucket.IdSize]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.m == nil {
+		a.m = make(map[[kbucket.IdSize]byte][kbucket.IdSize]byte)
+	}
+	a.m[oldKey], a.m[newKey] = newKey, oldKey
+}
+
+func (a *keyAliases) get(key [kbucket.IdSize]byte) ([kbucket.IdSize]byte, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alt, ok := a.m[key]
+	return alt, ok
+}
+
+func (a *keyAliases) reset() {
+	a.mu.Lock()
+	a.m = nil
+	a.mu.Unlock()
+}
+
+// 是否处于 Config.LegacyHasher 的兼容窗口中
+func (p *Peer) legacyActive() bool {
+	return p.cfg.LegacyHasher != nil && (p.cfg.LegacyUntil.IsZero() || p.now().Before(p.cfg.LegacyUntil))
+}
+
+// 兼容窗口中 key 是否为 value 的旧哈希
+func (p *Peer) legacyKey(key [kbucket.IdSize]byte, value []byte) bool {
+	return p.legacyActive() && keyWith(p.cfg.LegacyHasher, value) == key
+}
+
+// 兼容窗口中 key 的另一个 key，GetValue 读不到 key 时改读它
+func (p *Peer) keyAlias(key [kbucket.IdSize]byte) ([kbucket.IdSize]byte, bool) {
+	if !p.legacyActive() {
+		return key, false
+	}
+	return p.aliases.get(key)
+}
+
+// 把本地以 Config.LegacyHasher 计算 key 的内容寻址记录以当前哈希函数的 key 重新发布，
+// 并记下新旧 key 的对应，使 GetValue 在兼容窗口中可以读到任一个 key。旧记录保留到
+// 窗口结束，之后的调用直接删除它们。每个节点都重新发布自己保存的记录，所以网络中
+// 每个节点各自迁移即可；设置了 LegacyHasher 时 RunJanitor 会定期调用。
+// 本地已有新 key 的记录不再重新发布，可以重复调用。其他 Validator 的记录不受影响
+func (p *Peer) MigrateKeys(ctx context.Context) (KeyMigrationReport, error) {
+	var r KeyMigrationReport
+	if p.cfg.LegacyHasher == nil {
+		return r, ErrNoLegacyHasher
+	}
+	active := p.legacyActive()
+	if !active {
+		p.aliases.reset()
+	}
+	for _, rec := range p.store.records() {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		newKey := KeyFromBytes(rec.Value)
+		if newKey == rec.Key || keyWith(p.cfg.LegacyHasher, rec.Value) != rec.Key {
+			continue
+		}
+		r.Legacy++
+		if active {
+			p.aliases.add(rec.Key, newKey)
+		}
+		if !p.store.has(newKey) {
+			stored := 0
+			if p.acceptValue(newKey, rec.Value, rec.Provenance) {
+				stored++
+			}
+			n, _ := p.replicate(ctx, newKey, rec.Value, NewTraceID())
+			if stored+n == 0 {
+				r.Failed++
+				continue // 保留旧记录，下次再试
+			}
+			r.Migrated++
+		}
+		if !active && p.store.remove(rec.Key) {
+			r.Dropped++
+		}
+	}
+	return r, nil
+}
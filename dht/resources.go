@@ -0,0 +1,191 @@
+package dht
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// 受 ResourceLimits 限制的资源
+const (
+	ResourceLookups    = "lookups"     // 进行中的迭代查找
+	ResourceMessages   = "messages"    // 处理中的收到的请求
+	ResourceGoroutines = "goroutines"  // 为收到的消息与异步发布启动的后台 goroutine
+	ResourceSockets    = "sockets"     // 打开的 socket
+	ResourceStoreBytes = "store_bytes" // 本地存储的值的总字节数
+)
+
+// 资源超出 ResourceLimits 时请求被拒绝的原因，用于 QuotaMetrics
+const RejectResources = "resources"
+
+// 节点可以占用的资源上限，嵌入在其他程序中的节点可以借此限制对宿主的消耗。
+// 超出上限的新工作以 BUSY（或 ErrBusy）拒绝，零值字段表示不限制
+type ResourceLimits struct {
+	Lookups    int // 同时进行的迭代查找数，超出时查找以 ErrBusy 结束
+	Messages   int // 同时处理的收到的请求数，超出时 gRPC 回复 BUSY，UDP 与超出配额一样丢弃请求；低优先级的请求只能占用一半
+	Goroutines int // 后台 goroutine 数，超出时不再把请求方加入路由表，SetValueAsync 以 ErrBusy 结束
+	Sockets    int // 打开的 socket 数，包括监听的 socket 与 gRPC 的入站、出站连接，超出时拒绝新连接
+	StoreBytes int // 本地存储的值的总字节数，超出时以 BUSY 拒绝新的 STORE
+}
+
+// 当前占用的资源，以及各资源因超出上限而拒绝的次数
+type ResourceUsage struct {
+	Lookups    int
+	Messages   int
+	Goroutines int
+	Sockets    int
+	StoreBytes int
+	Rejected   map[string]uint64 // 以 Resource* 为键
+}
+
+type resourceManager struct {
+	mu       sync.Mutex
+	used     map[string]int
+	rejected map[string]uint64
+}
+
+func (l ResourceLimits) of(kind string) int {
+	switch kind {
+	case ResourceLookups:
+		return l.Lookups
+	case ResourceMessages:
+		return l.Messages
+	case ResourceGoroutines:
+		return l.Goroutines
+	case ResourceSockets:
+		return l.Sockets
+	}
+	return 0
+}
+
+// 占用一份 kind 资源，已到上限时返回 false。成功后由 release 归还
+func (p *Peer) acquire(kind, op string) bool {
+	return p.acquireUpTo(kind, op, p.cfg.Resources.of(kind))
+}
+
+// 与 acquire 相同，上限为 limit，0 表示不限制
+func (p *Peer) acquireUpTo(kind, op string, limit int) bool {
+	r := &p.res
+	r.mu.Lock()
+	if r.used == nil {
+		r.used = make(map[string]int)
+	}
+	if limit > 0 && r.used[kind] >= limit {
+		r.reject(kind)
+		r.mu.Unlock()
+		p.resourceRejected(op)
+		return false
+	}
+	r.used[kind]++
+	r.mu.Unlock()
+	return true
+}
+
+func (p *Peer) release(kind string) {
+	p.res.mu.Lock()
+	p.res.used[kind]--
+	p.res.mu.Unlock()
+}
+
+func (r *resourceManager) reject(kind string) {
+	if r.rejected == nil {
+		r.rejected = make(map[string]uint64)
+	}
+	r.rejected[kind]++
+}
+
+func (p *Peer) resourceRejected(op string) {
+	if m, ok := p.metrics.(QuotaMetrics); ok {
+		m.RequestRejected(op, RejectResources)
+	}
+}
+
+// 在后台运行 fn，后台 goroutine 已到上限时不运行并返回 false
+func (p *Peer) spawn(op string, fn func()) bool {
+	if !p.acquire(ResourceGoroutines, op) {
+		return false
+	}
+	go func() {
+		defer p.release(ResourceGoroutines)
+		fn()
+	}()
+	return true
+}
+
+// 保存 size 字节的新值是否会超出 StoreBytes
+func (p *Peer) storeOverBudget(size int) bool {
+	limit := p.cfg.Resources.StoreBytes
+	if limit <= 0 || p.store.bytes()+size <= limit {
+		return false
+	}
+	p.res.mu.Lock()
+	p.res.reject(ResourceStoreBytes)
+	p.res.mu.Unlock()
+	p.resourceRejected(OpStore)
+	return true
+}
+
+// 当前的资源占用
+func (p *Peer) ResourceUsage() ResourceUsage {
+	u := ResourceUsage{StoreBytes: p.store.bytes(), Rejected: make(map[string]uint64)}
+	p.res.mu.Lock()
+	defer p.res.mu.Unlock()
+	u.Lookups = p.res.used[ResourceLookups]
+	u.Messages = p.res.used[ResourceMessages]
+	u.Goroutines = p.res.used[ResourceGoroutines]
+	u.Sockets = p.res.used[ResourceSockets]
+	for kind, n := range p.res.rejected {
+		u.Rejected[kind] = n
+	}
+	return u
+}
+
+// 占用 socket 配额的连接，关闭时归还
+type countedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func (p *Peer) countConn(conn net.Conn) net.Conn {
+	return &countedConn{Conn: conn, release: func() { p.release(ResourceSockets) }}
+}
+
+// 每个接受的连接占用一份 socket 配额，超出时立即关闭新连接
+type limitedListener struct {
+	net.Listener
+	p *Peer
+}
+
+func (l limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.p.acquire(ResourceSockets, "accept") {
+			return l.p.countConn(conn), nil
+		}
+		conn.Close()
+	}
+}
+
+// 出站连接占用 socket 配额，超出时返回 ErrBusy
+func (p *Peer) dialLimited(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !p.acquire(ResourceSockets, "dial") {
+			return nil, ErrBusy
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			p.release(ResourceSockets)
+			return nil, err
+		}
+		return p.countConn(conn), nil
+	}
+}
@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 本节点一定位于最近 K 个节点之中的 keyspace 区域：与自身 ID 共享至少 Bits 位前缀的 key
+type ResponsibilityRange struct {
+	Self [kbucket.IdSize]byte
+	Bits int // 0 表示整个 keyspace
+}
+
+func (r ResponsibilityRange) Contains(key [kbucket.IdSize]byte) bool {
+	return kbucket.CommonPrefixLen(r.Self, key) >= r.Bits
+}
+
+// 区域中最小的 key：Self 的前 Bits 位，其余位为 0
+func (r ResponsibilityRange) first() [kbucket.IdSize]byte {
+	var key [kbucket.IdSize]byte
+	for i := 0; i < r.Bits && i < kbucket.IdSize*8; i++ {
+		key[i/8] |= r.Self[i/8] & (0x80 >> (i % 8))
+	}
+	return key
+}
+
+// 负责区域的变化。Bits 变小说明区域扩大（邻居离开），变大说明区域缩小（更近的节点加入）
+type ResponsibilityEvent struct {
+	Old  ResponsibilityRange
+	New  ResponsibilityRange
+	Time time.Time
+}
+
+// 按当前路由表计算负责区域。设第 K 近的邻居与自身共享 c 位前缀，则与自身共享
+// 超过 c 位的 key 到自身的距离小于到该邻居及更远节点的距离，比自身更近的节点
+// 最多只有前 K-1 个邻居。邻居不足 K 个时负责整个 keyspace
+func (p *Peer) ResponsibleRange() ResponsibilityRange {
+	r := ResponsibilityRange{Self: p.node.ID}
+	neighbors := p.kb.FindClosestNodes(p.node.ID, p.cfg.K)
+	if len(neighbors) == p.cfg.K {
+		r.Bits = kbucket.CommonPrefixLen(p.node.ID, neighbors[len(neighbors)-1].ID) + 1
+	}
+	return r
+}
+
+// 订阅负责区域的变化，缓冲区满时丢弃事件
+func (p *Peer) SubscribeResponsibility(buffer int) <-chan ResponsibilityEvent {
+	ch := make(chan ResponsibilityEvent, buffer)
+	p.respSubs = append(p.respSubs, ch)
+	return ch
+}
+
+// 重新计算负责区域，变化时通知订阅者。由调用方在路由表变化后或周期性调用，
+// 返回区域是否变化
+func (p *Peer) ResponsibilityTick() bool {
+	r := p.ResponsibleRange()
+	if r == p.respRange {
+		return false
+	}
+	ev := ResponsibilityEvent{Old: p.respRange, New: r, Time: time.Now()}
+	p.respRange = r
+	for _, ch := range p.respSubs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const (
+	DefaultMinRTO = 200 * time.Millisecond // 默认的 Config.MinRTO
+	DefaultMaxRTO = 5 * time.Second        // 默认的 Config.MaxRTO
+
+	rtoGranularity = 10 * time.Millisecond // RTO 中方差项的下限，避免 RTT 稳定时超时贴着均值
+)
+
+// 按 RFC 6298 用一次 RTT 样本更新平滑 RTT、平均偏差与 RTO
+func (s *PeerStats) sampleRTO(rtt, lo, hi time.Duration) {
+	if s.SRTT == 0 {
+		s.SRTT, s.RTTVar = rtt, rtt/2
+	} else {
+		diff := s.SRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		s.RTTVar = (3*s.RTTVar + diff) / 4
+		s.SRTT = (7*s.SRTT + rtt) / 8
+	}
+	s.RTO = min(max(s.SRTT+max(rtoGranularity, 4*s.RTTVar), lo), hi)
+}
+
+// 节点 id 当前的 RTO，没有测量过 RTT 时返回 0，由传输层使用固定的 Timeout
+func (p *Peer) rto(id [kbucket.IdSize]byte) time.Duration {
+	if id == ([kbucket.IdSize]byte{}) {
+		return 0
+	}
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	if s := p.peerStats[id]; s != nil {
+		return s.RTO
+	}
+	return 0
+}
+
+// 对 id 的请求超时：RTO 加倍（不超过 MaxRTO），直到下一次成功的测量
+func (p *Peer) backoffRTO(id [kbucket.IdSize]byte) {
+	p.peerStatsMu.Lock()
+	defer p.peerStatsMu.Unlock()
+	if s := p.peerStats[id]; s != nil && s.RTO > 0 {
+		s.RTO = min(2*s.RTO, p.cfg.MaxRTO)
+	}
+}
@@ -0,0 +1,111 @@
+package dht
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// 信令的类型，对应 WebRTC 协商的各个步骤
+type SignalKind byte
+
+const (
+	SignalOffer     SignalKind = iota + 1 // SDP offer
+	SignalAnswer                          // SDP answer
+	SignalCandidate                       // 一个 ICE 候选
+	SignalBye                             // 关闭连接
+)
+
+// 经由中继收到的一条信令。信令的内容由应用（例如 WebRTC）解释，DHT 只负责转交
+type Signal struct {
+	From     [kbucket.IdSize]byte // 发出信令的节点
+	FromAddr *net.UDPAddr         // 中继联系发出方的地址，即网络中其他节点认识它的地址
+	Relay    *net.UDPAddr         // 转交信令的节点，回复经由它发送；直接收到时为发出方的地址
+	Kind     SignalKind
+	Data     []byte
+}
+
+// 设置收到信令时调用的函数，nil 表示不接受信令。f 在单独的 goroutine 中调用
+func (t *UDPTransport) SetSignalHandler(f func(Signal)) {
+	t.mu.Lock()
+	t.signal = f
+	t.mu.Unlock()
+}
+
+// 经由 relay 把信令交给 target；relay 就是 target 时直接交给它。
+// 中继不认识 target 时返回 ErrTooFar，直接交给的 target 不接受信令时返回 ErrUnsupported。
+// 中继在回复之后才转交，转交失败不会报告给发出方
+func (t *UDPTransport) Signal(relay *net.UDPAddr, target [kbucket.IdSize]byte, kind SignalKind, data []byte) error {
+	return t.Traced(NewTraceID()).Signal(relay, target, kind, data)
+}
+
+func (c *TracedTransport) Signal(relay *net.UDPAddr, target [kbucket.IdSize]byte, kind SignalKind, data []byte) error {
+	return c.signal(relay, udpwire.SignalMessage{Target: target, Kind: byte(kind), Data: data})
+}
+
+func (c *TracedTransport) signal(addr *net.UDPAddr, m udpwire.SignalMessage) error {
+	if len(m.Data) > 0xffff || headerSize+2*kbucket.IdSize+1+len(m.FromAddr)+1+2+len(m.Data)+sigSize > maxPacketSize {
+		return ErrTooBig
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	udpwire.AppendSignal(buf, m)
+	resp, err := c.call(addr, msgSignal, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.release()
+	if len(resp.payload) != 1 {
+		return ErrBadPacket
+	}
+	return ErrorFromCode(ErrorCode(resp.payload[0]), "")
+}
+
+// 处理一个 SIGNAL 请求，返回回复的错误码。发给其他节点的信令只转交一次：
+// 目标在路由表中且有地址时填入请求方的 ID 与地址后转发
+func (t *UDPTransport) serveSignal(req message, m udpwire.SignalMessage) ErrorCode {
+	if m.Target != t.p.node.ID {
+		if m.FromAddr != "" { // 已经转交过一次
+			return CodeTooFar
+		}
+		n, ok := t.p.kb.GetBucket(t.p.kb.BucketIndex(m.Target)).FindNode(m.Target)
+		addr, isAddr := n.Data.(*net.UDPAddr)
+		if !ok || !isAddr {
+			return CodeTooFar
+		}
+		m.From, m.FromAddr = req.sender, req.from.String()
+		trace := req.trace
+		t.p.spawn(OpSignal, func() { t.Traced(trace).signal(addr, m) })
+		return CodeOK
+	}
+	t.mu.Lock()
+	handler := t.signal
+	t.mu.Unlock()
+	if handler == nil {
+		return CodeUnsupported
+	}
+	s := Signal{From: req.sender, FromAddr: req.from, Relay: req.from, Kind: SignalKind(m.Kind), Data: m.Data}
+	if m.FromAddr != "" {
+		addr, err := net.ResolveUDPAddr("udp", m.FromAddr)
+		if err != nil {
+			return CodeBadToken
+		}
+		s.From, s.FromAddr = m.From, addr
+	}
+	t.p.spawn(OpSignal, func() { handler(s) })
+	return CodeOK
+}
+
+// 解析 SIGNAL 请求并把错误码写入 buf
+func (t *UDPTransport) handleSignal(req message, buf *bytes.Buffer) bool {
+	m, err := udpwire.ReadSignal(bytes.NewReader(req.payload))
+	if err != nil {
+		t.drop(req, DropMalformed)
+		return false
+	}
+	t.p.onRequest(req.trace, OpSignal, req.sender, m.Target)
+	buf.WriteByte(byte(t.serveSignal(req, m)))
+	return true
+}
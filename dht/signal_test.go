@@ -0,0 +1,66 @@
+package dht
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// 两个只发起连接的节点经由种子交换信令：种子填入发出方的 ID 与它认识发出方的地址，
+// 接收方经由同一个中继回复；目标未知或不接受信令时报告给发出方
+func TestSignalRelay(t *testing.T) {
+	seed := NewPeer(KeyFromString("signal-seed"))
+	ts, _ := listenWebSocketPeer(t, seed)
+	a, b := NewPeer(KeyFromString("signal-a")), NewPeer(KeyFromString("signal-b"))
+	var transports []*UDPTransport
+	for _, p := range []*Peer{a, b} {
+		tr, err := DialWebSocket(p, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.Close()
+		if err := p.Bootstrap([]Contact{{Addr: ts.Addr()}}); err != nil {
+			t.Fatalf("Bootstrap: %v", err)
+		}
+		transports = append(transports, tr)
+	}
+	ta, tb := transports[0], transports[1]
+
+	if err := ta.Signal(ts.Addr(), seed.ID(), SignalOffer, []byte("offer")); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Signal to a peer without a handler = %v, want ErrUnsupported", err)
+	}
+	got := make(chan Signal, 2)
+	tb.SetSignalHandler(func(s Signal) { got <- s })
+	ta.SetSignalHandler(func(s Signal) { got <- s })
+	if err := ta.Signal(ts.Addr(), b.ID(), SignalOffer, []byte("offer")); err != nil {
+		t.Fatalf("Signal: %v", err)
+	}
+	s := receiveSignal(t, got)
+	if s.From != a.ID() || s.Kind != SignalOffer || string(s.Data) != "offer" || s.Relay.String() != ts.Addr().String() {
+		t.Fatalf("signal = %x %v %q via %v, want an offer from a via the seed", s.From[:4], s.Kind, s.Data, s.Relay)
+	}
+	if c, ok := seed.kb.GetBucket(seed.kb.BucketIndex(a.ID())).FindNode(a.ID()); !ok || c.Data.(*net.UDPAddr).String() != s.FromAddr.String() {
+		t.Fatalf("FromAddr = %v, want the seed's address for a", s.FromAddr)
+	}
+	if err := tb.Signal(s.Relay, s.From, SignalAnswer, []byte("answer")); err != nil {
+		t.Fatalf("answer: %v", err)
+	}
+	if s := receiveSignal(t, got); s.From != b.ID() || s.Kind != SignalAnswer || string(s.Data) != "answer" {
+		t.Fatalf("answer = %x %v %q, want an answer from b", s.From[:4], s.Kind, s.Data)
+	}
+	if err := ta.Signal(ts.Addr(), KeyFromString("signal-unknown"), SignalOffer, nil); !errors.Is(err, ErrTooFar) {
+		t.Fatalf("Signal to an unknown peer = %v, want ErrTooFar", err)
+	}
+}
+
+func receiveSignal(t *testing.T, got <-chan Signal) Signal {
+	t.Helper()
+	select {
+	case s := <-got:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was not delivered")
+		return Signal{}
+	}
+}
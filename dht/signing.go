@@ -0,0 +1,104 @@
+package dht
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// 带签名的消息末尾附加 公钥(32) | 签名(64)，见 udpwire.Signed
+const (
+	msgSigned = udpwire.Signed
+	sigSize   = ed25519.PublicKeySize + ed25519.SignatureSize
+)
+
+var (
+	ErrBadSignature     = errors.New("dht: bad signature")
+	ErrIdentityMismatch = errors.New("dht: node ID does not match public key")
+)
+
+// 使用由 priv 的公钥导出的 ID 创建节点（S/Kademlia 的做法）。这样的节点对发出的
+// 每条消息签名，其他节点可以确认消息来自 ID 的持有者，无法随意冒用 ID
+func NewPeerWithIdentity(priv ed25519.PrivateKey, cfg Config) (*Peer, error) {
+	p, err := NewPeerWithConfig(NodeIDFromPublicKey(priv.Public().(ed25519.PublicKey)), cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.identity = priv
+	return p, nil
+}
+
+// 节点的公钥，没有密钥身份时返回 nil
+func (p *Peer) PublicKey() ed25519.PublicKey {
+	if p.identity == nil {
+		return nil
+	}
+	return p.identity.Public().(ed25519.PublicKey)
+}
+
+// 节点的 ID 是否由其公钥导出，进程内的节点以此代替消息签名
+func (p *Peer) verifiable() bool {
+	return p.identity != nil && NodeIDFromPublicKey(p.PublicKey()) == p.node.ID
+}
+
+// 签名 msg，返回 公钥 | 签名
+func (p *Peer) sign(msg []byte) []byte {
+	return append(append([]byte(nil), p.PublicKey()...), ed25519.Sign(p.identity, msg)...)
+}
+
+// 检查 sig（公钥 | 签名）是 id 的持有者对 msg 的签名
+func verifySender(id [kbucket.IdSize]byte, msg, sig []byte) error {
+	if len(sig) != sigSize {
+		return ErrBadSignature
+	}
+	pub := ed25519.PublicKey(sig[:ed25519.PublicKeySize])
+	if NodeIDFromPublicKey(pub) != id {
+		return ErrIdentityMismatch
+	}
+	if !ed25519.Verify(pub, msg, sig[ed25519.PublicKeySize:]) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// 有密钥身份时为 UDP 数据包附加签名
+func (p *Peer) signPacket(packet []byte) []byte {
+	if p.identity == nil {
+		return packet
+	}
+	packet[0] |= msgSigned
+	packet = append(packet, p.PublicKey()...)
+	return append(packet, ed25519.Sign(p.identity, packet)...)
+}
+
+// 检查带签名的数据包并去掉签名，返回去掉签名后的数据包以及发送方的公钥，没有签名时
+// 公钥为 nil。去掉签名时就地清除 packet 的签名标志，公钥引用 packet
+func openPacket(packet []byte) ([]byte, ed25519.PublicKey, error) {
+	if len(packet) == 0 || packet[0]&msgSigned == 0 {
+		return packet, nil, nil
+	}
+	if len(packet) < headerSize+sigSize {
+		return nil, nil, ErrBadPacket
+	}
+	body := packet[:len(packet)-ed25519.SignatureSize]
+	h, _, err := udpwire.ParseHeader(packet)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := verifySender(h.Sender, body, packet[len(packet)-sigSize:]); err != nil {
+		return nil, nil, err
+	}
+	opened := packet[:len(packet)-sigSize]
+	opened[0] &^= msgSigned
+	return opened, ed25519.PublicKey(packet[len(opened) : len(opened)+ed25519.PublicKeySize]), nil
+}
+
+// 按 RequireSignatures 检查进程内的双方能否通信
+func (p *Peer) accepts(other *Peer) bool {
+	if p.banned(other.node.ID, nil) {
+		return false
+	}
+	return !p.cfg.RequireSignatures || other.verifiable()
+}
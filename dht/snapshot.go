@@ -0,0 +1,279 @@
+package dht
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"github.com/WuQingyang2/K_Bucket/internal/zstd"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const snapshotVersion = 2
+
+var (
+	snapshotMagic       = [4]byte{'K', 'B', 'S', 'N'}
+	ErrSnapshotCorrupt  = errors.New("dht: snapshot corrupt")
+	ErrSnapshotMismatch = errors.New("dht: snapshot belongs to another node")
+)
+
+// 快照格式：magic(4) | 版本(1) | 明文 SHA-256(32) | Zstandard 压缩的明文（版本 1 为 gzip）。
+// 明文包含自身 ID、路由表中的节点及其网络地址，以及本地存储的所有记录连同有效期与来源
+func (p *Peer) WriteSnapshot(w io.Writer) error {
+	var payload bytes.Buffer
+	payload.Write(p.node.ID[:])
+	contacts := p.kb.AllNodes()
+	binary.Write(&payload, binary.BigEndian, uint32(len(contacts)))
+	for _, node := range contacts {
+		payload.Write(node.ID[:])
+		var addr string
+		if a, ok := node.Data.(*net.UDPAddr); ok {
+			addr = a.String()
+		}
+		payload.WriteByte(byte(len(addr)))
+		payload.WriteString(addr)
+	}
+	records := p.store.records()
+	binary.Write(&payload, binary.BigEndian, uint32(len(records)))
+	for _, rec := range records {
+		payload.Write(rec.Key[:])
+		binary.Write(&payload, binary.BigEndian, unixNano(rec.Expires))
+		payload.Write(rec.Provenance.Publisher[:])
+		if rec.Provenance.Signed {
+			payload.WriteByte(1)
+		} else {
+			payload.WriteByte(0)
+		}
+		payload.Write(rec.Provenance.StoredBy[:])
+		binary.Write(&payload, binary.BigEndian, unixNano(rec.Provenance.Received))
+		binary.Write(&payload, binary.BigEndian, uint32(rec.Provenance.Hops))
+		binary.Write(&payload, binary.BigEndian, uint32(len(rec.Value)))
+		payload.Write(rec.Value)
+	}
+	sum := sha256.Sum256(payload.Bytes())
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic[:])
+	bw.WriteByte(snapshotVersion)
+	bw.Write(sum[:])
+	bw.Write(zstd.Encode(nil, payload.Bytes()))
+	return bw.Flush()
+}
+
+// 读取快照并恢复路由表与存储。resolve 把节点 ID 转换为联系方式（Node.data），
+// 返回 nil 时使用快照中保存的网络地址，没有地址的节点被跳过；resolve 为 nil 时只恢复存储
+func (p *Peer) ReadSnapshot(r io.Reader, resolve func(id [kbucket.IdSize]byte) interface{}) error {
+	var header [4 + 1 + sha256.Size]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return ErrSnapshotCorrupt
+	}
+	if !bytes.Equal(header[:4], snapshotMagic[:]) {
+		return ErrSnapshotCorrupt
+	}
+	var payload []byte
+	if header[4] == 1 { // 版本 1 使用 gzip 压缩
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return ErrSnapshotCorrupt
+		}
+		if payload, err = io.ReadAll(zr); err != nil {
+			return ErrSnapshotCorrupt
+		}
+	} else {
+		compressed, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if payload, err = zstd.Decode(compressed); err != nil {
+			return ErrSnapshotCorrupt
+		}
+	}
+	if sha256.Sum256(payload) != [sha256.Size]byte(header[5:]) {
+		return ErrSnapshotCorrupt
+	}
+	payload, err := migrate(ArtifactSnapshot, header[4], payload) // 旧版本快照升级到当前格式
+	if err != nil {
+		return err
+	}
+	return p.restoreSnapshot(bytes.NewReader(payload), resolve)
+}
+
+func (p *Peer) restoreSnapshot(r *bytes.Reader, resolve func(id [kbucket.IdSize]byte) interface{}) error {
+	var self [kbucket.IdSize]byte
+	if _, err := io.ReadFull(r, self[:]); err != nil {
+		return ErrSnapshotCorrupt
+	}
+	if self != p.node.ID {
+		return ErrSnapshotMismatch
+	}
+	var n uint32
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return ErrSnapshotCorrupt
+	}
+	contacts := make([]kbucket.Node, 0, n)
+	for i := uint32(0); i < n; i++ {
+		var id [kbucket.IdSize]byte
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return ErrSnapshotCorrupt
+		}
+		addr, err := readSnapshotString(r)
+		if err != nil {
+			return err
+		}
+		if resolve == nil {
+			continue
+		}
+		if data := resolve(id); data != nil {
+			contacts = append(contacts, kbucket.Node{ID: id, Data: data})
+		} else if udpAddr, err := net.ResolveUDPAddr("udp", addr); addr != "" && err == nil {
+			contacts = append(contacts, kbucket.Node{ID: id, Data: udpAddr})
+		}
+	}
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return ErrSnapshotCorrupt
+	}
+	records := make([]StoredRecord, 0, min(n, uint32(r.Len())))
+	for i := uint32(0); i < n; i++ {
+		var rec StoredRecord
+		var expires, received uint64
+		var signed byte
+		var hops, size uint32
+		if _, err := io.ReadFull(r, rec.Key[:]); err != nil {
+			return ErrSnapshotCorrupt
+		}
+		if binary.Read(r, binary.BigEndian, &expires) != nil {
+			return ErrSnapshotCorrupt
+		}
+		if _, err := io.ReadFull(r, rec.Provenance.Publisher[:]); err != nil {
+			return ErrSnapshotCorrupt
+		}
+		if binary.Read(r, binary.BigEndian, &signed) != nil {
+			return ErrSnapshotCorrupt
+		}
+		if _, err := io.ReadFull(r, rec.Provenance.StoredBy[:]); err != nil {
+			return ErrSnapshotCorrupt
+		}
+		if binary.Read(r, binary.BigEndian, &received) != nil || binary.Read(r, binary.BigEndian, &hops) != nil {
+			return ErrSnapshotCorrupt
+		}
+		if binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+			return ErrSnapshotCorrupt
+		}
+		rec.Value = make([]byte, size)
+		io.ReadFull(r, rec.Value)
+		rec.Expires = fromUnixNano(expires)
+		rec.Provenance.Signed = signed != 0
+		rec.Provenance.Received = fromUnixNano(received)
+		rec.Provenance.Hops = int(hops)
+		records = append(records, rec)
+	}
+	for _, node := range contacts { // 全部解析成功后才修改状态
+		p.kb.InsertNode(node)
+	}
+	now := p.now()
+	for _, rec := range records {
+		// 与 LoadStore 一样跳过无法通过检查或已经过期的记录
+		if p.validate(rec.Key, rec.Value) != nil || !rec.live(now) {
+			continue
+		}
+		if rec.Expires.IsZero() { // 旧版本快照没有保存有效期，按当前的配置计算
+			rec.Expires = p.store.newRecord(rec.Key, rec.Value, rec.Provenance, now).Expires
+		}
+		if p.store.restore(rec.Key, rec.Value, rec.Expires, rec.Provenance) {
+			p.emitStore(ValueStored, rec.Key)
+		}
+	}
+	return nil
+}
+
+func readSnapshotString(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil || int(n) > r.Len() {
+		return "", ErrSnapshotCorrupt
+	}
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return string(b), nil
+}
+
+// 版本 1 的明文：联系人只有 ID，记录只有 key 与值。升级后联系人没有地址，
+// 记录没有有效期与来源
+func migrateSnapshotV1(payload []byte) ([]byte, error) {
+	r := bytes.NewReader(payload)
+	var out bytes.Buffer
+	var self [kbucket.IdSize]byte
+	var n uint32
+	if _, err := io.ReadFull(r, self[:]); err != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+	out.Write(self[:])
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+	binary.Write(&out, binary.BigEndian, n)
+	for i := uint32(0); i < n; i++ {
+		var id [kbucket.IdSize]byte
+		if _, err := io.ReadFull(r, id[:]); err != nil {
+			return nil, ErrSnapshotCorrupt
+		}
+		out.Write(id[:])
+		out.WriteByte(0)
+	}
+	if binary.Read(r, binary.BigEndian, &n) != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+	binary.Write(&out, binary.BigEndian, n)
+	for i := uint32(0); i < n; i++ {
+		var key [kbucket.IdSize]byte
+		var size uint32
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, ErrSnapshotCorrupt
+		}
+		if binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+			return nil, ErrSnapshotCorrupt
+		}
+		out.Write(key[:])
+		out.Write(make([]byte, 8+kbucket.IdSize+1+kbucket.IdSize+8+4)) // 有效期与来源都为零值
+		binary.Write(&out, binary.BigEndian, size)
+		io.CopyN(&out, r, int64(size))
+	}
+	return out.Bytes(), nil
+}
+
+// 原子地写入快照文件：先写临时文件再重命名，写入中途失败不会破坏已有快照
+func (p *Peer) SaveSnapshot(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := p.WriteSnapshot(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (p *Peer) LoadSnapshot(path string, resolve func(id [kbucket.IdSize]byte) interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.ReadSnapshot(bufio.NewReader(f), resolve)
+}
@@ -0,0 +1,189 @@
+package dht
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/internal/zstd"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 保存了 n 条记录、路由表中有 peers 的节点
+func snapshotPeer(t *testing.T, id [kbucket.IdSize]byte, peers []*Peer, n int) *Peer {
+	t.Helper()
+	p := NewPeer(id)
+	for _, q := range peers {
+		p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+	}
+	for i := 0; i < n; i++ {
+		value := []byte(fmt.Sprintf("snapshot-%d", i))
+		key := KeyFromBytes(value)
+		p.store.put(key, value, Provenance{})
+	}
+	return p
+}
+
+// 按 ID 解析 peers 中的节点
+func resolvePeers(peers []*Peer) func([kbucket.IdSize]byte) interface{} {
+	return func(id [kbucket.IdSize]byte) interface{} {
+		for _, q := range peers {
+			if q.node.ID == id {
+				return q
+			}
+		}
+		return nil
+	}
+}
+
+// 快照写入文件后由同一 ID 的新节点读回，路由表与存储都恢复
+func TestSnapshotRoundTrip(t *testing.T) {
+	peers := newTestNetwork(4, 6)
+	id := KeyFromString("snapshot-self")
+	p := snapshotPeer(t, id, peers, 10)
+	path := filepath.Join(t.TempDir(), "snapshot")
+	if err := p.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewPeer(id)
+	if err := restored.LoadSnapshot(path, resolvePeers(peers)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.kb.Size(), p.kb.Size(); got != want {
+		t.Fatalf("restored routing table has %d nodes, want %d", got, want)
+	}
+	for key, value := range p.store.all() {
+		if got, err := restored.GetValue(context.Background(), key); !bytes.Equal(got, value) {
+			t.Fatalf("restored GetValue(%x) = %q, %v, want %q", key[:4], got, err, value)
+		}
+	}
+	storeOnly := NewPeer(id)
+	if err := storeOnly.LoadSnapshot(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if storeOnly.kb.Size() != 0 || storeOnly.store.len() != 10 {
+		t.Fatalf("nil resolve restored %d nodes and %d records", storeOnly.kb.Size(), storeOnly.store.len())
+	}
+}
+
+// 损坏或属于其他节点的快照被拒绝，且不修改节点的状态
+func TestSnapshotRejectsCorruption(t *testing.T) {
+	peers := newTestNetwork(4, 7)
+	id := KeyFromString("snapshot-corrupt")
+	var buf bytes.Buffer
+	if err := snapshotPeer(t, id, peers, 5).WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for name, corrupt := range map[string][]byte{
+		"checksum":  flipByte(data, 10),
+		"payload":   flipByte(data, len(data)-12),
+		"truncated": data[:len(data)/2],
+		"magic":     flipByte(data, 0),
+	} {
+		p := NewPeer(id)
+		if err := p.ReadSnapshot(bytes.NewReader(corrupt), resolvePeers(peers)); !errors.Is(err, ErrSnapshotCorrupt) {
+			t.Fatalf("%s: ReadSnapshot = %v, want ErrSnapshotCorrupt", name, err)
+		}
+		if p.kb.Size() != 0 || p.store.len() != 0 {
+			t.Fatalf("%s: corrupt snapshot restored %d nodes and %d records", name, p.kb.Size(), p.store.len())
+		}
+	}
+	other := NewPeer(KeyFromString("snapshot-other"))
+	if err := other.ReadSnapshot(bytes.NewReader(data), resolvePeers(peers)); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Fatalf("ReadSnapshot of another node's snapshot = %v, want ErrSnapshotMismatch", err)
+	}
+}
+
+// data 的副本，第 i 个字节取反
+func flipByte(data []byte, i int) []byte {
+	out := append([]byte(nil), data...)
+	out[i] ^= 0xff
+	return out
+}
+
+// 快照保存网络联系人的地址与记录的有效期和来源，恢复后与原来一致；内容经过 Zstandard 压缩
+func TestSnapshotPreservesMetadata(t *testing.T) {
+	id := KeyFromString("snapshot-metadata")
+	p := NewPeer(id)
+	remote := KeyFromString("snapshot-remote")
+	p.kb.InsertNode(kbucket.Node{ID: remote, Data: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 4100}})
+	publisher, storedBy := KeyFromString("snapshot-publisher"), KeyFromString("snapshot-stored-by")
+	value := bytes.Repeat([]byte("snapshot-metadata "), 200)
+	key := KeyFromBytes(value)
+	expires := time.Now().Add(42 * time.Minute).Round(0)
+	received := time.Now().Add(-time.Hour).Round(0)
+	p.store.restore(key, value, expires, Provenance{Publisher: publisher, Signed: true, StoredBy: storedBy, Received: received, Hops: 2})
+
+	var buf bytes.Buffer
+	if err := p.WriteSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= len(value) {
+		t.Fatalf("snapshot of a %d byte repetitive value is %d bytes", len(value), buf.Len())
+	}
+	if _, err := zstd.Decode(buf.Bytes()[4+1+sha256.Size:]); err != nil {
+		t.Fatalf("snapshot body is not a zstd frame: %v", err)
+	}
+
+	restored := NewPeer(id)
+	if err := restored.ReadSnapshot(bytes.NewReader(buf.Bytes()), func([kbucket.IdSize]byte) interface{} { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	node, ok := restored.kb.GetBucket(restored.kb.BucketIndex(remote)).FindNode(remote)
+	if addr, _ := node.Data.(*net.UDPAddr); !ok || addr == nil || addr.String() != "10.0.0.7:4100" {
+		t.Fatalf("restored contact = %v, %v, want 10.0.0.7:4100", node.Data, ok)
+	}
+	recs := restored.store.records()
+	if len(recs) != 1 {
+		t.Fatalf("restored %d records, want 1", len(recs))
+	}
+	rec := recs[0]
+	want := Provenance{Publisher: publisher, Signed: true, StoredBy: storedBy, Received: received, Hops: 2}
+	if !rec.Expires.Equal(expires) || !bytes.Equal(rec.Value, value) || rec.Provenance.Publisher != want.Publisher ||
+		!rec.Provenance.Signed || rec.Provenance.StoredBy != want.StoredBy || !rec.Provenance.Received.Equal(received) || rec.Provenance.Hops != 2 {
+		t.Fatalf("restored record expires %v with %+v, want %v with %+v", rec.Expires, rec.Provenance, expires, want)
+	}
+}
+
+// 版本 1 的 gzip 快照仍然可以读取，记录按当前配置重新计算有效期
+func TestSnapshotReadsVersion1(t *testing.T) {
+	id := KeyFromString("snapshot-v1")
+	value := []byte("snapshot-v1-value")
+	key := KeyFromBytes(value)
+	var payload bytes.Buffer
+	payload.Write(id[:])
+	binary.Write(&payload, binary.BigEndian, uint32(0))
+	binary.Write(&payload, binary.BigEndian, uint32(1))
+	payload.Write(key[:])
+	binary.Write(&payload, binary.BigEndian, uint32(len(value)))
+	payload.Write(value)
+	sum := sha256.Sum256(payload.Bytes())
+	var buf bytes.Buffer
+	buf.Write(snapshotMagic[:])
+	buf.WriteByte(1)
+	buf.Write(sum[:])
+	zw := gzip.NewWriter(&buf)
+	zw.Write(payload.Bytes())
+	zw.Close()
+
+	p := NewPeer(id)
+	if err := p.ReadSnapshot(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	recs := p.store.records()
+	if len(recs) != 1 || !bytes.Equal(recs[0].Value, value) {
+		t.Fatalf("restored %d records from a version 1 snapshot", len(recs))
+	}
+	if got := time.Until(recs[0].Expires); got < p.cfg.RecordTTL-time.Minute || got > p.cfg.RecordTTL {
+		t.Fatalf("version 1 record expires in %v, want about %v", got, p.cfg.RecordTTL)
+	}
+}
@@ -0,0 +1,86 @@
+package dht
+
+import "github.com/WuQingyang2/K_Bucket/kbucket"
+
+// 静态成员模式：成员集合由配置给出，不做节点发现，查找只在成员之间进行，
+// 适用于小规模可信集群直接复用键值复制逻辑
+func NewStaticCluster(ids [][kbucket.IdSize]byte) []*Peer {
+	peers := make([]*Peer, len(ids))
+	for i, id := range ids {
+		peers[i] = NewPeer(id)
+	}
+	for _, p := range peers {
+		p.SetStaticMembers(peers)
+	}
+	return peers
+}
+
+// 设置固定成员并进入静态模式，路由表只保留成员节点
+func (p *Peer) SetStaticMembers(members []*Peer) {
+	p.static = true
+	p.members = p.members[:0]
+	for _, node := range p.kb.AllNodes() {
+		p.kb.RemoveNode(node.ID)
+	}
+	for _, m := range members {
+		if m == nil || m.node.ID == p.node.ID {
+			continue
+		}
+		p.members = append(p.members, m)
+		p.kb.InsertNode(kbucket.Node{ID: m.node.ID, Data: m})
+	}
+}
+
+func (p *Peer) IsStatic() bool {
+	return p.static
+}
+
+// 按照与 key 的距离（见 Config.Metric）返回最近的 n 个成员
+func (p *Peer) staticClosest(key [kbucket.IdSize]byte, n int) []*Peer {
+	closest := make([]*Peer, 0, n+1)
+	for _, m := range p.members {
+		i := len(closest)
+		for i > 0 && p.closer(m.node.ID, closest[i-1].node.ID, key) {
+			i--
+		}
+		if i >= n {
+			continue
+		}
+		closest = append(closest, nil)
+		copy(closest[i+1:], closest[i:])
+		closest[i] = m
+		if len(closest) > n {
+			closest = closest[:n]
+		}
+	}
+	return closest
+}
+
+func (p *Peer) staticSetValue(hash [kbucket.IdSize]byte, value []byte) int {
+	stored := 0
+	for _, m := range p.staticClosest(hash, len(p.members)) {
+		if stored >= p.cfg.K {
+			break
+		}
+		if !m.store.has(hash) {
+			if m.storeFullFor(value) {
+				continue
+			}
+			m.forgetMiss(hash)
+			if m.store.putIfAbsent(hash, value, p.ownOrigin()) {
+				m.emitStore(ValueStored, hash)
+			}
+		}
+		stored++
+	}
+	return stored
+}
+
+func (p *Peer) staticGetValue(key [kbucket.IdSize]byte) []byte {
+	for _, m := range p.staticClosest(key, p.cfg.K) {
+		if value, ok := m.store.get(key); ok {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,341 @@
+package dht
+
+import (
+	"bytes"
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 本地记录的存储后端，TTL、缓存与重新发布由 Peer 在其之上实现。
+// 实现需要可以在多个 goroutine 中同时调用；后端不需要处理过期，
+// 过期的记录由 Peer 读取时忽略并在 ExpireRecords 中删除
+type Storage interface {
+	Get(key [kbucket.IdSize]byte) (StoredRecord, bool, error)
+	Put(rec StoredRecord) error
+	Delete(key [kbucket.IdSize]byte) error
+	// 遍历所有记录，顺序不限，fn 返回 false 时停止。fn 中不能写同一个后端
+	Iterate(fn func(StoredRecord) bool) error
+}
+
+// 可以把多条记录的写入与删除作为一个整体提交的后端。提交之前的写入对 Get 与 Iterate
+// 不可见，提交之后即使进程崩溃也不会只留下其中的一部分。分块保存的值、墓碑与新值等
+// 多条记录的写入使用事务（见 Peer.StoreLocal）；后端没有实现时逐条写入
+type TxStorage interface {
+	Storage
+	Begin() (StorageTx, error)
+}
+
+// 一个尚未提交的事务，按调用顺序记下写入与删除。不能在多个 goroutine 中同时使用
+type StorageTx interface {
+	Put(rec StoredRecord) error
+	Delete(key [kbucket.IdSize]byte) error
+	Commit() error   // 使所有写入与删除一起生效
+	Rollback() error // 放弃事务，Commit 之后调用没有作用
+}
+
+// 事务中的一个操作
+type txOp struct {
+	rec    StoredRecord // 删除时只使用 Key
+	delete bool
+}
+
+// 后端可以直接给出记录数时实现，否则通过 Iterate 统计
+type storageLen interface {
+	Len() int
+}
+
+// 后端可以直接给出值的总字节数时实现，否则通过 Iterate 统计。
+// 设置了 Resources.StoreBytes 时每个 STORE 都要检查总字节数，后端应当维护一个计数器
+type storageBytes interface {
+	Bytes() int
+}
+
+// 后端可以按 key 从小到大、从给定的 key 开始遍历时实现。RANGE_SYNC 按页读取记录时使用，
+// 否则每一页都要遍历并排序全部记录
+type storageRange interface {
+	// 遍历 key 不小于 from 的记录，fn 返回 false 时停止。fn 中不能写同一个后端
+	IterateFrom(from [kbucket.IdSize]byte, fn func(StoredRecord) bool) error
+}
+
+// 按顺序遍历时每次在锁内取出的 key 数
+const rangeBatch = 64
+
+// 从小到大排列的 key，供后端实现 IterateFrom。调用方负责加锁
+type keyIndex [][kbucket.IdSize]byte
+
+// 第一个不小于 key 的位置
+func (x keyIndex) search(key [kbucket.IdSize]byte) int {
+	return sort.Search(len(x), func(i int) bool { return bytes.Compare(x[i][:], key[:]) >= 0 })
+}
+
+func (x *keyIndex) add(key [kbucket.IdSize]byte) {
+	i := x.search(key)
+	if i < len(*x) && (*x)[i] == key {
+		return
+	}
+	*x = append(*x, key)
+	copy((*x)[i+1:], (*x)[i:])
+	(*x)[i] = key
+}
+
+func (x *keyIndex) remove(key [kbucket.IdSize]byte) {
+	if i := x.search(key); i < len(*x) && (*x)[i] == key {
+		*x = append((*x)[:i], (*x)[i+1:]...)
+	}
+}
+
+// 从 from 开始至多 rangeBatch 个 key
+func (x keyIndex) batch(from [kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+	i := x.search(from)
+	return append([][kbucket.IdSize]byte(nil), x[i:min(i+rangeBatch, len(x))]...)
+}
+
+// 每次取出一批 key 后在锁外逐条读取，不在整个遍历期间持有后端的锁
+func iterateFrom(from [kbucket.IdSize]byte, batch func(from [kbucket.IdSize]byte) [][kbucket.IdSize]byte, get func(key [kbucket.IdSize]byte) (StoredRecord, bool, error), fn func(StoredRecord) bool) error {
+	for {
+		keys := batch(from)
+		for _, key := range keys {
+			rec, ok, err := get(key)
+			if err != nil {
+				return err
+			}
+			if ok && !fn(rec) {
+				return nil
+			}
+		}
+		if len(keys) < rangeBatch {
+			return nil
+		}
+		var ok bool
+		if from, ok = nextKey(keys[len(keys)-1]); !ok {
+			return nil
+		}
+	}
+}
+
+// 按字节序紧接在 key 之后的 key，key 已是最大值时返回 false
+func nextKey(key [kbucket.IdSize]byte) ([kbucket.IdSize]byte, bool) {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0 {
+			return key, true
+		}
+	}
+	return key, false
+}
+
+// 设置保存本地记录的后端，已有的记录被复制过去。复制失败时保留原来的后端。
+// 同一个后端不能同时交给多个节点
+func (p *Peer) SetStorage(s Storage) error {
+	if m, ok := s.(*MemoryStorage); ok {
+		m.setEvict(p.evicted)
+	}
+	return p.store.setBackend(s)
+}
+
+// 后端因容量限制淘汰了 key
+func (p *Peer) evicted(key [kbucket.IdSize]byte) {
+	if p.store.cache != nil {
+		p.store.cache.remove(key)
+	}
+	p.dropSiblings(key)
+	p.emitStore(ValueEvicted, key)
+}
+
+// 内存中的存储后端，也是节点默认使用的后端。设置了容量时按 LRU 淘汰：
+// 写入后记录数或值的总字节数超出限制，就从最久未读写的记录开始删除
+type MemoryStorage struct {
+	mu         sync.Mutex
+	ll         *list.List // 最近读写的在前，元素为 *StoredRecord
+	items      map[[kbucket.IdSize]byte]*list.Element
+	sorted     keyIndex
+	bytes      int
+	maxRecords int
+	maxBytes   int
+	onEvict    func(key [kbucket.IdSize]byte)
+}
+
+// maxRecords 与 maxBytes 为 0 表示不限制
+func NewMemoryStorage(maxRecords, maxBytes int) *MemoryStorage {
+	return &MemoryStorage{
+		ll:         list.New(),
+		items:      make(map[[kbucket.IdSize]byte]*list.Element),
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+	}
+}
+
+func (s *MemoryStorage) setEvict(fn func(key [kbucket.IdSize]byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = fn
+}
+
+func (s *MemoryStorage) Get(key [kbucket.IdSize]byte) (StoredRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return StoredRecord{}, false, nil
+	}
+	s.ll.MoveToFront(el)
+	return *el.Value.(*StoredRecord), true, nil
+}
+
+// 单个值超过 maxBytes 时返回 ErrTooBig
+func (s *MemoryStorage) Put(rec StoredRecord) error {
+	if s.maxBytes > 0 && len(rec.Value) > s.maxBytes {
+		return ErrTooBig
+	}
+	s.mu.Lock()
+	s.putLocked(rec)
+	s.unlockAndEvict()
+	return nil
+}
+
+// 调用方需持有 s.mu
+func (s *MemoryStorage) putLocked(rec StoredRecord) {
+	if el, ok := s.items[rec.Key]; ok {
+		s.bytes += len(rec.Value) - len(el.Value.(*StoredRecord).Value)
+		el.Value = &rec
+		s.ll.MoveToFront(el)
+	} else {
+		s.items[rec.Key] = s.ll.PushFront(&rec)
+		s.sorted.add(rec.Key)
+		s.bytes += len(rec.Value)
+	}
+}
+
+// 按容量淘汰记录后释放 s.mu，再通知被淘汰的 key
+func (s *MemoryStorage) unlockAndEvict() {
+	var evicted [][kbucket.IdSize]byte
+	for s.over() {
+		old := s.ll.Back().Value.(*StoredRecord)
+		s.remove(old.Key)
+		evicted = append(evicted, old.Key)
+	}
+	onEvict := s.onEvict
+	s.mu.Unlock()
+	if onEvict != nil {
+		for _, key := range evicted {
+			onEvict(key)
+		}
+	}
+}
+
+// 调用方需持有 s.mu。刚写入的记录总在最前，不会被自己淘汰
+func (s *MemoryStorage) over() bool {
+	if s.ll.Len() <= 1 {
+		return false
+	}
+	return (s.maxRecords > 0 && s.ll.Len() > s.maxRecords) || (s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+func (s *MemoryStorage) Delete(key [kbucket.IdSize]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	return nil
+}
+
+// 调用方需持有 s.mu
+func (s *MemoryStorage) remove(key [kbucket.IdSize]byte) {
+	if el, ok := s.items[key]; ok {
+		s.bytes -= len(el.Value.(*StoredRecord).Value)
+		s.ll.Remove(el)
+		delete(s.items, key)
+		s.sorted.remove(key)
+	}
+}
+
+// 遍历调用时的副本，不改变 LRU 顺序
+func (s *MemoryStorage) Iterate(fn func(StoredRecord) bool) error {
+	s.mu.Lock()
+	records := make([]StoredRecord, 0, s.ll.Len())
+	for el := s.ll.Front(); el != nil; el = el.Next() {
+		records = append(records, *el.Value.(*StoredRecord))
+	}
+	s.mu.Unlock()
+	for _, rec := range records {
+		if !fn(rec) {
+			break
+		}
+	}
+	return nil
+}
+
+// 按 key 从小到大遍历，不改变 LRU 顺序
+func (s *MemoryStorage) IterateFrom(from [kbucket.IdSize]byte, fn func(StoredRecord) bool) error {
+	return iterateFrom(from, func(from [kbucket.IdSize]byte) [][kbucket.IdSize]byte {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.sorted.batch(from)
+	}, func(key [kbucket.IdSize]byte) (StoredRecord, bool, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		el, ok := s.items[key]
+		if !ok {
+			return StoredRecord{}, false, nil
+		}
+		return *el.Value.(*StoredRecord), true, nil
+	}, fn)
+}
+
+// 事务的写入在 Commit 时一起应用，之后才按容量淘汰
+func (s *MemoryStorage) Begin() (StorageTx, error) {
+	return &memoryTx{s: s}, nil
+}
+
+type memoryTx struct {
+	s   *MemoryStorage
+	ops []txOp
+}
+
+func (t *memoryTx) Put(rec StoredRecord) error {
+	if t.s.maxBytes > 0 && len(rec.Value) > t.s.maxBytes {
+		return ErrTooBig
+	}
+	t.ops = append(t.ops, txOp{rec: rec})
+	return nil
+}
+
+func (t *memoryTx) Delete(key [kbucket.IdSize]byte) error {
+	t.ops = append(t.ops, txOp{rec: StoredRecord{Key: key}, delete: true})
+	return nil
+}
+
+func (t *memoryTx) Commit() error {
+	s := t.s
+	s.mu.Lock()
+	for _, op := range t.ops {
+		if op.delete {
+			s.remove(op.rec.Key)
+		} else {
+			s.putLocked(op.rec)
+		}
+	}
+	t.ops = nil
+	s.unlockAndEvict()
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	t.ops = nil
+	return nil
+}
+
+func (s *MemoryStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// 值的总字节数
+func (s *MemoryStorage) Bytes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
@@ -0,0 +1,220 @@
+package dht
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 收到的 STORE 在写入存储之前经过的队列。Validator、距离与容量的检查仍在
+// 请求处理中同步完成，写入交给后台 goroutine，请求处理不会因存储变慢而阻塞。
+// 内存中的队列满时，新的写入追加到磁盘上的临时文件，按收到的顺序写入存储
+type storeQueue struct {
+	mu      sync.Mutex
+	mem     []queuedStore // 内存中的待写入记录
+	size    int           // mem 的容量
+	dir     string        // 临时文件所在的目录，空表示系统临时目录
+	spill   *os.File      // 溢出的记录，nil 表示还没有溢出过
+	readOff int64         // spill 中下一条待写入记录的偏移
+	endOff  int64         // spill 的写入位置
+	spilled int           // spill 中尚未写入存储的记录数
+	closed  bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var errStoreQueueClosed = errors.New("dht: store queue closed")
+
+type queuedStore struct {
+	key    [kbucket.IdSize]byte
+	value  []byte
+	origin Provenance
+}
+
+// 队列中待写入的记录数
+type StoreQueueStats struct {
+	Queued  int // 内存中的记录数
+	Spilled int // 临时文件中的记录数
+}
+
+// 让收到的 STORE 经过队列写入存储：内存中最多排队 size 条，
+// 超出的部分写入 dir 下的临时文件（dir 为空时使用系统临时目录）。
+// size 不大于 0 时关闭队列。原来的队列中的记录先全部写入存储。
+// 应在开始通信之前调用；Close 与 Stop 写完队列后，收到的 STORE 直接写入存储
+func (p *Peer) SetStoreQueue(size int, dir string) error {
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("dht: store queue: %s is not a directory", dir)
+		}
+	}
+	p.storeMu.Lock()
+	old := p.storeQueue
+	p.storeQueue = nil
+	p.storeMu.Unlock()
+	if old != nil { // 写入会触发存储事件，不能持有 storeMu 等待
+		old.close()
+	}
+	if size <= 0 {
+		return nil
+	}
+	q := &storeQueue{
+		size: size,
+		dir:  dir,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	p.storeMu.Lock()
+	p.storeQueue = q
+	p.storeMu.Unlock()
+	go q.run(func(s queuedStore) {
+		p.acceptValue(s.key, s.value, s.origin)
+	})
+	return nil
+}
+
+// 当前的写入队列，没有时返回 nil
+func (p *Peer) queue() *storeQueue {
+	p.storeMu.RLock()
+	defer p.storeMu.RUnlock()
+	return p.storeQueue
+}
+
+// 队列中待写入的记录数，没有设置队列时为零值
+func (p *Peer) StoreQueueStats() StoreQueueStats {
+	q := p.queue()
+	if q == nil {
+		return StoreQueueStats{}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return StoreQueueStats{Queued: len(q.mem), Spilled: q.spilled}
+}
+
+// 加入一条待写入的记录。已经溢出过的队列在临时文件清空之前都追加到文件，
+// 保证同一个 key 的写入不会乱序
+func (q *storeQueue) push(s queuedStore) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errStoreQueueClosed
+	}
+	if q.spilled == 0 && len(q.mem) < q.size {
+		s.value = append([]byte(nil), s.value...) // 请求处理返回后缓冲区可能被复用
+		q.mem = append(q.mem, s)
+	} else if err := q.appendSpill(s); err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 长度(4) | encodeRecord 的输出。调用方需持有 q.mu
+func (q *storeQueue) appendSpill(s queuedStore) error {
+	if q.spill == nil {
+		f, err := os.CreateTemp(q.dir, "kbucket-store-*.spill")
+		if err != nil {
+			return err
+		}
+		q.spill = f
+	}
+	rec := encodeRecord(StoredRecord{Key: s.key, Value: s.value, Provenance: s.origin})
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(rec)), uint32(len(rec)))
+	buf = append(buf, rec...)
+	if _, err := q.spill.WriteAt(buf, q.endOff); err != nil {
+		return err
+	}
+	q.endOff += int64(len(buf))
+	q.spilled++
+	return nil
+}
+
+// 取出最早的一条记录：先取内存中的，再按顺序读临时文件
+func (q *storeQueue) next() (queuedStore, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.mem) > 0 {
+		s := q.mem[0]
+		q.mem[0] = queuedStore{}
+		q.mem = q.mem[1:]
+		return s, true
+	}
+	if q.spilled == 0 {
+		return queuedStore{}, false
+	}
+	s, err := q.readSpill()
+	if err != nil { // 临时文件损坏，剩下的记录无法恢复
+		q.spilled = 0
+	} else {
+		q.spilled--
+	}
+	if q.spilled == 0 { // 清空后重新从内存排队
+		q.spill.Truncate(0)
+		q.readOff, q.endOff = 0, 0
+	}
+	return s, err == nil
+}
+
+// 调用方需持有 q.mu
+func (q *storeQueue) readSpill() (queuedStore, error) {
+	var n [4]byte
+	if _, err := q.spill.ReadAt(n[:], q.readOff); err != nil {
+		return queuedStore{}, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(n[:]))
+	if _, err := q.spill.ReadAt(payload, q.readOff+4); err != nil {
+		return queuedStore{}, err
+	}
+	rec, err := parseRecord(payload)
+	if err != nil {
+		return queuedStore{}, err
+	}
+	q.readOff += 4 + int64(len(payload))
+	return queuedStore{key: rec.Key, value: rec.Value, origin: rec.Provenance}, nil
+}
+
+func (q *storeQueue) run(apply func(queuedStore)) {
+	defer close(q.done)
+	for {
+		if s, ok := q.next(); ok {
+			apply(s)
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-q.stop: // 停止前写完剩下的记录
+			for s, ok := q.next(); ok; s, ok = q.next() {
+				apply(s)
+			}
+			return
+		}
+	}
+}
+
+// 写完队列中的记录后删除临时文件。之后 push 返回 errStoreQueueClosed，可以多次调用
+func (q *storeQueue) close() {
+	q.mu.Lock()
+	closed := q.closed
+	q.closed = true
+	q.mu.Unlock()
+	if closed {
+		return
+	}
+	close(q.stop)
+	<-q.done
+	if q.spill != nil {
+		q.spill.Close()
+		os.Remove(q.spill.Name())
+	}
+}
@@ -0,0 +1,54 @@
+package dht
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// 队列在后台写入存储并发出事件时订阅、取消订阅与查看队列，需配合 go test -race 运行
+func TestStoreQueueSubscribeWhileDraining(t *testing.T) {
+	p := NewPeer(KeyFromString("queue-subscribe"))
+	if err := p.SetStoreQueue(4, ""); err != nil {
+		t.Fatal(err)
+	}
+	const n = 200
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			value := []byte(fmt.Sprintf("queued-%d", i))
+			if code, _ := p.offerStore(KeyFromBytes(value), value, NewTraceID(), Provenance{}); code != CodeOK {
+				t.Errorf("offerStore = %v", code)
+			}
+		}
+	}()
+	go func() { // 不与队列同步，只通过 storeSubs 与写入存储的 goroutine 交互
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.UnsubscribeStore(p.SubscribeStore(1))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			p.StoreQueueStats()
+			if i == n/2 { // 换一个队列，原来的队列先写完
+				if err := p.SetStoreQueue(4, dir); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+	wg.Wait()
+	if err := p.SetStoreQueue(0, ""); err != nil { // 写完队列
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if !p.store.has(KeyFromBytes([]byte(fmt.Sprintf("queued-%d", i)))) {
+			t.Fatalf("queued-%d was not written", i)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package dht
+
+import (
+	"context"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 迭代查找的一次进度：每一轮查询结束后发送一次，查找结束时再发送一次 Done 为 true 的结果
+type LookupProgress struct {
+	Closest []Contact // 目前已确认的最近的至多 K 个节点，按距离从近到远排序
+	Hops    int       // 已联系的节点数
+	Done    bool      // 查找已结束，Closest 为最终结果
+	Err     error     // 查找中断的原因，只在 Done 时设置
+}
+
+// 以流的形式执行 Lookup：
+//
+//	for ev := range p.LookupStream(ctx, target) { ... }
+//
+// 每轮查询之后发送当前的最近节点集合，结束后关闭通道。调用方认为结果已经足够时
+// 取消 ctx 即可提前结束查找；取消后通道很快关闭，不保证还能收到 Done 的结果
+func (p *Peer) LookupStream(ctx context.Context, target [kbucket.IdSize]byte) <-chan LookupProgress {
+	ch := make(chan LookupProgress)
+	send := func(ev LookupProgress) {
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+		}
+	}
+	go func() {
+		defer close(ch)
+		budget := p.newLookupBudget(ctx)
+		hops := 0
+		budget.progress = func(closest []kbucket.Node, n int) {
+			hops = n
+			send(LookupProgress{Closest: contactsOf(closest), Hops: n})
+		}
+		closest := p.lookup(target, budget)
+		send(LookupProgress{Closest: contactsOf(closest), Hops: hops, Done: true, Err: budget.err})
+	}()
+	return ch
+}
@@ -0,0 +1,98 @@
+package dht
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 时间线中的一条指标样本
+type TelemetrySample struct {
+	Time   time.Time
+	Node   [kbucket.IdSize]byte
+	Metric string
+	Value  float64
+}
+
+// 研究集群的遥测汇总：定期从加入的节点收集指标，写入同一条时间线，
+// 一次实验只产出一份数据集。只有显式 Join 的节点会被收集
+type TelemetryAggregator struct {
+	peers   []*Peer
+	samples []TelemetrySample
+}
+
+func NewTelemetryAggregator() *TelemetryAggregator {
+	return &TelemetryAggregator{}
+}
+
+// 让 p 加入汇总
+func (a *TelemetryAggregator) Join(p *Peer) {
+	for _, q := range a.peers {
+		if q == p {
+			return
+		}
+	}
+	a.peers = append(a.peers, p)
+}
+
+func (a *TelemetryAggregator) Leave(p *Peer) {
+	for i, q := range a.peers {
+		if q == p {
+			a.peers = append(a.peers[:i], a.peers[i+1:]...)
+			return
+		}
+	}
+}
+
+// 记录一条应用自定义的指标
+func (a *TelemetryAggregator) Record(node [kbucket.IdSize]byte, metric string, value float64) {
+	a.samples = append(a.samples, TelemetrySample{Time: time.Now(), Node: node, Metric: metric, Value: value})
+}
+
+// 从所有加入的节点收集一轮内置指标，由调用方按采样周期调用，返回新增的样本数
+func (a *TelemetryAggregator) Collect() int {
+	now := time.Now()
+	before := len(a.samples)
+	for _, p := range a.peers {
+		for _, m := range []struct {
+			name  string
+			value float64
+		}{
+			{"contacts", float64(len(p.kb.AllNodes()))},
+			{"records", float64(p.store.len())},
+			{"depth_exceeded", float64(p.LookupDepthExceeded())},
+			{"state", float64(p.State())},
+		} {
+			a.samples = append(a.samples, TelemetrySample{Time: now, Node: p.node.ID, Metric: m.name, Value: m.value})
+		}
+	}
+	return len(a.samples) - before
+}
+
+// 按时间排序的样本副本
+func (a *TelemetryAggregator) Samples() []TelemetrySample {
+	samples := append([]TelemetrySample(nil), a.samples...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	return samples
+}
+
+// 以 CSV 写出时间线：time,node,metric,value
+func (a *TelemetryAggregator) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "node", "metric", "value"})
+	for _, s := range a.Samples() {
+		cw.Write([]string{
+			s.Time.Format(time.RFC3339Nano),
+			hex.EncodeToString(s.Node[:]),
+			s.Metric,
+			strconv.FormatFloat(s.Value, 'g', -1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,137 @@
+package dht
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 查找路径中的一跳
+type TraceHop struct {
+	From     [kbucket.IdSize]byte
+	To       [kbucket.IdSize]byte
+	Start    time.Duration          // 相对于操作开始的时间
+	Elapsed  time.Duration          // 响应时间
+	Contacts [][kbucket.IdSize]byte // 被查询节点给出的下一跳节点
+	Found    bool                   // 被查询节点是否持有（或成功保存了）该值
+}
+
+// 一次 GetValue/SetValue 的查找路径，可以导出为 DOT 或 JSON 附在问题报告中
+type LookupTrace struct {
+	Op    string
+	Key   [kbucket.IdSize]byte
+	Hops  []TraceHop
+	begin time.Time
+	mu    sync.Mutex // 保护 Hops 与 peers，同一轮查找的各跳并发进行
+	peers []*Peer    // 记录过程中参与的节点
+}
+
+// 记录查找路径的 GetValue
+func (p *Peer) TraceGetValue(ctx context.Context, key [kbucket.IdSize]byte) ([]byte, *LookupTrace, error) {
+	t := p.beginTrace("GetValue", key)
+	defer p.endTrace()
+	value, err := p.GetValue(ctx, key)
+	return value, t, err
+}
+
+// 记录查找路径的 SetValue
+func (p *Peer) TraceSetValue(ctx context.Context, key, value []byte) (int, *LookupTrace, error) {
+	t := p.beginTrace("SetValue", KeyFromBytes(value))
+	defer p.endTrace()
+	n, err := p.SetValue(ctx, key, value)
+	return n, t, err
+}
+
+func (p *Peer) beginTrace(op string, key [kbucket.IdSize]byte) *LookupTrace {
+	t := &LookupTrace{Op: op, Key: key, begin: time.Now(), peers: []*Peer{p}}
+	p.trace.Store(t)
+	return t
+}
+
+func (p *Peer) endTrace() {
+	t := p.trace.Load()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, peer := range t.peers {
+		peer.trace.CompareAndSwap(t, nil)
+	}
+	t.peers = nil
+	sort.SliceStable(t.Hops, func(i, j int) bool { return t.Hops[i].Start < t.Hops[j].Start })
+}
+
+// 记录一跳，contacts 为 to 在响应中给出的下一跳节点
+func (p *Peer) traceHop(to Contact, contacts []Contact, start time.Time, found bool) {
+	t := p.trace.Load()
+	if t == nil {
+		return
+	}
+	hop := TraceHop{
+		From:    p.node.ID,
+		To:      to.ID,
+		Start:   start.Sub(t.begin),
+		Elapsed: time.Since(start),
+		Found:   found,
+	}
+	for _, next := range contacts {
+		hop.Contacts = append(hop.Contacts, next.ID)
+	}
+	t.mu.Lock()
+	t.Hops = append(t.Hops, hop)
+	t.mu.Unlock()
+}
+
+func shortID(id [kbucket.IdSize]byte) string {
+	return hex.EncodeToString(id[:4])
+}
+
+// 导出为 Graphviz DOT 格式，边上标注顺序与响应时间，持有值的节点加粗显示
+func (t *LookupTrace) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph lookup {\n\tlabel=\"%s %x\";\n", t.Op, t.Key)
+	for i, hop := range t.Hops {
+		if hop.Found {
+			fmt.Fprintf(&b, "\t\"%s\" [style=bold];\n", shortID(hop.To))
+		}
+		fmt.Fprintf(&b, "\t\"%s\" -> \"%s\" [label=\"%d: %s\"];\n",
+			shortID(hop.From), shortID(hop.To), i+1, hop.Elapsed)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+type jsonHop struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	StartUs   int64    `json:"start_us"`
+	ElapsedUs int64    `json:"elapsed_us"`
+	Contacts  []string `json:"contacts"`
+	Found     bool     `json:"found"`
+}
+
+func (t *LookupTrace) MarshalJSON() ([]byte, error) {
+	hops := make([]jsonHop, len(t.Hops))
+	for i, hop := range t.Hops {
+		hops[i] = jsonHop{
+			From:      hex.EncodeToString(hop.From[:]),
+			To:        hex.EncodeToString(hop.To[:]),
+			StartUs:   hop.Start.Microseconds(),
+			ElapsedUs: hop.Elapsed.Microseconds(),
+			Found:     hop.Found,
+		}
+		for _, c := range hop.Contacts {
+			hops[i].Contacts = append(hops[i].Contacts, hex.EncodeToString(c[:]))
+		}
+	}
+	return json.Marshal(struct {
+		Op   string    `json:"op"`
+		Key  string    `json:"key"`
+		Hops []jsonHop `json:"hops"`
+	}{t.Op, hex.EncodeToString(t.Key[:]), hops})
+}
@@ -0,0 +1,63 @@
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 一次用户操作的追踪 ID，由发起操作的节点生成，随每一跳请求传递，
+// 使多个节点上的日志可以按同一次操作关联起来
+type TraceID [8]byte
+
+func NewTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+type traceKey struct{}
+
+// 返回携带追踪 ID 的 ctx，Messenger 用它发出的请求属于同一次操作
+func ContextWithTrace(ctx context.Context, trace TraceID) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// ctx 携带的追踪 ID，没有时生成一个新的
+func TraceFromContext(ctx context.Context) TraceID {
+	if trace, ok := ctx.Value(traceKey{}).(TraceID); ok {
+		return trace
+	}
+	return NewTraceID()
+}
+
+// 请求类型，传给 Hooks.OnRequest
+const (
+	OpPing      = "PING"
+	OpStore     = "STORE"
+	OpFindNode  = "FIND_NODE"
+	OpFindValue = "FIND_VALUE"
+	OpLeave     = "LEAVE"
+
+	OpAddProvider  = "ADD_PROVIDER"
+	OpGetProviders = "GET_PROVIDERS"
+
+	OpRangeSync  = "RANGE_SYNC"
+	OpDigestSync = "DIGEST_SYNC"
+	OpMerkleSync = "MERKLE_SYNC"
+
+	OpSignal = "SIGNAL"
+)
+
+// p 处理了来自 from 的请求
+func (p *Peer) onRequest(trace TraceID, op string, from, key [kbucket.IdSize]byte) {
+	if h := p.hooks; h != nil && h.OnRequest != nil {
+		h.OnRequest(p, trace, op, from, key)
+	}
+}
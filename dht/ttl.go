@@ -0,0 +1,60 @@
+package dht
+
+import (
+	"context"
+	"time"
+)
+
+// 删除本地所有过期的记录，返回删除的数量
+func (p *Peer) ExpireRecords() int {
+	expired := p.store.expire(p.now())
+	for _, key := range expired {
+		p.dropSiblings(key)
+		p.emitStore(ValueExpired, key)
+	}
+	return len(expired)
+}
+
+// 把超过重新发布周期（RepublishInterval 或命名空间策略）没有发布过的记录
+// 以低优先级重新 STORE 到距离 key 最近的节点，使记录在过期之前得到续期。返回重新发布的记录数
+func (p *Peer) Republish() int {
+	due := p.store.duePublish(p.now(), p.republishDue)
+	for key, value := range due {
+		p.replicate(maintenanceContext(), key, value, NewTraceID())
+	}
+	return len(due)
+}
+
+// 在后台周期性地清理过期记录、provider 记录与否定缓存、压缩发布日志、重新发布、与副本邻居做反熵同步、
+// 检查副本漂移并迁移旧哈希的 key（见 MigrateKeys），直到 ctx 结束。
+// interval 为检查周期，0 表示使用 RepublishInterval 的十分之一，每次的等待时间按 Jitter 抖动
+func (p *Peer) RunJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = p.cfg.RepublishInterval / 10
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	timer := time.NewTimer(p.jittered(interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			p.ExpireRecords()
+			p.Republish()
+			p.ExpireProviders()
+			p.expireValues()
+			p.expireMisses()
+			p.compactJournal()
+			p.RepublishProviders()
+			p.antiEntropy(ctx)
+			p.checkDrift(ctx)
+			if p.cfg.LegacyHasher != nil {
+				p.MigrateKeys(ctx)
+			}
+			timer.Reset(p.jittered(interval))
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package dht
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 再次收到 STORE 延长记录的有效期
+func TestRecordTTLRefresh(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("ttl-refresh"), Config{RecordTTL: time.Hour, RepublishInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("ttl-refresh")
+	key := KeyFromBytes(value)
+	p.store.put(key, value, Provenance{})
+	p.Faults().JumpClock(50 * time.Minute)
+	if !p.store.refresh(key) {
+		t.Fatal("refresh missed a live record")
+	}
+	p.Faults().JumpClock(50 * time.Minute) // 距写入 100 分钟，距续期 50 分钟
+	if n := p.ExpireRecords(); n != 0 || !p.store.has(key) {
+		t.Fatalf("refreshed record expired after %d removals", n)
+	}
+	p.Faults().JumpClock(time.Hour)
+	if n := p.ExpireRecords(); n != 1 {
+		t.Fatalf("ExpireRecords = %d after the refreshed TTL, want 1", n)
+	}
+}
+
+// 续期同时更新缓存中的过期时间，超过原来的有效期后读取仍然命中缓存
+func TestRecordTTLRefreshUpdatesCache(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("ttl-refresh-cache"), Config{RecordTTL: time.Hour, RepublishInterval: -1, CacheSize: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("ttl-refresh-cache")
+	key := KeyFromBytes(value)
+	p.store.put(key, value, Provenance{})
+	p.Faults().JumpClock(50 * time.Minute)
+	if !p.store.refresh(key) {
+		t.Fatal("refresh missed a live record")
+	}
+	p.Faults().JumpClock(50 * time.Minute)
+	before := p.CacheStats()
+	if !p.store.has(key) {
+		t.Fatal("refreshed record missing")
+	}
+	if after := p.CacheStats(); after.Hits != before.Hits+1 || after.Misses != before.Misses {
+		t.Fatalf("cache stats %+v -> %+v, want a hit with the refreshed expiry", before, after)
+	}
+}
+
+// 超过重新发布周期的记录被 STORE 到最近的节点，发布时间随之更新
+func TestRepublishBeforeExpiry(t *testing.T) {
+	peers := newTestNetwork(8, 9)
+	p, err := NewPeerWithConfig(KeyFromString("ttl-republish"), Config{RecordTTL: 2 * time.Hour, RepublishInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range peers {
+		p.kb.InsertNode(kbucket.Node{ID: q.node.ID, Data: q})
+	}
+	value := []byte("ttl-republish")
+	key := KeyFromBytes(value)
+	p.store.put(key, value, Provenance{})
+	if n := p.Republish(); n != 0 {
+		t.Fatalf("Republish = %d for a fresh record", n)
+	}
+	p.Faults().JumpClock(time.Hour + time.Minute)
+	if n := p.Republish(); n != 1 {
+		t.Fatalf("Republish = %d after the interval, want 1", n)
+	}
+	held := 0
+	for _, q := range peers {
+		if q.store.has(key) {
+			held++
+		}
+	}
+	if held == 0 {
+		t.Fatal("republished record reached no peer")
+	}
+	if n := p.Republish(); n != 0 {
+		t.Fatalf("second Republish = %d, want 0", n)
+	}
+}
+
+// RunJanitor 在后台删除过期的记录并发出 ValueExpired 事件，ctx 结束后退出
+func TestJanitorExpiresRecords(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("ttl-janitor"), Config{RecordTTL: time.Minute, RepublishInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := []byte("ttl-janitor")
+	key := KeyFromBytes(value)
+	p.store.put(key, value, Provenance{})
+	events := p.SubscribeStore(4)
+	p.Faults().JumpClock(2 * time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.RunJanitor(ctx, time.Millisecond)
+		close(done)
+	}()
+	select {
+	case ev := <-events:
+		if ev.Type != ValueExpired || ev.Key != key {
+			t.Fatalf("event = %v %x, want ValueExpired for the record", ev.Type, ev.Key[:4])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("janitor did not expire the record")
+	}
+	cancel()
+	<-done
+	if p.store.len() != 0 {
+		t.Fatalf("%d records left after expiry", p.store.len())
+	}
+}
+
+// 设置 CacheDecay 时远离 key 的缓存副本按更近的节点数缩短有效期且不重新发布，
+// 副本节点与本节点发布的 key 使用完整的有效期
+func TestCacheDecay(t *testing.T) {
+	p, err := NewPeerWithConfig(KeyFromString("decay-self"), Config{K: 2, Alpha: 2, FlatTable: 16, RecordTTL: time.Hour, RepublishInterval: 30 * time.Minute, CacheDecay: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	far, near := KeyFromString("decay-far"), p.ID()
+	near[kbucket.IdSize-1] ^= 1
+	owned := far
+	owned[kbucket.IdSize-1] ^= 0x80
+	for i := 1; i <= 4; i++ { // 4 个比本节点更接近 far 与 owned 的节点，有效期减半两次
+		id := far
+		id[kbucket.IdSize-1] ^= byte(i)
+		p.kb.InsertNode(kbucket.Node{ID: id, Data: NewPeer(id)})
+	}
+	p.owned.track(owned, []byte("decay-owned"))
+	now := p.now()
+	for _, tc := range []struct {
+		key       [kbucket.IdSize]byte
+		ttl       time.Duration
+		republish time.Duration
+	}{
+		{far, 15 * time.Minute, 0},
+		{near, time.Hour, 30 * time.Minute},
+		{owned, time.Hour, 30 * time.Minute},
+	} {
+		value := []byte("decay")
+		p.store.put(tc.key, value, Provenance{})
+		r, _, ok := p.store.record(tc.key)
+		if !ok {
+			t.Fatalf("record %x missing", tc.key[:4])
+		}
+		if got := r.Expires.Sub(now); got < tc.ttl || got > tc.ttl+time.Second {
+			t.Fatalf("TTL of %x = %v, want %v", tc.key[:4], got, tc.ttl)
+		}
+		if got := p.republishDue(tc.key, value); got != tc.republish {
+			t.Fatalf("republish interval of %x = %v, want %v", tc.key[:4], got, tc.republish)
+		}
+	}
+}
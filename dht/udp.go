@@ -0,0 +1,916 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+	"github.com/WuQingyang2/K_Bucket/transport/udpwire"
+)
+
+// UDP 消息类型，数据包格式见 udpwire
+const (
+	msgPing          = udpwire.Ping
+	msgPong          = udpwire.Pong
+	msgStore         = udpwire.Store
+	msgStoreResp     = udpwire.StoreResp
+	msgFindNode      = udpwire.FindNode
+	msgFindNodeResp  = udpwire.FindNodeResp
+	msgFindValue     = udpwire.FindValue
+	msgFindValueResp = udpwire.FindValueResp
+	msgLeave         = udpwire.Leave // 请求方即将离开网络，见 Peer.Close
+	msgLeaveResp     = udpwire.LeaveResp
+
+	msgAddProvider      = udpwire.AddProvider // 请求方声明自己是 key 的 provider，见 Peer.Provide
+	msgAddProviderResp  = udpwire.AddProviderResp
+	msgGetProviders     = udpwire.GetProviders
+	msgGetProvidersResp = udpwire.GetProvidersResp
+
+	msgRangeSync     = udpwire.RangeSync // 请求一段 keyspace 中的记录，见 Peer.SyncRange
+	msgRangeSyncResp = udpwire.RangeSyncResp
+
+	msgDigestSync     = udpwire.DigestSync // 比较一段 keyspace 中的 key，见 Peer.AntiEntropy
+	msgDigestSyncResp = udpwire.DigestSyncResp
+	msgMerkleSync     = udpwire.MerkleSync // 请求一个 Merkle 子树的摘要，见 Peer.AntiEntropy
+	msgMerkleSyncResp = udpwire.MerkleSyncResp
+	msgSignal         = udpwire.Signal // 经由接收方转交给另一个节点的信令，见 UDPTransport.Signal
+	msgSignalResp     = udpwire.SignalResp
+)
+
+const (
+	DefaultRPCTimeout = time.Second // 没有测量过 RTT 的节点的请求超时
+	DefaultRPCRetries = 2           // 超时后重发的次数
+
+	maxPacketSize = udpwire.MaxPacketSize
+	headerSize    = udpwire.HeaderSize
+)
+
+const (
+	findValueWithNodes = udpwire.FindValueWithNodes
+	findValueWithProof = udpwire.FindValueWithProof
+)
+
+var (
+	ErrTimeout     = errors.New("dht: rpc timeout")
+	ErrBadPacket   = udpwire.ErrBadPacket
+	ErrTransportUp = errors.New("dht: peer already has a transport")
+	errClosed      = errors.New("dht: transport closed")
+)
+
+// 一条 UDP 消息，前几个字段对应 udpwire.Header
+type message struct {
+	kind    byte
+	network NetworkID
+	rpcID   uint64
+	trace   TraceID
+	sender  [kbucket.IdSize]byte
+	payload []byte
+	from    *net.UDPAddr
+	signed  bool    // 带有发送方的有效签名
+	pub     []byte  // signed 时发送方的公钥，与 payload 一样引用读缓冲区
+	low     bool    // 低优先级的请求，见 udpwire.LowPriority
+	pooled  *[]byte // payload 所在的池中缓冲区，见 retain
+}
+
+// 基于 UDP 的 Kademlia RPC（PING、STORE、FIND_NODE、FIND_VALUE），
+// 使节点可以运行在不同的进程或机器上。通过网络认识的节点以 *net.UDPAddr
+// 作为 Node.Data 保存在路由表中。收到的请求在读循环中依次处理
+type UDPTransport struct {
+	Timeout time.Duration // 等待没有测量过 RTT 的节点响应的时间，其他节点使用各自的 RTO
+	Retries int
+
+	p       *Peer
+	mux     *UDPMux
+	network NetworkID
+	owned   bool       // mux 由 ListenUDP 创建，关闭时一并关闭
+	mu      sync.Mutex // 保护 pending、backoff、verifying 与 signal
+
+	pending   map[uint64]chan message
+	backoff   map[string]time.Time          // 回复 BUSY 的地址及其要求的等待截止时间
+	verifying map[[kbucket.IdSize]byte]bool // 正在验证地址变化的节点
+	signal    func(Signal)                  // 收到信令时调用，见 SetSignalHandler
+	done      chan struct{}
+}
+
+// addr 要求的等待期还剩多久，0 表示可以发送
+func (t *UDPTransport) backoffLeft(addr *net.UDPAddr) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	left := time.Until(t.backoff[addr.String()])
+	if left <= 0 {
+		delete(t.backoff, addr.String())
+		return 0
+	}
+	return left
+}
+
+func (t *UDPTransport) setBackoff(addr *net.UDPAddr, d time.Duration) {
+	t.mu.Lock()
+	if t.backoff == nil {
+		t.backoff = make(map[string]time.Time)
+	}
+	t.backoff[addr.String()] = time.Now().Add(d)
+	t.mu.Unlock()
+}
+
+// 在 addr 上监听并为 p 处理 RPC，只使用默认网络。socket 数已到
+// Config.Resources.Sockets 时返回 ErrBusy
+func ListenUDP(p *Peer, addr string) (*UDPTransport, error) {
+	if p.transport != nil {
+		return nil, ErrTransportUp
+	}
+	if !p.acquire(ResourceSockets, "listen") {
+		return nil, ErrBusy
+	}
+	mux, err := ListenMux(addr)
+	if err != nil {
+		p.release(ResourceSockets)
+		return nil, err
+	}
+	t, err := mux.Attach(p, DefaultNetwork)
+	if err != nil {
+		mux.Close()
+		p.release(ResourceSockets)
+		return nil, err
+	}
+	t.owned = true
+	return t, nil
+}
+
+func (t *UDPTransport) Addr() *net.UDPAddr {
+	return t.mux.Addr()
+}
+
+// 节点所在的网络
+func (t *UDPTransport) Network() NetworkID {
+	return t.network
+}
+
+func (t *UDPTransport) Close() error {
+	t.mu.Lock()
+	select {
+	case <-t.done:
+		t.mu.Unlock()
+		return nil
+	default:
+	}
+	close(t.done)
+	if t.p.transport == t {
+		t.p.transport = nil
+	}
+	t.mu.Unlock()
+	t.mux.detach(t)
+	if t.owned {
+		t.p.release(ResourceSockets)
+		return t.mux.Close()
+	}
+	return nil
+}
+
+// 以 trace 作为追踪 ID 发出请求，用于把多次 RPC 关联到同一次操作。
+// 直接调用 UDPTransport 的方法时每次请求使用新的追踪 ID
+func (t *UDPTransport) Traced(trace TraceID) *TracedTransport {
+	return &TracedTransport{t: t, ctx: context.Background(), trace: trace}
+}
+
+type TracedTransport struct {
+	t     *UDPTransport
+	ctx   context.Context // 结束时不再等待响应
+	trace TraceID
+	peer  [kbucket.IdSize]byte // 已知的对方 ID，用于按对方的 RTO 等待响应，零值表示未知
+	low   bool                 // 请求标记为低优先级，见 Priority
+}
+
+// 请求已知 ID 的节点，等待时间使用其 RTO，ctx 结束时放弃等待
+func (t *UDPTransport) tracedTo(ctx context.Context, trace TraceID, to Contact) *TracedTransport {
+	return &TracedTransport{t: t, ctx: ctx, trace: trace, peer: to.ID}
+}
+
+func (c *TracedTransport) call(addr *net.UDPAddr, kind byte, payload []byte) (message, error) {
+	return c.t.call(c.ctx, addr, kind, payload, c.trace, c.peer, c.low)
+}
+
+func (t *UDPTransport) Ping(addr *net.UDPAddr) ([kbucket.IdSize]byte, error) {
+	return t.Traced(NewTraceID()).Ping(addr)
+}
+
+func (t *UDPTransport) Store(addr *net.UDPAddr, key [kbucket.IdSize]byte, value []byte) error {
+	return t.Traced(NewTraceID()).Store(addr, key, value)
+}
+
+func (t *UDPTransport) FindNode(addr *net.UDPAddr, target [kbucket.IdSize]byte) ([]kbucket.Node, error) {
+	return t.Traced(NewTraceID()).FindNode(addr, target)
+}
+
+func (t *UDPTransport) FindNodeWithHints(addr *net.UDPAddr, target [kbucket.IdSize]byte) ([]kbucket.Node, []QualityHint, error) {
+	return t.Traced(NewTraceID()).FindNodeWithHints(addr, target)
+}
+
+func (t *UDPTransport) FindValue(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, []kbucket.Node, error) {
+	return t.Traced(NewTraceID()).FindValue(addr, key)
+}
+
+func (t *UDPTransport) FindValueAndNodes(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, []kbucket.Node, error) {
+	return t.Traced(NewTraceID()).FindValueAndNodes(addr, key)
+}
+
+func (t *UDPTransport) FindValueWithProof(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, *ResponsibilityProof, error) {
+	return t.Traced(NewTraceID()).FindValueWithProof(addr, key)
+}
+
+func (t *UDPTransport) Leave(addr *net.UDPAddr) error {
+	return t.Traced(NewTraceID()).Leave(addr)
+}
+
+func (t *UDPTransport) AddProvider(addr *net.UDPAddr, key [kbucket.IdSize]byte) error {
+	return t.Traced(NewTraceID()).AddProvider(addr, key)
+}
+
+func (t *UDPTransport) GetProviders(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]Provider, []kbucket.Node, error) {
+	return t.Traced(NewTraceID()).GetProviders(addr, key)
+}
+
+func (t *UDPTransport) RangeSync(addr *net.UDPAddr, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	return t.Traced(NewTraceID()).RangeSync(addr, r, from, limit)
+}
+
+func (t *UDPTransport) DigestSync(addr *net.UDPAddr, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	return t.Traced(NewTraceID()).DigestSync(addr, r, digest, limit)
+}
+
+func (t *UDPTransport) MerkleSync(addr *net.UDPAddr, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	return t.Traced(NewTraceID()).MerkleSync(addr, r, prefix, depth)
+}
+
+// ping 远端节点，返回其 ID。同时与对方交换握手信息，见 PeerInfo
+func (c *TracedTransport) Ping(addr *net.UDPAddr) ([kbucket.IdSize]byte, error) {
+	return c.ping(addr, true)
+}
+
+// hello 为 true 时请求附带本节点的握手信息，对方在响应中回复它的信息
+func (c *TracedTransport) ping(addr *net.UDPAddr, hello bool) ([kbucket.IdSize]byte, error) {
+	var payload []byte
+	if hello {
+		var buf bytes.Buffer
+		udpwire.AppendHello(&buf, c.t.p.LocalInfo().wire())
+		payload = buf.Bytes()
+	}
+	resp, err := c.call(addr, msgPing, payload)
+	if err != nil {
+		return [kbucket.IdSize]byte{}, err
+	}
+	defer resp.release()
+	if len(resp.payload) > 0 {
+		if h, err := udpwire.ReadHello(bytes.NewReader(resp.payload)); err == nil {
+			c.t.p.learnInfo(resp.sender, infoFromWire(h))
+		}
+	}
+	return resp.sender, nil
+}
+
+// 通知远端节点本节点即将离开，对方把本节点从路由表中删除
+func (c *TracedTransport) Leave(addr *net.UDPAddr) error {
+	resp, err := c.call(addr, msgLeave, nil)
+	if err != nil {
+		return err
+	}
+	resp.release()
+	return nil
+}
+
+// 请求远端节点把本节点记为 key 的 provider，远端以数据包的来源地址联系本节点
+func (c *TracedTransport) AddProvider(addr *net.UDPAddr, key [kbucket.IdSize]byte) error {
+	resp, err := c.call(addr, msgAddProvider, key[:])
+	if err != nil {
+		return err
+	}
+	defer resp.release()
+	if len(resp.payload) != 1 {
+		return ErrBadPacket
+	}
+	return ErrorFromCode(ErrorCode(resp.payload[0]), "")
+}
+
+// 向远端节点请求它保存的 key 的 provider 以及它知道的最近节点
+func (c *TracedTransport) GetProviders(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]Provider, []kbucket.Node, error) {
+	resp, err := c.call(addr, msgGetProviders, key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.release()
+	r := bytes.NewReader(resp.payload)
+	found, ttls, err := udpwire.ReadProviders(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes, err := udpwire.ReadContacts(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	providers := make([]Provider, len(found))
+	for i, n := range found {
+		providers[i].Contact = contactOf(n)
+		if ttls[i] > 0 {
+			providers[i].Expires = now.Add(time.Duration(ttls[i]) * time.Second)
+		}
+	}
+	return providers, nodes, nil
+}
+
+// 向远端节点请求落在 r 中、key 不小于 from 的至多 limit 条记录。
+// 远端限制请求频率时返回带有 RetryAfter 的 RPCError
+func (c *TracedTransport) RangeSync(addr *net.UDPAddr, r ResponsibilityRange, from [kbucket.IdSize]byte, limit int) (RangePage, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	udpwire.AppendRangeRequest(buf, udpwire.RangeRequest{Self: r.Self, Bits: uint8(r.Bits), From: from, Limit: uint16(min(limit, 0xffff))})
+	resp, err := c.call(addr, msgRangeSync, buf.Bytes())
+	if err != nil {
+		return RangePage{}, err
+	}
+	defer resp.release()
+	rd := bytes.NewReader(resp.payload)
+	code, err := rd.ReadByte()
+	if err != nil {
+		return RangePage{}, ErrBadPacket
+	}
+	if ErrorCode(code) == CodeBusy && rd.Len() == 4 {
+		var ms uint32
+		binary.Read(rd, binary.BigEndian, &ms)
+		return RangePage{}, &RPCError{Code: CodeBusy, RetryAfter: time.Duration(ms) * time.Millisecond}
+	}
+	if ErrorCode(code) != CodeOK {
+		return RangePage{}, ErrorFromCode(ErrorCode(code), "")
+	}
+	records, next, more, err := udpwire.ReadRangePage(rd)
+	if err != nil {
+		return RangePage{}, err
+	}
+	page := RangePage{Next: next, More: more, Records: make([]SyncRecord, len(records))}
+	for i, rec := range records {
+		page.Records[i] = SyncRecord{Key: rec.Key, Value: rec.Value, TTL: time.Duration(rec.TTL) * time.Second}
+	}
+	return page, nil
+}
+
+// 向远端节点请求落在 r 中、不在 digest 中的至多 limit 个 key。
+// 远端限制请求频率时返回带有 RetryAfter 的 RPCError
+func (c *TracedTransport) DigestSync(addr *net.UDPAddr, r ResponsibilityRange, digest *KeyDigest, limit int) ([][kbucket.IdSize]byte, bool, error) {
+	raw, _ := digest.MarshalBinary()
+	buf := getBuffer()
+	defer putBuffer(buf)
+	udpwire.AppendDigestRequest(buf, udpwire.DigestRequest{Self: r.Self, Bits: uint8(r.Bits), Limit: uint16(min(limit, 0xffff)), Digest: raw})
+	resp, err := c.call(addr, msgDigestSync, buf.Bytes())
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.release()
+	rd := bytes.NewReader(resp.payload)
+	code, err := rd.ReadByte()
+	if err != nil {
+		return nil, false, ErrBadPacket
+	}
+	if ErrorCode(code) == CodeBusy && rd.Len() == 4 {
+		var ms uint32
+		binary.Read(rd, binary.BigEndian, &ms)
+		return nil, false, &RPCError{Code: CodeBusy, RetryAfter: time.Duration(ms) * time.Millisecond}
+	}
+	if ErrorCode(code) != CodeOK {
+		return nil, false, ErrorFromCode(ErrorCode(code), "")
+	}
+	return udpwire.ReadKeyList(rd)
+}
+
+// 向远端节点请求落在 r 中、与 prefix 共享前 depth 位的 key 构成的 Merkle 子树的摘要
+func (c *TracedTransport) MerkleSync(addr *net.UDPAddr, r ResponsibilityRange, prefix [kbucket.IdSize]byte, depth int) (MerkleSummary, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	udpwire.AppendMerkleRequest(buf, udpwire.MerkleRequest{Self: r.Self, Bits: uint8(r.Bits), Prefix: prefix, Depth: uint8(depth)})
+	resp, err := c.call(addr, msgMerkleSync, buf.Bytes())
+	if err != nil {
+		return MerkleSummary{}, err
+	}
+	defer resp.release()
+	n, err := udpwire.ReadMerkleNode(bytes.NewReader(resp.payload))
+	if err != nil {
+		return MerkleSummary{}, err
+	}
+	return MerkleSummary{Hash: n.Hash, Leaf: n.Leaf, Keys: n.Keys, Children: n.Children}, nil
+}
+
+// 请求远端节点保存 value，远端拒绝时返回对应的 RPCError
+func (c *TracedTransport) Store(addr *net.UDPAddr, key [kbucket.IdSize]byte, value []byte) error {
+	if headerSize+kbucket.IdSize+4+len(value)+sigSize > maxPacketSize {
+		return ErrTooBig
+	}
+	if wait := c.t.backoffLeft(addr); wait > 0 { // 远端要求的等待期内不再发送
+		return &RPCError{Code: CodeBusy, RetryAfter: wait}
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.Write(key[:])
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+	resp, err := c.call(addr, msgStore, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.release()
+	switch {
+	case len(resp.payload) == 1:
+		return ErrorFromCode(ErrorCode(resp.payload[0]), "")
+	case len(resp.payload) == 5 && ErrorCode(resp.payload[0]) == CodeBusy: // 附带重试等待时间（毫秒）
+		wait := time.Duration(binary.BigEndian.Uint32(resp.payload[1:])) * time.Millisecond
+		c.t.setBackoff(addr, wait)
+		c.t.p.throttle(resp.sender, wait)
+		return &RPCError{Code: CodeBusy, RetryAfter: wait}
+	}
+	return ErrBadPacket
+}
+
+// 向远端节点请求距离 target 最近的节点
+func (c *TracedTransport) FindNode(addr *net.UDPAddr, target [kbucket.IdSize]byte) ([]kbucket.Node, error) {
+	resp, err := c.call(addr, msgFindNode, target[:])
+	if err != nil {
+		return nil, err
+	}
+	defer resp.release()
+	return udpwire.ReadContacts(bytes.NewReader(resp.payload))
+}
+
+// 与 FindNode 相同，同时请求远端对每个节点的质量提示，与节点一一对应。
+// 旧版本的节点不返回提示，此时提示为空
+func (c *TracedTransport) FindNodeWithHints(addr *net.UDPAddr, target [kbucket.IdSize]byte) ([]kbucket.Node, []QualityHint, error) {
+	nodes, hints, _, err := c.findNode(addr, target, udpwire.FindNodeWithHints)
+	return nodes, hints, err
+}
+
+// 按 flags 请求远端附带质量提示与公钥，返回的提示与公钥与节点一一对应，
+// 远端不支持时为空；不知道的公钥为 nil
+func (c *TracedTransport) findNode(addr *net.UDPAddr, target [kbucket.IdSize]byte, flags byte) ([]kbucket.Node, []QualityHint, [][]byte, error) {
+	resp, err := c.call(addr, msgFindNode, append(target[:], flags))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer resp.release()
+	r := bytes.NewReader(resp.payload)
+	nodes, err := udpwire.ReadContacts(r)
+	if err != nil || r.Len() == 0 {
+		return nodes, nil, nil, err
+	}
+	var hints []QualityHint
+	if flags&udpwire.FindNodeWithHints != 0 {
+		raw, err := udpwire.ReadHints(r)
+		if err != nil || len(raw) != len(nodes) {
+			return nil, nil, nil, ErrBadPacket
+		}
+		hints = make([]QualityHint, len(raw))
+		for i, h := range raw {
+			hints[i] = hintFromWire(h)
+		}
+		if r.Len() == 0 {
+			return nodes, hints, nil, nil
+		}
+	}
+	var keys [][]byte
+	if flags&udpwire.FindNodeWithKeys != 0 {
+		if keys, err = udpwire.ReadKeys(r); err != nil || len(keys) != len(nodes) {
+			return nil, nil, nil, ErrBadPacket
+		}
+	}
+	return nodes, hints, keys, nil
+}
+
+// 向远端节点请求 key 的值；远端没有该值时返回它知道的最近节点
+func (c *TracedTransport) FindValue(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, []kbucket.Node, error) {
+	value, nodes, _, err := c.findValue(addr, key, 0)
+	return value, nodes, err
+}
+
+// 与 FindValue 相同，但命中时也返回远端知道的最近节点，
+// 供 quorum 读取或读修复使用而不需要再发一次 FIND_NODE
+func (c *TracedTransport) FindValueAndNodes(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, []kbucket.Node, error) {
+	value, nodes, _, err := c.findValue(addr, key, findValueWithNodes)
+	return value, nodes, err
+}
+
+// 与 FindValue 相同，但命中时也返回远端的负责证明。远端不支持时证明为 nil
+func (c *TracedTransport) FindValueWithProof(addr *net.UDPAddr, key [kbucket.IdSize]byte) ([]byte, *ResponsibilityProof, error) {
+	value, _, proof, err := c.findValue(addr, key, findValueWithProof)
+	return value, proof, err
+}
+
+// 命中时的响应：1 | 值长度(4) | 值 | 最近节点（若请求）| 负责证明（若请求）。
+// 不支持标志的旧版本只返回值
+func (c *TracedTransport) findValue(addr *net.UDPAddr, key [kbucket.IdSize]byte, flags byte) ([]byte, []kbucket.Node, *ResponsibilityProof, error) {
+	resp, err := c.call(addr, msgFindValue, append(key[:], flags))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer resp.release()
+	r := bytes.NewReader(resp.payload)
+	found, err := r.ReadByte()
+	if err != nil {
+		return nil, nil, nil, ErrBadPacket
+	}
+	if found == 0 {
+		nodes, err := udpwire.ReadContacts(r)
+		return nil, nodes, nil, err
+	}
+	var size uint32
+	if binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+		return nil, nil, nil, ErrBadPacket
+	}
+	value := make([]byte, size)
+	io.ReadFull(r, value)
+	if c.t.p.validate(key, value) != nil { // 不接受无法通过检查的值
+		return nil, nil, nil, ErrBadPacket
+	}
+	var nodes []kbucket.Node
+	if flags&findValueWithNodes != 0 && r.Len() > 0 {
+		if nodes, err = udpwire.ReadContacts(r); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	var proof *ResponsibilityProof
+	if flags&findValueWithProof != 0 && r.Len() > 0 {
+		if proof, err = decodeProof(r, key, resp.sender); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return value, nodes, proof, nil
+}
+
+// 发送请求并等待匹配 RPC ID 的响应，超时后按 Retries 重发。测量过 RTT 的 peer 等待其 RTO，
+// 每次重发加倍，不超过 Config.MaxRTO；其他节点每次等待 Timeout。重发之后的响应无法确定
+// 对应哪一次发送，不作为 RTT 样本。响应的 payload 来自缓冲池，调用方解析完后需要 release
+func (t *UDPTransport) call(ctx context.Context, addr *net.UDPAddr, kind byte, payload []byte, trace TraceID, peer [kbucket.IdSize]byte, low bool) (message, error) {
+	var idBuf [8]byte
+	rand.Read(idBuf[:])
+	req := message{kind: kind, network: t.network, rpcID: binary.BigEndian.Uint64(idBuf[:]), trace: trace, sender: t.p.node.ID, payload: payload, low: low}
+	ch := make(chan message, 1)
+	t.mu.Lock()
+	t.pending[req.rpcID] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, req.rpcID)
+		t.mu.Unlock()
+	}()
+	pb := getPacket()
+	packet := t.p.signPacket(appendMessage(*pb, req))
+	*pb = packet
+	defer putPacket(pb)
+	rto := t.p.rto(peer)
+	timeout := t.Timeout
+	if rto > 0 {
+		timeout = rto
+	}
+	for attempt := 0; attempt <= t.Retries; attempt++ {
+		start := time.Now()
+		if _, err := t.mux.conn.WriteToUDP(packet, addr); err != nil {
+			return message{}, err
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case resp := <-ch:
+			timer.Stop()
+			rtt := time.Since(start)
+			sample := rtt
+			if attempt > 0 {
+				sample = 0
+			}
+			t.p.observe(resp.sender, true, sample)
+			t.p.metricRPC(rpcOp(kind), rtt, true)
+			t.learn(resp.sender, addr)
+			return resp, nil
+		case <-timer.C:
+			if rto > 0 {
+				timeout = min(2*timeout, t.p.cfg.MaxRTO)
+			}
+		case <-ctx.Done(): // 调用方不再等待，不计为对方的失败
+			timer.Stop()
+			return message{}, ctx.Err()
+		case <-t.done:
+			timer.Stop()
+			return message{}, errClosed
+		}
+	}
+	t.p.backoffRTO(peer)
+	t.p.metricRPC(rpcOp(kind), 0, false)
+	return message{}, ErrTimeout
+}
+
+// 请求类型对应的操作名
+func rpcOp(kind byte) string {
+	switch kind {
+	case msgPing:
+		return OpPing
+	case msgStore:
+		return OpStore
+	case msgFindNode:
+		return OpFindNode
+	case msgFindValue:
+		return OpFindValue
+	case msgLeave:
+		return OpLeave
+	case msgAddProvider:
+		return OpAddProvider
+	case msgGetProviders:
+		return OpGetProviders
+	case msgRangeSync:
+		return OpRangeSync
+	case msgDigestSync:
+		return OpDigestSync
+	case msgMerkleSync:
+		return OpMerkleSync
+	case msgSignal:
+		return OpSignal
+	}
+	return "unknown"
+}
+
+// 处理 mux 分发过来的一条消息：响应交给等待中的请求，请求直接处理
+func (t *UDPTransport) dispatch(msg message) {
+	if t.p.faults.timedOut(msg.sender) { // 注入的故障：与该节点的通信中断
+		return
+	}
+	if t.p.cfg.RequireSignatures && !msg.signed {
+		t.drop(msg, DropUnsigned)
+		return
+	}
+	if t.p.banned(msg.sender, msg.from) { // 不回复被封禁的节点，也不接受它的响应
+		return
+	}
+	if msg.signed {
+		t.p.learnKey(msg.sender, msg.pub)
+	}
+	switch msg.kind {
+	case msgPong, msgStoreResp, msgFindNodeResp, msgFindValueResp, msgLeaveResp, msgAddProviderResp, msgGetProvidersResp, msgRangeSyncResp, msgDigestSyncResp, msgMerkleSyncResp, msgSignalResp:
+		t.mu.Lock()
+		ch, ok := t.pending[msg.rpcID]
+		t.mu.Unlock()
+		if ok {
+			msg.retain() // 响应交给其他 goroutine，不能继续引用读缓冲区
+			select {
+			case ch <- msg:
+			default: // 重发导致的重复响应
+				msg.release()
+			}
+		}
+	default:
+		if t.p.servingPaused() { // 与超时相同
+			return
+		}
+		op := rpcOp(msg.kind)
+		if t.p.allowRequest(msg.sender, op) && t.p.acquireRequest(op, priorityOf(msg.low)) { // 超出配额的请求直接丢弃，与超时相同
+			t.handle(msg)
+			t.p.release(ResourceMessages)
+		}
+	}
+}
+
+// 处理一个请求并回复，同时把请求方加入路由表。在读循环中同步调用，
+// req.payload 引用读缓冲区，需要保留的内容必须复制
+func (t *UDPTransport) handle(req message) {
+	resp := message{network: t.network, rpcID: req.rpcID, trace: req.trace, sender: t.p.node.ID}
+	var key [kbucket.IdSize]byte // 请求涉及的 key 或目标，PING 为零值
+	buf := getBuffer()
+	defer putBuffer(buf)
+	r := bytes.NewReader(req.payload)
+	switch req.kind {
+	case msgPing:
+		resp.kind = msgPong
+		t.p.onRequest(req.trace, OpPing, req.sender, key)
+		if r.Len() > 0 { // 请求方附带了握手信息，回复本节点的信息
+			if h, err := udpwire.ReadHello(r); err == nil {
+				t.p.learnInfo(req.sender, infoFromWire(h))
+				udpwire.AppendHello(buf, t.p.LocalInfo().wire())
+			}
+		}
+	case msgStore:
+		var size uint32
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		if binary.Read(r, binary.BigEndian, &size) != nil || int(size) != r.Len() {
+			t.drop(req, DropMalformed)
+			return
+		}
+		value := make([]byte, size)
+		io.ReadFull(r, value)
+		t.p.onRequest(req.trace, OpStore, req.sender, key)
+		code, _ := t.p.offerStore(key, value, req.trace, senderOrigin(req.sender, req.signed))
+		resp.kind = msgStoreResp
+		buf.WriteByte(byte(code))
+		if code == CodeBusy {
+			binary.Write(buf, binary.BigEndian, uint32(t.p.busyRetryAfter()/time.Millisecond))
+		}
+	case msgFindNode:
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		flags, _ := r.ReadByte()
+		resp.kind = msgFindNodeResp
+		t.p.onRequest(req.trace, OpFindNode, req.sender, key)
+		nodes := networkNodes(t.p.findNodeResponse(key, t.p.cfg.K))
+		udpwire.AppendContacts(buf, nodes)
+		if flags&udpwire.FindNodeWithHints != 0 {
+			hints := make([]udpwire.Hint, len(nodes))
+			for i, n := range nodes {
+				hints[i] = t.p.qualityHint(n.ID).wire()
+			}
+			udpwire.AppendHints(buf, hints)
+		}
+		if flags&udpwire.FindNodeWithKeys != 0 {
+			keys := make([][]byte, len(nodes))
+			for i, n := range nodes {
+				keys[i] = t.p.contactKey(n)
+			}
+			udpwire.AppendKeys(buf, keys)
+		}
+	case msgFindValue:
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		flags, _ := r.ReadByte()
+		resp.kind = msgFindValueResp
+		t.p.onRequest(req.trace, OpFindValue, req.sender, key)
+		t.p.stats.record(key, false)
+		value, ok := t.p.store.get(key)
+		t.p.metricStore(ok)
+		if ok && headerSize+5+len(value)+sigSize <= maxPacketSize {
+			buf.WriteByte(1)
+			binary.Write(buf, binary.BigEndian, uint32(len(value)))
+			buf.Write(value)
+			if flags&findValueWithNodes != 0 {
+				contacts := getBuffer()
+				defer putBuffer(contacts)
+				udpwire.AppendContacts(contacts, t.p.kb.FindClosestNodes(key, t.p.cfg.K))
+				if headerSize+buf.Len()+contacts.Len()+sigSize > maxPacketSize { // 放不下时返回空列表
+					contacts.Reset()
+					contacts.WriteByte(0)
+				}
+				buf.Write(contacts.Bytes())
+			}
+			if flags&findValueWithProof != 0 {
+				proof := getBuffer()
+				defer putBuffer(proof)
+				encodeProof(proof, t.p.responsibilityProof(key))
+				if headerSize+buf.Len()+proof.Len()+sigSize <= maxPacketSize { // 放不下时不返回证明
+					buf.Write(proof.Bytes())
+				}
+			}
+		} else {
+			buf.WriteByte(0)
+			udpwire.AppendContacts(buf, t.p.kb.FindClosestNodes(key, t.p.cfg.K))
+		}
+	case msgLeave:
+		resp.kind = msgLeaveResp
+		t.p.onRequest(req.trace, OpLeave, req.sender, key)
+		t.p.peerDeparted(req.sender, req.from)
+	case msgAddProvider:
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		resp.kind = msgAddProviderResp
+		t.p.onRequest(req.trace, OpAddProvider, req.sender, key)
+		t.p.addProvider(key, Contact{ID: req.sender, Addr: req.from})
+		buf.WriteByte(byte(CodeOK))
+	case msgGetProviders:
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		resp.kind = msgGetProvidersResp
+		t.p.onRequest(req.trace, OpGetProviders, req.sender, key)
+		now := t.p.now()
+		found := t.p.providers.get(key, now)
+		nodes := make([]kbucket.Node, len(found))
+		ttls := make([]uint32, len(found))
+		for i, pr := range found {
+			nodes[i] = pr.Contact.node()
+			if !pr.Expires.IsZero() {
+				ttls[i] = uint32(max(pr.Expires.Sub(now)/time.Second, 1))
+			}
+		}
+		udpwire.AppendProviders(buf, nodes, ttls)
+		udpwire.AppendContacts(buf, t.p.kb.FindClosestNodes(key, t.p.cfg.K))
+	case msgRangeSync:
+		rr, err := udpwire.ReadRangeRequest(r)
+		if err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		resp.kind = msgRangeSyncResp
+		key = rr.Self
+		t.p.onRequest(req.trace, OpRangeSync, req.sender, key)
+		page, code, wait := t.p.serveRangeSync(req.sender, ResponsibilityRange{Self: rr.Self, Bits: int(rr.Bits)}, rr.From, int(rr.Limit))
+		buf.WriteByte(byte(code))
+		if code == CodeBusy {
+			binary.Write(buf, binary.BigEndian, uint32(wait/time.Millisecond))
+			break
+		}
+		records := make([]udpwire.Record, len(page.Records))
+		for i, rec := range page.Records {
+			records[i] = udpwire.Record{Key: rec.Key, Value: rec.Value, TTL: uint32(min(rec.TTL/time.Second, math.MaxUint32))}
+		}
+		udpwire.AppendRangePage(buf, records, page.Next, page.More)
+	case msgDigestSync:
+		dr, err := udpwire.ReadDigestRequest(r)
+		var digest KeyDigest
+		if err == nil {
+			err = digest.UnmarshalBinary(dr.Digest)
+		}
+		if err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		resp.kind = msgDigestSyncResp
+		key = dr.Self
+		t.p.onRequest(req.trace, OpDigestSync, req.sender, key)
+		keys, more, code, wait := t.p.serveDigestSync(req.sender, ResponsibilityRange{Self: dr.Self, Bits: int(dr.Bits)}, &digest, int(dr.Limit))
+		buf.WriteByte(byte(code))
+		if code == CodeBusy {
+			binary.Write(buf, binary.BigEndian, uint32(wait/time.Millisecond))
+			break
+		}
+		udpwire.AppendKeyList(buf, keys, more)
+	case msgMerkleSync:
+		mr, err := udpwire.ReadMerkleRequest(r)
+		if err != nil {
+			t.drop(req, DropMalformed)
+			return
+		}
+		resp.kind = msgMerkleSyncResp
+		key = mr.Self
+		t.p.onRequest(req.trace, OpMerkleSync, req.sender, key)
+		s := t.p.serveMerkleSync(ResponsibilityRange{Self: mr.Self, Bits: int(mr.Bits)}, mr.Prefix, int(mr.Depth))
+		udpwire.AppendMerkleNode(buf, udpwire.MerkleNode{Hash: s.Hash, Leaf: s.Leaf, Keys: s.Keys, Children: s.Children})
+	case msgSignal:
+		resp.kind = msgSignalResp
+		if !t.handleSignal(req, buf) {
+			return
+		}
+	default:
+		t.drop(req, DropUnknownKind)
+		return
+	}
+	if req.kind != msgLeave { // 离开的节点不再加入路由表
+		sender, from := req.sender, req.from
+		t.p.spawn(rpcOp(req.kind), func() { t.learn(sender, from) }) // 加入路由表可能需要 ping 其他节点，不能阻塞读循环
+	}
+	resp.payload = buf.Bytes()
+	pb := getPacket()
+	*pb = t.p.signPacket(appendMessage(*pb, resp))
+	t.mux.conn.WriteToUDP(*pb, req.from)
+	putPacket(pb)
+}
+
+// 把 m 编码后追加到 dst
+func appendMessage(dst []byte, m message) []byte {
+	kind := m.kind
+	if m.low {
+		kind |= udpwire.LowPriority
+	}
+	dst = udpwire.AppendHeader(dst, udpwire.Header{
+		Kind: kind, Network: uint32(m.network), RPCID: m.rpcID, Trace: m.trace, Sender: m.sender,
+	})
+	return append(dst, m.payload...)
+}
+
+// 带签名的数据包先验证签名，签名无效或 ID 与公钥不符时返回错误。
+// 不复制数据：payload 引用 packet，带签名的 packet 会被就地修改
+func decodeMessage(packet []byte) (message, error) {
+	packet, pub, err := openPacket(packet)
+	if err != nil {
+		return message{}, err
+	}
+	h, payload, err := udpwire.ParseHeader(packet)
+	if err != nil {
+		return message{}, err
+	}
+	return message{
+		kind:    h.Kind &^ udpwire.LowPriority,
+		low:     h.Kind&udpwire.LowPriority != 0,
+		network: NetworkID(h.Network),
+		rpcID:   h.RPCID,
+		trace:   h.Trace,
+		sender:  h.Sender,
+		payload: payload,
+		signed:  pub != nil,
+		pub:     pub,
+	}, nil
+}
@@ -0,0 +1,181 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+var ErrBadRecord = errors.New("dht: malformed record")
+
+// 检查 key 与值的绑定以及值本身能否接受。SetValue 发布之前、收到 STORE 时、
+// 查找得到其他节点返回的值时以及从文件恢复记录时检查，未通过的记录不会被保存或返回
+type Validator interface {
+	Validate(key [kbucket.IdSize]byte, value []byte) error
+}
+
+// 同一个 key 有多个有效记录时选择保留哪一个，返回 values 中的下标。
+// 出错时保留已有的记录；返回 ErrConcurrentRecords 时同时保留收到的记录，见 Conflicts
+type Selector interface {
+	Select(key [kbucket.IdSize]byte, values [][]byte) (int, error)
+}
+
+// 设置检查记录的 Validator，nil 表示使用 ContentValidator
+func (p *Peer) SetValidator(v Validator) {
+	p.validator = v
+}
+
+// 设置在冲突的记录之间选择的 Selector，nil 表示总是保留先收到的记录
+func (p *Peer) SetSelector(s Selector) {
+	p.selector = s
+}
+
+func (p *Peer) validate(key [kbucket.IdSize]byte, value []byte) error {
+	err := p.validateWith(key, value)
+	if errors.Is(err, ErrKeyMismatch) && p.legacyKey(key, value) { // 兼容窗口中旧哈希的 key 仍然有效
+		return nil
+	}
+	return err
+}
+
+func (p *Peer) validateWith(key [kbucket.IdSize]byte, value []byte) error {
+	if kind, ok := p.crdtKindOf(value); ok {
+		return validateCRDT(kind, key, value)
+	}
+	if v := p.policy(Namespace(value)).Validator; v != nil {
+		return v.Validate(key, value)
+	}
+	if p.validator == nil {
+		return ContentValidator{}.Validate(key, value)
+	}
+	return p.validator.Validate(key, value)
+}
+
+// 已有 key 的记录时决定是否保留它：值相同或 Selector 选择了已有的记录时
+// 只延长有效期并返回 true；没有记录或新值胜出时返回 false，由调用方保存新值
+func (p *Peer) keepExisting(key [kbucket.IdSize]byte, value []byte) bool {
+	old, ok := p.store.get(key)
+	if !ok {
+		return false
+	}
+	if _, crdt := p.crdtKindOf(value); crdt && !bytes.Equal(old, value) {
+		return false // 由 saveValue 合并两份状态
+	}
+	if p.selector != nil && !bytes.Equal(old, value) {
+		i, err := p.selector.Select(key, [][]byte{old, value})
+		if err == nil && i == 1 {
+			return false
+		}
+		if errors.Is(err, ErrConcurrentRecords) { // 版本相同的并发写入，两个都保留
+			p.keepSibling(key, value)
+		}
+	}
+	return p.store.refresh(key)
+}
+
+// 默认的 Validator：key 必须是值的哈希（内容寻址），这样的记录之间不会冲突
+type ContentValidator struct{}
+
+func (ContentValidator) Validate(key [kbucket.IdSize]byte, value []byte) error {
+	if KeyFromBytes(value) != key {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// 在 Validator 的检查之外限制值的大小，超出时返回 ErrTooBig
+type MaxSizeValidator struct {
+	Validator Validator // nil 表示 ContentValidator
+	MaxSize   int
+}
+
+func (v MaxSizeValidator) Validate(key [kbucket.IdSize]byte, value []byte) error {
+	if len(value) > v.MaxSize {
+		return ErrTooBig
+	}
+	if v.Validator == nil {
+		return ContentValidator{}.Validate(key, value)
+	}
+	return v.Validator.Validate(key, value)
+}
+
+// 带签名的可变记录：key 为发布者公钥导出的节点 ID，值由 SignRecord 生成。
+// 发布者可以用更大的序号替换之前的记录，需要配合 SequenceSelector 使用
+type SignedValidator struct{}
+
+func (SignedValidator) Validate(key [kbucket.IdSize]byte, value []byte) error {
+	pub, _, _, err := OpenRecord(value)
+	if err != nil {
+		return err
+	}
+	if NodeIDFromPublicKey(pub) != key {
+		return ErrIdentityMismatch
+	}
+	return nil
+}
+
+// 生成签名记录：公钥(32) | 序号(8) | 数据 | 签名(64)，签名覆盖签名之前的全部内容。
+// 记录的 key 为 NodeIDFromPublicKey(priv.Public())
+func SignRecord(priv ed25519.PrivateKey, seq uint64, data []byte) []byte {
+	record := make([]byte, 0, ed25519.PublicKeySize+8+len(data)+ed25519.SignatureSize)
+	record = append(record, priv.Public().(ed25519.PublicKey)...)
+	record = binary.BigEndian.AppendUint64(record, seq)
+	record = append(record, data...)
+	return append(record, ed25519.Sign(priv, record)...)
+}
+
+// 生成墓碑记录：数据为空的签名记录，表示发布者删除了 key 的记录。
+// seq 需要大于之前发布的序号，才能经 SequenceSelector 替换旧记录
+func SignTombstone(priv ed25519.PrivateKey, seq uint64) []byte {
+	return SignRecord(priv, seq, nil)
+}
+
+// value 是否为签名有效的墓碑记录
+func IsTombstone(value []byte) bool {
+	_, _, data, err := OpenRecord(value)
+	return err == nil && len(data) == 0
+}
+
+// 检查签名记录的签名并拆分各字段，data 引用 value
+func OpenRecord(value []byte) (pub ed25519.PublicKey, seq uint64, data []byte, err error) {
+	if len(value) < ed25519.PublicKeySize+8+ed25519.SignatureSize {
+		return nil, 0, nil, ErrBadRecord
+	}
+	body := value[:len(value)-ed25519.SignatureSize]
+	pub = ed25519.PublicKey(value[:ed25519.PublicKeySize])
+	if !ed25519.Verify(pub, body, value[len(body):]) {
+		return nil, 0, nil, ErrBadSignature
+	}
+	seq = binary.BigEndian.Uint64(body[ed25519.PublicKeySize:])
+	return pub, seq, body[ed25519.PublicKeySize+8:], nil
+}
+
+// 选择序号最大的签名记录。序号最大的记录有多个且内容不同时返回 ErrConcurrentRecords，
+// 由应用在 Get 的 Values 中处理冲突（或者用自己的 Selector 包装它）
+type SequenceSelector struct{}
+
+func (SequenceSelector) Select(key [kbucket.IdSize]byte, values [][]byte) (int, error) {
+	best, bestSeq, concurrent := -1, uint64(0), false
+	for i, v := range values {
+		_, seq, _, err := OpenRecord(v)
+		if err != nil {
+			continue
+		}
+		switch {
+		case best < 0 || seq > bestSeq:
+			best, bestSeq, concurrent = i, seq, false
+		case seq == bestSeq && !bytes.Equal(v, values[best]):
+			concurrent = true
+		}
+	}
+	if best < 0 {
+		return 0, ErrBadRecord
+	}
+	if concurrent {
+		return 0, ErrConcurrentRecords
+	}
+	return best, nil
+}
@@ -0,0 +1,88 @@
+package dht
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 由公钥导出的节点 ID
+func NodeIDFromPublicKey(pub ed25519.PublicKey) [kbucket.IdSize]byte {
+	return KeyFromBytes(pub)
+}
+
+// 搜索进度，Rate 为每秒尝试的密钥数
+type GrindProgress struct {
+	Tries   uint64
+	Elapsed time.Duration
+	Rate    float64
+}
+
+// 并行生成密钥对，直到导出的节点 ID 与 prefix 的前 bits 位相同，用于在私有部署中
+// 把专用存储节点放在特定应用 key 附近。workers 为 0 时使用全部 CPU；progress
+// 不为 nil 时大约每秒回调一次。ctx 取消时返回 ctx.Err()
+func GrindKey(ctx context.Context, prefix [kbucket.IdSize]byte, bits, workers int, progress func(GrindProgress)) (ed25519.PrivateKey, [kbucket.IdSize]byte, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		priv ed25519.PrivateKey
+		id   [kbucket.IdSize]byte
+	}
+	found := make(chan result, 1)
+	var tries uint64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				pub, priv, err := ed25519.GenerateKey(rand.Reader)
+				if err != nil {
+					return
+				}
+				atomic.AddUint64(&tries, 1)
+				id := NodeIDFromPublicKey(pub)
+				if kbucket.CommonPrefixLen(id, prefix) >= bits {
+					select {
+					case found <- result{priv, id}:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	defer wg.Wait()
+	for {
+		select {
+		case r := <-found:
+			return r.priv, r.id, nil
+		case <-ctx.Done():
+			select {
+			case r := <-found:
+				return r.priv, r.id, nil
+			default:
+			}
+			return nil, [kbucket.IdSize]byte{}, ctx.Err()
+		case <-ticker.C:
+			if progress != nil {
+				n := atomic.LoadUint64(&tries)
+				elapsed := time.Since(start)
+				progress(GrindProgress{Tries: n, Elapsed: elapsed, Rate: float64(n) / elapsed.Seconds()})
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package dht
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 记录（普通记录、CRDT 记录或多值 key）的新状态
+type KeyUpdate struct {
+	Key    [kbucket.IdSize]byte
+	Value  []byte   // STORE 保存的普通记录的当前值
+	CRDT   CRDT     // CRDT 记录合并后的状态
+	Values [][]byte // 多值 key 当前有效的值
+}
+
+// 订阅方维护的记录视图，只有视图变化时才通知应用
+type keyWatch struct {
+	subs   []chan KeyUpdate
+	value  []byte
+	crdt   CRDT
+	values []string
+}
+
+// 关注 key 的变化：向负责该 key 的节点登记，之后这些节点保存了更新的记录时
+// 会把新状态推送给本节点。缓冲区满时丢弃通知。负责的节点都只能通过网络联系时
+// 返回 ErrRemoteReplicas：登记与推送都直接访问对方，没有对应的 RPC
+func (p *Peer) WatchKey(key [kbucket.IdSize]byte, buffer int) (<-chan KeyUpdate, error) {
+	holders, err := p.inProcessReplicas(key)
+	if err != nil {
+		return nil, err
+	}
+	p.watchMu.Lock()
+	if p.keyWatches == nil {
+		p.keyWatches = make(map[[kbucket.IdSize]byte]*keyWatch)
+	}
+	w, ok := p.keyWatches[key]
+	if !ok {
+		w = &keyWatch{}
+		p.keyWatches[key] = w
+	}
+	ch := make(chan KeyUpdate, buffer)
+	w.subs = append(w.subs, ch)
+	p.watchMu.Unlock()
+	for _, holder := range append([]*Peer{p}, holders...) {
+		holder.addWatcher(key, p)
+	}
+	return ch, nil
+}
+
+func (p *Peer) addWatcher(key [kbucket.IdSize]byte, watcher *Peer) {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+	if p.watchers == nil {
+		p.watchers = make(map[[kbucket.IdSize]byte][]*Peer)
+	}
+	for _, w := range p.watchers[key] {
+		if w == watcher {
+			return
+		}
+	}
+	p.watchers[key] = append(p.watchers[key], watcher)
+}
+
+// 本地记录发生变化后通知登记过的关注者。关注者在锁外通知，可能包括本节点自身
+func (p *Peer) notifyWatchers(key [kbucket.IdSize]byte) {
+	p.watchMu.Lock()
+	watchers := append([]*Peer(nil), p.watchers[key]...)
+	p.watchMu.Unlock()
+	if len(watchers) == 0 {
+		return
+	}
+	update := KeyUpdate{Key: key}
+	if r, live, ok := p.store.record(key); ok && live {
+		update.Value = r.Value
+	}
+	if v, ok := p.crdtState(key); ok {
+		update.CRDT = v
+	}
+	for _, e := range p.liveValues(key, time.Now()) {
+		update.Values = append(update.Values, e.value)
+	}
+	for _, w := range watchers {
+		w.deliverUpdate(update)
+	}
+}
+
+// 多个持有者会推送同一次更新，合并进本地视图后只在视图变化时通知应用
+func (p *Peer) deliverUpdate(update KeyUpdate) {
+	p.watchMu.Lock()
+	defer p.watchMu.Unlock()
+	w, ok := p.keyWatches[update.Key]
+	if !ok {
+		return
+	}
+	changed := false
+	if update.Value != nil && !bytes.Equal(update.Value, w.value) {
+		w.value = update.Value
+		changed = true
+	}
+	if update.CRDT != nil {
+		if w.crdt == nil {
+			w.crdt = update.CRDT.Clone()
+			changed = true
+		} else if w.crdt.Merge(update.CRDT) {
+			changed = true
+		}
+	}
+	values := make([]string, len(update.Values))
+	for i, v := range update.Values {
+		values[i] = string(v)
+	}
+	sort.Strings(values)
+	if len(update.Values) > 0 && !equalStrings(values, w.values) {
+		w.values = values
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	out := KeyUpdate{Key: update.Key, Value: w.value, Values: update.Values}
+	if w.crdt != nil {
+		out.CRDT = w.crdt.Clone()
+	}
+	for _, ch := range w.subs {
+		select {
+		case ch <- out:
+		default:
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"testing"
+)
+
+func nextUpdate(t *testing.T, ch <-chan KeyUpdate) KeyUpdate {
+	t.Helper()
+	select {
+	case u := <-ch:
+		return u
+	default:
+		t.Fatal("no update delivered")
+		return KeyUpdate{}
+	}
+}
+
+// 普通 STORE 与按序号替换的签名记录都会通知关注者
+func TestWatchKeyPlainRecords(t *testing.T) {
+	ctx := context.Background()
+	p := NewPeer(KeyFromString("watch-self"))
+	value := []byte("watch-plain")
+	key := KeyFromBytes(value)
+	ch, err := p.WatchKey(key, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetValue(ctx, key[:], value); err != nil {
+		t.Fatal(err)
+	}
+	if u := nextUpdate(t, ch); !bytes.Equal(u.Value, value) {
+		t.Fatalf("update value = %q, want %q", u.Value, value)
+	}
+	p.SetValue(ctx, key[:], value) // 重复的 STORE 只延长有效期，不通知
+	if len(ch) != 0 {
+		t.Fatal("republishing an unchanged record notified watchers")
+	}
+
+	_, priv, _ := ed25519.GenerateKey(nil)
+	p.SetValidator(SignedValidator{})
+	p.SetSelector(SequenceSelector{})
+	id := NodeIDFromPublicKey(priv.Public().(ed25519.PublicKey))
+	ch, err = p.WatchKey(id, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		record := SignRecord(priv, seq, []byte{byte(seq)})
+		if _, err := p.SetValue(ctx, id[:], record); err != nil {
+			t.Fatal(err)
+		}
+		if u := nextUpdate(t, ch); !bytes.Equal(u.Value, record) {
+			t.Fatalf("update after seq %d carries another record", seq)
+		}
+	}
+}
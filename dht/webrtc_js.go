@@ -0,0 +1,273 @@
+//go:build js && wasm
+
+package dht
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall/js"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+var ErrNotWebSocketTransport = errors.New("dht: transport does not run over websocket")
+
+// 浏览器节点之间的 WebRTC 连接。两个节点经由双方都连接着的中继（通常是种子节点）
+// 用 SIGNAL 交换 SDP 与 ICE 候选，数据通道打开后，发给对方的数据包直接经由数据通道发送，
+// 不再需要中继。数据通道不保证顺序、不重传，与 UDP 的语义一致，重发仍由 UDPTransport 负责。
+// 到同一个节点只协商一次，已有连接时直接复用
+type WebRTC struct {
+	ICEServers []string // STUN/TURN 服务器的 URL，为空时只使用本地与对端反射候选
+
+	t    *UDPTransport
+	conn *WebSocketConn
+
+	mu       sync.Mutex
+	sessions map[[kbucket.IdSize]byte]*rtcSession
+}
+
+// 一次协商。远端描述设置之前收到的 ICE 候选先保存在 pending 中
+type rtcSession struct {
+	pc      js.Value
+	addr    *net.UDPAddr // 网络中其他节点认识对方的地址，数据通道以它登记
+	relay   *net.UDPAddr
+	peer    [kbucket.IdSize]byte
+	mu      sync.Mutex
+	remote  bool
+	pending []js.Value
+	funcs   []js.Func
+	done    chan error // 数据通道打开或协商失败
+}
+
+// 在经由 WebSocket 加入网络的 t 上接受与发起 WebRTC 连接，并把 t 的信令交给它处理
+func NewWebRTC(t *UDPTransport, iceServers ...string) (*WebRTC, error) {
+	conn, ok := t.mux.conn.(*WebSocketConn)
+	if !ok {
+		return nil, ErrNotWebSocketTransport
+	}
+	w := &WebRTC{ICEServers: iceServers, t: t, conn: conn, sessions: make(map[[kbucket.IdSize]byte]*rtcSession)}
+	t.SetSignalHandler(w.onSignal)
+	return w, nil
+}
+
+// 经由 relay 与 target 协商 WebRTC 连接，等待数据通道打开。target.Addr 为网络中其他节点
+// 认识它的地址（即中继联系它的地址）；已有到该地址的连接时立即返回
+func (w *WebRTC) Connect(ctx context.Context, relay *net.UDPAddr, target Contact) error {
+	if w.conn.connected(target.Addr) {
+		return nil
+	}
+	s, created := w.session(target.ID, target.Addr, relay)
+	if created {
+		dc := s.pc.Call("createDataChannel", "dht", js.ValueOf(map[string]any{"ordered": false, "maxRetransmits": 0}))
+		w.openChannel(s, dc)
+		go w.offer(s)
+	}
+	select {
+	case err := <-s.done:
+		s.done <- err // 同时等待的调用方也能收到结果
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 找到或创建与 peer 的会话
+func (w *WebRTC) session(peer [kbucket.IdSize]byte, addr, relay *net.UDPAddr) (*rtcSession, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if s, ok := w.sessions[peer]; ok {
+		return s, false
+	}
+	var servers []any
+	for _, url := range w.ICEServers {
+		servers = append(servers, map[string]any{"urls": url})
+	}
+	s := &rtcSession{
+		pc:    js.Global().Get("RTCPeerConnection").New(js.ValueOf(map[string]any{"iceServers": servers})),
+		addr:  addr,
+		relay: relay,
+		peer:  peer,
+		done:  make(chan error, 1),
+	}
+	s.on(s.pc, "icecandidate", func(ev js.Value) {
+		if c := ev.Get("candidate"); !c.IsNull() && !c.IsUndefined() {
+			data := []byte(js.Global().Get("JSON").Call("stringify", c).String())
+			go w.t.Signal(s.relay, s.peer, SignalCandidate, data) // 回调中不能等待网络
+		}
+	})
+	s.on(s.pc, "connectionstatechange", func(js.Value) {
+		if state := s.pc.Get("connectionState").String(); state == "failed" || state == "closed" {
+			go w.close(s, errJSConnClosed)
+		}
+	})
+	s.on(s.pc, "datachannel", func(ev js.Value) { w.openChannel(s, ev.Get("channel")) })
+	w.sessions[peer] = s
+	return s, true
+}
+
+func (s *rtcSession) on(obj js.Value, event string, f func(js.Value)) {
+	fn := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		f(args[0])
+		return nil
+	})
+	s.mu.Lock()
+	s.funcs = append(s.funcs, fn)
+	s.mu.Unlock()
+	obj.Call("addEventListener", event, fn)
+}
+
+// 数据通道打开后交给 WebSocketConn，关闭后结束会话
+func (w *WebRTC) openChannel(s *rtcSession, dc js.Value) {
+	c := newJSMessageConn(dc)
+	go func() {
+		if err := c.waitOpen(context.Background()); err != nil {
+			w.close(s, err)
+			return
+		}
+		s.finish(nil)
+		w.conn.adopt(c, s.addr)
+		w.close(s, nil)
+	}()
+}
+
+func (s *rtcSession) finish(err error) {
+	select {
+	case s.done <- err:
+	default:
+	}
+}
+
+// 结束会话并关闭 RTCPeerConnection
+func (w *WebRTC) close(s *rtcSession, err error) {
+	w.mu.Lock()
+	if w.sessions[s.peer] == s {
+		delete(w.sessions, s.peer)
+	}
+	w.mu.Unlock()
+	s.finish(err)
+	s.pc.Call("close")
+	s.mu.Lock()
+	for _, fn := range s.funcs {
+		fn.Release()
+	}
+	s.funcs = nil
+	s.mu.Unlock()
+}
+
+// 发起方：生成 offer 并经由中继发送
+func (w *WebRTC) offer(s *rtcSession) {
+	desc, err := await(s.pc.Call("createOffer"))
+	if err == nil {
+		_, err = await(s.pc.Call("setLocalDescription", desc))
+	}
+	if err == nil {
+		err = w.t.Signal(s.relay, s.peer, SignalOffer, describe(s.pc.Get("localDescription")))
+	}
+	if err != nil {
+		w.close(s, err)
+	}
+}
+
+// 处理经由中继收到的信令
+func (w *WebRTC) onSignal(sig Signal) {
+	switch sig.Kind {
+	case SignalOffer: // 应答方：以中继联系发起方的地址登记数据通道
+		s, _ := w.session(sig.From, sig.FromAddr, sig.Relay)
+		if err := w.setRemote(s, sig.Data); err != nil {
+			w.close(s, err)
+			return
+		}
+		desc, err := await(s.pc.Call("createAnswer"))
+		if err == nil {
+			_, err = await(s.pc.Call("setLocalDescription", desc))
+		}
+		if err == nil {
+			err = w.t.Signal(s.relay, s.peer, SignalAnswer, describe(s.pc.Get("localDescription")))
+		}
+		if err != nil {
+			w.close(s, err)
+		}
+	case SignalAnswer:
+		if s := w.lookup(sig.From); s != nil {
+			if err := w.setRemote(s, sig.Data); err != nil {
+				w.close(s, err)
+			}
+		}
+	case SignalCandidate:
+		if s := w.lookup(sig.From); s != nil {
+			s.addCandidate(js.Global().Get("JSON").Call("parse", string(sig.Data)))
+		}
+	case SignalBye:
+		if s := w.lookup(sig.From); s != nil {
+			w.close(s, errJSConnClosed)
+		}
+	}
+}
+
+func (w *WebRTC) lookup(peer [kbucket.IdSize]byte) *rtcSession {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sessions[peer]
+}
+
+// 设置远端描述，然后加入之前收到的 ICE 候选
+func (w *WebRTC) setRemote(s *rtcSession, data []byte) error {
+	desc := js.Global().Get("JSON").Call("parse", string(data))
+	if _, err := await(s.pc.Call("setRemoteDescription", desc)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.remote = true
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	for _, c := range pending {
+		s.pc.Call("addIceCandidate", c)
+	}
+	return nil
+}
+
+func (s *rtcSession) addCandidate(c js.Value) {
+	s.mu.Lock()
+	if !s.remote {
+		s.pending = append(s.pending, c)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.pc.Call("addIceCandidate", c)
+}
+
+// 把 RTCSessionDescription 编码为 JSON
+func describe(desc js.Value) []byte {
+	return []byte(js.Global().Get("JSON").Call("stringify", desc).String())
+}
+
+// 等待 JavaScript 的 Promise。不能在事件回调中调用
+func await(promise js.Value) (js.Value, error) {
+	values := make(chan js.Value, 1)
+	errs := make(chan error, 1)
+	then := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		v := js.Undefined()
+		if len(args) > 0 {
+			v = args[0]
+		}
+		values <- v
+		return nil
+	})
+	defer then.Release()
+	catch := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		errs <- js.Error{Value: args[0]}
+		return nil
+	})
+	defer catch.Release()
+	promise.Call("then", then, catch)
+	select {
+	case v := <-values:
+		return v, nil
+	case err := <-errs:
+		return js.Undefined(), err
+	}
+}
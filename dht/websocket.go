@@ -0,0 +1,513 @@
+package dht
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	webSocketPath = "/dht"                                 // 节点接受 WebSocket 连接的路径
+	webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // RFC 6455 计算 Sec-WebSocket-Accept 使用的常量
+
+	wsBacklog = 64 // 尚未被读循环取走的数据包数
+)
+
+// WebSocket 帧的操作码
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var ErrNotWebSocket = errors.New("dht: peer did not accept the websocket upgrade")
+
+// 以消息为单位收发的 WebSocket 连接。原生实现见 wsStream，浏览器中使用 JavaScript 的 WebSocket
+type wsMessageConn interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(b []byte) error
+	Close() error
+}
+
+// 把 UDP 数据包承载在 WebSocket 上的 PacketConn，使浏览器中的节点（GOOS=js GOARCH=wasm）
+// 可以加入网络。每个数据包是一条二进制消息，消息格式、签名与重发都与 UDP 相同。
+// 对方仍以 *net.UDPAddr 表示，其 "host:port" 是对方接受 WebSocket 连接的地址；
+// 发起连接时在 URL 中声明本节点的监听端口，对方据此把连接登记为本节点的地址，
+// 之后两个方向的请求都复用这条连接。没有监听地址的节点（例如浏览器）只能通过它发起的连接联系
+type WebSocketConn struct {
+	local *net.UDPAddr // 声明的监听地址，nil 表示只发起连接
+	ln    net.Listener
+	srv   *http.Server
+	dial  func(ctx context.Context, url string) (wsMessageConn, error)
+	tls   bool // 以 wss 联系其他节点
+
+	mu      sync.Mutex
+	peers   map[string]*wsPeer     // 发送使用的连接，以对方的 "host:port" 为键
+	conns   map[wsMessageConn]bool // 所有打开的连接，包括只用于接收的
+	packets chan wsPacket
+	done    chan struct{}
+	closed  sync.Once
+}
+
+// 到一个地址的连接，写入由 mu 串行化
+type wsPeer struct {
+	mu   sync.Mutex
+	conn wsMessageConn
+}
+
+type wsPacket struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// 在 addr 上接受 WebSocket 连接并让 p 通过它加入网络，返回的 UDPTransport 与 ListenUDP 的相同。
+// tlsConfig 不为 nil 时使用 wss：它需要包含本节点的证书，以及验证其他节点证书的 RootCAs
+func ListenWebSocket(p *Peer, addr string, tlsConfig *tls.Config) (*UDPTransport, error) {
+	if p.transport != nil {
+		return nil, ErrTransportUp
+	}
+	if !p.acquire(ResourceSockets, "listen") {
+		return nil, ErrBusy
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		p.release(ResourceSockets)
+		return nil, err
+	}
+	tcp := ln.Addr().(*net.TCPAddr)
+	c := newWebSocketConn(p, &net.UDPAddr{IP: tcp.IP, Port: tcp.Port, Zone: tcp.Zone}, tlsConfig)
+	c.ln = limitedListener{Listener: ln, p: p}
+	if tlsConfig != nil {
+		c.ln = tls.NewListener(c.ln, tlsConfig.Clone())
+	}
+	mux := http.NewServeMux()
+	mux.Handle(webSocketPath, c)
+	c.srv = &http.Server{Handler: mux}
+	go c.srv.Serve(c.ln)
+	return c.attach(p)
+}
+
+// 让 p 只通过发起的 WebSocket 连接加入网络，用于无法监听端口的浏览器节点。
+// 种子节点的地址为其 WebSocket 监听地址；tlsConfig 不为 nil 时使用 wss
+func DialWebSocket(p *Peer, tlsConfig *tls.Config) (*UDPTransport, error) {
+	if p.transport != nil {
+		return nil, ErrTransportUp
+	}
+	if !p.acquire(ResourceSockets, "listen") { // 与监听一样在关闭时归还
+		return nil, ErrBusy
+	}
+	return newWebSocketConn(p, nil, tlsConfig).attach(p)
+}
+
+func newWebSocketConn(p *Peer, local *net.UDPAddr, tlsConfig *tls.Config) *WebSocketConn {
+	return &WebSocketConn{
+		local:   local,
+		dial:    webSocketDialer(p, tlsConfig),
+		tls:     tlsConfig != nil,
+		peers:   make(map[string]*wsPeer),
+		conns:   make(map[wsMessageConn]bool),
+		packets: make(chan wsPacket, wsBacklog),
+		done:    make(chan struct{}),
+	}
+}
+
+// 与 ListenUDP 一样，关闭返回的 UDPTransport 时关闭连接并归还 socket 配额
+func (c *WebSocketConn) attach(p *Peer) (*UDPTransport, error) {
+	mux := NewMux(c)
+	t, err := mux.Attach(p, DefaultNetwork)
+	if err != nil {
+		mux.Close()
+		p.release(ResourceSockets)
+		return nil, err
+	}
+	t.owned = true
+	return t, nil
+}
+
+func (c *WebSocketConn) LocalAddr() net.Addr {
+	if c.local == nil {
+		return &net.UDPAddr{}
+	}
+	return c.local
+}
+
+func (c *WebSocketConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case pkt := <-c.packets:
+		return copy(b, pkt.data), pkt.from, nil
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// 经由到 addr 的连接发送一个数据包，还没有连接时先建立连接
+func (c *WebSocketConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	key := addr.String()
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	default:
+	}
+	peer, ok := c.peers[key]
+	if !ok {
+		peer = &wsPeer{}
+		c.peers[key] = peer
+	}
+	c.mu.Unlock()
+
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.conn == nil {
+		conn, err := c.connect(addr)
+		if err != nil {
+			c.forget(key, peer)
+			return 0, err
+		}
+		if !c.track(conn) {
+			c.forget(key, peer)
+			return 0, net.ErrClosed
+		}
+		peer.conn = conn
+		go c.readLoop(key, peer, conn, addr)
+	}
+	if err := peer.conn.WriteMessage(b); err != nil {
+		peer.conn.Close()
+		c.forget(key, peer)
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 连接 addr 上的节点，URL 中附带本节点的监听端口
+func (c *WebSocketConn) connect(addr *net.UDPAddr) (wsMessageConn, error) {
+	scheme := "ws://"
+	if c.tls {
+		scheme = "wss://"
+	}
+	url := scheme + addr.String() + webSocketPath
+	if c.local != nil {
+		url += "?port=" + strconv.Itoa(c.local.Port)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultRPCTimeout)
+	defer cancel()
+	return c.dial(ctx, url)
+}
+
+// 把连接上收到的数据包交给 UDPMux 的读循环，连接断开后不再用它发送
+func (c *WebSocketConn) readLoop(key string, peer *wsPeer, conn wsMessageConn, from *net.UDPAddr) {
+	defer func() {
+		conn.Close()
+		c.mu.Lock()
+		delete(c.conns, conn)
+		c.mu.Unlock()
+		peer.mu.Lock()
+		if peer.conn == conn {
+			peer.conn = nil
+		}
+		idle := peer.conn == nil // 只用于接收的连接断开时保留发送使用的连接
+		peer.mu.Unlock()
+		if idle {
+			c.forget(key, peer)
+		}
+	}()
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		select {
+		case c.packets <- wsPacket{data: data, from: from}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 登记新的连接，已经关闭时关闭它并返回 false
+func (c *WebSocketConn) track(conn wsMessageConn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		conn.Close()
+		return false
+	default:
+	}
+	c.conns[conn] = true
+	return true
+}
+
+func (c *WebSocketConn) forget(key string, peer *wsPeer) {
+	c.mu.Lock()
+	if c.peers[key] == peer {
+		delete(c.peers, key)
+	}
+	c.mu.Unlock()
+}
+
+// 接受其他节点发起的连接。对方声明了监听端口时以来源 IP 与该端口登记连接，
+// 否则以连接的来源地址登记
+func (c *WebSocketConn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := acceptWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	from := &net.UDPAddr{IP: remote.IP, Port: remote.Port, Zone: remote.Zone}
+	if port, err := strconv.Atoi(r.URL.Query().Get("port")); err == nil && port > 0 && port <= 0xffff {
+		from.Port = port
+	}
+	c.adopt(conn, from)
+}
+
+// 接收 from 建立的连接上的数据包直到连接断开，还没有到 from 的连接时也用它发送。
+// 除了 WebSocket，也用于浏览器节点之间的 WebRTC 数据通道
+func (c *WebSocketConn) adopt(conn wsMessageConn, from *net.UDPAddr) {
+	if !c.track(conn) {
+		return
+	}
+	key := from.String()
+	c.mu.Lock()
+	peer, ok := c.peers[key]
+	if !ok {
+		peer = &wsPeer{}
+		c.peers[key] = peer
+	}
+	c.mu.Unlock()
+	peer.mu.Lock()
+	if peer.conn == nil { // 已有到对方的连接时继续使用它发送，新连接只用于接收
+		peer.conn = conn
+	}
+	peer.mu.Unlock()
+	c.readLoop(key, peer, conn, from)
+}
+
+// 是否有到 addr 的连接
+func (c *WebSocketConn) connected(addr *net.UDPAddr) bool {
+	c.mu.Lock()
+	peer, ok := c.peers[addr.String()]
+	c.mu.Unlock()
+	if !ok {
+		return false
+	}
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return peer.conn != nil
+}
+
+// 关闭监听与所有连接
+func (c *WebSocketConn) Close() error {
+	var err error
+	c.closed.Do(func() {
+		c.mu.Lock()
+		close(c.done)
+		conns := c.conns
+		c.conns = make(map[wsMessageConn]bool)
+		c.mu.Unlock()
+		if c.srv != nil {
+			err = c.srv.Close() // 不包括已经接管的连接
+		}
+		for conn := range conns {
+			conn.Close()
+		}
+	})
+	return err
+}
+
+// 完成服务端的握手并接管底层连接
+func acceptWebSocket(w http.ResponseWriter, r *http.Request) (wsMessageConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, ErrNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil, ErrNotWebSocket
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsStream{conn: conn, r: rw.Reader}, nil
+}
+
+// header 中以逗号分隔的某个值等于 value（不区分大小写）
+func headerHas(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// 原生的 WebSocket 连接：按 RFC 6455 收发帧。客户端发送的帧需要掩码，
+// 服务端只接受带掩码的帧。一条消息超过 maxPacketSize 时关闭连接
+type wsStream struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool
+	wmu    sync.Mutex // 串行化写入，读取时回复 PING 与 CLOSE 也需要写
+}
+
+func (s *wsStream) ReadMessage() ([]byte, error) {
+	var msg []byte
+	started := false
+	for {
+		fin, op, payload, err := s.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsPing:
+			s.writeFrame(true, wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			s.writeFrame(true, wsClose, nil)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if started {
+				return nil, ErrBadPacket
+			}
+			started = true
+		case wsContinuation:
+			if !started {
+				return nil, ErrBadPacket
+			}
+		default:
+			return nil, ErrBadPacket
+		}
+		if len(msg)+len(payload) > maxPacketSize {
+			return nil, ErrTooBig
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (s *wsStream) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(s.r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	if masked == s.client { // 客户端的帧必须带掩码，服务端的帧不能带掩码
+		return false, 0, nil, ErrBadPacket
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(s.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(s.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > maxPacketSize {
+		return false, 0, nil, ErrTooBig
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(s.r, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(s.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+func (s *wsStream) WriteMessage(b []byte) error {
+	return s.writeFrame(true, wsBinary, b)
+}
+
+// 写一个帧，fin 为 false 时之后还有同一条消息的分片
+func (s *wsStream) writeFrame(fin bool, op byte, payload []byte) error {
+	frame := []byte{op, 0}
+	if fin {
+		frame[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		frame[1] = byte(n)
+	case n <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if !s.client {
+		frame = append(frame, payload...)
+	} else {
+		frame[1] |= 0x80
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := s.conn.Write(frame)
+	return err
+}
+
+func (s *wsStream) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,79 @@
+//go:build !(js && wasm)
+
+package dht
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 原生的 WebSocket 客户端：经由 Peer 的 socket 配额建立 TCP（wss 时再加上 TLS）连接并完成握手
+func webSocketDialer(p *Peer, tlsConfig *tls.Config) func(ctx context.Context, rawURL string) (wsMessageConn, error) {
+	var d net.Dialer
+	dial := p.dialLimited(d.DialContext)
+	return func(ctx context.Context, rawURL string) (wsMessageConn, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, "tcp", u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+		}
+		if u.Scheme == "wss" {
+			cfg := tlsConfig.Clone()
+			if cfg.ServerName == "" {
+				cfg.ServerName = u.Hostname()
+			}
+			tc := tls.Client(conn, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+			}
+			conn = tc
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		s, err := clientHandshake(conn, u)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		return s, nil
+	}
+}
+
+// 发送升级请求并检查 101 响应与 Sec-WebSocket-Accept
+func clientHandshake(conn net.Conn, u *url.URL) (*wsStream, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: make(http.Header)}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, fmt.Errorf("%w: http %s", ErrNotWebSocket, resp.Status)
+	}
+	return &wsStream{conn: conn, r: r, client: true}, nil
+}
@@ -0,0 +1,139 @@
+//go:build js && wasm
+
+package dht
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall/js"
+)
+
+var errJSConnClosed = errors.New("dht: connection closed")
+
+// 浏览器中的 WebSocket 客户端：使用 JavaScript 的 WebSocket，TLS 由浏览器处理，
+// 以 wss 联系其他节点时 tlsConfig 只需不为 nil
+func webSocketDialer(p *Peer, tlsConfig *tls.Config) func(ctx context.Context, url string) (wsMessageConn, error) {
+	return func(ctx context.Context, url string) (wsMessageConn, error) {
+		ctor := js.Global().Get("WebSocket")
+		if ctor.IsUndefined() {
+			return nil, fmt.Errorf("%w: no WebSocket in this environment", ErrUnreachable)
+		}
+		c := newJSMessageConn(ctor.New(url))
+		if err := c.waitOpen(ctx); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUnreachable, url, err)
+		}
+		return c, nil
+	}
+}
+
+// 以消息为单位收发二进制数据的 JavaScript 对象：WebSocket 或 RTCDataChannel。
+// 事件回调在 JavaScript 的事件循环中执行，只把消息放进 messages
+type jsMessageConn struct {
+	obj      js.Value
+	messages chan []byte
+	opened   chan struct{}
+	funcs    []js.Func
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newJSMessageConn(obj js.Value) *jsMessageConn {
+	c := &jsMessageConn{
+		obj:      obj,
+		messages: make(chan []byte, wsBacklog),
+		opened:   make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	obj.Set("binaryType", "arraybuffer")
+	var openOnce sync.Once
+	c.on("open", func(js.Value) { openOnce.Do(func() { close(c.opened) }) })
+	if obj.Get("readyState").String() == "open" { // 已经打开的数据通道不会再触发 open
+		openOnce.Do(func() { close(c.opened) })
+	}
+	c.on("error", func(js.Value) { c.shutdown() })
+	c.on("close", func(js.Value) { // 之后不再有事件，释放回调
+		c.shutdown()
+		for _, fn := range c.funcs {
+			fn.Release()
+		}
+	})
+	c.on("message", func(ev js.Value) {
+		data := ev.Get("data")
+		if !data.InstanceOf(js.Global().Get("ArrayBuffer")) || data.Get("byteLength").Int() > maxPacketSize {
+			return // 只接受二进制消息
+		}
+		msg := make([]byte, data.Get("byteLength").Int())
+		js.CopyBytesToGo(msg, js.Global().Get("Uint8Array").New(data))
+		select {
+		case c.messages <- msg:
+		default: // 读循环跟不上时丢弃，与 UDP 丢包相同
+		}
+	})
+	return c
+}
+
+func (c *jsMessageConn) on(event string, f func(js.Value)) {
+	fn := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		ev := js.Undefined()
+		if len(args) > 0 {
+			ev = args[0]
+		}
+		f(ev)
+		return nil
+	})
+	c.funcs = append(c.funcs, fn)
+	c.obj.Call("addEventListener", event, fn)
+}
+
+// 等待连接打开，失败或 ctx 结束时关闭连接
+func (c *jsMessageConn) waitOpen(ctx context.Context) error {
+	select {
+	case <-c.opened:
+		return nil
+	case <-c.closed:
+		c.Close()
+		return errJSConnClosed
+	case <-ctx.Done():
+		c.Close()
+		return ctx.Err()
+	}
+}
+
+func (c *jsMessageConn) shutdown() {
+	c.once.Do(func() { close(c.closed) })
+}
+
+func (c *jsMessageConn) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-c.messages:
+		return msg, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *jsMessageConn) WriteMessage(msg []byte) error {
+	select {
+	case <-c.closed:
+		return errJSConnClosed
+	default:
+	}
+	if c.obj.Get("readyState").String() != "open" { // send 在其他状态下抛出异常
+		return errJSConnClosed
+	}
+	buf := js.Global().Get("Uint8Array").New(len(msg))
+	js.CopyBytesToJS(buf, msg)
+	c.obj.Call("send", buf)
+	return nil
+}
+
+func (c *jsMessageConn) Close() error {
+	c.shutdown()
+	c.obj.Call("close")
+	return nil
+}
@@ -0,0 +1,116 @@
+package dht
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+)
+
+func listenWebSocketPeer(t *testing.T, p *Peer) (*UDPTransport, *WebSocketConn) {
+	t.Helper()
+	tr, err := ListenWebSocket(p, "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr, tr.mux.conn.(*WebSocketConn)
+}
+
+func (c *WebSocketConn) openConns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+// 两个监听 WebSocket 的节点之间的 RPC，反方向的请求复用同一条连接
+func TestWebSocketRPCs(t *testing.T) {
+	a, b := NewPeer(KeyFromString("ws-a")), NewPeer(KeyFromString("ws-b"))
+	ta, ca := listenWebSocketPeer(t, a)
+	tb, cb := listenWebSocketPeer(t, b)
+
+	if id, err := ta.Ping(tb.Addr()); err != nil || id != b.ID() {
+		t.Fatalf("Ping = %x, %v", id[:4], err)
+	}
+	value := []byte("ws-value")
+	key := KeyFromBytes(value)
+	if err := ta.Store(tb.Addr(), key, value); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if got, _, err := ta.FindValue(tb.Addr(), key); err != nil || string(got) != string(value) {
+		t.Fatalf("FindValue = %q, %v, want %q", got, err, value)
+	}
+	if id, err := tb.Ping(ta.Addr()); err != nil || id != a.ID() {
+		t.Fatalf("Ping back = %x, %v", id[:4], err)
+	}
+	if ca.openConns() != 1 || cb.openConns() != 1 {
+		t.Fatalf("open connections = %d, %d, want one shared connection", ca.openConns(), cb.openConns())
+	}
+}
+
+// 只发起连接的节点（浏览器中的节点）通过种子加入网络，发布与读取记录，
+// 种子经由同一条连接向它发出请求
+func TestWebSocketDialOnly(t *testing.T) {
+	seed := NewPeer(KeyFromString("ws-seed"))
+	ts, _ := listenWebSocketPeer(t, seed)
+	browser := NewPeer(KeyFromString("ws-browser"))
+	tb, err := DialWebSocket(browser, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tb.Close()
+
+	if err := browser.Bootstrap([]Contact{{Addr: ts.Addr()}}); err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	value := []byte("ws-browser-value")
+	key := KeyFromBytes(value)
+	if n, err := browser.SetValue(context.Background(), key[:], value); err != nil || n != 2 {
+		t.Fatalf("SetValue = %d, %v, want 2 copies", n, err)
+	}
+	if v, ok := seed.store.get(key); !ok || string(v) != string(value) {
+		t.Fatal("seed did not store the value published over the websocket")
+	}
+	c, ok := seed.kb.GetBucket(seed.kb.BucketIndex(browser.ID())).FindNode(browser.ID())
+	if !ok {
+		t.Fatal("seed did not learn the browser peer")
+	}
+	if id, err := ts.Ping(c.Data.(*net.UDPAddr)); err != nil || id != browser.ID() {
+		t.Fatalf("seed Ping over the browser's connection = %x, %v", id[:4], err)
+	}
+}
+
+// 分片的消息与其间的 PING 控制帧；超过数据包大小的消息关闭连接
+func TestWebSocketFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	cs := &wsStream{conn: client, r: bufio.NewReader(client), client: true}
+	ss := &wsStream{conn: server, r: bufio.NewReader(server)}
+
+	go func() {
+		cs.writeFrame(false, wsBinary, []byte("frag"))
+		cs.writeFrame(true, wsPing, []byte("p"))
+		cs.writeFrame(true, wsContinuation, []byte("mented"))
+	}()
+	pong := make(chan string, 1)
+	go func() {
+		_, op, payload, err := cs.readFrame()
+		if err == nil && op == wsPong {
+			pong <- string(payload)
+		}
+		close(pong)
+	}()
+	msg, err := ss.ReadMessage()
+	if err != nil || string(msg) != "fragmented" {
+		t.Fatalf("ReadMessage = %q, %v, want the reassembled message", msg, err)
+	}
+	if got := <-pong; got != "p" {
+		t.Fatalf("pong = %q, want the ping payload", got)
+	}
+
+	go cs.WriteMessage(make([]byte, maxPacketSize+1))
+	if _, err := ss.ReadMessage(); err != ErrTooBig {
+		t.Fatalf("oversized message = %v, want ErrTooBig", err)
+	}
+}
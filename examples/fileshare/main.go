@@ -0,0 +1,193 @@
+// fileshare 演示在 DHT 上共享文件：文件被切成固定大小的分块，每个分块以自身的
+// 哈希作为 key 保存；再保存一份列出全部分块 key 的清单。知道清单 key 的节点
+// 可以取回清单、逐块下载并校验整个文件。
+//
+// 不指定 -file 时共享一段生成的数据。从另一个节点下载后与原文件比较，
+// 不一致时以非零状态退出，可以作为集成冒烟测试
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const manifestMagic = "fileshare/1"
+
+// 清单：第一行为 "fileshare/1 <文件名> <大小> <sha256>"，之后每行一个分块的 key
+type manifest struct {
+	name   string
+	size   int
+	sum    [sha256.Size]byte
+	chunks [][kbucket.IdSize]byte
+}
+
+func (m manifest) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %d %x\n", manifestMagic, m.name, m.size, m.sum)
+	for _, key := range m.chunks {
+		fmt.Fprintf(&b, "%x\n", key)
+	}
+	return b.Bytes()
+}
+
+func decodeManifest(data []byte) (manifest, error) {
+	var m manifest
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	header := strings.Fields(lines[0])
+	if len(header) != 4 || header[0] != manifestMagic {
+		return m, errors.New("不是文件清单")
+	}
+	m.name = header[1]
+	size, err := strconv.Atoi(header[2])
+	sum, err2 := hex.DecodeString(header[3])
+	if err != nil || err2 != nil || len(sum) != sha256.Size {
+		return m, errors.New("清单头部损坏")
+	}
+	m.size = size
+	copy(m.sum[:], sum)
+	for _, line := range lines[1:] {
+		raw, err := hex.DecodeString(line)
+		if err != nil || len(raw) != kbucket.IdSize {
+			return m, fmt.Errorf("清单中的分块 key 损坏: %q", line)
+		}
+		var key [kbucket.IdSize]byte
+		copy(key[:], raw)
+		m.chunks = append(m.chunks, key)
+	}
+	return m, nil
+}
+
+func put(ctx context.Context, p *dht.Peer, value []byte) ([kbucket.IdSize]byte, error) {
+	key := dht.KeyFromBytes(value)
+	n, err := p.SetValue(ctx, key[:], value)
+	if err == nil && n == 0 {
+		err = errors.New("没有节点保存")
+	}
+	return key, err
+}
+
+// 切块并发布文件，返回清单的 key。全部分块与清单先在本地一起保存（见 dht.Peer.StoreLocal），
+// 共享节点不会只留下部分分块，再逐个发布到其他节点
+func share(ctx context.Context, p *dht.Peer, name string, data []byte, chunkSize int) ([kbucket.IdSize]byte, error) {
+	m := manifest{name: name, size: len(data), sum: sha256.Sum256(data)}
+	var batch dht.LocalBatch
+	var values [][]byte
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		key := dht.KeyFromBytes(data[off:end])
+		batch.Put(key, data[off:end])
+		values = append(values, data[off:end])
+		m.chunks = append(m.chunks, key)
+	}
+	key := dht.KeyFromBytes(m.encode())
+	batch.Put(key, m.encode())
+	if err := p.StoreLocal(&batch); err != nil {
+		return key, err
+	}
+	for i, value := range values {
+		if _, err := put(ctx, p, value); err != nil {
+			return key, fmt.Errorf("分块 %d: %v", i, err)
+		}
+	}
+	return put(ctx, p, m.encode())
+}
+
+// 按清单下载文件并校验大小与哈希
+func fetch(ctx context.Context, p *dht.Peer, key [kbucket.IdSize]byte) (string, []byte, error) {
+	raw, err := p.GetValue(ctx, key)
+	if err != nil {
+		return "", nil, fmt.Errorf("清单: %v", err)
+	}
+	m, err := decodeManifest(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	data := make([]byte, 0, m.size)
+	for i, chunk := range m.chunks {
+		part, err := p.GetValue(ctx, chunk)
+		if err != nil {
+			return "", nil, fmt.Errorf("分块 %d: %v", i, err)
+		}
+		data = append(data, part...)
+	}
+	if len(data) != m.size || sha256.Sum256(data) != m.sum {
+		return "", nil, errors.New("文件校验失败")
+	}
+	return m.name, data, nil
+}
+
+func main() {
+	peers := flag.Int("peers", 64, "节点数量")
+	file := flag.String("file", "", "要共享的文件，为空时共享生成的数据")
+	size := flag.Int("size", 256<<10, "未指定 -file 时生成的数据大小")
+	chunkSize := flag.Int("chunk", 4096, "分块大小")
+	out := flag.String("out", "", "把下载的文件写到这里")
+	flag.Parse()
+
+	name, data := "generated.bin", make([]byte, *size)
+	rand.New(rand.NewSource(1)).Read(data)
+	if *file != "" {
+		var err error
+		if data, err = os.ReadFile(*file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		name = filepath.Base(*file)
+	}
+	if *chunkSize < 1 || len(data) == 0 {
+		fmt.Fprintln(os.Stderr, "fileshare: 分块大小与文件都不能为空")
+		os.Exit(2)
+	}
+
+	network := make([]*dht.Peer, *peers)
+	for i := range network {
+		network[i] = dht.NewPeer(dht.KeyFromString(fmt.Sprintf("fileshare-%d", i)))
+		if i > 0 {
+			if err := network[i].Bootstrap([]dht.Contact{{Peer: network[0]}}); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	seeder, leecher := network[0], network[len(network)-1]
+	key, err := share(ctx, seeder, strings.ReplaceAll(name, " ", "_"), data, *chunkSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fileshare: 共享失败:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("共享 %s（%d 字节，%d 个分块），清单 key %x\n", name, len(data), (len(data)+*chunkSize-1) / *chunkSize, key)
+
+	gotName, got, err := fetch(ctx, leecher, key)
+	if err == nil && !bytes.Equal(got, data) {
+		err = errors.New("下载的内容与原文件不同")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fileshare: 下载失败:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("节点 %x 下载了 %s 并通过校验\n", leecher.ID(), gotName)
+	if *out != "" {
+		if err := os.WriteFile(*out, got, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
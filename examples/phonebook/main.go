@@ -0,0 +1,154 @@
+// phonebook 是建立在 DHT 上的分布式电话簿：每个名字对应一个 LWW 寄存器，
+// 任何节点都可以登记或修改号码，从其他节点都能查到最新的号码。
+// MergeCRDT 把寄存器 STORE 到距离名字最近的节点并在那里合并，GetCRDT 合并这些节点
+// 返回的状态，因此在哪个节点上读写都能得到最新的号码。
+//
+// 默认运行一段固定的脚本并检查结果，失败时以非零状态退出，可以作为集成冒烟测试；
+// 使用 -i 从标准输入读取命令：
+//
+//	add <name> <number>   登记或修改号码
+//	get <name>            查询号码
+//	peer <n>              切换到第 n 个节点执行之后的命令
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+)
+
+const namespace = "phonebook"
+
+type phonebook struct {
+	peers   []*dht.Peer
+	current *dht.Peer
+}
+
+func newPhonebook(n int) (*phonebook, error) {
+	peers := make([]*dht.Peer, n)
+	for i := range peers {
+		peers[i] = dht.NewPeer(dht.KeyFromString(fmt.Sprintf("phonebook-%d", i)))
+		peers[i].RegisterCRDTNamespace(namespace, dht.LWWRegisterKind)
+		if i == 0 {
+			continue
+		}
+		if err := peers[i].Bootstrap([]dht.Contact{{Peer: peers[0]}}); err != nil {
+			return nil, err
+		}
+	}
+	return &phonebook{peers: peers, current: peers[0]}, nil
+}
+
+func (pb *phonebook) add(name, number string) bool {
+	r := dht.NewLWWRegister()
+	r.Set([]byte(number), time.Now().UnixNano(), pb.current.ID())
+	return pb.current.MergeCRDT(context.Background(), namespace, name, r) == nil
+}
+
+func (pb *phonebook) get(name string) (string, bool) {
+	v, err := pb.current.GetCRDT(context.Background(), namespace, name)
+	r, ok := v.(*dht.LWWRegister)
+	if err != nil || !ok {
+		return "", false
+	}
+	return string(r.Value()), true
+}
+
+// 执行一条命令，返回输出
+func (pb *phonebook) exec(line string) (string, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	switch {
+	case fields[0] == "add" && len(fields) == 3:
+		if !pb.add(fields[1], fields[2]) {
+			return "", fmt.Errorf("无法登记 %s", fields[1])
+		}
+		return fmt.Sprintf("%s -> %s", fields[1], fields[2]), nil
+	case fields[0] == "get" && len(fields) == 2:
+		number, ok := pb.get(fields[1])
+		if !ok {
+			return fmt.Sprintf("%s: 未登记", fields[1]), nil
+		}
+		return fmt.Sprintf("%s: %s", fields[1], number), nil
+	case fields[0] == "peer" && len(fields) == 2:
+		i, err := strconv.Atoi(fields[1])
+		if err != nil || i < 0 || i >= len(pb.peers) {
+			return "", fmt.Errorf("节点编号应在 0 到 %d 之间", len(pb.peers)-1)
+		}
+		pb.current = pb.peers[i]
+		return fmt.Sprintf("使用节点 %d (%x)", i, pb.current.ID()), nil
+	}
+	return "", fmt.Errorf("未知命令: %s", line)
+}
+
+func interactive(pb *phonebook) {
+	sc := bufio.NewScanner(os.Stdin)
+	fmt.Print("> ")
+	for sc.Scan() {
+		out, err := pb.exec(sc.Text())
+		if err != nil {
+			fmt.Println(err)
+		} else if out != "" {
+			fmt.Println(out)
+		}
+		fmt.Print("> ")
+	}
+	fmt.Println()
+}
+
+// 在不同节点上登记、修改与查询，检查每个节点都读到最新的号码
+func demo(pb *phonebook) error {
+	entries := map[string]string{"alice": "555-0101", "bob": "555-0102", "carol": "555-0103"}
+	i := 0
+	for name, number := range entries {
+		pb.current = pb.peers[i%len(pb.peers)]
+		if !pb.add(name, number) {
+			return fmt.Errorf("登记 %s 失败", name)
+		}
+		i += 7
+	}
+	pb.current = pb.peers[len(pb.peers)-1]
+	entries["bob"] = "555-0199"
+	if !pb.add("bob", entries["bob"]) {
+		return fmt.Errorf("修改 bob 失败")
+	}
+	for idx, p := range pb.peers {
+		pb.current = p
+		for name, want := range entries {
+			if got, ok := pb.get(name); !ok || got != want {
+				return fmt.Errorf("节点 %d 查询 %s 得到 %q，应为 %q", idx, name, got, want)
+			}
+		}
+	}
+	fmt.Printf("%d 个节点都查到了 %d 个名字的最新号码\n", len(pb.peers), len(entries))
+	return nil
+}
+
+func main() {
+	peers := flag.Int("peers", 32, "节点数量")
+	inter := flag.Bool("i", false, "从标准输入读取命令")
+	flag.Parse()
+
+	pb, err := newPhonebook(*peers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *inter {
+		interactive(pb)
+		return
+	}
+	if err := demo(pb); err != nil {
+		fmt.Fprintln(os.Stderr, "phonebook:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+// rendezvous 演示基于 DHT 的节点发现：对同一话题感兴趣的节点把自己的 ID 追加到
+// 话题 key 下（AppendValue），新来的节点读取该 key 得到成员列表（GetValues），
+// 再用 FindPeer 找到每个成员的联系方式。AppendValue 与 GetValues 只与下一跳交换，
+// 因此读写前先用 Lookup 找到距离话题 key 最近的节点，在这些节点上追加与读取。
+//
+// 新节点没有找到全部成员时以非零状态退出，可以作为集成冒烟测试
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+const announceTTL = 10 * time.Minute // 成员需要在过期之前重新宣布
+
+func topicKey(topic string) [kbucket.IdSize]byte {
+	return dht.KeyFromString("rendezvous/" + topic)
+}
+
+// 负责 topic 的节点（距离话题 key 最近的节点）以及 p 自身
+func rendezvousPoints(p *dht.Peer, topic string) []*dht.Peer {
+	closest, _ := p.Lookup(context.Background(), topicKey(topic))
+	points := []*dht.Peer{p}
+	for _, c := range closest {
+		if c.Peer != nil {
+			points = append(points, c.Peer)
+		}
+	}
+	return points
+}
+
+// 宣布 p 是 topic 的成员
+func announce(p *dht.Peer, topic string) {
+	id := p.ID()
+	for _, point := range rendezvousPoints(p, topic) {
+		point.AppendValue(topicKey(topic), []byte(hex.EncodeToString(id[:])), announceTTL)
+	}
+}
+
+// topic 当前的成员，不包括 p 自身
+func members(p *dht.Peer, topic string) [][kbucket.IdSize]byte {
+	seen := map[[kbucket.IdSize]byte]bool{p.ID(): true}
+	var ids [][kbucket.IdSize]byte
+	for _, point := range rendezvousPoints(p, topic) {
+		values, err := point.GetValues(topicKey(topic))
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			var id [kbucket.IdSize]byte
+			if n, err := hex.Decode(id[:], v); err != nil || n != kbucket.IdSize || seen[id] {
+				continue
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func main() {
+	peers := flag.Int("peers", 100, "节点数量")
+	joiners := flag.Int("members", 5, "加入话题的节点数量")
+	topic := flag.String("topic", "chess", "话题")
+	seed := flag.Int64("seed", 1, "随机数种子")
+	flag.Parse()
+	if *joiners < 1 || *joiners >= *peers {
+		fmt.Fprintln(os.Stderr, "rendezvous: -members 应在 1 与 -peers 之间")
+		os.Exit(2)
+	}
+
+	network := make([]*dht.Peer, *peers)
+	for i := range network {
+		network[i] = dht.NewPeer(dht.KeyFromString(fmt.Sprintf("rendezvous-%d", i)))
+		if i > 0 {
+			if err := network[i].Bootstrap([]dht.Contact{{Peer: network[0]}}); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	want := make(map[[kbucket.IdSize]byte]bool)
+	for _, i := range r.Perm(*peers - 1)[:*joiners] { // 最后一个节点留作新来者
+		announce(network[i], *topic)
+		want[network[i].ID()] = true
+	}
+
+	newcomer := network[len(network)-1]
+	found := members(newcomer, *topic)
+	located := 0
+	for _, id := range found {
+		if !want[id] {
+			fmt.Fprintf(os.Stderr, "rendezvous: %x 不是话题成员\n", id)
+			os.Exit(1)
+		}
+		if node, ok := newcomer.FindPeer(id); ok {
+			fmt.Printf("成员 %x  最近确认 %v\n", id, node.LastSeen.Format(time.TimeOnly))
+			located++
+		} else {
+			fmt.Printf("成员 %x  未能找到联系方式\n", id)
+		}
+	}
+	fmt.Printf("新节点在话题 %q 中发现 %d/%d 个成员，找到其中 %d 个的联系方式\n", *topic, len(found), len(want), located)
+	if len(found) != len(want) || located != len(found) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/WuQingyang2/K_Bucket
+
+go 1.21
@@ -0,0 +1,112 @@
+// Package protowire 是 dht.proto 使用的 protobuf 编码的最小实现：只支持 varint 与
+// 长度前缀两种字段，足以编解码 dht.GRPCTransport 的消息。仅供本模块内部使用
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+
+	MaxMessage = 4 << 20 // 单条消息的上限，与 gRPC 的默认值一致
+)
+
+var ErrMalformed = errors.New("dht: malformed protobuf message")
+
+func AppendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+// 零值字段按 proto3 的约定省略
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = AppendTag(b, field, Bytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, field, Varint)
+	return binary.AppendUvarint(b, v)
+}
+
+// 依次处理 b 中的字段：varint 字段通过 x 传入，长度前缀字段通过 v 传入，
+// 其余类型的字段跳过
+func Fields(b []byte, fn func(field int, v []byte, x uint64) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		switch wire {
+		case Varint:
+			x, n := binary.Uvarint(b)
+			if n <= 0 {
+				return ErrMalformed
+			}
+			b = b[n:]
+			if err := fn(field, nil, x); err != nil {
+				return err
+			}
+		case Bytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return ErrMalformed
+			}
+			v := b[n : n+int(size)]
+			b = b[n+int(size):]
+			if err := fn(field, v, 0); err != nil {
+				return err
+			}
+		case Fixed64, Fixed32:
+			size := 8
+			if wire == Fixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return ErrMalformed
+			}
+			b = b[size:]
+		default:
+			return ErrMalformed
+		}
+	}
+	return nil
+}
+
+// gRPC 的消息帧：压缩标志(1) | 长度(4) | 消息
+func Frame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// 读取一个消息帧，不支持压缩
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if header[0] != 0 || size > MaxMessage {
+		return nil, ErrMalformed
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, ErrMalformed
+	}
+	return msg, nil
+}
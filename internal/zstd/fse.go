@@ -0,0 +1,114 @@
+package zstd
+
+import "math/bits"
+
+// 序列的三种码，也是预定义分布在 predefined 中的下标
+const (
+	tableLL = iota // literal length
+	tableOF        // offset
+	tableML        // match length
+)
+
+// literal length 码对应的基数与附加位数，见 RFC 8878 3.1.1.3.2.1.1
+var (
+	llBase = [36]uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536}
+	llBits = [36]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16}
+)
+
+// match length 码对应的基数与附加位数
+var (
+	mlBase = [53]uint32{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539}
+	mlBits = [53]uint8{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16}
+)
+
+// 预定义的分布，-1 表示概率小于 1 的符号，见 RFC 8878 3.1.1.3.2.2
+var predefined = [3]*fseTable{
+	tableLL: newFSETable(6, []int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1}),
+	tableOF: newFSETable(5, []int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}),
+	tableML: newFSETable(6, []int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1}),
+}
+
+// FSE 解码表中的一个状态：解出 symbol 后，下一个状态为 base 加上读取的 bits 位
+type fseEntry struct {
+	symbol uint8
+	bits   uint8
+	base   uint16
+}
+
+type fseTable struct {
+	log     uint8
+	count   []int16
+	entries []fseEntry
+	states  [][]uint16 // 每个符号对应的全部状态，供编码使用
+}
+
+// 按 RFC 8878 4.1.1 由归一化的概率构造解码表
+func newFSETable(log uint8, count []int16) *fseTable {
+	size := 1 << log
+	t := &fseTable{log: log, count: count, entries: make([]fseEntry, size), states: make([][]uint16, len(count))}
+	high := size - 1
+	next := make([]int, len(count))
+	for s, c := range count {
+		if c == -1 {
+			t.entries[high].symbol = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int(c)
+		}
+	}
+	pos, step := 0, size>>1+size>>3+3
+	for s, c := range count {
+		for i := 0; i < int(c); i++ {
+			t.entries[pos].symbol = uint8(s)
+			for pos = (pos + step) & (size - 1); pos > high; pos = (pos + step) & (size - 1) {
+			}
+		}
+	}
+	for state := range t.entries {
+		e := &t.entries[state]
+		n := next[e.symbol]
+		next[e.symbol]++
+		e.bits = log - uint8(bits.Len(uint(n))-1)
+		e.base = uint16(n<<e.bits - size)
+		t.states[e.symbol] = append(t.states[e.symbol], uint16(state))
+	}
+	return t
+}
+
+// 所有状态都解出 symbol 的表，对应 RLE 模式
+func rleTable(symbol uint8) *fseTable {
+	return &fseTable{entries: []fseEntry{{symbol: symbol}}}
+}
+
+// 任意一个解出 symbol 的状态，作为最后一个序列的状态
+func (t *fseTable) anyState(symbol uint8) uint16 {
+	return t.states[symbol][0]
+}
+
+// 解出 symbol、且下一个状态可以是 next 的状态，以及从它转移到 next 需要写入的比特
+func (t *fseTable) encode(symbol uint8, next uint16) (state uint16, n uint, v uint16) {
+	for _, s := range t.states[symbol] {
+		e := t.entries[s]
+		if next >= e.base && int(next) < int(e.base)+1<<e.bits {
+			return s, uint(e.bits), next - e.base
+		}
+	}
+	panic("zstd: inconsistent FSE table")
+}
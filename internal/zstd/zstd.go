@@ -0,0 +1,538 @@
+// Package zstd 是 Zstandard（RFC 8878）的最小实现，供 dht 的快照压缩使用。
+// 压缩使用贪心的 LZ77 匹配，字面量不做熵编码，序列使用预定义的 FSE 分布；
+// 输出是标准的 zstd 帧，可以用 zstd 命令行工具解压。解压只支持本包写出的子集：
+// 不支持字典、Huffman 编码的字面量以及预定义与 RLE 之外的序列分布。仅供本模块内部使用
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	magic        = 0xFD2FB528
+	maxBlockSize = 128 << 10 // 一个块解压后的上限
+	minMatch     = 4
+	hashLog      = 16
+	maxOffset    = 1<<29 - 4 // 预定义的 offset 分布最多表示 28 位的 offset 码
+)
+
+var (
+	ErrCorrupt     = errors.New("zstd: corrupt frame")
+	ErrUnsupported = errors.New("zstd: unsupported frame feature")
+)
+
+// 块类型
+const (
+	blockRaw = iota
+	blockRLE
+	blockCompressed
+)
+
+// 一个序列：先复制 lit 个字面量，再从 offset 字节之前复制 match 个字节
+type sequence struct {
+	lit, match, offset uint32
+}
+
+type encoder struct {
+	src   []byte
+	table []int32 // 4 字节哈希 → 最近出现的位置加一，0 表示没有
+}
+
+// 把 src 压缩为一个 zstd 帧追加到 dst。帧头记录内容大小，帧尾附带内容的校验和
+func Encode(dst, src []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, magic)
+	dst = append(dst, 3<<6|1<<5|1<<2) // 内容大小占 8 字节、单段、带校验和、没有字典
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(len(src)))
+	e := encoder{src: src, table: make([]int32, 1<<hashLog)}
+	for start := 0; ; start += maxBlockSize {
+		end := min(start+maxBlockSize, len(src))
+		dst = e.block(dst, start, end, end == len(src))
+		if end == len(src) {
+			break
+		}
+	}
+	return binary.LittleEndian.AppendUint32(dst, uint32(xxh64(src)))
+}
+
+func hash4(b []byte) uint32 {
+	return binary.LittleEndian.Uint32(b) * 2654435761 >> (32 - hashLog)
+}
+
+// 压缩 src[start:end] 为一个块。匹配可以引用之前的块，但不跨出本块
+func (e *encoder) block(dst []byte, start, end int, last bool) []byte {
+	src := e.src
+	var seqs []sequence
+	var lits []byte
+	anchor := start
+	for i := start; i+minMatch <= end; {
+		h := hash4(src[i:])
+		cand := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if cand < 0 || i-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+		n := minMatch
+		for i+n < end && src[cand+n] == src[i+n] {
+			n++
+		}
+		lits = append(lits, src[anchor:i]...)
+		seqs = append(seqs, sequence{lit: uint32(i - anchor), match: uint32(n), offset: uint32(i - cand)})
+		for j := i + 1; j < i+n && j+minMatch <= end; j += n/8 + 1 { // 匹配内部稀疏地记录位置
+			e.table[hash4(src[j:])] = int32(j + 1)
+		}
+		i += n
+		anchor = i
+	}
+	lits = append(lits, src[anchor:end]...)
+	if len(seqs) > 0 {
+		body := appendSequences(appendLiterals(nil, lits), seqs)
+		if len(body) < end-start {
+			dst = appendBlockHeader(dst, blockCompressed, len(body), last)
+			return append(dst, body...)
+		}
+	}
+	dst = appendBlockHeader(dst, blockRaw, end-start, last)
+	return append(dst, src[start:end]...)
+}
+
+// 最后一块(1 位) | 类型(2 位) | 大小(21 位)，小端
+func appendBlockHeader(dst []byte, kind, size int, last bool) []byte {
+	h := uint32(kind<<1 | size<<3)
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+// 不压缩的字面量段：类型 0，按长度选择 1、2 或 3 字节的段头
+func appendLiterals(dst, lits []byte) []byte {
+	n := len(lits)
+	switch {
+	case n < 1<<5:
+		dst = append(dst, byte(n<<3))
+	case n < 1<<12:
+		dst = append(dst, byte(1<<2|n<<4), byte(n>>4))
+	default:
+		dst = append(dst, byte(3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+	return append(dst, lits...)
+}
+
+// 序列段：数量 | 分布模式（全部为预定义） | 反向读取的比特流
+func appendSequences(dst []byte, seqs []sequence) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	dst = append(dst, 0)
+
+	codes := make([][3]uint8, n) // 每个序列的 literal length、offset 与 match length 码
+	for i, s := range seqs {
+		codes[i] = [3]uint8{llCode(s.lit), uint8(bits.Len32(s.offset+3) - 1), mlCode(s.match)}
+	}
+	// 解码方从最后写入的比特开始读，因此按解码顺序的逆序写入
+	var w bitWriter
+	var state [3]uint16
+	for i := n - 1; i >= 0; i-- {
+		s, c := seqs[i], codes[i]
+		if i == n-1 {
+			for t := range state {
+				state[t] = predefined[t].anyState(c[t])
+			}
+		} else { // 解码方依次更新 literal length、match length、offset 的状态
+			for _, t := range [3]int{tableOF, tableML, tableLL} {
+				prev, nb, v := predefined[t].encode(c[t], state[t])
+				w.add(uint64(v), nb)
+				state[t] = prev
+			}
+		}
+		// 解码方依次读取 offset、match length、literal length 的附加位
+		w.add(uint64(s.lit-llBase[c[tableLL]]), uint(llBits[c[tableLL]]))
+		w.add(uint64(s.match-mlBase[c[tableML]]), uint(mlBits[c[tableML]]))
+		w.add(uint64(s.offset+3-1<<c[tableOF]), uint(c[tableOF]))
+	}
+	// 解码方依次读取 literal length、offset、match length 的初始状态
+	for _, t := range [3]int{tableML, tableOF, tableLL} {
+		w.add(uint64(state[t]), uint(predefined[t].log))
+	}
+	return append(dst, w.close()...)
+}
+
+func llCode(v uint32) uint8 {
+	if v < 16 {
+		return uint8(v)
+	}
+	c := len(llBase) - 1
+	for llBase[c] > v {
+		c--
+	}
+	return uint8(c)
+}
+
+func mlCode(v uint32) uint8 {
+	if v < 35 {
+		return uint8(v - 3)
+	}
+	c := len(mlBase) - 1
+	for mlBase[c] > v {
+		c--
+	}
+	return uint8(c)
+}
+
+// 正向写入、反向读取的比特流
+type bitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+func (w *bitWriter) add(v uint64, n uint) {
+	w.acc |= (v & (1<<n - 1)) << w.n
+	w.n += n
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+// 写入结束标记位并补齐最后一个字节
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}
+
+type bitReader struct {
+	b   []byte
+	pos int // 尚未读取的比特数
+}
+
+func newBitReader(b []byte) (bitReader, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return bitReader{}, ErrCorrupt
+	}
+	return bitReader{b: b, pos: (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1}, nil
+}
+
+func (r *bitReader) read(n uint) (uint32, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if r.pos < int(n) {
+		return 0, ErrCorrupt
+	}
+	r.pos -= int(n)
+	var buf [8]byte
+	copy(buf[:], r.b[r.pos/8:])
+	return uint32(binary.LittleEndian.Uint64(buf[:]) >> (r.pos % 8) & (1<<n - 1)), nil
+}
+
+// 解压 src 中的一个 zstd 帧，帧之后不能有其他数据
+func Decode(src []byte) ([]byte, error) {
+	if len(src) < 5 || binary.LittleEndian.Uint32(src) != magic {
+		return nil, ErrCorrupt
+	}
+	fhd := src[4]
+	src = src[5:]
+	if fhd&(1<<3) != 0 { // 保留位
+		return nil, ErrCorrupt
+	}
+	single, checksum := fhd&(1<<5) != 0, fhd&(1<<2) != 0
+	if !single { // 窗口大小只影响内存用量，解码时整个帧都保留在内存中
+		if len(src) < 1 {
+			return nil, ErrCorrupt
+		}
+		src = src[1:]
+	}
+	if dictSize := [4]int{0, 1, 2, 4}[fhd&3]; dictSize > 0 {
+		if len(src) < dictSize {
+			return nil, ErrCorrupt
+		}
+		for _, b := range src[:dictSize] {
+			if b != 0 {
+				return nil, ErrUnsupported
+			}
+		}
+		src = src[dictSize:]
+	}
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && single {
+		fcsSize = 1
+	}
+	if len(src) < fcsSize {
+		return nil, ErrCorrupt
+	}
+	size, known := uint64(0), fcsSize > 0
+	switch fcsSize {
+	case 1:
+		size = uint64(src[0])
+	case 2:
+		size = uint64(binary.LittleEndian.Uint16(src)) + 256
+	case 4:
+		size = uint64(binary.LittleEndian.Uint32(src))
+	case 8:
+		size = binary.LittleEndian.Uint64(src)
+	}
+	src = src[fcsSize:]
+
+	out := make([]byte, 0, min(size, 64<<20))
+	d := decoder{rep: [3]uint32{1, 4, 8}}
+	for last := false; !last; {
+		if len(src) < 3 {
+			return nil, ErrCorrupt
+		}
+		h := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		last = h&1 != 0
+		kind, n := int(h>>1&3), int(h>>3)
+		src = src[3:]
+		var err error
+		switch kind {
+		case blockRaw:
+			if n > maxBlockSize || len(src) < n {
+				return nil, ErrCorrupt
+			}
+			out = append(out, src[:n]...)
+			src = src[n:]
+		case blockRLE:
+			if n > maxBlockSize || len(src) < 1 {
+				return nil, ErrCorrupt
+			}
+			for i := 0; i < n; i++ {
+				out = append(out, src[0])
+			}
+			src = src[1:]
+		case blockCompressed:
+			if n > maxBlockSize || len(src) < n {
+				return nil, ErrCorrupt
+			}
+			if out, err = d.block(out, src[:n]); err != nil {
+				return nil, err
+			}
+			src = src[n:]
+		default:
+			return nil, ErrCorrupt
+		}
+		if known && uint64(len(out)) > size {
+			return nil, ErrCorrupt
+		}
+	}
+	if known && uint64(len(out)) != size {
+		return nil, ErrCorrupt
+	}
+	if checksum {
+		if len(src) < 4 || binary.LittleEndian.Uint32(src) != uint32(xxh64(out)) {
+			return nil, ErrCorrupt
+		}
+		src = src[4:]
+	}
+	if len(src) != 0 {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
+
+type decoder struct {
+	rep [3]uint32 // 最近使用的三个 offset
+}
+
+// 解压一个压缩块追加到 out
+func (d *decoder) block(out, b []byte) ([]byte, error) {
+	lits, b, err := readLiterals(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 1 {
+		return nil, ErrCorrupt
+	}
+	n := int(b[0])
+	switch {
+	case n == 0:
+		if len(b) != 1 {
+			return nil, ErrCorrupt
+		}
+		return append(out, lits...), nil
+	case n < 128:
+		b = b[1:]
+	case n < 255:
+		if len(b) < 2 {
+			return nil, ErrCorrupt
+		}
+		n = (n-128)<<8 + int(b[1])
+		b = b[2:]
+	default:
+		if len(b) < 3 {
+			return nil, ErrCorrupt
+		}
+		n = int(b[1]) + int(b[2])<<8 + 0x7f00
+		b = b[3:]
+	}
+	if len(b) < 1 || b[0]&3 != 0 {
+		return nil, ErrCorrupt
+	}
+	modes := b[0]
+	b = b[1:]
+	var tables [3]*fseTable
+	for t, shift := range [3]uint{6, 4, 2} { // literal length、offset、match length 的模式
+		switch modes >> shift & 3 {
+		case 0:
+			tables[t] = predefined[t]
+		case 1:
+			if len(b) < 1 || int(b[0]) >= len(predefined[t].count) {
+				return nil, ErrCorrupt
+			}
+			tables[t] = rleTable(b[0])
+			b = b[1:]
+		default:
+			return nil, ErrUnsupported
+		}
+	}
+
+	r, err := newBitReader(b)
+	if err != nil {
+		return nil, err
+	}
+	var state [3]uint32
+	for t := range state {
+		if state[t], err = r.read(uint(tables[t].log)); err != nil {
+			return nil, err
+		}
+	}
+	start := len(out)
+	for i := 0; i < n; i++ {
+		var c [3]uint8
+		for t := range c {
+			c[t] = tables[t].entries[state[t]].symbol
+		}
+		if c[tableLL] >= uint8(len(llBase)) || c[tableML] >= uint8(len(mlBase)) || c[tableOF] > 31 {
+			return nil, ErrCorrupt
+		}
+		ov, err := r.read(uint(c[tableOF]))
+		if err != nil {
+			return nil, err
+		}
+		ov += 1 << c[tableOF]
+		ml, err := r.read(uint(mlBits[c[tableML]]))
+		if err != nil {
+			return nil, err
+		}
+		ml += mlBase[c[tableML]]
+		ll, err := r.read(uint(llBits[c[tableLL]]))
+		if err != nil {
+			return nil, err
+		}
+		ll += llBase[c[tableLL]]
+		if i < n-1 {
+			for _, t := range [3]int{tableLL, tableML, tableOF} {
+				e := tables[t].entries[state[t]]
+				v, err := r.read(uint(e.bits))
+				if err != nil {
+					return nil, err
+				}
+				state[t] = uint32(e.base) + v
+			}
+		}
+
+		offset := d.offset(ov, ll)
+		if int(ll) > len(lits) {
+			return nil, ErrCorrupt
+		}
+		out = append(out, lits[:ll]...)
+		lits = lits[ll:]
+		if offset == 0 || int(offset) > len(out) {
+			return nil, ErrCorrupt
+		}
+		for j := len(out) - int(offset); ml > 0; j, ml = j+1, ml-1 { // 源与目标可以重叠
+			out = append(out, out[j])
+		}
+		if len(out)-start > maxBlockSize {
+			return nil, ErrCorrupt
+		}
+	}
+	if r.pos != 0 {
+		return nil, ErrCorrupt
+	}
+	out = append(out, lits...)
+	if len(out)-start > maxBlockSize {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
+
+// 由 offset 值得到实际的 offset 并更新最近使用的 offset，见 RFC 8878 3.1.2.5
+func (d *decoder) offset(ov, ll uint32) uint32 {
+	if ov > 3 {
+		d.rep = [3]uint32{ov - 3, d.rep[0], d.rep[1]}
+		return d.rep[0]
+	}
+	i := ov - 1
+	if ll == 0 {
+		i++
+	}
+	switch i {
+	case 0:
+		return d.rep[0]
+	case 1:
+		d.rep[0], d.rep[1] = d.rep[1], d.rep[0]
+	case 2:
+		d.rep = [3]uint32{d.rep[2], d.rep[0], d.rep[1]}
+	default: // offset 值为 3 且没有字面量
+		d.rep = [3]uint32{d.rep[0] - 1, d.rep[0], d.rep[1]}
+	}
+	return d.rep[0]
+}
+
+// 读取字面量段，只支持不压缩与 RLE 两种类型
+func readLiterals(b []byte) (lits, rest []byte, err error) {
+	if len(b) < 1 {
+		return nil, nil, ErrCorrupt
+	}
+	kind := b[0] & 3
+	if kind > 1 {
+		return nil, nil, ErrUnsupported
+	}
+	var n, header int
+	switch b[0] >> 2 & 3 {
+	case 0, 2:
+		n, header = int(b[0]>>3), 1
+	case 1:
+		if len(b) < 2 {
+			return nil, nil, ErrCorrupt
+		}
+		n, header = int(b[0]>>4)+int(b[1])<<4, 2
+	case 3:
+		if len(b) < 3 {
+			return nil, nil, ErrCorrupt
+		}
+		n, header = int(b[0]>>4)+int(b[1])<<4+int(b[2])<<12, 3
+	}
+	if n > maxBlockSize {
+		return nil, nil, ErrCorrupt
+	}
+	b = b[header:]
+	if kind == 1 {
+		if len(b) < 1 {
+			return nil, nil, ErrCorrupt
+		}
+		lits = make([]byte, n)
+		for i := range lits {
+			lits[i] = b[0]
+		}
+		return lits, b[1:], nil
+	}
+	if len(b) < n {
+		return nil, nil, ErrCorrupt
+	}
+	return b[:n], b[n:], nil
+}
@@ -0,0 +1,124 @@
+package kbucket
+
+import "time"
+
+const agingHistorySize = 256 // 保留的老化采样与淘汰事件数量
+
+// 一个 bucket 中联系人的新旧程度
+type BucketAge struct {
+	Bucket     int
+	Contacts   int
+	Unverified int           // 尚未确认过存活的节点数
+	MeanAge    time.Duration // 已验证节点距离上次出现的平均时间
+	MaxAge     time.Duration
+}
+
+// 某一时刻所有非空 bucket 的老化情况
+type AgingSample struct {
+	Time    time.Time
+	Buckets []BucketAge
+}
+
+type EvictionReason int
+
+const (
+	EvictedUnresponsive EvictionReason = iota // bucket 已满时 ping 无响应
+	EvictedRemoved                            // 被显式删除
+	EvictedConflict                           // 与其他联系人声称同一个 ID
+	EvictedStale                              // 连续联系失败，被 PruneStale 清理
+	EvictedDeparted                           // 节点通知自己已经离开网络
+	EvictedFiltered                           // 不再通过准入检查，见 Refilter
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedUnresponsive:
+		return "unresponsive"
+	case EvictedRemoved:
+		return "removed"
+	case EvictedConflict:
+		return "conflict"
+	case EvictedStale:
+		return "stale"
+	case EvictedDeparted:
+		return "departed"
+	case EvictedFiltered:
+		return "filtered"
+	}
+	return "unknown"
+}
+
+type EvictionEvent struct {
+	Time   time.Time
+	Bucket int
+	ID     [IdSize]byte
+	Reason EvictionReason
+}
+
+// 路由表老化的时间序列，用于展示各区域联系人的新旧程度并与查找失败对照
+type agingLog struct {
+	samples   []AgingSample
+	evictions []EvictionEvent
+	regions   map[int][]RegionEvent // 各 bucket 最近的结构性事件，见 RegionHistory
+}
+
+// 采集一次各 bucket 的老化情况并加入时间序列，由调用方周期性调用
+func (kb *KBucket) RecordAging() AgingSample {
+	now := time.Now()
+	sample := AgingSample{Time: now}
+	for pos := kb.HomeBucket(); pos < IdSize*8; pos++ {
+		nodes := kb.GetBucket(pos).Nodes()
+		if len(nodes) == 0 {
+			continue
+		}
+		age := BucketAge{Bucket: pos, Contacts: len(nodes)}
+		var total time.Duration
+		for _, n := range nodes {
+			if n.LastSeen.IsZero() {
+				age.Unverified++
+				continue
+			}
+			d := now.Sub(n.LastSeen)
+			total += d
+			if d > age.MaxAge {
+				age.MaxAge = d
+			}
+		}
+		if verified := len(nodes) - age.Unverified; verified > 0 {
+			age.MeanAge = total / time.Duration(verified)
+		}
+		sample.Buckets = append(sample.Buckets, age)
+	}
+	kb.mu.Lock()
+	kb.aging.samples = append(kb.aging.samples, sample)
+	if len(kb.aging.samples) > agingHistorySize {
+		kb.aging.samples = kb.aging.samples[1:]
+	}
+	kb.mu.Unlock()
+	return sample
+}
+
+// 调用方需持有 kb.mu 的写锁
+func (kb *KBucket) recordEviction(pos int, id [IdSize]byte, reason EvictionReason) {
+	kb.aging.evictions = append(kb.aging.evictions, EvictionEvent{Time: time.Now(), Bucket: pos, ID: id, Reason: reason})
+	if kb.metrics != nil {
+		kb.metrics.Eviction(pos, reason)
+	}
+	if len(kb.aging.evictions) > agingHistorySize {
+		kb.aging.evictions = kb.aging.evictions[1:]
+	}
+}
+
+// 最近的老化采样，从旧到新
+func (kb *KBucket) AgingHistory() []AgingSample {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return append([]AgingSample(nil), kb.aging.samples...)
+}
+
+// 最近的淘汰事件，从旧到新
+func (kb *KBucket) Evictions() []EvictionEvent {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return append([]EvictionEvent(nil), kb.aging.evictions...)
+}
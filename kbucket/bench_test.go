@@ -0,0 +1,155 @@
+package kbucket
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+const benchContacts = 10000
+
+func randomIDs(r *rand.Rand, n int) [][IdSize]byte {
+	ids := make([][IdSize]byte, n)
+	for i := range ids {
+		r.Read(ids[i][:])
+	}
+	return ids
+}
+
+// 容量足够大的路由表，使 benchContacts 个随机节点都能留在表中
+func benchTable(b *testing.B) (*KBucket, [][IdSize]byte) {
+	b.Helper()
+	r := rand.New(rand.NewSource(1))
+	var self [IdSize]byte
+	r.Read(self[:])
+	kb := NewKBucket(self, benchContacts/2)
+	ids := randomIDs(r, benchContacts)
+	for _, id := range ids {
+		kb.InsertNode(Node{ID: id})
+	}
+	if n := kb.Size(); n < benchContacts*9/10 {
+		b.Fatalf("table holds only %d of %d contacts", n, benchContacts)
+	}
+	return kb, ids
+}
+
+// 每插入 benchContacts 个节点换一张新表，测量的是表逐渐填满过程中的平均代价
+func BenchmarkInsertNode(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	var self [IdSize]byte
+	r.Read(self[:])
+	ids := randomIDs(r, benchContacts)
+	var kb *KBucket
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%benchContacts == 0 {
+			b.StopTimer()
+			kb = NewKBucket(self, benchContacts/2)
+			b.StartTimer()
+		}
+		kb.InsertNode(Node{ID: ids[i%benchContacts]})
+	}
+}
+
+func BenchmarkFindClosestNodes(b *testing.B) {
+	kb, _ := benchTable(b)
+	targets := randomIDs(rand.New(rand.NewSource(2)), 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kb.FindClosestNodes(targets[i%len(targets)], BucketSize)
+	}
+}
+
+func BenchmarkFindClosestNodesK20(b *testing.B) {
+	kb, _ := benchTable(b)
+	targets := randomIDs(rand.New(rand.NewSource(2)), 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kb.FindClosestNodes(targets[i%len(targets)], 20)
+	}
+}
+
+// 多个 goroutine 查找最近节点，同时另一个 goroutine 不断记录节点存活与 RTT，
+// 相当于查找过程中每个响应都会更新路由表。节点列表写时复制，读取不等待这些写入。
+// 使用 k=20 的普通路由表，而不是 benchTable 中容量很大的 bucket
+func BenchmarkFindClosestNodesDuringUpdates(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	var self [IdSize]byte
+	r.Read(self[:])
+	kb := NewKBucket(self, 20)
+	for _, id := range randomIDs(r, benchContacts) {
+		kb.InsertNode(Node{ID: id})
+	}
+	var ids [][IdSize]byte
+	for _, n := range kb.AllNodes() {
+		ids = append(ids, n.ID)
+	}
+	targets := randomIDs(rand.New(rand.NewSource(2)), 1024)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		now := time.Now()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			kb.MarkSeen(ids[i%len(ids)], now)
+			kb.ObserveRTT(ids[(i+1)%len(ids)], time.Millisecond)
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			kb.FindClosestNodes(targets[i%len(targets)], 20)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkBucketIndex(b *testing.B) {
+	kb, ids := benchTable(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kb.BucketIndex(ids[i%len(ids)])
+	}
+}
+
+func BenchmarkFindNode(b *testing.B) {
+	kb, ids := benchTable(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i%len(ids)]
+		kb.GetBucket(kb.BucketIndex(id)).FindNode(id)
+	}
+}
+
+func BenchmarkCloser(b *testing.B) {
+	ids := randomIDs(rand.New(rand.NewSource(3)), 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Closer(ids[i%1024], ids[(i+1)%1024], ids[(i+2)%1024])
+	}
+}
+
+// 用默认容量构建一张 200 个随机节点的路由表，B/op 反映每张表占用的内存：
+// 只有分裂出来的 bucket 才会分配
+func BenchmarkBuildTable(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	ids := randomIDs(r, 200)
+	selves := randomIDs(r, 64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kb := NewKBucket(selves[i%len(selves)], BucketSize)
+		for _, id := range ids {
+			kb.InsertNode(Node{ID: id})
+		}
+	}
+}
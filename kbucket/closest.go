@@ -0,0 +1,108 @@
+package kbucket
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+)
+
+// 返回路由表中距离 target 最近的至多 k 个节点，按 XOR 距离（或 SetDistanceMetric 设置的度量）
+// 从近到远排序，不包括已失效的节点。
+// 设 target 落在 bucket t：bucket t 中的节点最近，其次是所有更低的 bucket
+// （与 target 距离的最高位同为 t），再往后依次是 t+1、t+2……
+// 候选节点放入大小为 k 的堆中，查询的代价随候选数线性、随 k 对数增长，不需要对整个 bucket 排序
+func (kb *KBucket) FindClosestNodes(target [IdSize]byte, k int) []Node {
+	if k <= 0 {
+		return nil
+	}
+	kb.mu.RLock()
+	if kb.metric != nil {
+		defer kb.mu.RUnlock()
+		return kb.closestByMetric(target, k)
+	}
+	h := &closestHeap{k: k, stale: kb.staleFailures()}
+	t := kb.BucketIndex(target)
+	kb.bucketLocked(t).collect(target, h)
+	if h.seen < k {
+		for i := t - 1; i >= kb.HomeBucket(); i-- { // 更低的 bucket 尚未分裂出来
+			kb.bucketLocked(i).collect(target, h)
+		}
+	}
+	for i := t + 1; i < IdSize*8 && h.seen < k; i++ {
+		kb.bucketLocked(i).collect(target, h)
+	}
+	kb.mu.RUnlock()
+	sort.Slice(h.items, func(i, j int) bool {
+		return bytes.Compare(h.items[i].dist[:], h.items[j].dist[:]) < 0
+	})
+	nodes := make([]Node, len(h.items))
+	for i, c := range h.items {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// 使用自定义度量时无法按 bucket 剪枝，对全部未失效的节点排序。调用方需持有 kb.mu
+func (kb *KBucket) closestByMetric(target [IdSize]byte, k int) []Node {
+	var nodes []Node
+	for _, n := range kb.allNodesLocked() {
+		if !kb.IsStale(n) {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool { return kb.metric.Closer(nodes[i].ID, nodes[j].ID, target) })
+	if len(nodes) > k {
+		nodes = nodes[:k]
+	}
+	return nodes
+}
+
+type candidate struct {
+	dist [IdSize]byte
+	node Node
+}
+
+// 保留最近的 k 个候选节点的大顶堆，堆顶是其中最远的一个
+type closestHeap struct {
+	items []candidate
+	k     int
+	stale int // 连续失败多少次后视为失效
+	seen  int // 已加入的候选数，可能超过 k
+}
+
+func (h *closestHeap) Len() int           { return len(h.items) }
+func (h *closestHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *closestHeap) Push(x interface{}) { h.items = append(h.items, x.(candidate)) }
+func (h *closestHeap) Less(i, j int) bool {
+	return bytes.Compare(h.items[i].dist[:], h.items[j].dist[:]) > 0
+}
+
+func (h *closestHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+func (h *closestHeap) offer(n Node, target [IdSize]byte) {
+	if n.Failures >= h.stale {
+		return
+	}
+	h.seen++
+	c := candidate{dist: Distance(n.ID, target), node: n}
+	if len(h.items) < h.k {
+		heap.Push(h, c)
+		return
+	}
+	if bytes.Compare(c.dist[:], h.items[0].dist[:]) < 0 {
+		h.items[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// 把 bucket 中的节点作为候选加入 h
+func (b *Bucket) collect(target [IdSize]byte, h *closestHeap) {
+	nodes := b.list()
+	for i := range nodes {
+		h.offer(nodes[i], target)
+	}
+}
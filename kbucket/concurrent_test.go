@@ -0,0 +1,106 @@
+package kbucket
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func randomID(r *rand.Rand) [IdSize]byte {
+	var id [IdSize]byte
+	r.Read(id[:])
+	return id
+}
+
+// 多个 goroutine 同时读写路由表，需配合 go test -race 运行
+func TestKBucketConcurrentAccess(t *testing.T) {
+	self := randomID(rand.New(rand.NewSource(1)))
+	kb := NewKBucket(self, BucketSize)
+	kb.SetOnInsert(func(n Node) { kb.AllNodes() }) // 回调中访问路由表不应死锁
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 500; i++ {
+				id := randomID(r)
+				switch i % 8 {
+				case 0, 1, 2:
+					kb.InsertNode(Node{ID: id})
+				case 3:
+					kb.RemoveNode(id)
+				case 4:
+					kb.FindClosestNodes(id, BucketSize)
+				case 5:
+					kb.KnowsPrefix(id, r.Intn(IdSize*8))
+				case 6:
+					kb.Touch(kb.BucketIndex(id))
+					kb.StaleBuckets(time.Minute)
+				case 7:
+					for _, n := range kb.SampleContacts(2, 1) {
+						kb.MarkSeen(n.ID, time.Now())
+					}
+					kb.GetBucket(kb.BucketIndex(id)).Nodes()
+				}
+			}
+		}(int64(w) + 2)
+	}
+	wg.Wait()
+
+	if report := kb.Check(false); !report.OK() {
+		t.Fatalf("routing table inconsistent after concurrent use: %v", report.Issues)
+	}
+}
+
+func TestKBucketConcurrentCheckAndInsert(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	kb := NewKBucket(randomID(r), BucketSize)
+	ids := make([][IdSize]byte, 2000)
+	for i := range ids {
+		ids[i] = randomID(r)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, id := range ids {
+			kb.InsertNode(Node{ID: id})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			kb.Check(true)
+			kb.AllNodes()
+		}
+	}()
+	wg.Wait()
+}
+
+// 已发布的节点列表不会被之后的写入修改：读取方拿到的切片保持不变
+func TestBucketCopyOnWrite(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	b := newBucket(4)
+	for i := 0; i < 3; i++ {
+		b.insertNode(Node{ID: randomID(r)})
+	}
+	snapshot := b.list()
+	want := append([]Node(nil), snapshot...)
+	b.insertNode(Node{ID: randomID(r)})             // 追加
+	b.insertNode(Node{ID: want[0].ID, Data: "new"}) // 移到末尾
+	b.mu.Lock()
+	b.modify(0, func(n *Node) { n.Failures++ })
+	b.mu.Unlock()
+	b.RemoveNode(want[1].ID)
+	for i := range want {
+		if snapshot[i].ID != want[i].ID || snapshot[i].Data != want[i].Data || snapshot[i].Failures != want[i].Failures {
+			t.Fatalf("published node %d changed from %+v to %+v", i, want[i], snapshot[i])
+		}
+	}
+	if n := b.Len(); n != 3 {
+		t.Fatalf("bucket holds %d nodes, want 3", n)
+	}
+}
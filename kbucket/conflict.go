@@ -0,0 +1,110 @@
+package kbucket
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 两个不同的联系人（Node.Data 不同，例如地址不同）声称拥有同一个节点 ID 时的处理策略
+type ConflictPolicy int
+
+const (
+	ConflictReplace      ConflictPolicy = iota // 新联系人直接覆盖旧的（默认）
+	ConflictKeepVerified                       // 保留已确认存活的一方，都已确认或都未确认时保留旧的
+	ConflictKeepOldest                         // 始终保留已有的联系人
+	ConflictChallenge                          // ping 双方，只保留唯一响应的一方；都无响应时都不保留
+	ConflictRejectBoth                         // 删除已有的联系人，也不加入新的
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictReplace:
+		return "replace"
+	case ConflictKeepVerified:
+		return "keep-verified"
+	case ConflictKeepOldest:
+		return "keep-oldest"
+	case ConflictChallenge:
+		return "challenge"
+	case ConflictRejectBoth:
+		return "reject-both"
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// 设置 ID 冲突的处理策略。ConflictChallenge 使用 SetPinger 设置的存活检查，
+// 没有设置时等同于 ConflictKeepOldest
+func (kb *KBucket) SetConflictPolicy(p ConflictPolicy) {
+	kb.mu.Lock()
+	kb.conflict = p
+	kb.mu.Unlock()
+}
+
+// 发现过的 ID 冲突次数
+func (kb *KBucket) Conflicts() uint64 {
+	return kb.conflicts.Load()
+}
+
+// 两个 Node.Data 是否指向同一个联系人。实现了 fmt.Stringer 的（例如 *net.UDPAddr）
+// 按字符串比较，nil 表示没有地址信息，不视为冲突
+func sameContact(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	if t := reflect.TypeOf(a); t == reflect.TypeOf(b) && t.Comparable() && a == b {
+		return true // 同一个值，不需要格式化
+	}
+	sa, okA := a.(fmt.Stringer)
+	sb, okB := b.(fmt.Stringer)
+	if okA && okB {
+		return sa.String() == sb.String()
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if !reflect.TypeOf(a).Comparable() {
+		return true // 无法比较的数据不作判断，保持覆盖的行为
+	}
+	return a == b
+}
+
+// 路由表中与 n 的 ID 相同但联系人不同的节点，调用方需持有 kb.mu
+func (kb *KBucket) conflictLocked(n Node) (Node, bool) {
+	old, ok := kb.bucketLocked(kb.BucketIndex(n.ID)).FindNode(n.ID)
+	return old, ok && !sameContact(old.Data, n.Data)
+}
+
+// 按策略处理 old 与 n 的冲突，返回是否应该用 n 覆盖 old。不持有路由表锁时调用
+func (kb *KBucket) resolveConflict(old, n Node, policy ConflictPolicy, ping func(Node) bool) bool {
+	kb.conflicts.Add(1)
+	switch policy {
+	case ConflictReplace:
+		return true
+	case ConflictKeepVerified:
+		return old.LastSeen.IsZero() && !n.LastSeen.IsZero()
+	case ConflictChallenge:
+		if ping == nil {
+			return false
+		}
+		oldAlive, newAlive := ping(old), ping(n)
+		if newAlive && !oldAlive {
+			return true
+		}
+		if !newAlive && !oldAlive {
+			kb.removeContact(old)
+		}
+	case ConflictRejectBoth:
+		kb.removeContact(old)
+	}
+	return false
+}
+
+// 删除 old，路由表中该 ID 已经换成其他联系人时不做修改
+func (kb *KBucket) removeContact(old Node) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	pos := kb.BucketIndex(old.ID)
+	if x, ok := kb.bucketLocked(pos).FindNode(old.ID); ok && sameContact(x.Data, old.Data) {
+		kb.evictLocked(pos, old.ID, EvictedConflict)
+	}
+}
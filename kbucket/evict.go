@@ -0,0 +1,87 @@
+package kbucket
+
+// 设置 bucket 已满时使用的存活检查。设置之后，新节点遇到已满的 bucket 时
+// 按 Kademlia 的策略处理：ping 最久未出现的节点，无响应则将其淘汰并加入新节点，
+// 否则保留旧节点，新节点进入该 bucket 的替补队列。nil 表示不检查（默认）。
+// ping 在不持有路由表锁的情况下进行
+func (kb *KBucket) SetPinger(ping func(Node) bool) {
+	kb.mu.Lock()
+	kb.pinger = ping
+	kb.mu.Unlock()
+}
+
+func (kb *KBucket) evictOrQueue(n Node, ping func(Node) bool) bool {
+	pos := kb.BucketIndex(n.ID)
+	bucket := kb.GetBucket(pos)
+	oldest, ok := bucket.oldest()
+	if !ok {
+		return bucket.insertNode(n)
+	}
+	alive := ping(oldest)
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	kb.prefixes = nil
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	for i, x := range bucket.list() {
+		if x.ID != oldest.ID {
+			continue
+		}
+		if alive { // 旧节点仍然存活：保留并视为最近出现，新节点作为替补
+			bucket.moveToTail(i, x)
+			bucket.addReplacement(n)
+			return false
+		}
+		bucket.removeAt(i)
+		kb.recordEviction(pos, x.ID, EvictedUnresponsive)
+		kb.checkMassEviction(pos, bucket.Len())
+		kb.quarantineLocked(x, EvictedUnresponsive)
+		break
+	}
+	if bucket.Len() >= bucket.capacity { // ping 期间 bucket 已被其他节点填满
+		bucket.addReplacement(n)
+		return false
+	}
+	bucket.appendNode(n)
+	return true
+}
+
+// 最久未出现的节点
+func (b *Bucket) oldest() (Node, bool) {
+	nodes := b.list()
+	if len(nodes) == 0 {
+		return Node{}, false
+	}
+	return nodes[0], true
+}
+
+// 替补队列的副本，最新的在末尾
+func (b *Bucket) Replacements() []Node {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Node(nil), b.replacements...)
+}
+
+// 调用方需持有 b.mu
+func (b *Bucket) addReplacement(n Node) {
+	for i, x := range b.replacements {
+		if x.ID == n.ID {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+			break
+		}
+	}
+	b.replacements = append(b.replacements, n)
+	if len(b.replacements) > b.capacity { // 只保留最近的替补
+		b.replacements = b.replacements[1:]
+	}
+}
+
+// 用最新的替补填补空位，调用方需持有 b.mu
+func (b *Bucket) promoteReplacement() {
+	if b.Len() >= b.capacity || len(b.replacements) == 0 {
+		return
+	}
+	last := len(b.replacements) - 1
+	b.appendNode(b.replacements[last])
+	b.replacements = b.replacements[:last]
+}
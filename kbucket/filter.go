@@ -0,0 +1,44 @@
+package kbucket
+
+// 设置加入路由表的准入检查：返回 false 的节点不会加入路由表或替补队列，
+// nil 表示不检查。检查在不持有锁时调用，Refilter 中除外
+func (kb *KBucket) SetFilter(fn func(Node) bool) {
+	kb.mu.Lock()
+	kb.filter = fn
+	kb.mu.Unlock()
+}
+
+func (kb *KBucket) admits(n Node) bool {
+	kb.mu.RLock()
+	filter := kb.filter
+	kb.mu.RUnlock()
+	return filter == nil || filter(n)
+}
+
+// 准入检查变得更严格之后（例如封禁了节点）删除不再通过检查的节点与替补，
+// 返回从路由表中删除的节点数。持有 kb.mu 时调用检查函数，检查函数不能访问路由表
+func (kb *KBucket) Refilter() int {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if kb.filter == nil {
+		return 0
+	}
+	removed := 0
+	for d, bucket := range kb.spine {
+		bucket.mu.Lock()
+		kept := bucket.replacements[:0]
+		for _, n := range bucket.replacements { // 先清理替补，删除节点时不会补入被拒绝的替补
+			if kb.filter(n) {
+				kept = append(kept, n)
+			}
+		}
+		bucket.replacements = kept
+		bucket.mu.Unlock()
+		for _, n := range bucket.Nodes() {
+			if !kb.filter(n) && kb.evictLocked(IdSize*8-1-d, n.ID, EvictedFiltered) {
+				removed++
+			}
+		}
+	}
+	return removed
+}
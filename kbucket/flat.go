@@ -0,0 +1,21 @@
+package kbucket
+
+import "math"
+
+// 创建扁平路由表：只有一个不分裂的 bucket，最多保存 capacity 个节点，不大于 0 时不限制。
+// 适合节点数不多、每个节点都能认识全部其他节点的小网络。k 用于 MaxNodes，
+// 即查找返回的节点数，不大于 0 时使用 BucketSize
+func NewFlatKBucket(nodeId [IdSize]byte, k, capacity int) *KBucket {
+	kb := NewKBucket(nodeId, k)
+	if capacity <= 0 {
+		capacity = math.MaxInt
+	}
+	kb.spine[0] = &Bucket{capacity: capacity} // 容量可能很大，不预先分配
+	kb.flat = true
+	return kb
+}
+
+// 是否为 NewFlatKBucket 创建的扁平路由表
+func (kb *KBucket) Flat() bool {
+	return kb.flat
+}
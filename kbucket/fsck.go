@@ -0,0 +1,111 @@
+package kbucket
+
+import "fmt"
+
+type IssueKind int
+
+const (
+	IssueMisplaced IssueKind = iota // 节点所在 bucket 与其距离不符
+	IssueDuplicate                  // 同一个 ID 出现多次
+	IssueSelf                       // 路由表中出现自身 ID
+	IssueOverfull                   // bucket 超出容量
+)
+
+func (k IssueKind) String() string {
+	switch k {
+	case IssueMisplaced:
+		return "misplaced"
+	case IssueDuplicate:
+		return "duplicate"
+	case IssueSelf:
+		return "self"
+	case IssueOverfull:
+		return "overfull"
+	}
+	return "unknown"
+}
+
+type CheckIssue struct {
+	Kind   IssueKind
+	Bucket int
+	ID     [IdSize]byte
+}
+
+func (i CheckIssue) String() string {
+	return fmt.Sprintf("bucket %d: %s %x", i.Bucket, i.Kind, i.ID)
+}
+
+type CheckReport struct {
+	Issues    []CheckIssue
+	Relocated int // 修复时移动到正确 bucket 的节点数
+	Dropped   int // 修复时删除的节点数
+}
+
+func (r CheckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// 检查路由表一致性：每个节点都位于与其距离对应的 bucket、没有重复节点、
+// 不包含自身、bucket 不超出容量。repair 为 true 时把错放的节点移动到正确的
+// bucket（目标已满则删除），并删除重复、自身以及超出容量的节点
+func (kb *KBucket) Check(repair bool) CheckReport {
+	var report CheckReport
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if repair {
+		kb.prefixes = nil
+	}
+	seen := make(map[[IdSize]byte]bool)
+	var misplaced []Node
+	for pos := kb.HomeBucket(); pos < IdSize*8; pos++ {
+		bucket := kb.bucketLocked(pos)
+		bucket.mu.Lock()
+		nodes := bucket.list()
+		before := len(nodes)
+		var kept []Node
+		for _, node := range nodes {
+			var kind IssueKind
+			switch {
+			case node.ID == kb.selfId:
+				kind = IssueSelf
+			case seen[node.ID]:
+				kind = IssueDuplicate
+			case kb.BucketIndex(node.ID) != pos:
+				kind = IssueMisplaced
+			case len(kept) >= bucket.capacity:
+				kind = IssueOverfull
+			default:
+				seen[node.ID] = true
+				kept = append(kept, node)
+				continue
+			}
+			report.Issues = append(report.Issues, CheckIssue{Kind: kind, Bucket: pos, ID: node.ID})
+			if !repair {
+				kept = append(kept, node)
+				if kind != IssueSelf {
+					seen[node.ID] = true
+				}
+				continue
+			}
+			if kind == IssueMisplaced {
+				seen[node.ID] = true
+				misplaced = append(misplaced, node)
+			} else {
+				report.Dropped++
+			}
+		}
+		bucket.setNodes(kept)
+		bucket.mu.Unlock()
+		if removed := before - len(kept); removed > 0 && repair {
+			kb.recordRegion(pos, RegionRepaired, len(kept), removed)
+		}
+	}
+	for _, node := range misplaced {
+		if kb.bucketLocked(kb.BucketIndex(node.ID)).insertNode(node) {
+			report.Relocated++
+		} else {
+			report.Dropped++
+		}
+	}
+	return report
+}
@@ -0,0 +1,109 @@
+package kbucket
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	regionHistorySize   = 32          // 每个 bucket 保留的事件数
+	massEvictionWindow  = time.Minute // 统计批量淘汰的时间窗口
+	massEvictionMinimum = 2           // 窗口内至少淘汰多少个节点才算批量淘汰
+)
+
+// 路由表区域（bucket）的结构性事件。路由表只会分裂包含自身 ID 的 bucket，
+// 不会合并，所以没有合并事件
+type RegionEventKind int
+
+const (
+	RegionSplit        RegionEventKind = iota // 包含自身 ID 的 bucket 分裂，更近的一半移入新 bucket
+	RegionCreated                             // 由分裂产生的新 bucket
+	RegionMassEviction                        // 窗口内淘汰的节点数达到 bucket 容量的一半
+	RegionRefreshed                           // 对该区域进行了刷新查找，见 RecordRefresh
+	RegionRepaired                            // Check 修复时在该区域移出或删除了节点
+)
+
+func (k RegionEventKind) String() string {
+	switch k {
+	case RegionSplit:
+		return "split"
+	case RegionCreated:
+		return "created"
+	case RegionMassEviction:
+		return "mass_eviction"
+	case RegionRefreshed:
+		return "refreshed"
+	case RegionRepaired:
+		return "repaired"
+	}
+	return "unknown"
+}
+
+type RegionEvent struct {
+	Time     time.Time
+	Bucket   int
+	Kind     RegionEventKind
+	Contacts int // 事件之后 bucket 中的节点数
+	Count    int // 分裂时移出、批量淘汰时淘汰、修复时移出或删除的节点数
+}
+
+// contacts 为事件之后 bucket 中的节点数，调用方需持有 kb.mu 的写锁
+func (kb *KBucket) recordRegion(pos int, kind RegionEventKind, contacts, count int) {
+	if kb.aging.regions == nil {
+		kb.aging.regions = make(map[int][]RegionEvent)
+	}
+	e := RegionEvent{Time: time.Now(), Bucket: pos, Kind: kind, Contacts: contacts, Count: count}
+	events := append(kb.aging.regions[pos], e)
+	if len(events) > regionHistorySize {
+		events = events[1:]
+	}
+	kb.aging.regions[pos] = events
+}
+
+// 在 recordEviction 之后调用：pos 在窗口内的淘汰数恰好达到阈值时记录一次批量淘汰。
+// 调用方需持有 kb.mu 的写锁
+func (kb *KBucket) checkMassEviction(pos, contacts int) {
+	threshold := max(kb.bucketLocked(pos).capacity/2, massEvictionMinimum)
+	since := time.Now().Add(-massEvictionWindow)
+	n := 0
+	for i := len(kb.aging.evictions) - 1; i >= 0 && kb.aging.evictions[i].Time.After(since); i-- {
+		if kb.aging.evictions[i].Bucket == pos {
+			n++
+		}
+	}
+	if n == threshold {
+		kb.recordRegion(pos, RegionMassEviction, contacts, n)
+	}
+}
+
+// 记录一次对 pos 对应区域的刷新查找，由调用方在查找完成后调用
+func (kb *KBucket) RecordRefresh(pos int) {
+	bucket := kb.GetBucket(pos)
+	kb.mu.Lock()
+	kb.recordRegion(pos, RegionRefreshed, bucket.Len(), 0)
+	kb.mu.Unlock()
+}
+
+// pos 对应 bucket 最近的事件，从旧到新
+func (kb *KBucket) RegionHistory(pos int) []RegionEvent {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return append([]RegionEvent(nil), kb.aging.regions[pos]...)
+}
+
+// 所有 bucket 最近的事件，按时间从旧到新
+func (kb *KBucket) RegionHistories() []RegionEvent {
+	kb.mu.RLock()
+	var events []RegionEvent
+	for _, h := range kb.aging.regions {
+		events = append(events, h...)
+	}
+	kb.mu.RUnlock()
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		return events[i].Bucket > events[j].Bucket // 分裂先于它产生的 bucket
+	})
+	return events
+}
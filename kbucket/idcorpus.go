@@ -0,0 +1,75 @@
+package kbucket
+
+import "math/rand"
+
+// 生成用于压测分裂和淘汰逻辑的边界 ID 集合。所有函数都使用传入的 r，
+// 相同的种子总是得到相同的 ID 集合
+
+// 与 base 共享前 prefixBits 位、其余位随机的 ID
+func SharedPrefixIDs(r *rand.Rand, base [IdSize]byte, prefixBits, n int) [][IdSize]byte {
+	ids := make([][IdSize]byte, n)
+	for i := range ids {
+		r.Read(ids[i][:])
+		ids[i] = withPrefix(ids[i], base, prefixBits)
+	}
+	return ids
+}
+
+// 与 self 只在最后几位不同的 ID，即 self 的近邻
+func NeighborIDs(self [IdSize]byte, n int) [][IdSize]byte {
+	ids := make([][IdSize]byte, 0, n)
+	for i := 1; len(ids) < n && i < 1<<16; i++ {
+		id := self
+		id[IdSize-1] ^= byte(i)
+		id[IdSize-2] ^= byte(i >> 8)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// 全零、全一、交替位以及只有单个比特为 1 的 ID
+func PatternIDs() [][IdSize]byte {
+	var zero, ones, alt, altInv [IdSize]byte
+	for i := 0; i < IdSize; i++ {
+		ones[i] = 0xff
+		alt[i] = 0xaa
+		altInv[i] = 0x55
+	}
+	ids := [][IdSize]byte{zero, ones, alt, altInv}
+	for bit := 0; bit < IdSize*8; bit++ {
+		var id [IdSize]byte
+		id[bit/8] = 0x80 >> uint(bit%8)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// 聚集在 clusters 个随机子网（共享 prefixBits 位前缀）中的 ID
+func ClusteredIDs(r *rand.Rand, clusters, perCluster, prefixBits int) [][IdSize]byte {
+	ids := make([][IdSize]byte, 0, clusters*perCluster)
+	for c := 0; c < clusters; c++ {
+		var base [IdSize]byte
+		r.Read(base[:])
+		ids = append(ids, SharedPrefixIDs(r, base, prefixBits, perCluster)...)
+	}
+	return ids
+}
+
+// 针对 self 组合以上所有类型的对抗性 ID 集合
+func AdversarialIDs(seed int64, self [IdSize]byte, n int) [][IdSize]byte {
+	r := rand.New(rand.NewSource(seed))
+	ids := PatternIDs()
+	ids = append(ids, NeighborIDs(self, n)...)
+	ids = append(ids, SharedPrefixIDs(r, self, IdSize*4, n)...)
+	ids = append(ids, SharedPrefixIDs(r, self, IdSize*8-8, n)...)
+	ids = append(ids, ClusteredIDs(r, 4, n/4+1, 24)...)
+	return ids
+}
+
+func withPrefix(id, base [IdSize]byte, prefixBits int) [IdSize]byte {
+	for i := 0; i < prefixBits && i < IdSize*8; i++ {
+		mask := byte(0x80 >> uint(i%8))
+		id[i/8] = id[i/8]&^mask | base[i/8]&mask
+	}
+	return id
+}
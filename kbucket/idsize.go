@@ -0,0 +1,7 @@
+//go:build !kbucket_id256
+
+package kbucket
+
+// ID 的字节数。默认与 SHA-1 摘要等长，使用 kbucket_id256 构建标签时为 32 字节（SHA-256）。
+// bucket 数组的大小随之变化
+const IdSize = 20
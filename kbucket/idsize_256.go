@@ -0,0 +1,6 @@
+//go:build kbucket_id256
+
+package kbucket
+
+// 与 SHA-256 摘要等长的 ID
+const IdSize = 32
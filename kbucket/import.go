@@ -0,0 +1,63 @@
+package kbucket
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// 从爬虫导出的联系人列表批量导入路由表。每行一个节点，第一列为十六进制 ID，
+// 其余列（地址等）由 verify 自行解析；空行和 # 开头的行被忽略。
+// verify 用来确认节点可达并返回其联系方式（Node.Data），返回 nil 的节点不会导入；
+// 最多同时进行 concurrency 个验证。返回导入的节点数量
+func (kb *KBucket) ImportContacts(r io.Reader, verify func(id [IdSize]byte, fields []string) interface{}, concurrency int) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	type result struct {
+		id   [IdSize]byte
+		data interface{}
+	}
+	results := make(chan result)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var parseErr error
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			raw, err := hex.DecodeString(fields[0])
+			if err != nil || len(raw) != IdSize {
+				parseErr = fmt.Errorf("kbucket: line %d: invalid node id %q", line, fields[0])
+				break
+			}
+			var id [IdSize]byte
+			copy(id[:], raw)
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(fields []string) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results <- result{id: id, data: verify(id, fields)}
+			}(fields[1:])
+		}
+		if parseErr == nil {
+			parseErr = scanner.Err()
+		}
+		wg.Wait()
+		close(results)
+	}()
+	imported := 0
+	for res := range results { // 路由表只在当前 goroutine 中修改
+		if res.data != nil && res.id != kb.selfId && kb.InsertNode(Node{ID: res.id, Data: res.data}) {
+			imported++
+		}
+	}
+	return imported, parseErr
+}
@@ -0,0 +1,130 @@
+package kbucket
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 一个 bucket 的状态
+type BucketInfo struct {
+	Index        int
+	Capacity     int
+	Nodes        []Node // 按最近出现的时间从旧到新
+	Replacements int    // 等待补位的节点数
+	LastLookup   time.Time
+	MeanRTT      time.Duration // 已测量 RTT 的节点的平均值，0 表示都没有测量
+}
+
+// 返回所有非空 bucket 的状态，按索引从小（近）到大（远）排列
+func (kb *KBucket) Snapshot() []BucketInfo {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	var infos []BucketInfo
+	for pos := kb.HomeBucket(); pos < IdSize*8; pos++ {
+		b := kb.bucketLocked(pos)
+		b.mu.RLock()
+		if nodes := b.list(); len(nodes) > 0 {
+			infos = append(infos, BucketInfo{
+				Index:        pos,
+				Capacity:     b.capacity,
+				Nodes:        append([]Node(nil), nodes...),
+				Replacements: len(b.replacements),
+				LastLookup:   b.lastLookup,
+				MeanRTT:      meanRTT(nodes),
+			})
+		}
+		b.mu.RUnlock()
+	}
+	return infos
+}
+
+func meanRTT(nodes []Node) time.Duration {
+	var sum time.Duration
+	n := 0
+	for _, node := range nodes {
+		if node.RTT > 0 {
+			sum += node.RTT
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
+}
+
+// 路由表中的节点数量
+func (kb *KBucket) Size() int {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	n := 0
+	for _, b := range kb.spine {
+		n += b.Len()
+	}
+	return n
+}
+
+// 路由表中的所有节点，与 AllNodes 相同
+func (kb *KBucket) Contacts() []Node {
+	return kb.AllNodes()
+}
+
+// 按 bucket 列出节点 ID，格式与 PrintKBucket 的输出相同
+func (kb *KBucket) String() string {
+	var b strings.Builder
+	for _, info := range kb.Snapshot() {
+		fmt.Fprintf(&b, "Bucket %d:\n", info.Index)
+		for i, node := range info.Nodes {
+			fmt.Fprintf(&b, "序号: %d nodeID: %x\n", i, node.ID)
+		}
+	}
+	return b.String()
+}
+
+type nodeJSON struct {
+	ID       string     `json:"id"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	RTT      float64    `json:"rtt_ms,omitempty"`
+}
+
+type bucketJSON struct {
+	Index        int        `json:"index"`
+	Capacity     int        `json:"capacity"`
+	Replacements int        `json:"replacements"`
+	LastLookup   *time.Time `json:"last_lookup,omitempty"`
+	MeanRTT      float64    `json:"mean_rtt_ms,omitempty"`
+	Nodes        []nodeJSON `json:"nodes"`
+}
+
+func (kb *KBucket) MarshalJSON() ([]byte, error) {
+	buckets := []bucketJSON{}
+	size := 0
+	for _, info := range kb.Snapshot() {
+		b := bucketJSON{Index: info.Index, Capacity: info.Capacity, Replacements: info.Replacements, MeanRTT: millis(info.MeanRTT)}
+		if !info.LastLookup.IsZero() {
+			b.LastLookup = &info.LastLookup
+		}
+		for _, node := range info.Nodes {
+			n := nodeJSON{ID: hex.EncodeToString(node.ID[:]), RTT: millis(node.RTT)}
+			if !node.LastSeen.IsZero() {
+				n.LastSeen = &node.LastSeen
+			}
+			b.Nodes = append(b.Nodes, n)
+		}
+		size += len(info.Nodes)
+		buckets = append(buckets, b)
+	}
+	return json.Marshal(struct {
+		Self    string       `json:"self"`
+		Home    int          `json:"home"`
+		Size    int          `json:"size"`
+		Buckets []bucketJSON `json:"buckets"`
+	}{hex.EncodeToString(kb.selfId[:]), kb.HomeBucket(), size, buckets})
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
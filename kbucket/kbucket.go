@@ -0,0 +1,438 @@
+// Package kbucket 实现 Kademlia 的 K-Bucket 路由表
+package kbucket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 定义常量
+const (
+	BucketSize = 3 //每个bucket的最大容量
+)
+
+type Node struct {
+	ID       [IdSize]byte  //节点ID长度为IdSize
+	Data     interface{}   //节点存储的数据
+	LastSeen time.Time     // 最近一次确认节点存活的时间，零值表示尚未验证
+	Failures int           // 上次确认存活之后连续联系失败的次数
+	RTT      time.Duration // 平滑后的往返时间，0 表示还没有测量，见 ObserveRTT
+}
+
+// 节点列表写时复制：修改时持有 mu 写锁、复制出新的切片再原子地换上，已发布的切片不再修改，
+// 读取节点列表（查找最近节点、FindNode 等）不需要加锁，不会与 MarkSeen、ObserveRTT 等写入互相等待
+type Bucket struct {
+	mu           sync.RWMutex           // 保护 replacements 与 lastLookup，并串行化对 nodes 的修改
+	capacity     int                    // 最多保存的节点数
+	nodes        atomic.Pointer[[]Node] //节点列表，按最近一次出现的时间从旧到新排列
+	replacements []Node                 // bucket 已满时等待补位的节点，最新的在末尾
+	lastLookup   time.Time              // 最近一次查找经过该 bucket 的时间
+}
+
+// 路由表可以在多个 goroutine 中同时使用。加锁顺序为先 KBucket.mu 后 Bucket.mu
+type KBucket struct {
+	mu        sync.RWMutex    // 保护 spine、prefixes、onInsert、filter 与 metric
+	selfId    [IdSize]byte    // 自身节点的ID
+	maxNodes  int             // 每个bucket的最大节点数量
+	onInsert  func(Node)      // 节点加入路由表时的回调
+	filter    func(Node) bool // 准入检查，nil 表示不检查
+	prefixes  *prefixSummary  // 节点 ID 前缀摘要，nil 表示需要重建
+	pinger    func(Node) bool // bucket 已满时检查最久未出现的节点是否存活
+	aging     agingLog        // 老化采样与淘汰事件
+	metrics   Metrics         // 指标回调，nil 表示不收集
+	conflict  ConflictPolicy  // 同一 ID 出现不同联系人时的处理策略
+	conflicts atomic.Uint64   // 发现过的 ID 冲突次数
+	rng       *rand.Rand      // 刷新目标与抽样使用的随机数源，nil 表示使用全局随机数源
+	stale     atomic.Int32    // 连续失败多少次后视为失效，0 表示使用 DefaultStaleFailures
+	flat      bool            // 扁平路由表，home bucket 不分裂，见 NewFlatKBucket
+	metric    Metric          // 距离度量，nil 表示 XOR
+
+	// 按论文中的二叉前缀树组织的 bucket。只有包含自身 ID 的叶子会分裂，树因此退化为
+	// 沿自身 ID 的一条链：spine[d] 是与自身共享 d 位前缀、第 d+1 位不同的节点所在的叶子，
+	// 即索引为 IdSize*8-1-d 的 bucket，最后一个叶子是 home bucket。叶子在分裂时才创建
+	spine []*Bucket
+
+	grace      time.Duration                // 隔离的宽限期，0 表示直接淘汰
+	quarantine map[[IdSize]byte]quarantined // 宽限期内可以恢复的被淘汰节点
+
+	// 包含自身 ID 所在区域的 bucket 的索引。它覆盖所有距离最高位不超过 home
+	// 的节点，满了之后分裂出更近的一半，home 随之减一
+	home atomic.Int32
+}
+
+func NewBucket() *Bucket {
+	return newBucket(BucketSize)
+}
+
+func newBucket(capacity int) *Bucket {
+	return &Bucket{capacity: capacity}
+}
+
+// 当前发布的节点列表，不需要加锁，调用方不能修改
+func (b *Bucket) list() []Node {
+	if p := b.nodes.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// 发布新的节点列表，之后不能再修改 nodes。调用方需持有 b.mu 的写锁
+func (b *Bucket) setNodes(nodes []Node) {
+	b.nodes.Store(&nodes)
+}
+
+// 在当前列表的副本上修改下标 i 处的节点并发布，返回修改后的节点。调用方需持有 b.mu 的写锁
+func (b *Bucket) modify(i int, f func(*Node)) Node {
+	nodes := append([]Node(nil), b.list()...)
+	f(&nodes[i])
+	b.setNodes(nodes)
+	return nodes[i]
+}
+
+// 发布去掉下标 i 处节点的新列表，调用方需持有 b.mu 的写锁
+func (b *Bucket) removeAt(i int) {
+	old := b.list()
+	nodes := make([]Node, 0, len(old)-1)
+	b.setNodes(append(append(nodes, old[:i]...), old[i+1:]...))
+}
+
+// 发布在末尾加上 n 的新列表，调用方需持有 b.mu 的写锁。append 只写入已发布列表长度之外的
+// 位置，读取方看不到，可以与它们共用底层数组；其他修改都复制出新的数组
+func (b *Bucket) appendNode(n Node) {
+	b.setNodes(append(b.list(), n))
+}
+
+func (b *Bucket) Len() int {
+	return len(b.list()) // 返回节点列表的长度
+}
+
+func (b *Bucket) Nodes() []Node { // 返回节点列表的副本
+	return append([]Node(nil), b.list()...)
+}
+
+func (b *Bucket) insertNode(n Node) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i := b.indexOf(n.ID); i >= 0 { // 节点已存在，则更新数据并移到末尾
+		x := b.list()[i]
+		x.Data = n.Data
+		if !n.LastSeen.IsZero() {
+			x.LastSeen = n.LastSeen
+			x.Failures = 0
+		}
+		b.moveToTail(i, x)
+		return true
+	}
+	if len(b.list()) >= b.capacity { // 超过容量，无法添加节点
+		return false
+	}
+	b.appendNode(n) // 添加新节点
+	return true
+}
+
+// 节点 id 在当前列表中的下标，不存在时返回 -1。按下标比较 ID，避免复制整个 Node。
+// 修改列表的调用方需持有 b.mu 的写锁，使下标在发布新列表之前保持有效
+func (b *Bucket) indexOf(id [IdSize]byte) int {
+	nodes := b.list()
+	for i := range nodes {
+		if nodes[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// 把下标 i 处的节点替换为 n 并移到末尾（最近出现），调用方需持有 b.mu 的写锁
+func (b *Bucket) moveToTail(i int, n Node) {
+	old := b.list()
+	nodes := make([]Node, 0, len(old))
+	nodes = append(append(nodes, old[:i]...), old[i+1:]...)
+	b.setNodes(append(nodes, n))
+}
+
+func (b *Bucket) UpdateNode(n Node) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if i := b.indexOf(n.ID); i >= 0 { // 更新节点数据
+		b.modify(i, func(x *Node) { x.Data = n.Data })
+	}
+}
+
+func (b *Bucket) RemoveNode(id [IdSize]byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.indexOf(id)
+	if i < 0 {
+		return false // 节点不存在，无法删除
+	}
+	b.removeAt(i)
+	b.promoteReplacement()
+	return true
+}
+
+func (b *Bucket) FindNode(id [IdSize]byte) (Node, bool) {
+	nodes := b.list()
+	for i := range nodes { // 查找节点
+		if nodes[i].ID == id {
+			return nodes[i], true
+		}
+	}
+	return Node{}, false // 节点不存在
+}
+
+// maxNodes 为每个 bucket 的容量（Kademlia 的 k），不大于 0 时使用 BucketSize。
+// 路由表开始时只有一个覆盖整个 keyspace 的 bucket，随节点加入逐步分裂
+func NewKBucket(nodeId [IdSize]byte, maxNodes int) *KBucket {
+	if maxNodes <= 0 {
+		maxNodes = BucketSize
+	}
+	kb := &KBucket{
+		selfId:   nodeId,
+		maxNodes: maxNodes,
+		spine:    []*Bucket{newBucket(maxNodes)},
+	}
+	kb.home.Store(IdSize*8 - 1)
+	return kb
+}
+
+// 已经分裂出来的第 pos 个 bucket，pos 不小于 home。调用方需持有 kb.mu
+func (kb *KBucket) bucketLocked(pos int) *Bucket {
+	return kb.spine[IdSize*8-1-pos]
+}
+
+// ID 中前导零的个数：每次检查 8 个字节，剩余不足 8 个的逐字节检查
+func leadingZeros(id [IdSize]byte) int {
+	i := 0
+	for ; i+8 <= IdSize; i += 8 {
+		if x := binary.BigEndian.Uint64(id[i:]); x != 0 {
+			return i*8 + bits.LeadingZeros64(x)
+		}
+	}
+	for ; i < IdSize; i++ {
+		if id[i] != 0 {
+			return i*8 + bits.LeadingZeros8(id[i])
+		}
+	}
+	return IdSize * 8
+}
+
+// 获取指定位置的 bucket。home 以下尚未分裂出来的位置返回一个空的 bucket
+func (kb *KBucket) GetBucket(pos int) *Bucket {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	if pos < kb.HomeBucket() {
+		return &Bucket{capacity: kb.maxNodes}
+	}
+	return kb.bucketLocked(pos)
+}
+
+func (kb *KBucket) SelfID() [IdSize]byte {
+	return kb.selfId
+}
+
+func (kb *KBucket) MaxNodes() int {
+	return kb.maxNodes
+}
+
+// 自身 ID 所在 bucket 的索引。索引更小的 bucket 尚未分裂出来，总是为空
+func (kb *KBucket) HomeBucket() int {
+	return int(kb.home.Load())
+}
+
+// 按与自身 ID 的 XOR 距离计算 bucket 索引：距离的最高位为 1 的位置。
+// 尚未分裂出来的近距离区域都归入自身所在的 bucket
+func (kb *KBucket) BucketIndex(id [IdSize]byte) int {
+	pos := kb.DistanceIndex(id)
+	if home := int(kb.home.Load()); pos < home {
+		return home
+	}
+	return pos
+}
+
+// 与自身 ID 的 XOR 距离的最高位的位置，不归入自身所在的 bucket；id 等于自身 ID 时为 -1。
+// 按距离而不是按路由表的分裂程度判断远近时使用
+func (kb *KBucket) DistanceIndex(id [IdSize]byte) int {
+	return IdSize*8 - 1 - leadingZeros(Distance(kb.selfId, id))
+}
+
+func (kb *KBucket) InsertNode(n Node) bool {
+	if n.ID == kb.selfId { // 自身节点不需要添加
+		return true
+	}
+	if !kb.admits(n) {
+		return false
+	}
+	kb.mu.Lock()
+	if old, conflict := kb.conflictLocked(n); conflict {
+		policy, pinger := kb.conflict, kb.pinger
+		kb.mu.Unlock()
+		if !kb.resolveConflict(old, n, policy, pinger) {
+			return false
+		}
+		kb.mu.Lock()
+	}
+	if restored, ok := kb.restoreLocked(n); ok {
+		return kb.insertRestored(restored)
+	}
+	home := kb.HomeBucket()
+	ok := kb.insertLocked(n)
+	onInsert, pinger, metrics := kb.onInsert, kb.pinger, kb.metrics
+	kb.mu.Unlock()
+	if !ok && pinger != nil {
+		ok = kb.evictOrQueue(n, pinger)
+	}
+	if pos := kb.BucketIndex(n.ID); ok && pos > home {
+		kb.reportOccupancy(metrics, pos, pos)
+	}
+	// 分裂会改变新旧 home bucket 的节点数
+	kb.reportOccupancy(metrics, kb.HomeBucket(), home)
+	if ok && onInsert != nil { // 回调在释放锁之后执行，回调中可以再访问路由表
+		onInsert(n)
+	}
+	return ok
+}
+
+// 放回被隔离的节点：bucket 有空位时直接放回，否则排在替补队列的最前面，
+// 不经过已满 bucket 的存活检查。调用方持有 kb.mu 的写锁，返回前释放
+func (kb *KBucket) insertRestored(n Node) bool {
+	ok := kb.insertLocked(n)
+	if !ok {
+		bucket := kb.bucketLocked(kb.BucketIndex(n.ID))
+		bucket.mu.Lock()
+		bucket.addReplacement(n)
+		bucket.mu.Unlock()
+	}
+	onInsert := kb.onInsert
+	kb.mu.Unlock()
+	if ok && onInsert != nil {
+		onInsert(n)
+	}
+	return ok
+}
+
+// 调用方需持有 kb.mu 的写锁
+func (kb *KBucket) insertLocked(n Node) bool {
+	kb.prefixes = nil
+	for {
+		pos := kb.BucketIndex(n.ID) // 计算节点应该放置的 bucket 的索引值
+		if kb.bucketLocked(pos).insertNode(n) {
+			return true
+		}
+		// 只有包含自身 ID 的 bucket 可以分裂（扁平路由表不分裂），其余已满的 bucket 交给淘汰策略处理
+		if pos != int(kb.home.Load()) || pos == 0 || kb.flat {
+			return false
+		}
+		kb.splitHome()
+	}
+}
+
+// 把自身所在的叶子分成两半：距离最高位等于 home 的节点留在原处，
+// 其余更近的节点移入新建的 home bucket。调用方需持有 kb.mu 的写锁
+func (kb *KBucket) splitHome() {
+	home := int(kb.home.Load())
+	bucket := kb.bucketLocked(home)
+	next := newBucket(kb.maxNodes)
+	kb.spine = append(kb.spine, next)
+	kb.home.Store(int32(home - 1))
+	bucket.mu.Lock()
+	var kept, moved []Node
+	for _, node := range bucket.list() {
+		if kb.BucketIndex(node.ID) == home {
+			kept = append(kept, node)
+		} else {
+			moved = append(moved, node)
+		}
+	}
+	bucket.setNodes(kept)
+	bucket.mu.Unlock()
+	for _, node := range moved { // 新 bucket 的容量与原 bucket 相同，不会丢失节点
+		next.insertNode(node)
+	}
+	kb.recordRegion(home, RegionSplit, len(kept), len(moved))
+	kb.recordRegion(home-1, RegionCreated, len(moved), 0)
+}
+
+func (kb *KBucket) RemoveNode(id [IdSize]byte) bool {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	return kb.evictLocked(kb.BucketIndex(id), id, EvictedRemoved)
+}
+
+// 删除主动离开网络的节点。与失效的节点不同，它不会进入隔离列表
+func (kb *KBucket) RemoveDeparted(id [IdSize]byte) bool {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	return kb.evictLocked(kb.BucketIndex(id), id, EvictedDeparted)
+}
+
+// 从第 pos 个 bucket 中删除节点并记录原因，调用方需持有 kb.mu 的写锁
+func (kb *KBucket) evictLocked(pos int, id [IdSize]byte, reason EvictionReason) bool {
+	bucket := kb.bucketLocked(pos)
+	n, ok := bucket.FindNode(id)
+	if !ok || !bucket.RemoveNode(id) { // 节点不存在
+		return false
+	}
+	kb.quarantineLocked(n, reason)
+	kb.prefixes = nil
+	kb.recordEviction(pos, id, reason)
+	kb.checkMassEviction(pos, bucket.Len())
+	if kb.metrics != nil {
+		kb.metrics.BucketOccupancy(pos, bucket.Len())
+	}
+	return true
+}
+
+func (kb *KBucket) AllNodes() []Node { // 返回路由表中的所有节点
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return kb.allNodesLocked()
+}
+
+func (kb *KBucket) allNodesLocked() []Node {
+	var nodes []Node
+	for pos := kb.HomeBucket(); pos < IdSize*8; pos++ {
+		nodes = append(nodes, kb.bucketLocked(pos).list()...)
+	}
+	return nodes
+}
+
+func (kb *KBucket) PrintKBucket() { // 打印整个 K-Bucket 中的所有节点
+	fmt.Print(kb.String())
+}
+
+func (b *Bucket) LastLookup() time.Time {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastLookup
+}
+
+// 设置节点加入路由表时的回调，nil 表示不回调
+func (kb *KBucket) SetOnInsert(fn func(Node)) {
+	kb.mu.Lock()
+	kb.onInsert = fn
+	kb.mu.Unlock()
+}
+
+// a 与 b 的 XOR 距离
+func Distance(a, b [IdSize]byte) [IdSize]byte {
+	var d [IdSize]byte
+	for i := 0; i < IdSize; i++ {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// a 与 target 的距离是否小于 b 与 target 的距离
+func Closer(a, b, target [IdSize]byte) bool {
+	for i := 0; i < IdSize; i++ {
+		if da, db := a[i]^target[i], b[i]^target[i]; da != db {
+			return da < db
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package kbucket
+
+import "time"
+
+const DefaultStaleFailures = 3 // 默认连续失败多少次后视为失效
+
+// 设置连续联系失败多少次后节点视为失效，n <= 0 表示使用 DefaultStaleFailures。
+// 失效的节点不再出现在 FindClosestNodes 的结果中，等待 PruneStale 清理或重新确认存活
+func (kb *KBucket) SetStaleFailures(n int) {
+	if n < 0 {
+		n = 0
+	}
+	kb.stale.Store(int32(n))
+}
+
+func (kb *KBucket) staleFailures() int {
+	if n := kb.stale.Load(); n > 0 {
+		return int(n)
+	}
+	return DefaultStaleFailures
+}
+
+// 节点是否已经失效
+func (kb *KBucket) IsStale(n Node) bool {
+	return n.Failures >= kb.staleFailures()
+}
+
+// 记录一次联系节点失败，返回连续失败的次数，节点不在路由表中时返回 0
+func (kb *KBucket) MarkFailed(id [IdSize]byte) int {
+	bucket := kb.GetBucket(kb.BucketIndex(id))
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if i := bucket.indexOf(id); i >= 0 {
+		return bucket.modify(i, func(n *Node) { n.Failures++ }).Failures
+	}
+	return 0
+}
+
+// 记录一次对节点 id 测得的往返时间，按 7/8 的权重与之前的值平滑。
+// 不在路由表中的节点忽略
+func (kb *KBucket) ObserveRTT(id [IdSize]byte, rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+	bucket := kb.GetBucket(kb.BucketIndex(id))
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if i := bucket.indexOf(id); i >= 0 {
+		bucket.modify(i, func(n *Node) {
+			if old := n.RTT; old > 0 {
+				rtt = old + (rtt-old)/8
+			}
+			n.RTT = rtt
+		})
+	}
+}
+
+// 删除已失效且超过 maxAge 没有确认存活的节点，maxAge 为 0 时删除所有失效节点。
+// 返回删除的节点数
+func (kb *KBucket) PruneStale(maxAge time.Duration) int {
+	kb.mu.Lock()
+	now := time.Now()
+	removed := 0
+	for pos := kb.HomeBucket(); pos < IdSize*8; pos++ {
+		for _, n := range kb.bucketLocked(pos).Nodes() {
+			if kb.IsStale(n) && now.Sub(n.LastSeen) >= maxAge && kb.evictLocked(pos, n.ID, EvictedStale) {
+				removed++
+			}
+		}
+	}
+	kb.mu.Unlock()
+	return removed
+}
@@ -0,0 +1,36 @@
+package kbucket
+
+// 节点与目标之间的距离度量。bucket 的划分始终按 XOR 前缀，Metric 只决定"最近"的含义：
+// FindClosestNodes 的结果以及 dht 中查找候选与副本的排序都按它比较。
+// 实现需要可以在多个 goroutine 中同时调用
+type Metric interface {
+	// a 与 target 的距离是否小于 b 与 target 的距离
+	Closer(a, b, target [IdSize]byte) bool
+}
+
+// Kademlia 的 XOR 距离，默认的度量
+type XORMetric struct{}
+
+func (XORMetric) Closer(a, b, target [IdSize]byte) bool {
+	return Closer(a, b, target)
+}
+
+// 设置比较距离的度量，nil 表示 XORMetric。应在路由表开始使用之前调用
+func (kb *KBucket) SetDistanceMetric(m Metric) {
+	if _, xor := m.(XORMetric); xor {
+		m = nil // 使用 XOR 时保留 FindClosestNodes 按 bucket 剪枝的查询
+	}
+	kb.mu.Lock()
+	kb.metric = m
+	kb.mu.Unlock()
+}
+
+// 当前使用的距离度量
+func (kb *KBucket) DistanceMetric() Metric {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	if kb.metric == nil {
+		return XORMetric{}
+	}
+	return kb.metric
+}
@@ -0,0 +1,24 @@
+package kbucket
+
+// 路由表的指标回调。实现需要足够快且不能访问路由表，部分回调在持有路由表锁时调用
+type Metrics interface {
+	BucketOccupancy(bucket, nodes int)          // bucket 中的节点数发生变化
+	Eviction(bucket int, reason EvictionReason) // 节点被移出路由表
+}
+
+// 设置指标回调，nil 表示不收集
+func (kb *KBucket) SetMetrics(m Metrics) {
+	kb.mu.Lock()
+	kb.metrics = m
+	kb.mu.Unlock()
+}
+
+// 报告 from 到 to（含）之间各 bucket 的节点数
+func (kb *KBucket) reportOccupancy(m Metrics, from, to int) {
+	if m == nil {
+		return
+	}
+	for pos := from; pos <= to; pos++ {
+		m.BucketOccupancy(pos, kb.GetBucket(pos).Len())
+	}
+}
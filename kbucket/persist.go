@@ -0,0 +1,80 @@
+package kbucket
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const tableVersion = 1
+
+var ErrTableMismatch = errors.New("kbucket: saved table belongs to another node")
+
+type savedNode struct {
+	ID       string    `json:"id"`
+	Addr     string    `json:"addr,omitempty"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+type savedTable struct {
+	Version int         `json:"version"`
+	Self    string      `json:"self"`
+	Nodes   []savedNode `json:"nodes"` // 每个 bucket 内按最近出现的时间从旧到新
+}
+
+// 以 JSON 保存路由表，节点重启后可以用 Load 恢复而不必重新引导。
+// 实现了 fmt.Stringer 的 Node.Data（例如 *net.UDPAddr）保存为地址，其余不保存
+func (kb *KBucket) Save(w io.Writer) error {
+	t := savedTable{Version: tableVersion, Self: hex.EncodeToString(kb.selfId[:])}
+	for _, node := range kb.AllNodes() {
+		n := savedNode{ID: hex.EncodeToString(node.ID[:]), LastSeen: node.LastSeen}
+		if s, ok := node.Data.(fmt.Stringer); ok {
+			n.Addr = s.String()
+		}
+		t.Nodes = append(t.Nodes, n)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// 读取 Save 保存的路由表并插入节点。resolve 把保存的地址转换为 Node.Data，
+// 返回 nil 的节点会被跳过；resolve 为 nil 时地址字符串直接作为 Node.Data。
+// 返回恢复的节点数量
+func (kb *KBucket) Load(r io.Reader, resolve func(id [IdSize]byte, addr string) interface{}) (int, error) {
+	var t savedTable
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return 0, fmt.Errorf("kbucket: decode table: %w", err)
+	}
+	if t.Version != tableVersion {
+		return 0, fmt.Errorf("kbucket: unsupported table version %d", t.Version)
+	}
+	if t.Self != hex.EncodeToString(kb.selfId[:]) {
+		return 0, ErrTableMismatch
+	}
+	nodes := make([]Node, 0, len(t.Nodes))
+	for _, n := range t.Nodes {
+		raw, err := hex.DecodeString(n.ID)
+		if err != nil || len(raw) != IdSize {
+			return 0, fmt.Errorf("kbucket: invalid node id %q", n.ID)
+		}
+		node := Node{LastSeen: n.LastSeen, Data: n.Addr}
+		copy(node.ID[:], raw)
+		if resolve != nil {
+			if node.Data = resolve(node.ID, n.Addr); node.Data == nil {
+				continue
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	loaded := 0
+	for _, node := range nodes { // 全部解析成功后才修改路由表
+		if kb.InsertNode(node) {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
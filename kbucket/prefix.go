@@ -0,0 +1,58 @@
+package kbucket
+
+const prefixSummaryBits = 24 // 前缀摘要覆盖的最大前缀长度
+
+// 路由表中所有节点 ID 前缀的紧凑摘要：prefixes[p] 保存所有节点的前 p 位，
+// 用于快速判断“是否认识与 X 共享至少 p 位前缀的节点”而无需扫描 bucket。
+// 路由表变化后在下次查询时重建
+type prefixSummary struct {
+	prefixes [prefixSummaryBits + 1]map[uint32]bool
+}
+
+func idPrefix(id [IdSize]byte, bits int) uint32 {
+	v := uint32(id[0])<<24 | uint32(id[1])<<16 | uint32(id[2])<<8 | uint32(id[3])
+	if bits == 0 {
+		return 0
+	}
+	return v >> uint(32-bits)
+}
+
+// 调用方需持有 kb.mu 的写锁
+func (kb *KBucket) buildPrefixSummary() *prefixSummary {
+	s := &prefixSummary{}
+	for p := range s.prefixes {
+		s.prefixes[p] = make(map[uint32]bool)
+	}
+	for _, node := range kb.allNodesLocked() {
+		for p := range s.prefixes {
+			s.prefixes[p][idPrefix(node.ID, p)] = true
+		}
+	}
+	return s
+}
+
+// 路由表中是否有节点与 target 至少共享 p 位前缀
+func (kb *KBucket) KnowsPrefix(target [IdSize]byte, p int) bool {
+	if p > prefixSummaryBits { // 超出摘要覆盖范围时退化为扫描
+		for _, node := range kb.AllNodes() {
+			if CommonPrefixLen(node.ID, target) >= p {
+				return true
+			}
+		}
+		return false
+	}
+	if p < 0 {
+		p = 0
+	}
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if kb.prefixes == nil {
+		kb.prefixes = kb.buildPrefixSummary()
+	}
+	return kb.prefixes.prefixes[p][idPrefix(target, p)]
+}
+
+// a 与 b 共同前缀的比特数
+func CommonPrefixLen(a, b [IdSize]byte) int {
+	return leadingZeros(Distance(a, b))
+}
@@ -0,0 +1,147 @@
+package kbucket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 随机操作序列下路由表的不变量：Check 不报告问题（没有重复、错放、超容量的节点），
+// 插入成功的节点可以找到，删除成功的节点不再出现，FindClosestNodes 的结果
+// 与对全部节点按 XOR 距离排序的结果一致
+type opRunner struct {
+	t    *testing.T
+	kb   *KBucket
+	pool [][IdSize]byte // 操作的节点从这里选取，包含靠近自身的节点以触发分裂
+	ever map[[IdSize]byte]bool
+}
+
+func newOpRunner(t *testing.T, seed int64, capacity int) *opRunner {
+	r := rand.New(rand.NewSource(seed))
+	self := randomID(r)
+	pool := append(AdversarialIDs(seed, self, 64), NeighborIDs(self, 32)...)
+	pool = append(pool, randomIDs(r, 64)...)
+	kb := NewKBucket(self, capacity)
+	if seed%2 == 1 {
+		kb.SetQuarantine(time.Hour) // 被淘汰的节点可以恢复，覆盖 restoreLocked
+	}
+	return &opRunner{t: t, kb: kb, pool: pool, ever: make(map[[IdSize]byte]bool)}
+}
+
+// 执行一步操作，op 与 arg 决定操作的种类与对象
+func (o *opRunner) step(op, arg byte) {
+	t, kb := o.t, o.kb
+	t.Helper()
+	id := o.pool[int(arg)%len(o.pool)]
+	switch op % 7 {
+	case 0, 1: // 插入的比例高一些，路由表才能长到需要分裂
+		if id == kb.SelfID() {
+			return
+		}
+		o.ever[id] = true
+		if kb.InsertNode(Node{ID: id}) {
+			if _, ok := kb.GetBucket(kb.BucketIndex(id)).FindNode(id); !ok {
+				t.Fatalf("node %x accepted but not findable", id)
+			}
+		}
+	case 2:
+		if kb.RemoveNode(id) {
+			if _, ok := kb.GetBucket(kb.BucketIndex(id)).FindNode(id); ok {
+				t.Fatalf("node %x removed but still present", id)
+			}
+		}
+	case 3:
+		kb.MarkFailed(id)
+	case 4:
+		kb.MarkSeen(id, time.Now())
+	case 5:
+		kb.PruneStale(0)
+	case 6:
+		o.checkClosest(id, 1+int(arg)%(2*kb.MaxNodes()))
+	}
+	o.checkInvariants()
+}
+
+func (o *opRunner) checkInvariants() {
+	t, kb := o.t, o.kb
+	t.Helper()
+	if report := kb.Check(false); !report.OK() {
+		t.Fatalf("inconsistent routing table: %v", report.Issues)
+	}
+	for pos := 0; pos < IdSize*8; pos++ {
+		if n := kb.GetBucket(pos).Len(); n > kb.MaxNodes() {
+			t.Fatalf("bucket %d holds %d nodes, capacity %d", pos, n, kb.MaxNodes())
+		}
+	}
+	all := kb.AllNodes()
+	if len(all) != kb.Size() {
+		t.Fatalf("AllNodes returns %d nodes, Size reports %d", len(all), kb.Size())
+	}
+	for _, n := range all {
+		if !o.ever[n.ID] {
+			t.Fatalf("node %x in table but never inserted", n.ID)
+		}
+	}
+}
+
+// FindClosestNodes 与暴力排序的前 k 个一致
+func (o *opRunner) checkClosest(target [IdSize]byte, k int) {
+	t, kb := o.t, o.kb
+	t.Helper()
+	var want []Node
+	for _, n := range kb.AllNodes() {
+		if !kb.IsStale(n) {
+			want = append(want, n)
+		}
+	}
+	sort.Slice(want, func(i, j int) bool {
+		di, dj := Distance(want[i].ID, target), Distance(want[j].ID, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+	if len(want) > k {
+		want = want[:k]
+	}
+	got := kb.FindClosestNodes(target, k)
+	if len(got) != len(want) {
+		t.Fatalf("FindClosestNodes(%x, %d) returned %d nodes, want %d", target, k, len(got), len(want))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID {
+			t.Fatalf("FindClosestNodes(%x, %d)[%d] = %x, want %x", target, k, i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestRoutingTableProperties(t *testing.T) {
+	steps := 1000
+	if testing.Short() {
+		steps = 300
+	}
+	for seed := int64(0); seed < 16; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		o := newOpRunner(t, seed, 1+r.Intn(BucketSize))
+		for i := 0; i < steps; i++ {
+			o.step(byte(r.Intn(256)), byte(r.Intn(256)))
+		}
+	}
+}
+
+// 前 8 字节是种子，第 9 字节决定 bucket 容量，其余每两个字节是一步操作
+func FuzzRoutingTableOps(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 1, 2, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5})
+	f.Add(bytes.Repeat([]byte{7, 0, 200, 1, 3, 0, 9, 4, 40}, 20))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) < 9 {
+			return
+		}
+		seed := int64(binary.BigEndian.Uint64(data))
+		o := newOpRunner(t, seed, 1+int(data[8])%BucketSize)
+		ops := data[9:]
+		for i := 0; i+1 < len(ops); i += 2 {
+			o.step(ops[i], ops[i+1])
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package kbucket
+
+import "time"
+
+// 因疑似失效而移出路由表、在宽限期内仍可恢复的节点
+type quarantined struct {
+	node  Node
+	until time.Time
+}
+
+// 设置隔离的宽限期。设置之后，因无响应或失效而淘汰的节点先进入隔离列表，
+// 宽限期内再次出现（InsertNode 带有存活时间）时立即恢复，保留原有的联系信息，
+// 不需要重新经过已满 bucket 的存活检查。0 表示直接淘汰（默认）
+func (kb *KBucket) SetQuarantine(grace time.Duration) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	kb.grace = grace
+	if grace <= 0 {
+		kb.quarantine = nil
+	}
+}
+
+// 隔离列表中尚未过期的节点
+func (kb *KBucket) Quarantined() []Node {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	kb.expireQuarantineLocked(time.Now())
+	nodes := make([]Node, 0, len(kb.quarantine))
+	for _, q := range kb.quarantine {
+		nodes = append(nodes, q.node)
+	}
+	return nodes
+}
+
+// 把被淘汰的节点放入隔离列表，未启用隔离时不做任何事。调用方需持有 kb.mu 的写锁
+func (kb *KBucket) quarantineLocked(n Node, reason EvictionReason) {
+	if kb.grace <= 0 || (reason != EvictedUnresponsive && reason != EvictedStale) {
+		return
+	}
+	now := time.Now()
+	kb.expireQuarantineLocked(now)
+	if kb.quarantine == nil {
+		kb.quarantine = make(map[[IdSize]byte]quarantined)
+	}
+	kb.quarantine[n.ID] = quarantined{node: n, until: now.Add(kb.grace)}
+}
+
+// 调用方需持有 kb.mu 的写锁
+func (kb *KBucket) expireQuarantineLocked(now time.Time) {
+	for id, q := range kb.quarantine {
+		if now.After(q.until) {
+			delete(kb.quarantine, id)
+		}
+	}
+}
+
+// 节点 n 在宽限期内再次出现时把它移出隔离列表，返回恢复后的节点。调用方需持有 kb.mu 的写锁
+func (kb *KBucket) restoreLocked(n Node) (Node, bool) {
+	q, ok := kb.quarantine[n.ID]
+	if !ok || n.LastSeen.IsZero() {
+		return Node{}, false
+	}
+	delete(kb.quarantine, n.ID)
+	if n.LastSeen.After(q.until) {
+		return Node{}, false
+	}
+	restored := q.node
+	if n.Data != nil {
+		restored.Data = n.Data
+	}
+	restored.LastSeen = n.LastSeen
+	restored.Failures = 0
+	return restored, true
+}
@@ -0,0 +1,69 @@
+package kbucket
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// 记录查找经过 pos 对应 bucket 的时间
+func (kb *KBucket) Touch(pos int) {
+	bucket := kb.GetBucket(pos)
+	bucket.mu.Lock()
+	bucket.lastLookup = time.Now()
+	bucket.mu.Unlock()
+}
+
+func (kb *KBucket) LastLookup(pos int) time.Time {
+	return kb.GetBucket(pos).LastLookup()
+}
+
+// 返回超过 maxAge 没有被查找经过的 bucket 索引，最久未查找的排在前面，
+// 刷新时优先处理这些真正陈旧的 keyspace 区域。尚未分裂出来的 bucket 不计入
+func (kb *KBucket) StaleBuckets(maxAge time.Duration) []int {
+	deadline := time.Now().Add(-maxAge)
+	var stale []int
+	var last [IdSize * 8]time.Time
+	for i := kb.HomeBucket(); i < len(last); i++ {
+		last[i] = kb.LastLookup(i)
+		if last[i].Before(deadline) {
+			stale = append(stale, i)
+		}
+	}
+	sort.SliceStable(stale, func(i, j int) bool {
+		return last[stale[i]].Before(last[stale[j]])
+	})
+	return stale
+}
+
+// 设置生成刷新目标与抽样联系人使用的随机数源，nil 表示使用全局随机数源。
+// 仿真中传入固定种子的 r 可以复现路由表的构建；r 不能同时被多个 goroutine 使用
+func (kb *KBucket) SetRand(r *rand.Rand) {
+	kb.mu.Lock()
+	kb.rng = r
+	kb.mu.Unlock()
+}
+
+func (kb *KBucket) random() *rand.Rand {
+	kb.mu.RLock()
+	defer kb.mu.RUnlock()
+	return kb.rng
+}
+
+// 生成一个落在 pos 对应 bucket 范围内的随机 ID，用作刷新查找的目标
+func (kb *KBucket) RefreshTarget(pos int) [IdSize]byte {
+	var id [IdSize]byte
+	if r := kb.random(); r != nil {
+		r.Read(id[:])
+	} else {
+		rand.Read(id[:])
+	}
+	zeros := IdSize*8 - 1 - pos
+	for i := 0; i < zeros; i++ { // 距离的前导零个数决定 bucket 索引
+		id[i/8] &^= 0x80 >> uint(i%8)
+	}
+	if zeros < IdSize*8 {
+		id[zeros/8] |= 0x80 >> uint(zeros%8)
+	}
+	return Distance(kb.selfId, id) // 上面生成的是距离，转换为 ID
+}
@@ -0,0 +1,71 @@
+package kbucket
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// 从路由表中不放回地抽取最多 n 个节点。bias 取值 0~1：0 表示均匀抽样，
+// 1 表示完全按陈旧程度加权，越久没有确认存活（或从未验证）的节点越容易被抽中
+func (kb *KBucket) SampleContacts(n int, bias float64) []Node {
+	nodes := kb.AllNodes()
+	if n <= 0 || len(nodes) == 0 {
+		return nil
+	}
+	bias = math.Max(0, math.Min(1, bias))
+	now := time.Now()
+	var maxAge time.Duration
+	for _, node := range nodes {
+		if !node.LastSeen.IsZero() && now.Sub(node.LastSeen) > maxAge {
+			maxAge = now.Sub(node.LastSeen)
+		}
+	}
+	type keyed struct {
+		node Node
+		key  float64
+	}
+	sample := make([]keyed, len(nodes))
+	r := kb.random()
+	for i, node := range nodes {
+		staleness := 1.0 // 未验证的节点视为最陈旧
+		if !node.LastSeen.IsZero() && maxAge > 0 {
+			staleness = float64(now.Sub(node.LastSeen)) / float64(maxAge)
+		} else if !node.LastSeen.IsZero() {
+			staleness = 0
+		}
+		weight := (1 - bias) + bias*staleness
+		if weight <= 0 {
+			weight = 1e-9
+		}
+		// 加权不放回抽样：key = u^(1/w)，取 key 最大的 n 个
+		u := rand.Float64()
+		if r != nil {
+			u = r.Float64()
+		}
+		sample[i] = keyed{node: node, key: math.Pow(u, 1/weight)}
+	}
+	sort.Slice(sample, func(i, j int) bool { return sample[i].key > sample[j].key })
+	if n > len(sample) {
+		n = len(sample)
+	}
+	result := make([]Node, n)
+	for i := range result {
+		result[i] = sample[i].node
+	}
+	return result
+}
+
+// 记录节点存活，同时清零连续失败次数
+func (kb *KBucket) MarkSeen(id [IdSize]byte, at time.Time) {
+	bucket := kb.GetBucket(kb.BucketIndex(id))
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if i := bucket.indexOf(id); i >= 0 {
+		n := bucket.list()[i]
+		n.LastSeen = at
+		n.Failures = 0
+		bucket.moveToTail(i, n)
+	}
+}
@@ -0,0 +1,96 @@
+package kbucket
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// 检查路由表的不变量：每个接受过的节点都能在其所属 bucket 中找到，
+// 表中没有多余的节点，各 bucket 不超出容量
+func checkTable(t *testing.T, kb *KBucket, accepted map[[IdSize]byte]bool) {
+	t.Helper()
+	if report := kb.Check(false); !report.OK() {
+		t.Fatalf("inconsistent routing table: %v", report.Issues)
+	}
+	for id := range accepted {
+		if _, ok := kb.GetBucket(kb.BucketIndex(id)).FindNode(id); !ok {
+			t.Fatalf("node %x accepted but not findable in bucket %d", id, kb.BucketIndex(id))
+		}
+	}
+	if all := kb.AllNodes(); len(all) != len(accepted) {
+		t.Fatalf("table holds %d nodes, %d were accepted", len(all), len(accepted))
+	}
+	for pos := 0; pos < IdSize*8; pos++ {
+		if n := kb.GetBucket(pos).Len(); n > kb.MaxNodes() {
+			t.Fatalf("bucket %d holds %d nodes, capacity %d", pos, n, kb.MaxNodes())
+		}
+	}
+}
+
+func insertAll(t *testing.T, kb *KBucket, ids [][IdSize]byte, accepted map[[IdSize]byte]bool) {
+	t.Helper()
+	for _, id := range ids {
+		if id == kb.SelfID() {
+			continue
+		}
+		if kb.InsertNode(Node{ID: id}) {
+			accepted[id] = true
+		}
+		checkTable(t, kb, accepted)
+	}
+}
+
+// 任意插入顺序下，分裂都不能丢失已接受的节点
+func TestSplitKeepsAcceptedNodes(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		self := randomID(r)
+		kb := NewKBucket(self, 1+r.Intn(8))
+		ids := AdversarialIDs(seed, self, 200)
+		r.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+		insertAll(t, kb, ids, make(map[[IdSize]byte]bool))
+	}
+}
+
+// 靠近自身的节点总能加入路由表：自身所在的 bucket 满了就分裂
+func TestSplitAcceptsNeighbors(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	self := randomID(r)
+	kb := NewKBucket(self, BucketSize)
+	accepted := make(map[[IdSize]byte]bool)
+	insertAll(t, kb, SharedPrefixIDs(r, self, 0, 100), accepted) // 先填满远处的 bucket
+	// neighbors[i] 与自身的距离为 i+1
+	neighbors := NeighborIDs(self, 64)
+	insertAll(t, kb, neighbors, accepted)
+	for _, id := range neighbors[:BucketSize] {
+		if !accepted[id] {
+			t.Fatalf("nearest neighbor %x was rejected", id)
+		}
+	}
+}
+
+// 插入与删除交替进行时不变量仍然成立
+func TestSplitWithRemovals(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		self := randomID(r)
+		kb := NewKBucket(self, BucketSize)
+		accepted := make(map[[IdSize]byte]bool)
+		ids := AdversarialIDs(seed, self, 200)
+		for i, id := range ids {
+			if id == self {
+				continue
+			}
+			if kb.InsertNode(Node{ID: id}) {
+				accepted[id] = true
+			}
+			if i%3 == 0 {
+				victim := ids[r.Intn(i+1)]
+				if kb.RemoveNode(victim) {
+					delete(accepted, victim)
+				}
+			}
+			checkTable(t, kb, accepted)
+		}
+	}
+}
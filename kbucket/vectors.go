@@ -0,0 +1,115 @@
+package kbucket
+
+import (
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// 参考向量保存在 vectors/vectors_<ID 比特数>.json 中，其他语言的实现可以直接读取：
+//
+//	{"id_bits": 160,
+//	 "index":   [{"self", "target", "distance", "common_prefix_len", "bucket"}, ...],
+//	 "closest": [{"self", "target", "k", "ids", "closest"}, ...]}
+//
+// ID 与距离均为大端序的十六进制字符串
+//
+//go:embed vectors/*.json
+var vectorFiles embed.FS
+
+// 一条 bucket 索引向量。Bucket 是完全分裂的路由表中 Target 所在的 bucket，
+// 即 XOR 距离最高位 1 的位置（从最低位 0 数起）
+type IndexVector struct {
+	Self            [IdSize]byte
+	Target          [IdSize]byte
+	Distance        [IdSize]byte
+	CommonPrefixLen int
+	Bucket          int
+}
+
+// 一条最近节点向量：从 IDs 中选出距离 Target 最近的 K 个，Closest 按距离从近到远排列
+type ClosestVector struct {
+	Self    [IdSize]byte
+	Target  [IdSize]byte
+	K       int
+	IDs     [][IdSize]byte
+	Closest [][IdSize]byte
+}
+
+type vectorFile struct {
+	IDBits int `json:"id_bits"`
+	Index  []struct {
+		Self            string `json:"self"`
+		Target          string `json:"target"`
+		Distance        string `json:"distance"`
+		CommonPrefixLen int    `json:"common_prefix_len"`
+		Bucket          int    `json:"bucket"`
+	} `json:"index"`
+	Closest []struct {
+		Self    string   `json:"self"`
+		Target  string   `json:"target"`
+		K       int      `json:"k"`
+		IDs     []string `json:"ids"`
+		Closest []string `json:"closest"`
+	} `json:"closest"`
+}
+
+// 当前 IdSize 下的 bucket 索引参考向量，由独立的实现生成，
+// 用于检查重构或其他实现与本包的 keyspace 计算逐位一致
+func IndexVectors() []IndexVector {
+	f := loadVectors()
+	vs := make([]IndexVector, len(f.Index))
+	for i, v := range f.Index {
+		vs[i] = IndexVector{
+			Self:            mustVectorID(v.Self),
+			Target:          mustVectorID(v.Target),
+			Distance:        mustVectorID(v.Distance),
+			CommonPrefixLen: v.CommonPrefixLen,
+			Bucket:          v.Bucket,
+		}
+	}
+	return vs
+}
+
+// 当前 IdSize 下的最近节点参考向量
+func ClosestVectors() []ClosestVector {
+	f := loadVectors()
+	vs := make([]ClosestVector, len(f.Closest))
+	for i, v := range f.Closest {
+		vs[i] = ClosestVector{Self: mustVectorID(v.Self), Target: mustVectorID(v.Target), K: v.K}
+		for _, id := range v.IDs {
+			vs[i].IDs = append(vs[i].IDs, mustVectorID(id))
+		}
+		for _, id := range v.Closest {
+			vs[i].Closest = append(vs[i].Closest, mustVectorID(id))
+		}
+	}
+	return vs
+}
+
+// 向量文件随包一起编译，格式错误只能是打包时的错误，因此直接 panic
+func loadVectors() vectorFile {
+	data, err := vectorFiles.ReadFile(fmt.Sprintf("vectors/vectors_%d.json", IdSize*8))
+	if err != nil {
+		panic("kbucket: " + err.Error())
+	}
+	var f vectorFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		panic("kbucket: malformed vectors: " + err.Error())
+	}
+	if f.IDBits != IdSize*8 {
+		panic(fmt.Sprintf("kbucket: vectors are for %d-bit IDs, want %d", f.IDBits, IdSize*8))
+	}
+	return f
+}
+
+func mustVectorID(s string) [IdSize]byte {
+	var id [IdSize]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != IdSize {
+		panic(fmt.Sprintf("kbucket: malformed vector ID %q", s))
+	}
+	copy(id[:], b)
+	return id
+}
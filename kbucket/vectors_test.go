@@ -0,0 +1,58 @@
+package kbucket
+
+import "testing"
+
+// 本包的 keyspace 计算与参考向量逐位一致
+func TestIndexVectors(t *testing.T) {
+	vs := IndexVectors()
+	if len(vs) == 0 {
+		t.Fatal("no index vectors")
+	}
+	for _, v := range vs {
+		if d := Distance(v.Self, v.Target); d != v.Distance {
+			t.Fatalf("Distance(%x, %x) = %x, want %x", v.Self, v.Target, d, v.Distance)
+		}
+		if cpl := CommonPrefixLen(v.Self, v.Target); cpl != v.CommonPrefixLen {
+			t.Fatalf("CommonPrefixLen(%x, %x) = %d, want %d", v.Self, v.Target, cpl, v.CommonPrefixLen)
+		}
+		if pos := IdSize*8 - 1 - v.CommonPrefixLen; pos != v.Bucket {
+			t.Fatalf("bucket of %x relative to %x = %d, want %d", v.Target, v.Self, pos, v.Bucket)
+		}
+		// 尚未分裂的近距离区域归入 home bucket
+		kb := NewKBucket(v.Self, BucketSize)
+		want := v.Bucket
+		if home := kb.HomeBucket(); want < home {
+			want = home
+		}
+		if pos := kb.BucketIndex(v.Target); pos != want {
+			t.Fatalf("BucketIndex(%x) with self %x = %d, want %d", v.Target, v.Self, pos, want)
+		}
+	}
+}
+
+func TestClosestVectors(t *testing.T) {
+	vs := ClosestVectors()
+	if len(vs) == 0 {
+		t.Fatal("no closest vectors")
+	}
+	for _, v := range vs {
+		kb := NewKBucket(v.Self, len(v.IDs)) // 容量足够，所有候选都能加入
+		for _, id := range v.IDs {
+			if !kb.InsertNode(Node{ID: id}) {
+				t.Fatalf("node %x rejected", id)
+			}
+		}
+		got := kb.FindClosestNodes(v.Target, v.K)
+		if len(got) != len(v.Closest) {
+			t.Fatalf("FindClosestNodes(%x, %d) returned %d nodes, want %d", v.Target, v.K, len(got), len(v.Closest))
+		}
+		for i, n := range got {
+			if n.ID != v.Closest[i] {
+				t.Fatalf("FindClosestNodes(%x, %d)[%d] = %x, want %x", v.Target, v.K, i, n.ID, v.Closest[i])
+			}
+			if i > 0 && !Closer(got[i-1].ID, n.ID, v.Target) {
+				t.Fatalf("Closer(%x, %x, %x) = false for consecutive results", got[i-1].ID, n.ID, v.Target)
+			}
+		}
+	}
+}
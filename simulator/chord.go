@@ -0,0 +1,325 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 仿真使用的路由策略
+type Strategy string
+
+const (
+	StrategyKademlia Strategy = "kademlia" // dht 包的 Kademlia 节点，默认的策略
+	StrategyChord    Strategy = "chord"    // 带后继列表的 Chord，作为比较的基线
+)
+
+// 按名字选择路由策略：kademlia 或 chord
+func ParseStrategy(name string) (Strategy, error) {
+	switch Strategy(name) {
+	case "", StrategyKademlia:
+		return StrategyKademlia, nil
+	case StrategyChord:
+		return StrategyChord, nil
+	}
+	return "", fmt.Errorf("simulator: unknown strategy %q", name)
+}
+
+const chordBits = kbucket.IdSize * 8
+
+// 一个 Chord 节点。指针可能指向已经离开的节点，联系时跳过，相当于超时
+type chordNode struct {
+	id      [kbucket.IdSize]byte
+	alive   bool
+	pred    *chordNode
+	succ    []*chordNode          // 后继列表，长度至多为复制数
+	fingers [chordBits]*chordNode // fingers[i] 为 id+2^i 的后继
+	next    int                   // 下一次修正的 finger
+	store   map[[kbucket.IdSize]byte][]byte
+}
+
+// Chord 基线的仿真状态。与 Kademlia 的仿真使用相同的参数、读写顺序与统计口径：
+// 每个 key 写入后继列表中的前 K 个节点，跳数为一次读取联系的节点数
+type chordSim struct {
+	cfg     Config
+	r       *rand.Rand
+	k       int
+	ring    []*chordNode // 在线节点，按 ID 排序，只用于新节点定位自己的后继
+	holders map[[kbucket.IdSize]byte]map[*chordNode]bool
+	report  Report
+}
+
+func runChord(cfg Config) (Report, error) {
+	s := &chordSim{
+		cfg:     cfg,
+		r:       rand.New(rand.NewSource(cfg.Seed)),
+		k:       cfg.DHT.K,
+		holders: make(map[[kbucket.IdSize]byte]map[*chordNode]bool),
+		report:  Report{Seed: cfg.Seed, Strategy: StrategyChord},
+	}
+	if s.k == 0 {
+		s.k = kbucket.BucketSize
+	}
+	for i := 0; i < cfg.Peers; i++ {
+		s.join()
+	}
+	s.settle()
+	keys := make([][kbucket.IdSize]byte, cfg.Keys)
+	values := make(map[[kbucket.IdSize]byte][]byte, cfg.Keys)
+	for i := range keys {
+		value := []byte(s.randomString())
+		keys[i] = dht.KeyFromBytes(value)
+		values[keys[i]] = value
+		s.put(s.randomNode(), keys[i], value)
+		s.tick()
+	}
+	hops := 0
+	for i := 0; i < cfg.Gets; i++ {
+		key := keys[s.r.Intn(len(keys))]
+		value, n := s.get(s.randomNode(), key)
+		if bytes.Equal(value, values[key]) {
+			s.report.Found++
+		}
+		hops += n
+		s.tick()
+	}
+	s.report.Peers = len(s.ring)
+	s.report.Gets = cfg.Gets
+	if cfg.Gets > 0 {
+		s.report.SuccessRate = float64(s.report.Found) / float64(cfg.Gets)
+		s.report.AvgHops = float64(hops) / float64(cfg.Gets)
+	}
+	total := 0
+	for key := range values {
+		n := len(s.holders[key])
+		for len(s.report.Replicas) <= n {
+			s.report.Replicas = append(s.report.Replicas, 0)
+		}
+		s.report.Replicas[n]++
+		total += n
+	}
+	s.report.MeanReplicas = float64(total) / float64(len(values))
+	return s.report, nil
+}
+
+// 新节点通过环定位后继，复制后继的后继列表，再通知后继；fingers 在之后的 tick 中逐个修正
+func (s *chordSim) join() {
+	n := &chordNode{alive: true, store: make(map[[kbucket.IdSize]byte][]byte)}
+	s.r.Read(n.id[:])
+	i := sort.Search(len(s.ring), func(i int) bool { return bytes.Compare(s.ring[i].id[:], n.id[:]) > 0 })
+	s.ring = append(s.ring, nil)
+	copy(s.ring[i+1:], s.ring[i:])
+	s.ring[i] = n
+	if len(s.ring) == 1 {
+		n.succ = []*chordNode{n}
+		return
+	}
+	succ := s.ring[(i+1)%len(s.ring)]
+	n.succ = s.successorList(succ)
+	for j := range n.fingers {
+		n.fingers[j] = succ
+	}
+	s.notify(succ, n)
+}
+
+// 初始节点加入之后让环稳定下来，相当于 Kademlia 的节点加入时查找自身填充路由表：
+// 反复 stabilize 直到后继列表不再变化，再修正所有 fingers
+func (s *chordSim) settle() {
+	for changed := true; changed; {
+		changed = false
+		for _, n := range s.ring {
+			before := n.succ
+			s.stabilize(n)
+			changed = changed || !slices.Equal(before, n.succ)
+		}
+	}
+	for _, n := range s.ring {
+		for i := range n.fingers {
+			n.fingers[i], _ = s.findSuccessor(n, addPow2(n.id, i))
+		}
+	}
+}
+
+// 以 first 开头、后接 first 的后继列表，跳过离开的节点与重复的节点，至多 k 个
+func (s *chordSim) successorList(first *chordNode) []*chordNode {
+	list := []*chordNode{first}
+	for _, x := range first.succ {
+		if len(list) == s.k {
+			break
+		}
+		if x.alive && !slices.Contains(list, x) {
+			list = append(list, x)
+		}
+	}
+	return list
+}
+
+// n 认为自己可能是 x 的前驱
+func (s *chordSim) notify(x, n *chordNode) {
+	if x.pred == nil || !x.pred.alive || between(n.id, x.pred.id, x.id) {
+		x.pred = n
+	}
+}
+
+// 每次读写之后：每个节点执行一次 stabilize 并修正一个 finger，再按 ChurnRate 更替节点
+func (s *chordSim) tick() {
+	for _, n := range s.ring {
+		s.stabilize(n)
+	}
+	for _, n := range s.ring {
+		target := addPow2(n.id, n.next)
+		n.fingers[n.next], _ = s.findSuccessor(n, target)
+		n.next = (n.next + 1) % chordBits
+	}
+	s.churn()
+}
+
+func (s *chordSim) stabilize(n *chordNode) {
+	succ := firstAlive(n.succ)
+	if succ == nil {
+		succ = n
+	}
+	if p := succ.pred; p != nil && p.alive && between(p.id, n.id, succ.id) && p != n {
+		succ = p
+	}
+	if succ == n {
+		n.succ = []*chordNode{n}
+		return
+	}
+	n.succ = s.successorList(succ)
+	s.notify(succ, n)
+}
+
+func (s *chordSim) churn() {
+	if s.cfg.ChurnRate == 0 || s.r.Float64() >= s.cfg.ChurnRate || len(s.ring) < 2 {
+		return
+	}
+	i := s.r.Intn(len(s.ring))
+	leaver := s.ring[i]
+	leaver.alive = false
+	s.ring = append(s.ring[:i], s.ring[i+1:]...)
+	for _, h := range s.holders { // 离开的节点带走了它保存的副本
+		delete(h, leaver)
+	}
+	s.report.Left++
+	s.report.Joined++
+	s.join()
+}
+
+// 从 from 开始迭代地查找 target 的后继，返回后继以及联系的节点数
+func (s *chordSim) findSuccessor(from *chordNode, target [kbucket.IdSize]byte) (*chordNode, int) {
+	n, hops := from, 0
+	for step := 0; step < 2*chordBits; step++ {
+		succ := firstAlive(n.succ)
+		if succ == nil || succ == n || between(target, n.id, succ.id) {
+			if succ == nil {
+				succ = n
+			}
+			return succ, hops
+		}
+		next := closestPreceding(n, target)
+		if next == nil {
+			return succ, hops
+		}
+		n = next
+		hops++
+	}
+	return firstAlive(n.succ), hops
+}
+
+// n 的 fingers 与后继列表中在 (n, target) 之间、离 target 最近的在线节点
+func closestPreceding(n *chordNode, target [kbucket.IdSize]byte) *chordNode {
+	var best *chordNode
+	consider := func(x *chordNode) {
+		if x == nil || !x.alive || x == n || !between(x.id, n.id, target) || x.id == target {
+			return
+		}
+		if best == nil || between(x.id, best.id, target) {
+			best = x
+		}
+	}
+	for i := chordBits - 1; i >= 0; i-- {
+		consider(n.fingers[i])
+	}
+	for _, x := range n.succ {
+		consider(x)
+	}
+	return best
+}
+
+func (s *chordSim) put(from *chordNode, key [kbucket.IdSize]byte, value []byte) {
+	succ, _ := s.findSuccessor(from, key)
+	if succ == nil {
+		return
+	}
+	for _, n := range s.successorList(succ) {
+		n.store[key] = value
+		if s.holders[key] == nil {
+			s.holders[key] = make(map[*chordNode]bool)
+		}
+		s.holders[key][n] = true
+	}
+}
+
+// 查找 key 的后继，再依次询问它的后继列表直到找到值，返回值与联系的节点数
+func (s *chordSim) get(from *chordNode, key [kbucket.IdSize]byte) ([]byte, int) {
+	succ, hops := s.findSuccessor(from, key)
+	if succ == nil {
+		return nil, hops
+	}
+	for _, n := range s.successorList(succ) {
+		hops++
+		if v, ok := n.store[key]; ok {
+			return v, hops
+		}
+	}
+	return nil, hops
+}
+
+func (s *chordSim) randomNode() *chordNode {
+	return s.ring[s.r.Intn(len(s.ring))]
+}
+
+func (s *chordSim) randomString() string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, s.r.Intn(30)+1)
+	for i := range b {
+		b[i] = charset[s.r.Intn(len(charset))]
+	}
+	return string(b)
+}
+
+func firstAlive(nodes []*chordNode) *chordNode {
+	for _, n := range nodes {
+		if n.alive {
+			return n
+		}
+	}
+	return nil
+}
+
+// x 是否在环上的区间 (a, b] 之内；a 等于 b 时区间为整个环
+func between(x, a, b [kbucket.IdSize]byte) bool {
+	ab, ax, xb := bytes.Compare(a[:], b[:]), bytes.Compare(a[:], x[:]), bytes.Compare(x[:], b[:])
+	if ab < 0 {
+		return ax < 0 && xb <= 0
+	}
+	return ax < 0 || xb <= 0
+}
+
+// id + 2^i mod 2^n
+func addPow2(id [kbucket.IdSize]byte, i int) [kbucket.IdSize]byte {
+	pos := kbucket.IdSize - 1 - i/8
+	carry := 1 << (i % 8)
+	for ; pos >= 0 && carry > 0; pos-- {
+		v := int(id[pos]) + carry
+		id[pos] = byte(v)
+		carry = v >> 8
+	}
+	return id
+}
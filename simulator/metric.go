@@ -0,0 +1,60 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 顺时针的环形距离（Chord）：ID 视为大端无符号整数，a 到 target 的距离为 (a - target) mod 2^n
+type RingMetric struct{}
+
+func (RingMetric) Closer(a, b, target [kbucket.IdSize]byte) bool {
+	da, db := sub(a, target), sub(b, target)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// 数轴上的距离：ID 视为大端无符号整数，距离为 |a - target|
+type LinearMetric struct{}
+
+func (LinearMetric) Closer(a, b, target [kbucket.IdSize]byte) bool {
+	da, db := absSub(a, target), absSub(b, target)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// (a - b) mod 2^n
+func sub(a, b [kbucket.IdSize]byte) [kbucket.IdSize]byte {
+	var d [kbucket.IdSize]byte
+	borrow := 0
+	for i := kbucket.IdSize - 1; i >= 0; i-- {
+		v := int(a[i]) - int(b[i]) - borrow
+		borrow = 0
+		if v < 0 {
+			v += 256
+			borrow = 1
+		}
+		d[i] = byte(v)
+	}
+	return d
+}
+
+func absSub(a, b [kbucket.IdSize]byte) [kbucket.IdSize]byte {
+	if bytes.Compare(a[:], b[:]) < 0 {
+		return sub(b, a)
+	}
+	return sub(a, b)
+}
+
+// 按名字选择距离度量：xor、ring 或 linear
+func ParseMetric(name string) (kbucket.Metric, error) {
+	switch name {
+	case "", "xor":
+		return kbucket.XORMetric{}, nil
+	case "ring":
+		return RingMetric{}, nil
+	case "linear":
+		return LinearMetric{}, nil
+	}
+	return nil, fmt.Errorf("simulator: unknown metric %q", name)
+}
@@ -0,0 +1,105 @@
+package simulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 节点在线时平均连续经历的读写次数，见 Profile.Session
+const DefaultSession = 20
+
+// 一类节点的能力。仿真按 Weight 随机为每个节点（包括运行中加入的节点）选择一类
+type Profile struct {
+	Name       string
+	Weight     float64 // 这类节点所占的相对比例
+	Bandwidth  int     // 每次读写中最多应答的请求数，超出后对其余请求表现为超时，0 表示不限制
+	Storage    int     // 最多保存的记录数，存满后拒绝 STORE，0 表示不限制
+	Uptime     float64 // 在线时间所占的比例，0 表示始终在线
+	Session    int     // 平均连续在线的读写次数，Uptime 小于 1 时使用，0 表示 DefaultSession
+	ClientOnly bool    // 只发起读写，不进入其他节点的路由表，见 dht.Config.ClientOnly
+}
+
+func (p Profile) withDefaults() Profile {
+	if p.Uptime == 0 {
+		p.Uptime = 1
+	}
+	if p.Session == 0 {
+		p.Session = DefaultSession
+	}
+	return p
+}
+
+func (p Profile) validate() error {
+	switch {
+	case p.Weight <= 0:
+		return fmt.Errorf("simulator: profile %q: invalid Weight %v", p.Name, p.Weight)
+	case p.Bandwidth < 0:
+		return fmt.Errorf("simulator: profile %q: invalid Bandwidth %d", p.Name, p.Bandwidth)
+	case p.Storage < 0:
+		return fmt.Errorf("simulator: profile %q: invalid Storage %d", p.Name, p.Storage)
+	case p.Uptime <= 0 || p.Uptime > 1:
+		return fmt.Errorf("simulator: profile %q: Uptime %v out of range (0, 1]", p.Name, p.Uptime)
+	case p.Session < 1:
+		return fmt.Errorf("simulator: profile %q: invalid Session %d", p.Name, p.Session)
+	}
+	return nil
+}
+
+// 解析命令行形式的节点类别，类别之间以逗号分隔，每类为
+//
+//	name:weight[:bw=N][:store=N][:uptime=F][:session=N][:client]
+//
+// 例如 "server:3,home:5:uptime=0.6:bw=20,mobile:2:client"
+func ParseProfiles(s string) ([]Profile, error) {
+	var profiles []Profile
+	for _, class := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(class), ":")
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("simulator: malformed profile %q", class)
+		}
+		p := Profile{Name: fields[0]}
+		var err error
+		if p.Weight, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("simulator: profile %q: invalid weight %q", p.Name, fields[1])
+		}
+		for _, opt := range fields[2:] {
+			if opt == "client" {
+				p.ClientOnly = true
+				continue
+			}
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "bw":
+				p.Bandwidth, err = strconv.Atoi(value)
+			case "store":
+				p.Storage, err = strconv.Atoi(value)
+			case "uptime":
+				p.Uptime, err = strconv.ParseFloat(value, 64)
+			case "session":
+				p.Session, err = strconv.Atoi(value)
+			default:
+				return nil, fmt.Errorf("simulator: profile %q: unknown option %q", p.Name, opt)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("simulator: profile %q: invalid option %q", p.Name, opt)
+			}
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// 一类节点在仿真中的表现
+type ClassReport struct {
+	Name        string
+	Peers       int // 结束时属于这一类的节点数（包括暂时离线的节点）
+	Gets        int // 这类节点发起的读取次数
+	Found       int
+	SuccessRate float64
+	Served      int     // 这类节点应答的请求数
+	Saturated   int     // 因带宽耗尽而停止应答的次数
+	Records     int     // 结束时这类节点保存的副本数
+	PerPeer     float64 // 平均每个节点保存的副本数
+	Online      float64 // 读写时在线的节点所占的平均比例
+}
@@ -0,0 +1,654 @@
+// Package simulator 在进程内运行可复现的 DHT 仿真：按固定种子创建节点、写入并读取
+// 键值对，运行中按比例模拟节点的离开与加入，最后汇总查找成功率、跳数与副本分布。
+// 节点可以分为带宽、存储配额、在线规律各不相同的类别，报告按类别分别统计。
+// 还可以加入回复伪造联系人的恶意节点，比较不同 dht.ContactAcceptance 下路由表受到的污染，
+// 以及让初始节点随机错开启动、限速加入，观察种子节点承受的负载。
+// 设置 Strategy 为 StrategyChord 时改为运行带后继列表的 Chord 基线，在相同的场景与指标下比较
+package simulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WuQingyang2/K_Bucket/dht"
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 默认参数
+const (
+	DefaultPeers = 100
+	DefaultKeys  = 200
+	DefaultGets  = 100
+)
+
+// 仿真参数。零值字段使用默认值
+type Config struct {
+	Peers      int        // 初始节点数
+	Keys       int        // 写入的键值对数量
+	Gets       int        // 读取次数
+	BucketSize int        // 每个 bucket 的容量，0 表示使用 DHT.K
+	ChurnRate  float64    // 每次读写之后发生一次节点更替（一个节点离开、一个新节点加入）的概率
+	Seed       int64      // 随机数种子，相同的参数与种子得到相同的报告
+	DHT        dht.Config // 节点参数
+	Profiles   []Profile  // 节点类别，为空时所有节点能力相同
+	Strategy   Strategy   // 路由策略，空值表示 StrategyKademlia
+
+	// 初始节点中恶意节点的数量，它们对 FIND_NODE 回复伪造的联系人。DHT.ContactAcceptance 为
+	// dht.AcceptAll 或 dht.AcceptVerified 时联系人在后台加入路由表，相同的参数与种子得到的报告可能略有差别
+	Attackers int
+
+	// 初始节点如何加入网络：前 Seeds 个节点先加入，其余节点轮流以它们为种子，0 表示每个节点
+	// 随机选择一个在线节点。其余节点在 [0, StartJitter) 内随机的仿真时刻启动，再经过共用的
+	// dht.BootstrapLimiter 开始加入，速率为每秒 JoinRate 个、突发 JoinBurst 个，0 表示不限制
+	Seeds       int
+	StartJitter time.Duration
+	JoinRate    float64
+	JoinBurst   int
+}
+
+// 一次仿真的结果
+type Report struct {
+	Seed         int64
+	Strategy     Strategy
+	Peers        int // 结束时在线的节点数
+	Joined       int // 运行中加入的节点数
+	Left         int // 运行中离开的节点数
+	Gets         int
+	Found        int
+	SuccessRate  float64
+	AvgHops      float64 // 每次读取平均联系的节点数
+	Replicas     []int   // Replicas[i] 为结束时恰好有 i 个在线副本的 key 数
+	MeanReplicas float64
+	Classes      []ClassReport // 按 Config.Profiles 的顺序，没有设置类别时为空
+
+	Attackers   int     // 恶意节点数
+	Forged      int     // 结束时其余节点路由表中伪造联系人的总数
+	ForgedShare float64 // 伪造联系人占其余节点路由表条目的比例
+
+	PeakLoad int           // 初始节点加入期间，单个节点在一秒仿真时间内应答的最多请求数
+	JoinSpan time.Duration // 第一个与最后一个初始节点开始加入相隔的仿真时间
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	if r.Strategy == StrategyChord {
+		fmt.Fprintf(&b, "strategy: %s\n", r.Strategy)
+	}
+	fmt.Fprintf(&b, "seed %d: %d peers online (%d joined, %d left)\n", r.Seed, r.Peers, r.Joined, r.Left)
+	fmt.Fprintf(&b, "lookups: %d/%d found (%.1f%%), %.2f hops on average\n", r.Found, r.Gets, 100*r.SuccessRate, r.AvgHops)
+	fmt.Fprintf(&b, "replicas: %.2f per key on average\n", r.MeanReplicas)
+	for n, keys := range r.Replicas {
+		if keys > 0 {
+			fmt.Fprintf(&b, "  %2d replicas: %d keys\n", n, keys)
+		}
+	}
+	if r.Attackers > 0 {
+		fmt.Fprintf(&b, "attack: %d attackers, %d forged contacts in routing tables (%.1f%% of entries)\n",
+			r.Attackers, r.Forged, 100*r.ForgedShare)
+	}
+	if r.PeakLoad > 0 {
+		fmt.Fprintf(&b, "bootstrap: busiest peer served %d requests/s, joins spread over %v\n", r.PeakLoad, r.JoinSpan)
+	}
+	if len(r.Classes) > 0 {
+		fmt.Fprintf(&b, "%-10s %6s %7s %9s %8s %10s %8s %9s\n", "class", "peers", "online", "lookups", "success", "served", "records", "per-peer")
+		for _, c := range r.Classes {
+			fmt.Fprintf(&b, "%-10s %6d %6.1f%% %4d/%-4d %7.1f%% %10d %8d %9.2f\n",
+				c.Name, c.Peers, 100*c.Online, c.Found, c.Gets, 100*c.SuccessRate, c.Served, c.Records, c.PerPeer)
+			if c.Saturated > 0 {
+				fmt.Fprintf(&b, "  %s: bandwidth exhausted %d times\n", c.Name, c.Saturated)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (c Config) withDefaults() Config {
+	if c.Peers == 0 {
+		c.Peers = DefaultPeers
+	}
+	if c.Keys == 0 {
+		c.Keys = DefaultKeys
+	}
+	if c.Gets == 0 {
+		c.Gets = DefaultGets
+	}
+	if c.BucketSize > 0 {
+		c.DHT.K = c.BucketSize
+	}
+	if c.Strategy == "" {
+		c.Strategy = StrategyKademlia
+	}
+	if len(c.Profiles) > 0 {
+		profiles := make([]Profile, len(c.Profiles))
+		for i, p := range c.Profiles {
+			profiles[i] = p.withDefaults()
+		}
+		c.Profiles = profiles
+	}
+	return c
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.Peers < 1:
+		return fmt.Errorf("simulator: invalid Peers %d", c.Peers)
+	case c.Keys < 1:
+		return fmt.Errorf("simulator: invalid Keys %d", c.Keys)
+	case c.Gets < 0:
+		return fmt.Errorf("simulator: invalid Gets %d", c.Gets)
+	case c.BucketSize < 0:
+		return fmt.Errorf("simulator: invalid BucketSize %d", c.BucketSize)
+	case c.ChurnRate < 0 || c.ChurnRate > 1:
+		return fmt.Errorf("simulator: ChurnRate %v out of range [0, 1]", c.ChurnRate)
+	case c.Attackers < 0 || c.Attackers >= c.Peers:
+		return fmt.Errorf("simulator: invalid Attackers %d for %d peers", c.Attackers, c.Peers)
+	case c.Seeds < 0 || c.Seeds >= c.Peers:
+		return fmt.Errorf("simulator: invalid Seeds %d for %d peers", c.Seeds, c.Peers)
+	case c.StartJitter < 0:
+		return fmt.Errorf("simulator: invalid StartJitter %v", c.StartJitter)
+	case c.JoinRate < 0 || c.JoinBurst < 0:
+		return fmt.Errorf("simulator: invalid JoinRate %v or JoinBurst %d", c.JoinRate, c.JoinBurst)
+	}
+	if c.Strategy == StrategyChord {
+		if len(c.Profiles) > 0 || c.Attackers > 0 || c.Seeds > 0 || c.StartJitter > 0 || c.JoinRate > 0 {
+			return errors.New("simulator: the chord strategy supports only Peers, Keys, Gets, BucketSize, ChurnRate and DHT.K")
+		}
+		return nil
+	} else if c.Strategy != StrategyKademlia {
+		return fmt.Errorf("simulator: unknown strategy %q", c.Strategy)
+	}
+	servers := len(c.Profiles) == 0
+	for _, p := range c.Profiles {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		servers = servers || !p.ClientOnly
+	}
+	if !servers {
+		return errors.New("simulator: every profile is client-only")
+	}
+	return c.DHT.Validate()
+}
+
+// 回调与驱动仿真的 goroutine 共享的状态由 mu 保护：同一轮查找的各跳并发进行，
+// 回调可能同时被多个 goroutine 调用。live 与 class 只由驱动仿真的 goroutine
+// 修改，它读取时不需要加锁
+type sim struct {
+	cfg     Config
+	r       *rand.Rand // 只在驱动仿真的 goroutine 中使用
+	hooks   *dht.Hooks
+	mu      sync.Mutex
+	live    []*dht.Peer
+	gone    [][kbucket.IdSize]byte                      // 已经离开的节点
+	holders map[[kbucket.IdSize]byte]map[*dht.Peer]bool // 保存了每个 key 的节点
+	hops    int
+	report  Report
+
+	profiles  []Profile
+	class     map[*dht.Peer]int  // 节点所属的类别
+	down      map[*dht.Peer]bool // 暂时离线的节点
+	served    map[*dht.Peer]int  // 本次读写中节点应答的请求数
+	saturated []*dht.Peer        // 本次读写中带宽耗尽的节点
+	classes   []classStats
+
+	attackers map[*dht.Peer]bool
+	forged    map[[kbucket.IdSize]byte]bool // 恶意节点伪造的联系人
+
+	second int                // 初始节点加入期间的仿真时间，以秒计
+	load   map[loadSample]int // 初始节点加入期间每个节点每秒应答的请求数，加入结束后为 nil
+}
+
+type loadSample struct {
+	peer   *dht.Peer
+	second int
+}
+
+type classStats struct {
+	ClassReport
+	present int // 每次读写时属于这一类的节点数之和
+	online  int // 其中在线的节点数之和
+}
+
+// 按 cfg 运行一次仿真。仿真在调用方的 goroutine 中顺序执行，
+// 离开的节点通过故障注入对其余节点表现为超时
+func Run(cfg Config) (Report, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return Report{}, err
+	}
+	if cfg.Strategy == StrategyChord {
+		return runChord(cfg)
+	}
+	s := &sim{
+		cfg:     cfg,
+		r:       rand.New(rand.NewSource(cfg.Seed)),
+		holders: make(map[[kbucket.IdSize]byte]map[*dht.Peer]bool),
+		report:  Report{Seed: cfg.Seed, Strategy: StrategyKademlia},
+
+		profiles: cfg.Profiles,
+		class:    make(map[*dht.Peer]int),
+		down:     make(map[*dht.Peer]bool),
+		served:   make(map[*dht.Peer]int),
+	}
+	if len(s.profiles) == 0 {
+		s.profiles = []Profile{Profile{Name: "default", Weight: 1}.withDefaults()}
+	}
+	s.classes = make([]classStats, len(s.profiles))
+	s.hooks = &dht.Hooks{
+		OnLookupHop: func(*dht.Peer, [kbucket.IdSize]byte, *dht.Peer) {
+			s.mu.Lock()
+			s.hops++
+			s.mu.Unlock()
+		},
+		OnStore: func(p *dht.Peer, key [kbucket.IdSize]byte) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.holders[key] == nil {
+				s.holders[key] = make(map[*dht.Peer]bool)
+			}
+			s.holders[key][p] = true
+		},
+		OnRequest: func(p *dht.Peer, _ dht.TraceID, _ string, _, _ [kbucket.IdSize]byte) {
+			s.mu.Lock()
+			s.serve(p)
+			if s.load != nil {
+				s.load[loadSample{p, s.second}]++
+			}
+			s.mu.Unlock()
+		},
+	}
+	if err := s.joinAll(); err != nil {
+		return Report{}, err
+	}
+	s.attack()
+	ctx := context.Background()
+	keys := make([][kbucket.IdSize]byte, cfg.Keys)
+	values := make(map[[kbucket.IdSize]byte][]byte, cfg.Keys)
+	for i := range keys {
+		value := []byte(s.randomString())
+		keys[i] = dht.KeyFromBytes(value)
+		values[keys[i]] = value
+		s.randomPeer().SetValue(ctx, keys[i][:], value) // 发布失败体现在副本分布中
+		if err := s.tick(); err != nil {
+			return Report{}, err
+		}
+	}
+	hops := 0
+	for i := 0; i < cfg.Gets; i++ {
+		key := keys[s.r.Intn(len(keys))]
+		p := s.randomPeer()
+		s.mu.Lock()
+		start := s.hops
+		c := &s.classes[s.class[p]]
+		c.Gets++
+		s.mu.Unlock()
+		value, err := p.GetValue(ctx, key)
+		s.mu.Lock()
+		if err == nil && bytes.Equal(value, values[key]) {
+			s.report.Found++
+			c.Found++
+		}
+		hops += s.hops - start
+		s.mu.Unlock()
+		if err := s.tick(); err != nil {
+			return Report{}, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report.Peers = s.online()
+	s.report.Gets = cfg.Gets
+	if cfg.Gets > 0 {
+		s.report.SuccessRate = float64(s.report.Found) / float64(cfg.Gets)
+		s.report.AvgHops = float64(hops) / float64(cfg.Gets)
+	}
+	total := 0
+	for key := range values {
+		n := len(s.holders[key])
+		for len(s.report.Replicas) <= n {
+			s.report.Replicas = append(s.report.Replicas, 0)
+		}
+		s.report.Replicas[n]++
+		total += n
+	}
+	s.report.MeanReplicas = float64(total) / float64(len(values))
+	if len(cfg.Profiles) > 0 {
+		s.report.Classes = s.classReports()
+	}
+	s.countForged()
+	return s.report, nil
+}
+
+// 加入初始节点。没有设置 Seeds、StartJitter 与 JoinRate 时依次加入，
+// 不消耗额外的随机数，使其他仿真的结果保持不变
+func (s *sim) joinAll() error {
+	cfg := s.cfg
+	if cfg.Seeds == 0 && cfg.StartJitter == 0 && cfg.JoinRate == 0 {
+		for i := 0; i < cfg.Peers; i++ {
+			if err := s.join(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := 0; i < cfg.Seeds; i++ {
+		if err := s.join(); err != nil {
+			return err
+		}
+	}
+	var seeds []*dht.Peer
+	for _, p := range s.live {
+		if !s.profiles[s.class[p]].ClientOnly {
+			seeds = append(seeds, p)
+		}
+	}
+	starts := make([]time.Duration, cfg.Peers-cfg.Seeds)
+	if cfg.StartJitter > 0 {
+		for i := range starts {
+			starts[i] = time.Duration(s.r.Int63n(int64(cfg.StartJitter)))
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	}
+	limiter := dht.NewBootstrapLimiter(cfg.JoinRate, cfg.JoinBurst)
+	epoch := time.Unix(0, 0)
+	s.load = make(map[loadSample]int)
+	var first, last time.Duration
+	for i, d := range starts {
+		at := limiter.Reserve(epoch.Add(d)).Sub(epoch) // 限速器按仿真时间预约，不真正等待
+		if i == 0 {
+			first = at
+		}
+		last = at
+		s.mu.Lock()
+		s.second = int(at / time.Second)
+		s.mu.Unlock()
+		var seed *dht.Peer
+		if len(seeds) > 0 {
+			seed = seeds[i%len(seeds)]
+		}
+		if err := s.joinVia(seed); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	for _, n := range s.load {
+		s.report.PeakLoad = max(s.report.PeakLoad, n)
+	}
+	s.load = nil
+	s.mu.Unlock()
+	s.report.JoinSpan = last - first
+	return nil
+}
+
+// 创建一个新节点并通过一个在线节点加入网络
+func (s *sim) join() error {
+	return s.joinVia(nil)
+}
+
+// 创建一个新节点并以 seed 为种子加入网络，seed 为 nil 时随机选择一个在线的服务节点
+func (s *sim) joinVia(seed *dht.Peer) error {
+	var id [kbucket.IdSize]byte
+	s.r.Read(id[:])
+	class := s.pickClass()
+	profile := s.profiles[class]
+	cfg := s.cfg.DHT
+	cfg.ClientOnly = profile.ClientOnly
+	p, err := dht.NewPeerWithConfig(id, cfg)
+	if err != nil {
+		return err
+	}
+	p.KBucket().SetRand(s.r)
+	p.SetHooks(s.hooks)
+	p.SetStoreCapacity(profile.Storage)
+	for _, g := range s.gone { // 新节点同样无法联系已经离开的节点
+		p.Faults().Timeout(g)
+	}
+	for _, q := range s.live { // 以及暂时离线的节点
+		if s.down[q] {
+			p.Faults().Timeout(q.ID())
+		}
+	}
+	if seed == nil {
+		seed = s.randomServer()
+	}
+	if seed != nil {
+		if err := p.Bootstrap([]dht.Contact{{Peer: seed}}); err != nil && !errors.Is(err, dht.ErrNoSeeds) {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.live = append(s.live, p)
+	s.class[p] = class
+	s.mu.Unlock()
+	return nil
+}
+
+// 随机选出 Attackers 个节点，它们对 FIND_NODE 回复同一批伪造的联系人：ID 随机、
+// 地址不可达。没有恶意节点时不消耗随机数，使其他仿真的结果保持不变
+func (s *sim) attack() {
+	if s.cfg.Attackers == 0 {
+		return
+	}
+	s.attackers = make(map[*dht.Peer]bool)
+	s.forged = make(map[[kbucket.IdSize]byte]bool)
+	k := s.cfg.DHT.K
+	if k == 0 {
+		k = kbucket.BucketSize
+	}
+	forged := make([]dht.Contact, k)
+	for i := range forged {
+		s.r.Read(forged[i].ID[:])
+		forged[i].Addr = &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 4000} // 文档用的地址段，不会有节点应答
+		s.forged[forged[i].ID] = true
+	}
+	for _, i := range s.r.Perm(len(s.live))[:s.cfg.Attackers] {
+		s.attackers[s.live[i]] = true
+		s.live[i].Faults().ForgeContacts(forged)
+	}
+	s.report.Attackers = s.cfg.Attackers
+}
+
+// 统计仍在线的诚实节点路由表中的伪造联系人
+func (s *sim) countForged() {
+	if s.forged == nil {
+		return
+	}
+	entries := 0
+	for _, p := range s.live {
+		if s.attackers[p] {
+			continue
+		}
+		for _, n := range p.KBucket().AllNodes() {
+			entries++
+			if s.forged[n.ID] {
+				s.report.Forged++
+			}
+		}
+	}
+	if entries > 0 {
+		s.report.ForgedShare = float64(s.report.Forged) / float64(entries)
+	}
+}
+
+// 按 Weight 随机选择新节点的类别。只有一类时不消耗随机数，使不设置类别的仿真结果保持不变
+func (s *sim) pickClass() int {
+	if len(s.profiles) == 1 {
+		return 0
+	}
+	total := 0.0
+	for _, p := range s.profiles {
+		total += p.Weight
+	}
+	x := s.r.Float64() * total
+	for i, p := range s.profiles {
+		if x < p.Weight {
+			return i
+		}
+		x -= p.Weight
+	}
+	return len(s.profiles) - 1
+}
+
+// 每次读写之后：恢复带宽耗尽的节点，按在线规律切换节点的在线状态，再按 ChurnRate 更替节点
+func (s *sim) tick() error {
+	s.mu.Lock()
+	s.update()
+	s.mu.Unlock()
+	return s.churn()
+}
+
+func (s *sim) update() {
+	for _, p := range s.saturated {
+		s.setReachable(p, true)
+	}
+	s.saturated = s.saturated[:0]
+	clear(s.served)
+	for _, p := range s.live {
+		c := &s.classes[s.class[p]]
+		c.present++
+		if !s.down[p] {
+			c.online++
+		}
+		profile := s.profiles[s.class[p]]
+		if profile.Uptime >= 1 {
+			continue
+		}
+		// 两状态的马尔可夫链：平均在线 Session 次读写，长期在线比例为 Uptime
+		leave := 1 / float64(profile.Session)
+		if s.down[p] {
+			if s.r.Float64() < min(leave*profile.Uptime/(1-profile.Uptime), 1) {
+				delete(s.down, p)
+				s.setReachable(p, true)
+			}
+		} else if s.r.Float64() < leave && s.online() > 1 {
+			s.down[p] = true
+			s.setReachable(p, false)
+		}
+	}
+}
+
+// 其余节点能否联系 p
+func (s *sim) setReachable(p *dht.Peer, ok bool) {
+	for _, q := range s.live {
+		if q == p {
+			continue
+		}
+		if ok {
+			q.Faults().Heal(p.ID())
+		} else {
+			q.Faults().Timeout(p.ID())
+		}
+	}
+}
+
+// p 应答了一次请求，达到带宽上限后在本次读写的剩余时间里不再应答
+func (s *sim) serve(p *dht.Peer) {
+	c := &s.classes[s.class[p]]
+	c.Served++
+	s.served[p]++
+	if bw := s.profiles[s.class[p]].Bandwidth; bw > 0 && s.served[p] == bw {
+		c.Saturated++
+		s.saturated = append(s.saturated, p)
+		s.setReachable(p, false)
+	}
+}
+
+func (s *sim) online() int {
+	return len(s.live) - len(s.down)
+}
+
+func (s *sim) classReports() []ClassReport {
+	for _, p := range s.live {
+		s.classes[s.class[p]].Peers++
+	}
+	for _, h := range s.holders {
+		for p := range h {
+			s.classes[s.class[p]].Records++
+		}
+	}
+	reports := make([]ClassReport, len(s.classes))
+	for i, c := range s.classes {
+		c.Name = s.profiles[i].Name
+		if c.Gets > 0 {
+			c.SuccessRate = float64(c.Found) / float64(c.Gets)
+		}
+		if c.Peers > 0 {
+			c.PerPeer = float64(c.Records) / float64(c.Peers)
+		}
+		if c.present > 0 {
+			c.Online = float64(c.online) / float64(c.present)
+		}
+		reports[i] = c.ClassReport
+	}
+	return reports
+}
+
+// 以 ChurnRate 的概率让一个节点离开，再加入一个新节点
+func (s *sim) churn() error {
+	if s.cfg.ChurnRate == 0 || s.r.Float64() >= s.cfg.ChurnRate || len(s.live) < 2 {
+		return nil
+	}
+	i := s.r.Intn(len(s.live))
+	leaver := s.live[i]
+	s.mu.Lock()
+	s.live = append(s.live[:i], s.live[i+1:]...)
+	delete(s.class, leaver)
+	delete(s.down, leaver)
+	s.gone = append(s.gone, leaver.ID())
+	for _, p := range s.live {
+		p.Faults().Timeout(leaver.ID())
+	}
+	for _, h := range s.holders { // 离开的节点带走了它保存的副本
+		delete(h, leaver)
+	}
+	s.report.Left++
+	s.report.Joined++
+	s.mu.Unlock()
+	return s.join()
+}
+
+// 随机选择一个在线节点发起读写
+func (s *sim) randomPeer() *dht.Peer {
+	if len(s.down) == 0 {
+		return s.live[s.r.Intn(len(s.live))]
+	}
+	var online []*dht.Peer
+	for _, p := range s.live {
+		if !s.down[p] {
+			online = append(online, p)
+		}
+	}
+	return online[s.r.Intn(len(online))]
+}
+
+// 随机选择一个在线且为其他节点提供服务的节点作为新节点的种子，没有时返回 nil
+func (s *sim) randomServer() *dht.Peer {
+	var servers []*dht.Peer
+	for _, p := range s.live {
+		if !s.down[p] && !s.profiles[s.class[p]].ClientOnly {
+			servers = append(servers, p)
+		}
+	}
+	if len(servers) == 0 {
+		return nil
+	}
+	return servers[s.r.Intn(len(servers))]
+}
+
+// 用来创建随机字符串
+func (s *sim) randomString() string {
+	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, s.r.Intn(30)+1)
+	for i := range b {
+		b[i] = charset[s.r.Intn(len(charset))]
+	}
+	return string(b)
+}
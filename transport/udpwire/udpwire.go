@@ -0,0 +1,622 @@
+// Package udpwire 定义 dht.UDPTransport 使用的数据包格式，供其他实现与之互通。
+// 只负责编解码，不包含重传、签名验证与请求处理
+package udpwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+// 消息类型。响应的类型等于请求的类型加一
+const (
+	Ping byte = iota + 1
+	Pong
+	Store
+	StoreResp
+	FindNode
+	FindNodeResp
+	FindValue
+	FindValueResp
+	Leave // 请求方即将离开网络
+	LeaveResp
+	AddProvider // 请求方声明自己持有 key 对应的数据
+	AddProviderResp
+	GetProviders
+	GetProvidersResp
+	RangeSync // 请求落在一段 keyspace 中的记录，用于新副本的初始同步
+	RangeSyncResp
+	DigestSync // 携带请求方一段 keyspace 中 key 的摘要，请求摘要中没有的 key，用于反熵
+	DigestSyncResp
+	MerkleSync // 请求一段 keyspace 中某个 Merkle 子树的摘要，用于反熵
+	MerkleSyncResp
+	Signal // 请求方经由接收方转交给另一个节点的信令，用于浏览器节点之间建立 WebRTC 连接
+	SignalResp
+)
+
+// 类型字节的最高位表示消息带有签名：消息末尾附加 公钥(32) | 签名(64)，
+// 签名覆盖签名之前的全部内容
+const Signed byte = 0x80
+
+// 类型字节的次高位表示低优先级的请求（刷新、重新发布等维护流量），接收方为它们保留较少的
+// 处理名额。只发给在握手中声明支持的节点，旧版本的节点不认识带这一位的类型
+const LowPriority byte = 0x40
+
+// FIND_VALUE 请求的标志位，附加在 key 之后；旧版本的请求没有这一字节，视为 0
+const (
+	FindValueWithNodes byte = 1 << iota // 命中时同时返回最近的节点
+	FindValueWithProof                  // 命中时同时返回响应方的负责证明
+)
+
+// FIND_NODE 请求的标志位，附加在 target 之后；旧版本的请求没有这一字节，视为 0
+const (
+	FindNodeWithHints byte = 1 << iota // 响应在联系人列表之后附带质量提示，见 AppendHints
+	FindNodeWithKeys                   // 响应在联系人列表与提示之后附带联系人的公钥，见 AppendKeys
+)
+
+const (
+	MaxPacketSize = 65507 // UDP 负载上限
+	HeaderSize    = 1 + 4 + 8 + 8 + kbucket.IdSize
+	MaxContacts   = 255 // 联系人、提示与 provider 列表的数量只占一个字节
+	HashSize      = 32  // Merkle 子树哈希（SHA-256）的字节数
+)
+
+var ErrBadPacket = errors.New("dht: malformed packet")
+
+// 消息头：类型(1) | 网络 ID(4) | RPC ID(8) | 追踪 ID(8) | 发送方 ID(IdSize)，之后是负载
+type Header struct {
+	Kind    byte
+	Network uint32
+	RPCID   uint64
+	Trace   [8]byte
+	Sender  [kbucket.IdSize]byte
+}
+
+// 把 h 编码后追加到 dst
+func AppendHeader(dst []byte, h Header) []byte {
+	var header [HeaderSize]byte
+	header[0] = h.Kind
+	binary.BigEndian.PutUint32(header[1:5], h.Network)
+	binary.BigEndian.PutUint64(header[5:13], h.RPCID)
+	copy(header[13:21], h.Trace[:])
+	copy(header[21:], h.Sender[:])
+	return append(dst, header[:]...)
+}
+
+// 解析消息头，返回的负载引用 packet，不复制数据
+func ParseHeader(packet []byte) (Header, []byte, error) {
+	if len(packet) < HeaderSize {
+		return Header{}, nil, ErrBadPacket
+	}
+	h := Header{
+		Kind:    packet[0],
+		Network: binary.BigEndian.Uint32(packet[1:5]),
+		RPCID:   binary.BigEndian.Uint64(packet[5:13]),
+	}
+	copy(h.Trace[:], packet[13:21])
+	copy(h.Sender[:], packet[21:HeaderSize])
+	return h, packet[HeaderSize:], nil
+}
+
+// 联系人列表：数量(1) | 每个联系人为 ID(IdSize) | 地址长度(1) | 地址。
+// 只编码 Data 为 *net.UDPAddr 的节点，最多编码前 MaxContacts 个
+func AppendContacts(buf *bytes.Buffer, nodes []kbucket.Node) {
+	var contacts []kbucket.Node
+	for _, n := range nodes {
+		if _, ok := n.Data.(*net.UDPAddr); ok && len(contacts) < MaxContacts {
+			contacts = append(contacts, n)
+		}
+	}
+	buf.WriteByte(byte(len(contacts)))
+	for _, n := range contacts {
+		addr := n.Data.(*net.UDPAddr).String()
+		buf.Write(n.ID[:])
+		buf.WriteByte(byte(len(addr)))
+		buf.WriteString(addr)
+	}
+}
+
+// 读取 AppendContacts 编码的联系人列表，节点的 Data 为 *net.UDPAddr
+func ReadContacts(r *bytes.Reader) ([]kbucket.Node, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrBadPacket
+	}
+	nodes := make([]kbucket.Node, 0, count)
+	for i := 0; i < int(count); i++ {
+		var n kbucket.Node
+		if _, err := io.ReadFull(r, n.ID[:]); err != nil {
+			return nil, ErrBadPacket
+		}
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrBadPacket
+		}
+		raw := make([]byte, size)
+		if _, err := io.ReadFull(r, raw); err != nil {
+			return nil, ErrBadPacket
+		}
+		addr, err := net.ResolveUDPAddr("udp", string(raw))
+		if err != nil {
+			return nil, ErrBadPacket
+		}
+		n.Data = addr
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// 响应方对一个联系人的观测
+type Hint struct {
+	RTT         uint32 // 平均 RTT，单位微秒，0 表示没有观测
+	Reliability uint8  // 成功联系的百分比
+}
+
+// 质量提示：数量(1) | 每个提示为 RTT(4) | 可靠性(1)，顺序与之前的联系人列表一致，
+// 与联系人一样最多编码前 MaxContacts 个
+func AppendHints(buf *bytes.Buffer, hints []Hint) {
+	if len(hints) > MaxContacts {
+		hints = hints[:MaxContacts]
+	}
+	buf.WriteByte(byte(len(hints)))
+	for _, h := range hints {
+		binary.Write(buf, binary.BigEndian, h.RTT)
+		buf.WriteByte(h.Reliability)
+	}
+}
+
+func ReadHints(r *bytes.Reader) ([]Hint, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrBadPacket
+	}
+	hints := make([]Hint, count)
+	for i := range hints {
+		if err := binary.Read(r, binary.BigEndian, &hints[i].RTT); err != nil {
+			return nil, ErrBadPacket
+		}
+		if hints[i].Reliability, err = r.ReadByte(); err != nil {
+			return nil, ErrBadPacket
+		}
+	}
+	return hints, nil
+}
+
+// 联系人的 ed25519 公钥的长度
+const KeySize = 32
+
+// 联系人的公钥：数量(1) | 每个公钥 32 字节，不知道的公钥为全零，顺序与之前的联系人列表一致，
+// 与联系人一样最多编码前 MaxContacts 个。keys 中长度不是 KeySize 的公钥按不知道编码
+func AppendKeys(buf *bytes.Buffer, keys [][]byte) {
+	if len(keys) > MaxContacts {
+		keys = keys[:MaxContacts]
+	}
+	buf.WriteByte(byte(len(keys)))
+	var zero [KeySize]byte
+	for _, k := range keys {
+		if len(k) != KeySize {
+			k = zero[:]
+		}
+		buf.Write(k)
+	}
+}
+
+// 读取 AppendKeys 编码的公钥，不知道的公钥为 nil
+func ReadKeys(r *bytes.Reader) ([][]byte, error) {
+	count, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrBadPacket
+	}
+	keys := make([][]byte, count)
+	var zero [KeySize]byte
+	for i := range keys {
+		k := make([]byte, KeySize)
+		if _, err := io.ReadFull(r, k); err != nil {
+			return nil, ErrBadPacket
+		}
+		if !bytes.Equal(k, zero[:]) {
+			keys[i] = k
+		}
+	}
+	return keys, nil
+}
+
+// provider 列表：与 AppendContacts 相同的联系人列表，之后是每个 provider
+// 剩余的有效期（秒，4 字节，0 表示不过期），顺序与联系人一致。
+// 只编码 Data 为 *net.UDPAddr 的节点，ttls 与 nodes 一一对应
+func AppendProviders(buf *bytes.Buffer, nodes []kbucket.Node, ttls []uint32) {
+	var contacts []kbucket.Node
+	var kept []uint32
+	for i, n := range nodes {
+		if _, ok := n.Data.(*net.UDPAddr); ok && len(contacts) < MaxContacts {
+			contacts = append(contacts, n)
+			kept = append(kept, ttls[i])
+		}
+	}
+	AppendContacts(buf, contacts)
+	for _, ttl := range kept {
+		binary.Write(buf, binary.BigEndian, ttl)
+	}
+}
+
+func ReadProviders(r *bytes.Reader) ([]kbucket.Node, []uint32, error) {
+	nodes, err := ReadContacts(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	ttls := make([]uint32, len(nodes))
+	for i := range ttls {
+		if err := binary.Read(r, binary.BigEndian, &ttls[i]); err != nil {
+			return nil, nil, ErrBadPacket
+		}
+	}
+	return nodes, ttls, nil
+}
+
+// 握手信息，附加在 PING 与 PONG 的负载中；旧版本的 PING 与 PONG 负载为空
+type Hello struct {
+	Version      string
+	Codecs       []string
+	Features     []string
+	MaxValueSize uint32 // 0 表示未声明
+	MaxRecords   uint32 // 0 表示不限制
+}
+
+// 版本 | 编码列表 | 特性列表 | 最大值字节数(4) | 最多记录数(4)。
+// 字符串为 长度(1) | 内容，超过 255 字节的部分被截断；列表为 数量(1) | 字符串
+func AppendHello(buf *bytes.Buffer, h Hello) {
+	appendString(buf, h.Version)
+	for _, list := range [][]string{h.Codecs, h.Features} {
+		if len(list) > 255 {
+			list = list[:255]
+		}
+		buf.WriteByte(byte(len(list)))
+		for _, s := range list {
+			appendString(buf, s)
+		}
+	}
+	binary.Write(buf, binary.BigEndian, h.MaxValueSize)
+	binary.Write(buf, binary.BigEndian, h.MaxRecords)
+}
+
+func ReadHello(r *bytes.Reader) (Hello, error) {
+	var h Hello
+	var err error
+	if h.Version, err = readString(r); err != nil {
+		return Hello{}, err
+	}
+	for _, list := range []*[]string{&h.Codecs, &h.Features} {
+		count, err := r.ReadByte()
+		if err != nil {
+			return Hello{}, ErrBadPacket
+		}
+		for i := 0; i < int(count); i++ {
+			s, err := readString(r)
+			if err != nil {
+				return Hello{}, err
+			}
+			*list = append(*list, s)
+		}
+	}
+	if binary.Read(r, binary.BigEndian, &h.MaxValueSize) != nil || binary.Read(r, binary.BigEndian, &h.MaxRecords) != nil {
+		return Hello{}, ErrBadPacket
+	}
+	return h, nil
+}
+
+// RANGE_SYNC 请求：与 Self 共享至少 Bits 位前缀、且不小于 From 的 key，至多 Limit 条
+type RangeRequest struct {
+	Self  [kbucket.IdSize]byte
+	Bits  uint8
+	From  [kbucket.IdSize]byte
+	Limit uint16
+}
+
+// Self(IdSize) | Bits(1) | From(IdSize) | Limit(2)
+func AppendRangeRequest(buf *bytes.Buffer, req RangeRequest) {
+	buf.Write(req.Self[:])
+	buf.WriteByte(req.Bits)
+	buf.Write(req.From[:])
+	binary.Write(buf, binary.BigEndian, req.Limit)
+}
+
+func ReadRangeRequest(r *bytes.Reader) (RangeRequest, error) {
+	var req RangeRequest
+	if _, err := io.ReadFull(r, req.Self[:]); err != nil {
+		return req, ErrBadPacket
+	}
+	var err error
+	if req.Bits, err = r.ReadByte(); err != nil {
+		return req, ErrBadPacket
+	}
+	if _, err := io.ReadFull(r, req.From[:]); err != nil {
+		return req, ErrBadPacket
+	}
+	if binary.Read(r, binary.BigEndian, &req.Limit) != nil {
+		return req, ErrBadPacket
+	}
+	return req, nil
+}
+
+// RANGE_SYNC 响应中的一条记录，TTL 为剩余有效期（秒），0 表示不过期
+type Record struct {
+	Key   [kbucket.IdSize]byte
+	Value []byte
+	TTL   uint32
+}
+
+// RANGE_SYNC 的一页：还有下一页(1) | 下一页的起点(IdSize) | 数量(2) |
+// 每条记录为 key(IdSize) | 有效期(4) | 长度(4) | 值
+func AppendRangePage(buf *bytes.Buffer, records []Record, next [kbucket.IdSize]byte, more bool) {
+	if more {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(next[:])
+	binary.Write(buf, binary.BigEndian, uint16(len(records)))
+	for _, rec := range records {
+		buf.Write(rec.Key[:])
+		binary.Write(buf, binary.BigEndian, rec.TTL)
+		binary.Write(buf, binary.BigEndian, uint32(len(rec.Value)))
+		buf.Write(rec.Value)
+	}
+}
+
+// 读取 AppendRangePage 编码的一页，记录的值是新分配的副本
+func ReadRangePage(r *bytes.Reader) (records []Record, next [kbucket.IdSize]byte, more bool, err error) {
+	flag, err := r.ReadByte()
+	if err != nil {
+		return nil, next, false, ErrBadPacket
+	}
+	if _, err := io.ReadFull(r, next[:]); err != nil {
+		return nil, next, false, ErrBadPacket
+	}
+	var count uint16
+	if binary.Read(r, binary.BigEndian, &count) != nil {
+		return nil, next, false, ErrBadPacket
+	}
+	for i := 0; i < int(count); i++ {
+		var rec Record
+		var size uint32
+		if _, err := io.ReadFull(r, rec.Key[:]); err != nil {
+			return nil, next, false, ErrBadPacket
+		}
+		if binary.Read(r, binary.BigEndian, &rec.TTL) != nil || binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+			return nil, next, false, ErrBadPacket
+		}
+		rec.Value = make([]byte, size)
+		io.ReadFull(r, rec.Value)
+		records = append(records, rec)
+	}
+	return records, next, flag == 1, nil
+}
+
+// DIGEST_SYNC 请求：与 Self 共享至少 Bits 位前缀、且不在 Digest 中的 key，至多 Limit 个
+type DigestRequest struct {
+	Self   [kbucket.IdSize]byte
+	Bits   uint8
+	Limit  uint16
+	Digest []byte
+}
+
+// Self(IdSize) | Bits(1) | Limit(2) | 摘要长度(4) | 摘要
+func AppendDigestRequest(buf *bytes.Buffer, req DigestRequest) {
+	buf.Write(req.Self[:])
+	buf.WriteByte(req.Bits)
+	binary.Write(buf, binary.BigEndian, req.Limit)
+	binary.Write(buf, binary.BigEndian, uint32(len(req.Digest)))
+	buf.Write(req.Digest)
+}
+
+// 读取 AppendDigestRequest 编码的请求，摘要是新分配的副本
+func ReadDigestRequest(r *bytes.Reader) (DigestRequest, error) {
+	var req DigestRequest
+	if _, err := io.ReadFull(r, req.Self[:]); err != nil {
+		return req, ErrBadPacket
+	}
+	var err error
+	if req.Bits, err = r.ReadByte(); err != nil {
+		return req, ErrBadPacket
+	}
+	var size uint32
+	if binary.Read(r, binary.BigEndian, &req.Limit) != nil || binary.Read(r, binary.BigEndian, &size) != nil || int(size) > r.Len() {
+		return req, ErrBadPacket
+	}
+	req.Digest = make([]byte, size)
+	io.ReadFull(r, req.Digest)
+	return req, nil
+}
+
+// key 列表：还有更多(1) | 数量(2) | key(IdSize)...
+func AppendKeyList(buf *bytes.Buffer, keys [][kbucket.IdSize]byte, more bool) {
+	if more {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(keys)))
+	for _, key := range keys {
+		buf.Write(key[:])
+	}
+}
+
+func ReadKeyList(r *bytes.Reader) (keys [][kbucket.IdSize]byte, more bool, err error) {
+	flag, err := r.ReadByte()
+	if err != nil {
+		return nil, false, ErrBadPacket
+	}
+	var count uint16
+	if binary.Read(r, binary.BigEndian, &count) != nil || int(count)*kbucket.IdSize > r.Len() {
+		return nil, false, ErrBadPacket
+	}
+	keys = make([][kbucket.IdSize]byte, count)
+	for i := range keys {
+		io.ReadFull(r, keys[i][:])
+	}
+	return keys, flag == 1, nil
+}
+
+// MERKLE_SYNC 请求：与 Self 共享至少 Bits 位前缀的 key 中，与 Prefix 共享前 Depth 位的 key 构成的子树
+type MerkleRequest struct {
+	Self   [kbucket.IdSize]byte
+	Bits   uint8
+	Prefix [kbucket.IdSize]byte
+	Depth  uint8
+}
+
+// Self(IdSize) | Bits(1) | Prefix(IdSize) | Depth(1)
+func AppendMerkleRequest(buf *bytes.Buffer, req MerkleRequest) {
+	buf.Write(req.Self[:])
+	buf.WriteByte(req.Bits)
+	buf.Write(req.Prefix[:])
+	buf.WriteByte(req.Depth)
+}
+
+func ReadMerkleRequest(r *bytes.Reader) (MerkleRequest, error) {
+	var req MerkleRequest
+	if _, err := io.ReadFull(r, req.Self[:]); err != nil {
+		return req, ErrBadPacket
+	}
+	var err error
+	if req.Bits, err = r.ReadByte(); err != nil {
+		return req, ErrBadPacket
+	}
+	if _, err := io.ReadFull(r, req.Prefix[:]); err != nil {
+		return req, ErrBadPacket
+	}
+	if req.Depth, err = r.ReadByte(); err != nil || int(req.Depth) > kbucket.IdSize*8 {
+		return req, ErrBadPacket
+	}
+	return req, nil
+}
+
+// MERKLE_SYNC 响应中的子树。Hash 为零值表示子树为空；叶子节点带有其中全部的 key，
+// 其余节点带有左右子树的哈希
+type MerkleNode struct {
+	Hash     [HashSize]byte
+	Leaf     bool
+	Keys     [][kbucket.IdSize]byte
+	Children [2][HashSize]byte
+}
+
+// 类型(1，0 为空、1 为叶子、2 为内部节点) | 哈希(HashSize) |
+// 叶子为 数量(2) | key(IdSize)...，内部节点为 左子树哈希 | 右子树哈希
+func AppendMerkleNode(buf *bytes.Buffer, n MerkleNode) {
+	switch {
+	case n.Hash == [HashSize]byte{}:
+		buf.WriteByte(0)
+		return
+	case n.Leaf:
+		buf.WriteByte(1)
+		buf.Write(n.Hash[:])
+		binary.Write(buf, binary.BigEndian, uint16(len(n.Keys)))
+		for _, key := range n.Keys {
+			buf.Write(key[:])
+		}
+	default:
+		buf.WriteByte(2)
+		buf.Write(n.Hash[:])
+		buf.Write(n.Children[0][:])
+		buf.Write(n.Children[1][:])
+	}
+}
+
+func ReadMerkleNode(r *bytes.Reader) (MerkleNode, error) {
+	var n MerkleNode
+	kind, err := r.ReadByte()
+	if err != nil || kind > 2 {
+		return n, ErrBadPacket
+	}
+	if kind == 0 {
+		return n, nil
+	}
+	if _, err := io.ReadFull(r, n.Hash[:]); err != nil {
+		return n, ErrBadPacket
+	}
+	if kind == 2 {
+		if _, err := io.ReadFull(r, n.Children[0][:]); err != nil {
+			return n, ErrBadPacket
+		}
+		if _, err := io.ReadFull(r, n.Children[1][:]); err != nil {
+			return n, ErrBadPacket
+		}
+		return n, nil
+	}
+	n.Leaf = true
+	var count uint16
+	if binary.Read(r, binary.BigEndian, &count) != nil || int(count)*kbucket.IdSize > r.Len() {
+		return n, ErrBadPacket
+	}
+	n.Keys = make([][kbucket.IdSize]byte, count)
+	for i := range n.Keys {
+		io.ReadFull(r, n.Keys[i][:])
+	}
+	return n, nil
+}
+
+// SIGNAL 请求。请求方发出时 From 与 FromAddr 为零值；中继转交时填入请求方的 ID
+// 与中继联系请求方的地址，目标只接受经过一次转交的信令
+type SignalMessage struct {
+	Target   [kbucket.IdSize]byte
+	From     [kbucket.IdSize]byte
+	FromAddr string
+	Kind     byte
+	Data     []byte
+}
+
+// Target(IdSize) | From(IdSize) | FromAddr(长度 1 + 字符串) | Kind(1) | 数据长度(2) | 数据
+func AppendSignal(buf *bytes.Buffer, m SignalMessage) {
+	buf.Write(m.Target[:])
+	buf.Write(m.From[:])
+	appendString(buf, m.FromAddr)
+	buf.WriteByte(m.Kind)
+	binary.Write(buf, binary.BigEndian, uint16(len(m.Data)))
+	buf.Write(m.Data)
+}
+
+func ReadSignal(r *bytes.Reader) (SignalMessage, error) {
+	var m SignalMessage
+	if _, err := io.ReadFull(r, m.Target[:]); err != nil {
+		return m, ErrBadPacket
+	}
+	if _, err := io.ReadFull(r, m.From[:]); err != nil {
+		return m, ErrBadPacket
+	}
+	var err error
+	if m.FromAddr, err = readString(r); err != nil {
+		return m, err
+	}
+	if m.Kind, err = r.ReadByte(); err != nil {
+		return m, ErrBadPacket
+	}
+	var size uint16
+	if binary.Read(r, binary.BigEndian, &size) != nil || int(size) != r.Len() {
+		return m, ErrBadPacket
+	}
+	m.Data = make([]byte, size)
+	io.ReadFull(r, m.Data)
+	return m, nil
+}
+
+func appendString(buf *bytes.Buffer, s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil || int(n) > r.Len() {
+		return "", ErrBadPacket
+	}
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return string(b), nil
+}
@@ -0,0 +1,80 @@
+package udpwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/WuQingyang2/K_Bucket/kbucket"
+)
+
+func testContacts(n int) []kbucket.Node {
+	nodes := make([]kbucket.Node, n)
+	for i := range nodes {
+		binary.BigEndian.PutUint32(nodes[i].ID[:], uint32(i+1))
+		nodes[i].Data = &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 4000}
+	}
+	return nodes
+}
+
+// 超过一个字节能表示的联系人只编码前 MaxContacts 个，数量不会回绕
+func TestContactsCapped(t *testing.T) {
+	nodes := testContacts(MaxContacts + 45)
+	var buf bytes.Buffer
+	AppendContacts(&buf, nodes)
+	hints := make([]Hint, len(nodes))
+	AppendHints(&buf, hints)
+	keys := make([][]byte, len(nodes))
+	keys[0] = bytes.Repeat([]byte{1}, KeySize)
+	AppendKeys(&buf, keys)
+	r := bytes.NewReader(buf.Bytes())
+	got, err := ReadContacts(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != MaxContacts {
+		t.Fatalf("decoded %d contacts, want %d", len(got), MaxContacts)
+	}
+	for i, n := range got {
+		if n.ID != nodes[i].ID || n.Data.(*net.UDPAddr).String() != nodes[i].Data.(*net.UDPAddr).String() {
+			t.Fatalf("contact %d = %x %v, want %x %v", i, n.ID[:4], n.Data, nodes[i].ID[:4], nodes[i].Data)
+		}
+	}
+	if gotHints, err := ReadHints(r); err != nil || len(gotHints) != MaxContacts {
+		t.Fatalf("decoded %d hints, %v, want %d", len(gotHints), err, MaxContacts)
+	}
+	gotKeys, err := ReadKeys(r)
+	if err != nil || len(gotKeys) != MaxContacts {
+		t.Fatalf("decoded %d keys, %v, want %d", len(gotKeys), err, MaxContacts)
+	}
+	if !bytes.Equal(gotKeys[0], keys[0]) || gotKeys[1] != nil {
+		t.Fatalf("keys = %x, %x, want %x and an unknown key", gotKeys[0], gotKeys[1], keys[0])
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d trailing bytes", r.Len())
+	}
+}
+
+// provider 列表与联系人一样截断，有效期仍然与联系人一一对应
+func TestProvidersCapped(t *testing.T) {
+	nodes := testContacts(MaxContacts + 1)
+	ttls := make([]uint32, len(nodes))
+	for i := range ttls {
+		ttls[i] = uint32(i)
+	}
+	var buf bytes.Buffer
+	AppendProviders(&buf, nodes, ttls)
+	got, gotTTLs, err := ReadProviders(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != MaxContacts || len(gotTTLs) != MaxContacts {
+		t.Fatalf("decoded %d providers and %d ttls, want %d", len(got), len(gotTTLs), MaxContacts)
+	}
+	for i := range gotTTLs {
+		if gotTTLs[i] != ttls[i] {
+			t.Fatalf("ttl %d = %d, want %d", i, gotTTLs[i], ttls[i])
+		}
+	}
+}